@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"mcp-sync/models"
 	"mcp-sync/services"
+	"time"
 )
 
 // App struct
@@ -30,6 +31,9 @@ func (a *App) startup(ctx context.Context) {
 		return
 	}
 	a.appService = appService
+
+	// Check the stored credentials in the background so an expired token shows up before the first sync
+	go appService.ValidateStoredCredentials()
 }
 
 // DetectAgents detects installed agents on the system
@@ -37,18 +41,321 @@ func (a *App) DetectAgents() ([]models.Agent, error) {
 	return a.appService.DetectAgents()
 }
 
+// GetAgentCatalog returns every known agent with its detection status and server count
+func (a *App) GetAgentCatalog() ([]models.AgentCatalogEntry, error) {
+	return a.appService.GetAgentCatalog()
+}
+
+// ReloadAgentDefinitions re-reads agents.yaml and ~/.mcp-sync/agents.d without restarting the app
+func (a *App) ReloadAgentDefinitions() error {
+	return a.appService.ReloadAgentDefinitions()
+}
+
 // InitializeGistSync sets up GitHub Gist synchronization
 // Returns the Gist ID (either provided or auto-created)
 func (a *App) InitializeGistSync(token, gistID string) (string, error) {
-	// Validate token
-	if err := a.appService.ValidateGitHubToken(token); err != nil {
-		return "", err
-	}
-	
-	// Initialize sync and save config
+	// Validates the token, then initializes sync and saves config
 	return a.appService.InitializeGistSync(token, gistID)
 }
 
+// InitializeGistSyncDetailed sets up GitHub Gist synchronization and reports whether a new gist was created
+func (a *App) InitializeGistSyncDetailed(token, gistID string) (*models.InitResult, error) {
+	return a.appService.InitializeGistSyncDetailed(token, gistID)
+}
+
+// SetCredentialHelper sets a command that prints the GitHub token, used instead of the stored token
+func (a *App) SetCredentialHelper(command string) error {
+	return a.appService.SetCredentialHelper(command)
+}
+
+// GetGitHubTokenSource reports where the GitHub token in use comes from: env, credential_helper or stored
+func (a *App) GetGitHubTokenSource() (string, error) {
+	return a.appService.GetGitHubTokenSource()
+}
+
+// UpdateGitHubToken replaces the stored GitHub token without re-initializing sync
+func (a *App) UpdateGitHubToken(newToken string) error {
+	return a.appService.UpdateGitHubToken(newToken)
+}
+
+// MigrateGist moves the synced configuration to a new gist owned by newToken's account
+func (a *App) MigrateGist(newToken string, deleteOld bool) (string, error) {
+	return a.appService.MigrateGist(newToken, deleteOld)
+}
+
+// TearDownSync clears sync credentials, optionally deleting the remote gist and disabling encryption
+func (a *App) TearDownSync(deleteRemote bool, disableEncryption bool) error {
+	return a.appService.TearDownSync(deleteRemote, disableEncryption)
+}
+
+// PushByTag pushes only the servers carrying the given tag
+func (a *App) PushByTag(tag string) error {
+	return a.appService.PushByTag(tag)
+}
+
+// PullByTag pulls only the servers carrying the given tag
+func (a *App) PullByTag(tag string) ([]models.MCPServer, error) {
+	return a.appService.PullByTag(tag)
+}
+
+// SetSecret stores a value in the system keyring for ${secret:name} env references
+func (a *App) SetSecret(name, value string) error {
+	return a.appService.SetSecret(name, value)
+}
+
+// DeleteSecret removes a stored secret value
+func (a *App) DeleteSecret(name string) error {
+	return a.appService.DeleteSecret(name)
+}
+
+// RotateEncryptionKey re-encrypts local data with a freshly generated master key
+func (a *App) RotateEncryptionKey() error {
+	return a.appService.RotateEncryptionKey()
+}
+
+// ResetEncryption sets unreadable encrypted files aside and starts over with a new key
+func (a *App) ResetEncryption() (string, error) {
+	return a.appService.ResetEncryption()
+}
+
+// CompactStorage prunes backups, re-encrypts old data files and tidies the version index
+func (a *App) CompactStorage() (*models.CompactReport, error) {
+	return a.appService.CompactStorage()
+}
+
+// ExportHistoryArchive writes all config versions and sync logs to one archive file
+func (a *App) ExportHistoryArchive(archivePath string, opts models.HistoryArchiveOptions) error {
+	return a.appService.ExportHistoryArchiveFile(archivePath, opts)
+}
+
+// ImportHistoryArchive restores config versions and sync logs from an exported archive file
+func (a *App) ImportHistoryArchive(archivePath string) error {
+	return a.appService.ImportHistoryArchiveFile(archivePath)
+}
+
+// IsInSync reports whether local agents and the gist both still match the last successful sync
+func (a *App) IsInSync() (bool, error) {
+	return a.appService.IsInSync()
+}
+
+// ImportServersFromJSON adds servers from a pasted mcpServers/context_servers snippet to the target agents.
+// The result maps each agent ID to an error message, or an empty string on success.
+func (a *App) ImportServersFromJSON(content string, targetAgentIDs []string, overwrite bool) (map[string]string, error) {
+	results, err := a.appService.ImportServersFromJSON([]byte(content), targetAgentIDs, overwrite)
+	if err != nil {
+		return nil, err
+	}
+	messages := make(map[string]string, len(results))
+	for agentID, importErr := range results {
+		if importErr != nil {
+			messages[agentID] = importErr.Error()
+		} else {
+			messages[agentID] = ""
+		}
+	}
+	return messages, nil
+}
+
+// SetGistFileName changes the gist file used for sync; an empty name restores mcp-config.json
+func (a *App) SetGistFileName(fileName string) error {
+	return a.appService.SetGistFileName(fileName)
+}
+
+// CreateProfile adds a named sync profile with its own gist, file name and agent selection
+func (a *App) CreateProfile(name, gistID, fileName string, agents []string) error {
+	return a.appService.CreateProfile(name, gistID, fileName, agents)
+}
+
+// ListProfiles returns all named sync profiles
+func (a *App) ListProfiles() ([]models.ProfileConfig, error) {
+	return a.appService.ListProfiles()
+}
+
+// SwitchProfile makes the named profile the target of subsequent pushes and pulls
+func (a *App) SwitchProfile(name string) error {
+	return a.appService.SwitchProfile(name)
+}
+
+// SetConflictPolicy sets how auto-sync resolves conflicts: manual, prefer_local, prefer_remote or prefer_newer
+func (a *App) SetConflictPolicy(policy string) error {
+	return a.appService.SetConflictPolicy(policy)
+}
+
+// RunAutoSync performs one unattended sync cycle using the configured conflict policy
+func (a *App) RunAutoSync() (*models.AutoSyncResult, error) {
+	return a.appService.RunAutoSync()
+}
+
+// PauseSync suspends auto-sync for duration; it resumes on its own afterwards. Manual syncs still run
+func (a *App) PauseSync(duration time.Duration) error {
+	return a.appService.PauseSync(duration)
+}
+
+// ResumeSync ends a pause started by PauseSync right away
+func (a *App) ResumeSync() {
+	a.appService.ResumeSync()
+}
+
+// SetConfigLimits sets the size limits enforced on pulled and imported configs
+func (a *App) SetConfigLimits(limits models.ConfigLimits) error {
+	return a.appService.SetConfigLimits(limits)
+}
+
+// SetBackupRetention sets how many agent config backups are kept and for how long, then prunes accordingly
+func (a *App) SetBackupRetention(retention models.BackupRetention) error {
+	return a.appService.SetBackupRetention(retention)
+}
+
+// PruneBackups deletes agent config backups outside the retention policy and returns how many were removed
+func (a *App) PruneBackups() (int, error) {
+	return a.appService.PruneBackups()
+}
+
+// SetStripSecrets sets the agents whose sensitive env values are kept local-only ("*" for all)
+func (a *App) SetStripSecrets(agents []string) error {
+	return a.appService.SetStripSecrets(agents)
+}
+
+// AddSensitivePattern adds a custom field-name pattern that is treated as sensitive
+func (a *App) AddSensitivePattern(pattern string) error {
+	return a.appService.AddSensitivePattern(pattern)
+}
+
+// GetSensitivePatterns returns the default and custom sensitive field-name patterns
+func (a *App) GetSensitivePatterns() []string {
+	return a.appService.GetSensitivePatterns()
+}
+
+// SetRequireEncryption makes every sync operation fail until encryption is enabled with a key
+func (a *App) SetRequireEncryption(required bool) error {
+	return a.appService.SetRequireEncryption(required)
+}
+
+// CanSync returns nil when syncing is possible, or an error explaining why it is not
+func (a *App) CanSync() error {
+	return a.appService.CanSync()
+}
+
+// ValidateStoredCredentials checks that the stored GitHub token and gist are still usable
+func (a *App) ValidateStoredCredentials() (*models.CredStatus, error) {
+	return a.appService.ValidateStoredCredentials()
+}
+
+// GetCredentialStatus returns the result of the last credential check, or nil before the first check
+func (a *App) GetCredentialStatus() *models.CredStatus {
+	return a.appService.GetCredentialStatus()
+}
+
+// GetEnvironmentName returns the environment name whose gist overlay this machine applies
+func (a *App) GetEnvironmentName() (string, error) {
+	return a.appService.GetEnvironmentName()
+}
+
+// SetEnvironmentName sets this machine's environment name; an empty name falls back to the hostname
+func (a *App) SetEnvironmentName(name string) error {
+	return a.appService.SetEnvironmentName(name)
+}
+
+// GetEnvironmentOverlays returns the per-environment overlays stored in the gist
+func (a *App) GetEnvironmentOverlays() (map[string]map[string]interface{}, error) {
+	return a.appService.GetEnvironmentOverlays()
+}
+
+// SetEnvironmentOverlay stores the overlay for an environment in the gist; an empty overlay removes it
+func (a *App) SetEnvironmentOverlay(name string, overlay map[string]interface{}) error {
+	return a.appService.SetEnvironmentOverlay(name, overlay)
+}
+
+// ValidateAgainstSchema checks an agent's MCP config against the JSON schema configured for it in agents.yaml
+func (a *App) ValidateAgainstSchema(agentID string) ([]string, error) {
+	return a.appService.ValidateAgainstSchema(agentID)
+}
+
+// SetSchemaSafeMode makes pushes refuse agent configs that do not match their JSON schema
+func (a *App) SetSchemaSafeMode(enabled bool) error {
+	return a.appService.SetSchemaSafeMode(enabled)
+}
+
+// SetPushValidation chooses what a push does with an invalid agent config: "skip" the agent or abort the push ("strict")
+func (a *App) SetPushValidation(policy string) error {
+	return a.appService.SetPushValidation(policy)
+}
+
+// SetPortablePaths sets whether paths under ${HOME} and the given anchors are stored relative to them in the gist
+func (a *App) SetPortablePaths(enabled bool, anchors map[string]string) error {
+	return a.appService.SetPortablePaths(enabled, anchors)
+}
+
+// SetExpandCodexEnv sets whether ${NAME} references in env values are expanded when writing Codex configs
+func (a *App) SetExpandCodexEnv(enabled bool) error {
+	return a.appService.SetExpandCodexEnv(enabled)
+}
+
+// SetConflictFiles makes a merge with unresolved conflicts write conflicts/<operation ID>.json for manual resolution
+func (a *App) SetConflictFiles(enabled bool) error {
+	return a.appService.SetConflictFiles(enabled)
+}
+
+// ListConflictFiles returns the operation IDs of conflict files that have not been applied yet
+func (a *App) ListConflictFiles() ([]string, error) {
+	return a.appService.ListConflictFiles()
+}
+
+// ApplyResolvedConflicts applies a hand-edited conflict file and pushes the result
+func (a *App) ApplyResolvedConflicts(operationID string) error {
+	return a.appService.ApplyResolvedConflicts(operationID)
+}
+
+// SetMetricsEnabled turns local sync metrics collection on or off
+func (a *App) SetMetricsEnabled(enabled bool) error {
+	return a.appService.SetMetricsEnabled(enabled)
+}
+
+// GetMetrics returns the locally collected sync metrics
+func (a *App) GetMetrics() (*models.SyncMetrics, error) {
+	return a.appService.GetMetrics()
+}
+
+// ResetMetrics clears the locally collected sync metrics
+func (a *App) ResetMetrics() error {
+	return a.appService.ResetMetrics()
+}
+
+// SetAllServersEnabled disables every MCP server of an agent, or re-enables the ones it disabled
+func (a *App) SetAllServersEnabled(agentID string, enabled bool) error {
+	return a.appService.SetAllServersEnabled(agentID, enabled)
+}
+
+// PullFromGistMerge pulls from the Gist, adding and updating remote servers while keeping local-only ones
+func (a *App) PullFromGistMerge() (*models.PullResult, error) {
+	return a.appService.PullFromGistMerge()
+}
+
+// GetSetupState returns the progress of the first-run setup wizard
+func (a *App) GetSetupState() (*models.SetupState, error) {
+	return a.appService.GetSetupState()
+}
+
+// CompleteSetupStep completes one setup wizard step and returns the new progress
+func (a *App) CompleteSetupStep(step, value string) (*models.SetupState, error) {
+	return a.appService.CompleteSetupStep(step, value)
+}
+
+// NormalizeAgentConfig rewrites an agent's config file in canonical form (after a backup)
+func (a *App) NormalizeAgentConfig(agentID string) error {
+	return a.appService.NormalizeAgentConfig(agentID)
+}
+
+// GetMergeBase returns the config snapshot from the last successful sync
+func (a *App) GetMergeBase() (*models.ConfigVersion, error) {
+	return a.appService.GetMergeBase()
+}
+
+// Doctor runs consistency checks on the local and remote sync state
+func (a *App) Doctor() (*models.DoctorReport, error) {
+	return a.appService.Doctor()
+}
+
 // GetSyncConfig retrieves the current sync configuration
 func (a *App) GetSyncConfig() (models.SyncConfig, error) {
 	return a.appService.GetSyncConfig()
@@ -64,6 +371,11 @@ func (a *App) PushAllAgentsToGist() error {
 	return a.appService.PushAllAgentsToGist()
 }
 
+// FlushPendingPushes retries pushes that were queued while offline
+func (a *App) FlushPendingPushes() error {
+	return a.appService.FlushPendingPushes()
+}
+
 // PushToGist pushes configuration to GitHub Gist
 func (a *App) PushToGist(servers []models.MCPServer) error {
 	return a.appService.PushToGist(servers)
@@ -74,14 +386,37 @@ func (a *App) PullFromGist() ([]models.MCPServer, error) {
 	return a.appService.PullFromGist()
 }
 
+// ForcePush pushes all agents' configurations to Gist, skipping conflict detection
+func (a *App) ForcePush() error {
+	return a.appService.ForcePush()
+}
+
+// ForcePull pulls configuration from Gist, skipping conflict detection
+func (a *App) ForcePull() ([]models.MCPServer, error) {
+	return a.appService.ForcePull()
+}
+
 // ApplyConfigToAgent applies MCP configuration to a specific agent
 func (a *App) ApplyConfigToAgent(agentID string, servers []models.MCPServer) error {
 	return a.appService.ApplyConfigToAgents(agentID, servers)
 }
 
-// ApplyConfigToAllAgents applies MCP configuration to all detected agents
-func (a *App) ApplyConfigToAllAgents(servers []models.MCPServer) error {
-	return a.appService.ApplyConfigToAllAgents(servers)
+// ApplyConfigToAllAgents applies MCP configuration to all detected agents, continuing past failures.
+// The result maps each agent ID to an error message, or an empty string on success.
+func (a *App) ApplyConfigToAllAgents(servers []models.MCPServer) (map[string]string, error) {
+	results, err := a.appService.ApplyConfigToAllAgents(servers)
+	if results == nil {
+		return nil, err
+	}
+	messages := make(map[string]string, len(results))
+	for agentID, applyErr := range results {
+		if applyErr != nil {
+			messages[agentID] = applyErr.Error()
+		} else {
+			messages[agentID] = ""
+		}
+	}
+	return messages, nil
 }
 
 // GetConfigVersions retrieves the configuration version history
@@ -94,21 +429,96 @@ func (a *App) GetSyncLogs(limit int) ([]models.SyncLog, error) {
 	return a.appService.GetSyncLogs(limit)
 }
 
+// GetAuditLog retrieves the most recent config and backup file writes, newest first
+func (a *App) GetAuditLog(limit int) ([]models.AuditEntry, error) {
+	return a.appService.GetAuditLog(limit)
+}
+
+// GetVersionContent returns the decrypted content of a stored version, or of the merge base for "merge_base"
+func (a *App) GetVersionContent(versionID string) (string, error) {
+	return a.appService.GetVersionContent(versionID)
+}
+
+// DeleteVersion deletes a stored version by ID or file name; the merge base cannot be deleted
+func (a *App) DeleteVersion(versionID string) error {
+	return a.appService.DeleteVersion(versionID)
+}
+
+// GetConfigVersionsWithSkipped retrieves version history along with the number of unreadable files
+func (a *App) GetConfigVersionsWithSkipped(limit int) (*models.ConfigVersionList, error) {
+	return a.appService.GetConfigVersionsWithSkipped(limit)
+}
+
+// GetSyncLogsWithSkipped retrieves sync logs along with the number of unreadable files
+func (a *App) GetSyncLogsWithSkipped(limit int) (*models.SyncLogList, error) {
+	return a.appService.GetSyncLogsWithSkipped(limit)
+}
+
+// DiagnoseUndecryptable lists version and log files that cannot be decrypted, with the reason
+func (a *App) DiagnoseUndecryptable() ([]string, error) {
+	return a.appService.DiagnoseUndecryptable()
+}
+
 // GetAgentMCPConfig reads the MCP configuration from a specific agent's config file
 func (a *App) GetAgentMCPConfig(agentID string) (map[string]interface{}, error) {
 	return a.appService.GetAgentMCPConfig(agentID)
 }
 
+// PlanSync previews a push or pull across all agents without writing anything
+func (a *App) PlanSync(direction string) (*models.SyncPlan, error) {
+	return a.appService.PlanSync(direction)
+}
+
+// PushAgentToGist uploads a single agent's configuration, keeping the other agents' remote entries
+func (a *App) PushAgentToGist(agentID string) error {
+	return a.appService.PushAgentToGist(agentID)
+}
+
+// PullAgentFromGist restores a single agent's configuration from the Gist
+func (a *App) PullAgentFromGist(agentID string) error {
+	return a.appService.PullAgentFromGist(agentID)
+}
+
+// GetAgentMCPConfigDetailed reads an agent's MCP configuration along with its format, path and config key
+func (a *App) GetAgentMCPConfigDetailed(agentID string) (*models.AgentConfigResult, error) {
+	return a.appService.GetAgentMCPConfigDetailed(agentID)
+}
+
 // SaveAgentMCPConfig saves MCP configuration to a specific agent's config file
 func (a *App) SaveAgentMCPConfig(agentID string, configJson map[string]interface{}) error {
 	return a.appService.SaveAgentMCPConfig(agentID, configJson)
 }
 
-// SyncConfigBetweenAgents syncs configuration from source agent to target agent with automatic format conversion
-func (a *App) SyncConfigBetweenAgents(sourceAgentID, targetAgentID string) error {
+// CompareAgents reports which MCP servers differ between two agents
+func (a *App) CompareAgents(agentA, agentB string) (*models.AgentComparison, error) {
+	return a.appService.CompareAgents(agentA, agentB)
+}
+
+// SyncConfigBetweenAgents syncs configuration from source agent to target agent with automatic format conversion,
+// rolling the target back if the write fails, and reports which servers were written and which were skipped
+func (a *App) SyncConfigBetweenAgents(sourceAgentID, targetAgentID string) (*models.AgentSyncResult, error) {
 	return a.appService.SyncConfigBetweenAgents(sourceAgentID, targetAgentID)
 }
 
+// MirrorFromAgent copies the source agent's servers to each target (removing servers the source lacks when removeExtra is set)
+// and returns the error message for each target that failed; errors are returned as strings so they survive the bridge
+func (a *App) MirrorFromAgent(sourceAgentID string, targetAgentIDs []string, removeExtra bool) (map[string]string, error) {
+	results, err := a.appService.MirrorFromAgent(sourceAgentID, targetAgentIDs, removeExtra)
+	if err != nil {
+		return nil, err
+	}
+	failures := make(map[string]string, len(results))
+	for agentID, targetErr := range results {
+		failures[agentID] = targetErr.Error()
+	}
+	return failures, nil
+}
+
+// CheckServerPaths lists absolute paths in an agent's server command, args, env and cwd that do not exist on this machine
+func (a *App) CheckServerPaths(agentID string) ([]models.PathIssue, error) {
+	return a.appService.CheckServerPaths(agentID)
+}
+
 // GetGistSecurityWarnings returns security warnings for Gist synchronization
 func (a *App) GetGistSecurityWarnings() []map[string]string {
 	return a.appService.GetGistSecurityWarnings()
@@ -119,6 +529,16 @@ func (a *App) SetupGistEncryption(enabled bool, password string) error {
 	return a.appService.SetupGistEncryption(enabled, password)
 }
 
+// CompleteEncryptionMigration moves legacy password-encrypted data to keyring encryption and removes the stored passwords
+func (a *App) CompleteEncryptionMigration() error {
+	return a.appService.CompleteEncryptionMigration()
+}
+
+// IsKeyringAvailable reports whether a working system keyring exists; encryption cannot be enabled without one
+func (a *App) IsKeyringAvailable() bool {
+	return a.appService.IsKeyringAvailable()
+}
+
 // DetectPushConflict detects conflicts before pushing to Gist
 func (a *App) DetectPushConflict() (*models.SyncConflict, error) {
 	return a.appService.DetectPushConflict()
@@ -131,10 +551,39 @@ func (a *App) DetectPullConflict() (*models.SyncConflict, error) {
 
 // ResolveConflict resolves a detected conflict with the specified strategy
 // resolution: "keep_local", "use_remote", "merge"
-func (a *App) ResolveConflict(conflictType string, resolution string) error {
+func (a *App) ResolveConflict(conflictType string, resolution string) (*models.ResolutionResult, error) {
 	return a.appService.ResolveConflict(conflictType, resolution)
 }
 
+// StagePull fetches the remote configs and stores each agent's pending changes for review without writing anything
+func (a *App) StagePull() (*models.StagedPull, error) {
+	return a.appService.StagePull()
+}
+
+// GetStagedPull returns the pull waiting for review, or nil when nothing is staged
+func (a *App) GetStagedPull() (*models.StagedPull, error) {
+	return a.appService.GetStagedPull()
+}
+
+// CommitStagedPull applies the staged configs of the given agents (all staged agents when empty)
+// and returns the error message for each agent that failed
+func (a *App) CommitStagedPull(agentIDs []string) (map[string]string, error) {
+	results, err := a.appService.CommitStagedPull(agentIDs)
+	if err != nil {
+		return nil, err
+	}
+	failures := make(map[string]string, len(results))
+	for agentID, agentErr := range results {
+		failures[agentID] = agentErr.Error()
+	}
+	return failures, nil
+}
+
+// DiscardStagedPull drops the staged pull without touching any agent config
+func (a *App) DiscardStagedPull() error {
+	return a.appService.DiscardStagedPull()
+}
+
 // ConvertAgentConfig converts MCP config from one agent format to another
 func (a *App) ConvertAgentConfig(sourceAgentID, targetAgentID string, sourceConfig map[string]interface{}) (*services.ConversionResult, error) {
 	return a.appService.ConvertAgentConfig(sourceAgentID, targetAgentID, sourceConfig)
@@ -150,6 +599,12 @@ func (a *App) ConvertFromCodex(targetAgentID string, codexConfig map[string]inte
 	return a.appService.ConvertFromCodex(targetAgentID, codexConfig)
 }
 
+// ConvertFile converts the MCP servers in a config file to another format and writes them to outputPath;
+// empty formats are detected automatically
+func (a *App) ConvertFile(inputPath, sourceFormat, targetFormat, outputPath string) error {
+	return a.appService.ConvertFile(inputPath, sourceFormat, targetFormat, outputPath)
+}
+
 // BatchConvertConfig converts config to multiple target formats
 func (a *App) BatchConvertConfig(sourceAgentID string, sourceConfig map[string]interface{}, targetAgentIDs []string) ([]*services.ConversionResult, error) {
 	return a.appService.BatchConvertConfig(sourceAgentID, sourceConfig, targetAgentIDs)
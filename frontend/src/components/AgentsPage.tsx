@@ -92,8 +92,10 @@ export function AgentsPage() {
         setSaveMessage("未选择源工具!")
         return
       }
-      await (window as any).go.main.App.SyncConfigBetweenAgents(selectedAgent, targetAgentId)
-      setSaveMessage(`已同步到 ${targetAgentId}（自动处理格式差异）`)
+      const result = await (window as any).go.main.App.SyncConfigBetweenAgents(selectedAgent, targetAgentId)
+      const skipped = Object.keys(result.skipped || {})
+      const skippedNote = skipped.length > 0 ? `，跳过 ${skipped.join(", ")}` : ""
+      setSaveMessage(result.written ? `已同步到 ${targetAgentId}（自动处理格式差异${skippedNote}）` : `${targetAgentId} 已是最新，无需同步`)
       setTimeout(() => setSaveMessage(""), 3000)
     } catch (error) {
       setSaveMessage("同步失败: " + (error as any).message)
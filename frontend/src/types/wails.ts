@@ -8,7 +8,7 @@ interface AppRuntime {
   PushToGist(servers: MCPServer[]): Promise<void>
   PullFromGist(): Promise<MCPServer[]>
   ApplyConfigToAgent(agentID: string, servers: MCPServer[]): Promise<void>
-  ApplyConfigToAllAgents(servers: MCPServer[]): Promise<void>
+  ApplyConfigToAllAgents(servers: MCPServer[]): Promise<Record<string, string>>
   GetConfigVersions(limit: number): Promise<ConfigVersion[]>
   GetSyncLogs(limit: number): Promise<SyncLog[]>
   Greet(name: string): Promise<string>
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
 	"embed"
+	"encoding/hex"
+	"flag"
+	"os"
+
+	"mcp-sync/services"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -11,7 +17,25 @@ import (
 //go:embed all:frontend/dist
 var assets embed.FS
 
+// apiTokenEnv names the environment variable holding the local API token
+const apiTokenEnv = "MCP_SYNC_API_TOKEN"
+
 func main() {
+	// -api serves the local HTTP API instead of opening the window, so other tools can script mcp-sync.
+	// Unknown arguments (for example ones added by the OS when launching the app) are ignored.
+	flags := flag.NewFlagSet("mcp-sync", flag.ContinueOnError)
+	apiAddr := flags.String("api", "", "serve the local HTTP API on this localhost address (e.g. 127.0.0.1:8765) without the GUI")
+	flags.Parse(os.Args[1:])
+	if *apiAddr != "" {
+		os.Exit(serveLocalAPI(*apiAddr))
+	}
+
+	// A malformed agents.yaml would leave the app with no agents to sync; stop before opening the window
+	if _, err := services.NewConfigLoader(); err != nil {
+		println("Error loading agent definitions:", err.Error())
+		os.Exit(1)
+	}
+
 	// Create an instance of the app structure
 	app := NewApp()
 
@@ -34,3 +58,30 @@ func main() {
 		println("Error:", err.Error())
 	}
 }
+
+// serveLocalAPI runs the local API until it fails and returns the process exit code.
+// The token is read from MCP_SYNC_API_TOKEN; when unset a random one is generated and printed.
+func serveLocalAPI(addr string) int {
+	appService, err := services.NewAppService()
+	if err != nil {
+		println("Error initializing app service:", err.Error())
+		return 1
+	}
+
+	token := os.Getenv(apiTokenEnv)
+	if token == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			println("Error generating API token:", err.Error())
+			return 1
+		}
+		token = hex.EncodeToString(buf)
+		println("Local API token (set " + apiTokenEnv + " to choose your own): " + token)
+	}
+
+	if err := appService.ServeLocalAPI(addr, token); err != nil {
+		println("Error:", err.Error())
+		return 1
+	}
+	return 0
+}
@@ -12,16 +12,90 @@ type Agent struct {
 	Enabled       bool     `json:"enabled"`
 }
 
+// AgentCatalogEntry 将 agents.yaml 中的 agent 定义与本机的检测结果合并，供界面展示全部已知 agent
+type AgentCatalogEntry struct {
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Description   string   `json:"description"`
+	Format        string   `json:"format"`     // agents.yaml 中的 format，例如 standard、zed、codex_toml
+	ConfigKey     string   `json:"config_key"` // MCP 服务器所在的配置键
+	Status        string   `json:"status"`     // detected, not_installed, unsupported（当前平台没有配置路径）
+	ConfigPaths   []string `json:"config_paths"`
+	ExistingPaths []string `json:"existing_paths"`
+	ServerCount   int      `json:"server_count"`
+	HasServers    bool     `json:"has_servers"`
+	Error         string   `json:"error,omitempty"` // 已检测到但读取配置失败时的错误
+}
+
+// SetupState 描述首次设置向导的进度，步骤按完成顺序排列
+type SetupState struct {
+	Steps          []SetupStep   `json:"steps"`
+	NextStep       string        `json:"next_step"` // 第一个未完成的步骤，全部完成时为空
+	Complete       bool          `json:"complete"`
+	DetectedAgents []string      `json:"detected_agents"`
+	Defaults       SetupDefaults `json:"defaults"`
+}
+
+// SetupStep 是设置向导中的一步：agents、token、gist 或 encryption
+type SetupStep struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Done       bool   `json:"done"`
+	Detail     string `json:"detail,omitempty"`     // 当前状态，例如检测到的 agent 数量
+	Suggestion string `json:"suggestion,omitempty"` // 未完成时的建议操作
+}
+
+// SetupDefaults 是设置向导建议的默认选项
+type SetupDefaults struct {
+	EnableEncryption bool `json:"enable_encryption"` // Gist 同步要求加密，始终建议开启
+	CreateGist       bool `json:"create_gist"`       // 还没有 Gist 时建议新建一个私有 Gist
+}
+
 type MCPServer struct {
 	ID              string            `json:"id"`
 	Name            string            `json:"name"`
 	Command         string            `json:"command"`
-	Args            []string          `json:"args"`
+	Args            []string          `json:"args"` // 字符串参数；包含对象等非字符串参数时只是 RawArgs 中的字符串部分
 	Env             map[string]string `json:"env"`
 	Enabled         bool              `json:"enabled"`
 	Description     string            `json:"description"`
 	SupportedAgents []string          `json:"supported_agents"`
+	Tags            []string          `json:"tags,omitempty"` // 分组标签，如 "work"、"personal"
 	CreatedAt       time.Time         `json:"created_at"`
+	// RawArgs 在 args 包含非字符串元素（例如带 flag 的对象）时保存完整的原始参数，转换时不会丢失
+	RawArgs []interface{} `json:"raw_args,omitempty"`
+	// Meta 是服务器配置中的 _meta 字段，供用户保存任意注释，同步和格式转换时原样保留
+	Meta map[string]interface{} `json:"_meta,omitempty"`
+	// Headers 是 HTTP 传输的服务器发送的请求头（通常用于认证），stdio 服务器没有该字段
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ArgList 返回完整的参数列表：有 RawArgs 时返回它，否则返回 Args
+func (s MCPServer) ArgList() []interface{} {
+	if s.RawArgs != nil {
+		return s.RawArgs
+	}
+	if len(s.Args) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = arg
+	}
+	return args
+}
+
+// SetArgList 设置参数列表：Args 保存其中的字符串，包含非字符串元素时 RawArgs 保存完整列表
+func (s *MCPServer) SetArgList(args []interface{}) {
+	s.Args = nil
+	s.RawArgs = nil
+	for _, arg := range args {
+		if str, ok := arg.(string); ok {
+			s.Args = append(s.Args, str)
+		} else {
+			s.RawArgs = args
+		}
+	}
 }
 
 type SyncConfig struct {
@@ -41,6 +115,90 @@ type SyncConfig struct {
 	GistEncryptionPassword string `json:"gist_encryption_password,omitempty"`
 	// 新增字段表示加密系统版本
 	EncryptionVersion string `json:"encryption_version,omitempty"`
+	// 上次轮换本地主密钥的时间
+	LastKeyRotation time.Time `json:"last_key_rotation"`
+	// Gist 中保存同步配置的文件名，为空时使用 mcp-config.json
+	GistFileName string `json:"gist_file_name,omitempty"`
+	// 命名同步配置；当前激活的配置同时写在上面的 GistID/GistFileName 中
+	Profiles      map[string]ProfileConfig `json:"profiles,omitempty"`
+	ActiveProfile string                   `json:"active_profile,omitempty"`
+	// 自动同步遇到冲突时的默认处理方式：manual, prefer_local, prefer_remote, prefer_newer（空等同 manual）
+	ConflictPolicy string `json:"conflict_policy,omitempty"`
+	// 拉取和导入配置的规模限制，为空时使用默认值
+	Limits *ConfigLimits `json:"limits,omitempty"`
+	// StripSecrets 列出推送前剥离敏感 env 值的 agent（"*" 表示全部），这些值只保留在本地
+	StripSecrets []string `json:"strip_secrets,omitempty"`
+	// MetricsEnabled 开启后在本地 metrics.json 中统计同步次数、传输量和耗时
+	MetricsEnabled bool `json:"metrics_enabled,omitempty"`
+	// ToggledOff 记录 SetAllServersEnabled 停用的服务器（agent ID -> 服务器名），重新启用时只恢复这些服务器
+	ToggledOff map[string][]string `json:"toggled_off,omitempty"`
+	// DisabledServers 记录 Gist 中标记为停用、因此没有写入配置文件的服务器（agent ID -> 服务器名 -> 标准格式配置），推送时附加回去
+	DisabledServers map[string]map[string]interface{} `json:"disabled_servers,omitempty"`
+	// PendingGistRequest 是正在创建的 Gist 的客户端请求 ID，Gist 保存到配置后清空；重试时用它找回响应丢失但已创建的 Gist
+	PendingGistRequest string `json:"pending_gist_request,omitempty"`
+	// ExtraSensitivePatterns 是追加在默认模式之后的敏感字段名模式（小写子串匹配），用于掩码和剥离组织特有的密钥字段
+	ExtraSensitivePatterns []string `json:"extra_sensitive_patterns,omitempty"`
+	// RequireEncryption 开启后，加密未启用或密钥缺失时所有推送、拉取和冲突检测都以 ErrEncryptionRequired 失败
+	RequireEncryption bool `json:"require_encryption,omitempty"`
+	// ConflictFiles 开启后，merge 遇到无法自动合并的服务器时把双方版本写入 conflicts/<操作 ID>.json，供手动解决后用 ApplyResolvedConflicts 应用
+	ConflictFiles bool `json:"conflict_files,omitempty"`
+	// EnvironmentName 是本机的环境名（例如 work、home），拉取时叠加 Gist 中同名的覆盖层；为空时使用主机名
+	EnvironmentName string `json:"environment_name,omitempty"`
+	// SchemaSafeMode 开启后，推送前用 agents.yaml 中配置的 JSON schema 校验每个 agent 的配置，不符合时拒绝推送
+	SchemaSafeMode bool `json:"schema_safe_mode,omitempty"`
+	// CredentialHelper 是提供 GitHub token 的命令（类似 git 的凭据助手），优先于 GitHubToken；环境变量 MCP_SYNC_GITHUB_TOKEN 又优先于它
+	CredentialHelper string `json:"credential_helper,omitempty"`
+	// ExpandCodexEnv 开启后，写入 Codex 配置时把 env 值中的 ${NAME} 展开为本机环境变量的值（Codex 不会自己展开）；
+	// 推送时仍还原为 ${NAME}，其他机器按自己的环境展开
+	ExpandCodexEnv bool `json:"expand_codex_env,omitempty"`
+	// BackupRetention 是 agent 配置备份的保留策略，为 nil 时保留所有备份
+	BackupRetention *BackupRetention `json:"backup_retention,omitempty"`
+	// PushValidation 决定推送时遇到校验失败的 agent 如何处理：skip（默认，跳过该 agent 并记录）或 strict（中止整次推送）
+	PushValidation string `json:"push_validation,omitempty"`
+	// PortablePaths 开启后，推送时把服务器中位于锚点目录下的绝对路径写成 ${HOME}/...、${WORKSPACE}/... 的形式，
+	// 写入 agent 配置时再按本机的锚点还原为绝对路径
+	PortablePaths bool `json:"portable_paths,omitempty"`
+	// PathAnchors 是除 HOME（始终为本机主目录）外的路径锚点，锚点名到本机目录，例如 WORKSPACE -> /Users/me/code
+	PathAnchors map[string]string `json:"path_anchors,omitempty"`
+}
+
+// SyncMetrics 是本地统计的同步指标，不会上传到任何地方
+type SyncMetrics struct {
+	Pushes                int       `json:"pushes"`                   // 成功的推送次数
+	Pulls                 int       `json:"pulls"`                    // 成功的拉取次数
+	Failures              int       `json:"failures"`                 // 失败的推送和拉取次数
+	Conflicts             int       `json:"conflicts"`                // 检测到的冲突次数
+	BytesPushed           int64     `json:"bytes_pushed"`             // 推送的配置内容（加密前）字节数
+	BytesPulled           int64     `json:"bytes_pulled"`             // 拉取的配置内容（解密后）字节数
+	SyncCount             int       `json:"sync_count"`               // 计入耗时的同步次数，包括失败的
+	TotalSyncDurationMs   float64   `json:"total_sync_duration_ms"`   // 累计耗时
+	AverageSyncDurationMs float64   `json:"average_sync_duration_ms"` // 平均耗时
+	LastSyncDurationMs    float64   `json:"last_sync_duration_ms"`
+	Since                 time.Time `json:"since"` // 开始统计（或上次重置）的时间
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// ConfigLimits 限制从 Gist 拉取或由用户导入的配置规模，避免损坏或恶意的内容被写入所有 agent。
+// A zero field disables that check.
+type ConfigLimits struct {
+	MaxPayloadBytes int `json:"max_payload_bytes"`
+	MaxServers      int `json:"max_servers"`
+	MaxDepth        int `json:"max_depth"`
+}
+
+// BackupRetention 决定每个 agent 保留哪些配置备份：最近的 MaxCount 个和 MaxAgeDays 天内的备份都保留，
+// 两个条件都不满足的才删除。字段为 0 表示不按该项保留，两个都为 0 时不删除任何备份
+type BackupRetention struct {
+	MaxCount   int `json:"max_count"`
+	MaxAgeDays int `json:"max_age_days"`
+}
+
+// ProfileConfig 是一个命名同步配置，拥有自己的 Gist、文件名和 agent 范围
+type ProfileConfig struct {
+	Name         string   `json:"name"`
+	GistID       string   `json:"gist_id"`
+	GistFileName string   `json:"gist_file_name,omitempty"`
+	Agents       []string `json:"agents,omitempty"` // 为空表示所有已安装的 agent
 }
 
 type SyncLog struct {
@@ -52,6 +210,19 @@ type SyncLog struct {
 	Details   string    `json:"details"`
 }
 
+// AuditEntry 是审计日志中的一条记录：工具写入的一个文件
+type AuditEntry struct {
+	ID          string    `json:"id"`
+	Timestamp   time.Time `json:"timestamp"`
+	OperationID string    `json:"operation_id"` // 同一操作（如一次拉取）中的所有写入共享该 ID
+	Operation   string    `json:"operation"`    // pull, pull_merge, import, save_agent_config 等
+	Kind        string    `json:"kind"`         // config: agent 配置文件, backup: 备份文件
+	AgentID     string    `json:"agent_id,omitempty"`
+	Path        string    `json:"path"`
+	BeforeHash  string    `json:"before_hash,omitempty"` // 写入前文件内容的 SHA-256，文件原本不存在时为空
+	AfterHash   string    `json:"after_hash"`
+}
+
 type ConfigVersion struct {
 	ID        string    `json:"id"`
 	Timestamp time.Time `json:"timestamp"`
@@ -61,10 +232,234 @@ type ConfigVersion struct {
 	Hash      string    `json:"hash"` // SHA256 hash for comparison
 }
 
+// ConfigVersionList 是版本列表及因无法解密而跳过的文件数
+type ConfigVersionList struct {
+	Versions []ConfigVersion `json:"versions"`
+	Skipped  int             `json:"skipped"`
+}
+
+// SyncLogList 是同步日志列表及因无法解密而跳过的文件数
+type SyncLogList struct {
+	Logs    []SyncLog `json:"logs"`
+	Skipped int       `json:"skipped"`
+}
+
 type SyncConflict struct {
 	HasConflict   bool           `json:"has_conflict"`
 	ConflictType  string         `json:"conflict_type"` // push_conflict, pull_conflict
 	LocalVersion  *ConfigVersion `json:"local_version"`
 	RemoteVersion *ConfigVersion `json:"remote_version"`
 	Message       string         `json:"message"`
+	// 按冲突策略预选的处理方式（keep_local / use_remote），manual 时为空
+	SuggestedResolution string `json:"suggested_resolution,omitempty"`
+}
+
+// ResolutionResult 描述 ResolveConflict 的结果。服务器按本机安装的 agent 统计，每个服务器只计入一项
+type ResolutionResult struct {
+	Strategy   string `json:"strategy"`    // keep_local, use_remote, merge
+	KeptLocal  int    `json:"kept_local"`  // 两边不同、采用本地版本的服务器
+	KeptRemote int    `json:"kept_remote"` // 两边不同、采用远程版本的服务器
+	Merged     int    `json:"merged"`      // 合并了两边修改的服务器
+	Unchanged  int    `json:"unchanged"`   // 两边相同的服务器
+	Pushed     bool   `json:"pushed"`
+	Pulled     bool   `json:"pulled"`
+	// VersionID 是解决后本地最新的配置版本
+	VersionID string `json:"version_id,omitempty"`
+}
+
+// ConflictFile 是 merge 无法自动合并时写入的手动解决文档，每个冲突的服务器并列给出本地和远程版本
+type ConflictFile struct {
+	OperationID  string           `json:"operation_id"`
+	CreatedAt    time.Time        `json:"created_at"`
+	Instructions string           `json:"instructions"`
+	Conflicts    []ServerConflict `json:"conflicts"`
+}
+
+// ServerConflict 是一个无法自动合并的服务器。Local 或 Remote 为 null 表示该侧删除了这个服务器
+type ServerConflict struct {
+	AgentID string      `json:"agent_id"`
+	Section string      `json:"section"` // agent 配置中的服务器键，例如 mcpServers
+	Server  string      `json:"server"`
+	Local   interface{} `json:"local"`
+	Remote  interface{} `json:"remote"`
+	// 由用户填写：local、remote、delete，或 custom 并在 Resolved 中给出合并后的配置
+	Resolution string      `json:"resolution"`
+	Resolved   interface{} `json:"resolved,omitempty"`
+}
+
+// CredStatus 是已保存的 GitHub 凭据最近一次检查的结果
+type CredStatus struct {
+	Status  string `json:"status"`  // valid, expired, gist_inaccessible, unreachable, not_configured
+	Message string `json:"message"` // 给用户看的说明，例如需要重新认证
+	// Transient 表示网络错误或限流等临时问题，凭据本身可能仍然有效，稍后会重新检查
+	Transient bool      `json:"transient"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// AutoSyncResult 是一次自动同步的结果
+type AutoSyncResult struct {
+	Action   string        `json:"action"` // none, push, pull, conflict, paused
+	Policy   string        `json:"policy"`
+	Conflict *SyncConflict `json:"conflict,omitempty"`
+	Message  string        `json:"message"`
+}
+
+// PullResult 是 PullFromGistMerge 的结果，按 agent 列出合并时的变化
+type PullResult struct {
+	Agents       []AgentPullResult `json:"agents"`
+	AppliedCount int               `json:"applied_count"` // 写入了配置文件的 agent 数量
+}
+
+// AgentPullResult 描述一个 agent 在合并拉取中的变化
+type AgentPullResult struct {
+	AgentID string   `json:"agent_id"`
+	Added   []string `json:"added"`           // 只在远程存在、新增到本地的服务器
+	Updated []string `json:"updated"`         // 本地也有但内容不同、已替换为远程版本的服务器
+	Kept    []string `json:"kept"`            // 只在本地存在、被保留的服务器
+	Error   string   `json:"error,omitempty"` // 读取或写入失败时的错误
+}
+
+// StagedPull 是已从 Gist 拉取、尚未写入 agent 配置文件的远程配置，供用户审阅后用 CommitStagedPull 应用
+type StagedPull struct {
+	StagedAt time.Time `json:"staged_at"`
+	// Agents 是每个本机安装的 agent 的远程配置相对本地的变化，按 agent ID 排序
+	Agents []StagedAgentPull `json:"agents"`
+	// Configs 是暂存的远程配置（agent ID -> 完整配置），提交时原样写入
+	Configs map[string]interface{} `json:"configs"`
+}
+
+// StagedAgentPull 描述提交暂存的拉取后一个 agent 的服务器会如何变化
+type StagedAgentPull struct {
+	AgentID   string             `json:"agent_id"`
+	Added     []string           `json:"added"`     // 只在远程存在，提交后新增
+	Removed   []string           `json:"removed"`   // 只在本地存在，提交后删除
+	Updated   []ServerDifference `json:"updated"`   // 两边不同的服务器；字段差异中 A 为本地值，B 为远程值
+	Unchanged []string           `json:"unchanged"` // 两边相同的服务器
+}
+
+// InitResult 是 InitializeGistSync 的结果
+type InitResult struct {
+	GistID        string `json:"gist_id"`
+	Created       bool   `json:"created"` // true when a new gist was created rather than an existing one reused
+	TokenScopesOK bool   `json:"token_scopes_ok"`
+}
+
+// AgentConfigResult 是 agent 的 MCP 配置及其来源文件的信息
+type AgentConfigResult struct {
+	Servers    map[string]interface{} `json:"servers"` // 以 ConfigKey 为键，与 GetAgentMCPConfig 的返回值相同
+	Format     string                 `json:"format"`  // json、json5 或 toml
+	ConfigPath string                 `json:"config_path"`
+	ConfigKey  string                 `json:"config_key"`
+}
+
+type PendingPush struct {
+	ID        string                 `json:"id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Agents    map[string]interface{} `json:"agents"`
+}
+
+// DoctorFinding 是一致性检查发现的单个问题
+type DoctorFinding struct {
+	Check      string `json:"check"`    // encryption, token, gist, data_dir, storage_files, agents
+	Severity   string `json:"severity"` // info, warning, error
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// DoctorReport 汇总一致性检查的结果
+type DoctorReport struct {
+	CheckedAt time.Time       `json:"checked_at"`
+	Healthy   bool            `json:"healthy"` // true when no finding has error severity
+	Findings  []DoctorFinding `json:"findings"`
+}
+
+// AgentComparison 是两个 agent 的 MCP 服务器（统一为标准结构后）的对比结果
+type AgentComparison struct {
+	AgentA    string             `json:"agent_a"`
+	AgentB    string             `json:"agent_b"`
+	OnlyInA   []string           `json:"only_in_a"`
+	OnlyInB   []string           `json:"only_in_b"`
+	Identical []string           `json:"identical"` // 两边都有且完全相同的服务器
+	Different []ServerDifference `json:"different"` // 两边都有但字段不同的服务器
+}
+
+// AgentSyncResult 是在两个 agent 之间同步服务器的结果
+type AgentSyncResult struct {
+	Written   bool                `json:"written"`           // 是否写入了目标；目标已经是同样的服务器时为 false
+	Converted []string            `json:"converted"`         // 写入目标的服务器
+	Skipped   map[string]string   `json:"skipped"`           // 没有写入的服务器及原因（目标格式无法表示或转换后无效）
+	Dropped   map[string][]string `json:"dropped,omitempty"` // 写入了但丢失了部分字段的服务器及丢失的字段
+}
+
+// ServerDifference 列出同名服务器在两个 agent 中不同的字段
+type ServerDifference struct {
+	Name   string            `json:"name"`
+	Fields []FieldDifference `json:"fields"`
+}
+
+// FieldDifference 是一个字段在两边的值；嵌套字段用点分隔（如 env.API_KEY），缺少该字段的一边为 nil
+type FieldDifference struct {
+	Field string      `json:"field"`
+	A     interface{} `json:"a"`
+	B     interface{} `json:"b"`
+}
+
+// SyncPlan 是一次推送或拉取的预演结果，生成时不写入任何文件
+type SyncPlan struct {
+	Direction string          `json:"direction"` // push, pull
+	Agents    []AgentSyncPlan `json:"agents"`
+}
+
+// AgentSyncPlan 描述同步中单个 agent 的读写、格式转换和会丢失的服务器
+type AgentSyncPlan struct {
+	AgentID    string   `json:"agent_id"`
+	Action     string   `json:"action"` // read, write, skip
+	ConfigPath string   `json:"config_path,omitempty"`
+	Conversion string   `json:"conversion,omitempty"` // 例如 "standard -> codex_toml"
+	Servers    []string `json:"servers"`
+	// Dropped 是目标格式无法表示、写入时会被丢弃的服务器
+	Dropped []string `json:"dropped,omitempty"`
+	// WindowsWrapped 是以 cmd /c npx 包装、只能在 Windows 上运行的服务器
+	WindowsWrapped []string `json:"windows_wrapped,omitempty"`
+	Reason         string   `json:"reason,omitempty"`
+}
+
+// PathIssue 是服务器 args、env、cwd 或 command 中指向本机不存在的文件或目录的路径
+type PathIssue struct {
+	Server string `json:"server"`
+	Field  string `json:"field"` // 例如 args[2]、env.ROOT_DIR、cwd、command
+	Path   string `json:"path"`
+	Reason string `json:"reason"` // missing, inaccessible
+}
+
+// SyncStatus 是本地 API 的 /api/status 返回的同步状态，不包含 token 等凭据
+type SyncStatus struct {
+	GistConfigured    bool        `json:"gist_configured"`
+	GistID            string      `json:"gist_id,omitempty"`
+	EncryptionEnabled bool        `json:"encryption_enabled"`
+	AutoSync          bool        `json:"auto_sync"`
+	LastSyncTime      time.Time   `json:"last_sync_time"`
+	LastSyncStatus    string      `json:"last_sync_status"`
+	Credentials       *CredStatus `json:"credentials,omitempty"`       // 尚未检查凭据时为空
+	SyncPausedUntil   *time.Time  `json:"sync_paused_until,omitempty"` // 自动同步暂停到该时间，未暂停时为空
+}
+
+// CompactReport 是 CompactStorage 整理数据目录的结果；文件路径相对于数据目录
+type CompactReport struct {
+	BackupsPruned int      `json:"backups_pruned"` // 按备份保留策略删除的备份数
+	Reencrypted   []string `json:"reencrypted"`    // 改写为当前加密方式的文件
+	Quarantined   []string `json:"quarantined"`    // 移到 QuarantineDir 的无法解密的文件，格式为 "路径: 原因"
+	// QuarantineDir 是本次移走的无法解密文件所在的目录（数据目录下的 orphaned_*），没有文件被移走时为空
+	QuarantineDir string `json:"quarantine_dir,omitempty"`
+	// Kept 是无法解密但留在原处的文件及原因（例如密钥丢失，或没有任何文件能用当前密钥解密）
+	Kept         []string `json:"kept,omitempty"`
+	IndexEntries int      `json:"index_entries"` // 重建后版本索引中的条目数
+	IndexDropped int      `json:"index_dropped"` // 从版本索引中去掉的条目数（版本文件已不存在或无法读取）
+}
+
+// HistoryArchiveOptions 决定 ExportHistoryArchive 写出的归档格式
+type HistoryArchiveOptions struct {
+	Compress bool `json:"compress"` // 用 gzip 压缩归档
+	// KeepEncrypted 为 true 时按磁盘上的原样导出加密的文件，只能导入到持有同一密钥的安装中；否则解密后导出
+	KeepEncrypted bool `json:"keep_encrypted"`
 }
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"mcp-sync/models"
+)
+
+// afterAgentSyncWrite 在 SyncConfigBetweenAgents 写入目标文件之后、校验之前调用；测试用它模拟写坏的文件
+var afterAgentSyncWrite = func(path string) {}
+
+// planAgentSync 决定转换后（目标格式结构、以 targetKey 为键）的哪些服务器写入目标：目标格式无法表示的
+// 和转换后不能通过 ValidateMCPServer 的服务器被跳过，其余的写入。返回要写入的服务器，以及与源 agent
+// 的标准结构服务器对比得到的结果；写入了但缺少源服务器某些字段的服务器记录在 Dropped 中
+func planAgentSync(source, converted map[string]interface{}, targetKey, targetFormat string) (map[string]interface{}, *models.AgentSyncResult) {
+	kept := make(map[string]interface{}, len(converted))
+	for name, server := range converted {
+		kept[name] = server
+	}
+	// Codex only holds stdio servers; leave out what it would drop so the result reports it
+	if isTOMLFormat(targetFormat) {
+		kept, _ = dropCodexUnsupported(kept)
+	}
+	target, _ := standardServersFrom(map[string]interface{}{targetKey: kept}, targetKey)
+
+	result := &models.AgentSyncResult{Converted: []string{}, Skipped: map[string]string{}}
+	for _, name := range sortedKeys(source) {
+		server, ok := target[name]
+		if !ok {
+			result.Skipped[name] = fmt.Sprintf("not supported by the %s format", targetFormat)
+			continue
+		}
+		if problems := ValidateMCPServer(name, server); len(problems) > 0 {
+			result.Skipped[name] = strings.Join(problems, "; ")
+			delete(kept, name)
+			continue
+		}
+		result.Converted = append(result.Converted, name)
+
+		sourceMap, _ := source[name].(map[string]interface{})
+		serverMap, _ := server.(map[string]interface{})
+		var dropped []string
+		for field := range sourceMap {
+			if _, ok := serverMap[field]; !ok {
+				dropped = append(dropped, field)
+			}
+		}
+		if len(dropped) > 0 {
+			sort.Strings(dropped)
+			if result.Dropped == nil {
+				result.Dropped = make(map[string][]string)
+			}
+			result.Dropped[name] = dropped
+		}
+	}
+	return kept, result
+}
+
+// writeAgentConfigVerified 备份目标 agent 的配置后写入 config，再读回检查 servers 中的每个服务器都在且有效。
+// 写入或检查失败时把文件恢复为写入前的内容（原来没有文件时删除），并返回错误
+func (as *AppService) writeAgentConfigVerified(agentID string, config map[string]interface{}, servers []string) error {
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		return err
+	}
+	original, err := os.ReadFile(configPath)
+	existed := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s before writing: %w", agentID, err)
+	}
+	if _, err := as.backupAgentConfig(agentID); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", agentID, err)
+	}
+
+	err = as.SaveAgentMCPConfig(agentID, config)
+	if err == nil {
+		afterAgentSyncWrite(configPath)
+		err = as.verifyAgentServers(agentID, servers)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if restoreErr := as.restoreAgentFile(agentID, configPath, original, existed); restoreErr != nil {
+		return fmt.Errorf("failed to write %s (%v) and failed to roll back: %w", agentID, err, restoreErr)
+	}
+	println(fmt.Sprintf("Rolled back %s after a failed write: %v", agentID, err))
+	return fmt.Errorf("failed to write %s, rolled back: %w", agentID, err)
+}
+
+// verifyAgentServers 读回 agent 的配置，检查 servers 中的每个服务器都存在并通过 ValidateMCPServer
+func (as *AppService) verifyAgentServers(agentID string, servers []string) error {
+	written, err := as.standardAgentServers(agentID)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for _, name := range servers {
+		server, ok := written[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("Server %s: missing after write", name))
+			continue
+		}
+		problems = append(problems, ValidateMCPServer(name, server)...)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrValidationFailed, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// restoreAgentFile 把 agent 配置文件恢复为 original；existed 为 false 时文件原本不存在，删除它
+func (as *AppService) restoreAgentFile(agentID, path string, original []byte, existed bool) error {
+	return as.auditedWrite("rollback", agentID, path, func() error {
+		if !existed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		return os.WriteFile(path, original, 0644)
+	})
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyncBetweenAgentsWritesAndBacksUp(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}, "git": {"command": "git-mcp"}}}`)
+	zedPath := writeAgentFile(t, as, "zed", `{"theme": "One Dark", "context_servers": {}}`)
+
+	result, err := as.SyncConfigBetweenAgents("cursor", "zed")
+	if err != nil {
+		t.Fatalf("SyncConfigBetweenAgents() error = %v", err)
+	}
+	if !result.Written || !reflect.DeepEqual(result.Converted, []string{"fetch", "git"}) || len(result.Skipped) != 0 {
+		t.Errorf("SyncConfigBetweenAgents() = %+v, want fetch and git written", result)
+	}
+	if text := readFile(t, zedPath); !strings.Contains(text, "mcp-server-fetch") || !strings.Contains(text, "One Dark") {
+		t.Errorf("zed config after sync:\n%s", text)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(as.storage.dataDir, "backups", "zed_*"))
+	if len(backups) != 1 {
+		t.Errorf("found %d zed backups, want 1", len(backups))
+	}
+}
+
+func TestSyncBetweenAgentsReportsSkippedServers(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]},
+		"remote": {"url": "https://mcp.example.com/sse", "headers": {"Authorization": "Bearer x"}},
+		"broken": {"args": ["no-command"]}
+	}}`)
+	codexPath := writeAgentFile(t, as, "codex", "model = \"o3\"\n")
+
+	result, err := as.SyncConfigBetweenAgents("cursor", "codex")
+	if err != nil {
+		t.Fatalf("SyncConfigBetweenAgents() error = %v", err)
+	}
+	if !result.Written || !reflect.DeepEqual(result.Converted, []string{"fetch"}) {
+		t.Errorf("SyncConfigBetweenAgents() = %+v, want only fetch written", result)
+	}
+	if !strings.Contains(result.Skipped["remote"], "codex_toml") || !strings.Contains(result.Skipped["broken"], "command") {
+		t.Errorf("skipped = %v, want remote (unsupported) and broken (invalid)", result.Skipped)
+	}
+
+	text := readFile(t, codexPath)
+	if !strings.Contains(text, "mcp-server-fetch") || strings.Contains(text, "mcp.example.com") || strings.Contains(text, "no-command") {
+		t.Errorf("codex config after sync:\n%s", text)
+	}
+}
+
+func TestSyncBetweenAgentsRollsBackFailedWrite(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+	original := `{"theme": "One Dark", "context_servers": {"old": {"command": "old-mcp", "source": "custom"}}}`
+	zedPath := writeAgentFile(t, as, "zed", original)
+
+	previous := afterAgentSyncWrite
+	afterAgentSyncWrite = func(path string) {
+		// A write cut off halfway
+		os.WriteFile(path, []byte(`{"theme": "One Dark", "context_ser`), 0644)
+	}
+	t.Cleanup(func() { afterAgentSyncWrite = previous })
+
+	if _, err := as.SyncConfigBetweenAgents("cursor", "zed"); err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("SyncConfigBetweenAgents() error = %v, want a rolled back write", err)
+	}
+	if text := readFile(t, zedPath); text != original {
+		t.Errorf("zed config was not rolled back:\n%s", text)
+	}
+
+	// A target that did not exist before is removed again
+	if err := os.Remove(zedPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.SyncConfigBetweenAgents("cursor", "zed"); err == nil {
+		t.Fatal("SyncConfigBetweenAgents() succeeded with a broken write")
+	}
+	if _, err := os.Stat(zedPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("new zed config was left behind after the rollback: %v", err)
+	}
+}
@@ -1,18 +1,35 @@
 package services
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"mcp-sync/models"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
+// AppService 是前端调用的服务入口，其方法可以被多个 goroutine 同时调用（UI 操作与自动同步）。
+//
+// Concurrency contract:
+//   - configMu guards the gistSync pointer and every load-modify-save of the sync config.
+//     A published GistSyncService is never mutated; credential changes swap in a copy.
+//   - syncMu serializes operations that read and write remote and local configs as a unit
+//     (push, pull, merge, key rotation). Acquire syncMu before configMu, never the reverse.
+//   - fileLocks holds one mutex per agent config path; auditedWrite takes it around the write,
+//     and SaveAgentMCPConfig from reading the local file through the write, so agents written
+//     in parallel never write the same file at once or overwrite each other's changes.
 type AppService struct {
 	detector      *AgentDetector
 	configManager *ConfigManager
@@ -23,88 +40,261 @@ type AppService struct {
 	windowsSvc    *WindowsService
 	converter     *ConfigConverter
 	tomlAdapter   *TOMLAdapter
+	secrets       SecretStore
+	metrics       *Metrics
+	// syncMu serializes sync operations with key rotation
+	syncMu sync.Mutex
+	// configMu guards gistSync and sync config read-modify-write
+	configMu sync.Mutex
+	// auditMu guards operation, the operation file writes are recorded under in the audit log
+	auditMu   sync.Mutex
+	operation *auditOperation
+	// credMu guards credStatus, the result of the last credential check
+	credMu     sync.Mutex
+	credStatus *models.CredStatus
+	// fileLocks maps a config file path to the *sync.Mutex serializing writes to it
+	fileLocks sync.Map
+	// pauseMu guards pausedUntil, the time auto-sync stays paused until (zero when not paused)
+	pauseMu     sync.Mutex
+	pausedUntil time.Time
+	// keyring holds the master key shared by local storage and gist encryption; nil when unavailable
+	keyring SystemKeyring
 }
 
+// NewAppService 创建应用服务，本地状态保存在 DataDir()（MCP_SYNC_HOME 或 ~/.mcp-sync）中，密钥保存在系统密钥环中
 func NewAppService() (*AppService, error) {
-	// Initialize storage
-	// 优先使用 USERPROFILE (Windows)，其次是 HOME (Unix/Linux/macOS)
-	homeDir := os.Getenv("USERPROFILE")
-	if homeDir == "" {
-		homeDir = os.Getenv("HOME")
+	dataDir, err := DataDir()
+	if err != nil {
+		return nil, err
 	}
-
-	if homeDir == "" {
-		return nil, fmt.Errorf("could not determine user home directory")
+	keyring, err := NewSystemKeyring()
+	if err != nil {
+		// 如果系统密钥环不可用，仍然返回服务但加密功能将被禁用
+		fmt.Printf("Warning: failed to initialize system keyring: %v\n", err)
 	}
+	return NewAppServiceWithKeyring(dataDir, keyring)
+}
 
-	dataDir := filepath.Join(homeDir, ".mcp-sync")
-	storage, err := NewStorageService(dataDir)
+// NewAppServiceWithKeyring 创建应用服务，本地状态保存在 dataDir 中，加密密钥和 secret 保存在 keyring 中
+// （测试中使用 InMemoryKeyring，不访问系统密钥环）；keyring 为 nil 时加密不可用
+func NewAppServiceWithKeyring(dataDir string, keyring SystemKeyring) (*AppService, error) {
+	// Initialize storage
+	storage, err := NewStorageServiceWithKeyring(dataDir, osFileSystem{}, realClock{}, keyring)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
 
+	// A broken agents.yaml stops here; the detector and config manager share this loader
 	configLoader, err := NewConfigLoader()
 	if err != nil {
 		return nil, err
 	}
+	detector := newAgentDetector(configLoader)
 
 	// 创建安全管理器（使用 gist ID 作为加密密钥的一部分）
-	securityMgr := NewSecurityManager(homeDir)
+	// The legacy key is derived from the home directory; without one, the data directory stands in
+	securityKey := userHomeDir()
+	if securityKey == "" {
+		securityKey = dataDir
+	}
+	securityMgr := NewSecurityManager(securityKey)
 
 	converter := NewConfigConverter(configLoader)
 	tomlAdapter := NewTOMLAdapter()
 
-	return &AppService{
-		detector:      NewAgentDetector(),
-		configManager: NewConfigManager(),
+	as := &AppService{
+		detector:      detector,
+		configManager: newConfigManager(detector),
 		configLoader:  configLoader,
 		storage:       storage,
 		securityMgr:   securityMgr,
 		windowsSvc:    NewWindowsService(),
 		converter:     converter,
 		tomlAdapter:   tomlAdapter,
-	}, nil
+		metrics:       NewMetrics(storage),
+		keyring:       keyring,
+	}
+
+	// Secrets referenced as ${secret:name} live in the system keyring
+	if storage.crypto != nil {
+		as.secrets = storage.crypto
+	}
+
+	// Custom sensitive-field patterns apply to every IsSensitiveField check
+	if syncConfig, err := storage.LoadSyncConfig(); err == nil {
+		SetExtraSensitivePatterns(syncConfig.ExtraSensitivePatterns)
+	} else {
+		SetExtraSensitivePatterns(nil)
+	}
+
+	return as, nil
 }
 
 func (as *AppService) DetectAgents() ([]models.Agent, error) {
 	return as.detector.DetectInstalledAgents()
 }
 
+// GetAgentCatalog 返回所有已定义的 agent，包括未安装或当前平台不支持的，并附上检测状态和已配置的服务器数量
+func (as *AppService) GetAgentCatalog() ([]models.AgentCatalogEntry, error) {
+	var catalog []models.AgentCatalogEntry
+	for _, def := range as.configLoader.GetAgentDefinitions() {
+		entry := models.AgentCatalogEntry{
+			ID:            def.ID,
+			Name:          def.Name,
+			Description:   def.Description,
+			Format:        def.Format,
+			ConfigKey:     def.ConfigKey,
+			ConfigPaths:   as.configLoader.GetConfigPathsForAgent(def.ID),
+			ExistingPaths: as.configLoader.GetExistingConfigPaths(def.ID),
+		}
+
+		switch {
+		case len(entry.ConfigPaths) == 0:
+			entry.Status = "unsupported"
+		case len(entry.ExistingPaths) == 0:
+			entry.Status = "not_installed"
+		default:
+			entry.Status = "detected"
+			config, err := as.readAgentMCPConfig(def.ID)
+			if err != nil {
+				entry.Error = err.Error()
+			} else if servers, ok := standardServersFrom(config, def.ConfigKey); ok {
+				entry.ServerCount = len(servers)
+				entry.HasServers = len(servers) > 0
+			}
+		}
+
+		catalog = append(catalog, entry)
+	}
+	return catalog, nil
+}
+
+// InitializeGistSync 是 InitializeGistSyncDetailed 的简化版本，只返回 Gist ID
 func (as *AppService) InitializeGistSync(token, gistID string) (string, error) {
+	result, err := as.InitializeGistSyncDetailed(token, gistID)
+	if err != nil {
+		return "", err
+	}
+	return result.GistID, nil
+}
+
+// InitializeGistSyncDetailed 检查 token 权限，创建新 Gist（gistID 为空时）或验证已有 Gist，然后保存同步配置。
+// 失败时返回的错误可用 errors.Is 区分：ErrUnauthorized（token 无效）、ErrTokenMissingGistScope、
+// ErrGistNotFound、ErrGistNoAccess，网络错误则原样返回
+func (as *AppService) InitializeGistSyncDetailed(token, gistID string) (*models.InitResult, error) {
+	result := &models.InitResult{}
+	current, err := as.GetSyncConfig()
+	if err != nil {
+		return result, fmt.Errorf("failed to load sync config: %w", err)
+	}
+
+	// Without an explicit token, use the one from the environment or the credential helper
+	external := false
+	if token == "" {
+		var source string
+		var err error
+		token, source, err = resolveGitHubToken(current)
+		if err != nil {
+			return result, err
+		}
+		if token == "" {
+			return result, fmt.Errorf("GitHub token is required")
+		}
+		external = source != TokenSourceStored
+	}
+
+	if err := as.newGistSyncFor(token, "", current).ValidateTokenScopes(); err != nil {
+		return result, err
+	}
+	result.TokenScopesOK = true
+
 	// If no gistID provided, create a new gist
 	if gistID == "" {
-		gs := NewGistSyncService(token, "")
-		var err error
-		gistID, err = gs.CreateGist([]models.MCPServer{}, "MCP Sync Configuration")
+		requestID, err := as.gistRequestID()
+		if err != nil {
+			return result, err
+		}
+		gs := as.newGistSyncFor(token, "", current)
+		gistID, err = gs.CreateGistOnce(requestID, []models.MCPServer{}, "MCP Sync Configuration")
 		if err != nil {
-			return "", fmt.Errorf("failed to create new gist: %w", err)
+			return result, fmt.Errorf("failed to create new gist: %w", err)
 		}
 		println(fmt.Sprintf("Created new Gist with ID: %s", gistID))
+		result.Created = true
+	} else {
+		// Make sure an existing gist is usable before saving it
+		if err := as.newGistSyncFor(token, gistID, current).ValidateGist(); err != nil {
+			return result, err
+		}
 	}
+	result.GistID = gistID
 
-	as.gistSync = NewGistSyncService(token, gistID)
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
 	// Save sync config to storage
-	config, _ := as.storage.LoadSyncConfig()
-	config.GitHubToken = token
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return result, fmt.Errorf("failed to load sync config: %w", err)
+	}
+	as.gistSync = as.newGistSyncFor(token, gistID, config)
+
+	// Tokens from the environment or a credential helper are resolved again when needed and never written to disk
+	if !external {
+		config.GitHubToken = token
+	}
 	config.GistID = gistID
+	config.PendingGistRequest = ""
 	config.LastUpdateTime = nowTime()
 
 	if err := as.storage.SaveSyncConfig(config); err != nil {
-		return "", err
+		return result, err
 	}
 
-	return gistID, nil
+	return result, nil
+}
+
+// gistRequestID 返回创建 Gist 时使用的客户端请求 ID。ID 在创建成功并保存之前一直保留在同步配置中，
+// 因此响应丢失后的重试会找到上一次已经创建的 Gist
+func (as *AppService) gistRequestID() (string, error) {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load sync config: %w", err)
+	}
+	if config.PendingGistRequest == "" {
+		config.PendingGistRequest = genID()
+		if err := as.storage.SaveSyncConfig(config); err != nil {
+			return "", fmt.Errorf("failed to save gist request ID: %w", err)
+		}
+	}
+	return config.PendingGistRequest, nil
 }
 
 // SetupGistEncryption 配置 Gist 同步的加密
 func (as *AppService) SetupGistEncryption(enabled bool, password string) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
 	if as.gistSync == nil {
 		return fmt.Errorf("gist sync not initialized")
 	}
 
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+
+	// Enable local storage encryption (使用系统密钥环) before the config claims it is on
+	if enabled {
+		if err := as.storage.EnableEncryption(""); err != nil { // 新版本不需要密码参数
+			return fmt.Errorf("failed to enable encryption: %w", err)
+		}
+	}
+
 	// Also save encryption config to storage
-	config, _ := as.storage.LoadSyncConfig()
 	config.EnableEncryption = enabled
 	config.EncryptionVersion = "2.0" // 标记使用新版本加密系统
 	config.LastUpdateTime = nowTime()
@@ -126,127 +316,144 @@ func (as *AppService) SetupGistEncryption(enabled bool, password string) error {
 		return fmt.Errorf("failed to save encryption config: %w", err)
 	}
 
-	// Enable local storage encryption (使用系统密钥环)
-	if enabled {
-		as.storage.EnableEncryption("") // 新版本不需要密码参数
+	// Configure a copy so operations already holding the current backend are unaffected
+	gs := as.gistSync.WithCredentials(as.gistSync.githubToken, as.gistSync.gistID)
+	if err := gs.SetEncryption(enabled, password); err != nil {
+		return err
 	}
-
-	return as.gistSync.SetEncryption(enabled, password)
+	as.gistSync = gs
+	return nil
 }
 
-func (as *AppService) ValidateGitHubToken(token string) error {
-	gs := NewGistSyncService(token, "")
-	return gs.ValidateToken()
-}
+// CompleteEncryptionMigration 完成从旧版密码加密到系统密钥环加密的迁移：启用了加密时把本地数据全部改为用密钥环中的主密钥加密，
+// 确认每个文件都能用主密钥读取后，再清空并移除配置中的 EncryptionPassword 和 GistEncryptionPassword。任一步失败时密码字段保持不变
+func (as *AppService) CompleteEncryptionMigration() error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("encryption_migration")()
+
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
-// PushAllAgentsToGist 推送所有已安装 agents 的完整配置到 Gist（保留完整的原始配置）
-func (as *AppService) PushAllAgentsToGist() error {
-	// Load sync config to get credentials
 	config, err := as.storage.LoadSyncConfig()
 	if err != nil {
 		return fmt.Errorf("failed to load sync config: %w", err)
 	}
-
-	if config.GitHubToken == "" || config.GistID == "" {
-		return fmt.Errorf("GitHub token or Gist ID not configured")
+	password := config.GistEncryptionPassword
+	if password == "" {
+		password = config.EncryptionPassword
 	}
 
-	// Initialize gist sync if not already done
-	if as.gistSync == nil {
-		as.gistSync = NewGistSyncService(config.GitHubToken, config.GistID)
-
-		// Setup encryption if enabled
-		if config.EnableEncryption {
-			password := config.GistEncryptionPassword
-			// 如果新字段为空但旧字段有值，使用旧字段（迁移场景）
-			if password == "" && config.EncryptionPassword != "" {
-				password = config.EncryptionPassword
-			}
-			as.gistSync.SetEncryption(config.EnableEncryption, password)
+	if config.EnableEncryption {
+		if err := as.storage.MigrateLegacyEncryption(password); err != nil {
+			return fmt.Errorf("failed to migrate local data to keyring encryption: %w", err)
 		}
+		config.EncryptionVersion = "2.0"
 	}
 
-	// Collect all agents' COMPLETE configurations (not just servers)
-	agents, err := as.detector.DetectInstalledAgents()
-	if err != nil {
-		return fmt.Errorf("failed to detect agents: %w", err)
+	config.GistEncryptionPassword = ""
+	config.EncryptionPassword = ""
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save sync config: %w", err)
 	}
 
-	// Prepare a map of all agent configs to push
-	allAgentConfigs := make(map[string]interface{})
-	pushedCount := 0
-
-	for _, agent := range agents {
-		if agent.Status == "detected" {
-			agentConfig, err := as.GetAgentMCPConfig(agent.ID)
-			if err != nil {
-				println(fmt.Sprintf("Warning: failed to read config from %s: %v", agent.ID, err))
-				continue
-			}
-
-			// Store the COMPLETE config for this agent
-			allAgentConfigs[agent.ID] = agentConfig
-			pushedCount++
-			println(fmt.Sprintf("Collected complete config from agent: %s", agent.ID))
+	// The gist backend stops using the password too
+	if as.gistSync != nil && config.EnableEncryption && password != "" {
+		gs := as.gistSync.WithCredentials(as.gistSync.githubToken, as.gistSync.gistID)
+		if err := gs.SetEncryption(true, ""); err != nil {
+			return err
 		}
+		as.gistSync = gs
 	}
+	return nil
+}
 
-	println(fmt.Sprintf("Pushing complete configurations from %d agents to Gist", pushedCount))
-
-	// Save version before push
-	configContent, _ := json.MarshalIndent(allAgentConfigs, "", "  ")
-	version := models.ConfigVersion{
-		ID:        "local_" + nowStr(),
-		Timestamp: nowTime(),
-		Content:   string(configContent),
-		Source:    "local",
-		Note:      fmt.Sprintf("Pushed complete config from %d agents", pushedCount),
+// prepareGistSync loads the stored credentials and lazily creates the gist backend for a sync operation,
+// refusing with ErrEncryptionRequired while RequireEncryption is on and encryption is not.
+// The returned backend stays valid for the whole operation even if credentials change meanwhile.
+func (as *AppService) prepareGistSync() (models.SyncConfig, *GistSyncService, error) {
+	config, gs, err := as.gistSyncBackend()
+	if err != nil {
+		return config, nil, err
 	}
-	as.storage.SaveConfigVersion(version)
+	if err := as.checkRequiredEncryption(config); err != nil {
+		return config, nil, err
+	}
+	return config, gs, nil
+}
 
-	// Push complete configs to Gist
-	if pushErr := as.gistSync.PushAgentConfigsToGist(allAgentConfigs); pushErr != nil {
-		as.storage.SaveSyncLog(models.SyncLog{
-			ID:        genID(),
-			Timestamp: nowTime(),
-			Action:    "push",
-			Status:    "failed",
-			Message:   pushErr.Error(),
-		})
-		return pushErr
+// checkRequiredEncryption 在开启 RequireEncryption 时确认加密已启用且系统密钥环中有密钥，否则返回 ErrEncryptionRequired
+func (as *AppService) checkRequiredEncryption(config models.SyncConfig) error {
+	if !config.RequireEncryption {
+		return nil
+	}
+	if !config.EnableEncryption {
+		return fmt.Errorf("%w: enable encryption before syncing (required by the require_encryption setting)", ErrEncryptionRequired)
 	}
+	if !as.storage.IsEncryptionEnabled() {
+		return fmt.Errorf("%w: the encryption key is missing from the system keyring", ErrEncryptionRequired)
+	}
+	return nil
+}
 
-	// Update sync time
-	updatedConfig, _ := as.storage.LoadSyncConfig()
-	updatedConfig.LastSyncTime = nowTime()
-	updatedConfig.LastSyncStatus = "success"
-	as.storage.SaveSyncConfig(updatedConfig)
+// CanSync 检查当前能否同步：需要已配置 GitHub token 和 Gist，开启 RequireEncryption 时还需要加密已启用且密钥存在。
+// 可以同步时返回 nil，否则返回的错误说明原因
+func (as *AppService) CanSync() error {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	token, _, err := resolveGitHubToken(config)
+	if err != nil {
+		return err
+	}
+	if token == "" || config.GistID == "" {
+		return fmt.Errorf("GitHub token or Gist ID not configured")
+	}
+	return as.checkRequiredEncryption(config)
+}
 
-	as.storage.SaveSyncLog(models.SyncLog{
-		ID:        genID(),
-		Timestamp: nowTime(),
-		Action:    "push",
-		Status:    "success",
-		Message:   fmt.Sprintf("Pushed complete configurations from %d agents to Gist", pushedCount),
-	})
+// SetRequireEncryption 开启后，加密未启用或密钥缺失时所有推送、拉取和冲突检测都会失败
+func (as *AppService) SetRequireEncryption(required bool) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.RequireEncryption = required
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save require encryption setting: %w", err)
+	}
 	return nil
 }
 
-func (as *AppService) PushToGist(servers []models.MCPServer) error {
-	// Load sync config to get credentials
+// gistSyncBackend 与 prepareGistSync 相同，但不检查 RequireEncryption，用于开启加密等本身不同步的操作
+func (as *AppService) gistSyncBackend() (models.SyncConfig, *GistSyncService, error) {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
 	config, err := as.storage.LoadSyncConfig()
 	if err != nil {
-		return fmt.Errorf("failed to load sync config: %w", err)
+		return config, nil, fmt.Errorf("failed to load sync config: %w", err)
 	}
 
-	if config.GitHubToken == "" || config.GistID == "" {
-		return fmt.Errorf("GitHub token or Gist ID not configured")
+	if config.GistID == "" {
+		return config, nil, fmt.Errorf("GitHub token or Gist ID not configured")
 	}
 
-	// Initialize gist sync if not already done
 	if as.gistSync == nil {
-		as.gistSync = NewGistSyncService(config.GitHubToken, config.GistID)
+		token, _, err := resolveGitHubToken(config)
+		if err != nil {
+			return config, nil, err
+		}
+		if token == "" {
+			return config, nil, fmt.Errorf("GitHub token or Gist ID not configured")
+		}
+		as.gistSync = as.newGistSyncFor(token, config.GistID, config)
 
 		// Setup encryption if enabled
 		if config.EnableEncryption {
@@ -259,815 +466,3561 @@ func (as *AppService) PushToGist(servers []models.MCPServer) error {
 		}
 	}
 
-	// Save version before push
-	configContent, _ := as.configManager.ExportConfigAsJSON(servers)
-	version := models.ConfigVersion{
-		ID:        "local_" + nowStr(),
-		Timestamp: nowTime(),
-		Content:   string(configContent),
-		Source:    "local",
-		Note:      "Pushed to Gist",
-	}
-	as.storage.SaveConfigVersion(version)
+	return config, as.gistSync, nil
+}
 
-	// Push to Gist
-	if pushErr := as.gistSync.PushToGist(servers); pushErr != nil {
-		as.storage.SaveSyncLog(models.SyncLog{
-			ID:        genID(),
-			Timestamp: nowTime(),
-			Action:    "push",
-			Status:    "failed",
-			Message:   pushErr.Error(),
-		})
-		return pushErr
+// newGistSyncFor 创建使用 config 中 Gist 文件名和环境名的同步后端，Gist 加密使用与本地存储相同的密钥环
+func (as *AppService) newGistSyncFor(token, gistID string, config models.SyncConfig) *GistSyncService {
+	gs := NewGistSyncService(token, gistID)
+	gs.SetKeyring(as.keyring)
+	gs.SetFileName(config.GistFileName)
+	gs.SetLimits(effectiveLimits(config))
+	gs.SetEnvironment(environmentName(config))
+	return gs
+}
+
+// SetConfigLimits 设置拉取和导入配置的规模限制；字段为 0 表示不限制该项
+func (as *AppService) SetConfigLimits(limits models.ConfigLimits) error {
+	if limits.MaxPayloadBytes < 0 || limits.MaxServers < 0 || limits.MaxDepth < 0 {
+		return fmt.Errorf("limits must not be negative")
 	}
 
-	// Update sync time
-	updatedConfig, _ := as.storage.LoadSyncConfig()
-	updatedConfig.LastSyncTime = nowTime()
-	updatedConfig.LastSyncStatus = "success"
-	as.storage.SaveSyncConfig(updatedConfig)
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
-	as.storage.SaveSyncLog(models.SyncLog{
-		ID:        genID(),
-		Timestamp: nowTime(),
-		Action:    "push",
-		Status:    "success",
-		Message:   "Configuration pushed to Gist",
-	})
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.Limits = &limits
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save limits: %w", err)
+	}
 
+	if as.gistSync != nil {
+		gs := as.gistSync.WithCredentials(as.gistSync.githubToken, as.gistSync.gistID)
+		gs.SetLimits(limits)
+		as.gistSync = gs
+	}
 	return nil
 }
 
-func (as *AppService) PullFromGist() ([]models.MCPServer, error) {
-	// Load sync config to get credentials
+// SetStripSecrets 设置推送前剥离敏感 env 值的 agent 列表（"*" 表示全部 agent），空列表关闭该功能
+func (as *AppService) SetStripSecrets(agents []string) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
 	config, err := as.storage.LoadSyncConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to load sync config: %w", err)
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.StripSecrets = agents
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save strip secrets setting: %w", err)
 	}
+	return nil
+}
 
-	if config.GitHubToken == "" || config.GistID == "" {
-		return nil, fmt.Errorf("GitHub token or Gist ID not configured")
+// AddSensitivePattern 追加一个自定义敏感字段模式（不区分大小写的子串匹配），与默认模式合并后用于掩码和剥离密钥；
+// 已存在的模式不会重复添加
+func (as *AppService) AddSensitivePattern(pattern string) error {
+	pattern = normalizeSensitivePattern(pattern)
+	if pattern == "" {
+		return fmt.Errorf("sensitive pattern cannot be empty")
 	}
 
-	// Initialize gist sync if not already done
-	if as.gistSync == nil {
-		as.gistSync = NewGistSyncService(config.GitHubToken, config.GistID)
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
-		// Setup encryption if enabled
-		if config.EnableEncryption {
-			password := config.GistEncryptionPassword
-			// 如果新字段为空但旧字段有值，使用旧字段（迁移场景）
-			if password == "" && config.EncryptionPassword != "" {
-				password = config.EncryptionPassword
-			}
-			as.gistSync.SetEncryption(config.EnableEncryption, password)
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	for _, existing := range SensitivePatterns() {
+		if existing == pattern {
+			return nil
 		}
 	}
+	config.ExtraSensitivePatterns = append(config.ExtraSensitivePatterns, pattern)
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save sensitive patterns: %w", err)
+	}
+	SetExtraSensitivePatterns(config.ExtraSensitivePatterns)
+	return nil
+}
 
-	// Pull complete agent configs from Gist
-	agentConfigs, err := as.gistSync.PullAgentConfigsFromGist()
+// GetSensitivePatterns 返回当前生效的敏感字段模式，默认模式在前
+func (as *AppService) GetSensitivePatterns() []string {
+	return SensitivePatterns()
+}
+
+// stripsSecrets 判断推送 agentID 的配置前是否需要剥离敏感 env 值
+func (as *AppService) stripsSecrets(agentID string) bool {
+	config, err := as.GetSyncConfig()
 	if err != nil {
-		as.storage.SaveSyncLog(models.SyncLog{
-			ID:        genID(),
-			Timestamp: nowTime(),
-			Action:    "pull",
-			Status:    "failed",
-			Message:   err.Error(),
-		})
-		return nil, err
+		return false
+	}
+	for _, id := range config.StripSecrets {
+		if id == "*" || id == agentID {
+			return true
+		}
 	}
+	return false
+}
 
-	// Save version
-	configContent, _ := json.MarshalIndent(agentConfigs, "", "  ")
-	version := models.ConfigVersion{
-		ID:        "remote_" + nowStr(),
-		Timestamp: nowTime(),
-		Content:   string(configContent),
-		Source:    "gist",
-		Note:      "Pulled complete configs from Gist",
+// SetGistFileName 修改 Gist 中保存同步配置的文件名，空字符串恢复默认的 mcp-config.json
+// Nothing is renamed remotely: the next push writes to the new file and pulls read from it.
+func (as *AppService) SetGistFileName(fileName string) error {
+	fileName = strings.TrimSpace(fileName)
+	if strings.ContainsAny(fileName, "/\\") {
+		return fmt.Errorf("invalid gist file name: %s", fileName)
 	}
-	as.storage.SaveConfigVersion(version)
 
-	// Apply downloaded complete configurations to each agent
-	appliedCount := 0
-	if len(agentConfigs) > 0 {
-		for agentID, agentConfig := range agentConfigs {
-			// Apply the complete config to this specific agent
-			err := as.SaveAgentMCPConfig(agentID, agentConfig.(map[string]interface{}))
-			if err == nil {
-				appliedCount++
-				println(fmt.Sprintf("Applied complete configuration to agent: %s", agentID))
-			} else {
-				println(fmt.Sprintf("Warning: failed to apply config to %s: %v", agentID, err))
-			}
-		}
-	}
-	println(fmt.Sprintf("Applied complete configurations to %d agents", appliedCount))
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
-	// Update sync time
-	updatedConfig, _ := as.storage.LoadSyncConfig()
-	updatedConfig.LastSyncTime = nowTime()
-	updatedConfig.LastSyncStatus = "success"
-	as.storage.SaveSyncConfig(updatedConfig)
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.GistFileName = fileName
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save gist file name: %w", err)
+	}
 
-	as.storage.SaveSyncLog(models.SyncLog{
-		ID:        genID(),
-		Timestamp: nowTime(),
-		Action:    "pull",
-		Status:    "success",
-		Message:   fmt.Sprintf("Complete configurations pulled from Gist and applied to %d agents", appliedCount),
-	})
+	if as.gistSync != nil {
+		gs := as.gistSync.WithCredentials(as.gistSync.githubToken, as.gistSync.gistID)
+		gs.SetFileName(fileName)
+		as.gistSync = gs
+	}
+	return nil
+}
 
-	// Convert back to servers list for compatibility
-	servers := []models.MCPServer{}
-	for _, config := range agentConfigs {
-		if configMap, ok := config.(map[string]interface{}); ok {
-			// Try to extract servers from any config key
-			for _, serversData := range configMap {
-				if serverMap, ok := serversData.(map[string]interface{}); ok {
-					for serverName, serverConfig := range serverMap {
-						server := models.MCPServer{
-							ID:   serverName,
-							Name: serverName,
-						}
-						if serverMap, ok := serverConfig.(map[string]interface{}); ok {
-							if cmd, ok := serverMap["command"].(string); ok {
-								server.Command = cmd
-							}
-						}
-						servers = append(servers, server)
-					}
-				}
-			}
+// CreateProfile 新建命名同步配置。gistID 为空时与当前配置共用同一个 Gist（用不同文件名区分），
+// agents 为空时同步所有已安装的 agent。第一次创建时当前设置会保存为 "default" 配置。
+func (as *AppService) CreateProfile(name, gistID, fileName string, agents []string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("profile name is required")
+	}
+	if strings.ContainsAny(fileName, "/\\") {
+		return fmt.Errorf("invalid gist file name: %s", fileName)
+	}
+	for _, agentID := range agents {
+		if as.configLoader.GetAgentDefinition(agentID) == nil {
+			return fmt.Errorf("unknown agent: %s", agentID)
 		}
 	}
 
-	return servers, nil
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]models.ProfileConfig)
+	}
+	if config.ActiveProfile == "" && config.GistID != "" {
+		// Keep the existing setup reachable once profiles are in use
+		config.ActiveProfile = "default"
+		config.Profiles["default"] = models.ProfileConfig{Name: "default"}
+	}
+	syncActiveProfile(&config)
+	if _, exists := config.Profiles[name]; exists {
+		return fmt.Errorf("profile %s already exists", name)
+	}
+
+	if gistID == "" {
+		gistID = config.GistID
+	}
+	config.Profiles[name] = models.ProfileConfig{
+		Name:         name,
+		GistID:       gistID,
+		GistFileName: fileName,
+		Agents:       agents,
+	}
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+	return nil
 }
 
-func (as *AppService) ApplyConfigToAgents(agentID string, servers []models.MCPServer) error {
-	return as.configManager.WriteAgentMCPConfig(agentID, servers)
+// ListProfiles 返回所有命名同步配置，按名称排序
+func (as *AppService) ListProfiles() ([]models.ProfileConfig, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil, err
+	}
+	syncActiveProfile(&config)
+
+	profiles := make([]models.ProfileConfig, 0, len(config.Profiles))
+	for _, profile := range config.Profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles, nil
 }
 
-func (as *AppService) ApplyConfigToAllAgents(servers []models.MCPServer) error {
-	agents, err := as.detector.DetectInstalledAgents()
+// SwitchProfile 切换当前同步配置：之后的推送和拉取使用该配置的 Gist、文件名和 agent 范围。
+// Each profile keeps its own merge base, so switching back resumes three-way merges where they left off.
+func (as *AppService) SwitchProfile(name string) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	profile, exists := config.Profiles[name]
+	if !exists {
+		return fmt.Errorf("profile %s does not exist", name)
+	}
+	if config.ActiveProfile == name {
+		return nil
 	}
 
-	for _, agent := range agents {
-		if agent.Status == "detected" {
-			as.configManager.WriteAgentMCPConfig(agent.ID, servers)
-		}
+	syncActiveProfile(&config)
+	previous := config.ActiveProfile
+	config.ActiveProfile = name
+	config.GistID = profile.GistID
+	config.GistFileName = profile.GistFileName
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to switch profile: %w", err)
+	}
+
+	// Recreated from the new settings on the next sync
+	as.gistSync = nil
+	if err := as.storage.SwapMergeBase(previous, name); err != nil {
+		println(fmt.Sprintf("Warning: failed to switch merge base: %v", err))
 	}
 
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "profile_switch",
+		Status:    "success",
+		Message:   fmt.Sprintf("Switched to sync profile %s", name),
+	})
 	return nil
 }
 
-func (as *AppService) GetSyncConfig() (models.SyncConfig, error) {
-	return as.storage.LoadSyncConfig()
+// syncActiveProfile 把当前生效的 Gist 设置写回激活的配置（迁移、改文件名等操作只修改顶层字段）
+func syncActiveProfile(config *models.SyncConfig) {
+	profile, exists := config.Profiles[config.ActiveProfile]
+	if !exists {
+		return
+	}
+	profile.GistID = config.GistID
+	profile.GistFileName = config.GistFileName
+	config.Profiles[config.ActiveProfile] = profile
 }
 
-func (as *AppService) SaveSyncConfig(config models.SyncConfig) error {
-	return as.storage.SaveSyncConfig(config)
-}
+// agentScope 返回当前配置限定的 agent 集合；nil 表示所有 agent
+func (as *AppService) agentScope() map[string]bool {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil
+	}
+	profile, exists := config.Profiles[config.ActiveProfile]
+	if !exists || len(profile.Agents) == 0 {
+		return nil
+	}
 
-func (as *AppService) GetConfigVersions(limit int) ([]models.ConfigVersion, error) {
-	return as.storage.ListConfigVersions(limit)
+	scope := make(map[string]bool, len(profile.Agents))
+	for _, agentID := range profile.Agents {
+		scope[agentID] = true
+	}
+	return scope
 }
 
-func (as *AppService) GetSyncLogs(limit int) ([]models.SyncLog, error) {
-	return as.storage.GetSyncLogs(limit)
-}
+// recordSyncSuccess 记录最近一次成功同步的时间
+func (as *AppService) recordSyncSuccess() {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
 
-func (as *AppService) GetAgentMCPConfig(agentID string) (map[string]interface{}, error) {
-	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	config, err := as.storage.LoadSyncConfig()
 	if err != nil {
-		return nil, err
+		println(fmt.Sprintf("Warning: failed to record sync time: %v", err))
+		return
 	}
+	config.LastSyncTime = nowTime()
+	config.LastSyncStatus = "success"
+	as.storage.SaveSyncConfig(config)
+}
 
-	// Check if this is a TOML format (Codex)
-	format := as.configLoader.GetFormat(agentID)
-	if format == "codex_toml" {
-		// Use TOML adapter for Codex
-		servers, err := as.tomlAdapter.GetMCPServersAsStandard(configPath)
-		if err != nil {
-			return nil, err
-		}
-		keyName := as.configLoader.GetConfigKey(agentID)
-		return map[string]interface{}{
-			keyName: servers,
-		}, nil
+// UpdateGitHubToken 轮换 GitHub token，不重新初始化同步
+// The gist ID and encryption settings are kept; the stored token is overwritten in place.
+func (as *AppService) UpdateGitHubToken(newToken string) error {
+	if newToken == "" {
+		return fmt.Errorf("GitHub token must not be empty")
 	}
 
-	// Read the config file (JSON format)
-	data, err := os.ReadFile(configPath)
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to load sync config: %w", err)
 	}
 
-	// Remove comments from JSON if present (Zed and other editors support JSON with comments)
-	dataStr := string(data)
-	lines := strings.Split(dataStr, "\n")
-	var cleanedLines []string
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if !strings.HasPrefix(trimmed, "//") {
-			cleanedLines = append(cleanedLines, line)
+	gs := as.newGistSyncFor(newToken, config.GistID, config)
+	if err := gs.ValidateTokenScopes(); err != nil {
+		return err
+	}
+	if config.GistID != "" {
+		if err := gs.ValidateGist(); err != nil {
+			return err
 		}
 	}
-	cleanedData := []byte(strings.Join(cleanedLines, "\n"))
 
-	var config map[string]interface{}
-	if err := json.Unmarshal(cleanedData, &config); err != nil {
-		return nil, err
+	config.GitHubToken = newToken
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save GitHub token: %w", err)
 	}
 
-	// Get key name from config loader based on agent definition
-	keyName := as.configLoader.GetConfigKey(agentID)
-
-	// Extract only the MCP servers section
-	mcpServers, ok := config[keyName]
-	if !ok {
-		mcpServers = make(map[string]interface{})
+	// Refresh the cached backend so subsequent operations use the new token,
+	// unless a token from the environment or the credential helper takes precedence
+	if as.gistSync != nil {
+		if external, _, _ := externalGitHubToken(config); external == "" {
+			as.gistSync = as.gistSync.WithCredentials(newToken, as.gistSync.gistID)
+		}
 	}
 
-	return map[string]interface{}{
-		keyName: mcpServers,
-	}, nil
+	return nil
 }
 
-func (as *AppService) SaveAgentMCPConfig(agentID string, mcpServersConfig map[string]interface{}) error {
-	configPath, err := as.detector.GetAgentConfigPath(agentID)
+// MigrateGist 将同步配置迁移到新账号下的新 Gist
+// The encrypted content is copied verbatim so it stays readable with the current key.
+func (as *AppService) MigrateGist(newToken string, deleteOld bool) (string, error) {
+	_, oldGist, err := as.prepareGistSync()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	// Check if this is a TOML format (Codex)
-	format := as.configLoader.GetFormat(agentID)
-	if format == "codex_toml" {
-		// Extract servers from input config
-		keyName := as.configLoader.GetConfigKey(agentID)
-		var servers map[string]interface{}
-		if serversInterface, ok := mcpServersConfig[keyName]; ok {
-			if serversMap, ok := serversInterface.(map[string]interface{}); ok {
-				servers = serversMap
-			}
-		}
-		// Use TOML adapter for Codex
-		return as.tomlAdapter.SetMCPServersFromStandard(configPath, servers)
+	content, err := oldGist.FetchRawContent()
+	if err != nil {
+		return "", fmt.Errorf("failed to read current gist: %w", err)
+	}
+
+	newGist := oldGist.WithCredentials(newToken, "")
+	if err := newGist.ValidateTokenScopes(); err != nil {
+		return "", err
 	}
 
-	// Read the full config file first (JSON format)
-	data, err := os.ReadFile(configPath)
+	requestID, err := as.gistRequestID()
 	if err != nil {
-		return err
+		return "", err
 	}
+	newGistID, err := newGist.CreateGistWithContentOnce(requestID, content, "MCP Sync Configuration")
+	if err != nil {
+		return "", fmt.Errorf("failed to create new gist: %w", err)
+	}
+	newGist.gistID = newGistID
 
-	// Remove comments if present
-	dataStr := string(data)
-	lines := strings.Split(dataStr, "\n")
-	var cleanedLines []string
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if !strings.HasPrefix(trimmed, "//") {
-			cleanedLines = append(cleanedLines, line)
+	// Confirm the copied content is still readable before repointing
+	if content != "" && newGist.IsEncryptionEnabled() {
+		if _, err := newGist.FetchLatestVersion(false); err != nil {
+			return "", fmt.Errorf("migrated gist %s is not readable with the current key: %w", newGistID, err)
 		}
 	}
-	cleanedData := []byte(strings.Join(cleanedLines, "\n"))
 
-	var fullConfig map[string]interface{}
-	if err := json.Unmarshal(cleanedData, &fullConfig); err != nil {
-		return err
+	as.configMu.Lock()
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		as.configMu.Unlock()
+		return "", fmt.Errorf("failed to load sync config: %w", err)
 	}
+	config.GitHubToken = newToken
+	config.GistID = newGistID
+	config.PendingGistRequest = ""
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		as.configMu.Unlock()
+		return "", fmt.Errorf("failed to save migrated gist config: %w", err)
+	}
+	as.gistSync = newGist
+	as.configMu.Unlock()
 
-	// Get config key from agent definition
-	targetKeyName := as.configLoader.GetConfigKey(agentID)
-	sourceFormat := as.configLoader.GetFormat(agentID)
-
-	// Determine source key name from input
-	sourceKeyName := ""
-	if _, hasZedKey := mcpServersConfig["context_servers"]; hasZedKey {
-		sourceKeyName = "context_servers"
-	} else if _, hasStdKey := mcpServersConfig["mcpServers"]; hasStdKey {
-		sourceKeyName = "mcpServers"
+	message := fmt.Sprintf("Migrated sync from gist %s to %s", oldGist.gistID, newGistID)
+	if deleteOld {
+		if err := oldGist.DeleteGist(); err != nil {
+			println(fmt.Sprintf("Warning: failed to delete old gist %s: %v", oldGist.gistID, err))
+			message += " (old gist could not be deleted)"
+		} else {
+			message += " and deleted the old gist"
+		}
 	}
 
-	// Get the servers data
-	var serversData interface{}
-	if sourceKeyName != "" {
-		serversData = mcpServersConfig[sourceKeyName]
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "migrate",
+		Status:    "success",
+		Message:   message,
+	})
+
+	return newGistID, nil
+}
+
+// TearDownSync 停止使用同步：清除本地凭据，可选删除远程 Gist 并关闭加密
+func (as *AppService) TearDownSync(deleteRemote bool, disableEncryption bool) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
 	}
 
-	// Transform format if needed
-	if sourceKeyName != targetKeyName && sourceKeyName != "" {
-		// Need to convert between formats
-		if sourceKeyName == "context_servers" && targetKeyName == "mcpServers" {
-			serversData = convertZedToStandard(serversData)
-		} else if sourceKeyName == "mcpServers" && targetKeyName == "context_servers" {
-			serversData = convertStandardToZed(serversData)
+	token, _, _ := resolveGitHubToken(config)
+	if deleteRemote && token != "" && config.GistID != "" {
+		gs := as.gistSync
+		if gs == nil {
+			gs = NewGistSyncService(token, config.GistID)
+		}
+		if err := gs.DeleteGist(); err != nil {
+			return fmt.Errorf("failed to delete remote gist: %w", err)
 		}
 	}
 
-	// For Zed format, add required fields
-	if sourceFormat == "zed" && targetKeyName == "context_servers" {
-		serversData = convertStandardToZed(serversData)
-	}
+	config.GitHubToken = ""
+	config.GistID = ""
+	config.LastSyncStatus = ""
+	config.LastUpdateTime = nowTime()
 
-	// Update the config with target format
-	if serversData != nil {
-		fullConfig[targetKeyName] = serversData
+	if disableEncryption {
+		// Decrypt local history while the key still exists so it stays readable
+		if err := as.storage.DecryptDataFiles(); err != nil {
+			return fmt.Errorf("failed to decrypt local data before disabling encryption: %w", err)
+		}
+		if err := as.storage.DisableEncryption(); err != nil {
+			return err
+		}
+		config.EnableEncryption = false
+		config.GistEncryptionPassword = ""
+		config.EncryptionPassword = ""
 	}
 
-	// Write back the full config
-	updatedData, err := json.MarshalIndent(fullConfig, "", "  ")
-	if err != nil {
-		return err
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to clear sync config: %w", err)
 	}
+	as.gistSync = nil
 
-	if err := os.WriteFile(configPath, updatedData, 0644); err != nil {
-		return err
+	message := "Sync credentials cleared"
+	if deleteRemote {
+		message += ", remote gist deleted"
 	}
+	if disableEncryption {
+		message += ", encryption disabled"
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "teardown",
+		Status:    "success",
+		Message:   message,
+	})
 
 	return nil
 }
 
-// convertZedToStandard converts Zed context_servers format to standard mcpServers format
-func convertZedToStandard(data interface{}) interface{} {
-	servers, ok := data.(map[string]interface{})
-	if !ok {
-		return data
+// ValidateGitHubToken 检查 token 是否有效并且可以读写 gist（支持 classic 与 fine-grained token）
+func (as *AppService) ValidateGitHubToken(token string) error {
+	gs := NewGistSyncService(token, "")
+	return gs.ValidateTokenScopes()
+}
+
+// PushAllAgentsToGist 推送所有已安装 agents 的完整配置到 Gist（保留完整的原始配置）
+func (as *AppService) PushAllAgentsToGist() (err error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	start := time.Now()
+	payloadBytes := 0
+	defer func() { as.recordSyncMetrics("push", payloadBytes, start, err) }()
+
+	// Load sync config to get credentials and initialize gist sync if not already done
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
 	}
 
-	result := make(map[string]interface{})
-	for name, config := range servers {
-		configMap, ok := config.(map[string]interface{})
-		if !ok {
-			continue
+	// A file edited while it is being read would push stale data: read again once, then give up
+	var allAgentConfigs map[string]interface{}
+	var skipped []string
+	pushedCount := 0
+	for attempt := 1; ; attempt++ {
+		stamps := as.stampAgentFiles(as.installedAgentIDs())
+		allAgentConfigs, pushedCount, skipped, err = as.preparePushConfigs(gs)
+		if err != nil {
+			return err
 		}
+		afterPushRead()
 
-		// Extract relevant fields and convert to standard format
-		newConfig := make(map[string]interface{})
-		if cmd, ok := configMap["command"]; ok {
-			newConfig["command"] = cmd
-		}
-		if args, ok := configMap["args"]; ok {
-			newConfig["args"] = args
+		changed := changedAgentFiles(as.storage.fs, stamps)
+		if len(changed) == 0 {
+			break
 		}
-		if env, ok := configMap["env"]; ok {
-			newConfig["env"] = env
+		if attempt == maxPushReadAttempts {
+			changedErr := changedDuringPushError(changed)
+			as.storage.SaveSyncLog(models.SyncLog{
+				ID:        genID(),
+				Timestamp: nowTime(),
+				Action:    "push",
+				Status:    "failed",
+				Message:   changedErr.Error(),
+			})
+			return changedErr
 		}
-
-		result[name] = newConfig
+		println(fmt.Sprintf("Warning: %s changed on disk while pushing, reading again", strings.Join(changed, ", ")))
 	}
 
-	return result
-}
+	println(fmt.Sprintf("Pushing complete configurations from %d agents to Gist", pushedCount))
 
-// convertStandardToZed converts standard mcpServers format to Zed context_servers format
-func convertStandardToZed(data interface{}) interface{} {
-	servers, ok := data.(map[string]interface{})
-	if !ok {
-		return data
+	// Save version before push
+	configContent, _ := json.MarshalIndent(allAgentConfigs, "", "  ")
+	payloadBytes = len(configContent)
+	version := models.ConfigVersion{
+		ID:        "local_" + nowStr(),
+		Timestamp: nowTime(),
+		Content:   string(configContent),
+		Source:    "local",
+		Note:      fmt.Sprintf("Pushed complete config from %d agents", pushedCount),
 	}
+	as.storage.SaveConfigVersion(version)
 
-	result := make(map[string]interface{})
-	for name, config := range servers {
-		configMap, ok := config.(map[string]interface{})
-		if !ok {
-			continue
+	// Push complete configs to Gist
+	if pushErr := gs.PushAgentConfigsToGist(allAgentConfigs); pushErr != nil {
+		// Queue the payload when offline so it can be flushed once connectivity returns
+		if isNetworkError(pushErr) {
+			return as.queuePendingPush(allAgentConfigs, pushErr)
 		}
 
-		// Convert to Zed format
-		newConfig := make(map[string]interface{})
-		newConfig["source"] = "custom"
-		newConfig["enabled"] = true
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "push",
+			Status:    "failed",
+			Message:   pushErr.Error(),
+		})
+		return pushErr
+	}
 
-		if cmd, ok := configMap["command"]; ok {
-			newConfig["command"] = cmd
-		}
-		if args, ok := configMap["args"]; ok {
-			newConfig["args"] = args
-		}
-		if env, ok := configMap["env"]; ok {
-			newConfig["env"] = env
-		}
+	changes := as.summarizeAgentChanges(as.mergeBaseAgents(), allAgentConfigs)
+	as.updateMergeBase(allAgentConfigs, "push")
+
+	// Update sync time
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push",
+		Status:    "success",
+		Message:   pushSuccessMessage(pushedCount, changes, skipped),
+	})
+
+	return nil
+}
+
+// preparePushConfigs 读取要推送的 agent 配置并做 schema 检查和推送校验，返回配置、实际推送的 agent 数和被跳过的 agent
+func (as *AppService) preparePushConfigs(gs *GistSyncService) (map[string]interface{}, int, []string, error) {
+	// Collect all agents' COMPLETE configurations (not just servers)
+	allAgentConfigs, err := as.collectAgentConfigs()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if err := as.checkSchemas(sortedKeys(allAgentConfigs)); err != nil {
+		return nil, 0, nil, err
+	}
+	// Invalid agents are left out (or abort the push in strict mode) so a broken config never reaches other machines
+	skipped, err := as.applyPushValidation(allAgentConfigs)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	pushedCount := len(allAgentConfigs)
+	if len(skipped) > 0 {
+		// Keep the last pushed config of skipped agents rather than dropping them from the Gist
+		if remote, err := gs.PullAgentConfigsFromGist(); err == nil {
+			for _, agentID := range skipped {
+				if config, ok := remote[agentID]; ok {
+					allAgentConfigs[agentID] = config
+				}
+			}
+		}
+	}
+	return allAgentConfigs, pushedCount, skipped, nil
+}
+
+// pushSuccessMessage 返回推送成功的同步日志消息：相对上次同步的变化摘要，以及因校验失败而未推送的 agent
+func pushSuccessMessage(pushedCount int, changes changeSummary, skipped []string) string {
+	message := fmt.Sprintf("Pushed %d agents to Gist: %s", pushedCount, changes)
+	if len(skipped) > 0 {
+		message += fmt.Sprintf(" (skipped invalid: %s)", strings.Join(skipped, ", "))
+	}
+	return message
+}
+
+// SetMetricsEnabled 开启或关闭本地同步指标的记录；关闭时保留已有的指标
+func (as *AppService) SetMetricsEnabled(enabled bool) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.MetricsEnabled = enabled
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save metrics setting: %w", err)
+	}
+	return nil
+}
+
+// GetMetrics 返回本地累计的同步指标
+func (as *AppService) GetMetrics() (*models.SyncMetrics, error) {
+	metrics, err := as.metrics.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metrics: %w", err)
+	}
+	return &metrics, nil
+}
+
+// ResetMetrics 清零本地同步指标
+func (as *AppService) ResetMetrics() error {
+	return as.metrics.Reset()
+}
+
+// metricsEnabled 判断是否开启了本地指标记录
+func (as *AppService) metricsEnabled() bool {
+	config, err := as.GetSyncConfig()
+	return err == nil && config.MetricsEnabled
+}
+
+// recordSyncMetrics 在开启指标时记录一次推送或拉取；记录失败不影响同步结果
+func (as *AppService) recordSyncMetrics(direction string, payloadBytes int, start time.Time, syncErr error) {
+	if !as.metricsEnabled() {
+		return
+	}
+	if err := as.metrics.RecordSync(direction, payloadBytes, time.Since(start), syncErr); err != nil {
+		println(fmt.Sprintf("Warning: failed to record metrics: %v", err))
+	}
+}
+
+// recordConflictMetrics 在开启指标时记录一次冲突
+func (as *AppService) recordConflictMetrics() {
+	if !as.metricsEnabled() {
+		return
+	}
+	if err := as.metrics.RecordConflict(); err != nil {
+		println(fmt.Sprintf("Warning: failed to record metrics: %v", err))
+	}
+}
+
+// queuePendingPush 在网络不可用时保存待推送的配置
+func (as *AppService) queuePendingPush(agentConfigs map[string]interface{}, pushErr error) error {
+	pending := models.PendingPush{
+		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		Timestamp: nowTime(),
+		Agents:    agentConfigs,
+	}
+	if err := as.storage.SavePendingPush(pending); err != nil {
+		return fmt.Errorf("push failed (%v) and could not be queued: %w", pushErr, err)
+	}
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push",
+		Status:    "queued",
+		Message:   "Network unavailable, push queued for retry",
+		Details:   pushErr.Error(),
+	})
+
+	return fmt.Errorf("network unavailable, push queued for retry: %w", pushErr)
+}
+
+// FlushPendingPushes 重试离线期间排队的推送
+// Every queued payload is a complete snapshot, so only the newest one is sent and older ones are discarded.
+// The snapshot was taken against the merge base: when the gist has changed since then, the queue is kept
+// and ErrConflict is returned instead of overwriting the remote changes.
+func (as *AppService) FlushPendingPushes() error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	pending, err := as.storage.ListPendingPushes()
+	if err != nil {
+		return fmt.Errorf("failed to load pending pushes: %w", err)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+
+	latest := pending[len(pending)-1]
+	flushErr := func(err error) error {
+		if isNetworkError(err) {
+			return fmt.Errorf("network still unavailable, %d push(es) remain queued: %w", len(pending), err)
+		}
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "push",
+			Status:    "failed",
+			Message:   fmt.Sprintf("Failed to flush queued push: %v", err),
+		})
+		return err
+	}
+
+	remoteConfigs, revision, err := gs.PullAgentConfigsWithRevision()
+	if err != nil {
+		return flushErr(err)
+	}
+	if !jsonEqual(remoteConfigs, as.mergeBaseAgents()) {
+		as.recordConflictMetrics()
+		return flushErr(fmt.Errorf("%w: the Gist changed since the push was queued, pull and resolve before flushing %d queued push(es)", ErrConflict, len(pending)))
+	}
+	// The revision guards against a push landing between the check above and this write
+	if err := gs.PushAgentConfigsIfUnchanged(latest.Agents, revision); err != nil {
+		return flushErr(err)
+	}
+
+	for _, push := range pending {
+		if err := as.storage.DeletePendingPush(push.ID); err != nil {
+			println(fmt.Sprintf("Warning: failed to remove pending push %s: %v", push.ID, err))
+		}
+	}
+	changes := as.summarizeAgentChanges(as.mergeBaseAgents(), latest.Agents)
+	as.updateMergeBase(latest.Agents, "push")
+
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push",
+		Status:    "success",
+		Message:   fmt.Sprintf("Flushed queued push from %s (%d queued): %s", latest.Timestamp.Format(time.RFC3339), len(pending), changes),
+	})
+
+	return nil
+}
+
+// isNetworkError reports whether err is a transport failure rather than an API or validation error
+func isNetworkError(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// collectAgentConfigs 读取所有已检测到的 agent 的完整 MCP 配置
+func (as *AppService) collectAgentConfigs() (map[string]interface{}, error) {
+	agents, err := as.detector.DetectInstalledAgents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect agents: %w", err)
+	}
+
+	scope := as.agentScope()
+	allAgentConfigs := make(map[string]interface{})
+	for _, agent := range agents {
+		if scope != nil && !scope[agent.ID] {
+			continue
+		}
+		if agent.Status == "detected" {
+			agentConfig, err := as.collectAgentConfig(agent.ID)
+			if err != nil {
+				println(fmt.Sprintf("Warning: failed to read config from %s: %v", agent.ID, err))
+				continue
+			}
+
+			// Store the COMPLETE config for this agent
+			allAgentConfigs[agent.ID] = agentConfig
+			println(fmt.Sprintf("Collected complete config from agent: %s", agent.ID))
+		}
+	}
+
+	return allAgentConfigs, nil
+}
+
+// collectAgentConfig 读取一个 agent 的完整 MCP 配置并转换为推送到 Gist 的形式：
+// 补上已停用的服务器，按设置去掉敏感环境变量，并把路径改写为可移植的锚点
+func (as *AppService) collectAgentConfig(agentID string) (map[string]interface{}, error) {
+	agentConfig, err := as.GetAgentMCPConfig(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	agentConfig = as.withDisabledServers(agentID, agentConfig)
+	if as.stripsSecrets(agentID) {
+		agentConfig = stripSensitiveEnv(agentConfig)
+	}
+	if as.portablePathsEnabled() {
+		agentConfig = mapConfigServers(agentConfig, as.PortabilizePaths)
+	}
+	return agentConfig, nil
+}
+
+// PlanSync 预演一次推送（push）或拉取（pull），列出每个 agent 会读写的文件、格式转换和会丢失的服务器。
+// 不写入任何文件；拉取时只读取 Gist
+func (as *AppService) PlanSync(direction string) (*models.SyncPlan, error) {
+	plan := &models.SyncPlan{Direction: direction, Agents: []models.AgentSyncPlan{}}
+
+	switch direction {
+	case "push":
+		agentConfigs, err := as.collectAgentConfigs()
+		if err != nil {
+			return nil, err
+		}
+		for agentID, agentConfig := range agentConfigs {
+			configMap, _ := agentConfig.(map[string]interface{})
+			plan.Agents = append(plan.Agents, as.planAgentSync(agentID, "read", configMap))
+		}
+	case "pull":
+		_, gs, err := as.prepareGistSync()
+		if err != nil {
+			return nil, err
+		}
+		agentConfigs, err := gs.ReadOnly().PullAgentConfigsFromGist()
+		if err != nil {
+			return nil, err
+		}
+		scope := as.agentScope()
+		for agentID, agentConfig := range agentConfigs {
+			if scope != nil && !scope[agentID] {
+				plan.Agents = append(plan.Agents, models.AgentSyncPlan{AgentID: agentID, Action: "skip", Servers: []string{}, Reason: "not in the active profile"})
+				continue
+			}
+			configMap, ok := agentConfig.(map[string]interface{})
+			if !ok {
+				plan.Agents = append(plan.Agents, models.AgentSyncPlan{AgentID: agentID, Action: "skip", Servers: []string{}, Reason: "unexpected config shape in Gist"})
+				continue
+			}
+			plan.Agents = append(plan.Agents, as.planAgentSync(agentID, "write", configMap))
+		}
+	default:
+		return nil, fmt.Errorf("unknown sync direction %q, expected push or pull", direction)
+	}
+
+	sort.Slice(plan.Agents, func(i, j int) bool { return plan.Agents[i].AgentID < plan.Agents[j].AgentID })
+	return plan, nil
+}
+
+// planAgentSync 描述 config 从 agent 读出（read）或写入 agent（write）时会发生的转换
+func (as *AppService) planAgentSync(agentID, action string, config map[string]interface{}) models.AgentSyncPlan {
+	step := models.AgentSyncPlan{AgentID: agentID, Action: action, Servers: []string{}}
+
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		step.Action = "skip"
+		step.Reason = err.Error()
+		return step
+	}
+	step.ConfigPath = configPath
+
+	format := as.configLoader.GetFormat(agentID)
+	servers, _ := standardServersFrom(config, as.configLoader.GetConfigKey(agentID))
+	for name := range servers {
+		step.Servers = append(step.Servers, name)
+	}
+	sort.Strings(step.Servers)
+
+	for _, name := range step.Servers {
+		serverMap, _ := servers[name].(map[string]interface{})
+		if action == "write" && isTOMLFormat(format) {
+			if _, unsupported := codexUnsupportedTransport(serverMap); unsupported {
+				step.Dropped = append(step.Dropped, name)
+			}
+		}
+		command, _ := serverMap["command"].(string)
+		args, _ := serverMap["args"].([]interface{})
+		if as.windowsSvc.IsNpxCommand(command, args) && as.windowsSvc.IsAlreadyWrapped(command, args) {
+			step.WindowsWrapped = append(step.WindowsWrapped, name)
+		}
+	}
+
+	// Zed stays Zed and standard stays standard; only cross-format writes and TOML convert
+	switch {
+	case isTOMLFormat(format) && action == "read":
+		step.Conversion = format + " -> standard"
+	case isTOMLFormat(format):
+		step.Conversion = "standard -> " + format
+	case action == "write":
+		_, fromZed := config["context_servers"]
+		if fromZed && format != "zed" {
+			step.Conversion = "zed -> " + format
+		} else if !fromZed && format == "zed" {
+			step.Conversion = "standard -> zed"
+		}
+	}
+
+	return step
+}
+
+func (as *AppService) PushToGist(servers []models.MCPServer) error {
+	// Load sync config to get credentials and initialize gist sync if not already done
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+
+	// Diff against the previous push before this one is saved as a version
+	changes := summarizeServerChanges(as.lastPushedServers(), servers)
+
+	// Save version before push
+	configContent, _ := as.configManager.ExportConfigAsJSON(servers)
+	version := models.ConfigVersion{
+		ID:        "local_" + nowStr(),
+		Timestamp: nowTime(),
+		Content:   string(configContent),
+		Source:    "local",
+		Note:      "Pushed to Gist",
+	}
+	as.storage.SaveConfigVersion(version)
+
+	// Push to Gist
+	if pushErr := gs.PushToGist(servers); pushErr != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "push",
+			Status:    "failed",
+			Message:   pushErr.Error(),
+		})
+		return pushErr
+	}
+
+	// Update sync time
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push",
+		Status:    "success",
+		Message:   fmt.Sprintf("Configuration pushed to Gist: %s", changes),
+	})
+
+	return nil
+}
+
+func (as *AppService) PullFromGist() (_ []models.MCPServer, err error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("pull")()
+
+	start := time.Now()
+	payloadBytes := 0
+	defer func() { as.recordSyncMetrics("pull", payloadBytes, start, err) }()
+
+	// Load sync config to get credentials and initialize gist sync if not already done
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pull complete agent configs from Gist
+	agentConfigs, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "pull",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return nil, err
+	}
+
+	// An empty or never-pushed gist is a valid pull with nothing to apply
+	if len(agentConfigs) == 0 {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "pull",
+			Status:    "success",
+			Message:   "Gist contains no agent configurations, nothing applied",
+		})
+		return []models.MCPServer{}, nil
+	}
+
+	// Save version
+	configContent, _ := json.MarshalIndent(agentConfigs, "", "  ")
+	payloadBytes = len(configContent)
+	version := models.ConfigVersion{
+		ID:        "remote_" + nowStr(),
+		Timestamp: nowTime(),
+		Content:   string(configContent),
+		Source:    "gist",
+		Note:      "Pulled complete configs from Gist",
+	}
+	as.storage.SaveConfigVersion(version)
+
+	// Apply downloaded complete configurations to each agent
+	appliedCount := 0
+	scope := as.agentScope()
+	var pullIDs []string
+	for agentID, agentConfig := range agentConfigs {
+		if scope != nil && !scope[agentID] {
+			continue
+		}
+		if _, ok := agentConfig.(map[string]interface{}); !ok {
+			println(fmt.Sprintf("Warning: skipping %s, unexpected config shape in Gist", agentID))
+			continue
+		}
+		pullIDs = append(pullIDs, agentID)
+	}
+	// Apply the complete config to each agent, several agents at a time
+	applyErrs := forEachAgentParallel(pullIDs, func(agentID string) error {
+		return as.applyRemoteAgentConfig(agentID, agentConfigs[agentID].(map[string]interface{}))
+	})
+	for _, agentID := range pullIDs {
+		if err := applyErrs[agentID]; err != nil {
+			println(fmt.Sprintf("Warning: failed to apply config to %s: %v", agentID, err))
+			continue
+		}
+		appliedCount++
+		println(fmt.Sprintf("Applied complete configuration to agent: %s", agentID))
+	}
+	println(fmt.Sprintf("Applied complete configurations to %d agents", appliedCount))
+	changes := as.summarizeAgentChanges(as.mergeBaseAgents(), agentConfigs)
+	as.updateMergeBase(agentConfigs, "pull")
+
+	// Update sync time
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "pull",
+		Status:    "success",
+		Message:   fmt.Sprintf("Pulled from Gist and applied to %d agents: %s", appliedCount, changes),
+	})
+
+	// Convert back to servers list for compatibility
+	servers := []models.MCPServer{}
+	for _, config := range agentConfigs {
+		if configMap, ok := config.(map[string]interface{}); ok {
+			// Try to extract servers from any config key
+			for key, serversData := range configMap {
+				if key == disabledServersKey {
+					continue
+				}
+				if serverMap, ok := serversData.(map[string]interface{}); ok {
+					for serverName, serverConfig := range serverMap {
+						server := models.MCPServer{
+							ID:   serverName,
+							Name: serverName,
+						}
+						if serverMap, ok := serverConfig.(map[string]interface{}); ok {
+							if cmd, ok := serverMap["command"].(string); ok {
+								server.Command = cmd
+							}
+						}
+						servers = append(servers, server)
+					}
+				}
+			}
+		}
+	}
+
+	return servers, nil
+}
+
+// PushByTag 只推送带有指定标签的服务器，远程中其他分组的服务器保持不变
+func (as *AppService) PushByTag(tag string) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	if tag == "" {
+		return fmt.Errorf("tag is required")
+	}
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+
+	localConfigs, err := as.collectAgentConfigs()
+	if err != nil {
+		return err
+	}
+	if err := as.checkSchemas(sortedKeys(localConfigs)); err != nil {
+		return err
+	}
+	remoteConfigs, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		return fmt.Errorf("failed to read remote configs: %w", err)
+	}
+
+	// Replace the tagged group in the remote payload with the local tagged servers
+	merged := make(map[string]interface{})
+	pushedCount := 0
+	for _, agentID := range unionKeys(localConfigs, remoteConfigs) {
+		keyName := as.configLoader.GetConfigKey(agentID)
+		servers := filterServersByTag(agentServers(remoteConfigs[agentID], keyName), tag, false)
+		for name, server := range filterServersByTag(agentServers(localConfigs[agentID], keyName), tag, true) {
+			servers[name] = server
+			pushedCount++
+		}
+		// Start from the agent's existing config so extra_sync_keys settings survive; only the servers are replaced
+		existing, _ := remoteConfigs[agentID].(map[string]interface{})
+		if existing == nil {
+			existing, _ = localConfigs[agentID].(map[string]interface{})
+		}
+		config := make(map[string]interface{}, len(existing)+1)
+		for key, value := range existing {
+			config[key] = value
+		}
+		config[keyName] = servers
+		if len(servers) > 0 || len(config) > 1 {
+			merged[agentID] = config
+		}
+	}
+
+	configContent, _ := json.MarshalIndent(merged, "", "  ")
+	as.storage.SaveConfigVersion(models.ConfigVersion{
+		ID:        "local_" + nowStr(),
+		Timestamp: nowTime(),
+		Content:   string(configContent),
+		Source:    "local",
+		Note:      fmt.Sprintf("Pushed servers tagged %q", tag),
+	})
+
+	if err := gs.PushAgentConfigsToGist(merged); err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "push",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return err
+	}
+
+	as.updateMergeBase(merged, "push")
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push",
+		Status:    "success",
+		Message:   fmt.Sprintf("Pushed %d servers tagged %q to Gist", pushedCount, tag),
+	})
+
+	return nil
+}
+
+// PullFromGistMerge 拉取 Gist 中的配置并与本地合并：远程的服务器被新增或更新，只在本地存在的服务器保留不变。
+// 没有变化的 agent 不会被写入；写入前先备份
+func (as *AppService) PullFromGistMerge() (_ *models.PullResult, err error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("pull_merge")()
+
+	start := time.Now()
+	payloadBytes := 0
+	defer func() { as.recordSyncMetrics("pull", payloadBytes, start, err) }()
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+
+	agentConfigs, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "pull",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return nil, err
+	}
+
+	configContent, _ := json.MarshalIndent(agentConfigs, "", "  ")
+	payloadBytes = len(configContent)
+	if len(agentConfigs) > 0 {
+		as.storage.SaveConfigVersion(models.ConfigVersion{
+			ID:        "remote_" + nowStr(),
+			Timestamp: nowTime(),
+			Content:   string(configContent),
+			Source:    "gist",
+			Note:      "Pulled configs from Gist and merged with local servers",
+		})
+	}
+
+	result := &models.PullResult{Agents: []models.AgentPullResult{}}
+	scope := as.agentScope()
+	for _, agentID := range unionKeys(agentConfigs, nil) {
+		if scope != nil && !scope[agentID] {
+			continue
+		}
+		configMap, ok := agentConfigs[agentID].(map[string]interface{})
+		if !ok {
+			println(fmt.Sprintf("Warning: skipping %s, unexpected config shape in Gist", agentID))
+			continue
+		}
+
+		agentResult, applied := as.mergeRemoteAgentConfig(agentID, configMap)
+		if applied {
+			result.AppliedCount++
+		}
+		result.Agents = append(result.Agents, agentResult)
+	}
+
+	changes := as.summarizeAgentChanges(as.mergeBaseAgents(), agentConfigs)
+	as.updateMergeBase(agentConfigs, "pull")
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "pull",
+		Status:    "success",
+		Message:   fmt.Sprintf("Merged configurations from Gist into %d agents, local-only servers kept: %s", result.AppliedCount, changes),
+	})
+
+	return result, nil
+}
+
+// mergeRemoteAgentConfig 把远程的服务器合并进 agent 的本地配置，返回变化以及是否写入了配置文件
+func (as *AppService) mergeRemoteAgentConfig(agentID string, remoteConfig map[string]interface{}) (models.AgentPullResult, bool) {
+	agentResult := models.AgentPullResult{AgentID: agentID, Added: []string{}, Updated: []string{}, Kept: []string{}}
+
+	local, err := as.standardAgentServers(agentID)
+	if err != nil {
+		agentResult.Error = fmt.Sprintf("failed to read local config: %v", err)
+		return agentResult, false
+	}
+	remote, _ := standardServersFrom(remoteConfig, as.configLoader.GetConfigKey(agentID))
+
+	merged := make(map[string]interface{}, len(local)+len(remote))
+	for _, name := range unionKeys(local, remote) {
+		localServer, inLocal := local[name]
+		remoteServer, inRemote := remote[name]
+		switch {
+		case !inRemote:
+			merged[name] = localServer
+			agentResult.Kept = append(agentResult.Kept, name)
+		case !inLocal:
+			merged[name] = remoteServer
+			agentResult.Added = append(agentResult.Added, name)
+		default:
+			merged[name] = remoteServer
+			if !jsonEqual(localServer, remoteServer) {
+				agentResult.Updated = append(agentResult.Updated, name)
+			}
+		}
+	}
+
+	disabled, _ := remoteConfig[disabledServersKey].(map[string]interface{})
+	if len(agentResult.Added) == 0 && len(agentResult.Updated) == 0 {
+		if err := as.rememberDisabledServers(agentID, disabled); err != nil {
+			agentResult.Error = err.Error()
+		}
+		return agentResult, false
+	}
+
+	if _, err := as.backupAgentConfig(agentID); err != nil {
+		agentResult.Error = fmt.Sprintf("failed to back up before pull: %v", err)
+		return agentResult, false
+	}
+	// merged is in the standard structure; keyed by mcpServers so it is not read as Zed servers
+	mergedConfig := map[string]interface{}{"mcpServers": merged}
+	if disabled != nil {
+		mergedConfig[disabledServersKey] = disabled
+	}
+	if err := as.applyRemoteAgentConfig(agentID, mergedConfig); err != nil {
+		agentResult.Error = fmt.Sprintf("failed to apply merged config: %v", err)
+		return agentResult, false
+	}
+	println(fmt.Sprintf("Merged remote servers into %s: %d added, %d updated, %d kept", agentID, len(agentResult.Added), len(agentResult.Updated), len(agentResult.Kept)))
+	return agentResult, true
+}
+
+// PullByTag 只拉取带有指定标签的服务器，本地其他分组的服务器保持不变
+func (as *AppService) PullByTag(tag string) ([]models.MCPServer, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("pull_by_tag")()
+
+	if tag == "" {
+		return nil, fmt.Errorf("tag is required")
+	}
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteConfigs, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "pull",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return nil, err
+	}
+
+	servers := []models.MCPServer{}
+	scope := as.agentScope()
+	applied, unchanged := 0, 0
+	var failed []string
+	var errs []error
+	// The tagged servers each agent now shares with the gist, recorded in the merge base below
+	synced := make(map[string]map[string]interface{})
+	for _, agentID := range sortedKeys(remoteConfigs) {
+		if scope != nil && !scope[agentID] {
+			continue
+		}
+		localConfig, err := as.GetAgentMCPConfig(agentID)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: failed to read local config: %v", agentID, err))
+			errs = append(errs, fmt.Errorf("%s: %w", agentID, err))
+			continue
+		}
+
+		keyName := as.configLoader.GetConfigKey(agentID)
+		localServers := agentServers(localConfig, keyName)
+		tagged := filterServersByTag(agentServers(remoteConfigs[agentID], keyName), tag, true)
+		var names []string
+		for name := range tagged {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		tagServers := make([]models.MCPServer, 0, len(names))
+		for _, name := range names {
+			tagServers = append(tagServers, models.MCPServer{ID: name, Name: name, Tags: []string{tag}})
+		}
+
+		// Agents whose tagged servers already match the gist are left untouched
+		if jsonEqual(tagged, filterServersByTag(localServers, tag, true)) {
+			unchanged++
+			synced[agentID] = tagged
+			servers = append(servers, tagServers...)
+			continue
+		}
+
+		merged := filterServersByTag(localServers, tag, false)
+		for name, server := range tagged {
+			merged[name] = server
+		}
+		if err := as.SaveAgentMCPConfig(agentID, map[string]interface{}{keyName: merged}); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", agentID, err))
+			errs = append(errs, fmt.Errorf("%s: %w", agentID, err))
+			continue
+		}
+		applied++
+		synced[agentID] = tagged
+		servers = append(servers, tagServers...)
+	}
+	as.updateMergeBaseTag(synced, tag, "pull")
+
+	status := "success"
+	switch {
+	case len(failed) > 0 && applied+unchanged == 0:
+		status = "failed"
+	case len(failed) > 0:
+		status = "partial"
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "pull",
+		Status:    status,
+		Message:   fmt.Sprintf("Pulled %d servers tagged %q from Gist and applied them to %d agents", len(servers), tag, applied),
+		Details:   strings.Join(failed, "; "),
+	})
+
+	if len(errs) > 0 {
+		return servers, fmt.Errorf("failed to apply servers tagged %q to %d agents: %w", tag, len(errs), errors.Join(errs...))
+	}
+	return servers, nil
+}
+
+// PushAgentToGist 只把一个 agent 的本地配置推送到 Gist，远程中其他 agent 的配置保持不变。
+// 读取和写入之间 Gist 被修改时返回 ErrConflict
+func (as *AppService) PushAgentToGist(agentID string) (err error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	start := time.Now()
+	payloadBytes := 0
+	defer func() { as.recordSyncMetrics("push", payloadBytes, start, err) }()
+
+	if scope := as.agentScope(); scope != nil && !scope[agentID] {
+		return fmt.Errorf("agent %s is not part of the active profile", agentID)
+	}
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+	if err := as.checkSchemas([]string{agentID}); err != nil {
+		return err
+	}
+
+	localConfig, err := as.collectAgentConfig(agentID)
+	if err != nil {
+		return fmt.Errorf("failed to read %s config: %w", agentID, err)
+	}
+	remoteConfigs, revision, err := gs.PullAgentConfigsWithRevision()
+	if err != nil {
+		return fmt.Errorf("failed to read remote configs: %w", err)
+	}
+	remoteConfigs[agentID] = localConfig
+
+	configContent, _ := json.MarshalIndent(remoteConfigs, "", "  ")
+	payloadBytes = len(configContent)
+	as.storage.SaveConfigVersion(models.ConfigVersion{
+		ID:        "local_" + nowStr(),
+		Timestamp: nowTime(),
+		Content:   string(configContent),
+		Source:    "local",
+		Note:      fmt.Sprintf("Pushed configuration for %s", agentID),
+	})
+
+	if err := gs.PushAgentConfigsIfUnchanged(remoteConfigs, revision); err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "push_agent",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return err
+	}
+
+	as.updateMergeBase(remoteConfigs, "push")
+	as.recordSyncSuccess()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push_agent",
+		Status:    "success",
+		Message:   fmt.Sprintf("Pushed configuration for %s to Gist", agentID),
+	})
+
+	return nil
+}
+
+// PullAgentFromGist 只从 Gist 恢复一个 agent 的配置（写入前先备份），其他 agent 保持不变。
+// 成功后只更新合并基准中该 agent 的条目
+func (as *AppService) PullAgentFromGist(agentID string) (err error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("pull_agent")()
+
+	start := time.Now()
+	payloadBytes := 0
+	defer func() { as.recordSyncMetrics("pull", payloadBytes, start, err) }()
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+
+	agentConfigs, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		return err
+	}
+
+	configMap, ok := agentConfigs[agentID].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%w: agent %s has no configuration in the Gist", ErrNotFound, agentID)
+	}
+	agentContent, _ := json.Marshal(configMap)
+	payloadBytes = len(agentContent)
+
+	if _, err := as.backupAgentConfig(agentID); err != nil {
+		return fmt.Errorf("failed to back up %s before pull: %w", agentID, err)
+	}
+
+	// SaveAgentMCPConfig (via applyRemoteAgentConfig) converts the remote config to the agent's current format
+	if err := as.applyRemoteAgentConfig(agentID, configMap); err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "pull_agent",
+			Status:    "failed",
+			Message:   fmt.Sprintf("Failed to apply %s from Gist: %v", agentID, err),
+		})
+		return err
+	}
+	as.updateMergeBaseAgents(map[string]interface{}{agentID: configMap}, "pull")
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "pull_agent",
+		Status:    "success",
+		Message:   fmt.Sprintf("Pulled configuration for %s from Gist", agentID),
+	})
+
+	return nil
+}
+
+// agentServers returns the server map stored under keyName in an agent config
+func agentServers(agentConfig interface{}, keyName string) map[string]interface{} {
+	if configMap, ok := agentConfig.(map[string]interface{}); ok {
+		if servers, ok := configMap[keyName].(map[string]interface{}); ok {
+			return servers
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// filterServersByTag keeps servers that carry tag (or, when want is false, those that do not)
+func filterServersByTag(servers map[string]interface{}, tag string, want bool) map[string]interface{} {
+	result := make(map[string]interface{})
+	for name, server := range servers {
+		if serverHasTag(server, tag) == want {
+			result[name] = server
+		}
+	}
+	return result
+}
+
+// serverHasTag reports whether a server entry lists tag in its "tags" field
+func serverHasTag(server interface{}, tag string) bool {
+	serverMap, ok := server.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	switch tags := serverMap["tags"].(type) {
+	case []interface{}:
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	case []string:
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unionKeys returns the sorted keys present in either map
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (as *AppService) ApplyConfigToAgents(agentID string, servers []models.MCPServer) error {
+	return as.writeAgentServers(agentID, servers)
+}
+
+// ApplyConfigToAllAgents 把服务器列表写入所有已检测到的 agent，某个 agent 失败时继续写入其余 agent。
+// 结果按 agent 返回（成功为 nil）；有 agent 失败时同时返回汇总错误，可以用 errors.Is 匹配各 agent 的错误
+func (as *AppService) ApplyConfigToAllAgents(servers []models.MCPServer) (map[string]error, error) {
+	agents, err := as.detector.DetectInstalledAgents()
+	if err != nil {
+		return nil, err
+	}
+
+	var agentIDs []string
+	for _, agent := range agents {
+		if agent.Status == "detected" {
+			agentIDs = append(agentIDs, agent.ID)
+		}
+	}
+	results := forEachAgentParallel(agentIDs, func(agentID string) error {
+		return as.writeAgentServers(agentID, servers)
+	})
+
+	var failed []string
+	for agentID, err := range results {
+		if err != nil {
+			failed = append(failed, agentID)
+		}
+	}
+	if len(failed) == 0 {
+		return results, nil
+	}
+
+	sort.Strings(failed)
+	errs := make([]error, 0, len(failed))
+	for _, agentID := range failed {
+		errs = append(errs, fmt.Errorf("%s: %w", agentID, results[agentID]))
+	}
+	return results, fmt.Errorf("failed to apply config to %d of %d agents: %w", len(failed), len(results), errors.Join(errs...))
+}
+
+// writeAgentServers 通过 ConfigManager 把服务器列表写入 agent 配置文件，并记录到审计日志
+func (as *AppService) writeAgentServers(agentID string, servers []models.MCPServer) error {
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		return err
+	}
+	return as.auditedWrite("apply_config", agentID, configPath, func() error {
+		return as.configManager.WriteAgentMCPConfig(agentID, servers)
+	})
+}
+
+func (as *AppService) GetSyncConfig() (models.SyncConfig, error) {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+	return as.storage.LoadSyncConfig()
+}
+
+func (as *AppService) SaveSyncConfig(config models.SyncConfig) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+	return as.storage.SaveSyncConfig(config)
+}
+
+func (as *AppService) GetConfigVersions(limit int) ([]models.ConfigVersion, error) {
+	return as.storage.ListConfigVersions(limit)
+}
+
+func (as *AppService) GetSyncLogs(limit int) ([]models.SyncLog, error) {
+	return as.storage.GetSyncLogs(limit)
+}
+
+// GetConfigVersionsWithSkipped 返回版本列表，并报告无法读取而被跳过的文件数
+func (as *AppService) GetConfigVersionsWithSkipped(limit int) (*models.ConfigVersionList, error) {
+	versions, skipped, err := as.storage.ListConfigVersionsWithSkipped(limit)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ConfigVersionList{Versions: versions, Skipped: skipped}, nil
+}
+
+// GetSyncLogsWithSkipped 返回同步日志，并报告无法读取而被跳过的文件数
+func (as *AppService) GetSyncLogsWithSkipped(limit int) (*models.SyncLogList, error) {
+	logs, skipped, err := as.storage.GetSyncLogsWithSkipped(limit)
+	if err != nil {
+		return nil, err
+	}
+	return &models.SyncLogList{Logs: logs, Skipped: skipped}, nil
+}
+
+// DiagnoseUndecryptable 列出无法解密或解析的版本和日志文件及原因
+func (as *AppService) DiagnoseUndecryptable() ([]string, error) {
+	if !fileExists(as.storage.GetDataDir()) {
+		return nil, fmt.Errorf("data directory %s does not exist", as.storage.GetDataDir())
+	}
+	broken := as.storage.UndecryptableFiles()
+	if broken == nil {
+		broken = []string{}
+	}
+	return broken, nil
+}
+
+// GetAgentMCPConfig 读取 agent 的 MCP 配置；已解析的密钥值会还原为 ${secret:name} 引用
+func (as *AppService) GetAgentMCPConfig(agentID string) (map[string]interface{}, error) {
+	config, err := as.readAgentMCPConfig(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := as.storage.LoadSecretRefs()
+	if err != nil {
+		println(fmt.Sprintf("Warning: failed to load secret references: %v", err))
+		return config, nil
+	}
+	if err := restoreSecretRefs(agentID, config, as.secrets, refs); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// GetAgentMCPConfigDetailed 与 GetAgentMCPConfig 相同，但同时返回配置文件的格式、路径和配置键
+func (as *AppService) GetAgentMCPConfigDetailed(agentID string) (*models.AgentConfigResult, error) {
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, err := as.GetAgentMCPConfig(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	format := "json"
+	switch agentFormat := as.configLoader.GetFormat(agentID); {
+	case isTOMLFormat(agentFormat):
+		format = "toml"
+	case agentFormat == "json5":
+		format = "json5"
+	}
+
+	return &models.AgentConfigResult{
+		Servers:    servers,
+		Format:     format,
+		ConfigPath: configPath,
+		ConfigKey:  as.configLoader.GetConfigKey(agentID),
+	}, nil
+}
+
+// readAgentMCPConfig 读取 agent 配置文件中的 MCP 服务器部分（磁盘上的原始值）
+func (as *AppService) readAgentMCPConfig(agentID string) (map[string]interface{}, error) {
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	keyName := as.configLoader.GetConfigKey(agentID)
+	servers, err := formatAdapterFor(as.configLoader.GetFormat(agentID)).ReadServers(configPath, keyName)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := as.readExtraSettings(agentID, configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]interface{}{
+		keyName: servers,
+	}
+	// Settings declared in extra_sync_keys travel next to the servers
+	for key, value := range settings {
+		config[key] = value
+	}
+	return config, nil
+}
+
+func (as *AppService) SaveAgentMCPConfig(agentID string, mcpServersConfig map[string]interface{}) error {
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		return err
+	}
+	// Taken before the server transformations below, which treat every object in the config as servers
+	settings := as.extraSettingsIn(agentID, mcpServersConfig)
+
+	// ${HOME}/... paths from the gist become absolute paths on this machine
+	if as.portablePathsEnabled() {
+		mcpServersConfig = mapConfigServers(mcpServersConfig, as.MaterializePaths)
+	}
+
+	// Resolve ${secret:name} references at apply time; only the reference is kept for syncing
+	mcpServersConfig, err = as.resolveSecrets(agentID, mcpServersConfig)
+	if err != nil {
+		return err
+	}
+
+	// Held from reading the local file to the write, so a parallel write to a shared file is not lost
+	defer as.lockConfigFile(configPath)()
+
+	// Values stripped before pushing stay as they are in the local config
+	if local, err := as.readAgentMCPConfig(agentID); err == nil {
+		mcpServersConfig = keepLocalSecrets(mcpServersConfig, local)
+	}
+
+	// The input may be keyed by context_servers, mcpServers or the agent's own key;
+	// the format adapter writes it in the agent's format and keeps the rest of the file
+	keyName := as.configLoader.GetConfigKey(agentID)
+	servers, ok := standardServersFrom(mcpServersConfig, keyName)
+	if !ok && len(settings) == 0 {
+		return nil
+	}
+
+	// Numbers and booleans in args/env become strings, the same for every format (see coerce.go)
+	return as.auditedWriteLocked("save_agent_config", agentID, configPath, func() error {
+		if ok {
+			if err := formatAdapterFor(as.configLoader.GetFormat(agentID)).WriteServers(configPath, keyName, coerceServers(servers)); err != nil {
+				return err
+			}
+		}
+		return as.writeExtraSettings(agentID, configPath, settings)
+	})
+}
+
+// SetAllServersEnabled 启用或停用 agent 的全部 MCP 服务器，写入前先备份。停用只是给服务器加上 disabled 标记
+// （Zed 和 Codex 中为 enabled = false），配置本身保留；之前已单独停用的服务器不会被记录，
+// 因此重新启用后恢复的正好是停用前处于启用状态的那些服务器
+func (as *AppService) SetAllServersEnabled(agentID string, enabled bool) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("toggle_servers")()
+
+	config, err := as.readAgentMCPConfig(agentID)
+	if err != nil {
+		return fmt.Errorf("failed to read %s config: %w", agentID, err)
+	}
+	keyName := as.configLoader.GetConfigKey(agentID)
+	servers, ok := standardServersFrom(config, keyName)
+	if !ok || len(servers) == 0 {
+		return nil
+	}
+
+	syncConfig, err := as.GetSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	toggled := syncConfig.ToggledOff[agentID]
+
+	var changed []string
+	if enabled {
+		// Without a record of what was toggled off, enabling means enabling everything
+		names := toggled
+		if names == nil {
+			for name := range servers {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			if server, ok := servers[name].(map[string]interface{}); ok && serverDisabled(server) {
+				delete(server, "disabled")
+				changed = append(changed, name)
+			}
+		}
+		toggled = nil
+	} else {
+		for name, server := range servers {
+			if serverMap, ok := server.(map[string]interface{}); ok && !serverDisabled(serverMap) {
+				serverMap["disabled"] = true
+				changed = append(changed, name)
+			}
+		}
+		toggled = append(toggled, changed...)
+		sort.Strings(toggled)
+	}
+
+	if len(changed) > 0 {
+		if _, err := as.backupAgentConfig(agentID); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", agentID, err)
+		}
+		// servers are in the standard structure; keyed by mcpServers so they are not read as Zed servers
+		if err := as.SaveAgentMCPConfig(agentID, map[string]interface{}{"mcpServers": servers}); err != nil {
+			return err
+		}
+	}
+
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+	latest, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	if len(toggled) > 0 {
+		if latest.ToggledOff == nil {
+			latest.ToggledOff = make(map[string][]string)
+		}
+		latest.ToggledOff[agentID] = toggled
+	} else {
+		delete(latest.ToggledOff, agentID)
+	}
+	if err := as.storage.SaveSyncConfig(latest); err != nil {
+		return fmt.Errorf("failed to save toggled servers: %w", err)
+	}
+
+	println(fmt.Sprintf("%s: set enabled=%t on %d servers", agentID, enabled, len(changed)))
+	return nil
+}
+
+// NormalizeAgentConfig 以规范形式重写 agent 的配置文件：JSON 的键按字母排序、两空格缩进，服务器中空的 args/env 被删除。
+// Codex 的 TOML 按固定顺序重新生成；JSON5 和 Zed 的 settings.json 只规范化服务器部分，其余内容保持原样。
+// 内容有变化时才会备份并写入，因此重复执行不会产生新的备份。JSON 文件开头的 // 注释会保留，其他位置的注释会丢失
+func (as *AppService) NormalizeAgentConfig(agentID string) error {
+	defer as.beginOperation("normalize")()
+
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		return err
+	}
+	current, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s config: %w", agentID, err)
+	}
+	text, enc := decodeConfigText(current)
+
+	keyName := as.configLoader.GetConfigKey(agentID)
+	var normalized []byte
+	switch format := as.configLoader.GetFormat(agentID); {
+	case isTOMLFormat(format):
+		config, err := as.tomlAdapter.ReadCodexConfig(configPath)
+		if err != nil {
+			return err
+		}
+		// The canonical form lists servers alphabetically rather than in file order
+		config.ServerOrder = nil
+		normalized = []byte(as.tomlAdapter.RenderCodexConfig(config))
+
+	case format == "json5" || format == "zed":
+		config, err := readJSON5ConfigFile(configPath)
+		if err != nil {
+			return err
+		}
+		servers, ok := config[keyName].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		style := json5Style
+		if format == "zed" {
+			style = jsoncStyle
+		}
+		if normalized, err = replaceSection(text, keyName, withKeyOrder(dropEmptyServerFields(servers), nil), style); err != nil {
+			return fmt.Errorf("failed to normalize %s: %w", filepath.Base(configPath), err)
+		}
+
+	default:
+		config, err := readJSONConfigFile(configPath)
+		if err != nil {
+			return err
+		}
+		if servers, ok := config[keyName].(map[string]interface{}); ok {
+			config[keyName] = dropEmptyServerFields(servers)
+		}
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		normalized = append([]byte(leadingCommentLines(string(text))), append(data, '\n')...)
+	}
+
+	// Line endings and a BOM are kept as they are; only the content is normalized
+	normalized = enc.encode(normalized)
+	if bytes.Equal(normalized, current) {
+		return nil
+	}
+	if _, err := as.backupAgentConfig(agentID); err != nil {
+		return fmt.Errorf("failed to back up %s before normalizing: %w", agentID, err)
+	}
+	return as.auditedWrite("normalize", agentID, configPath, func() error {
+		return os.WriteFile(configPath, normalized, 0644)
+	})
+}
+
+// dropEmptyServerFields 删除服务器配置中空的 args 和 env，它们与不设置等价
+func dropEmptyServerFields(servers map[string]interface{}) map[string]interface{} {
+	for _, server := range servers {
+		serverMap, ok := server.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if args, ok := serverMap["args"].([]interface{}); ok && len(args) == 0 {
+			delete(serverMap, "args")
+		}
+		if env, ok := serverMap["env"].(map[string]interface{}); ok && len(env) == 0 {
+			delete(serverMap, "env")
+		}
+	}
+	return servers
+}
+
+// leadingCommentLines 返回文件开头连续的 // 注释行（例如 Zed settings.json 的说明），包括结尾的换行
+func leadingCommentLines(content string) string {
+	var header strings.Builder
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			break
+		}
+		header.WriteString(strings.TrimRight(line, " \t\r\n") + "\n")
+	}
+	return header.String()
+}
+
+// serverDisabled reports whether a standard server config carries the disabled marker
+func serverDisabled(server map[string]interface{}) bool {
+	disabled, _ := server["disabled"].(bool)
+	return disabled
+}
+
+// resolveSecrets 将配置中的 ${secret:name} 替换为密钥环中的值，并记录引用以便推送时还原。
+// 开启 ExpandCodexEnv 时，Codex 配置中的 ${NAME} 也在这里展开为环境变量的值
+func (as *AppService) resolveSecrets(agentID string, config map[string]interface{}) (map[string]interface{}, error) {
+	refs, err := as.storage.LoadSecretRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret references: %w", err)
+	}
+
+	syncConfig, _ := as.storage.LoadSyncConfig()
+	expandEnv := syncConfig.ExpandCodexEnv && isTOMLFormat(as.configLoader.GetFormat(agentID))
+	resolved, err := resolveSecretRefs(agentID, config, as.secrets, refs, expandEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(refs) > 0 {
+		if err := as.storage.SaveSecretRefs(refs); err != nil {
+			return nil, fmt.Errorf("failed to save secret references: %w", err)
+		}
+	}
+
+	return resolved, nil
+}
+
+// SetExpandCodexEnv 设置写入 Codex 配置时是否展开 env 值中的 ${NAME} 环境变量引用
+func (as *AppService) SetExpandCodexEnv(enabled bool) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.ExpandCodexEnv = enabled
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save Codex env expansion setting: %w", err)
+	}
+	return nil
+}
+
+// IsKeyringAvailable 报告系统密钥环是否可用；不可用时无法启用加密，界面应禁用加密开关
+func (as *AppService) IsKeyringAvailable() bool {
+	return as.storage.KeyringAvailable()
+}
+
+// RotateEncryptionKey 轮换本地主密钥并记录轮换时间；与同步操作互斥。
+// Gist 也用主密钥加密时，先用旧密钥读取 Gist，在替换主密钥之前用新密钥重新加密并写回；无法读取 Gist 时拒绝轮换
+func (as *AppService) RotateEncryptionKey() error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	if as.storage.crypto == nil || !as.storage.crypto.IsEnabled() {
+		return fmt.Errorf("local encryption is not enabled")
+	}
+
+	reencryptGist, err := as.prepareGistReencrypt()
+	if err == nil {
+		err = as.storage.crypto.RotateKeyWith(reencryptGist)
+	}
+	if err != nil {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "key_rotation",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return err
+	}
+
+	as.configMu.Lock()
+	config, err := as.storage.LoadSyncConfig()
+	if err == nil {
+		config.LastKeyRotation = nowTime()
+		err = as.storage.SaveSyncConfig(config)
+	}
+	as.configMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("key rotated but failed to record rotation time: %w", err)
+	}
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "key_rotation",
+		Status:    "success",
+		Message:   "Local encryption key rotated",
+	})
+
+	return nil
+}
+
+// prepareGistReencrypt 在 Gist 用主密钥加密时，用当前密钥读取 Gist，返回在轮换中用新密钥重写它的函数；
+// 没有配置 Gist 或 Gist 使用单独的加密密码时返回 nil。Gist 已配置但无法读取时返回错误，调用方应拒绝轮换
+func (as *AppService) prepareGistReencrypt() (func(newKey []byte) error, error) {
+	// An unreadable config must not be mistaken for "no gist configured"
+	if _, err := as.GetSyncConfig(); err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config, gs, err := as.gistSyncBackend()
+	if config.GistID == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot rotate the key without re-encrypting the gist: %w", err)
+	}
+	if !gs.usesKeyringKey() {
+		return nil, nil
+	}
+	reencrypt, err := gs.prepareReencrypt()
+	if err != nil {
+		return nil, fmt.Errorf("cannot rotate the key: failed to read the gist with the current key: %w", err)
+	}
+	return func(newKey []byte) error {
+		if err := reencrypt(newKey); err != nil {
+			return fmt.Errorf("failed to re-encrypt the gist with the new key: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// ResetEncryption 在密钥丢失时重置本地加密：把无法解密的文件移到 orphaned 目录并生成新密钥。
+// 文件不会被删除，找回旧密钥后可手动移回。返回 orphaned 目录（没有文件被移动时为空）。
+func (as *AppService) ResetEncryption() (string, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	orphanDir, err := as.storage.OrphanEncryptedFiles()
+	if err != nil {
+		return "", fmt.Errorf("failed to set aside unreadable files: %w", err)
+	}
+
+	if err := as.storage.EnableEncryption(""); err != nil {
+		return orphanDir, fmt.Errorf("failed to create a new encryption key: %w", err)
+	}
+
+	message := "Encryption reset with a new key"
+	if orphanDir != "" {
+		message += ", unreadable files moved to " + orphanDir
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "encryption_reset",
+		Status:    "success",
+		Message:   message,
+	})
+
+	return orphanDir, nil
+}
+
+// Doctor 检查本地与远程同步状态的一致性，返回发现的问题及修复建议
+func (as *AppService) Doctor() (*models.DoctorReport, error) {
+	report := &models.DoctorReport{CheckedAt: nowTime()}
+	add := func(check, severity, message, suggestion string) {
+		report.Findings = append(report.Findings, models.DoctorFinding{
+			Check:      check,
+			Severity:   severity,
+			Message:    message,
+			Suggestion: suggestion,
+		})
+	}
+
+	// Data directory
+	if err := as.storage.CheckWritable(); err != nil {
+		add("data_dir", "error", fmt.Sprintf("Data directory %s is not writable: %v", as.storage.GetDataDir(), err),
+			"Check the permissions of the data directory and free disk space")
+	}
+
+	// Check the key before loading the config, which auto-enables encryption when the key is missing
+	keyPresent := as.storage.IsEncryptionEnabled()
+
+	config, err := as.storage.LoadSyncConfig()
+	switch {
+	case errors.Is(err, ErrEncryptionKeyMissing):
+		add("encryption", "error", "Encryption is enabled but no key was found in the system keyring to read existing encrypted files",
+			"Restore the key from a backup, or reset encryption to set the unreadable files aside and start with a new key")
+	case err != nil:
+		add("encryption", "error", fmt.Sprintf("Sync config cannot be read: %v", err),
+			"Restore the encryption key or remove sync_config.json and set up sync again")
+	case config.EnableEncryption && !keyPresent:
+		add("encryption", "info", "Encryption was enabled in the sync config without a key; a new key was generated",
+			"No existing data was encrypted, so nothing was lost")
+	}
+
+	// Undecryptable history
+	if broken := as.storage.UndecryptableFiles(); len(broken) > 0 {
+		add("storage_files", "warning", fmt.Sprintf("%d version/log files cannot be decrypted: %s", len(broken), strings.Join(broken, ", ")),
+			"These files were written with a different key; restore that key or delete the files")
+	}
+
+	// Token and gist
+	if err == nil {
+		as.doctorCheckRemote(config, add)
+	}
+
+	// Agent definitions that load but can never be detected or synced
+	for _, problem := range as.configLoader.ValidateAgentDefinitions() {
+		add("agents", "warning", "agents.yaml: "+problem, "Fix the agent definition in agents.yaml")
+	}
+
+	// Agent detection
+	agents, detectErr := as.detector.DetectInstalledAgents()
+	if detectErr != nil {
+		add("agents", "error", fmt.Sprintf("Agent detection failed: %v", detectErr), "Check agents.yaml for syntax errors")
+	} else {
+		detected := 0
+		for _, agent := range agents {
+			if agent.Status != "detected" {
+				continue
+			}
+			detected++
+			if _, err := as.readAgentMCPConfig(agent.ID); err != nil {
+				add("agents", "warning", fmt.Sprintf("Config for %s cannot be parsed: %v", agent.ID, err),
+					fmt.Sprintf("Fix or restore the config file at %s", agent.ExistingPaths[0]))
+			}
+		}
+		if detected == 0 {
+			add("agents", "warning", "No installed agents were detected", "Install a supported agent or add its config path to agents.yaml")
+		}
+	}
+
+	report.Healthy = true
+	for _, finding := range report.Findings {
+		if finding.Severity == "error" {
+			report.Healthy = false
+		}
+	}
+
+	return report, nil
+}
+
+// doctorCheckRemote 检查 GitHub token 的有效性、权限以及 Gist 是否存在
+func (as *AppService) doctorCheckRemote(config models.SyncConfig, add func(check, severity, message, suggestion string)) {
+	token, _, err := resolveGitHubToken(config)
+	if err != nil {
+		add("token", "error", err.Error(), "Fix the credential helper command or set "+GitHubTokenEnv)
+		return
+	}
+	if token == "" {
+		if config.GistID != "" {
+			add("token", "error", "A gist ID is configured but the GitHub token is missing", "Set a GitHub token with the gist scope")
+		} else {
+			add("token", "info", "Gist sync is not configured", "Add a GitHub token to enable sync")
+		}
+		return
+	}
+
+	gs := as.newGistSyncFor(token, config.GistID, config)
+	if err := gs.ValidateTokenScopes(); err != nil {
+		switch {
+		case isNetworkError(err):
+			add("token", "warning", fmt.Sprintf("Could not reach GitHub to validate the token: %v", err), "Check your network connection and try again")
+		case errors.Is(err, ErrTokenMissingGistScope):
+			add("token", "error", "GitHub token is missing the gist scope", "Create a token with the gist scope and update it")
+		default:
+			add("token", "error", fmt.Sprintf("GitHub token is invalid: %v", err), "Generate a new token and update it")
+		}
+		return
+	}
+
+	if config.GistID == "" {
+		add("gist", "warning", "GitHub token is set but no gist is configured", "Create a gist or enter an existing gist ID")
+		return
+	}
+
+	if err := gs.ValidateGist(); err != nil {
+		switch {
+		case errors.Is(err, ErrGistNotFound):
+			add("gist", "error", fmt.Sprintf("Gist %s no longer exists", config.GistID), "Create a new gist or migrate to another one")
+		case errors.Is(err, ErrGistNoAccess):
+			add("gist", "error", fmt.Sprintf("Gist %s is not accessible with this token", config.GistID), "Use a token from the account that owns the gist")
+		case errors.Is(err, ErrGistWrongOwner):
+			add("gist", "warning", fmt.Sprintf("Gist %s belongs to a different account", config.GistID), "Push will fail; migrate the config to a gist you own")
+		case errors.Is(err, ErrGistUnexpectedContent):
+			add("gist", "warning", fmt.Sprintf("Gist %s does not look like an mcp-sync gist", config.GistID), "Check that the gist ID is correct")
+		case isNetworkError(err):
+			add("gist", "warning", fmt.Sprintf("Could not reach GitHub to check the gist: %v", err), "Check your network connection and try again")
+		default:
+			add("gist", "error", fmt.Sprintf("Gist check failed: %v", err), "Verify the gist ID and token")
+		}
+	}
+}
+
+// SetSecret 保存可在 env 中以 ${secret:name} 引用的密钥值
+func (as *AppService) SetSecret(name, value string) error {
+	if as.secrets == nil {
+		return fmt.Errorf("secret store unavailable: system keyring not initialized")
+	}
+	return as.secrets.SetSecret(name, value)
+}
+
+// DeleteSecret 删除命名的密钥值
+func (as *AppService) DeleteSecret(name string) error {
+	if as.secrets == nil {
+		return fmt.Errorf("secret store unavailable: system keyring not initialized")
+	}
+	return as.secrets.DeleteSecret(name)
+}
+
+// convertZedToStandard converts Zed context_servers format to standard mcpServers format
+func convertZedToStandard(data interface{}) interface{} {
+	servers, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+
+	result := make(map[string]interface{})
+	for name, config := range servers {
+		configMap, ok := config.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Extract relevant fields and convert to standard format
+		newConfig := make(map[string]interface{})
+		if cmd, ok := configMap["command"]; ok {
+			newConfig["command"] = cmd
+		}
+		if args, ok := configMap["args"]; ok {
+			newConfig["args"] = coerceArgs(args)
+		}
+		if env, ok := configMap["env"]; ok {
+			newConfig["env"] = coerceEnv(env)
+		}
+		// HTTP servers: Zed and the standard format both use url and headers
+		if url, ok := configMap["url"]; ok {
+			newConfig["url"] = url
+		}
+		if headers, ok := configMap["headers"]; ok {
+			newConfig["headers"] = headers
+		}
+		if tags, ok := configMap["tags"]; ok {
+			newConfig["tags"] = tags
+		}
+		copyReservedFields(newConfig, configMap)
+		if enabled, ok := configMap["enabled"].(bool); ok && !enabled {
+			newConfig["disabled"] = true
+		}
+
+		result[name] = newConfig
+	}
+
+	return result
+}
+
+// convertStandardToZed converts standard mcpServers format to Zed context_servers format
+func convertStandardToZed(data interface{}) interface{} {
+	servers, ok := data.(map[string]interface{})
+	if !ok {
+		return data
+	}
+
+	result := make(map[string]interface{})
+	for name, config := range servers {
+		configMap, ok := config.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Convert to Zed format
+		newConfig := make(map[string]interface{})
+		newConfig["source"] = "custom"
+		newConfig["enabled"] = !serverDisabled(configMap)
+
+		if cmd, ok := configMap["command"]; ok {
+			newConfig["command"] = cmd
+		}
+		if args, ok := configMap["args"]; ok {
+			newConfig["args"] = coerceArgs(args)
+		}
+		if env, ok := configMap["env"]; ok {
+			newConfig["env"] = coerceEnv(env)
+		}
+		// HTTP servers: Zed and the standard format both use url and headers
+		if url, ok := configMap["url"]; ok {
+			newConfig["url"] = url
+		}
+		if headers, ok := configMap["headers"]; ok {
+			newConfig["headers"] = headers
+		}
+		if tags, ok := configMap["tags"]; ok {
+			newConfig["tags"] = tags
+		}
+		copyReservedFields(newConfig, configMap)
 
 		result[name] = newConfig
 	}
 
-	return result
+	return result
+}
+
+// CompareAgents 对比两个 agent 的 MCP 服务器。两边都先转换为标准结构（Zed 的 source/enabled 等格式字段不参与比较），
+// 结果按服务器名排序
+func (as *AppService) CompareAgents(agentA, agentB string) (*models.AgentComparison, error) {
+	serversA, err := as.standardAgentServers(agentA)
+	if err != nil {
+		return nil, err
+	}
+	serversB, err := as.standardAgentServers(agentB)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &models.AgentComparison{
+		AgentA:    agentA,
+		AgentB:    agentB,
+		OnlyInA:   []string{},
+		OnlyInB:   []string{},
+		Identical: []string{},
+		Different: []models.ServerDifference{},
+	}
+	for _, name := range unionKeys(serversA, serversB) {
+		a, inA := serversA[name]
+		b, inB := serversB[name]
+		switch {
+		case !inB:
+			comparison.OnlyInA = append(comparison.OnlyInA, name)
+		case !inA:
+			comparison.OnlyInB = append(comparison.OnlyInB, name)
+		default:
+			aMap, _ := a.(map[string]interface{})
+			bMap, _ := b.(map[string]interface{})
+			if fields := diffFields("", aMap, bMap); len(fields) > 0 {
+				comparison.Different = append(comparison.Different, models.ServerDifference{Name: name, Fields: fields})
+			} else {
+				comparison.Identical = append(comparison.Identical, name)
+			}
+		}
+	}
+	return comparison, nil
+}
+
+// standardAgentServers 读取 agent 的服务器并转换为标准结构，值统一为 JSON 通用类型
+func (as *AppService) standardAgentServers(agentID string) (map[string]interface{}, error) {
+	config, err := as.GetAgentMCPConfig(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s config: %w", agentID, err)
+	}
+	servers, _ := standardServersFrom(config, as.configLoader.GetConfigKey(agentID))
+
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make(map[string]interface{})
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+// SyncConfigBetweenAgents syncs configuration from source agent to target agent, automatically handling format conversion.
+// The converted servers are validated before writing and the target is backed up; if the written file does not read back
+// with every converted server, it is rolled back. The result lists the servers written and those the target format could
+// not hold; a target that already holds the converted servers is left untouched (Written is false).
+func (as *AppService) SyncConfigBetweenAgents(sourceAgentID, targetAgentID string) (*models.AgentSyncResult, error) {
+	defer as.beginOperation("sync_agents")()
+
+	// Read config from source agent
+	sourceConfig, err := as.GetAgentMCPConfig(sourceAgentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source agent config: %w", err)
+	}
+	
+	// Debug: show initial read data
+	sourceKeyDebug := as.configLoader.GetConfigKey(sourceAgentID)
+	if serversData, ok := sourceConfig[sourceKeyDebug]; ok {
+		if serverMap, ok := serversData.(map[string]interface{}); ok {
+			println(fmt.Sprintf("[Debug] 初始读取到 %d 个服务器", len(serverMap)))
+			for name, serverInterface := range serverMap {
+				if sc, ok := serverInterface.(map[string]interface{}); ok {
+					println(fmt.Sprintf("  服务器 '%s': %d 个字段", name, len(sc)))
+					for key := range sc {
+						println(fmt.Sprintf("    - %s", key))
+					}
+				}
+			}
+		}
+	}
+
+	// Unwrap Windows-specific commands from source if it's already wrapped
+	if as.windowsSvc.IsWindows() {
+		println("  检测源配置中是否需要解包 Windows npx 命令")
+
+		// Extract servers data from source
+		sourceKey := as.configLoader.GetConfigKey(sourceAgentID)
+		serversData, ok := sourceConfig[sourceKey]
+		if !ok {
+			serversData = make(map[string]interface{})
+		}
+
+		// Convert to MCPServer slice, unwrap, and convert back
+		servers := ServersFromMap(serversData)
+		servers = as.windowsSvc.ApplyWindowsTransformation(servers, false)
+		serversData = ServersToMap(servers)
+
+		sourceConfig[sourceKey] = serversData
+		println("  源配置 Windows npx 命令解包完成")
+	}
+
+	// Get source and target agent definitions
+	sourceKey := as.configLoader.GetConfigKey(sourceAgentID)
+	sourceFormat := as.configLoader.GetFormat(sourceAgentID)
+	targetKey := as.configLoader.GetConfigKey(targetAgentID)
+	targetFormat := as.configLoader.GetFormat(targetAgentID)
+
+	// Extract servers data from source
+	serversData, ok := sourceConfig[sourceKey]
+	if !ok {
+		serversData = make(map[string]interface{})
+	}
+	sourceServers, _ := standardServersFrom(sourceConfig, sourceKey)
+
+	println(fmt.Sprintf("同步配置: %s (%s/%s) -> %s (%s/%s)",
+		sourceAgentID, sourceKey, sourceFormat,
+		targetAgentID, targetKey, targetFormat))
+
+	// Apply Windows-specific transformations if running on Windows
+	if as.windowsSvc.IsWindows() {
+		println("  检测到 Windows 系统，应用 npx 命令转换")
+
+		// Convert to MCPServer slice, apply transformation, and convert back
+		servers := ServersFromMap(serversData)
+		println(fmt.Sprintf("  [转换前] %d 个服务器", len(servers)))
+		for _, s := range servers {
+			println(fmt.Sprintf("    %s: command=%s, args=%d, env=%d", s.Name, s.Command, len(s.Args), len(s.Env)))
+		}
+		
+		servers = as.windowsSvc.ApplyWindowsTransformation(servers, true)
+		
+		println(fmt.Sprintf("  [转换后] %d 个服务器", len(servers)))
+		for _, s := range servers {
+			println(fmt.Sprintf("    %s: command=%s, args=%d, env=%d", s.Name, s.Command, len(s.Args), len(s.Env)))
+		}
+		
+		serversData = ServersToMap(servers)
+
+		println("  Windows npx 命令转换完成")
+	}
+
+	// Normalize format names (codex_toml is already converted to standard by GetAgentMCPConfig,
+	// and json5 only differs from standard in file syntax)
+	normalizedSourceFormat := sourceFormat
+	normalizedTargetFormat := targetFormat
+	if isTOMLFormat(normalizedSourceFormat) || hasStandardServers(normalizedSourceFormat) {
+		normalizedSourceFormat = "standard"
+	}
+	if isTOMLFormat(normalizedTargetFormat) || hasStandardServers(normalizedTargetFormat) {
+		normalizedTargetFormat = "standard"
+	}
+
+	// Convert format if needed
+	if normalizedSourceFormat != normalizedTargetFormat {
+		println(fmt.Sprintf("  转换格式: %s -> %s", normalizedSourceFormat, normalizedTargetFormat))
+
+		// Try to use the configuration-based transform rules first
+		if chain, ok := as.configLoader.GetTransformChain(normalizedSourceFormat, normalizedTargetFormat); ok {
+			for _, transformRule := range chain {
+				serversData = as.configLoader.ApplyTransformRule(serversData, transformRule)
+			}
+			println(fmt.Sprintf("  使用配置规则进行转换"))
+		} else {
+			// Fall back to hardcoded conversions
+			println(fmt.Sprintf("  未找到配置规则，使用内置转换"))
+			if normalizedSourceFormat == "standard" && normalizedTargetFormat == "zed" {
+				serversData = convertStandardToZed(serversData)
+			} else if normalizedSourceFormat == "zed" && normalizedTargetFormat == "standard" {
+				serversData = convertZedToStandard(serversData)
+			}
+		}
+	} else {
+		println("  格式相同,无需转换")
+	}
+
+	// Servers the target cannot hold, or that came out of the conversion invalid, are skipped; the rest are written
+	converted, _ := serversData.(map[string]interface{})
+	converted, result := planAgentSync(sourceServers, converted, targetKey, targetFormat)
+	if len(result.Skipped) > 0 || len(result.Dropped) > 0 {
+		println(fmt.Sprintf("  %s 无法完整表示: 跳过 %v, 丢失字段 %v", targetAgentID, result.Skipped, result.Dropped))
+	}
+
+	// Save to target agent with appropriate key name
+	targetConfig := map[string]interface{}{
+		targetKey: converted,
+	}
+	want, _ := standardServersFrom(targetConfig, targetKey)
+
+	// Compare in the standard structure so Zed's source/enabled fields and TOML typing don't count as changes
+	if current, err := as.GetAgentMCPConfig(targetAgentID); err == nil {
+		have, _ := standardServersFrom(current, targetKey)
+		if jsonEqual(want, have) {
+			println(fmt.Sprintf("  %s 已是最新，跳过写入", targetAgentID))
+			return result, nil
+		}
+	}
+
+	// A conversion that produced invalid servers is not written at all
+	if invalid := as.validateForPush(map[string]interface{}{targetAgentID: targetConfig}); len(invalid) > 0 {
+		return nil, fmt.Errorf("%w: converted config for %s: %s", ErrValidationFailed, targetAgentID, strings.Join(invalid[targetAgentID], "; "))
+	}
+
+	if err := as.writeAgentConfigVerified(targetAgentID, targetConfig, result.Converted); err != nil {
+		return nil, err
+	}
+	result.Written = true
+	return result, nil
+}
+
+
+// ConvertAgentConfig converts MCP config from one agent format to another
+func (as *AppService) ConvertAgentConfig(sourceAgentID, targetAgentID string, sourceConfig map[string]interface{}) (*ConversionResult, error) {
+	return as.converter.ConvertAgentConfig(sourceAgentID, targetAgentID, sourceConfig)
+}
+
+// ConvertToCodex converts any agent config to Codex format
+func (as *AppService) ConvertToCodex(sourceAgentID string, sourceConfig map[string]interface{}) (*ConversionResult, error) {
+	return as.converter.ConvertToCodex(sourceAgentID, sourceConfig)
+}
+
+// ConvertFromCodex converts Codex config to any agent format
+func (as *AppService) ConvertFromCodex(targetAgentID string, codexConfig map[string]interface{}) (*ConversionResult, error) {
+	return as.converter.ConvertFromCodex(targetAgentID, codexConfig)
+}
+
+// BatchConvertConfig converts config to multiple target formats
+func (as *AppService) BatchConvertConfig(sourceAgentID string, sourceConfig map[string]interface{}, targetAgentIDs []string) ([]*ConversionResult, error) {
+	return as.converter.BatchConvertConfig(sourceAgentID, sourceConfig, targetAgentIDs)
+}
+
+// ValidateConfigFormat validates if a config matches expected format
+func (as *AppService) ValidateConfigFormat(agentID string, config map[string]interface{}) (bool, []string) {
+	return as.converter.ValidateConfigFormat(agentID, config)
+}
+
+// ExportConversionAsJSON exports a conversion result as JSON string
+func (as *AppService) ExportConversionAsJSON(result *ConversionResult) (string, error) {
+	return as.converter.ExportConversionAsJSON(result)
+}
+
+// GetGistSecurityWarnings 获取 Gist 同步的安全警告
+func (as *AppService) GetGistSecurityWarnings() []map[string]string {
+	return []map[string]string{
+		{
+			"level":       "warning",
+			"title":       "Encryption Required",
+			"description": "All configurations are encrypted with your password before syncing to Gist.",
+			"suggestion":  "Use a strong password with at least 8 characters including uppercase, lowercase, numbers and special characters.",
+		},
+		{
+			"level":       "warning",
+			"title":       "Password Safety",
+			"description": "Your encryption password is critical for security. Never share it with anyone.",
+			"suggestion":  "Store your password securely and never commit it to version control.",
+		},
+	}
+}
+
+func nowTime() time.Time {
+	return time.Now().UTC()
+}
+
+func nowStr() string {
+	return time.Now().UTC().Format("20060102150405")
+}
+
+func genID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// computeHash 计算内容的 SHA256 hash
+func computeHash(content string) string {
+	hash := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(hash[:])
+}
+
+// getLatestLocalVersion 获取最新的本地配置版本
+func (as *AppService) getLatestLocalVersion() (*models.ConfigVersion, error) {
+	versions, err := as.storage.ListConfigVersions(1)
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+
+	// Versions saved before the semantic hash carry a hash of the raw content (or none), so compute it here
+	versions[0].Hash = canonicalConfigHash(versions[0].Content)
+	return &versions[0], nil
+}
+
+// Conflict policies for unattended sync
+const (
+	ConflictPolicyManual       = "manual"
+	ConflictPolicyPreferLocal  = "prefer_local"
+	ConflictPolicyPreferRemote = "prefer_remote"
+	ConflictPolicyPreferNewer  = "prefer_newer"
+)
+
+// SetConflictPolicy 设置自动同步遇到冲突时的默认处理方式
+func (as *AppService) SetConflictPolicy(policy string) error {
+	switch policy {
+	case ConflictPolicyManual, ConflictPolicyPreferLocal, ConflictPolicyPreferRemote, ConflictPolicyPreferNewer:
+	default:
+		return fmt.Errorf("unknown conflict policy: %s", policy)
+	}
+
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.ConflictPolicy = policy
+	config.LastUpdateTime = nowTime()
+	return as.storage.SaveSyncConfig(config)
+}
+
+// suggestResolution 按冲突策略给出预选的处理方式；manual 或无法判断时返回空
+func (as *AppService) suggestResolution(policy string, localAgents []string, remoteTime time.Time) string {
+	switch policy {
+	case ConflictPolicyPreferLocal:
+		return "keep_local"
+	case ConflictPolicyPreferRemote:
+		return "use_remote"
+	case ConflictPolicyPreferNewer:
+		if as.localModTime(localAgents).After(remoteTime) {
+			return "keep_local"
+		}
+		return "use_remote"
+	}
+	return ""
 }
 
-// SyncConfigBetweenAgents syncs configuration from source agent to target agent, automatically handling format conversion
-func (as *AppService) SyncConfigBetweenAgents(sourceAgentID, targetAgentID string) error {
-	// Read config from source agent
-	sourceConfig, err := as.GetAgentMCPConfig(sourceAgentID)
+// installedAgentIDs 返回当前同步范围内已检测到的 agent
+func (as *AppService) installedAgentIDs() []string {
+	agents, err := as.detector.DetectInstalledAgents()
 	if err != nil {
-		return fmt.Errorf("failed to read source agent config: %w", err)
+		return nil
 	}
-	
-	// Debug: show initial read data
-	sourceKeyDebug := as.configLoader.GetConfigKey(sourceAgentID)
-	if serversData, ok := sourceConfig[sourceKeyDebug]; ok {
-		if serverMap, ok := serversData.(map[string]interface{}); ok {
-			println(fmt.Sprintf("[Debug] 初始读取到 %d 个服务器", len(serverMap)))
-			for name, serverInterface := range serverMap {
-				if sc, ok := serverInterface.(map[string]interface{}); ok {
-					println(fmt.Sprintf("  服务器 '%s': %d 个字段", name, len(sc)))
-					for key := range sc {
-						println(fmt.Sprintf("    - %s", key))
-					}
+	scope := as.agentScope()
+	var ids []string
+	for _, agent := range agents {
+		if agent.Status == "detected" && (scope == nil || scope[agent.ID]) {
+			ids = append(ids, agent.ID)
+		}
+	}
+	return ids
+}
+
+// localModTime 返回这些 agent 配置文件中最新的修改时间
+func (as *AppService) localModTime(agentIDs []string) time.Time {
+	var latest time.Time
+	for _, agentID := range agentIDs {
+		path, err := as.detector.GetAgentConfigPath(agentID)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// RunAutoSync 执行一次无人值守的同步：只有一侧有改动时直接推送或拉取；两侧都有改动时按
+// ConflictPolicy 处理，manual 策略不做任何修改并返回冲突，交给用户选择。同步前先检查已保存的凭据，
+// token 失效或 Gist 不可用时直接返回错误，状态可以通过 GetCredentialStatus 读取。PauseSync 暂停期间不做任何事。
+func (as *AppService) RunAutoSync() (*models.AutoSyncResult, error) {
+	// A paused cycle does nothing at all; manual push and pull are not affected
+	if until := as.SyncPausedUntil(); !until.IsZero() {
+		return &models.AutoSyncResult{
+			Action:  "paused",
+			Message: fmt.Sprintf("Auto-sync is paused until %s", until.Format(time.RFC3339)),
+		}, nil
+	}
+
+	config, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+	policy := config.ConflictPolicy
+	if policy == "" {
+		policy = ConflictPolicyManual
+	}
+	result := &models.AutoSyncResult{Action: "none", Policy: policy}
+
+	// An expired token or a lost gist stops here instead of failing halfway through the sync;
+	// transient errors are left to the sync itself
+	if status, err := as.checkCredentials(gs); err != nil && !status.Transient {
+		return nil, fmt.Errorf("stored credentials are no longer valid: %w", err)
+	}
+
+	local, err := as.collectAgentConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if err := as.checkSchemas(sortedKeys(local)); err != nil {
+		return nil, err
+	}
+	remoteVersion, err := gs.GetLatestVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote version: %w", err)
+	}
+
+	localAgents := make([]string, 0, len(local))
+	for agentID := range local {
+		localAgents = append(localAgents, agentID)
+	}
+	sort.Strings(localAgents)
+
+	resolution := ""
+	switch {
+	case remoteVersion == nil:
+		resolution = "keep_local"
+	default:
+		var payload struct {
+			Agents map[string]interface{} `json:"agents"`
+		}
+		if err := json.Unmarshal([]byte(remoteVersion.Content), &payload); err != nil {
+			return nil, fmt.Errorf("failed to parse remote version: %w", err)
+		}
+		var base map[string]interface{}
+		if mergeBase, err := as.GetMergeBase(); err == nil && mergeBase != nil {
+			json.Unmarshal([]byte(mergeBase.Content), &base)
+		}
+
+		localChanged := !sameAgentConfigs(local, base, localAgents)
+		remoteChanged := !sameAgentConfigs(payload.Agents, base, localAgents)
+		switch {
+		case sameAgentConfigs(local, payload.Agents, localAgents):
+			result.Message = "Local and remote configurations are already in sync"
+		case base != nil && !remoteChanged:
+			resolution = "keep_local"
+		case base != nil && !localChanged:
+			resolution = "use_remote"
+		default:
+			resolution = as.suggestResolution(policy, localAgents, remoteVersion.Timestamp)
+			if resolution == "" {
+				as.recordConflictMetrics()
+				result.Action = "conflict"
+				result.Conflict = &models.SyncConflict{
+					HasConflict:   true,
+					ConflictType:  "push_conflict",
+					RemoteVersion: remoteVersion,
+					Message:       "Local and cloud configurations both changed. Choose to keep local, use remote, or merge.",
 				}
+				result.Message = "Both sides changed; waiting for a manual choice"
 			}
 		}
 	}
 
-	// Unwrap Windows-specific commands from source if it's already wrapped
-	if as.windowsSvc.IsWindows() {
-		println("  检测源配置中是否需要解包 Windows npx 命令")
+	switch resolution {
+	case "keep_local":
+		if err := as.PushAllAgentsToGist(); err != nil {
+			return nil, err
+		}
+		result.Action = "push"
+		result.Message = fmt.Sprintf("Pushed local configuration (%d agents)", len(localAgents))
+	case "use_remote":
+		if _, err := as.PullFromGist(); err != nil {
+			return nil, err
+		}
+		result.Action = "pull"
+		result.Message = "Applied remote configuration"
+	}
 
-		// Extract servers data from source
-		sourceKey := as.configLoader.GetConfigKey(sourceAgentID)
-		serversData, ok := sourceConfig[sourceKey]
-		if !ok {
-			serversData = make(map[string]interface{})
+	status := "success"
+	if result.Action == "conflict" {
+		status = "conflict"
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "auto_sync",
+		Status:    status,
+		Message:   result.Message,
+		Details:   "policy=" + policy,
+	})
+
+	return result, nil
+}
+
+// sameAgentConfigs 比较两份配置中指定 agent 的内容是否一致（按 JSON 语义比较）
+func sameAgentConfigs(a, b map[string]interface{}, agentIDs []string) bool {
+	for _, agentID := range agentIDs {
+		if !jsonEqual(a[agentID], b[agentID]) {
+			return false
 		}
+	}
+	return true
+}
 
-		// Convert to MCPServer slice, unwrap, and convert back
-		servers := as.convertServersDataToMCPServers(serversData)
-		servers = as.windowsSvc.ApplyWindowsTransformation(servers, false)
-		serversData = as.convertMCPServersToServersData(servers)
+// jsonEqual 按 JSON 语义比较两个值，忽略 []string 与 []interface{} 等 Go 类型差异
+func jsonEqual(a, b interface{}) bool {
+	left, _ := json.Marshal(a)
+	right, _ := json.Marshal(b)
+	var l, r interface{}
+	json.Unmarshal(left, &l)
+	json.Unmarshal(right, &r)
+	return reflect.DeepEqual(l, r)
+}
 
-		sourceConfig[sourceKey] = serversData
-		println("  源配置 Windows npx 命令解包完成")
+// DetectPushConflict 检测推送冲突 - 比较本地和云端版本
+func (as *AppService) DetectPushConflict() (*models.SyncConflict, error) {
+	// Load sync config and initialize gist sync if needed
+	config, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
 	}
+	// Detection only reads the remote
+	gs = gs.ReadOnly()
 
-	// Get source and target agent definitions
-	sourceKey := as.configLoader.GetConfigKey(sourceAgentID)
-	sourceFormat := as.configLoader.GetFormat(sourceAgentID)
-	targetKey := as.configLoader.GetConfigKey(targetAgentID)
-	targetFormat := as.configLoader.GetFormat(targetAgentID)
+	// Get local version
+	localVersion, err := as.getLatestLocalVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local version: %w", err)
+	}
 
-	// Extract servers data from source
-	serversData, ok := sourceConfig[sourceKey]
-	if !ok {
-		serversData = make(map[string]interface{})
+	if localVersion == nil {
+		return &models.SyncConflict{HasConflict: false}, nil
 	}
 
-	println(fmt.Sprintf("同步配置: %s (%s/%s) -> %s (%s/%s)",
-		sourceAgentID, sourceKey, sourceFormat,
-		targetAgentID, targetKey, targetFormat))
+	// Get remote version from Gist (nil when the gist holds no agent configs yet)
+	remoteVersion, err := gs.GetLatestVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote version: %w", err)
+	}
 
-	// Apply Windows-specific transformations if running on Windows
-	if as.windowsSvc.IsWindows() {
-		println("  检测到 Windows 系统，应用 npx 命令转换")
+	// Compare hashes
+	if remoteVersion != nil && localVersion.Hash != remoteVersion.Hash {
+		as.recordConflictMetrics()
+		return &models.SyncConflict{
+			HasConflict:         true,
+			ConflictType:        "push_conflict",
+			LocalVersion:        localVersion,
+			RemoteVersion:       remoteVersion,
+			Message:             "Local configuration differs from cloud version. Choose to keep local, use remote, or merge.",
+			SuggestedResolution: as.suggestResolution(config.ConflictPolicy, as.installedAgentIDs(), remoteVersion.Timestamp),
+		}, nil
+	}
 
-		// Convert to MCPServer slice, apply transformation, and convert back
-		servers := as.convertServersDataToMCPServers(serversData)
-		println(fmt.Sprintf("  [转换前] %d 个服务器", len(servers)))
-		for _, s := range servers {
-			println(fmt.Sprintf("    %s: command=%s, args=%d, env=%d", s.Name, s.Command, len(s.Args), len(s.Env)))
+	return &models.SyncConflict{HasConflict: false}, nil
+}
+
+// DetectPullConflict 检测拉取冲突 - 检查本地是否有未推送的改动
+func (as *AppService) DetectPullConflict() (*models.SyncConflict, error) {
+	// Load sync config and initialize gist sync if needed
+	config, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+	// Detection only reads the remote
+	gs = gs.ReadOnly()
+
+	// Get local version
+	localVersion, err := as.getLatestLocalVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local version: %w", err)
+	}
+
+	// Get remote version
+	remoteVersion, err := gs.GetLatestVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote version: %w", err)
+	}
+
+	if remoteVersion == nil {
+		return &models.SyncConflict{HasConflict: false}, nil
+	}
+
+	// Compare hashes - if local is newer than remote, there's unsaved local changes
+	if localVersion != nil && localVersion.Timestamp.After(remoteVersion.Timestamp) && localVersion.Hash != remoteVersion.Hash {
+		as.recordConflictMetrics()
+		return &models.SyncConflict{
+			HasConflict:         true,
+			ConflictType:        "pull_conflict",
+			LocalVersion:        localVersion,
+			RemoteVersion:       remoteVersion,
+			Message:             "You have local changes not yet pushed to cloud. Choose to keep local, use remote, or merge.",
+			SuggestedResolution: as.suggestResolution(config.ConflictPolicy, as.installedAgentIDs(), remoteVersion.Timestamp),
+		}, nil
+	}
+
+	return &models.SyncConflict{HasConflict: false}, nil
+}
+
+// ResolveConflict 解决冲突 - 根据用户选择，返回采用的策略、各方保留和合并的服务器数量以及解决后的版本
+func (as *AppService) ResolveConflict(conflictType string, resolution string) (*models.ResolutionResult, error) {
+	defer as.beginOperation("resolve_conflict")()
+
+	// resolution: "keep_local", "use_remote", "merge"
+	var result *models.ResolutionResult
+	switch resolution {
+	case "keep_local", "use_remote":
+		// Read both sides first so the result can say which servers actually differed
+		_, gs, err := as.prepareGistSync()
+		if err != nil {
+			return nil, err
 		}
-		
-		servers = as.windowsSvc.ApplyWindowsTransformation(servers, true)
-		
-		println(fmt.Sprintf("  [转换后] %d 个服务器", len(servers)))
-		for _, s := range servers {
-			println(fmt.Sprintf("    %s: command=%s, args=%d, env=%d", s.Name, s.Command, len(s.Args), len(s.Env)))
+		local, err := as.collectAgentConfigs()
+		if err != nil {
+			return nil, err
 		}
-		
-		serversData = as.convertMCPServersToServersData(servers)
+		remote, err := gs.PullAgentConfigsFromGist()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote configs: %w", err)
+		}
+
+		if resolution == "keep_local" {
+			// Just push local to remote
+			if err := as.PushAllAgentsToGist(); err != nil {
+				return nil, err
+			}
+			result = as.resolutionCounts(local, remote, local)
+			result.Pushed = true
+		} else {
+			// Just pull remote to local
+			if _, err := as.PullFromGist(); err != nil {
+				return nil, err
+			}
+			result = as.resolutionCounts(local, remote, remote)
+			result.Pulled = true
+		}
+
+	case "merge":
+		var err error
+		if result, err = as.mergeWithRemote(); err != nil {
+			return nil, err
+		}
+		result.Pushed, result.Pulled = true, true
+
+	default:
+		return nil, fmt.Errorf("unknown resolution type: %s", resolution)
+	}
+
+	result.Strategy = resolution
+	if versions, err := as.storage.ListConfigVersions(1); err == nil && len(versions) > 0 {
+		result.VersionID = versions[0].ID
+	}
+	return result, nil
+}
+
+// resolutionCounts 按服务器比较解决前的本地和远程配置与结果 resolved，只统计 local 中的（本机安装的）agent
+func (as *AppService) resolutionCounts(local, remote, resolved map[string]interface{}) *models.ResolutionResult {
+	result := &models.ResolutionResult{}
+	servers := func(configs map[string]interface{}, agentID string) map[string]interface{} {
+		config, _ := configs[agentID].(map[string]interface{})
+		found, _ := standardServersFrom(config, as.configLoader.GetConfigKey(agentID))
+		return found
+	}
+
+	for agentID := range local {
+		localServers, remoteServers := servers(local, agentID), servers(remote, agentID)
+		for name, server := range servers(resolved, agentID) {
+			localServer, inLocal := localServers[name]
+			remoteServer, inRemote := remoteServers[name]
+			switch {
+			case inLocal && inRemote && jsonEqual(localServer, remoteServer):
+				result.Unchanged++
+			case inLocal && jsonEqual(server, localServer):
+				result.KeptLocal++
+			case inRemote && jsonEqual(server, remoteServer):
+				result.KeptRemote++
+			default:
+				result.Merged++
+			}
+		}
+	}
+	return result
+}
+
+// mergeWithRemote 以历史中的共同祖先做三方合并，合并结果写回本地并推送到 Gist。
+// 有无法自动合并的服务器时不写入任何配置并返回错误；开启 ConflictFiles 时同时写出冲突文件供手动解决
+func (as *AppService) mergeWithRemote() (*models.ResolutionResult, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	syncConfig, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := as.loadMergeInputs(gs)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, conflicts := mergeAgentConfigs(inputs.ancestor, inputs.local, inputs.remote)
+	if len(conflicts) > 0 {
+		err := fmt.Errorf("%w: merge conflicts in %s; choose keep_local or use_remote", ErrConflict, strings.Join(conflicts, ", "))
+		if syncConfig.ConflictFiles {
+			operationID, path, writeErr := as.writeConflictFile(inputs, conflicts)
+			if writeErr != nil {
+				println(fmt.Sprintf("Warning: failed to write conflict file: %v", writeErr))
+			} else {
+				err = fmt.Errorf("%w: merge conflicts in %s; resolve them in %s and apply operation %s", ErrConflict, strings.Join(conflicts, ", "), path, operationID)
+			}
+		}
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "merge",
+			Status:    "failed",
+			Message:   err.Error(),
+		})
+		return nil, err
+	}
+
+	if err := as.applyMergedConfigs(gs, merged, inputs.installed, "Merged local and remote configs"); err != nil {
+		return nil, err
+	}
+
+	installedLocal := make(map[string]interface{}, len(inputs.installed))
+	for agentID := range inputs.installed {
+		installedLocal[agentID] = inputs.local[agentID]
+	}
+	return as.resolutionCounts(installedLocal, inputs.remote, merged), nil
+}
+
+// mergeInputs 是三方合并的输入
+type mergeInputs struct {
+	local, remote, ancestor map[string]interface{}
+	// installed 是本机安装的 agent；其余 agent 在 local 中沿用远程配置
+	installed map[string]bool
+}
+
+// loadMergeInputs 读取本地配置、Gist 中的配置和合并基准
+func (as *AppService) loadMergeInputs(gs *GistSyncService) (*mergeInputs, error) {
+	local, err := as.collectAgentConfigs()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote configs: %w", err)
+	}
+
+	// Agents not installed here are carried over unchanged rather than treated as deleted
+	installed := make(map[string]bool)
+	for agentID := range local {
+		installed[agentID] = true
+	}
+	for agentID, config := range remote {
+		if !installed[agentID] {
+			local[agentID] = config
+		}
+	}
+
+	var ancestor map[string]interface{}
+	if base, err := as.GetMergeBase(); err != nil {
+		println(fmt.Sprintf("Warning: merge base unavailable, falling back to whole-server merge: %v", err))
+	} else if base != nil {
+		if err := json.Unmarshal([]byte(base.Content), &ancestor); err != nil {
+			println(fmt.Sprintf("Warning: merge base unreadable, falling back to whole-server merge: %v", err))
+			ancestor = nil
+		}
+	}
+
+	return &mergeInputs{local: local, remote: remote, ancestor: ancestor, installed: installed}, nil
+}
+
+// applyMergedConfigs 把合并结果写入本机安装的 agent 并推送到 Gist，然后更新合并基准和历史
+func (as *AppService) applyMergedConfigs(gs *GistSyncService, merged map[string]interface{}, installed map[string]bool, note string) error {
+	for agentID := range installed {
+		agentConfig, ok := merged[agentID].(map[string]interface{})
+		if !ok {
+			agentConfig = map[string]interface{}{as.configLoader.GetConfigKey(agentID): map[string]interface{}{}}
+		}
+		if err := as.applyRemoteAgentConfig(agentID, agentConfig); err != nil {
+			return fmt.Errorf("failed to apply merged config to %s: %w", agentID, err)
+		}
+	}
+
+	if err := gs.PushAgentConfigsToGist(merged); err != nil {
+		return fmt.Errorf("merged locally but failed to push: %w", err)
+	}
+
+	as.updateMergeBase(merged, "merge")
+
+	configContent, _ := json.MarshalIndent(merged, "", "  ")
+	as.storage.SaveConfigVersion(models.ConfigVersion{
+		ID:        "local_" + nowStr(),
+		Timestamp: nowTime(),
+		Content:   string(configContent),
+		Source:    "local",
+		Note:      note,
+	})
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "merge",
+		Status:    "success",
+		Message:   fmt.Sprintf("Merged configurations for %d agents", len(merged)),
+	})
 
-		println("  Windows npx 命令转换完成")
+	return nil
+}
+
+// ImportServersFromJSON 导入用户粘贴的 mcpServers/context_servers 片段到目标 agent。
+// 每个目标单独转换格式、校验、备份后写入，结果按 agent 返回；同名服务器在 overwrite 为 false 时报错而不覆盖。
+func (as *AppService) ImportServersFromJSON(data []byte, targetAgentIDs []string, overwrite bool) (map[string]error, error) {
+	defer as.beginOperation("import")()
+
+	config, _ := as.GetSyncConfig()
+	limits := effectiveLimits(config)
+	if err := checkPayloadLimits(limits, data); err != nil {
+		return nil, err
 	}
 
-	// Normalize format names (codex_toml is already converted to standard by GetAgentMCPConfig)
-	normalizedSourceFormat := sourceFormat
-	normalizedTargetFormat := targetFormat
-	if normalizedSourceFormat == "codex_toml" {
-		normalizedSourceFormat = "standard"
+	imported, format, err := as.converter.ParseServers(data)
+	if err != nil {
+		return nil, err
 	}
-	if normalizedTargetFormat == "codex_toml" {
-		normalizedTargetFormat = "standard"
+	if len(imported) == 0 {
+		return nil, fmt.Errorf("no servers found in %s config", format)
+	}
+	if err := checkServerLimit(limits, len(imported)); err != nil {
+		return nil, err
 	}
 
-	// Convert format if needed
-	if normalizedSourceFormat != normalizedTargetFormat {
-		println(fmt.Sprintf("  转换格式: %s -> %s", normalizedSourceFormat, normalizedTargetFormat))
+	results := make(map[string]error)
+	for _, agentID := range targetAgentIDs {
+		results[agentID] = as.importServersToAgent(agentID, imported, overwrite)
+	}
 
-		// Try to use the configuration-based transform rule first
-		transformRule := as.configLoader.GetTransformRule(normalizedSourceFormat, normalizedTargetFormat)
-		if transformRule != nil {
-			serversData = as.configLoader.ApplyTransformRule(serversData, transformRule)
-			println(fmt.Sprintf("  使用配置规则进行转换"))
+	succeeded := 0
+	var failed []string
+	for _, agentID := range targetAgentIDs {
+		if err := results[agentID]; err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", agentID, err))
 		} else {
-			// Fall back to hardcoded conversions
-			println(fmt.Sprintf("  未找到配置规则，使用内置转换"))
-			if normalizedSourceFormat == "standard" && normalizedTargetFormat == "zed" {
-				serversData = convertStandardToZed(serversData)
-			} else if normalizedSourceFormat == "zed" && normalizedTargetFormat == "standard" {
-				serversData = convertZedToStandard(serversData)
-			}
+			succeeded++
 		}
-	} else {
-		println("  格式相同,无需转换")
 	}
-
-	// Save to target agent with appropriate key name
-	targetConfig := map[string]interface{}{
-		targetKey: serversData,
+	status := "success"
+	switch {
+	case len(failed) > 0 && succeeded == 0:
+		status = "failed"
+	case len(failed) > 0:
+		status = "partial"
 	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "import",
+		Status:    status,
+		Message:   fmt.Sprintf("Imported %d servers (%s) into %d of %d agents", len(imported), format, succeeded, len(targetAgentIDs)),
+		Details:   strings.Join(failed, "; "),
+	})
 
-	return as.SaveAgentMCPConfig(targetAgentID, targetConfig)
+	return results, nil
 }
 
-// Helper methods for Windows transformations
-func (as *AppService) convertServersDataToMCPServers(serversData interface{}) []models.MCPServer {
-	var servers []models.MCPServer
-	if serverMap, ok := serversData.(map[string]interface{}); ok {
-		for serverName, serverConfig := range serverMap {
-			server := models.MCPServer{
-				ID:   serverName,
-				Name: serverName,
-			}
-			if serverMap, ok := serverConfig.(map[string]interface{}); ok {
-				if cmd, ok := serverMap["command"].(string); ok {
-					server.Command = cmd
-				}
-				
-				// Handle args - support both []interface{} and []string
-				if argsInterface, ok := serverMap["args"]; ok {
-					switch args := argsInterface.(type) {
-					case []interface{}:
-						for _, arg := range args {
-							if argStr, ok := arg.(string); ok {
-								server.Args = append(server.Args, argStr)
-							}
-						}
-					case []string:
-						server.Args = args
-					}
-				}
-				
-				// Handle env - support both map[string]interface{} and map[string]string
-				if envInterface, ok := serverMap["env"]; ok {
-					switch env := envInterface.(type) {
-					case map[string]interface{}:
-						server.Env = make(map[string]string)
-						for k, v := range env {
-							if strVal, ok := v.(string); ok {
-								server.Env[k] = strVal
-							}
-						}
-					case map[string]string:
-						server.Env = env
-					}
-				}
-			}
-			servers = append(servers, server)
-		}
+// importServersToAgent 将标准格式的服务器写入单个 agent 的配置
+func (as *AppService) importServersToAgent(agentID string, imported map[string]interface{}, overwrite bool) error {
+	if as.configLoader.GetAgentDefinition(agentID) == nil {
+		return fmt.Errorf("unknown agent: %s", agentID)
 	}
-	return servers
-}
 
-func (as *AppService) convertMCPServersToServersData(servers []models.MCPServer) map[string]interface{} {
-	serversData := make(map[string]interface{})
-	for _, server := range servers {
-		serverConfig := make(map[string]interface{})
-		serverConfig["command"] = server.Command
-		if len(server.Args) > 0 {
-			argsInterface := make([]interface{}, len(server.Args))
-			for i, arg := range server.Args {
-				argsInterface[i] = arg
-			}
-			serverConfig["args"] = argsInterface
-		}
-		if len(server.Env) > 0 {
-			envInterface := make(map[string]interface{})
-			for k, v := range server.Env {
-				envInterface[k] = v
-			}
-			serverConfig["env"] = envInterface
-		}
-		serversData[server.Name] = serverConfig
+	current, err := as.GetAgentMCPConfig(agentID)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
 	}
-	return serversData
-}
+	keyName := as.configLoader.GetConfigKey(agentID)
+	existing := agentServers(current, keyName)
 
-// ConvertAgentConfig converts MCP config from one agent format to another
-func (as *AppService) ConvertAgentConfig(sourceAgentID, targetAgentID string, sourceConfig map[string]interface{}) (*ConversionResult, error) {
-	return as.converter.ConvertAgentConfig(sourceAgentID, targetAgentID, sourceConfig)
-}
+	var duplicates []string
+	for name := range imported {
+		if _, exists := existing[name]; exists {
+			duplicates = append(duplicates, name)
+		}
+	}
+	if len(duplicates) > 0 && !overwrite {
+		sort.Strings(duplicates)
+		return fmt.Errorf("servers already exist: %s", strings.Join(duplicates, ", "))
+	}
 
-// ConvertToCodex converts any agent config to Codex format
-func (as *AppService) ConvertToCodex(sourceAgentID string, sourceConfig map[string]interface{}) (*ConversionResult, error) {
-	return as.converter.ConvertToCodex(sourceAgentID, sourceConfig)
-}
+	converted := imported
+	if as.configLoader.GetFormat(agentID) == "zed" {
+		converted, _ = convertStandardToZed(imported).(map[string]interface{})
+	}
+	if valid, problems := as.converter.ValidateConfigFormat(agentID, converted); !valid {
+		return fmt.Errorf("invalid servers for %s: %s", agentID, strings.Join(problems, "; "))
+	}
 
-// ConvertFromCodex converts Codex config to any agent format
-func (as *AppService) ConvertFromCodex(targetAgentID string, codexConfig map[string]interface{}) (*ConversionResult, error) {
-	return as.converter.ConvertFromCodex(targetAgentID, codexConfig)
-}
+	merged := make(map[string]interface{}, len(existing)+len(converted))
+	for name, server := range existing {
+		merged[name] = server
+	}
+	for name, server := range converted {
+		merged[name] = server
+	}
 
-// BatchConvertConfig converts config to multiple target formats
-func (as *AppService) BatchConvertConfig(sourceAgentID string, sourceConfig map[string]interface{}, targetAgentIDs []string) ([]*ConversionResult, error) {
-	return as.converter.BatchConvertConfig(sourceAgentID, sourceConfig, targetAgentIDs)
+	if _, err := as.backupAgentConfig(agentID); err != nil {
+		return fmt.Errorf("failed to back up config: %w", err)
+	}
+	return as.SaveAgentMCPConfig(agentID, map[string]interface{}{keyName: merged})
 }
 
-// ValidateConfigFormat validates if a config matches expected format
-func (as *AppService) ValidateConfigFormat(agentID string, config map[string]interface{}) (bool, []string) {
-	return as.converter.ValidateConfigFormat(agentID, config)
+// GetMergeBase 返回最近一次成功推送/拉取时的配置快照（三方合并的共同祖先）；从未同步过时返回 nil
+func (as *AppService) GetMergeBase() (*models.ConfigVersion, error) {
+	return as.storage.LoadMergeBase()
 }
 
-// ExportConversionAsJSON exports a conversion result as JSON string
-func (as *AppService) ExportConversionAsJSON(result *ConversionResult) (string, error) {
-	return as.converter.ExportConversionAsJSON(result)
-}
+// GetVersionContent 返回本地版本解密后的内容，用于排查同步问题。versionID 可以是版本 ID 或 versions 目录中的文件名，
+// merge_base 表示合并基准
+func (as *AppService) GetVersionContent(versionID string) (string, error) {
+	if versionID == "merge_base" {
+		base, err := as.storage.LoadMergeBase()
+		if err != nil {
+			return "", fmt.Errorf("failed to read merge base: %w", err)
+		}
+		if base == nil {
+			return "", fmt.Errorf("merge base: %w", ErrNotFound)
+		}
+		return base.Content, nil
+	}
 
-// GetGistSecurityWarnings 获取 Gist 同步的安全警告
-func (as *AppService) GetGistSecurityWarnings() []map[string]string {
-	return []map[string]string{
-		{
-			"level":       "warning",
-			"title":       "Encryption Required",
-			"description": "All configurations are encrypted with your password before syncing to Gist.",
-			"suggestion":  "Use a strong password with at least 8 characters including uppercase, lowercase, numbers and special characters.",
-		},
-		{
-			"level":       "warning",
-			"title":       "Password Safety",
-			"description": "Your encryption password is critical for security. Never share it with anyone.",
-			"suggestion":  "Store your password securely and never commit it to version control.",
-		},
+	version, err := as.storage.GetConfigVersion(versionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read version %s: %w", versionID, err)
 	}
+	return version.Content, nil
 }
 
-func nowTime() time.Time {
-	return time.Now().UTC()
-}
+// DeleteVersion 删除一个本地版本（按 ID 或文件名），例如无法解密的损坏文件。合并基准不能删除，它会在下次同步成功后被替换
+func (as *AppService) DeleteVersion(versionID string) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
 
-func nowStr() string {
-	return time.Now().UTC().Format("20060102150405")
+	if versionID == "merge_base" {
+		return fmt.Errorf("the merge base cannot be deleted; it is replaced by the next successful sync")
+	}
+	if err := as.storage.DeleteConfigVersion(versionID); err != nil {
+		return fmt.Errorf("failed to delete version %s: %w", versionID, err)
+	}
+	return nil
 }
 
-func genID() string {
-	b := make([]byte, 8)
-	rand.Read(b)
-	return fmt.Sprintf("%x", b)
+// updateMergeBase 在同步成功后记录合并基准
+func (as *AppService) updateMergeBase(agents map[string]interface{}, source string) {
+	content, err := json.MarshalIndent(agents, "", "  ")
+	if err != nil {
+		println(fmt.Sprintf("Warning: failed to encode merge base: %v", err))
+		return
+	}
+	hash := sha256.Sum256(content)
+	base := models.ConfigVersion{
+		ID:        "merge_base",
+		Timestamp: nowTime(),
+		Content:   string(content),
+		Source:    source,
+		Note:      "Content as of the last successful sync",
+		Hash:      hex.EncodeToString(hash[:]),
+	}
+	if err := as.storage.SaveMergeBase(base); err != nil {
+		println(fmt.Sprintf("Warning: failed to save merge base: %v", err))
+	}
 }
 
-// computeHash 计算内容的 SHA256 hash
-func computeHash(content string) string {
-	hash := sha256.Sum256([]byte(content))
-	return hex.EncodeToString(hash[:])
+// updateMergeBaseAgents 只替换合并基准中 agents 里这些 agent 的条目，其他 agent 保持上一次同步时的内容
+func (as *AppService) updateMergeBaseAgents(agents map[string]interface{}, source string) {
+	base := as.mergeBaseAgents()
+	for agentID, config := range agents {
+		base[agentID] = config
+	}
+	as.updateMergeBase(base, source)
 }
 
-// getLatestLocalVersion 获取最新的本地配置版本
-func (as *AppService) getLatestLocalVersion() (*models.ConfigVersion, error) {
-	versions, err := as.storage.ListConfigVersions(1)
-	if err != nil || len(versions) == 0 {
-		return nil, err
+// updateMergeBaseTag 把合并基准中每个 agent 带 tag 的服务器替换为 tagged 中的服务器，
+// 其他服务器和设置保持上一次同步时的内容；tagged 为空时不修改合并基准
+func (as *AppService) updateMergeBaseTag(tagged map[string]map[string]interface{}, tag, source string) {
+	if len(tagged) == 0 {
+		return
 	}
-
-	// 计算最新版本的 hash
-	versions[0].Hash = computeHash(versions[0].Content)
-	return &versions[0], nil
+	base := as.mergeBaseAgents()
+	for agentID, servers := range tagged {
+		existing, _ := base[agentID].(map[string]interface{})
+		if existing == nil && len(servers) == 0 {
+			continue
+		}
+		keyName := as.configLoader.GetConfigKey(agentID)
+		merged := filterServersByTag(agentServers(existing, keyName), tag, false)
+		for name, server := range servers {
+			merged[name] = server
+		}
+		config := make(map[string]interface{}, len(existing)+1)
+		for key, value := range existing {
+			config[key] = value
+		}
+		config[keyName] = merged
+		base[agentID] = config
+	}
+	as.updateMergeBase(base, source)
 }
 
-// DetectPushConflict 检测推送冲突 - 比较本地和云端版本
-func (as *AppService) DetectPushConflict() (*models.SyncConflict, error) {
-	// Load sync config
-	config, err := as.storage.LoadSyncConfig()
+// ForcePush 强制推送 - 跳过冲突检测，直接用本地配置覆盖云端
+// The remote version being overwritten is backed up as a config version and noted in the sync log.
+func (as *AppService) ForcePush() error {
+	_, gs, err := as.prepareGistSync()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if config.GitHubToken == "" || config.GistID == "" {
-		return nil, fmt.Errorf("GitHub token or Gist ID not configured")
+	if !gs.IsEncryptionEnabled() {
+		return fmt.Errorf("%w. Please set an encryption password", ErrEncryptionRequired)
 	}
 
-	// Initialize gist sync if needed
-	if as.gistSync == nil {
-		as.gistSync = NewGistSyncService(config.GitHubToken, config.GistID)
-		if config.EnableEncryption {
-			password := config.GistEncryptionPassword
-			// 如果新字段为空但旧字段有值，使用旧字段（迁移场景）
-			if password == "" && config.EncryptionPassword != "" {
-				password = config.EncryptionPassword
-			}
-			as.gistSync.SetEncryption(config.EnableEncryption, password)
+	// Back up the remote version before overwriting it
+	overwrittenHash := ""
+	backupID := ""
+	if remoteVersion, err := gs.FetchLatestVersion(false); err == nil && remoteVersion != nil {
+		overwrittenHash = remoteVersion.Hash
+		backupID = "backup_remote_" + nowStr()
+		as.storage.SaveConfigVersion(models.ConfigVersion{
+			ID:        backupID,
+			Timestamp: nowTime(),
+			Content:   remoteVersion.Content,
+			Source:    "gist",
+			Note:      "Backup of remote configuration before force push",
+			Hash:      remoteVersion.Hash,
+		})
+		// Content identical to the newest local version is not stored twice
+		if stored, err := as.storage.FindConfigVersionByHash(remoteVersion.Hash); err == nil {
+			backupID = stored.ID
 		}
+	} else if err != nil {
+		println(fmt.Sprintf("Warning: could not back up remote version before force push: %v", err))
 	}
 
-	// Get local version
-	localVersion, err := as.getLatestLocalVersion()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get local version: %w", err)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		return err
 	}
 
-	if localVersion == nil {
-		return &models.SyncConflict{HasConflict: false}, nil
-	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "force_push",
+		Status:    "success",
+		Message:   fmt.Sprintf("Forced push overwrote remote version %s without conflict check", shortHash(overwrittenHash)),
+		Details:   fmt.Sprintf("overwritten_hash=%s backup_version=%s", overwrittenHash, backupID),
+	})
 
-	// Get remote version from Gist
-	remoteVersion, err := as.gistSync.GetLatestVersion()
+	return nil
+}
+
+// ForcePull 强制拉取 - 跳过冲突检测，直接用云端配置覆盖本地
+// Local agent files are backed up before being overwritten and the override is noted in the sync log.
+func (as *AppService) ForcePull() ([]models.MCPServer, error) {
+	defer as.beginOperation("force_pull")()
+
+	_, gs, err := as.prepareGistSync()
 	if err != nil {
-		// If Gist is empty, no conflict
-		return &models.SyncConflict{HasConflict: false}, nil
+		return nil, err
 	}
 
-	// Compare hashes
-	if remoteVersion != nil && localVersion.Hash != remoteVersion.Hash {
-		return &models.SyncConflict{
-			HasConflict:   true,
-			ConflictType:  "push_conflict",
-			LocalVersion:  localVersion,
-			RemoteVersion: remoteVersion,
-			Message:       "Local configuration differs from cloud version. Choose to keep local, use remote, or merge.",
-		}, nil
+	if !gs.IsEncryptionEnabled() {
+		return nil, fmt.Errorf("%w. Please set an encryption password", ErrEncryptionRequired)
 	}
 
-	return &models.SyncConflict{HasConflict: false}, nil
-}
-
-// DetectPullConflict 检测拉取冲突 - 检查本地是否有未推送的改动
-func (as *AppService) DetectPullConflict() (*models.SyncConflict, error) {
-	// Load sync config
-	config, err := as.storage.LoadSyncConfig()
+	// Back up the local state before overwriting it
+	localConfigs, err := as.collectAgentConfigs()
 	if err != nil {
 		return nil, err
 	}
-
-	if config.GitHubToken == "" || config.GistID == "" {
-		return nil, fmt.Errorf("GitHub token or Gist ID not configured")
+	for agentID := range localConfigs {
+		if _, err := as.backupAgentConfig(agentID); err != nil {
+			return nil, fmt.Errorf("failed to back up %s before force pull: %w", agentID, err)
+		}
 	}
 
-	// Initialize gist sync if needed
-	if as.gistSync == nil {
-		as.gistSync = NewGistSyncService(config.GitHubToken, config.GistID)
-		if config.EnableEncryption {
-			password := config.GistEncryptionPassword
-			// 如果新字段为空但旧字段有值，使用旧字段（迁移场景）
-			if password == "" && config.EncryptionPassword != "" {
-				password = config.EncryptionPassword
-			}
-			as.gistSync.SetEncryption(config.EnableEncryption, password)
-		}
+	localContent, _ := json.MarshalIndent(localConfigs, "", "  ")
+	overwrittenHash := canonicalConfigHash(string(localContent))
+	backupID := "backup_local_" + nowStr()
+	as.storage.SaveConfigVersion(models.ConfigVersion{
+		ID:        backupID,
+		Timestamp: nowTime(),
+		Content:   string(localContent),
+		Source:    "local",
+		Note:      "Backup of local configuration before force pull",
+		Hash:      overwrittenHash,
+	})
+	// Content identical to the newest local version is not stored twice
+	if stored, err := as.storage.FindConfigVersionByHash(overwrittenHash); err == nil {
+		backupID = stored.ID
 	}
 
-	// Get local version
-	localVersion, err := as.getLatestLocalVersion()
+	servers, err := as.PullFromGist()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get local version: %w", err)
+		return nil, err
 	}
 
-	// Get remote version
-	remoteVersion, err := as.gistSync.GetLatestVersion()
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "force_pull",
+		Status:    "success",
+		Message:   fmt.Sprintf("Forced pull overwrote local version %s without conflict check", shortHash(overwrittenHash)),
+		Details:   fmt.Sprintf("overwritten_hash=%s backup_version=%s", overwrittenHash, backupID),
+	})
+
+	return servers, nil
+}
+
+// backupAgentConfig 在覆盖 agent 配置文件之前进行备份
+func (as *AppService) backupAgentConfig(agentID string) (string, error) {
+	configPath, err := as.detector.GetAgentConfigPath(agentID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get remote version: %w", err)
+		return "", err
 	}
-
-	if remoteVersion == nil {
-		return &models.SyncConflict{HasConflict: false}, nil
+	backupPath, err := as.storage.BackupAgentFile(agentID, configPath)
+	if err == nil && backupPath != "" {
+		as.recordAudit("backup", "backup", agentID, backupPath, "", fileSHA256(as.storage.fs, backupPath))
+		if _, pruneErr := as.PruneBackups(); pruneErr != nil {
+			println(fmt.Sprintf("Warning: failed to prune backups: %v", pruneErr))
+		}
 	}
+	return backupPath, err
+}
 
-	// Compare hashes - if local is newer than remote, there's unsaved local changes
-	if localVersion != nil && localVersion.Timestamp.After(remoteVersion.Timestamp) && localVersion.Hash != remoteVersion.Hash {
-		return &models.SyncConflict{
-			HasConflict:   true,
-			ConflictType:  "pull_conflict",
-			LocalVersion:  localVersion,
-			RemoteVersion: remoteVersion,
-			Message:       "You have local changes not yet pushed to cloud. Choose to keep local, use remote, or merge.",
-		}, nil
+// PruneBackups 按 SyncConfig.BackupRetention 删除多余的 agent 配置备份，返回删除的数量；没有设置保留策略时不删除。
+// 每次备份后都会自动执行
+func (as *AppService) PruneBackups() (int, error) {
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load sync config: %w", err)
 	}
-
-	return &models.SyncConflict{HasConflict: false}, nil
+	retention := config.BackupRetention
+	if retention == nil {
+		return 0, nil
+	}
+	return as.storage.PruneBackups(retention.MaxCount, time.Duration(retention.MaxAgeDays)*24*time.Hour)
 }
 
-// ResolveConflict 解决冲突 - 根据用户选择
-func (as *AppService) ResolveConflict(conflictType string, resolution string) error {
-	// resolution: "keep_local", "use_remote", "merge"
-
-	switch resolution {
-	case "keep_local":
-		// Just push local to remote
-		return as.PushAllAgentsToGist()
+// SetBackupRetention 设置 agent 配置备份的保留策略并立即按新策略清理
+func (as *AppService) SetBackupRetention(retention models.BackupRetention) error {
+	if retention.MaxCount < 0 || retention.MaxAgeDays < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
 
-	case "use_remote":
-		// Just pull remote to local
-		_, err := as.PullFromGist()
-		return err
+	as.configMu.Lock()
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		as.configMu.Unlock()
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.BackupRetention = &retention
+	config.LastUpdateTime = nowTime()
+	err = as.storage.SaveSyncConfig(config)
+	as.configMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save backup retention: %w", err)
+	}
 
-	case "merge":
-		// TODO: Implement smart merge logic
-		// For now, just use remote
-		_, err := as.PullFromGist()
-		return err
+	_, err = as.PruneBackups()
+	return err
+}
 
-	default:
-		return fmt.Errorf("unknown resolution type: %s", resolution)
+// shortHash 返回用于日志展示的短 hash
+func shortHash(hash string) string {
+	if hash == "" {
+		return "(unknown)"
+	}
+	if len(hash) > 12 {
+		return hash[:12]
 	}
+	return hash
 }
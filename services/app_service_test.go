@@ -0,0 +1,3566 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// newTestAppService builds an AppService rooted in a temporary home directory
+func newTestAppService(t testing.TB) *AppService {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv(DataDirEnv, "")
+	t.Setenv(GitHubTokenEnv, "")
+
+	as := startTestAppService(t)
+	// Keep local storage unencrypted unless a test enables it with its own keyring
+	as.storage.crypto = nil
+	as.secrets = mapSecretStore{}
+	return as
+}
+
+// startTestAppService starts an AppService the way the app does, in DataDir() for the current test environment,
+// but with an in-memory keyring so tests never read or write the system keyring
+func startTestAppService(t testing.TB) *AppService {
+	t.Helper()
+
+	dataDir, err := DataDir()
+	if err != nil {
+		t.Fatalf("DataDir() error = %v", err)
+	}
+	as, err := NewAppServiceWithKeyring(dataDir, NewInMemoryKeyring())
+	if err != nil {
+		t.Fatalf("NewAppServiceWithKeyring() error = %v", err)
+	}
+	return as
+}
+
+// mapSecretStore is an in-memory SecretStore
+type mapSecretStore map[string]string
+
+func (m mapSecretStore) SetSecret(name, value string) error {
+	m[name] = value
+	return nil
+}
+
+func (m mapSecretStore) GetSecret(name string) (string, error) {
+	value, ok := m[name]
+	if !ok {
+		return "", errors.New("secret not found: " + name)
+	}
+	return value, nil
+}
+
+func (m mapSecretStore) DeleteSecret(name string) error {
+	delete(m, name)
+	return nil
+}
+
+// connectTestGist stores credentials for the stub server and attaches an encrypted gist client
+func connectTestGist(t *testing.T, as *AppService, token, gistID string) {
+	t.Helper()
+
+	config, _ := as.storage.LoadSyncConfig()
+	config.GitHubToken = token
+	config.GistID = gistID
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		t.Fatalf("SaveSyncConfig() error = %v", err)
+	}
+	as.gistSync = newTestGistSync(token, gistID)
+}
+
+// writeAgentFile writes content to the agent's primary config path and returns the path
+func writeAgentFile(t testing.TB, as *AppService, agentID, content string) string {
+	t.Helper()
+
+	path, err := as.detector.GetAgentConfigPath(agentID)
+	if err != nil {
+		t.Fatalf("GetAgentConfigPath(%s) error = %v", agentID, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// remotePayload builds an encrypted gist payload holding the given agent configs
+func remotePayload(t *testing.T, agents map[string]interface{}, timestamp time.Time) string {
+	t.Helper()
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"agents":    agents,
+		"timestamp": timestamp.Format(time.RFC3339),
+		"encrypted": true,
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryptForTest(t, string(data))
+}
+
+func findSyncLog(t *testing.T, as *AppService, action string) *models.SyncLog {
+	t.Helper()
+	logs, err := as.GetSyncLogs(100)
+	if err != nil {
+		t.Fatalf("GetSyncLogs() error = %v", err)
+	}
+	for i := range logs {
+		if logs[i].Action == action {
+			return &logs[i]
+		}
+	}
+	return nil
+}
+
+func TestForcePushOverridesConflict(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"remote-only": map[string]interface{}{"command": "remote"}}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	gistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"local": {"command": "node", "args": ["server.js"]}}}`)
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "local_1", Timestamp: nowTime(), Content: "local state", Source: "local"})
+
+	conflict, err := as.DetectPushConflict()
+	if err != nil {
+		t.Fatalf("DetectPushConflict() error = %v", err)
+	}
+	if !conflict.HasConflict {
+		t.Fatalf("expected a would-be push conflict")
+	}
+	remoteHash := conflict.RemoteVersion.Hash
+
+	if err := as.ForcePush(); err != nil {
+		t.Fatalf("ForcePush() error = %v", err)
+	}
+
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, `"local"`) || strings.Contains(pushed, "remote-only") {
+		t.Errorf("remote was not overwritten by local config: %s", pushed)
+	}
+
+	entry := findSyncLog(t, as, "force_push")
+	if entry == nil {
+		t.Fatalf("expected a force_push sync log entry")
+	}
+	if !strings.Contains(entry.Message, shortHash(remoteHash)) || !strings.Contains(entry.Details, remoteHash) {
+		t.Errorf("force_push log does not record overwritten version %s: %+v", remoteHash, entry)
+	}
+
+	versions, _ := as.GetConfigVersions(10)
+	foundBackup := false
+	for _, v := range versions {
+		if strings.HasPrefix(v.ID, "backup_remote_") && v.Hash == remoteHash {
+			foundBackup = true
+		}
+	}
+	if !foundBackup {
+		t.Errorf("expected the overwritten remote version to be backed up")
+	}
+}
+
+func TestForcePullOverridesConflict(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"remote-server": map[string]interface{}{"command": "remote"}}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	gistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"local-only": {"command": "node"}}}`)
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "local_1", Timestamp: nowTime(), Content: "unpushed local change", Source: "local"})
+
+	conflict, err := as.DetectPullConflict()
+	if err != nil {
+		t.Fatalf("DetectPullConflict() error = %v", err)
+	}
+	if !conflict.HasConflict {
+		t.Fatalf("expected a would-be pull conflict")
+	}
+
+	if _, err := as.ForcePull(); err != nil {
+		t.Fatalf("ForcePull() error = %v", err)
+	}
+
+	if content := readFile(t, path); !strings.Contains(content, "remote-server") {
+		t.Errorf("local config was not overwritten by remote: %s", content)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "cursor_*"))
+	if len(backups) != 1 {
+		t.Fatalf("expected one cursor backup, got %v", backups)
+	}
+	if !strings.Contains(readFile(t, backups[0]), "local-only") {
+		t.Errorf("backup does not contain the overwritten local config")
+	}
+
+	entry := findSyncLog(t, as, "force_pull")
+	if entry == nil || !strings.Contains(entry.Message, "Forced pull overwrote local version") {
+		t.Fatalf("expected a force_pull sync log entry, got %+v", entry)
+	}
+}
+
+func TestForcePushRequiresEncryption(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	as.gistSync = NewGistSyncService("token-a", gistID)
+
+	if err := as.ForcePush(); err == nil {
+		t.Fatalf("ForcePush() should fail without encryption")
+	}
+	if _, err := as.ForcePull(); err == nil {
+		t.Fatalf("ForcePull() should fail without encryption")
+	}
+	if server.requestCount(http.MethodPatch) != 0 {
+		t.Errorf("no gist update should be sent without encryption")
+	}
+}
+
+func TestForcePushRequiresCredentials(t *testing.T) {
+	as := newTestAppService(t)
+	if err := as.ForcePush(); err == nil {
+		t.Fatalf("ForcePush() should fail without configured credentials")
+	}
+}
+
+// offlineURL returns the address of a server that has already been shut down
+func offlineURL() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+func TestPushQueuedWhenOfflineAndFlushed(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"queued": {"command": "node"}}}`)
+
+	as.gistSync.apiBaseURL = offlineURL()
+	if err := as.PushAllAgentsToGist(); err == nil {
+		t.Fatalf("PushAllAgentsToGist() should report the offline failure")
+	}
+
+	pending, err := as.storage.ListPendingPushes()
+	if err != nil {
+		t.Fatalf("ListPendingPushes() error = %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("expected 1 queued push, got %d", len(pending))
+	}
+	if entry := findSyncLog(t, as, "push"); entry == nil || entry.Status != "queued" {
+		t.Errorf("expected a queued push log entry, got %+v", entry)
+	}
+
+	// Still offline: flush keeps the queue
+	if err := as.FlushPendingPushes(); err == nil {
+		t.Fatalf("FlushPendingPushes() should fail while offline")
+	}
+	if pending, _ := as.storage.ListPendingPushes(); len(pending) != 1 {
+		t.Fatalf("queue should be kept while offline, got %d", len(pending))
+	}
+
+	// Connectivity returns
+	as.gistSync.apiBaseURL = server.URL
+	if err := as.FlushPendingPushes(); err != nil {
+		t.Fatalf("FlushPendingPushes() error = %v", err)
+	}
+	if pending, _ := as.storage.ListPendingPushes(); len(pending) != 0 {
+		t.Errorf("queue should be empty after flush, got %d", len(pending))
+	}
+	if pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json")); !strings.Contains(pushed, "queued") {
+		t.Errorf("queued payload was not pushed: %s", pushed)
+	}
+}
+
+func TestFlushPendingPushesKeepsQueueWhenRemoteChanged(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"base": {"command": "node"}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"queued": {"command": "node"}}}`)
+	as.gistSync.apiBaseURL = offlineURL()
+	if err := as.PushAllAgentsToGist(); err == nil {
+		t.Fatalf("PushAllAgentsToGist() should report the offline failure")
+	}
+
+	// Another machine pushes while this one is offline
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"other-machine": map[string]interface{}{"command": "node"}}},
+	}, time.Now())
+	server.writeFileForTest(gistID, remote)
+
+	as.gistSync.apiBaseURL = server.URL
+	if err := as.FlushPendingPushes(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("FlushPendingPushes() error = %v, want ErrConflict", err)
+	}
+	if pending, _ := as.storage.ListPendingPushes(); len(pending) != 1 {
+		t.Errorf("queue should be kept on conflict, got %d", len(pending))
+	}
+	if server.fileContent(gistID, DefaultGistFileName) != remote {
+		t.Errorf("flush overwrote the other machine's push")
+	}
+}
+
+func TestPushNotQueuedOnAuthError(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "revoked-token", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	if err := as.PushAllAgentsToGist(); err == nil {
+		t.Fatalf("PushAllAgentsToGist() should fail with a revoked token")
+	}
+	if pending, _ := as.storage.ListPendingPushes(); len(pending) != 0 {
+		t.Errorf("auth failures must not be queued, got %d", len(pending))
+	}
+}
+
+func TestInitializeGistSyncValidatesExistingGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+
+	as := newTestAppService(t)
+	if _, err := as.InitializeGistSync("token-a", "missing"); !errors.Is(err, ErrGistNotFound) {
+		t.Fatalf("InitializeGistSync() error = %v, want %v", err, ErrGistNotFound)
+	}
+
+	config, _ := as.GetSyncConfig()
+	if config.GistID != "" {
+		t.Errorf("an invalid gist ID must not be saved, got %q", config.GistID)
+	}
+}
+
+func TestInitializeGistSyncDetailed(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("token-a", "alice", "gist")
+	server.addClassicToken("no-gist", "alice", "repo")
+	existing := server.addGist("alice", "")
+
+	t.Run("creates a new gist", func(t *testing.T) {
+		as := newTestAppService(t)
+		result, err := as.InitializeGistSyncDetailed("token-a", "")
+		if err != nil {
+			t.Fatalf("InitializeGistSyncDetailed() error = %v", err)
+		}
+		if !result.Created || !result.TokenScopesOK || result.GistID == "" || !server.hasGist(result.GistID) {
+			t.Errorf("result = %+v, want a newly created gist", result)
+		}
+		if config, _ := as.GetSyncConfig(); config.GistID != result.GistID {
+			t.Errorf("saved gist ID = %q, want %q", config.GistID, result.GistID)
+		}
+	})
+
+	t.Run("reuses an existing gist", func(t *testing.T) {
+		as := newTestAppService(t)
+		result, err := as.InitializeGistSyncDetailed("token-a", existing)
+		if err != nil {
+			t.Fatalf("InitializeGistSyncDetailed() error = %v", err)
+		}
+		if result.Created || !result.TokenScopesOK || result.GistID != existing {
+			t.Errorf("result = %+v, want reused gist %s", result, existing)
+		}
+	})
+
+	t.Run("rejects a bad token", func(t *testing.T) {
+		as := newTestAppService(t)
+		gists := server.requestCount("POST")
+		result, err := as.InitializeGistSyncDetailed("revoked", "")
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("InitializeGistSyncDetailed() error = %v, want ErrUnauthorized", err)
+		}
+		if result.TokenScopesOK || result.Created || server.requestCount("POST") != gists {
+			t.Errorf("result = %+v, no gist should be created with a bad token", result)
+		}
+		if config, _ := as.GetSyncConfig(); config.GitHubToken != "" {
+			t.Errorf("a rejected token must not be saved")
+		}
+	})
+
+	t.Run("reports a token without the gist scope", func(t *testing.T) {
+		as := newTestAppService(t)
+		result, err := as.InitializeGistSyncDetailed("no-gist", existing)
+		if !errors.Is(err, ErrTokenMissingGistScope) || result.TokenScopesOK {
+			t.Errorf("InitializeGistSyncDetailed() = %+v, %v, want ErrTokenMissingGistScope", result, err)
+		}
+	})
+}
+
+func TestUpdateGitHubTokenUsedForSubsequentPushes(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("old-token", "alice", "gist")
+	server.addClassicToken("new-token", "alice", "gist, repo")
+	server.addClassicToken("repo-only", "alice", "repo")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "old-token", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	if err := as.UpdateGitHubToken("repo-only"); !errors.Is(err, ErrTokenMissingGistScope) {
+		t.Fatalf("UpdateGitHubToken() error = %v, want %v", err, ErrTokenMissingGistScope)
+	}
+
+	if err := as.UpdateGitHubToken("new-token"); err != nil {
+		t.Fatalf("UpdateGitHubToken() error = %v", err)
+	}
+
+	config, _ := as.GetSyncConfig()
+	if config.GitHubToken != "new-token" || config.GistID != gistID {
+		t.Errorf("unexpected sync config after rotation: token=%q gist=%q", config.GitHubToken, config.GistID)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if server.lastAuth != "Bearer new-token" {
+		t.Errorf("push used %q, want the rotated token", server.lastAuth)
+	}
+	if !as.gistSync.IsEncryptionEnabled() {
+		t.Errorf("token rotation must not change encryption settings")
+	}
+}
+
+// Run with -race: UI-triggered and background sync calls share one AppService
+func TestConcurrentPushAndPull(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("token-a", "alice", "gist")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("initial PushAllAgentsToGist() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 10; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			errs <- as.PushAllAgentsToGist()
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := as.PullFromGist()
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- as.UpdateGitHubToken("token-a")
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := as.GetSyncConfig()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent sync error = %v", err)
+		}
+	}
+	config, _ := as.GetSyncConfig()
+	if config.GitHubToken != "token-a" || config.LastSyncStatus != "success" {
+		t.Errorf("unexpected sync config after concurrent syncs: %+v", config)
+	}
+}
+
+func TestSetGistFileNameUsedForPushAndPull(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+
+	if err := as.SetGistFileName("../evil"); err == nil {
+		t.Error("expected error for a file name with a path separator")
+	}
+	if err := as.SetGistFileName("laptop.json"); err != nil {
+		t.Fatalf("SetGistFileName() error = %v", err)
+	}
+	if config, _ := as.GetSyncConfig(); config.GistFileName != "laptop.json" {
+		t.Errorf("GistFileName = %q, want laptop.json", config.GistFileName)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if !strings.Contains(decryptForTest(t, server.fileContent(gistID, "laptop.json")), "fetch") {
+		t.Errorf("push did not use the configured file name")
+	}
+	if got := server.fileContent(gistID, "mcp-config.json"); got != `{"servers": []}` {
+		t.Errorf("default file was modified: %q", got)
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	if conflict, err := as.DetectPushConflict(); err != nil || conflict == nil {
+		t.Errorf("DetectPushConflict() = %v, %v, want conflict from laptop.json", conflict, err)
+	}
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if content := readFile(t, path); !strings.Contains(content, "fetch") {
+		t.Errorf("pull did not read the configured file: %s", content)
+	}
+}
+
+func TestSwitchProfileTargetsOwnGistFileAndAgents(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	workGist := server.addGist("alice", `{"servers": []}`)
+	homeGist := server.addGistFiles("alice", map[string]string{})
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", workGist)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"work-db": {"command": "db"}}}`)
+	windsurfPath := writeAgentFile(t, as, "windsurf", `{"mcpServers": {"notes": {"command": "notes"}}}`)
+
+	if err := as.CreateProfile("home", homeGist, "home.json", []string{"windsurf"}); err != nil {
+		t.Fatalf("CreateProfile() error = %v", err)
+	}
+	if err := as.CreateProfile("home", "", "", nil); err == nil {
+		t.Error("expected error creating a duplicate profile")
+	}
+	if err := as.CreateProfile("bad", "", "", []string{"no-such-agent"}); err == nil {
+		t.Error("expected error for an unknown agent")
+	}
+
+	profiles, err := as.ListProfiles()
+	if err != nil || len(profiles) != 2 || profiles[0].Name != "default" || profiles[1].Name != "home" {
+		t.Fatalf("ListProfiles() = %+v, %v, want default and home", profiles, err)
+	}
+	if profiles[0].GistID != workGist {
+		t.Errorf("default profile gist = %s, want %s", profiles[0].GistID, workGist)
+	}
+
+	// The default profile still pushes every agent to the original gist
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if pushed := decryptForTest(t, server.fileContent(workGist, "mcp-config.json")); !strings.Contains(pushed, "work-db") || !strings.Contains(pushed, "notes") {
+		t.Errorf("default profile push missing agents: %s", pushed)
+	}
+
+	if err := as.SwitchProfile("missing"); err == nil {
+		t.Error("expected error switching to an unknown profile")
+	}
+	if err := as.SwitchProfile("home"); err != nil {
+		t.Fatalf("SwitchProfile() error = %v", err)
+	}
+	as.gistSync = newTestGistSync("token-a", homeGist)
+	as.gistSync.SetFileName("home.json")
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	pushed := decryptForTest(t, server.fileContent(homeGist, "home.json"))
+	if !strings.Contains(pushed, "notes") || strings.Contains(pushed, "work-db") {
+		t.Errorf("home profile push should hold only windsurf: %s", pushed)
+	}
+
+	// Pulling under the home profile only touches windsurf
+	server.writeNamedFileForTest(homeGist, "home.json", remotePayload(t, map[string]interface{}{
+		"cursor":   map[string]interface{}{"mcpServers": map[string]interface{}{"intruder": map[string]interface{}{"command": "x"}}},
+		"windsurf": map[string]interface{}{"mcpServers": map[string]interface{}{"notes2": map[string]interface{}{"command": "notes"}}},
+	}, time.Now()))
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if content := readFile(t, windsurfPath); !strings.Contains(content, "notes2") {
+		t.Errorf("windsurf not updated by pull: %s", content)
+	}
+	cursorConfig, _ := as.GetAgentMCPConfig("cursor")
+	if _, ok := agentServers(cursorConfig, "mcpServers")["intruder"]; ok {
+		t.Errorf("pull changed cursor, which is outside the home profile")
+	}
+
+	if err := as.SwitchProfile("default"); err != nil {
+		t.Fatalf("SwitchProfile() error = %v", err)
+	}
+	config, _ := as.GetSyncConfig()
+	if config.GistID != workGist || config.GistFileName != "" || config.ActiveProfile != "default" {
+		t.Errorf("unexpected config after switching back: gist=%s file=%q active=%s", config.GistID, config.GistFileName, config.ActiveProfile)
+	}
+}
+
+func TestSwitchProfileKeepsMergeBasePerProfile(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	workGist := server.addGist("alice", `{"servers": []}`)
+	homeGist := server.addGistFiles("alice", map[string]string{})
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", workGist)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"work-db": {"command": "db"}}}`)
+	if err := as.CreateProfile("home", homeGist, "home.json", []string{"cursor"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	workBase, _ := as.GetMergeBase()
+
+	if err := as.SwitchProfile("home"); err != nil {
+		t.Fatalf("SwitchProfile(home) error = %v", err)
+	}
+	if base, err := as.GetMergeBase(); err != nil || base != nil {
+		t.Errorf("merge base of a profile that never synced = %+v, %v; want none", base, err)
+	}
+
+	if err := as.SwitchProfile("default"); err != nil {
+		t.Fatalf("SwitchProfile(default) error = %v", err)
+	}
+	if base, err := as.GetMergeBase(); err != nil || base == nil || base.Content != workBase.Content {
+		t.Errorf("merge base after switching back = %+v, %v; want the default profile's", base, err)
+	}
+}
+
+func TestMigrateGistToNewAccount(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	server.addUser("token-b", "bob")
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"kept": map[string]interface{}{"command": "node"}}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	oldGistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", oldGistID)
+
+	newGistID, err := as.MigrateGist("token-b", true)
+	if err != nil {
+		t.Fatalf("MigrateGist() error = %v", err)
+	}
+	if newGistID == oldGistID || server.gistOwner(newGistID) != "bob" {
+		t.Fatalf("expected a new gist owned by bob, got %s owned by %q", newGistID, server.gistOwner(newGistID))
+	}
+	if server.fileContent(newGistID, "mcp-config.json") != remote {
+		t.Errorf("encrypted content was not copied verbatim")
+	}
+	if server.hasGist(oldGistID) {
+		t.Errorf("old gist should have been deleted")
+	}
+
+	config, _ := as.GetSyncConfig()
+	if config.GitHubToken != "token-b" || config.GistID != newGistID {
+		t.Errorf("sync config not repointed: token=%q gist=%q", config.GitHubToken, config.GistID)
+	}
+
+	// Content stays readable with the preserved key
+	agents, err := as.gistSync.PullAgentConfigsFromGist()
+	if err != nil {
+		t.Fatalf("PullAgentConfigsFromGist() after migration error = %v", err)
+	}
+	if _, ok := agents["cursor"]; !ok {
+		t.Errorf("migrated gist lost agent configs: %v", agents)
+	}
+}
+
+func TestMigrateGistKeepsOldGistByDefault(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	server.addUser("token-b", "bob")
+	oldGistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, time.Now()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", oldGistID)
+
+	if _, err := as.MigrateGist("token-b", false); err != nil {
+		t.Fatalf("MigrateGist() error = %v", err)
+	}
+	if !server.hasGist(oldGistID) {
+		t.Errorf("old gist should be kept when deleteOld is false")
+	}
+}
+
+func TestTearDownSync(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	config, _ := as.GetSyncConfig()
+	config.EnableEncryption = true
+	config.GistEncryptionPassword = "secret"
+	as.SaveSyncConfig(config)
+
+	if err := as.TearDownSync(true, true); err != nil {
+		t.Fatalf("TearDownSync() error = %v", err)
+	}
+
+	if server.hasGist(gistID) {
+		t.Errorf("remote gist should be deleted")
+	}
+	config, _ = as.GetSyncConfig()
+	if config.GitHubToken != "" || config.GistID != "" || config.EnableEncryption || config.GistEncryptionPassword != "" {
+		t.Errorf("local sync state not cleared: %+v", config)
+	}
+	if as.gistSync != nil {
+		t.Errorf("cached gist backend should be dropped")
+	}
+}
+
+func TestTearDownSyncKeepsRemote(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+
+	if err := as.TearDownSync(false, false); err != nil {
+		t.Fatalf("TearDownSync() error = %v", err)
+	}
+	if !server.hasGist(gistID) || server.requestCount(http.MethodDelete) != 0 {
+		t.Errorf("remote gist must be kept when deleteRemote is false")
+	}
+	if config, _ := as.GetSyncConfig(); config.GitHubToken != "" {
+		t.Errorf("token should be cleared")
+	}
+}
+
+func TestPullFromEmptyGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"local-only": {"command": "node"}}}`)
+
+	servers, err := as.PullFromGist()
+	if err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if len(servers) != 0 {
+		t.Errorf("PullFromGist() = %v, want no servers", servers)
+	}
+	if content := readFile(t, path); !strings.Contains(content, "local-only") {
+		t.Errorf("local config must be untouched by an empty pull: %s", content)
+	}
+
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "local_1", Timestamp: nowTime(), Content: "local", Source: "local"})
+	if conflict, err := as.DetectPushConflict(); err != nil || conflict.HasConflict {
+		t.Errorf("DetectPushConflict() = %+v, %v, want no conflict", conflict, err)
+	}
+	if conflict, err := as.DetectPullConflict(); err != nil || conflict.HasConflict {
+		t.Errorf("DetectPullConflict() = %+v, %v, want no conflict", conflict, err)
+	}
+}
+
+func TestPushByTagExcludesOtherServers(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"work-db": {"command": "db", "tags": ["work"]},
+		"home-notes": {"command": "notes", "tags": ["personal"]},
+		"untagged": {"command": "plain"}
+	}}`)
+
+	if err := as.PushByTag("work"); err != nil {
+		t.Fatalf("PushByTag() error = %v", err)
+	}
+
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, "work-db") {
+		t.Errorf("tagged server missing from push: %s", pushed)
+	}
+	if strings.Contains(pushed, "home-notes") || strings.Contains(pushed, "untagged") {
+		t.Errorf("push included servers outside the work tag: %s", pushed)
+	}
+}
+
+func TestPullByTagKeepsOtherLocalServers(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"work-api":     map[string]interface{}{"command": "api", "tags": []string{"work"}},
+			"remote-other": map[string]interface{}{"command": "other"},
+		}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	gistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"work-old": {"command": "old", "tags": ["work"]},
+		"home-notes": {"command": "notes", "tags": ["personal"]}
+	}}`)
+
+	servers, err := as.PullByTag("work")
+	if err != nil {
+		t.Fatalf("PullByTag() error = %v", err)
+	}
+	if len(servers) != 1 || servers[0].Name != "work-api" {
+		t.Errorf("PullByTag() = %+v, want only work-api", servers)
+	}
+
+	content := readFile(t, path)
+	for _, want := range []string{"work-api", "home-notes", `"tags"`} {
+		if !strings.Contains(content, want) {
+			t.Errorf("local config missing %s: %s", want, content)
+		}
+	}
+	for _, unwanted := range []string{"work-old", "remote-other"} {
+		if strings.Contains(content, unwanted) {
+			t.Errorf("local config should not contain %s: %s", unwanted, content)
+		}
+	}
+}
+
+func TestPullByTagSkipsUnchangedAgentsAndReportsFailures(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	workAPI := map[string]interface{}{"command": "api", "tags": []string{"work"}}
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor":      map[string]interface{}{"mcpServers": map[string]interface{}{"work-api": workAPI}},
+		"windsurf":    map[string]interface{}{"mcpServers": map[string]interface{}{"work-api": workAPI}},
+		"claude-code": map[string]interface{}{"mcpServers": map[string]interface{}{"work-api": workAPI}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	gistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"work-old": {"command": "old", "tags": ["work"]}}}`)
+	unchanged := `{"mcpServers": {"work-api": {"command": "api", "tags": ["work"]}, "home": {"command": "home"}}}`
+	windsurfPath := writeAgentFile(t, as, "windsurf", unchanged)
+	writeAgentFile(t, as, "claude-code", `{"mcpServers": {`)
+
+	if _, err := as.PullByTag("work"); err == nil || !strings.Contains(err.Error(), "claude-code") {
+		t.Fatalf("PullByTag() error = %v, want the claude-code failure", err)
+	}
+	if content := readFile(t, cursorPath); !strings.Contains(content, "work-api") || strings.Contains(content, "work-old") {
+		t.Errorf("cursor not updated: %s", content)
+	}
+	if content := readFile(t, windsurfPath); content != unchanged {
+		t.Errorf("windsurf was rewritten although its tagged servers match: %s", content)
+	}
+
+	entry := findSyncLog(t, as, "pull")
+	if entry == nil || entry.Status != "partial" || !strings.Contains(entry.Details, "claude-code") || strings.Contains(entry.Details, "cursor") {
+		t.Errorf("pull log = %+v, want partial naming claude-code", entry)
+	}
+}
+func TestMetricsRecordPushWhenEnabled(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+
+	// Metrics are opt-in: nothing is recorded until enabled
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(as.storage.GetDataDir(), "metrics.json")); !os.IsNotExist(err) {
+		t.Errorf("metrics.json should not exist while metrics are disabled (stat error = %v)", err)
+	}
+
+	if err := as.SetMetricsEnabled(true); err != nil {
+		t.Fatalf("SetMetricsEnabled() error = %v", err)
+	}
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+
+	metrics, err := as.GetMetrics()
+	if err != nil {
+		t.Fatalf("GetMetrics() error = %v", err)
+	}
+	if metrics.Pushes != 1 || metrics.Pulls != 0 || metrics.Failures != 0 || metrics.SyncCount != 1 {
+		t.Errorf("metrics after one push = %+v", metrics)
+	}
+	if metrics.BytesPushed == 0 || metrics.LastSyncDurationMs <= 0 || metrics.AverageSyncDurationMs != metrics.TotalSyncDurationMs {
+		t.Errorf("push should record bytes and a duration: %+v", metrics)
+	}
+	if metrics.Since.IsZero() {
+		t.Errorf("metrics should record when collection started")
+	}
+
+	// A failed pull counts as a failure, not a pull
+	server.writeFileForTest(gistID, "not encrypted with this key")
+	if _, err := as.PullFromGist(); err == nil {
+		t.Fatalf("expected PullFromGist() to fail")
+	}
+	if metrics, _ := as.GetMetrics(); metrics.Pulls != 0 || metrics.Failures != 1 || metrics.SyncCount != 2 {
+		t.Errorf("metrics after a failed pull = %+v", metrics)
+	}
+
+	if err := as.ResetMetrics(); err != nil {
+		t.Fatalf("ResetMetrics() error = %v", err)
+	}
+	if metrics, _ := as.GetMetrics(); metrics.Pushes != 0 || metrics.Failures != 0 || metrics.SyncCount != 0 || metrics.Since.IsZero() {
+		t.Errorf("metrics after reset = %+v", metrics)
+	}
+}
+
+func TestSecretReferencesResolvedOnApplyAndKeptOnPush(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	if err := as.SetSecret("my-key", "sk-live-123"); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+
+	err := as.SaveAgentMCPConfig("cursor", map[string]interface{}{
+		"mcpServers": map[string]interface{}{
+			"api": map[string]interface{}{
+				"command": "node",
+				"env": map[string]interface{}{
+					"API_KEY": "${secret:my-key}",
+					"AUTH":    "Bearer ${secret:my-key}",
+					"MODE":    "prod",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SaveAgentMCPConfig() error = %v", err)
+	}
+
+	onDisk := readFile(t, path)
+	if !strings.Contains(onDisk, `"sk-live-123"`) || !strings.Contains(onDisk, `"Bearer sk-live-123"`) || strings.Contains(onDisk, "${secret:") {
+		t.Errorf("agent config should hold resolved values: %s", onDisk)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if strings.Contains(pushed, "sk-live-123") {
+		t.Errorf("synced payload leaked the secret value: %s", pushed)
+	}
+	if !strings.Contains(pushed, "${secret:my-key}") || !strings.Contains(pushed, "Bearer ${secret:my-key}") {
+		t.Errorf("synced payload should carry the references: %s", pushed)
+	}
+
+	versions, _ := as.GetConfigVersions(10)
+	for _, v := range versions {
+		if strings.Contains(v.Content, "sk-live-123") {
+			t.Errorf("version %s leaked the secret value", v.ID)
+		}
+	}
+}
+
+func TestSecretReferenceMissingSecret(t *testing.T) {
+	as := newTestAppService(t)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	err := as.SaveAgentMCPConfig("cursor", map[string]interface{}{
+		"mcpServers": map[string]interface{}{
+			"api": map[string]interface{}{"command": "node", "env": map[string]interface{}{"API_KEY": "${secret:absent}"}},
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected an error for an unknown secret")
+	}
+	if content := readFile(t, path); strings.Contains(content, "api") {
+		t.Errorf("config must not be written when a reference cannot be resolved: %s", content)
+	}
+}
+
+func TestCodexEnvVariablesExpandedOnApplyAndKeptOnPush(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	t.Setenv("MCP_DATA_ROOT", "/srv/data")
+	codexPath := writeAgentFile(t, as, "codex", "")
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	servers := map[string]interface{}{"mcpServers": map[string]interface{}{
+		"db": map[string]interface{}{"command": "db-mcp", "env": map[string]interface{}{"DATA": "${MCP_DATA_ROOT}/db"}},
+	}}
+
+	// Without the flag Codex gets the reference verbatim
+	if err := as.SaveAgentMCPConfig("codex", servers); err != nil {
+		t.Fatalf("SaveAgentMCPConfig(codex) error = %v", err)
+	}
+	if onDisk := readFile(t, codexPath); !strings.Contains(onDisk, `"${MCP_DATA_ROOT}/db"`) {
+		t.Errorf("Codex config without expansion = %s, want the reference kept", onDisk)
+	}
+
+	if err := as.SetExpandCodexEnv(true); err != nil {
+		t.Fatalf("SetExpandCodexEnv() error = %v", err)
+	}
+	for _, agentID := range []string{"codex", "cursor"} {
+		if err := as.SaveAgentMCPConfig(agentID, servers); err != nil {
+			t.Fatalf("SaveAgentMCPConfig(%s) error = %v", agentID, err)
+		}
+	}
+	if onDisk := readFile(t, codexPath); !strings.Contains(onDisk, `"/srv/data/db"`) {
+		t.Errorf("Codex config should hold the expanded value: %s", onDisk)
+	}
+	// JSON agents expand variables themselves, so their configs keep the reference
+	if onDisk := readFile(t, cursorPath); !strings.Contains(onDisk, `"${MCP_DATA_ROOT}/db"`) {
+		t.Errorf("cursor config should keep the reference: %s", onDisk)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if strings.Contains(pushed, "/srv/data") || strings.Count(pushed, "${MCP_DATA_ROOT}/db") != 2 {
+		t.Errorf("synced payload should carry the portable reference for both agents: %s", pushed)
+	}
+}
+
+func TestStripSecretsKeepsSensitiveEnvLocal(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"api": {"command": "node", "env": {"API_KEY": "sk-real", "LOG_LEVEL": "debug"}}}}`)
+	if err := as.SetStripSecrets([]string{"cursor"}); err != nil {
+		t.Fatalf("SetStripSecrets() error = %v", err)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if strings.Contains(pushed, "sk-real") || !strings.Contains(pushed, LocalSecretPlaceholder) || !strings.Contains(pushed, "debug") {
+		t.Errorf("pushed payload should hold the placeholder instead of the secret: %s", pushed)
+	}
+
+	// Another machine changes a plain value; the placeholder comes back on pull
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"api": map[string]interface{}{
+				"command": "node",
+				"env":     map[string]interface{}{"API_KEY": LocalSecretPlaceholder, "LOG_LEVEL": "info"},
+			},
+		}},
+	}, nowTime().Add(time.Hour)))
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+
+	onDisk := readFile(t, path)
+	if !strings.Contains(onDisk, `"sk-real"`) || !strings.Contains(onDisk, `"info"`) || strings.Contains(onDisk, LocalSecretPlaceholder) {
+		t.Errorf("local secret should survive the pull: %s", onDisk)
+	}
+}
+
+func TestAddSensitivePatternExtendsDefaults(t *testing.T) {
+	as := newTestAppService(t)
+	t.Cleanup(func() { SetExtraSensitivePatterns(nil) })
+
+	if IsSensitiveField("ACCESS_CODE") {
+		t.Fatalf("ACCESS_CODE should not be sensitive before the pattern is added")
+	}
+	if err := as.AddSensitivePattern("  Access_Code "); err != nil {
+		t.Fatalf("AddSensitivePattern() error = %v", err)
+	}
+	if err := as.AddSensitivePattern("access_code"); err != nil {
+		t.Fatalf("second AddSensitivePattern() error = %v", err)
+	}
+	if err := as.AddSensitivePattern(" "); err == nil {
+		t.Errorf("AddSensitivePattern() with an empty pattern should fail")
+	}
+
+	patterns := as.GetSensitivePatterns()
+	if len(patterns) != len(sensitivePatterns)+1 || patterns[len(patterns)-1] != "access_code" {
+		t.Errorf("GetSensitivePatterns() = %v", patterns)
+	}
+
+	sanitized := SanitizeConfig(map[string]interface{}{"env": map[string]interface{}{
+		"ACCESS_CODE": "abcdef123456",
+		"API_KEY":     "sk-1234567890",
+		"LOG_LEVEL":   "debug",
+	}})
+	env := sanitized["env"].(map[string]interface{})
+	if env["ACCESS_CODE"] == "abcdef123456" || env["API_KEY"] == "sk-1234567890" || env["LOG_LEVEL"] != "debug" {
+		t.Errorf("SanitizeConfig() env = %v", env)
+	}
+
+	// The pattern is persisted and applied again on the next start
+	SetExtraSensitivePatterns(nil)
+	startTestAppService(t)
+	if !IsSensitiveField("team_access_code") {
+		t.Errorf("custom pattern was not reloaded from the sync config")
+	}
+}
+
+// findFinding returns the first finding for check with the given severity
+func findFinding(report *models.DoctorReport, check, severity string) *models.DoctorFinding {
+	for i := range report.Findings {
+		if report.Findings[i].Check == check && report.Findings[i].Severity == severity {
+			return &report.Findings[i]
+		}
+	}
+	return nil
+}
+
+func TestDoctorHealthy(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("token-a", "alice", "gist, repo")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	report, err := as.Doctor()
+	if err != nil {
+		t.Fatalf("Doctor() error = %v", err)
+	}
+	if !report.Healthy || len(report.Findings) != 0 {
+		t.Errorf("Doctor() = %+v, want healthy with no findings", report)
+	}
+}
+
+func TestDoctorFindings(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, server *stubGistServer, as *AppService)
+		check    string
+		severity string
+	}{
+		{
+			name: "encryption enabled but key missing",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				config, _ := as.GetSyncConfig()
+				config.EnableEncryption = true
+				as.SaveSyncConfig(config)
+				dir := filepath.Join(as.storage.GetDataDir(), "versions")
+				os.MkdirAll(dir, 0755)
+				os.WriteFile(filepath.Join(dir, "version_1.json"), []byte("ENC:written-with-lost-key"), 0644)
+				as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+				as.storage.crypto.dataDir = as.storage.GetDataDir()
+			},
+			check:    "encryption",
+			severity: "error",
+		},
+		{
+			name: "invalid token",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				connectTestGist(t, as, "revoked", "gist001")
+			},
+			check:    "token",
+			severity: "error",
+		},
+		{
+			name: "token without gist scope",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				server.addClassicToken("repo-only", "alice", "repo")
+				connectTestGist(t, as, "repo-only", server.addGist("alice", `{"servers": []}`))
+			},
+			check:    "token",
+			severity: "error",
+		},
+		{
+			name: "gist deleted",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				server.addUser("token-a", "alice")
+				connectTestGist(t, as, "token-a", "missing")
+			},
+			check:    "gist",
+			severity: "error",
+		},
+		{
+			name: "data dir not writable",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				blocker := filepath.Join(t.TempDir(), "file")
+				os.WriteFile(blocker, []byte("x"), 0644)
+				as.storage.dataDir = filepath.Join(blocker, "data")
+			},
+			check:    "data_dir",
+			severity: "error",
+		},
+		{
+			name: "undecryptable version file",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				dir := filepath.Join(as.storage.GetDataDir(), "versions")
+				os.MkdirAll(dir, 0755)
+				os.WriteFile(filepath.Join(dir, "version_1.json"), []byte("ENC:not-decryptable"), 0644)
+			},
+			check:    "storage_files",
+			severity: "warning",
+		},
+		{
+			name: "unparsable agent config",
+			setup: func(t *testing.T, server *stubGistServer, as *AppService) {
+				writeAgentFile(t, as, "cursor", `{"mcpServers": `)
+			},
+			check:    "agents",
+			severity: "warning",
+		},
+		{
+			name:     "no agents detected",
+			setup:    func(t *testing.T, server *stubGistServer, as *AppService) {},
+			check:    "agents",
+			severity: "warning",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStubGistServer(t)
+			as := newTestAppService(t)
+			tt.setup(t, server, as)
+
+			report, err := as.Doctor()
+			if err != nil {
+				t.Fatalf("Doctor() error = %v", err)
+			}
+			finding := findFinding(report, tt.check, tt.severity)
+			if finding == nil {
+				t.Fatalf("expected a %s %s finding, got %+v", tt.severity, tt.check, report.Findings)
+			}
+			if finding.Suggestion == "" {
+				t.Errorf("finding should carry a suggested fix: %+v", finding)
+			}
+			if tt.severity == "error" && report.Healthy {
+				t.Errorf("report with an error finding must not be healthy")
+			}
+		})
+	}
+}
+
+// loseEncryptionKey encrypts local data with a keyring-held key, then removes the key from the keyring
+func loseEncryptionKey(t *testing.T, as *AppService) *InMemoryKeyring {
+	t.Helper()
+
+	keyring := NewInMemoryKeyring()
+	as.storage.crypto = NewSecureCryptoWithKeyring(keyring)
+	as.storage.crypto.dataDir = as.storage.GetDataDir()
+	as.storage.EnableEncryption("")
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "v1", Content: "encrypted history"})
+	as.storage.SaveSyncConfig(models.SyncConfig{ID: "default", EnableEncryption: true})
+	return keyring
+}
+
+// failingKeyring is a SystemKeyring whose every operation fails, like a platform without a keyring service
+type failingKeyring struct{}
+
+func (failingKeyring) SetKey(service, keyName string, keyData []byte) error {
+	return errors.New("keyring service unavailable")
+}
+
+func (failingKeyring) GetKey(service, keyName string) ([]byte, error) {
+	return nil, errors.New("keyring service unavailable")
+}
+
+func (failingKeyring) DeleteKey(service, keyName string) error {
+	return errors.New("keyring service unavailable")
+}
+
+func TestEnableEncryptionFailsWithoutKeyring(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	for name, crypto := range map[string]*SecureCrypto{
+		"no keyring":      nil,
+		"failing keyring": NewSecureCryptoWithKeyring(failingKeyring{}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			as := newTestAppService(t)
+			connectTestGist(t, as, "token-a", gistID)
+			as.storage.crypto = crypto
+
+			if as.IsKeyringAvailable() {
+				t.Errorf("IsKeyringAvailable() = true, want false")
+			}
+			if err := as.storage.EnableEncryption(""); !errors.Is(err, ErrKeyringUnavailable) {
+				t.Errorf("EnableEncryption() error = %v, want ErrKeyringUnavailable", err)
+			}
+			if err := as.SetupGistEncryption(true, "gist-password"); !errors.Is(err, ErrKeyringUnavailable) {
+				t.Fatalf("SetupGistEncryption() error = %v, want ErrKeyringUnavailable", err)
+			}
+
+			// Nothing claims to be encrypted while data would be stored in plaintext
+			config, err := as.GetSyncConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.EnableEncryption || as.storage.IsEncryptionEnabled() {
+				t.Errorf("encryption recorded as enabled: config %v, storage %v", config.EnableEncryption, as.storage.IsEncryptionEnabled())
+			}
+		})
+	}
+
+	as := newTestAppService(t)
+	as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	if !as.IsKeyringAvailable() {
+		t.Errorf("IsKeyringAvailable() with a working keyring = false")
+	}
+	if err := as.storage.EnableEncryption(""); err != nil || !as.storage.IsEncryptionEnabled() {
+		t.Errorf("EnableEncryption() with a working keyring error = %v", err)
+	}
+}
+
+// encryptGistWithKeyring encrypts local data and the gist with the master key in a fresh in-memory keyring,
+// the default setup when no gist password is set
+func encryptGistWithKeyring(t *testing.T, as *AppService) *InMemoryKeyring {
+	t.Helper()
+
+	keyring := NewInMemoryKeyring()
+	as.keyring = keyring
+	as.storage.crypto = NewSecureCryptoWithKeyring(keyring)
+	as.storage.crypto.dataDir = as.storage.GetDataDir()
+	as.gistSync.SetKeyring(keyring)
+	if err := as.SetupGistEncryption(true, ""); err != nil {
+		t.Fatalf("SetupGistEncryption() error = %v", err)
+	}
+	as.gistSync = nil
+	return keyring
+}
+
+func TestRotateEncryptionKeyReencryptsGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	keyring := encryptGistWithKeyring(t, as)
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": "npx"}}}`)
+	if err := as.PushAgentToGist("cursor"); err != nil {
+		t.Fatalf("PushAgentToGist() error = %v", err)
+	}
+	oldKey, _ := keyring.GetKey(keyringServiceName(), "master_key")
+
+	if err := as.RotateEncryptionKey(); err != nil {
+		t.Fatalf("RotateEncryptionKey() error = %v", err)
+	}
+	if newKey, _ := keyring.GetKey(keyringServiceName(), "master_key"); bytes.Equal(newKey, oldKey) {
+		t.Fatalf("master key was not rotated")
+	}
+
+	// A fresh client, as after a restart, reads the gist with the new key
+	as.gistSync = nil
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	if err := as.PullAgentFromGist("cursor"); err != nil {
+		t.Fatalf("PullAgentFromGist() after rotation error = %v", err)
+	}
+	if content := readFile(t, path); !strings.Contains(content, `"npx"`) {
+		t.Errorf("pulled cursor config = %s, want the pushed server", content)
+	}
+}
+
+func TestRotateEncryptionKeyRefusesWhenGistIsUnreadable(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	keyring := encryptGistWithKeyring(t, as)
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": "npx"}}}`)
+	if err := as.PushAgentToGist("cursor"); err != nil {
+		t.Fatalf("PushAgentToGist() error = %v", err)
+	}
+	oldKey, _ := keyring.GetKey(keyringServiceName(), "master_key")
+	before := server.fileContent(gistID, DefaultGistFileName)
+
+	server.failNext(http.StatusServiceUnavailable)
+	if err := as.RotateEncryptionKey(); err == nil {
+		t.Fatalf("RotateEncryptionKey() with an unreachable gist expected an error")
+	}
+	if currentKey, _ := keyring.GetKey(keyringServiceName(), "master_key"); !bytes.Equal(currentKey, oldKey) {
+		t.Errorf("master key changed although the gist could not be re-encrypted")
+	}
+	if server.fileContent(gistID, DefaultGistFileName) != before {
+		t.Errorf("gist was rewritten by a refused rotation")
+	}
+	if config, err := as.GetSyncConfig(); err != nil || config.GistID != gistID {
+		t.Errorf("local data unreadable after a refused rotation: %+v, %v", config, err)
+	}
+}
+
+func TestRequireEncryptionBlocksSyncUntilEncryptionIsOn(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+	if err := as.CanSync(); err != nil {
+		t.Fatalf("CanSync() without the setting error = %v", err)
+	}
+	if err := as.SetRequireEncryption(true); err != nil {
+		t.Fatalf("SetRequireEncryption() error = %v", err)
+	}
+
+	operations := map[string]func() error{
+		"CanSync":             as.CanSync,
+		"PushAllAgentsToGist": as.PushAllAgentsToGist,
+		"PushAgentToGist":     func() error { return as.PushAgentToGist("cursor") },
+		"PushToGist":          func() error { return as.PushToGist(nil) },
+		"ForcePush":           as.ForcePush,
+		"PullFromGist":        func() error { _, err := as.PullFromGist(); return err },
+		"PullFromGistMerge":   func() error { _, err := as.PullFromGistMerge(); return err },
+		"PullAgentFromGist":   func() error { return as.PullAgentFromGist("cursor") },
+		"ForcePull":           func() error { _, err := as.ForcePull(); return err },
+		"PlanSync":            func() error { _, err := as.PlanSync("pull"); return err },
+		"RunAutoSync":         func() error { _, err := as.RunAutoSync(); return err },
+		"DetectPushConflict":  func() error { _, err := as.DetectPushConflict(); return err },
+		"DetectPullConflict":  func() error { _, err := as.DetectPullConflict(); return err },
+		"ResolveConflict":     func() error { _, err := as.ResolveConflict("push_conflict", "merge"); return err },
+	}
+	for name, op := range operations {
+		if err := op(); !errors.Is(err, ErrEncryptionRequired) {
+			t.Errorf("%s() error = %v, want ErrEncryptionRequired", name, err)
+		}
+	}
+	if server.requestCount("GET") != 0 || server.requestCount("PATCH") != 0 {
+		t.Errorf("refused operations must not reach the gist: %d GET, %d PATCH", server.requestCount("GET"), server.requestCount("PATCH"))
+	}
+
+	// Turning encryption on with a key in the keyring unblocks syncing
+	as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	as.storage.crypto.dataDir = as.storage.GetDataDir()
+	if err := as.SetupGistEncryption(true, "test-password"); err != nil {
+		t.Fatalf("SetupGistEncryption() error = %v", err)
+	}
+	if err := as.CanSync(); err != nil {
+		t.Errorf("CanSync() with encryption on error = %v", err)
+	}
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Errorf("PushAllAgentsToGist() with encryption on error = %v", err)
+	}
+}
+
+func TestLoadSyncConfigKeyMissingDoesNotMintKey(t *testing.T) {
+	as := newTestAppService(t)
+	keyring := loseEncryptionKey(t, as)
+	lostKey, _ := keyring.GetKey("mcp-sync", "master_key")
+	keyring.DeleteKey("mcp-sync", "master_key")
+
+	// Same state with a plaintext config still flagged as encrypted
+	configPath := filepath.Join(as.storage.GetDataDir(), "sync_config.json")
+	for _, plaintext := range []bool{false, true} {
+		if plaintext {
+			os.WriteFile(configPath, []byte(`{"id": "default", "enable_encryption": true}`), 0644)
+		}
+		if _, err := as.GetSyncConfig(); !errors.Is(err, ErrEncryptionKeyMissing) {
+			t.Fatalf("GetSyncConfig() error = %v, want ErrEncryptionKeyMissing (plaintext config: %v)", err, plaintext)
+		}
+		if _, err := keyring.GetKey("mcp-sync", "master_key"); err == nil {
+			t.Fatalf("a new key must not be generated while encrypted files exist")
+		}
+	}
+
+	// Restoring the original key makes the history readable again
+	keyring.SetKey("mcp-sync", "master_key", lostKey)
+	versions, err := as.GetConfigVersions(10)
+	if err != nil || len(versions) != 1 || versions[0].Content != "encrypted history" {
+		t.Errorf("versions not readable after key restore: %+v, %v", versions, err)
+	}
+}
+
+func TestGistSetupKeepsConfigWhenKeyIsMissing(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+	as := newTestAppService(t)
+	keyring := loseEncryptionKey(t, as)
+	connectTestGist(t, as, "token-a", gistID)
+	keyring.DeleteKey("mcp-sync", "master_key")
+
+	configPath := filepath.Join(as.storage.GetDataDir(), "sync_config.json")
+	before := readFile(t, configPath)
+
+	operations := map[string]func() error{
+		"InitializeGistSyncDetailed": func() error { _, err := as.InitializeGistSyncDetailed("token-a", gistID); return err },
+		"SetupGistEncryption":        func() error { return as.SetupGistEncryption(true, "") },
+		"MigrateGist":                func() error { _, err := as.MigrateGist("token-a", false); return err },
+	}
+	for name, op := range operations {
+		if err := op(); !errors.Is(err, ErrEncryptionKeyMissing) {
+			t.Errorf("%s() error = %v, want ErrEncryptionKeyMissing", name, err)
+		}
+		if readFile(t, configPath) != before {
+			t.Fatalf("%s() overwrote the unreadable sync config", name)
+		}
+	}
+}
+
+func TestResetEncryptionSetsUnreadableFilesAside(t *testing.T) {
+	as := newTestAppService(t)
+	keyring := loseEncryptionKey(t, as)
+	keyring.DeleteKey("mcp-sync", "master_key")
+
+	orphanDir, err := as.ResetEncryption()
+	if err != nil {
+		t.Fatalf("ResetEncryption() error = %v", err)
+	}
+	if orphanDir == "" {
+		t.Fatalf("expected unreadable files to be moved aside")
+	}
+	if moved, _ := filepath.Glob(filepath.Join(orphanDir, "versions", "*.json")); len(moved) != 1 {
+		t.Errorf("expected the encrypted version in %s, got %v", orphanDir, moved)
+	}
+	if _, err := keyring.GetKey("mcp-sync", "master_key"); err != nil {
+		t.Errorf("a new key should be generated after reset")
+	}
+	if _, err := as.GetSyncConfig(); err != nil {
+		t.Errorf("GetSyncConfig() after reset error = %v", err)
+	}
+}
+
+func TestGetVersionContentAndDeleteVersion(t *testing.T) {
+	as := newTestAppService(t)
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "v1", Content: `{"cursor": {}}`})
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "v2", Content: `{"zed": {}}`})
+	corrupt := filepath.Join(as.storage.GetDataDir(), "versions", "version_1.json")
+	os.WriteFile(corrupt, []byte("ENC:other-key"), 0644)
+	as.updateMergeBase(map[string]interface{}{"cursor": map[string]interface{}{}}, "push")
+
+	if content, err := as.GetVersionContent("v1"); err != nil || content != `{"cursor": {}}` {
+		t.Errorf("GetVersionContent(v1) = %q, %v", content, err)
+	}
+	if content, err := as.GetVersionContent("merge_base"); err != nil || !strings.Contains(content, `"cursor"`) {
+		t.Errorf("GetVersionContent(merge_base) = %q, %v", content, err)
+	}
+	if _, err := as.GetVersionContent("version_1.json"); err == nil {
+		t.Errorf("GetVersionContent() of an undecryptable file should fail")
+	}
+	if _, err := as.GetVersionContent("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetVersionContent(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := as.GetVersionContent("../sync_config.json"); err == nil {
+		t.Errorf("GetVersionContent() must not read outside the versions directory")
+	}
+
+	// A regular version and a corrupt file (by file name) can be deleted
+	if err := as.DeleteVersion("v2"); err != nil {
+		t.Fatalf("DeleteVersion(v2) error = %v", err)
+	}
+	if err := as.DeleteVersion("version_1.json"); err != nil {
+		t.Fatalf("DeleteVersion(version_1.json) error = %v", err)
+	}
+	versions, err := as.GetConfigVersionsWithSkipped(10)
+	if err != nil || len(versions.Versions) != 1 || versions.Versions[0].ID != "v1" || versions.Skipped != 0 {
+		t.Errorf("versions after delete = %+v, %v", versions, err)
+	}
+
+	// The merge base is kept
+	if err := as.DeleteVersion("merge_base"); err == nil {
+		t.Errorf("DeleteVersion(merge_base) should fail")
+	}
+	if base, _ := as.GetMergeBase(); base == nil {
+		t.Errorf("merge base was removed")
+	}
+	if err := as.DeleteVersion("v2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second DeleteVersion(v2) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestUndecryptableHistoryIsReported(t *testing.T) {
+	as := newTestAppService(t)
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "good", Content: "readable"})
+	as.storage.SaveSyncLog(models.SyncLog{ID: "good", Action: "push"})
+	for _, sub := range []string{"versions", "logs"} {
+		os.WriteFile(filepath.Join(as.storage.GetDataDir(), sub, sub+"_1.json"), []byte("ENC:other-key"), 0644)
+	}
+
+	versions, err := as.GetConfigVersionsWithSkipped(10)
+	if err != nil {
+		t.Fatalf("GetConfigVersionsWithSkipped() error = %v", err)
+	}
+	if len(versions.Versions) != 1 || versions.Versions[0].ID != "good" || versions.Skipped != 1 {
+		t.Errorf("GetConfigVersionsWithSkipped() = %+v, want 1 version and 1 skipped", versions)
+	}
+
+	logs, err := as.GetSyncLogsWithSkipped(10)
+	if err != nil {
+		t.Fatalf("GetSyncLogsWithSkipped() error = %v", err)
+	}
+	if len(logs.Logs) != 1 || logs.Skipped != 1 {
+		t.Errorf("GetSyncLogsWithSkipped() = %+v, want 1 log and 1 skipped", logs)
+	}
+
+	broken, err := as.DiagnoseUndecryptable()
+	if err != nil {
+		t.Fatalf("DiagnoseUndecryptable() error = %v", err)
+	}
+	if len(broken) != 2 {
+		t.Fatalf("DiagnoseUndecryptable() = %v, want 2 entries", broken)
+	}
+	for _, entry := range broken {
+		if !strings.Contains(entry, "_1.json: ") || !strings.Contains(entry, "no decryption key") {
+			t.Errorf("entry should name the file and the reason: %q", entry)
+		}
+	}
+}
+
+func TestResolveConflictMergeCombinesArgs(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	remoteAgents := func(args ...string) map[string]interface{} {
+		return map[string]interface{}{"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fs": map[string]interface{}{"command": "npx", "args": args},
+		}}}
+	}
+	gistID := server.addGist("alice", remotePayload(t, remoteAgents("-y", "fs", "/work"), time.Now()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	as.updateMergeBase(remoteAgents("-y", "fs", "/home"), "pull")
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": "npx", "args": ["-y", "fs", "/home", "--readonly"]}}}`)
+
+	if _, err := as.ResolveConflict("push_conflict", "merge"); err != nil {
+		t.Fatalf("ResolveConflict(merge) error = %v", err)
+	}
+
+	var local map[string]map[string]map[string]interface{}
+	json.Unmarshal([]byte(readFile(t, path)), &local)
+	want := []interface{}{"-y", "fs", "/work", "--readonly"}
+	if got := local["mcpServers"]["fs"]["args"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("merged local args = %v, want %v", got, want)
+	}
+	if pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json")); !strings.Contains(pushed, "--readonly") || !strings.Contains(pushed, "/work") {
+		t.Errorf("merged config was not pushed: %s", pushed)
+	}
+}
+
+func TestResolveConflictReportsOutcome(t *testing.T) {
+	cursor := func(servers map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"cursor": map[string]interface{}{"mcpServers": servers}}
+	}
+	server := func(args ...string) map[string]interface{} {
+		return map[string]interface{}{"command": "node", "args": args}
+	}
+	remote := cursor(map[string]interface{}{"same": server("same.js"), "a": server("a.js", "--remote"), "r": server("r.js")})
+	local := `{"mcpServers": {"same": {"command": "node", "args": ["same.js"]}, "a": {"command": "node", "args": ["a.js", "--local"]}, "l": {"command": "node", "args": ["l.js"]}}}`
+
+	tests := []struct {
+		resolution string
+		want       models.ResolutionResult
+	}{
+		{"keep_local", models.ResolutionResult{Strategy: "keep_local", KeptLocal: 2, Unchanged: 1, Pushed: true}},
+		{"use_remote", models.ResolutionResult{Strategy: "use_remote", KeptRemote: 2, Unchanged: 1, Pulled: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resolution, func(t *testing.T) {
+			stub := newStubGistServer(t)
+			stub.addUser("token-a", "alice")
+			gistID := stub.addGist("alice", remotePayload(t, remote, time.Now()))
+			as := newTestAppService(t)
+			connectTestGist(t, as, "token-a", gistID)
+			writeAgentFile(t, as, "cursor", local)
+
+			result, err := as.ResolveConflict("push_conflict", tt.resolution)
+			if err != nil {
+				t.Fatalf("ResolveConflict(%s) error = %v", tt.resolution, err)
+			}
+			if result.VersionID == "" {
+				t.Errorf("ResolveConflict(%s) returned no version ID", tt.resolution)
+			}
+			result.VersionID = ""
+			if *result != tt.want {
+				t.Errorf("ResolveConflict(%s) = %+v, want %+v", tt.resolution, *result, tt.want)
+			}
+		})
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		stub := newStubGistServer(t)
+		stub.addUser("token-a", "alice")
+		// Since the common base, remote changed a, local changed b, and both changed different parts of c
+		gistID := stub.addGist("alice", remotePayload(t, cursor(map[string]interface{}{
+			"a": server("a.js", "--remote"), "b": server("b.js"), "c": server("-y", "fs", "/work"),
+		}), time.Now()))
+		as := newTestAppService(t)
+		connectTestGist(t, as, "token-a", gistID)
+		as.updateMergeBase(cursor(map[string]interface{}{
+			"a": server("a.js"), "b": server("b.js"), "c": server("-y", "fs", "/home"),
+		}), "pull")
+		writeAgentFile(t, as, "cursor", `{"mcpServers": {
+			"a": {"command": "node", "args": ["a.js"]},
+			"b": {"command": "node", "args": ["b.js", "--local"]},
+			"c": {"command": "node", "args": ["-y", "fs", "/home", "--readonly"]}
+		}}`)
+
+		result, err := as.ResolveConflict("push_conflict", "merge")
+		if err != nil {
+			t.Fatalf("ResolveConflict(merge) error = %v", err)
+		}
+		versions, _ := as.GetConfigVersions(1)
+		want := models.ResolutionResult{Strategy: "merge", KeptLocal: 1, KeptRemote: 1, Merged: 1, Pushed: true, Pulled: true, VersionID: versions[0].ID}
+		if *result != want {
+			t.Errorf("ResolveConflict(merge) = %+v, want %+v", *result, want)
+		}
+	})
+}
+
+func TestResolveConflictMergeReportsOverlap(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	remote := remotePayload(t, map[string]interface{}{"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+		"fs": map[string]interface{}{"command": "npx", "args": []string{"/remote"}},
+	}}}, time.Now())
+	gistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	original := `{"mcpServers": {"fs": {"command": "npx", "args": ["/local"]}}}`
+	path := writeAgentFile(t, as, "cursor", original)
+
+	_, err := as.ResolveConflict("push_conflict", "merge")
+	if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), "cursor/fs") {
+		t.Fatalf("ResolveConflict(merge) error = %v, want conflict on cursor/fs", err)
+	}
+	if readFile(t, path) != original {
+		t.Errorf("local config must be untouched when the merge conflicts")
+	}
+	if server.fileContent(gistID, "mcp-config.json") != remote {
+		t.Errorf("remote must be untouched when the merge conflicts")
+	}
+}
+
+// conflictingMerge sets up a merge that conflicts on cursor/fs with conflict files enabled and
+// returns the operation ID of the conflict file it wrote
+func conflictingMerge(t *testing.T) (*AppService, *stubGistServer, string, string, string) {
+	t.Helper()
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+		"fs":   map[string]interface{}{"command": "npx", "args": []string{"/remote"}},
+		"time": map[string]interface{}{"command": "uvx", "args": []string{"mcp-server-time"}},
+	}}}, time.Now()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	if err := as.SetConflictFiles(true); err != nil {
+		t.Fatalf("SetConflictFiles() error = %v", err)
+	}
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": "npx", "args": ["/local"]}}}`)
+
+	_, err := as.ResolveConflict("push_conflict", "merge")
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("ResolveConflict(merge) error = %v, want ErrConflict", err)
+	}
+	ids, err := as.ListConflictFiles()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("ListConflictFiles() = %v, %v, want one file", ids, err)
+	}
+	return as, server, gistID, path, ids[0]
+}
+
+func TestMergeWritesConflictFileAndAppliesResolution(t *testing.T) {
+	as, server, gistID, path, operationID := conflictingMerge(t)
+
+	file, err := as.storage.LoadConflictFile(operationID)
+	if err != nil {
+		t.Fatalf("LoadConflictFile() error = %v", err)
+	}
+	if len(file.Conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want only cursor/fs", file.Conflicts)
+	}
+	conflict := file.Conflicts[0]
+	if conflict.AgentID != "cursor" || conflict.Section != "mcpServers" || conflict.Server != "fs" {
+		t.Errorf("conflict = %+v, want cursor mcpServers fs", conflict)
+	}
+	localArgs := conflict.Local.(map[string]interface{})["args"]
+	remoteArgs := conflict.Remote.(map[string]interface{})["args"]
+	if !reflect.DeepEqual(localArgs, []interface{}{"/local"}) || !reflect.DeepEqual(remoteArgs, []interface{}{"/remote"}) {
+		t.Errorf("conflict should hold both variants: local %v, remote %v", conflict.Local, conflict.Remote)
+	}
+
+	// Resolve by hand, as a user editing the file would
+	file.Conflicts[0].Resolution = "custom"
+	file.Conflicts[0].Resolved = map[string]interface{}{"command": "npx", "args": []interface{}{"/local", "/remote"}}
+	if _, err := as.storage.SaveConflictFile(*file); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.ApplyResolvedConflicts(operationID); err != nil {
+		t.Fatalf("ApplyResolvedConflicts() error = %v", err)
+	}
+
+	var local map[string]map[string]map[string]interface{}
+	json.Unmarshal([]byte(readFile(t, path)), &local)
+	if got, want := local["mcpServers"]["fs"]["args"], []interface{}{"/local", "/remote"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resolved local args = %v, want %v", got, want)
+	}
+	if local["mcpServers"]["time"] == nil {
+		t.Errorf("non-conflicting remote server should be merged in: %v", local)
+	}
+	if pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json")); !strings.Contains(pushed, `"/local"`) || !strings.Contains(pushed, `"/remote"`) {
+		t.Errorf("resolved config was not pushed: %s", pushed)
+	}
+	if ids, _ := as.ListConflictFiles(); len(ids) != 0 {
+		t.Errorf("applied conflict file should be removed, still have %v", ids)
+	}
+}
+
+func TestApplyResolvedConflictsValidatesFile(t *testing.T) {
+	as, server, gistID, path, operationID := conflictingMerge(t)
+	original := readFile(t, path)
+	remote := server.fileContent(gistID, "mcp-config.json")
+
+	file, err := as.storage.LoadConflictFile(operationID)
+	if err != nil {
+		t.Fatalf("LoadConflictFile() error = %v", err)
+	}
+	resolve := func(resolution string, resolved interface{}) {
+		file.Conflicts[0].Resolution = resolution
+		file.Conflicts[0].Resolved = resolved
+		if _, err := as.storage.SaveConflictFile(*file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, edit := range map[string]func(){
+		"unresolved":            func() { resolve("", nil) },
+		"unknown resolution":    func() { resolve("both", nil) },
+		"custom without server": func() { resolve("custom", "npx /local") },
+		"custom missing command": func() {
+			resolve("custom", map[string]interface{}{"args": []interface{}{"/local"}})
+		},
+	} {
+		edit()
+		if err := as.ApplyResolvedConflicts(operationID); err == nil || !strings.Contains(err.Error(), "cursor/fs") {
+			t.Errorf("%s: ApplyResolvedConflicts() error = %v, want error naming cursor/fs", name, err)
+		}
+	}
+	if readFile(t, path) != original || server.fileContent(gistID, "mcp-config.json") != remote {
+		t.Errorf("nothing may be written when the conflict file is invalid")
+	}
+	if err := as.ApplyResolvedConflicts("../sync_config"); err == nil {
+		t.Errorf("ApplyResolvedConflicts() should reject a path as operation ID")
+	}
+	if err := as.ApplyResolvedConflicts("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ApplyResolvedConflicts(missing) error = %v, want ErrNotFound", err)
+	}
+
+	// A local edit after the conflict file was written makes it stale
+	resolve("local", nil)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": "npx", "args": ["/edited"]}}}`)
+	if err := as.ApplyResolvedConflicts(operationID); !errors.Is(err, ErrConflict) {
+		t.Errorf("stale ApplyResolvedConflicts() error = %v, want ErrConflict", err)
+	}
+	if server.fileContent(gistID, "mcp-config.json") != remote {
+		t.Errorf("remote must be untouched when the conflict file is stale")
+	}
+}
+
+func TestMergeBaseUpdatedOnlyOnSuccessfulSync(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"first": {"command": "node"}}}`)
+
+	if base, _ := as.GetMergeBase(); base != nil {
+		t.Fatalf("GetMergeBase() before any sync = %+v, want nil", base)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	base, err := as.GetMergeBase()
+	if err != nil || base == nil || !strings.Contains(base.Content, "first") {
+		t.Fatalf("GetMergeBase() after push = %+v, %v", base, err)
+	}
+
+	// A failed push leaves the base alone
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"second": {"command": "node"}}}`)
+	connectTestGist(t, as, "revoked", gistID)
+	if err := as.PushAllAgentsToGist(); err == nil {
+		t.Fatalf("expected push with a revoked token to fail")
+	}
+	if base, _ := as.GetMergeBase(); strings.Contains(base.Content, "second") {
+		t.Errorf("merge base must not change on a failed push")
+	}
+
+	// A pull replaces the base with the remote content
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"pulled": map[string]interface{}{"command": "node"}}},
+	}, time.Now()))
+	connectTestGist(t, as, "token-a", gistID)
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if base, _ := as.GetMergeBase(); base == nil || !strings.Contains(base.Content, "pulled") || base.Source != "pull" {
+		t.Errorf("GetMergeBase() after pull = %+v", base)
+	}
+}
+
+func TestMergeBaseAutoResolvesDivergence(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"a": {"command": "node", "args": ["a.js"]},
+		"b": {"command": "node", "args": ["b.js"]}
+	}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+
+	// Another machine changes server a; this machine changes server b
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"a": map[string]interface{}{"command": "node", "args": []string{"a.js", "--remote"}},
+			"b": map[string]interface{}{"command": "node", "args": []string{"b.js"}},
+		}},
+	}, time.Now()))
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"a": {"command": "node", "args": ["a.js"]},
+		"b": {"command": "node", "args": ["b.js", "--local"]}
+	}}`)
+
+	if _, err := as.ResolveConflict("push_conflict", "merge"); err != nil {
+		t.Fatalf("ResolveConflict(merge) error = %v", err)
+	}
+
+	content := readFile(t, path)
+	if !strings.Contains(content, "--remote") || !strings.Contains(content, "--local") {
+		t.Errorf("local config should contain both edits: %s", content)
+	}
+	if base, _ := as.GetMergeBase(); base == nil || base.Source != "merge" || !strings.Contains(base.Content, "--local") {
+		t.Errorf("merge base should be the merged result: %+v", base)
+	}
+}
+
+func TestApplyConfigToAllAgentsReportsFailedAgent(t *testing.T) {
+	as := newTestAppService(t)
+	claudePath := writeAgentFile(t, as, "claude-code", `{"mcpServers": {}}`)
+	// A directory where cursor's config file should be is detected but cannot be written
+	cursorPath, _ := as.detector.GetAgentConfigPath("cursor")
+	if err := os.MkdirAll(cursorPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := []models.MCPServer{{ID: "fetch", Name: "fetch", Command: "uvx", Args: []string{"mcp-server-fetch"}, Enabled: true}}
+	results, err := as.ApplyConfigToAllAgents(servers)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 agents") || !strings.Contains(err.Error(), "cursor:") {
+		t.Errorf("ApplyConfigToAllAgents() error = %v, want an aggregate error naming cursor", err)
+	}
+	if len(results) != 2 || results["cursor"] == nil || results["claude-code"] != nil {
+		t.Errorf("ApplyConfigToAllAgents() results = %v, want only cursor to fail", results)
+	}
+	if !errors.Is(err, results["cursor"]) {
+		t.Errorf("aggregate error does not wrap the cursor error")
+	}
+
+	// The failure did not stop the other agent from being written
+	if !strings.Contains(readFile(t, claudePath), "mcp-server-fetch") {
+		t.Errorf("claude-code config was not written: %s", readFile(t, claudePath))
+	}
+}
+
+func TestImportServersFromJSON(t *testing.T) {
+	as := newTestAppService(t)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"existing": {"command": "old"}}}`)
+	codexPath := writeAgentFile(t, as, "codex", "[mcp_servers.existing]\ncommand = \"old\"\n")
+
+	snippet := `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}}}`
+	results, err := as.ImportServersFromJSON([]byte(snippet), []string{"cursor", "codex"}, false)
+	if err != nil {
+		t.Fatalf("ImportServersFromJSON() error = %v", err)
+	}
+	for _, agentID := range []string{"cursor", "codex"} {
+		if results[agentID] != nil {
+			t.Errorf("import into %s failed: %v", agentID, results[agentID])
+		}
+	}
+
+	cursor := readFile(t, cursorPath)
+	if !strings.Contains(cursor, "fetch") || !strings.Contains(cursor, "existing") {
+		t.Errorf("cursor config missing imported or existing server: %s", cursor)
+	}
+	codex := readFile(t, codexPath)
+	if !strings.Contains(codex, "[mcp_servers.fetch]") || !strings.Contains(codex, "mcp-server-fetch") {
+		t.Errorf("codex config missing imported server: %s", codex)
+	}
+	if !strings.Contains(codex, "[mcp_servers.existing]") {
+		t.Errorf("codex config lost existing server: %s", codex)
+	}
+}
+
+func TestImportServersFromJSONReportsDuplicates(t *testing.T) {
+	as := newTestAppService(t)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "old"}}}`)
+
+	snippet := `{"context_servers": {"fetch": {"command": {"path": "new", "args": []}}}}`
+	results, err := as.ImportServersFromJSON([]byte(snippet), []string{"cursor"}, false)
+	if err != nil {
+		t.Fatalf("ImportServersFromJSON() error = %v", err)
+	}
+	if results["cursor"] == nil || !strings.Contains(results["cursor"].Error(), "fetch") {
+		t.Fatalf("expected duplicate error naming fetch, got %v", results["cursor"])
+	}
+	if content := readFile(t, path); !strings.Contains(content, `"old"`) {
+		t.Errorf("duplicate server was overwritten without the flag: %s", content)
+	}
+
+	results, err = as.ImportServersFromJSON([]byte(snippet), []string{"cursor"}, true)
+	if err != nil || results["cursor"] != nil {
+		t.Fatalf("overwrite import error = %v, %v", err, results["cursor"])
+	}
+	if content := readFile(t, path); !strings.Contains(content, `"new"`) {
+		t.Errorf("overwrite did not replace server: %s", content)
+	}
+}
+
+func TestImportServersFromJSONLogsFailedAgents(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "old"}}}`)
+	writeAgentFile(t, as, "codex", "model = \"o3\"\n")
+	snippet := `{"mcpServers": {"fetch": {"command": "uvx"}}}`
+
+	if _, err := as.ImportServersFromJSON([]byte(snippet), []string{"cursor", "codex"}, false); err != nil {
+		t.Fatalf("ImportServersFromJSON() error = %v", err)
+	}
+	entry := findSyncLog(t, as, "import")
+	if entry == nil || entry.Status != "partial" || !strings.Contains(entry.Details, "cursor") || strings.Contains(entry.Details, "codex") {
+		t.Errorf("import log = %+v, want partial naming cursor", entry)
+	}
+
+	if _, err := as.ImportServersFromJSON([]byte(snippet), []string{"cursor", "codex"}, false); err != nil {
+		t.Fatalf("ImportServersFromJSON() error = %v", err)
+	}
+	if entry := findSyncLog(t, as, "import"); entry == nil || entry.Status != "failed" {
+		t.Errorf("import log with every agent failing = %+v, want failed", entry)
+	}
+}
+
+func TestImportServersFromJSONRejectsUnknownShape(t *testing.T) {
+	as := newTestAppService(t)
+	if _, err := as.ImportServersFromJSON([]byte(`{"foo": 1}`), []string{"cursor"}, false); err == nil {
+		t.Error("expected error for unrecognized snippet")
+	}
+}
+
+// divergeForAutoSync pushes a common base, then edits the cursor config locally and in the gist
+// with the given modification times
+func divergeForAutoSync(t *testing.T, policy string, localTime, remoteTime time.Time) (*AppService, *stubGistServer, string, string) {
+	t.Helper()
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"base": {"command": "base"}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if err := as.SetConflictPolicy(policy); err != nil {
+		t.Fatalf("SetConflictPolicy() error = %v", err)
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"local-edit": {"command": "local"}}}`)
+	if err := os.Chtimes(path, localTime, localTime); err != nil {
+		t.Fatal(err)
+	}
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"remote-edit": map[string]interface{}{"command": "remote"}}},
+	}, remoteTime))
+	return as, server, gistID, path
+}
+
+func TestAutoSyncPreferNewerPicksRemote(t *testing.T) {
+	now := time.Now()
+	as, _, _, path := divergeForAutoSync(t, ConflictPolicyPreferNewer, now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	result, err := as.RunAutoSync()
+	if err != nil {
+		t.Fatalf("RunAutoSync() error = %v", err)
+	}
+	if result.Action != "pull" || result.Conflict != nil {
+		t.Fatalf("RunAutoSync() = %+v, want pull without conflict", result)
+	}
+	if content := readFile(t, path); !strings.Contains(content, "remote-edit") {
+		t.Errorf("newer remote config was not applied: %s", content)
+	}
+}
+
+func TestAutoSyncPreferNewerPicksLocal(t *testing.T) {
+	now := time.Now()
+	as, server, gistID, _ := divergeForAutoSync(t, ConflictPolicyPreferNewer, now, now.Add(-3*time.Hour))
+
+	// The manual flow gets the same choice pre-selected
+	if conflict, err := as.DetectPushConflict(); err != nil || conflict.SuggestedResolution != "keep_local" {
+		t.Errorf("DetectPushConflict() = %+v, %v, want keep_local suggested", conflict, err)
+	}
+
+	result, err := as.RunAutoSync()
+	if err != nil {
+		t.Fatalf("RunAutoSync() error = %v", err)
+	}
+	if result.Action != "push" {
+		t.Fatalf("RunAutoSync() = %+v, want push", result)
+	}
+	if pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json")); !strings.Contains(pushed, "local-edit") {
+		t.Errorf("newer local config was not pushed: %s", pushed)
+	}
+}
+
+func TestAutoSyncManualSurfacesConflict(t *testing.T) {
+	now := time.Now()
+	as, server, gistID, path := divergeForAutoSync(t, ConflictPolicyManual, now, now.Add(-time.Hour))
+	remoteBefore := server.fileContent(gistID, "mcp-config.json")
+
+	result, err := as.RunAutoSync()
+	if err != nil {
+		t.Fatalf("RunAutoSync() error = %v", err)
+	}
+	if result.Action != "conflict" || result.Conflict == nil || !result.Conflict.HasConflict {
+		t.Fatalf("RunAutoSync() = %+v, want a conflict", result)
+	}
+	if content := readFile(t, path); !strings.Contains(content, "local-edit") {
+		t.Errorf("manual policy changed the local config: %s", content)
+	}
+	if server.fileContent(gistID, "mcp-config.json") != remoteBefore {
+		t.Errorf("manual policy changed the gist")
+	}
+
+	if err := as.SetConflictPolicy("coin_flip"); err == nil {
+		t.Error("expected error for an unknown policy")
+	}
+}
+
+func TestAutoSyncOneSidedChangeNeedsNoPolicy(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"base": {"command": "base"}}}`)
+
+	// Empty gist: the first cycle pushes
+	result, err := as.RunAutoSync()
+	if err != nil || result.Action != "push" {
+		t.Fatalf("RunAutoSync() = %+v, %v, want push", result, err)
+	}
+	result, err = as.RunAutoSync()
+	if err != nil || result.Action != "none" {
+		t.Fatalf("RunAutoSync() = %+v, %v, want none", result, err)
+	}
+
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"remote-edit": map[string]interface{}{"command": "remote"}}},
+	}, time.Now()))
+	result, err = as.RunAutoSync()
+	if err != nil || result.Action != "pull" {
+		t.Fatalf("RunAutoSync() = %+v, %v, want pull under the default manual policy", result, err)
+	}
+	if content := readFile(t, path); !strings.Contains(content, "remote-edit") {
+		t.Errorf("remote-only change was not applied: %s", content)
+	}
+}
+
+func TestValidateStoredCredentials(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	if status, err := as.ValidateStoredCredentials(); err != nil || status.Status != CredStatusNotConfigured {
+		t.Fatalf("ValidateStoredCredentials() before setup = %+v, %v, want not_configured", status, err)
+	}
+
+	connectTestGist(t, as, "token-a", gistID)
+	status, err := as.ValidateStoredCredentials()
+	if err != nil || status.Status != CredStatusValid || status.Transient {
+		t.Fatalf("ValidateStoredCredentials() = %+v, %v, want valid", status, err)
+	}
+	if got := as.GetCredentialStatus(); got == nil || got.Status != CredStatusValid {
+		t.Errorf("GetCredentialStatus() = %+v, want the last check", got)
+	}
+
+	server.revokeToken("token-a")
+	status, err = as.ValidateStoredCredentials()
+	if err != nil || status.Status != CredStatusExpired || status.Transient || !strings.Contains(status.Message, "re-authenticate") {
+		t.Fatalf("ValidateStoredCredentials() with revoked token = %+v, %v, want expired", status, err)
+	}
+	// Auto-sync stops before touching the gist instead of failing mid-sync
+	patches := server.requestCount(http.MethodPatch)
+	if _, err := as.RunAutoSync(); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("RunAutoSync() with revoked token error = %v, want ErrUnauthorized", err)
+	}
+	if server.requestCount(http.MethodPatch) != patches {
+		t.Errorf("RunAutoSync() must not write with a revoked token")
+	}
+
+	server.Close()
+	status, err = as.ValidateStoredCredentials()
+	if err != nil || status.Status != CredStatusUnreachable || !status.Transient {
+		t.Fatalf("ValidateStoredCredentials() without network = %+v, %v, want transient unreachable", status, err)
+	}
+}
+
+func TestEnvironmentOverlaysApplyPerDevice(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+		"fs": map[string]interface{}{"command": "npx", "args": []string{"-y", "fs", "/data"}},
+	}}}, time.Now()))
+
+	// device configures a machine with its own environment name and pulls
+	device := func(environment string) (*AppService, string) {
+		as := newTestAppService(t)
+		connectTestGist(t, as, "token-a", gistID)
+		if err := as.SetEnvironmentName(environment); err != nil {
+			t.Fatalf("SetEnvironmentName() error = %v", err)
+		}
+		path := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+		if _, err := as.PullFromGist(); err != nil {
+			t.Fatalf("PullFromGist() on %s error = %v", environment, err)
+		}
+		return as, path
+	}
+	fsArgs := func(path string) interface{} {
+		var config map[string]map[string]map[string]interface{}
+		json.Unmarshal([]byte(readFile(t, path)), &config)
+		return config["mcpServers"]["fs"]["args"]
+	}
+
+	work, _ := device("work")
+	for name, args := range map[string][]interface{}{
+		"work": {"-y", "fs", "/work/projects"},
+		"home": {"-y", "fs", "/home/jane"},
+	} {
+		overlay := map[string]interface{}{"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fs": map[string]interface{}{"args": args},
+		}}}
+		if err := work.SetEnvironmentOverlay(name, overlay); err != nil {
+			t.Fatalf("SetEnvironmentOverlay(%s) error = %v", name, err)
+		}
+	}
+
+	_, workPath := device("work")
+	if got, want := fsArgs(workPath), []interface{}{"-y", "fs", "/work/projects"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("work args = %v, want %v", got, want)
+	}
+	_, otherPath := device("laptop")
+	if got, want := fsArgs(otherPath), []interface{}{"-y", "fs", "/data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("device without an overlay args = %v, want the base %v", got, want)
+	}
+	home, homePath := device("home")
+	if got, want := fsArgs(homePath), []interface{}{"-y", "fs", "/home/jane"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("home args = %v, want %v", got, want)
+	}
+
+	// home is the current device. Pushing from it keeps the base and every overlay, and still carries its other edits
+	writeAgentFile(t, home, "cursor", `{"mcpServers": {"fs": {"command": "npx", "args": ["-y", "fs", "/home/jane"]}, "time": {"command": "uvx"}}}`)
+	if err := home.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	var pushed struct {
+		Agents   map[string]map[string]map[string]map[string]interface{} `json:"agents"`
+		Overlays map[string]interface{}                                  `json:"overlays"`
+	}
+	json.Unmarshal([]byte(decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))), &pushed)
+	base := pushed.Agents["cursor"]["mcpServers"]
+	if !reflect.DeepEqual(base["fs"]["args"], []interface{}{"-y", "fs", "/data"}) || base["time"] == nil {
+		t.Errorf("pushed base = %v, want fs on /data plus the new time server", base)
+	}
+	if len(pushed.Overlays) != 2 {
+		t.Errorf("pushed overlays = %v, want work and home kept", pushed.Overlays)
+	}
+}
+
+func TestRemoveOverlayRestoresBaseValues(t *testing.T) {
+	base := map[string]interface{}{"fs": map[string]interface{}{"command": "npx", "env": map[string]interface{}{"ROOT": "/data", "MODE": "ro"}}}
+	patch := map[string]interface{}{
+		"fs":    map[string]interface{}{"env": map[string]interface{}{"ROOT": "/work", "MODE": nil}},
+		"local": map[string]interface{}{"command": "work-only"},
+	}
+
+	applied := applyOverlay(base, patch)
+	want := map[string]interface{}{
+		"fs":    map[string]interface{}{"command": "npx", "env": map[string]interface{}{"ROOT": "/work"}},
+		"local": map[string]interface{}{"command": "work-only"},
+	}
+	if !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applyOverlay() = %v, want %v", applied, want)
+	}
+	if got := removeOverlay(applied, patch, base); !reflect.DeepEqual(got, base) {
+		t.Errorf("removeOverlay(applyOverlay()) = %v, want the base %v", got, base)
+	}
+
+	// A server deleted locally stays deleted
+	if got := removeOverlay(map[string]interface{}{}, patch, base); len(got) != 0 {
+		t.Errorf("removeOverlay() resurrected deleted servers: %v", got)
+	}
+}
+
+func TestSetAllServersEnabledRestoresPriorSet(t *testing.T) {
+	as := newTestAppService(t)
+	original := `{"mcpServers": {
+		"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]},
+		"git": {"command": "git-mcp"},
+		"legacy": {"command": "old", "disabled": true}
+	}, "theme": "dark"}`
+	path := writeAgentFile(t, as, "cursor", original)
+
+	serversOnDisk := func() map[string]interface{} {
+		t.Helper()
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(readFile(t, path)), &config); err != nil {
+			t.Fatal(err)
+		}
+		if config["theme"] != "dark" {
+			t.Errorf("other settings were lost: %v", config)
+		}
+		servers, _ := config["mcpServers"].(map[string]interface{})
+		return servers
+	}
+	var want map[string]interface{}
+	json.Unmarshal([]byte(original), &want)
+
+	if err := as.SetAllServersEnabled("cursor", false); err != nil {
+		t.Fatalf("SetAllServersEnabled(false) error = %v", err)
+	}
+	disabled := serversOnDisk()
+	if len(disabled) != 3 {
+		t.Fatalf("disabling must keep every server, got %v", disabled)
+	}
+	for name, server := range disabled {
+		if !serverDisabled(server.(map[string]interface{})) {
+			t.Errorf("server %s is still enabled", name)
+		}
+	}
+	backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "cursor_*"))
+	if len(backups) != 1 {
+		t.Errorf("expected one backup before writing, got %v", backups)
+	}
+
+	if err := as.SetAllServersEnabled("cursor", true); err != nil {
+		t.Fatalf("SetAllServersEnabled(true) error = %v", err)
+	}
+	if got := serversOnDisk(); !reflect.DeepEqual(got, want["mcpServers"]) {
+		t.Errorf("re-enabling should restore the prior set:\n got %v\nwant %v", got, want["mcpServers"])
+	}
+	if config, _ := as.GetSyncConfig(); len(config.ToggledOff) != 0 {
+		t.Errorf("toggle record should be cleared after re-enabling: %v", config.ToggledOff)
+	}
+}
+
+func TestSetAllServersEnabledZedAndCodex(t *testing.T) {
+	as := newTestAppService(t)
+	zedPath := writeAgentFile(t, as, "zed", `{"context_servers": {"fetch": {"source": "custom", "enabled": true, "command": "uvx"}}}`)
+	codexPath := writeAgentFile(t, as, "codex", "[mcp_servers.fetch]\ncommand = \"uvx\"\n")
+
+	for _, agentID := range []string{"zed", "codex"} {
+		if err := as.SetAllServersEnabled(agentID, false); err != nil {
+			t.Fatalf("SetAllServersEnabled(%s, false) error = %v", agentID, err)
+		}
+	}
+	if zed := readFile(t, zedPath); !strings.Contains(zed, `"enabled": false`) || !strings.Contains(zed, `"uvx"`) {
+		t.Errorf("zed settings after disabling = %s", zed)
+	}
+	if codex := readFile(t, codexPath); !strings.Contains(codex, "enabled = false") || !strings.Contains(codex, `"uvx"`) {
+		t.Errorf("codex config after disabling = %s", codex)
+	}
+
+	for _, agentID := range []string{"zed", "codex"} {
+		if err := as.SetAllServersEnabled(agentID, true); err != nil {
+			t.Fatalf("SetAllServersEnabled(%s, true) error = %v", agentID, err)
+		}
+	}
+	if zed := readFile(t, zedPath); !strings.Contains(zed, `"enabled": true`) {
+		t.Errorf("zed settings after re-enabling = %s", zed)
+	}
+	if codex := readFile(t, codexPath); strings.Contains(codex, "enabled") {
+		t.Errorf("codex config after re-enabling = %s", codex)
+	}
+}
+
+func TestCompareAgents(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"fetch": {"command": "uvx", "args": ["mcp-server-fetch"], "env": {"LOG": "debug", "ONLY_A": "1"}},
+		"git": {"command": "git-mcp"},
+		"same": {"command": "same-mcp"}
+	}}`)
+	writeAgentFile(t, as, "zed", `{"context_servers": {
+		"fetch": {"source": "custom", "enabled": true, "command": "uvx", "args": ["mcp-server-fetch"], "env": {"LOG": "info"}},
+		"db": {"source": "custom", "enabled": true, "command": "db-mcp"},
+		"same": {"source": "custom", "enabled": true, "command": "same-mcp"}
+	}}`)
+
+	comparison, err := as.CompareAgents("cursor", "zed")
+	if err != nil {
+		t.Fatalf("CompareAgents() error = %v", err)
+	}
+	if !reflect.DeepEqual(comparison.OnlyInA, []string{"git"}) || !reflect.DeepEqual(comparison.OnlyInB, []string{"db"}) {
+		t.Errorf("only in A = %v, only in B = %v", comparison.OnlyInA, comparison.OnlyInB)
+	}
+	// Zed's source/enabled fields are format details, not differences
+	if !reflect.DeepEqual(comparison.Identical, []string{"same"}) {
+		t.Errorf("identical = %v, want [same]", comparison.Identical)
+	}
+
+	if len(comparison.Different) != 1 || comparison.Different[0].Name != "fetch" {
+		t.Fatalf("different = %+v, want only fetch", comparison.Different)
+	}
+	want := []models.FieldDifference{
+		{Field: "env.LOG", A: "debug", B: "info"},
+		{Field: "env.ONLY_A", A: "1", B: nil},
+	}
+	if got := comparison.Different[0].Fields; !reflect.DeepEqual(got, want) {
+		t.Errorf("fetch field differences = %+v, want %+v", got, want)
+	}
+}
+
+func TestGetAgentCatalog(t *testing.T) {
+	as := newTestAppService(t)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}, "git": {"command": "git-mcp"}}}`)
+	writeAgentFile(t, as, "zed", `{"theme": "One Dark", "context_servers": {}}`)
+
+	catalog, err := as.GetAgentCatalog()
+	if err != nil {
+		t.Fatalf("GetAgentCatalog() error = %v", err)
+	}
+	if len(catalog) != len(as.configLoader.GetAgentDefinitions()) {
+		t.Errorf("catalog has %d entries, want one per definition (%d)", len(catalog), len(as.configLoader.GetAgentDefinitions()))
+	}
+	entries := make(map[string]models.AgentCatalogEntry)
+	for _, entry := range catalog {
+		entries[entry.ID] = entry
+	}
+
+	cursor := entries["cursor"]
+	if cursor.Status != "detected" || !cursor.HasServers || cursor.ServerCount != 2 {
+		t.Errorf("cursor entry = %+v, want detected with 2 servers", cursor)
+	}
+	if len(cursor.ExistingPaths) != 1 || cursor.ExistingPaths[0] != cursorPath || cursor.ConfigKey != "mcpServers" {
+		t.Errorf("cursor paths = %v, key = %q", cursor.ExistingPaths, cursor.ConfigKey)
+	}
+
+	windsurf := entries["windsurf"]
+	if windsurf.Name == "" || windsurf.Status != "not_installed" || windsurf.HasServers || len(windsurf.ConfigPaths) == 0 {
+		t.Errorf("windsurf entry = %+v, want a known agent that is not installed", windsurf)
+	}
+
+	zed := entries["zed"]
+	if zed.Status != "detected" || zed.HasServers || zed.ServerCount != 0 || zed.Format != "zed" || zed.Error != "" {
+		t.Errorf("zed entry = %+v, want detected with no servers", zed)
+	}
+}
+
+func TestGetAgentMCPConfigDetailedReportsFormat(t *testing.T) {
+	as := newTestAppService(t)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+	codexPath := writeAgentFile(t, as, "codex", "[mcp_servers.fetch]\ncommand = \"uvx\"\n")
+
+	tests := []struct {
+		agentID    string
+		path       string
+		wantFormat string
+		wantKey    string
+	}{
+		{agentID: "cursor", path: cursorPath, wantFormat: "json", wantKey: "mcpServers"},
+		{agentID: "codex", path: codexPath, wantFormat: "toml", wantKey: "mcp_servers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.agentID, func(t *testing.T) {
+			result, err := as.GetAgentMCPConfigDetailed(tt.agentID)
+			if err != nil {
+				t.Fatalf("GetAgentMCPConfigDetailed() error = %v", err)
+			}
+			if result.Format != tt.wantFormat || result.ConfigKey != tt.wantKey || result.ConfigPath != tt.path {
+				t.Errorf("got format=%q key=%q path=%q, want %q %q %q",
+					result.Format, result.ConfigKey, result.ConfigPath, tt.wantFormat, tt.wantKey, tt.path)
+			}
+			servers, ok := result.Servers[tt.wantKey].(map[string]interface{})
+			if !ok || servers["fetch"] == nil {
+				t.Errorf("servers = %+v, want fetch under %s", result.Servers, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestGetAgentMCPConfigReadsCodexTOML(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "codex", "[mcp_servers.fetch]\ncommand = \"uvx\"\nargs = [\"mcp-server-fetch\"]\n\n[mcp_servers.fetch.env]\nLOG = \"debug\"\n")
+
+	config, err := as.GetAgentMCPConfig("codex")
+	if err != nil {
+		t.Fatalf("GetAgentMCPConfig() error = %v", err)
+	}
+	servers, ok := config["mcp_servers"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected servers under mcp_servers, got %+v", config)
+	}
+	fetch, ok := servers["fetch"].(map[string]interface{})
+	if !ok || fetch["command"] != "uvx" {
+		t.Fatalf("fetch server = %+v", servers["fetch"])
+	}
+	if args, _ := fetch["args"].([]interface{}); len(args) != 1 || args[0] != "mcp-server-fetch" {
+		t.Errorf("args = %+v", fetch["args"])
+	}
+	if env, _ := fetch["env"].(map[string]interface{}); env["LOG"] != "debug" {
+		t.Errorf("env = %+v", fetch["env"])
+	}
+}
+
+func TestPlanSyncPushListsReadsAndConversions(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"fetch": {"command": "uvx"},
+		"wrapped": {"command": "cmd", "args": ["/c", "npx", "-y", "pkg"]}
+	}}`)
+	writeAgentFile(t, as, "codex", "[mcp_servers.fs]\ncommand = \"npx\"\n")
+
+	plan, err := as.PlanSync("push")
+	if err != nil {
+		t.Fatalf("PlanSync(push) error = %v", err)
+	}
+	steps := make(map[string]models.AgentSyncPlan)
+	for _, step := range plan.Agents {
+		steps[step.AgentID] = step
+	}
+
+	cursor := steps["cursor"]
+	if cursor.Action != "read" || cursor.Conversion != "" || !reflect.DeepEqual(cursor.Servers, []string{"fetch", "wrapped"}) {
+		t.Errorf("cursor step = %+v", cursor)
+	}
+	if !reflect.DeepEqual(cursor.WindowsWrapped, []string{"wrapped"}) {
+		t.Errorf("cursor WindowsWrapped = %v, want [wrapped]", cursor.WindowsWrapped)
+	}
+	codex := steps["codex"]
+	if codex.Action != "read" || codex.Conversion != "codex_toml -> standard" || len(codex.Dropped) != 0 {
+		t.Errorf("codex step = %+v", codex)
+	}
+
+	if _, err := as.PlanSync("sideways"); err == nil {
+		t.Errorf("PlanSync() with an unknown direction should fail")
+	}
+}
+
+func TestPlanSyncPullFlagsLossyCodexWritesWithoutApplying(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"codex": map[string]interface{}{"mcp_servers": map[string]interface{}{
+			"local":  map[string]interface{}{"command": "uvx"},
+			"remote": map[string]interface{}{"type": "http", "url": "https://example.com/mcp"},
+			"sse":    map[string]interface{}{"url": "https://example.com/sse"},
+		}},
+		"zed": map[string]interface{}{"context_servers": map[string]interface{}{
+			"fetch": map[string]interface{}{"source": "custom", "command": "uvx"},
+		}},
+		"unknown-agent": map[string]interface{}{"mcpServers": map[string]interface{}{}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	codexPath := writeAgentFile(t, as, "codex", "model = \"o3\"\n")
+
+	plan, err := as.PlanSync("pull")
+	if err != nil {
+		t.Fatalf("PlanSync(pull) error = %v", err)
+	}
+	steps := make(map[string]models.AgentSyncPlan)
+	for _, step := range plan.Agents {
+		steps[step.AgentID] = step
+	}
+
+	codex := steps["codex"]
+	if codex.Action != "write" || codex.Conversion != "standard -> codex_toml" || codex.ConfigPath != codexPath {
+		t.Errorf("codex step = %+v", codex)
+	}
+	if !reflect.DeepEqual(codex.Dropped, []string{"remote", "sse"}) {
+		t.Errorf("codex Dropped = %v, want [remote sse]", codex.Dropped)
+	}
+	if zed := steps["zed"]; zed.Action != "write" || zed.Conversion != "" || len(zed.Dropped) != 0 {
+		t.Errorf("zed step = %+v", zed)
+	}
+	if unknown := steps["unknown-agent"]; unknown.Action != "skip" || unknown.Reason == "" {
+		t.Errorf("unknown agent step = %+v", unknown)
+	}
+
+	// A plan never applies anything
+	if content := readFile(t, codexPath); content != "model = \"o3\"\n" {
+		t.Errorf("PlanSync() modified the codex config: %q", content)
+	}
+	if log := findSyncLog(t, as, "pull"); log != nil {
+		t.Errorf("PlanSync() recorded a pull: %+v", log)
+	}
+}
+
+func TestPullAgentFromGistLeavesOtherAgentsUntouched(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor":   map[string]interface{}{"mcpServers": map[string]interface{}{"remote-cursor": map[string]interface{}{"command": "remote"}}},
+		"windsurf": map[string]interface{}{"mcpServers": map[string]interface{}{"remote-windsurf": map[string]interface{}{"command": "remote"}}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"local-cursor": {"command": "local"}}, "theme": "dark"}`)
+	windsurfOriginal := `{"mcpServers": {"local-windsurf": {"command": "local"}}}`
+	windsurfPath := writeAgentFile(t, as, "windsurf", windsurfOriginal)
+
+	if err := as.PullAgentFromGist("cursor"); err != nil {
+		t.Fatalf("PullAgentFromGist() error = %v", err)
+	}
+
+	cursor := readFile(t, cursorPath)
+	if !strings.Contains(cursor, "remote-cursor") || strings.Contains(cursor, "local-cursor") || !strings.Contains(cursor, "theme") {
+		t.Errorf("cursor config after pull = %s", cursor)
+	}
+	if windsurf := readFile(t, windsurfPath); windsurf != windsurfOriginal {
+		t.Errorf("windsurf config changed: %s", windsurf)
+	}
+	backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "cursor_*"))
+	if len(backups) != 1 {
+		t.Errorf("expected one cursor backup, got %v", backups)
+	}
+	// Only the pulled agent is recorded in the merge base
+	if base, _ := as.GetMergeBase(); base == nil || !strings.Contains(base.Content, "remote-cursor") || strings.Contains(base.Content, "windsurf") {
+		t.Errorf("merge base after a single-agent pull = %+v, want only cursor", base)
+	}
+
+	if err := as.PullAgentFromGist("codex"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("PullAgentFromGist(codex) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestPushAgentToGistReplacesOnlyThatAgent(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor":   map[string]interface{}{"mcpServers": map[string]interface{}{"remote-cursor": map[string]interface{}{"command": "remote"}}},
+		"windsurf": map[string]interface{}{"mcpServers": map[string]interface{}{"remote-windsurf": map[string]interface{}{"command": "remote"}}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"local-cursor": {"command": "local"}}}`)
+	writeAgentFile(t, as, "windsurf", `{"mcpServers": {"local-windsurf": {"command": "local"}}}`)
+
+	if err := as.PushAgentToGist("cursor"); err != nil {
+		t.Fatalf("PushAgentToGist() error = %v", err)
+	}
+
+	remote, err := newTestGistSync("token-a", gistID).PullAgentConfigsFromGist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := agentServers(remote["cursor"], "mcpServers")
+	if cursor["local-cursor"] == nil || cursor["remote-cursor"] != nil {
+		t.Errorf("remote cursor entry = %+v, want the local config", cursor)
+	}
+	windsurf := agentServers(remote["windsurf"], "mcpServers")
+	if windsurf["remote-windsurf"] == nil || windsurf["local-windsurf"] != nil {
+		t.Errorf("remote windsurf entry changed: %+v", windsurf)
+	}
+	if log := findSyncLog(t, as, "push_agent"); log == nil || log.Status != "success" {
+		t.Errorf("push_agent log = %+v", log)
+	}
+}
+
+func TestNormalizeAgentConfigIsIdempotent(t *testing.T) {
+	as := newTestAppService(t)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"theme":"dark",   "mcpServers": {
+    "zeta": {"env": {}, "command": "zeta-mcp", "args": []},
+	"fetch": {"env": {"Z": "1", "A": "2"}, "args": ["mcp-server-fetch"], "command": "uvx"}
+}}`)
+	codexPath := writeAgentFile(t, as, "codex", "model = \"o3\"\n\n[mcp_servers.zeta]\ncommand = \"zeta-mcp\"\nargs = []\n\n[mcp_servers.fetch]\ncommand = \"uvx\"\nenv = { \"Z\" = \"1\", \"A\" = \"2\" }\n")
+
+	for _, agentID := range []string{"cursor", "codex"} {
+		before, err := as.standardAgentServers(agentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := as.NormalizeAgentConfig(agentID); err != nil {
+			t.Fatalf("NormalizeAgentConfig(%s) error = %v", agentID, err)
+		}
+		after, err := as.standardAgentServers(agentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !jsonEqual(dropEmptyServerFields(before), after) {
+			t.Errorf("%s servers changed: before %#v, after %#v", agentID, before, after)
+		}
+	}
+
+	cursor := readFile(t, cursorPath)
+	if strings.Contains(cursor, `"args": []`) || strings.Contains(cursor, `"env": {}`) {
+		t.Errorf("empty args/env were kept:\n%s", cursor)
+	}
+	if strings.Index(cursor, `"fetch"`) > strings.Index(cursor, `"zeta"`) || !strings.Contains(cursor, `"theme": "dark"`) {
+		t.Errorf("cursor config is not canonical:\n%s", cursor)
+	}
+	codex := readFile(t, codexPath)
+	if !strings.Contains(codex, `model = "o3"`) || strings.Index(codex, "mcp_servers.fetch") > strings.Index(codex, "mcp_servers.zeta") {
+		t.Errorf("codex config is not canonical:\n%s", codex)
+	}
+
+	for _, agentID := range []string{"cursor", "codex"} {
+		if err := as.NormalizeAgentConfig(agentID); err != nil {
+			t.Fatalf("second NormalizeAgentConfig(%s) error = %v", agentID, err)
+		}
+	}
+	if got := readFile(t, cursorPath); got != cursor {
+		t.Errorf("normalizing cursor twice changed it:\n%s\nvs\n%s", cursor, got)
+	}
+	if got := readFile(t, codexPath); got != codex {
+		t.Errorf("normalizing codex twice changed it:\n%s\nvs\n%s", codex, got)
+	}
+	backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "*"))
+	if len(backups) != 2 {
+		t.Errorf("expected one backup per agent, got %v", backups)
+	}
+}
+
+func TestDisabledServersRoundTripThroughSync(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"fetch": {"command": "uvx"},
+		"git": {"command": "git-mcp", "disabled": true}
+	}}`)
+
+	pushedAgents := func() map[string]interface{} {
+		t.Helper()
+		var payload struct {
+			Agents map[string]interface{} `json:"agents"`
+		}
+		if err := json.Unmarshal([]byte(decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))), &payload); err != nil {
+			t.Fatal(err)
+		}
+		return payload.Agents
+	}
+	disabledIn := func(agents map[string]interface{}) map[string]interface{} {
+		cursor, _ := agents["cursor"].(map[string]interface{})
+		disabled, _ := cursor[disabledServersKey].(map[string]interface{})
+		return disabled
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	want := map[string]interface{}{"git": map[string]interface{}{"command": "git-mcp"}}
+	if got := disabledIn(pushedAgents()); !reflect.DeepEqual(got, want) {
+		t.Errorf("pushed disabled_servers = %#v, want %#v", got, want)
+	}
+
+	// Applying the payload leaves the disabled server out of the file but remembers it
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if onDisk := readFile(t, path); strings.Contains(onDisk, "git-mcp") || !strings.Contains(onDisk, "uvx") {
+		t.Errorf("disabled server should be omitted from the agent file: %s", onDisk)
+	}
+	config, _ := as.GetSyncConfig()
+	if !jsonEqual(config.DisabledServers["cursor"], want) {
+		t.Errorf("remembered disabled servers = %#v", config.DisabledServers)
+	}
+
+	// The remembered state is pushed again even though the file no longer holds the server
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if got := disabledIn(pushedAgents()); !reflect.DeepEqual(got, want) {
+		t.Errorf("disabled state lost after a round trip: %#v", got)
+	}
+
+	// Another machine re-enables the server
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{"command": "uvx"},
+			"git":   map[string]interface{}{"command": "git-mcp"},
+		}},
+	}, nowTime().Add(time.Hour)))
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if onDisk := readFile(t, path); !strings.Contains(onDisk, "git-mcp") {
+		t.Errorf("re-enabled server should be written: %s", onDisk)
+	}
+	if config, _ := as.GetSyncConfig(); len(config.DisabledServers) != 0 {
+		t.Errorf("re-enabled server is still remembered as disabled: %#v", config.DisabledServers)
+	}
+}
+
+func TestDataDirEnvRedirectsAllState(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	dataDir := filepath.Join(t.TempDir(), "isolated")
+	t.Setenv(DataDirEnv, dataDir)
+
+	as := startTestAppService(t)
+	as.storage.crypto = nil
+	if got := as.storage.GetDataDir(); got != dataDir {
+		t.Fatalf("data dir = %s, want %s", got, dataDir)
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`)
+	if err := as.SetMetricsEnabled(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.NormalizeAgentConfig("cursor"); err != nil {
+		t.Fatal(err)
+	}
+	as.recordSyncMetrics("push", 10, time.Now(), nil)
+	as.storage.SaveSyncLog(models.SyncLog{ID: genID(), Timestamp: nowTime(), Action: "push", Status: "success"})
+
+	for _, name := range []string{"sync_config.json", "metrics.json", "logs", "backups"} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); err != nil {
+			t.Errorf("%s was not written to %s: %v", name, dataDir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(home, ".mcp-sync")); !os.IsNotExist(err) {
+		t.Errorf("state leaked into the default data dir: %v", err)
+	}
+	if dir, err := keyringFileDir(); err != nil || dir != filepath.Join(dataDir, "keyring") {
+		t.Errorf("keyringFileDir() = %s, %v", dir, err)
+	}
+
+	t.Setenv(DataDirEnv, "")
+	if dir, err := DataDir(); err != nil || dir != filepath.Join(home, ".mcp-sync") {
+		t.Errorf("DataDir() without %s = %s, %v", DataDirEnv, dir, err)
+	}
+}
+
+func TestDataDirEnvIsolatesKeyringEntry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the Windows key files already live in the data dir")
+	}
+	keyring := NewInMemoryKeyring()
+
+	t.Setenv(DataDirEnv, filepath.Join(t.TempDir(), "first"))
+	first := NewSecureCryptoWithKeyring(keyring)
+	first.dataDir = t.TempDir()
+	t.Setenv(DataDirEnv, filepath.Join(t.TempDir(), "second"))
+	second := NewSecureCryptoWithKeyring(keyring)
+	t.Setenv(DataDirEnv, "")
+	shared := NewSecureCryptoWithKeyring(keyring)
+
+	if shared.serviceName != "mcp-sync" {
+		t.Errorf("service name without %s = %s, want mcp-sync", DataDirEnv, shared.serviceName)
+	}
+	if first.serviceName == second.serviceName || first.serviceName == shared.serviceName {
+		t.Fatalf("isolated instances share the keyring entry %s", first.serviceName)
+	}
+
+	for _, crypto := range []*SecureCrypto{first, second} {
+		if err := crypto.Enable(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ciphertext, err := second.Encrypt("payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.RotateKey(); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	if plaintext, err := second.Decrypt(ciphertext); err != nil || plaintext != "payload" {
+		t.Errorf("rotating one instance's key broke the other: %q, %v", plaintext, err)
+	}
+}
+
+func TestSetupStateTransitions(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("token-a", "alice", "gist")
+	as := newTestAppService(t)
+
+	expectNext := func(state *models.SetupState, next string) {
+		t.Helper()
+		if state.NextStep != next || state.Complete != (next == "") {
+			t.Fatalf("next step = %q (complete %t), want %q", state.NextStep, state.Complete, next)
+		}
+	}
+
+	state, err := as.GetSetupState()
+	if err != nil {
+		t.Fatalf("GetSetupState() error = %v", err)
+	}
+	expectNext(state, "agents")
+	if !state.Defaults.EnableEncryption || !state.Defaults.CreateGist || state.Steps[1].Suggestion == "" {
+		t.Errorf("defaults = %+v, steps = %+v", state.Defaults, state.Steps)
+	}
+
+	if _, err := as.CompleteSetupStep("gist", ""); err == nil {
+		t.Error("choosing a Gist without a token should fail")
+	}
+	if _, err := as.CompleteSetupStep("bogus", ""); err == nil {
+		t.Error("unknown steps should be rejected")
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	state, err = as.CompleteSetupStep("agents", "")
+	if err != nil {
+		t.Fatalf("CompleteSetupStep(agents) error = %v", err)
+	}
+	expectNext(state, "token")
+	if !reflect.DeepEqual(state.DetectedAgents, []string{"cursor"}) {
+		t.Errorf("detected agents = %v", state.DetectedAgents)
+	}
+
+	if _, err := as.CompleteSetupStep("token", "revoked"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("CompleteSetupStep(token) with a bad token error = %v", err)
+	}
+	state, err = as.CompleteSetupStep("token", "token-a")
+	if err != nil {
+		t.Fatalf("CompleteSetupStep(token) error = %v", err)
+	}
+	expectNext(state, "gist")
+
+	state, err = as.CompleteSetupStep("gist", "")
+	if err != nil {
+		t.Fatalf("CompleteSetupStep(gist) error = %v", err)
+	}
+	expectNext(state, "encryption")
+	if state.Defaults.CreateGist || !server.hasGist(state.Steps[2].Detail) {
+		t.Errorf("gist step = %+v, defaults = %+v", state.Steps[2], state.Defaults)
+	}
+
+	// Enabling encryption for real uses the system keyring; record it the way SetupGistEncryption does
+	config, _ := as.storage.LoadSyncConfig()
+	config.EnableEncryption = true
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	state, err = as.GetSetupState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectNext(state, "")
+}
+
+func TestPullFromGistMergeKeepsLocalOnlyServers(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"shared": map[string]interface{}{"command": "shared-mcp", "args": []interface{}{"--v2"}},
+			"remote": map[string]interface{}{"command": "remote-mcp"},
+			"same":   map[string]interface{}{"command": "same-mcp"},
+		}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"theme": "dark", "mcpServers": {
+		"shared": {"command": "shared-mcp", "args": ["--v1"]},
+		"local": {"command": "local-mcp"},
+		"same": {"command": "same-mcp"}
+	}}`)
+
+	result, err := as.PullFromGistMerge()
+	if err != nil {
+		t.Fatalf("PullFromGistMerge() error = %v", err)
+	}
+	want := []models.AgentPullResult{{
+		AgentID: "cursor",
+		Added:   []string{"remote"},
+		Updated: []string{"shared"},
+		Kept:    []string{"local"},
+	}}
+	if result.AppliedCount != 1 || !reflect.DeepEqual(result.Agents, want) {
+		t.Errorf("PullFromGistMerge() = %+v, want %+v", result, want)
+	}
+
+	servers, err := as.standardAgentServers("cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers["local"] == nil || servers["remote"] == nil || servers["same"] == nil {
+		t.Errorf("merged servers = %#v", servers)
+	}
+	if shared, _ := servers["shared"].(map[string]interface{}); !jsonEqual(shared["args"], []interface{}{"--v2"}) {
+		t.Errorf("shared server was not updated: %#v", shared)
+	}
+	if !strings.Contains(readFile(t, path), `"theme": "dark"`) {
+		t.Errorf("other settings were lost: %s", readFile(t, path))
+	}
+
+	// A second merge-pull finds nothing to change and writes nothing
+	result, err = as.PullFromGistMerge()
+	if err != nil {
+		t.Fatalf("second PullFromGistMerge() error = %v", err)
+	}
+	if result.AppliedCount != 0 || len(result.Agents[0].Kept) != 1 {
+		t.Errorf("second PullFromGistMerge() = %+v", result)
+	}
+	backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "cursor_*"))
+	if len(backups) != 1 {
+		t.Errorf("expected one backup, got %v", backups)
+	}
+}
+
+func TestPullRecordsAuditEntriesForEachWrittenAgent(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"remote": map[string]interface{}{"command": "remote-mcp"}}},
+		"codex":  map[string]interface{}{"mcp_servers": map[string]interface{}{"remote": map[string]interface{}{"command": "remote-mcp"}}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	paths := map[string]string{
+		"cursor": writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`),
+		"codex":  writeAgentFile(t, as, "codex", "model = \"o3\"\n"),
+	}
+	before := map[string]string{"cursor": fileSHA256(osFileSystem{}, paths["cursor"]), "codex": fileSHA256(osFileSystem{}, paths["codex"])}
+
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+
+	entries, err := as.GetAuditLog(100)
+	if err != nil {
+		t.Fatalf("GetAuditLog() error = %v", err)
+	}
+	configWrites := make(map[string]models.AuditEntry)
+	for _, entry := range entries {
+		if entry.Operation != "pull" || entry.OperationID != entries[0].OperationID || entry.Kind != "config" {
+			t.Errorf("entry %+v is not a config write of the pull operation", entry)
+		}
+		configWrites[entry.AgentID] = entry
+	}
+	for agentID, path := range paths {
+		entry, ok := configWrites[agentID]
+		if !ok {
+			t.Errorf("no audit entry for %s in %+v", agentID, entries)
+			continue
+		}
+		if entry.Path != path || entry.BeforeHash != before[agentID] || entry.AfterHash != fileSHA256(osFileSystem{}, path) || entry.AfterHash == entry.BeforeHash {
+			t.Errorf("%s audit entry = %+v", agentID, entry)
+		}
+	}
+
+	// Backups are audited too, and the limit returns the newest entries first
+	backupPath, err := as.backupAgentConfig("cursor")
+	if err != nil {
+		t.Fatalf("backupAgentConfig() error = %v", err)
+	}
+	latest, _ := as.GetAuditLog(1)
+	if len(latest) != 1 || latest[0].Kind != "backup" || latest[0].Path != backupPath || latest[0].AfterHash != fileSHA256(osFileSystem{}, backupPath) {
+		t.Errorf("GetAuditLog(1) = %+v", latest)
+	}
+}
+
+// snapshotFiles records the content and modification time of each path
+func snapshotFiles(t *testing.T, paths ...string) map[string]string {
+	t.Helper()
+	snapshot := make(map[string]string, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshot[path] = info.ModTime().String() + "\n" + readFile(t, path)
+	}
+	return snapshot
+}
+
+func TestPullWithWrongKeyLeavesAgentFilesUntouched(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor":      map[string]interface{}{"mcpServers": map[string]interface{}{}},
+		"claude-code": map[string]interface{}{"mcpServers": map[string]interface{}{}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	as.gistSync.securityMgr = NewSecurityManager("wrong-password")
+	paths := []string{
+		writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx"}}}`),
+		writeAgentFile(t, as, "claude-code", `{"mcpServers": {"time": {"command": "uvx"}}}`),
+	}
+	before := snapshotFiles(t, paths...)
+
+	pulls := map[string]func() error{
+		"PullFromGist":      func() error { _, err := as.PullFromGist(); return err },
+		"PullFromGistMerge": func() error { _, err := as.PullFromGistMerge(); return err },
+		"PullAgentFromGist": func() error { return as.PullAgentFromGist("cursor") },
+		"ForcePull":         func() error { _, err := as.ForcePull(); return err },
+	}
+	for name, pull := range pulls {
+		if err := pull(); !errors.Is(err, ErrDecryptFailed) {
+			t.Errorf("%s() error = %v, want ErrDecryptFailed", name, err)
+		}
+	}
+
+	if after := snapshotFiles(t, paths...); !reflect.DeepEqual(after, before) {
+		t.Errorf("agent files were modified by a pull that could not decrypt:\nbefore %v\nafter  %v", before, after)
+	}
+	entries, _ := as.GetAuditLog(100)
+	for _, entry := range entries {
+		if entry.Kind == "config" {
+			t.Errorf("audit log records a config write: %+v", entry)
+		}
+	}
+}
+
+func TestPullWithMalformedAgentEntryAppliesNothing(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"claude-code": map[string]interface{}{"mcpServers": map[string]interface{}{"remote": map[string]interface{}{"command": "remote-mcp"}}},
+		"cursor":      "not an object",
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "claude-code", `{"mcpServers": {"time": {"command": "uvx"}}}`)
+	before := snapshotFiles(t, path)
+
+	if _, err := as.PullFromGist(); !errors.Is(err, ErrInvalidPayload) {
+		t.Fatalf("PullFromGist() error = %v, want ErrInvalidPayload", err)
+	}
+	if after := snapshotFiles(t, path); !reflect.DeepEqual(after, before) {
+		t.Errorf("claude-code was written although the payload was rejected: %s", readFile(t, path))
+	}
+}
+
+func TestPullCoercesNumericAndBoolArgsAndEnv(t *testing.T) {
+	numeric := map[string]interface{}{
+		"command": "server-mcp",
+		"args":    []interface{}{"--port", 8080, "--verbose", true},
+		"env":     map[string]interface{}{"PORT": 8080, "RATIO": 0.5, "DEBUG": true, "UNSET": nil},
+	}
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"numeric": numeric}},
+		"codex":  map[string]interface{}{"mcp_servers": map[string]interface{}{"numeric": numeric}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	codexPath := writeAgentFile(t, as, "codex", "model = \"o3\"\n")
+
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+
+	wantArgs := []interface{}{"--port", "8080", "--verbose", "true"}
+	wantEnv := map[string]interface{}{"PORT": "8080", "RATIO": "0.5", "DEBUG": "true"}
+	for _, agentID := range []string{"cursor", "codex"} {
+		servers, err := as.standardAgentServers(agentID)
+		if err != nil {
+			t.Fatalf("standardAgentServers(%s) error = %v", agentID, err)
+		}
+		got, _ := servers["numeric"].(map[string]interface{})
+		if !jsonEqual(got["args"], wantArgs) || !jsonEqual(got["env"], wantEnv) {
+			t.Errorf("%s server = %#v, want args %v and env %v", agentID, got, wantArgs, wantEnv)
+		}
+	}
+	if content := readFile(t, codexPath); !strings.Contains(content, `"PORT" = "8080"`) {
+		t.Errorf("codex env was not written as strings:\n%s", content)
+	}
+}
+
+func TestInitializeGistSyncRetryDoesNotDuplicateGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("token-a", "alice", "gist")
+	as := newTestAppService(t)
+	gistCount := func() int {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return len(server.gists)
+	}
+
+	// The gist is created but the response never arrives
+	server.dropNextCreate = true
+	if _, err := as.InitializeGistSyncDetailed("token-a", ""); err == nil {
+		t.Fatal("InitializeGistSyncDetailed() should fail when the response is lost")
+	}
+	if gistCount() != 1 {
+		t.Fatalf("expected the lost create to have made one gist, got %d", gistCount())
+	}
+
+	result, err := as.InitializeGistSyncDetailed("token-a", "")
+	if err != nil {
+		t.Fatalf("retried InitializeGistSyncDetailed() error = %v", err)
+	}
+	if gistCount() != 1 || server.requestCount("POST") != 1 || !server.hasGist(result.GistID) {
+		t.Errorf("retry created a duplicate: %d gists, %d creates, result %+v", gistCount(), server.requestCount("POST"), result)
+	}
+	config, _ := as.GetSyncConfig()
+	if config.GistID != result.GistID || config.PendingGistRequest != "" {
+		t.Errorf("sync config = gist %q, pending request %q", config.GistID, config.PendingGistRequest)
+	}
+
+	// Once saved, the next initialization creates a fresh gist
+	if _, err := as.InitializeGistSyncDetailed("token-a", ""); err != nil {
+		t.Fatal(err)
+	}
+	if gistCount() != 2 {
+		t.Errorf("a new initialization should create a new gist, got %d gists", gistCount())
+	}
+}
+
+func TestMirrorFromAgent(t *testing.T) {
+	source := `{"mcpServers": {
+		"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]},
+		"fs": {"command": "npx", "args": ["-y", "fs"], "description": "project files"}
+	}}`
+	target := `{"theme": "One Dark", "context_servers": {
+		"fetch": {"source": "custom", "enabled": true, "command": "old-fetch"},
+		"zed-only": {"source": "custom", "enabled": true, "command": "zed-tool"}
+	}}`
+
+	t.Run("mirror removes extra servers", func(t *testing.T) {
+		as := newTestAppService(t)
+		writeAgentFile(t, as, "cursor", source)
+		zedPath := writeAgentFile(t, as, "zed", target)
+
+		failures, err := as.MirrorFromAgent("cursor", []string{"zed", "cursor", "no-such-agent"}, true)
+		if err != nil {
+			t.Fatalf("MirrorFromAgent() error = %v", err)
+		}
+		if len(failures) != 1 || failures["no-such-agent"] == nil {
+			t.Errorf("MirrorFromAgent() failures = %v, want only the unknown agent", failures)
+		}
+
+		servers, err := as.standardAgentServers("zed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := as.standardAgentServers("cursor")
+		if !jsonEqual(servers, want) {
+			t.Errorf("zed servers = %v, want exactly the cursor servers %v", servers, want)
+		}
+		content := readFile(t, zedPath)
+		if !strings.Contains(content, `"source": "custom"`) || !strings.Contains(content, "One Dark") {
+			t.Errorf("zed settings were not written in Zed format with other settings kept:\n%s", content)
+		}
+
+		backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "zed_*"))
+		if len(backups) != 1 || !strings.Contains(readFile(t, backups[0]), "zed-only") {
+			t.Errorf("expected a backup of the original zed settings, got %v", backups)
+		}
+
+		// Running again finds nothing to change and makes no new backup
+		if failures, err := as.MirrorFromAgent("cursor", []string{"zed"}, true); err != nil || len(failures) != 0 {
+			t.Fatalf("second MirrorFromAgent() = %v, %v", failures, err)
+		}
+		if again, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "zed_*")); len(again) != 1 {
+			t.Errorf("unchanged target was backed up again: %v", again)
+		}
+	})
+
+	t.Run("additive keeps extra servers", func(t *testing.T) {
+		as := newTestAppService(t)
+		writeAgentFile(t, as, "cursor", source)
+		writeAgentFile(t, as, "zed", target)
+
+		failures, err := as.MirrorFromAgent("cursor", []string{"zed"}, false)
+		if err != nil || len(failures) != 0 {
+			t.Fatalf("MirrorFromAgent() = %v, %v", failures, err)
+		}
+
+		servers, err := as.standardAgentServers("zed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(servers) != 3 || servers["zed-only"] == nil {
+			t.Errorf("zed servers = %v, want cursor's servers plus zed-only", servers)
+		}
+		if fetch, _ := servers["fetch"].(map[string]interface{}); fetch["command"] != "uvx" {
+			t.Errorf("fetch = %v, want the source version to replace the target's", servers["fetch"])
+		}
+		if fs, _ := servers["fs"].(map[string]interface{}); fs["description"] != "project files" {
+			t.Errorf("fs = %v, want the description carried over", servers["fs"])
+		}
+
+		backups, _ := filepath.Glob(filepath.Join(as.storage.GetDataDir(), "backups", "zed_*"))
+		if len(backups) != 1 {
+			t.Errorf("expected one zed backup, got %v", backups)
+		}
+	})
+}
+
+func TestIdenticalPushesStoreOneVersion(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "{}")
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}}}`)
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("first PushAllAgentsToGist() error = %v", err)
+	}
+
+	// Make the second sync's timestamp observable
+	config, _ := as.storage.LoadSyncConfig()
+	stale := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	config.LastSyncTime = stale
+	as.storage.SaveSyncConfig(config)
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("second PushAllAgentsToGist() error = %v", err)
+	}
+
+	versions, err := as.GetConfigVersions(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Errorf("two identical pushes stored %d versions, want 1", len(versions))
+	}
+	if config, _ := as.GetSyncConfig(); !config.LastSyncTime.After(stale) {
+		t.Errorf("LastSyncTime = %v, want it updated by the second push", config.LastSyncTime)
+	}
+
+	// A real change is recorded again
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch", "--verbose"]}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := as.GetConfigVersions(10); len(versions) != 2 {
+		t.Errorf("push with changed config left %d versions, want 2", len(versions))
+	}
+}
+
+func TestGitHubTokenResolution(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-env", "alice")
+	server.addUser("token-helper", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	// The stored token is not accepted by the server, so a valid check proves another token was used
+	storeCredentials := func(as *AppService) {
+		config, _ := as.storage.LoadSyncConfig()
+		config.GitHubToken = "token-stored"
+		config.GistID = gistID
+		as.storage.SaveSyncConfig(config)
+	}
+
+	t.Run("env var overrides the stored token", func(t *testing.T) {
+		as := newTestAppService(t)
+		storeCredentials(as)
+		t.Setenv(GitHubTokenEnv, "token-env")
+
+		status, err := as.ValidateStoredCredentials()
+		if err != nil || status.Status != CredStatusValid {
+			t.Fatalf("ValidateStoredCredentials() = %+v, %v, want valid with the env token", status, err)
+		}
+		if server.lastAuth != "Bearer token-env" {
+			t.Errorf("last request authorized with %q, want the env token", server.lastAuth)
+		}
+		if source, _ := as.GetGitHubTokenSource(); source != TokenSourceEnv {
+			t.Errorf("GetGitHubTokenSource() = %q, want %q", source, TokenSourceEnv)
+		}
+	})
+
+	t.Run("credential helper is invoked", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("helper command uses sh")
+		}
+		as := newTestAppService(t)
+		storeCredentials(as)
+
+		marker := filepath.Join(t.TempDir(), "called")
+		if err := as.SetCredentialHelper("cat > '" + marker + "'; printf 'password=token-helper\\n'"); err != nil {
+			t.Fatalf("SetCredentialHelper() error = %v", err)
+		}
+		status, err := as.ValidateStoredCredentials()
+		if err != nil || status.Status != CredStatusValid {
+			t.Fatalf("ValidateStoredCredentials() = %+v, %v, want valid with the helper token", status, err)
+		}
+		if server.lastAuth != "Bearer token-helper" {
+			t.Errorf("last request authorized with %q, want the helper token", server.lastAuth)
+		}
+		if input := readFile(t, marker); !strings.Contains(input, "host=github.com") {
+			t.Errorf("helper received %q, want the git credential request", input)
+		}
+
+		// The env var still wins over the helper
+		t.Setenv(GitHubTokenEnv, "token-env")
+		if source, _ := as.GetGitHubTokenSource(); source != TokenSourceEnv {
+			t.Errorf("GetGitHubTokenSource() = %q, want %q", source, TokenSourceEnv)
+		}
+	})
+
+	t.Run("external tokens are not stored", func(t *testing.T) {
+		as := newTestAppService(t)
+		t.Setenv(GitHubTokenEnv, "token-env")
+
+		if _, err := as.InitializeGistSyncDetailed("", gistID); err != nil {
+			t.Fatalf("InitializeGistSyncDetailed() error = %v", err)
+		}
+		config, _ := as.GetSyncConfig()
+		if config.GitHubToken != "" || config.GistID != gistID {
+			t.Errorf("sync config = token %q, gist %q, want only the gist saved", config.GitHubToken, config.GistID)
+		}
+		if err := as.CanSync(); err != nil {
+			t.Errorf("CanSync() with the env token error = %v", err)
+		}
+	})
+}
+
+func TestCompleteEncryptionMigrationClearsLegacyPassword(t *testing.T) {
+	as := newTestAppService(t)
+	as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	as.storage.crypto.dataDir = as.storage.GetDataDir()
+
+	// A legacy install: local data encrypted with the password kept in the config
+	as.storage.securityMgr = NewSecurityManager("legacy-pass")
+	as.storage.oldEnabled = true
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "v1", Content: "legacy history"})
+	as.storage.SaveSyncConfig(models.SyncConfig{ID: "default", EnableEncryption: true, EncryptionPassword: "legacy-pass"})
+	configPath := filepath.Join(as.storage.GetDataDir(), "sync_config.json")
+	if !strings.HasPrefix(readFile(t, configPath), "ENC:") {
+		t.Fatal("legacy config was not encrypted")
+	}
+
+	if err := as.CompleteEncryptionMigration(); err != nil {
+		t.Fatalf("CompleteEncryptionMigration() error = %v", err)
+	}
+
+	// Without the password, everything still decrypts with the keyring key
+	if as.storage.securityMgr != nil || as.storage.oldEnabled {
+		t.Error("legacy password decryption is still active")
+	}
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		t.Fatalf("LoadSyncConfig() after migration error = %v", err)
+	}
+	if config.EncryptionPassword != "" || config.GistEncryptionPassword != "" || !config.EnableEncryption {
+		t.Errorf("config after migration = %+v, want encryption on and no passwords", config)
+	}
+	raw, _ := as.storage.crypto.DecryptIfNeeded([]byte(readFile(t, configPath)))
+	if strings.Contains(string(raw), "password") {
+		t.Errorf("stored config still has a password field:\n%s", raw)
+	}
+	versions, err := as.storage.ListConfigVersions(10)
+	if err != nil || len(versions) != 1 || versions[0].Content != "legacy history" {
+		t.Errorf("ListConfigVersions() after migration = %v, %v, want the legacy version", versions, err)
+	}
+}
+
+func TestCompleteEncryptionMigrationKeepsPasswordOnFailure(t *testing.T) {
+	as := newTestAppService(t)
+	as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	as.storage.crypto.dataDir = as.storage.GetDataDir()
+
+	as.storage.securityMgr = NewSecurityManager("other-pass")
+	as.storage.oldEnabled = true
+	as.storage.SaveConfigVersion(models.ConfigVersion{ID: "v1", Content: "history under another password"})
+	as.storage.securityMgr = NewSecurityManager("legacy-pass")
+	as.storage.SaveSyncConfig(models.SyncConfig{ID: "default", EnableEncryption: true, GistEncryptionPassword: "legacy-pass"})
+
+	if err := as.CompleteEncryptionMigration(); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("CompleteEncryptionMigration() with unreadable data error = %v, want ErrDecryptFailed", err)
+	}
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		t.Fatalf("LoadSyncConfig() error = %v", err)
+	}
+	if config.GistEncryptionPassword != "legacy-pass" {
+		t.Errorf("password was cleared although migration failed")
+	}
+}
+
+func TestBackupsArePrunedAfterEachBackup(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	if err := as.SetBackupRetention(models.BackupRetention{MaxCount: 2}); err != nil {
+		t.Fatalf("SetBackupRetention() error = %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := as.backupAgentConfig("cursor"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if names := backupNames(t, as.storage); len(names) != 2 {
+		t.Errorf("backups after four backups = %v, want the newest 2", names)
+	}
+
+	if err := as.SetBackupRetention(models.BackupRetention{MaxCount: -1}); err == nil {
+		t.Error("SetBackupRetention() accepted a negative count")
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"mcp-sync/models"
+)
+
+// auditOperation 标识一次会写入文件的操作，操作中的所有写入在审计日志里共享同一个 ID
+type auditOperation struct {
+	id   string
+	name string
+}
+
+// beginOperation 开始一个名为 name 的操作并返回结束函数。嵌套调用沿用外层操作，
+// 例如拉取中保存 agent 配置和备份都记在同一次拉取下
+func (as *AppService) beginOperation(name string) func() {
+	as.auditMu.Lock()
+	defer as.auditMu.Unlock()
+
+	if as.operation != nil {
+		return func() {}
+	}
+	op := &auditOperation{id: genID(), name: name}
+	as.operation = op
+	return func() {
+		as.auditMu.Lock()
+		defer as.auditMu.Unlock()
+		if as.operation == op {
+			as.operation = nil
+		}
+	}
+}
+
+// currentOperation 返回正在进行的操作；没有时返回只包含这一次写入、名为 fallback 的新操作
+func (as *AppService) currentOperation(fallback string) auditOperation {
+	as.auditMu.Lock()
+	defer as.auditMu.Unlock()
+
+	if as.operation != nil {
+		return *as.operation
+	}
+	return auditOperation{id: genID(), name: fallback}
+}
+
+// fileSHA256 返回文件内容的 SHA-256；文件不存在或无法读取时返回空字符串
+func fileSHA256(fs FileSystem, path string) string {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// auditedWrite 执行 write，并在审计日志中记录 path 写入前后的 hash。operation 是没有进行中的操作时使用的操作名。
+// 写入期间持有 path 的文件锁，并行写入多个 agent 时共用同一文件的 agent 依次写入
+func (as *AppService) auditedWrite(operation, agentID, path string, write func() error) error {
+	defer as.lockConfigFile(path)()
+	return as.auditedWriteLocked(operation, agentID, path, write)
+}
+
+// auditedWriteLocked 与 auditedWrite 相同，但调用方已经持有 path 的文件锁（例如写入前还要读取同一文件）
+func (as *AppService) auditedWriteLocked(operation, agentID, path string, write func() error) error {
+	before := fileSHA256(as.storage.fs, path)
+	if err := write(); err != nil {
+		return err
+	}
+	as.recordAudit(operation, "config", agentID, path, before, fileSHA256(as.storage.fs, path))
+	return nil
+}
+
+// recordAudit 追加一条审计记录；审计日志写入失败只打印日志，不影响已经完成的写入
+func (as *AppService) recordAudit(operation, kind, agentID, path, before, after string) {
+	op := as.currentOperation(operation)
+	entry := models.AuditEntry{
+		ID:          genID(),
+		Timestamp:   nowTime(),
+		OperationID: op.id,
+		Operation:   op.name,
+		Kind:        kind,
+		AgentID:     agentID,
+		Path:        path,
+		BeforeHash:  before,
+		AfterHash:   after,
+	}
+	if err := as.storage.AppendAuditEntry(entry); err != nil {
+		println(fmt.Sprintf("[Audit] Failed to record write of %s: %v", path, err))
+	}
+}
+
+// GetAuditLog 返回最近 limit 条文件写入记录，最新的在前
+func (as *AppService) GetAuditLog(limit int) ([]models.AuditEntry, error) {
+	entries, err := as.storage.GetAuditLog(limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
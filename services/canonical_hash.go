@@ -0,0 +1,96 @@
+package services
+
+import "encoding/json"
+
+// canonicalConfigHash 计算配置版本内容的语义 hash，用于判断两个版本是否相同：
+// 键排序、去掉空白，args 和 env 按 coerce.go 的规则统一为字符串，空的 args 和 env 与不写相同。
+// Gist 负载（带 agents 和 timestamp）只比较其中的 agents，与本地版本保存的 agent 配置对比；
+// 内容不是 JSON 时退回到原文的 hash
+func canonicalConfigHash(content string) string {
+	var data interface{}
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return computeHash(content)
+	}
+	if payload, ok := data.(map[string]interface{}); ok {
+		_, hasTimestamp := payload["timestamp"]
+		if agents, ok := payload["agents"].(map[string]interface{}); ok && hasTimestamp {
+			data = agents
+		}
+	}
+	if agents, ok := data.(map[string]interface{}); ok {
+		data = canonicalAgentConfigs(agents)
+	}
+
+	// encoding/json writes map keys sorted and without whitespace
+	canonical, err := json.Marshal(data)
+	if err != nil {
+		return computeHash(content)
+	}
+	return computeHash(string(canonical))
+}
+
+// canonicalAgentConfigs 返回统一了每个服务器 args 和 env 表示的 agent 配置（agent ID -> 配置），不修改传入的 map。
+// 配置中值为对象、且成员都是对象的键（mcpServers、context_servers 等）被当作服务器表
+func canonicalAgentConfigs(agents map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(agents))
+	for agentID, agentConfig := range agents {
+		config, ok := agentConfig.(map[string]interface{})
+		if !ok {
+			result[agentID] = agentConfig
+			continue
+		}
+		canonical := make(map[string]interface{}, len(config))
+		for key, value := range config {
+			if servers, ok := value.(map[string]interface{}); ok && isServerTable(servers) {
+				value = canonicalServers(servers)
+			}
+			canonical[key] = value
+		}
+		result[agentID] = canonical
+	}
+	return result
+}
+
+// isServerTable 判断 value 的成员是否都是对象
+func isServerTable(value map[string]interface{}) bool {
+	for _, member := range value {
+		if _, ok := member.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalServers 返回 args 和 env 统一为字符串、去掉空 args 和 env 的服务器
+func canonicalServers(servers map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(servers))
+	for name, server := range coerceServers(servers) {
+		serverMap, ok := server.(map[string]interface{})
+		if !ok {
+			result[name] = server
+			continue
+		}
+		canonical := make(map[string]interface{}, len(serverMap))
+		for field, value := range serverMap {
+			if (field == "args" || field == "env") && isEmptyCollection(value) {
+				continue
+			}
+			canonical[field] = value
+		}
+		result[name] = canonical
+	}
+	return result
+}
+
+// isEmptyCollection 判断 value 是否为 null、空数组或空对象
+func isEmptyCollection(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
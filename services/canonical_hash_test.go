@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"mcp-sync/models"
+)
+
+func TestCanonicalConfigHashIgnoresFormatting(t *testing.T) {
+	base := canonicalConfigHash(`{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": ["--port", "8080"], "env": {"DEBUG": "true"}}}}}`)
+
+	same := map[string]string{
+		"reordered and indented": "{\n  \"cursor\": {\n    \"mcpServers\": {\n      \"fetch\": {\n        \"env\": {\"DEBUG\": \"true\"},\n        \"args\": [\"--port\", \"8080\"],\n        \"command\": \"uvx\"\n      }\n    }\n  }\n}\n",
+		"typed args and env":     `{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": ["--port", 8080], "env": {"DEBUG": true}}}}}`,
+		"empty env":              `{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": ["--port", "8080"], "env": {"DEBUG": "true", "UNSET": null}}}}}`,
+		"gist payload":           `{"timestamp": "2024-01-01T00:00:00Z", "encrypted": true, "agents": {"cursor": {"mcpServers": {"fetch": {"args": ["--port", "8080"], "command": "uvx", "env": {"DEBUG": "true"}}}}}}`,
+	}
+	for name, content := range same {
+		if got := canonicalConfigHash(content); got != base {
+			t.Errorf("%s: hash differs from the original formatting", name)
+		}
+	}
+	if canonicalConfigHash(`{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": []}}}}`) !=
+		canonicalConfigHash(`{"cursor": {"mcpServers": {"fetch": {"command": "uvx"}}}}`) {
+		t.Error("empty args hash differently from no args")
+	}
+
+	different := map[string]string{
+		"changed arg":   `{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": ["--port", "8081"], "env": {"DEBUG": "true"}}}}}`,
+		"reordered arg": `{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": ["8080", "--port"], "env": {"DEBUG": "true"}}}}}`,
+		"other agent":   `{"zed": {"mcpServers": {"fetch": {"command": "uvx", "args": ["--port", "8080"], "env": {"DEBUG": "true"}}}}}`,
+	}
+	for name, content := range different {
+		if canonicalConfigHash(content) == base {
+			t.Errorf("%s: hash equals the original although the config differs", name)
+		}
+	}
+
+	if canonicalConfigHash("not json") != computeHash("not json") {
+		t.Error("non-JSON content should hash as is")
+	}
+}
+
+func TestConflictDetectionIgnoresReformatting(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	remote := remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{"command": "uvx", "args": []interface{}{"--port", 8080}},
+		}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	gistID := server.addGist("alice", remote)
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	saveLocal := func(content string) {
+		t.Helper()
+		if err := as.storage.SaveConfigVersion(models.ConfigVersion{ID: "local_" + genID(), Timestamp: nowTime(), Content: content, Source: "local"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same servers, written by another machine with different key order, indentation and arg types
+	saveLocal("{\n    \"cursor\": {\"mcpServers\": {\"fetch\": {\"args\": [\"--port\", \"8080\"], \"command\": \"uvx\"}}}\n}")
+	if conflict, err := as.DetectPushConflict(); err != nil || conflict.HasConflict {
+		t.Errorf("DetectPushConflict() after reformatting = %+v, %v; want no conflict", conflict, err)
+	}
+	if conflict, err := as.DetectPullConflict(); err != nil || conflict.HasConflict {
+		t.Errorf("DetectPullConflict() after reformatting = %+v, %v; want no conflict", conflict, err)
+	}
+
+	saveLocal(`{"cursor": {"mcpServers": {"fetch": {"command": "uvx", "args": ["--port", "9090"]}}}}`)
+	if conflict, err := as.DetectPushConflict(); err != nil || !conflict.HasConflict {
+		t.Errorf("DetectPushConflict() after a real change = %+v, %v; want a conflict", conflict, err)
+	}
+	if conflict, err := as.DetectPullConflict(); err != nil || !conflict.HasConflict {
+		t.Errorf("DetectPullConflict() after a real change = %+v, %v; want a conflict", conflict, err)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"mcp-sync/models"
+)
+
+// changeSummary 统计一次同步相对上一个版本新增、修改和删除的服务器数，以及有变化的 agent 数
+type changeSummary struct {
+	Added   int
+	Changed int
+	Removed int
+	Agents  int
+}
+
+// String 返回同步日志中使用的摘要，例如 "+2 servers, ~1 changed, -1 removed across 3 agents"
+func (s changeSummary) String() string {
+	if s.Added == 0 && s.Changed == 0 && s.Removed == 0 {
+		return "no server changes"
+	}
+	noun := "servers"
+	if s.Added == 1 {
+		noun = "server"
+	}
+	summary := fmt.Sprintf("+%d %s, ~%d changed, -%d removed", s.Added, noun, s.Changed, s.Removed)
+	switch {
+	case s.Agents == 1:
+		summary += " across 1 agent"
+	case s.Agents > 1:
+		summary += fmt.Sprintf(" across %d agents", s.Agents)
+	}
+	return summary
+}
+
+// add 把一个 agent（或一组服务器）的变化计入摘要
+func (s *changeSummary) add(previous, current map[string]interface{}) {
+	added, changed, removed := 0, 0, 0
+	for _, name := range unionKeys(previous, current) {
+		before, inPrevious := previous[name]
+		after, inCurrent := current[name]
+		switch {
+		case !inPrevious:
+			added++
+		case !inCurrent:
+			removed++
+		case !jsonEqual(before, after):
+			changed++
+		}
+	}
+	s.Added += added
+	s.Changed += changed
+	s.Removed += removed
+	if added+changed+removed > 0 {
+		s.Agents++
+	}
+}
+
+// summarizeAgentChanges 按 agent 对比上一个版本 previous 和本次同步的 current（agent ID 到完整配置）中的服务器
+func (as *AppService) summarizeAgentChanges(previous, current map[string]interface{}) changeSummary {
+	var summary changeSummary
+	for _, agentID := range unionKeys(previous, current) {
+		keyName := as.configLoader.GetConfigKey(agentID)
+		before, _ := previous[agentID].(map[string]interface{})
+		after, _ := current[agentID].(map[string]interface{})
+		beforeServers, _ := standardServersFrom(before, keyName)
+		afterServers, _ := standardServersFrom(after, keyName)
+		summary.add(beforeServers, afterServers)
+	}
+	return summary
+}
+
+// mergeBaseAgents 返回合并基准中的 agent 配置，作为本次同步的上一个版本；从未同步过时返回空 map
+func (as *AppService) mergeBaseAgents() map[string]interface{} {
+	agents := make(map[string]interface{})
+	base, err := as.storage.LoadMergeBase()
+	if err != nil || base == nil {
+		return agents
+	}
+	if err := json.Unmarshal([]byte(base.Content), &agents); err != nil {
+		println(fmt.Sprintf("Warning: failed to parse merge base: %v", err))
+		return make(map[string]interface{})
+	}
+	return agents
+}
+
+// summarizeServerChanges 对比旧版推送（服务器列表）的上一次内容 previous 和本次的 servers
+func summarizeServerChanges(previous, servers []models.MCPServer) changeSummary {
+	byID := func(list []models.MCPServer) map[string]interface{} {
+		result := make(map[string]interface{}, len(list))
+		for _, server := range list {
+			result[server.ID] = server
+		}
+		return result
+	}
+	var summary changeSummary
+	summary.add(byID(previous), byID(servers))
+	// A plain server list has no agents to count
+	summary.Agents = 0
+	return summary
+}
+
+// lastPushedServers 返回旧版推送上一次保存的服务器列表；没有时返回 nil
+func (as *AppService) lastPushedServers() []models.MCPServer {
+	versions, err := as.storage.ListConfigVersions(50)
+	if err != nil {
+		return nil
+	}
+	for _, version := range versions {
+		if version.Source != "local" || version.Note != "Pushed to Gist" {
+			continue
+		}
+		servers, err := as.configManager.ImportConfigFromJSON([]byte(version.Content))
+		if err != nil {
+			return nil
+		}
+		return servers
+	}
+	return nil
+}
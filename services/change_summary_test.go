@@ -0,0 +1,121 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"mcp-sync/models"
+)
+
+func TestChangeSummaryString(t *testing.T) {
+	tests := []struct {
+		summary changeSummary
+		want    string
+	}{
+		{changeSummary{}, "no server changes"},
+		{changeSummary{Added: 2, Changed: 1, Removed: 1, Agents: 3}, "+2 servers, ~1 changed, -1 removed across 3 agents"},
+		{changeSummary{Added: 1, Agents: 1}, "+1 server, ~0 changed, -0 removed across 1 agent"},
+		{changeSummary{Removed: 2}, "+0 servers, ~0 changed, -2 removed"},
+	}
+	for _, tt := range tests {
+		if got := tt.summary.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.summary, got, tt.want)
+		}
+	}
+
+	previous := []models.MCPServer{{ID: "a", Command: "a-mcp"}, {ID: "b", Command: "b-mcp"}}
+	current := []models.MCPServer{{ID: "a", Command: "a-mcp", Args: []string{"--v2"}}, {ID: "c", Command: "c-mcp"}}
+	if got := summarizeServerChanges(previous, current).String(); got != "+1 server, ~1 changed, -1 removed" {
+		t.Errorf("summarizeServerChanges() = %q", got)
+	}
+}
+
+// syncLogMessages returns the messages of every log with the given action, newest first
+func syncLogMessages(t *testing.T, as *AppService, action string) []string {
+	t.Helper()
+	logs, err := as.GetSyncLogs(100)
+	if err != nil {
+		t.Fatalf("GetSyncLogs() error = %v", err)
+	}
+	var messages []string
+	for _, log := range logs {
+		if log.Action == action {
+			messages = append(messages, log.Message)
+		}
+	}
+	return messages
+}
+
+func containsMessage(messages []string, substr string) bool {
+	for _, message := range messages {
+		if strings.Contains(message, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPushLogSummarizesChanges(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}, "old": {"command": "old-mcp"}}}`)
+	writeAgentFile(t, as, "windsurf", `{"mcpServers": {"search": {"command": "search-mcp"}}}`)
+	writeAgentFile(t, as, "claude-code", `{"mcpServers": {}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("first PushAllAgentsToGist() error = %v", err)
+	}
+	if messages := syncLogMessages(t, as, "push"); !containsMessage(messages, "+3 servers, ~0 changed, -0 removed across 2 agents") {
+		t.Errorf("first push logs = %q", messages)
+	}
+
+	// cursor: fetch changed, old removed; windsurf: one added; claude-code: one added
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch", "--v2"]}}}`)
+	writeAgentFile(t, as, "windsurf", `{"mcpServers": {"search": {"command": "search-mcp"}, "git": {"command": "git-mcp"}}}`)
+	writeAgentFile(t, as, "claude-code", `{"mcpServers": {"memory": {"command": "memory-mcp"}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("second PushAllAgentsToGist() error = %v", err)
+	}
+	if messages := syncLogMessages(t, as, "push"); !containsMessage(messages, "+2 servers, ~1 changed, -1 removed across 3 agents") {
+		t.Errorf("second push logs = %q", messages)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("third PushAllAgentsToGist() error = %v", err)
+	}
+	if messages := syncLogMessages(t, as, "push"); !containsMessage(messages, "no server changes") {
+		t.Errorf("unchanged push logs = %q", messages)
+	}
+}
+
+func TestPullLogSummarizesChanges(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{"command": "uvx"},
+			"git":   map[string]interface{}{"command": "git-mcp"},
+		}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	// The last sync saw fetch with other args and a server that is gone from the gist now
+	as.updateMergeBase(map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{"command": "uvx", "args": []interface{}{"--old"}},
+			"old":   map[string]interface{}{"command": "old-mcp"},
+		}},
+	}, "pull")
+
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	if log := findSyncLog(t, as, "pull"); log == nil || !strings.Contains(log.Message, "+1 server, ~1 changed, -1 removed across 1 agent") {
+		t.Errorf("pull log = %+v", log)
+	}
+}
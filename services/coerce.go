@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Gist 中的配置是任意 JSON，args 和 env 中可能出现数字或布尔值。写入 agent 前按以下规则统一转换：
+//   - env 的值是环境变量，总是转换为字符串：数字写成不带指数和多余小数位的十进制（8080、0.5），
+//     布尔值写成 true/false，对象和数组写成 JSON 文本，null 的键被删除
+//   - args 中的数字和布尔值按同样的规则转换为字符串，null 被删除；对象等非标量参数在 JSON 格式中原样保留，
+//     在 Codex 中无法表示，会被跳过
+//
+// 这样同一个服务器写入 JSON、JSON5、Zed 和 Codex 时得到的值一致。
+
+// scalarString 按上述规则把 JSON 标量转换为字符串；value 不是标量（或为 null）时返回 false
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// coerceEnv 返回值全部为字符串的 env；env 不是对象时原样返回
+func coerceEnv(env interface{}) interface{} {
+	switch values := env.(type) {
+	case map[string]string:
+		return values
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			if value == nil {
+				continue
+			}
+			if s, ok := scalarString(value); ok {
+				result[key] = s
+			} else if data, err := json.Marshal(value); err == nil {
+				result[key] = string(data)
+			}
+		}
+		return result
+	}
+	return env
+}
+
+// coerceArgs 返回标量参数转换为字符串后的 args，非标量参数原样保留；args 不是数组时原样返回
+func coerceArgs(args interface{}) interface{} {
+	values, ok := args.([]interface{})
+	if !ok {
+		return args
+	}
+	result := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		if s, ok := scalarString(value); ok {
+			result = append(result, s)
+		} else {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// coerceServers 返回 args 和 env 按上述规则转换后的标准格式服务器，不修改传入的 map
+func coerceServers(servers map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(servers))
+	for name, server := range servers {
+		serverMap, ok := server.(map[string]interface{})
+		if !ok {
+			result[name] = server
+			continue
+		}
+		coerced := make(map[string]interface{}, len(serverMap))
+		for key, value := range serverMap {
+			coerced[key] = value
+		}
+		if args, ok := coerced["args"]; ok {
+			coerced["args"] = coerceArgs(args)
+		}
+		if env, ok := coerced["env"]; ok {
+			coerced["env"] = coerceEnv(env)
+		}
+		result[name] = coerced
+	}
+	return result
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// CompactStorage 整理数据目录：按备份保留策略清理备份，把未加密或用旧密码加密的文件改写为当前的加密方式，
+// 把无法解密的版本、日志、待推送和备份文件移到 orphaned 目录（不删除，找回密钥后可手动移回），并按现有版本文件重建版本索引。
+// 密钥丢失或没有任何文件能用当前密钥解密时不移动文件，只在 Kept 中报告。持有同步锁，任何时候运行都是安全的
+func (as *AppService) CompactStorage() (*models.CompactReport, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	report := &models.CompactReport{Reencrypted: []string{}, Quarantined: []string{}}
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+	if retention := config.BackupRetention; retention != nil {
+		pruned, err := as.storage.PruneBackups(retention.MaxCount, time.Duration(retention.MaxAgeDays)*24*time.Hour)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prune backups: %w", err)
+		}
+		report.BackupsPruned = pruned
+	}
+
+	if err := as.storage.compactDataFiles(report); err != nil {
+		return report, fmt.Errorf("failed to compact data files: %w", err)
+	}
+	if err := as.storage.rebuildVersionIndex(report); err != nil {
+		return report, fmt.Errorf("failed to rebuild version index: %w", err)
+	}
+
+	message := fmt.Sprintf("Storage compacted: %d backups pruned, %d files re-encrypted, %d unreadable files set aside, %d kept",
+		report.BackupsPruned, len(report.Reencrypted), len(report.Quarantined), len(report.Kept))
+	if report.QuarantineDir != "" {
+		message += ", unreadable files moved to " + report.QuarantineDir
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "compact_storage",
+		Status:    "success",
+		Message:   message,
+	})
+
+	return report, nil
+}
+
+// inCurrentScheme 判断已能解密的 data 是否已经是当前的加密方式：启用了密钥环加密时要求能用主密钥解密，
+// 只启用了旧密码加密时要求已加密，没有启用加密时要求未加密
+func (s *StorageService) inCurrentScheme(data []byte) bool {
+	if s.crypto != nil && s.crypto.IsEnabled() {
+		if !s.isEncrypted(data) {
+			return false
+		}
+		_, err := s.crypto.DecryptIfNeeded(data)
+		return err == nil
+	}
+	if s.securityMgr != nil && s.oldEnabled {
+		return s.isEncrypted(data)
+	}
+	return !s.isEncrypted(data)
+}
+
+// compactDataFiles 把数据文件改写为当前的加密方式，并把无法解密的版本、日志、待推送和备份文件移到
+// orphaned 目录（与 OrphanEncryptedFiles 相同）。顶层文件（同步配置等）即使无法解密也不移动；
+// 版本索引由 rebuildVersionIndex 重建，这里跳过
+func (s *StorageService) compactDataFiles(report *models.CompactReport) error {
+	type unreadable struct {
+		rel string
+		err error
+	}
+	var broken []unreadable
+	readable := 0
+
+	for _, path := range dataFilePaths(s.fs, s.dataDir) {
+		if path == s.versionIndexPath() {
+			continue
+		}
+		rel, err := filepath.Rel(s.dataDir, path)
+		if err != nil {
+			return err
+		}
+		data, err := s.fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := s.decryptIfNeeded(data)
+		if err != nil {
+			broken = append(broken, unreadable{rel, err})
+			continue
+		}
+		if s.isEncrypted(data) {
+			readable++
+		}
+		if s.inCurrentScheme(data) {
+			continue
+		}
+
+		rewritten, err := s.encryptIfNeeded(plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", rel, err)
+		}
+		if err := s.fs.WriteFile(path, rewritten, 0644); err != nil {
+			return err
+		}
+		// Confirm the file reads back to the same content before moving on
+		written, err := s.fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if check, err := s.decryptIfNeeded(written); err != nil || !bytes.Equal(check, plaintext) || !s.inCurrentScheme(written) {
+			return fmt.Errorf("%w %s: it does not read back after re-encrypting", ErrDecryptFailed, rel)
+		}
+		report.Reencrypted = append(report.Reencrypted, rel)
+	}
+
+	for _, file := range broken {
+		entry := fmt.Sprintf("%s: %v", file.rel, file.err)
+		switch {
+		case errors.Is(file.err, ErrEncryptionKeyMissing):
+			report.Kept = append(report.Kept, entry+" (restore the key or reset encryption)")
+		case readable == 0:
+			// Nothing decrypts, so the key itself is more likely wrong than these files orphaned
+			report.Kept = append(report.Kept, entry+" (no file decrypts with the current key)")
+		case !strings.Contains(file.rel, string(filepath.Separator)):
+			report.Kept = append(report.Kept, entry+" (top-level state files are never moved)")
+		default:
+			if report.QuarantineDir == "" {
+				report.QuarantineDir = filepath.Join(s.dataDir, fmt.Sprintf("orphaned_%d", s.clock.Now().UnixNano()))
+			}
+			target := filepath.Join(report.QuarantineDir, file.rel)
+			println(fmt.Sprintf("Moving unreadable file %s to %s", entry, target))
+			if err := s.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := s.fs.Rename(filepath.Join(s.dataDir, file.rel), target); err != nil {
+				return fmt.Errorf("failed to move %s: %w", file.rel, err)
+			}
+			report.Quarantined = append(report.Quarantined, entry)
+		}
+	}
+	return nil
+}
+
+// rebuildVersionIndex 按 versions 目录中能读取的版本文件重建版本索引，hash 都按 canonicalConfigHash 重新计算，
+// 这样索引中不会留下已删除版本的条目或旧的原文 hash。索引没有变化且已是当前加密方式时不改写
+func (s *StorageService) rebuildVersionIndex(report *models.CompactReport) error {
+	names, err := s.versionFileNames()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]string, len(names))
+	for _, name := range names {
+		version, err := s.readConfigVersion(filepath.Join(s.dataDir, "versions", name))
+		if err != nil {
+			continue
+		}
+		index[name] = canonicalConfigHash(version.Content)
+	}
+
+	previous := s.loadVersionIndex()
+	for name := range previous {
+		if _, ok := index[name]; !ok {
+			report.IndexDropped++
+		}
+	}
+	report.IndexEntries = len(index)
+
+	data, err := s.fs.ReadFile(s.versionIndexPath())
+	if err != nil && len(index) == 0 {
+		return nil
+	}
+	if err == nil && s.inCurrentScheme(data) && reflect.DeepEqual(previous, index) {
+		if plaintext, err := s.decryptIfNeeded(data); err == nil && json.Valid(plaintext) {
+			return nil
+		}
+	}
+	return s.saveVersionIndex(index)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mcp-sync/models"
+)
+
+// writeForeignVersion writes a version file encrypted with a key this install does not have
+func writeForeignVersion(t *testing.T, as *AppService, name string) string {
+	t.Helper()
+	other := NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	if err := other.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(models.ConfigVersion{ID: "foreign", Content: `{"cursor": {}}`})
+	encrypted, err := other.EncryptIfNeeded(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(as.storage.GetDataDir(), "versions", name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, encrypted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCompactStorageTidiesMessyDataDir(t *testing.T) {
+	as := newTestAppService(t)
+	storage := as.storage
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	// Plaintext data from before encryption was turned on
+	storage.SaveSyncConfig(models.SyncConfig{ID: "default", BackupRetention: &models.BackupRetention{MaxCount: 1}})
+	storage.SaveConfigVersion(models.ConfigVersion{ID: "v1", Content: `{"cursor": {"mcpServers": {"a": {"command": "a"}}}}`})
+	storage.SaveSyncLog(models.SyncLog{ID: "l1", Action: "push", Status: "success"})
+	for i := 0; i < 3; i++ {
+		if _, err := storage.BackupAgentFile("cursor", cursorPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A version encrypted with the legacy password
+	storage.securityMgr = NewSecurityManager("legacy-pass")
+	storage.oldEnabled = true
+	storage.SaveConfigVersion(models.ConfigVersion{ID: "v2", Content: `{"cursor": {"mcpServers": {"b": {"command": "b"}}}}`})
+
+	// The current keyring key, a version under it, an orphan under another key and a stale index entry
+	storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	if err := storage.crypto.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	storage.SaveConfigVersion(models.ConfigVersion{ID: "v3", Content: `{"cursor": {"mcpServers": {"c": {"command": "c"}}}}`})
+	orphanPath := writeForeignVersion(t, as, "version_1.json")
+	index := storage.loadVersionIndex()
+	index["version_0.json"] = "stale"
+	if err := storage.saveVersionIndex(index); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := as.CompactStorage()
+	if err != nil {
+		t.Fatalf("CompactStorage() error = %v", err)
+	}
+	if report.BackupsPruned != 2 {
+		t.Errorf("BackupsPruned = %d, want 2", report.BackupsPruned)
+	}
+	if len(report.Quarantined) != 1 || !strings.Contains(report.Quarantined[0], "version_1.json") || len(report.Kept) != 0 {
+		t.Errorf("Quarantined = %v, Kept = %v, want only the orphaned version set aside", report.Quarantined, report.Kept)
+	}
+	if _, err := os.Stat(orphanPath); !os.IsNotExist(err) {
+		t.Errorf("orphaned version still exists: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(report.QuarantineDir, "versions", "version_1.json")); err != nil {
+		t.Errorf("orphaned version was not moved to the quarantine dir: %v", err)
+	}
+	// Config, the plaintext and legacy versions, the log and the remaining backup
+	if len(report.Reencrypted) != 5 {
+		t.Errorf("Reencrypted = %v, want 5 files", report.Reencrypted)
+	}
+
+	// Everything now reads with the keyring key alone
+	storage.securityMgr = nil
+	storage.oldEnabled = false
+	for _, path := range dataFilePaths(storage.fs, storage.GetDataDir()) {
+		data := readFile(t, path)
+		if !strings.HasPrefix(data, "ENC:") {
+			t.Errorf("%s is not encrypted", filepath.Base(path))
+		}
+		if _, err := storage.crypto.DecryptIfNeeded([]byte(data)); err != nil {
+			t.Errorf("%s does not decrypt with the keyring key: %v", filepath.Base(path), err)
+		}
+	}
+	versions, skipped, err := storage.ListConfigVersionsWithSkipped(10)
+	if err != nil || len(versions) != 3 || skipped != 0 {
+		t.Errorf("ListConfigVersionsWithSkipped() = %d versions, %d skipped, %v; want 3 and 0", len(versions), skipped, err)
+	}
+
+	// The index holds exactly the version files, with semantic hashes
+	names, _ := storage.versionFileNames()
+	want := make(map[string]string)
+	for _, name := range names {
+		version, err := storage.readConfigVersion(filepath.Join(storage.GetDataDir(), "versions", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[name] = canonicalConfigHash(version.Content)
+	}
+	if got := storage.loadVersionIndex(); !reflect.DeepEqual(got, want) {
+		t.Errorf("version index = %v, want %v", got, want)
+	}
+	if report.IndexEntries != 3 || report.IndexDropped != 1 {
+		t.Errorf("IndexEntries = %d, IndexDropped = %d, want 3 and 1 (the stale entry)", report.IndexEntries, report.IndexDropped)
+	}
+	if findSyncLog(t, as, "compact_storage") == nil {
+		t.Error("no compact_storage sync log")
+	}
+
+	// A second run finds nothing to do
+	again, err := as.CompactStorage()
+	if err != nil {
+		t.Fatalf("second CompactStorage() error = %v", err)
+	}
+	if again.BackupsPruned != 0 || len(again.Reencrypted) != 0 || len(again.Quarantined) != 0 || again.IndexDropped != 0 {
+		t.Errorf("second CompactStorage() = %+v, want nothing done", again)
+	}
+}
+
+func TestCompactStorageKeepsFilesItCannotJudge(t *testing.T) {
+	tests := []struct {
+		name   string
+		crypto bool
+		reason string
+	}{
+		{name: "key missing", crypto: false, reason: "reset encryption"},
+		{name: "nothing decrypts", crypto: true, reason: "no file decrypts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as := newTestAppService(t)
+			if tt.crypto {
+				as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+				if err := as.storage.crypto.Enable(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := writeForeignVersion(t, as, "version_1.json")
+
+			report, err := as.CompactStorage()
+			if err != nil {
+				t.Fatalf("CompactStorage() error = %v", err)
+			}
+			if len(report.Quarantined) != 0 || len(report.Kept) != 1 || !strings.Contains(report.Kept[0], tt.reason) {
+				t.Errorf("Quarantined = %v, Kept = %v, want the file kept (%s)", report.Quarantined, report.Kept, tt.reason)
+			}
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("unreadable version was moved: %v", err)
+			}
+		})
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"embed"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"gopkg.in/yaml.v2"
 )
@@ -20,6 +25,11 @@ type AgentDefinition struct {
 	Platforms   map[string]PlatformConfig `yaml:"platforms"`
 	ConfigKey   string                    `yaml:"config_key"`
 	Format      string                    `yaml:"format"`
+	// SchemaURL 和 SchemaPath 指向 agent 发布的 JSON schema，供 ValidateAgainstSchema 使用；同时设置时优先使用本地文件
+	SchemaURL  string `yaml:"schema_url"`
+	SchemaPath string `yaml:"schema_path"`
+	// ExtraSyncKeys 是与服务器一起同步的其他顶层设置（例如超时、允许的工具），原样收集和写回
+	ExtraSyncKeys []string `yaml:"extra_sync_keys"`
 }
 
 type PlatformConfig struct {
@@ -39,18 +49,52 @@ type AgentsConfig struct {
 	Agents     []AgentDefinition        `yaml:"agents"`
 }
 
+// ConfigLoader 由 detector、config manager 和 converter 共享；Reload 会原地替换其中的定义，
+// 所以它们总是看到同一份定义。发布后的 AgentsConfig 不再修改
 type ConfigLoader struct {
+	// mu guards config, which Reload swaps for a newly loaded one
+	mu     sync.RWMutex
 	config *AgentsConfig
+	// goos 选择使用哪个平台的配置路径，为空时使用 runtime.GOOS（测试中可以模拟其他平台）
+	goos string
 }
 
+// NewConfigLoader 加载 agents.yaml：优先使用磁盘上的文件（开发时），否则使用内嵌的文件，再合并 UserAgentsDir 中用户的定义。
+// 文件有语法错误或没有定义任何 agent 时返回 *AgentsConfigError，不会退回到内嵌的文件
 func NewConfigLoader() (*ConfigLoader, error) {
+	config, err := loadAgentsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newConfigLoader(config), nil
+}
+
+// Reload 重新读取并校验 agents.yaml 和 UserAgentsDir 中的定义，成功后替换当前的定义。
+// 出错时保留原来的定义并返回错误
+func (cl *ConfigLoader) Reload() error {
+	config, err := loadAgentsConfig()
+	if err != nil {
+		return err
+	}
+	printAgentsConfigProblems(config)
+	cl.mu.Lock()
+	cl.config = config
+	cl.mu.Unlock()
+	return nil
+}
+
+// loadAgentsConfig 读取 agents.yaml（磁盘上的或内嵌的）并合并用户的定义
+func loadAgentsConfig() (*AgentsConfig, error) {
 	// Try to load from disk first (for development)
-	data, err := os.ReadFile("services/agents.yaml")
+	source := "services/agents.yaml"
+	data, err := os.ReadFile(source)
 	if err != nil {
 		// Try current directory
-		data, err = os.ReadFile("agents.yaml")
+		source = "agents.yaml"
+		data, err = os.ReadFile(source)
 		if err != nil {
 			// Fall back to embedded file
+			source = "embedded agents.yaml"
 			data, err = configFS.ReadFile("agents.yaml")
 			if err != nil {
 				return nil, fmt.Errorf("failed to load agents.yaml: %w", err)
@@ -58,20 +102,138 @@ func NewConfigLoader() (*ConfigLoader, error) {
 		}
 	}
 
+	config, err := parseAgentsConfig(source, data)
+	if err != nil {
+		return nil, err
+	}
+	if dir, err := UserAgentsDir(); err == nil {
+		if err := mergeUserAgents(config, dir); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// AgentsConfigError 是 agents.yaml 无法使用时的错误，带有出错的位置和该行的内容
+type AgentsConfigError struct {
+	Source  string // 文件路径，内嵌文件为 "embedded agents.yaml"
+	Line    int    // 从 1 开始的行号；YAML 解析器没有给出位置时为 0
+	Message string
+	Context string // 第 Line 行的原文
+	Err     error
+}
+
+func (e *AgentsConfigError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid %s", e.Source)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " at line %d", e.Line)
+	}
+	b.WriteString(": " + e.Message)
+	if e.Context != "" {
+		fmt.Fprintf(&b, "\n  %d | %s", e.Line, e.Context)
+	}
+	return b.String()
+}
+
+func (e *AgentsConfigError) Unwrap() error { return e.Err }
+
+// yamlLinePattern 匹配 yaml.v2 错误信息中的 "line N: "
+var yamlLinePattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// newAgentsConfigError 把 yaml.Unmarshal 的错误转换为 *AgentsConfigError。
+// yaml.v2 只报告行号；类型错误可能有多条，只定位第一条，其余计入消息
+func newAgentsConfigError(source string, data []byte, err error) *AgentsConfigError {
+	configErr := &AgentsConfigError{Source: source, Message: strings.TrimPrefix(err.Error(), "yaml: "), Err: err}
+
+	problem := configErr.Message
+	more := 0
+	if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+		problem = typeErr.Errors[0]
+		more = len(typeErr.Errors) - 1
+	}
+	if match := yamlLinePattern.FindStringSubmatch(problem); match != nil {
+		configErr.Line, _ = strconv.Atoi(match[1])
+		configErr.Message = match[2]
+		if more > 0 {
+			configErr.Message += fmt.Sprintf(" (and %d more problems)", more)
+		}
+		lines := strings.Split(string(data), "\n")
+		if configErr.Line <= len(lines) {
+			configErr.Context = strings.TrimRight(lines[configErr.Line-1], "\r")
+		}
+	}
+	return configErr
+}
+
+// newConfigLoaderFromYAML parses an agents.yaml document and logs format pairs that cannot be converted
+func newConfigLoaderFromYAML(data []byte) (*ConfigLoader, error) {
+	config, err := parseAgentsConfig("agents.yaml", data)
+	if err != nil {
+		return nil, err
+	}
+	return newConfigLoader(config), nil
+}
+
+// newConfigLoader 创建使用 config 的加载器，并打印不完整的 agent 定义和无法转换的格式
+func newConfigLoader(config *AgentsConfig) *ConfigLoader {
+	printAgentsConfigProblems(config)
+	return &ConfigLoader{config: config}
+}
+
+// printAgentsConfigProblems 打印 config 中不完整的 agent 定义和无法转换的格式
+func printAgentsConfigProblems(config *AgentsConfig) {
+	loader := &ConfigLoader{config: config}
+	for _, problem := range append(loader.ValidateAgentDefinitions(), loader.ValidateTransforms()...) {
+		println(fmt.Sprintf("Warning: agents.yaml: %s", problem))
+	}
+}
+
+// definitions 返回当前的定义；调用方在一次操作中应只取一次，以免中途被 Reload 替换
+func (cl *ConfigLoader) definitions() *AgentsConfig {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	return cl.config
+}
+
+// parseAgentsConfig 解析 source（用于错误信息的文件名）中的 agents.yaml 内容，至少要定义一个 agent
+func parseAgentsConfig(source string, data []byte) (*AgentsConfig, error) {
+	config, err := decodeAgentsConfig(source, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Agents) == 0 {
+		return nil, &AgentsConfigError{Source: source, Message: "no agents are defined"}
+	}
+	return config, nil
+}
+
+// decodeAgentsConfig 解码 agents.yaml 格式的内容，并检查每个 agent 都有唯一的 id
+func decodeAgentsConfig(source string, data []byte) (*AgentsConfig, error) {
 	var config AgentsConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse agents.yaml: %w", err)
+		return nil, newAgentsConfigError(source, data, err)
 	}
-
-	return &ConfigLoader{config: &config}, nil
+	// Every other lookup is by ID, so an agent without one (or sharing one) can never be used
+	seenIDs := make(map[string]bool, len(config.Agents))
+	for i, agent := range config.Agents {
+		if strings.TrimSpace(agent.ID) == "" {
+			return nil, &AgentsConfigError{Source: source, Message: fmt.Sprintf("agent #%d (%s) has no id", i+1, agent.Name)}
+		}
+		if seenIDs[agent.ID] {
+			return nil, &AgentsConfigError{Source: source, Message: fmt.Sprintf("agent id %q is defined more than once", agent.ID)}
+		}
+		seenIDs[agent.ID] = true
+	}
+	return &config, nil
 }
 
 func (cl *ConfigLoader) GetAgentDefinitions() []AgentDefinition {
-	return cl.config.Agents
+	return cl.definitions().Agents
 }
 
 func (cl *ConfigLoader) GetAgentDefinition(agentID string) *AgentDefinition {
-	for _, agent := range cl.config.Agents {
+	for _, agent := range cl.definitions().Agents {
 		if agent.ID == agentID {
 			return &agent
 		}
@@ -79,29 +241,59 @@ func (cl *ConfigLoader) GetAgentDefinition(agentID string) *AgentDefinition {
 	return nil
 }
 
-// ExpandPath expands paths like ~, $APPDATA, $ProgramData
-func (cl *ConfigLoader) ExpandPath(path string) string {
-	homeDir := os.Getenv("HOME")
-	if homeDir == "" {
-		homeDir = os.Getenv("USERPROFILE")
+// platform returns the platform whose config paths are used
+func (cl *ConfigLoader) platform() string {
+	if cl.goos != "" {
+		return cl.goos
 	}
+	return runtime.GOOS
+}
 
-	// Replace ~ with home directory
-	path = strings.ReplaceAll(path, "~", homeDir)
-
-	// Replace $APPDATA
-	if runtime.GOOS == "windows" {
-		appData := os.Getenv("APPDATA")
-		path = strings.ReplaceAll(path, "$APPDATA", appData)
+// ExpandPath expands a leading ~, $APPDATA or $ProgramData and joins the remaining segments with filepath.Join,
+// so the result uses the platform's separators and segments with spaces, unicode or parentheses
+// (~/Library/Application Support, C:\Program Files (x86)) are kept as they are
+func (cl *ConfigLoader) ExpandPath(path string) string {
+	segments := splitPathSegments(path, cl.platform() == "windows")
+	if len(segments) == 0 {
+		return path
+	}
 
-		programData := os.Getenv("ProgramData")
-		if programData == "" {
-			programData = "C:\\ProgramData"
+	var base string
+	switch segments[0] {
+	case "~":
+		// A ~ elsewhere (e.g. PROGRA~1) is part of the name
+		base = userHomeDir()
+	case "$APPDATA":
+		if cl.platform() != "windows" {
+			return path
+		}
+		base = os.Getenv("APPDATA")
+		if base == "" && userHomeDir() != "" {
+			base = filepath.Join(userHomeDir(), "AppData", "Roaming")
+		}
+	case "$ProgramData":
+		if cl.platform() != "windows" {
+			return path
+		}
+		base = os.Getenv("ProgramData")
+		if base == "" {
+			base = "C:\\ProgramData"
 		}
-		path = strings.ReplaceAll(path, "$ProgramData", programData)
+	default:
+		return path
 	}
+	if base == "" {
+		return path
+	}
+
+	return filepath.Join(append([]string{base}, segments[1:]...)...)
+}
 
-	return path
+// splitPathSegments splits a config path on / (and \ for Windows paths), dropping empty segments
+func splitPathSegments(path string, windows bool) []string {
+	return strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || (windows && r == '\\')
+	})
 }
 
 // GetConfigPathsForAgent returns all possible config paths for an agent on current platform
@@ -111,8 +303,7 @@ func (cl *ConfigLoader) GetConfigPathsForAgent(agentID string) []string {
 		return nil
 	}
 
-	goos := runtime.GOOS
-	platformConfig, exists := agent.Platforms[goos]
+	platformConfig, exists := agent.Platforms[cl.platform()]
 	if !exists {
 		return nil
 	}
@@ -158,6 +349,51 @@ func (cl *ConfigLoader) GetFormat(agentID string) string {
 	return agent.Format
 }
 
+// GetExtraSyncKeys 返回 agent 在服务器之外同步的顶层设置键；无法使用的键（见 extraSyncKeyProblem）不包括在内
+func (cl *ConfigLoader) GetExtraSyncKeys(agentID string) []string {
+	agent := cl.GetAgentDefinition(agentID)
+	if agent == nil {
+		return nil
+	}
+	var keys []string
+	for _, key := range agent.ExtraSyncKeys {
+		if extraSyncKeyProblem(*agent, key) == "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// extraSyncKeyProblem 说明 key 为什么不能作为 agent 的 extra_sync_keys，可以使用时返回空字符串。
+// 服务器所在的键不能作为设置同步，否则会被当作服务器读取
+func extraSyncKeyProblem(agent AgentDefinition, key string) string {
+	switch {
+	case strings.TrimSpace(key) == "":
+		return "empty key in extra_sync_keys"
+	case key == agent.ConfigKey || key == "mcpServers" || key == "context_servers" || key == "mcp_servers" || key == disabledServersKey:
+		return fmt.Sprintf("extra_sync_keys entry %q is a servers key", key)
+	}
+	if _, ok := formatAdapterFor(agent.Format).(SettingsAdapter); !ok {
+		return fmt.Sprintf("extra_sync_keys are not supported for format %q", agent.Format)
+	}
+	return ""
+}
+
+// isTOMLFormat reports whether an agent format is stored as TOML (Codex)
+func isTOMLFormat(format string) bool {
+	switch format {
+	case "codex_toml", "codex", "toml":
+		return true
+	}
+	return false
+}
+
+// hasStandardServers reports whether an agent format stores servers in the standard mcpServers
+// structure; JSON5 differs from standard only in file syntax
+func hasStandardServers(format string) bool {
+	return format == "standard" || format == "json5"
+}
+
 // GetFirstExistingPath returns the first path that exists
 func (cl *ConfigLoader) GetFirstExistingPath(agentID string) (string, error) {
 	paths := cl.GetConfigPathsForAgent(agentID)
@@ -178,13 +414,147 @@ func (cl *ConfigLoader) GetFirstExistingPath(agentID string) (string, error) {
 // GetTransformRule returns the transform rule for converting between two formats
 func (cl *ConfigLoader) GetTransformRule(fromFormat, toFormat string) *TransformRule {
 	key := fromFormat + "_to_" + toFormat
-	rule, exists := cl.config.Transforms[key]
+	rule, exists := cl.definitions().Transforms[key]
 	if !exists {
 		return nil
 	}
 	return &rule
 }
 
+// transformFormat returns the format used to look up transform rules. Formats whose servers are read and
+// written in the standard structure (json5, and codex_toml through the TOML adapter) convert as standard
+func transformFormat(format string) string {
+	if hasStandardServers(format) || isTOMLFormat(format) {
+		return "standard"
+	}
+	return format
+}
+
+// GetTransformChain returns the rules that convert fromFormat to toFormat, in order: the direct rule if
+// there is one, otherwise fromFormat→standard followed by standard→toFormat. Formats that convert as the
+// same format need no rules. ok is false when there is no way to convert.
+func (cl *ConfigLoader) GetTransformChain(fromFormat, toFormat string) ([]*TransformRule, bool) {
+	from, to := transformFormat(fromFormat), transformFormat(toFormat)
+	if from == to {
+		return nil, true
+	}
+	if rule := cl.GetTransformRule(from, to); rule != nil {
+		return []*TransformRule{rule}, true
+	}
+
+	var chain []*TransformRule
+	if from != "standard" {
+		rule := cl.GetTransformRule(from, "standard")
+		if rule == nil {
+			return nil, false
+		}
+		chain = append(chain, rule)
+	}
+	if to != "standard" {
+		rule := cl.GetTransformRule("standard", to)
+		if rule == nil {
+			return nil, false
+		}
+		chain = append(chain, rule)
+	}
+	return chain, true
+}
+
+// knownPlatforms 是 agents.yaml 中 platforms 可以使用的键，与 runtime.GOOS 一致
+var knownPlatforms = []string{"darwin", "linux", "windows"}
+
+// ValidateAgentDefinitions reports agent definitions that load but cannot be detected or synced: a missing
+// config_key or format, a format with neither an adapter nor transform rules, an unknown platform key, or
+// no config path on any platform. Each problem starts with the agent ID, e.g. "cursor: no config_key".
+func (cl *ConfigLoader) ValidateAgentDefinitions() []string {
+	var problems []string
+	for _, agent := range cl.definitions().Agents {
+		add := func(format string, args ...interface{}) {
+			problems = append(problems, agent.ID+": "+fmt.Sprintf(format, args...))
+		}
+
+		if agent.ConfigKey == "" {
+			add("no config_key")
+		}
+		_, hasAdapter := formatAdapters[agent.Format]
+		switch {
+		case agent.Format == "":
+			add("no format")
+		case !hasAdapter && cl.GetTransformRule(agent.Format, "standard") == nil && cl.GetTransformRule("standard", agent.Format) == nil:
+			add("unknown format %q (no adapter and no transform rules)", agent.Format)
+		}
+
+		hasPath := false
+		for _, platform := range sortedPlatformKeys(agent.Platforms) {
+			if !containsPlatform(platform) {
+				add("unknown platform %q, expected one of %s", platform, strings.Join(knownPlatforms, ", "))
+			}
+			for _, path := range agent.Platforms[platform].ConfigPaths {
+				if strings.TrimSpace(path) != "" {
+					hasPath = true
+				}
+			}
+		}
+		if !hasPath {
+			add("no config_paths on any platform")
+		}
+		for _, key := range agent.ExtraSyncKeys {
+			if problem := extraSyncKeyProblem(agent, key); problem != "" {
+				add("%s", problem)
+			}
+		}
+	}
+	return problems
+}
+
+// sortedPlatformKeys 返回 platforms 的键，按字母排序
+func sortedPlatformKeys(platforms map[string]PlatformConfig) []string {
+	keys := make([]string, 0, len(platforms))
+	for key := range platforms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsPlatform 判断 platform 是否是 knownPlatforms 之一
+func containsPlatform(platform string) bool {
+	for _, known := range knownPlatforms {
+		if platform == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransforms reports every ordered pair of declared agent formats that has neither a direct
+// transform rule nor a path through the standard format, e.g. "zed -> foo: no zed_to_foo rule ...".
+func (cl *ConfigLoader) ValidateTransforms() []string {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, agent := range cl.definitions().Agents {
+		format := transformFormat(agent.Format)
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+	sort.Strings(formats)
+
+	var problems []string
+	for _, from := range formats {
+		for _, to := range formats {
+			if from == to {
+				continue
+			}
+			if _, ok := cl.GetTransformChain(from, to); !ok {
+				problems = append(problems, fmt.Sprintf("%s -> %s: no %s_to_%s rule and no path through standard", from, to, from, to))
+			}
+		}
+	}
+	return problems
+}
+
 // ApplyTransformRule applies a transformation rule to the server data
 func (cl *ConfigLoader) ApplyTransformRule(data interface{}, rule *TransformRule) interface{} {
 	if rule == nil {
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newPlatformDetector returns a detector that resolves config paths as on goos
+func newPlatformDetector(t *testing.T, goos string) *AgentDetector {
+	t.Helper()
+
+	loader, err := NewConfigLoader()
+	if err != nil {
+		t.Fatalf("NewConfigLoader() error = %v", err)
+	}
+	loader.goos = goos
+	return &AgentDetector{configLoader: loader}
+}
+
+func TestDetectClaudeDesktopOnMacOSWithSpacedPath(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "Jane Doe")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", "")
+
+	path := filepath.Join(home, "Library", "Application Support", "Claude", "claude_desktop_config.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`{"mcpServers": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	detector := newPlatformDetector(t, "darwin")
+	agents, err := detector.DetectInstalledAgents()
+	if err != nil {
+		t.Fatalf("DetectInstalledAgents() error = %v", err)
+	}
+	var found bool
+	for _, agent := range agents {
+		if agent.ID != "claude-desktop" {
+			continue
+		}
+		found = true
+		if agent.Status != "detected" || !reflect.DeepEqual(agent.ExistingPaths, []string{path}) {
+			t.Errorf("claude-desktop = %+v, want detected at %s", agent, path)
+		}
+	}
+	if !found {
+		t.Fatalf("claude-desktop is not defined for darwin")
+	}
+	if got, err := detector.GetAgentConfigPath("claude-desktop"); err != nil || got != path {
+		t.Errorf("GetAgentConfigPath() = %q, %v, want %q", got, err, path)
+	}
+}
+
+func TestClaudeDesktopConfigPathOnWindows(t *testing.T) {
+	appData := filepath.Join(t.TempDir(), "AppData", "Roaming")
+	t.Setenv("APPDATA", appData)
+
+	detector := newPlatformDetector(t, "windows")
+	paths := detector.configLoader.GetConfigPathsForAgent("claude-desktop")
+	want := filepath.Join(appData, "Claude", "claude_desktop_config.json")
+	if len(paths) != 1 || filepath.Clean(paths[0]) != want {
+		t.Errorf("claude-desktop paths on windows = %v, want [%s]", paths, want)
+	}
+}
+
+func TestExpandPathOnlyExpandsLeadingTilde(t *testing.T) {
+	t.Setenv("HOME", "/home/jane")
+
+	loader := &ConfigLoader{goos: "linux"}
+	tests := map[string]string{
+		"~":                               "/home/jane",
+		"~/Library/Application Support/x": "/home/jane/Library/Application Support/x",
+		"/opt/PROGRA~1/config.json":       "/opt/PROGRA~1/config.json",
+	}
+	for path, want := range tests {
+		if got := loader.ExpandPath(path); got != want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestExpandPathKeepsSpacesUnicodeAndParentheses(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "Jöhn Dœ", "用户")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", "")
+	t.Setenv("APPDATA", filepath.Join(home, "App Data (x86)"))
+	t.Setenv("ProgramData", "")
+
+	windows := &ConfigLoader{goos: "windows"}
+	darwin := &ConfigLoader{goos: "darwin"}
+	tests := []struct {
+		loader *ConfigLoader
+		path   string
+		want   string
+	}{
+		{darwin, "~/Library/Application Support/Zed/settings.json", filepath.Join(home, "Library", "Application Support", "Zed", "settings.json")},
+		{windows, "$APPDATA/Claude/claude_desktop_config.json", filepath.Join(home, "App Data (x86)", "Claude", "claude_desktop_config.json")},
+		{windows, "$ProgramData/gemini-cli/settings.json", filepath.Join("C:\\ProgramData", "gemini-cli", "settings.json")},
+		{windows, "~\\.cursor\\mcp.json", filepath.Join(home, ".cursor", "mcp.json")},
+		// $APPDATA only means something on Windows, and only at the start of a path
+		{darwin, "$APPDATA/Claude/config.json", "$APPDATA/Claude/config.json"},
+		{windows, "/opt/$APPDATA/config.json", "/opt/$APPDATA/config.json"},
+	}
+	for _, tt := range tests {
+		if got := tt.loader.ExpandPath(tt.path); got != tt.want {
+			t.Errorf("ExpandPath(%q) on %s = %q, want %q", tt.path, tt.loader.goos, got, tt.want)
+		}
+	}
+
+	// Without APPDATA, the roaming profile under the home directory is used
+	t.Setenv("APPDATA", "")
+	if got, want := windows.ExpandPath("$APPDATA/Zed/settings.json"), filepath.Join(home, "AppData", "Roaming", "Zed", "settings.json"); got != want {
+		t.Errorf("ExpandPath() without APPDATA = %q, want %q", got, want)
+	}
+}
+
+func TestAgentConfigUnderSpacedUnicodeHome(t *testing.T) {
+	as := newTestAppService(t)
+	home := filepath.Join(t.TempDir(), "Program Files (x86)", "Jöhn Dœ 用户")
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}}}`)
+	if !strings.HasPrefix(path, home) {
+		t.Fatalf("cursor config path %s is not under %s", path, home)
+	}
+
+	agents, err := as.DetectAgents()
+	if err != nil {
+		t.Fatalf("DetectAgents() error = %v", err)
+	}
+	for _, agent := range agents {
+		if agent.ID == "cursor" && (agent.Status != "detected" || !reflect.DeepEqual(agent.ExistingPaths, []string{path})) {
+			t.Errorf("cursor = %+v, want detected at %s", agent, path)
+		}
+	}
+
+	config, err := as.GetAgentMCPConfig("cursor")
+	if err != nil {
+		t.Fatalf("GetAgentMCPConfig() error = %v", err)
+	}
+	servers, _ := standardServersFrom(config, "mcpServers")
+	servers["time"] = map[string]interface{}{"command": "uvx", "args": []interface{}{"mcp-server-time"}}
+	if err := as.SaveAgentMCPConfig("cursor", map[string]interface{}{"mcpServers": servers}); err != nil {
+		t.Fatalf("SaveAgentMCPConfig() error = %v", err)
+	}
+
+	saved, err := as.standardAgentServers("cursor")
+	if err != nil {
+		t.Fatalf("standardAgentServers() error = %v", err)
+	}
+	if saved["fetch"] == nil || saved["time"] == nil {
+		t.Errorf("servers written under %s = %#v", home, saved)
+	}
+}
+
+func TestValidateTransformsReportsMissingRules(t *testing.T) {
+	loader, err := newConfigLoaderFromYAML([]byte(`
+transforms:
+  standard_to_zed:
+    add_fields:
+      source: custom
+  standard_to_acme:
+    keep_fields: [command]
+agents:
+  - id: cursor
+    format: standard
+  - id: codex
+    format: codex_toml
+  - id: zed
+    format: zed
+  - id: acme
+    format: acme
+`))
+	if err != nil {
+		t.Fatalf("newConfigLoaderFromYAML() error = %v", err)
+	}
+
+	want := []string{
+		"acme -> standard: no acme_to_standard rule and no path through standard",
+		"acme -> zed: no acme_to_zed rule and no path through standard",
+		"zed -> acme: no zed_to_acme rule and no path through standard",
+		"zed -> standard: no zed_to_standard rule and no path through standard",
+	}
+	if got := loader.ValidateTransforms(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidateTransforms() = %q, want %q", got, want)
+	}
+
+	// standard -> zed is direct, codex converts as standard, and nothing is needed between the two
+	if chain, ok := loader.GetTransformChain("codex_toml", "zed"); !ok || len(chain) != 1 || chain[0].AddFields["source"] != "custom" {
+		t.Errorf("GetTransformChain(codex_toml, zed) = %v, %v, want the standard_to_zed rule", chain, ok)
+	}
+	if chain, ok := loader.GetTransformChain("json5", "codex_toml"); !ok || len(chain) != 0 {
+		t.Errorf("GetTransformChain(json5, codex_toml) = %v, %v, want no rules needed", chain, ok)
+	}
+}
+
+func TestGetTransformChainGoesThroughStandard(t *testing.T) {
+	loader, err := newConfigLoaderFromYAML([]byte(`
+transforms:
+  zed_to_standard:
+    remove_fields: [source]
+  standard_to_acme:
+    add_fields:
+      type: stdio
+agents:
+  - id: zed
+    format: zed
+  - id: acme
+    format: acme
+`))
+	if err != nil {
+		t.Fatalf("newConfigLoaderFromYAML() error = %v", err)
+	}
+
+	chain, ok := loader.GetTransformChain("zed", "acme")
+	if !ok || len(chain) != 2 || len(chain[0].RemoveFields) != 1 || chain[1].AddFields["type"] != "stdio" {
+		t.Errorf("GetTransformChain(zed, acme) = %v, %v, want zed_to_standard then standard_to_acme", chain, ok)
+	}
+	if got := loader.ValidateTransforms(); len(got) != 1 || !strings.HasPrefix(got[0], "acme -> zed:") {
+		t.Errorf("ValidateTransforms() = %q, want only acme -> zed", got)
+	}
+}
+
+func TestBundledAgentsHaveAllTransforms(t *testing.T) {
+	loader, err := NewConfigLoader()
+	if err != nil {
+		t.Fatalf("NewConfigLoader() error = %v", err)
+	}
+	if problems := loader.ValidateTransforms(); len(problems) != 0 {
+		t.Errorf("agents.yaml is missing transforms: %q", problems)
+	}
+	if problems := loader.ValidateAgentDefinitions(); len(problems) != 0 {
+		t.Errorf("agents.yaml has incomplete agent definitions: %q", problems)
+	}
+}
+
+func TestMalformedAgentsYAMLReportsLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		line    int
+		message string
+		context string
+	}{
+		{"syntax", "agents:\n  - id: cursor\n    name: [unclosed\n    format: standard\n", 3, "did not find expected ',' or ']'", "    name: [unclosed"},
+		{"wrong type", "agents:\n  - id: cursor\n    platforms: oops\n", 3, "cannot unmarshal !!str `oops`", "    platforms: oops"},
+		{"no agents", "transforms: {}\n", 0, "no agents are defined", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader, err := newConfigLoaderFromYAML([]byte(tt.yaml))
+			var configErr *AgentsConfigError
+			if loader != nil || !errors.As(err, &configErr) {
+				t.Fatalf("newConfigLoaderFromYAML() = %v, %v, want *AgentsConfigError", loader, err)
+			}
+			if configErr.Line != tt.line || !strings.Contains(configErr.Message, tt.message) || configErr.Context != tt.context {
+				t.Errorf("error = %+v, want line %d, message %q, context %q", configErr, tt.line, tt.message, tt.context)
+			}
+		})
+	}
+}
+
+func TestBrokenAgentsYAMLOverrideFailsLoudly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "agents.yaml"), []byte("agents:\n  - id: cursor\n\tname: Cursor\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(DataDirEnv, "")
+
+	// The broken override is reported; the embedded file is not used in its place
+	_, err = NewConfigLoader()
+	var configErr *AgentsConfigError
+	if !errors.As(err, &configErr) || configErr.Source != "agents.yaml" || configErr.Line != 3 {
+		t.Fatalf("NewConfigLoader() error = %v, want agents.yaml line 3", err)
+	}
+	if !strings.Contains(err.Error(), "line 3") || !strings.Contains(err.Error(), "tab character") {
+		t.Errorf("error message %q lacks the position or the problem", err)
+	}
+
+	if detector, err := NewAgentDetector(); detector != nil || !errors.As(err, &configErr) {
+		t.Errorf("NewAgentDetector() = %v, %v, want the agents.yaml error", detector, err)
+	}
+	if manager, err := NewConfigManager(); manager != nil || !errors.As(err, &configErr) {
+		t.Errorf("NewConfigManager() = %v, %v, want the agents.yaml error", manager, err)
+	}
+	if as, err := NewAppServiceWithKeyring(t.TempDir(), NewInMemoryKeyring()); as != nil || !errors.As(err, &configErr) {
+		t.Errorf("NewAppServiceWithKeyring() = %v, %v, want the agents.yaml error", as, err)
+	}
+}
+
+func TestValidateAgentDefinitions(t *testing.T) {
+	const complete = `
+  - id: cursor
+    config_key: mcpServers
+    format: standard
+    platforms:
+      linux:
+        config_paths: [~/.cursor/mcp.json]
+`
+	tests := []struct {
+		name  string
+		agent string
+		want  []string
+	}{
+		{"complete", complete, nil},
+		{"no config_key", "\n  - id: cursor\n    format: standard\n    platforms:\n      linux:\n        config_paths: [~/.cursor/mcp.json]\n",
+			[]string{"cursor: no config_key"}},
+		{"no format", "\n  - id: cursor\n    config_key: mcpServers\n    platforms:\n      linux:\n        config_paths: [~/.cursor/mcp.json]\n",
+			[]string{"cursor: no format"}},
+		{"unknown format", "\n  - id: cursor\n    config_key: mcpServers\n    format: standrad\n    platforms:\n      linux:\n        config_paths: [~/.cursor/mcp.json]\n",
+			[]string{`cursor: unknown format "standrad" (no adapter and no transform rules)`}},
+		{"no platforms", "\n  - id: cursor\n    config_key: mcpServers\n    format: standard\n",
+			[]string{"cursor: no config_paths on any platform"}},
+		{"empty paths", "\n  - id: cursor\n    config_key: mcpServers\n    format: standard\n    platforms:\n      linux:\n        config_paths: []\n",
+			[]string{"cursor: no config_paths on any platform"}},
+		{"unknown platform", "\n  - id: cursor\n    config_key: mcpServers\n    format: standard\n    platforms:\n      macos:\n        config_paths: [~/.cursor/mcp.json]\n",
+			[]string{`cursor: unknown platform "macos", expected one of darwin, linux, windows`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader, err := newConfigLoaderFromYAML([]byte("agents:" + tt.agent))
+			if err != nil {
+				t.Fatalf("newConfigLoaderFromYAML() error = %v", err)
+			}
+			if got := loader.ValidateAgentDefinitions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateAgentDefinitions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A format with its own transform rules is known even without an adapter
+	loader, err := newConfigLoaderFromYAML([]byte("transforms:\n  standard_to_acme:\n    keep_fields: [command]\nagents:" +
+		strings.Replace(complete, "format: standard", "format: acme", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loader.ValidateAgentDefinitions(); len(got) != 0 {
+		t.Errorf("ValidateAgentDefinitions() = %q, want the acme format accepted", got)
+	}
+}
+
+func TestAgentDefinitionsWithoutUsableIDFailToLoad(t *testing.T) {
+	tests := map[string]string{
+		"no id":        "agents:\n  - name: Cursor\n    format: standard\n",
+		"duplicate id": "agents:\n  - id: cursor\n  - id: cursor\n",
+	}
+	for name, yaml := range tests {
+		_, err := newConfigLoaderFromYAML([]byte(yaml))
+		var configErr *AgentsConfigError
+		if !errors.As(err, &configErr) {
+			t.Errorf("%s: newConfigLoaderFromYAML() error = %v, want *AgentsConfigError", name, err)
+		}
+	}
+}
@@ -7,15 +7,26 @@ import (
 	"mcp-sync/models"
 	"os"
 	"path/filepath"
+	"reflect"
 )
 
 type ConfigManager struct {
 	detector *AgentDetector
 }
 
-func NewConfigManager() *ConfigManager {
+// NewConfigManager 创建配置管理器；agents.yaml 无法加载时返回错误
+func NewConfigManager() (*ConfigManager, error) {
+	detector, err := NewAgentDetector()
+	if err != nil {
+		return nil, err
+	}
+	return newConfigManager(detector), nil
+}
+
+// newConfigManager 创建使用 detector 的配置管理器
+func newConfigManager(detector *AgentDetector) *ConfigManager {
 	return &ConfigManager{
-		detector: NewAgentDetector(),
+		detector: detector,
 	}
 }
 
@@ -33,7 +44,7 @@ func (cm *ConfigManager) ReadAgentMCPConfig(agentID string) (models.MCPServer, e
 		return models.MCPServer{}, fmt.Errorf("config file not found: %s", configPath)
 	}
 
-	data, err := ioutil.ReadFile(configPath)
+	data, _, err := readConfigText(configPath)
 	if err != nil {
 		return models.MCPServer{}, err
 	}
@@ -67,7 +78,7 @@ func (cm *ConfigManager) WriteAgentMCPConfig(agentID string, servers []models.MC
 	var config map[string]interface{}
 
 	if fileExists(configPath) {
-		data, err := ioutil.ReadFile(configPath)
+		data, _, err := readConfigText(configPath)
 		if err != nil {
 			return err
 		}
@@ -133,7 +144,7 @@ func (cm *ConfigManager) WriteAgentMCPConfig(agentID string, servers []models.MC
 
 		serverConfig := map[string]interface{}{
 			"command": server.Command,
-			"args":    server.Args,
+			"args":    server.ArgList(),
 			"env":     server.Env,
 		}
 
@@ -148,6 +159,20 @@ func (cm *ConfigManager) WriteAgentMCPConfig(agentID string, servers []models.MC
 			}
 		}
 
+		// Tags are stored as a custom field on the server entry
+		if len(server.Tags) > 0 {
+			serverConfig["tags"] = server.Tags
+		}
+		if server.Description != "" {
+			serverConfig["description"] = server.Description
+		}
+		if len(server.Meta) > 0 {
+			serverConfig["_meta"] = server.Meta
+		}
+		if len(server.Headers) > 0 {
+			serverConfig["headers"] = server.Headers
+		}
+
 		existingMcpServers[server.Name] = serverConfig
 	}
 
@@ -159,7 +184,7 @@ func (cm *ConfigManager) WriteAgentMCPConfig(agentID string, servers []models.MC
 		return err
 	}
 
-	return ioutil.WriteFile(configPath, data, 0644)
+	return ioutil.WriteFile(configPath, configFileEncoding(configPath).encode(data), 0644)
 }
 
 func (cm *ConfigManager) GetAgentMCPConfig(agentID string) (map[string]interface{}, error) {
@@ -172,7 +197,7 @@ func (cm *ConfigManager) GetAgentMCPConfig(agentID string) (map[string]interface
 		return make(map[string]interface{}), nil
 	}
 
-	data, err := ioutil.ReadFile(configPath)
+	data, _, err := readConfigText(configPath)
 	if err != nil {
 		return nil, err
 	}
@@ -196,59 +221,13 @@ func (cm *ConfigManager) GetAgentMCPConfig(agentID string) (map[string]interface
 		if serversData, exists := config[configKey]; exists {
 			if serverMap, ok := serversData.(map[string]interface{}); ok {
 				// Convert to MCPServer slice for unwrapping
-				var servers []models.MCPServer
-				for serverName, serverConfig := range serverMap {
-					server := models.MCPServer{
-						ID:   serverName,
-						Name: serverName,
-					}
-					if serverMap, ok := serverConfig.(map[string]interface{}); ok {
-						if cmd, ok := serverMap["command"].(string); ok {
-							server.Command = cmd
-						}
-						if args, ok := serverMap["args"].([]interface{}); ok {
-							for _, arg := range args {
-								if argStr, ok := arg.(string); ok {
-									server.Args = append(server.Args, argStr)
-								}
-							}
-						}
-						if env, ok := serverMap["env"].(map[string]interface{}); ok {
-							server.Env = make(map[string]string)
-							for k, v := range env {
-								if strVal, ok := v.(string); ok {
-									server.Env[k] = strVal
-								}
-							}
-						}
-					}
-					servers = append(servers, server)
-				}
+				servers := ServersFromMap(serverMap)
 
 				// Apply Windows transformation (unwrap npx commands)
 				servers = windowsSvc.ApplyWindowsTransformation(servers, false)
 
 				// Convert back to config format
-				unwrappedServersData := make(map[string]interface{})
-				for _, server := range servers {
-					serverConfig := make(map[string]interface{})
-					serverConfig["command"] = server.Command
-					if len(server.Args) > 0 {
-						argsInterface := make([]interface{}, len(server.Args))
-						for i, arg := range server.Args {
-							argsInterface[i] = arg
-						}
-						serverConfig["args"] = argsInterface
-					}
-					if len(server.Env) > 0 {
-						envInterface := make(map[string]interface{})
-						for k, v := range server.Env {
-							envInterface[k] = v
-						}
-						serverConfig["env"] = envInterface
-					}
-					unwrappedServersData[server.Name] = serverConfig
-				}
+				unwrappedServersData := ServersToMap(servers)
 
 				config[configKey] = unwrappedServersData
 			}
@@ -344,13 +323,5 @@ func configEqual(a, b models.MCPServer) bool {
 	if a.Name != b.Name || a.Command != b.Command {
 		return false
 	}
-	if len(a.Args) != len(b.Args) {
-		return false
-	}
-	for i, arg := range a.Args {
-		if arg != b.Args[i] {
-			return false
-		}
-	}
-	return true
+	return reflect.DeepEqual(a.ArgList(), b.ArgList())
 }
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"mcp-sync/models"
+)
+
+// conflictInstructions 写在冲突文件开头，说明如何填写 resolution
+const conflictInstructions = "For each conflict set \"resolution\" to \"local\", \"remote\" or \"delete\", " +
+	"or to \"custom\" with the merged server config in \"resolved\". Then apply this operation ID with ApplyResolvedConflicts."
+
+// SetConflictFiles 设置 merge 遇到冲突时是否写出冲突文件
+func (as *AppService) SetConflictFiles(enabled bool) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.ConflictFiles = enabled
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save conflict file setting: %w", err)
+	}
+	return nil
+}
+
+// ListConflictFiles 返回尚未应用的冲突文件的操作 ID
+func (as *AppService) ListConflictFiles() ([]string, error) {
+	ids, err := as.storage.ListConflictFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflict files: %w", err)
+	}
+	return ids, nil
+}
+
+// conflictEntries 按 mergeAgentConfigs 报告的 "agent/server" 冲突收集双方的服务器配置
+func conflictEntries(local, remote map[string]interface{}, conflicts []string) []models.ServerConflict {
+	entries := []models.ServerConflict{}
+	seen := make(map[string]bool)
+	for _, conflict := range conflicts {
+		if seen[conflict] {
+			continue
+		}
+		seen[conflict] = true
+
+		agentID, server, _ := strings.Cut(conflict, "/")
+		lAgent, _ := local[agentID].(map[string]interface{})
+		rAgent, _ := remote[agentID].(map[string]interface{})
+		for _, section := range unionKeys(lAgent, rAgent) {
+			l, inL := asMap(lAgent[section], true)[server]
+			r, inR := asMap(rAgent[section], true)[server]
+			if inL == inR && jsonEqual(l, r) {
+				continue
+			}
+			entries = append(entries, models.ServerConflict{
+				AgentID: agentID,
+				Section: section,
+				Server:  server,
+				Local:   l,
+				Remote:  r,
+			})
+		}
+	}
+	return entries
+}
+
+// conflictKey 标识冲突文件中的一个服务器
+func conflictKey(c models.ServerConflict) string {
+	return c.AgentID + "/" + c.Section + "/" + c.Server
+}
+
+// writeConflictFile 把当前操作中无法自动合并的服务器写入冲突文件，返回操作 ID 和文件路径
+func (as *AppService) writeConflictFile(inputs *mergeInputs, conflicts []string) (string, string, error) {
+	op := as.currentOperation("merge")
+	path, err := as.storage.SaveConflictFile(models.ConflictFile{
+		OperationID:  op.id,
+		CreatedAt:    nowTime(),
+		Instructions: conflictInstructions,
+		Conflicts:    conflictEntries(inputs.local, inputs.remote, conflicts),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return op.id, path, nil
+}
+
+// ApplyResolvedConflicts 应用手动解决后的冲突文件。它重新做一次三方合并，确认冲突与文件中记录的本地和远程版本一致，
+// 再按每个冲突的 resolution 填入服务器，写回本地并推送到 Gist。文件无效或配置在此期间有变化时不写入任何内容
+func (as *AppService) ApplyResolvedConflicts(operationID string) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("apply_resolved_conflicts")()
+
+	file, err := as.storage.LoadConflictFile(operationID)
+	if err != nil {
+		return fmt.Errorf("failed to read conflict file: %w", err)
+	}
+	if file.OperationID != operationID {
+		return fmt.Errorf("conflict file %s records operation %q", operationID, file.OperationID)
+	}
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+	inputs, err := as.loadMergeInputs(gs)
+	if err != nil {
+		return err
+	}
+
+	merged, conflicts := mergeAgentConfigs(inputs.ancestor, inputs.local, inputs.remote)
+	if !sameConflicts(file.Conflicts, conflictEntries(inputs.local, inputs.remote, conflicts)) {
+		return fmt.Errorf("%w: configs changed since conflict file %s was written; merge again", ErrConflict, operationID)
+	}
+
+	if problems := as.resolveConflicts(merged, file.Conflicts); len(problems) > 0 {
+		return fmt.Errorf("invalid conflict file %s: %s", operationID, strings.Join(problems, "; "))
+	}
+
+	if err := as.applyMergedConfigs(gs, merged, inputs.installed, "Applied manually resolved conflicts"); err != nil {
+		return err
+	}
+	if err := as.storage.DeleteConflictFile(operationID); err != nil {
+		println(fmt.Sprintf("Warning: failed to remove applied conflict file %s: %v", operationID, err))
+	}
+	return nil
+}
+
+// sameConflicts 检查冲突文件记录的冲突与当前冲突是否为同一组服务器，且双方版本都没有变化
+func sameConflicts(recorded, current []models.ServerConflict) bool {
+	if len(recorded) != len(current) {
+		return false
+	}
+	byKey := make(map[string]models.ServerConflict, len(current))
+	for _, c := range current {
+		byKey[conflictKey(c)] = c
+	}
+	for _, r := range recorded {
+		c, ok := byKey[conflictKey(r)]
+		if !ok || !jsonEqual(r.Local, c.Local) || !jsonEqual(r.Remote, c.Remote) {
+			return false
+		}
+		delete(byKey, conflictKey(r))
+	}
+	return len(byKey) == 0
+}
+
+// resolveConflicts 按 resolution 把冲突的服务器写入 merged，返回发现的问题；有问题时 merged 不应再使用
+func (as *AppService) resolveConflicts(merged map[string]interface{}, conflicts []models.ServerConflict) []string {
+	var problems []string
+	for _, c := range conflicts {
+		name := c.AgentID + "/" + c.Server
+
+		var value interface{}
+		switch c.Resolution {
+		case "local":
+			value = c.Local
+		case "remote":
+			value = c.Remote
+		case "delete":
+		case "custom":
+			server, ok := c.Resolved.(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: custom resolution needs a server object in resolved", name))
+				continue
+			}
+			if c.Section == as.configLoader.GetConfigKey(c.AgentID) && as.configLoader.GetAgentDefinition(c.AgentID) != nil {
+				if valid, errs := as.converter.ValidateConfigFormat(c.AgentID, map[string]interface{}{c.Server: server}); !valid {
+					problems = append(problems, fmt.Sprintf("%s: %s", name, strings.Join(errs, ", ")))
+					continue
+				}
+			}
+			value = server
+		case "":
+			problems = append(problems, fmt.Sprintf("%s: no resolution", name))
+			continue
+		default:
+			problems = append(problems, fmt.Sprintf("%s: unknown resolution %q", name, c.Resolution))
+			continue
+		}
+
+		agentConfig, ok := merged[c.AgentID].(map[string]interface{})
+		if !ok {
+			agentConfig = make(map[string]interface{})
+			merged[c.AgentID] = agentConfig
+		}
+		servers, ok := agentConfig[c.Section].(map[string]interface{})
+		if !ok {
+			servers = make(map[string]interface{})
+			agentConfig[c.Section] = servers
+		}
+		if value == nil {
+			delete(servers, c.Server)
+		} else {
+			servers[c.Server] = value
+		}
+	}
+	return problems
+}
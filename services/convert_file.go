@@ -0,0 +1,116 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// convertFormatKeys 是 ConvertFile 支持的格式及其服务器所在的配置键
+var convertFormatKeys = map[string]string{
+	"standard":   "mcpServers",
+	"zed":        "context_servers",
+	"codex_toml": "mcp_servers",
+	"json5":      "mcpServers",
+}
+
+// normalizeConvertFormat 把格式别名转换为 convertFormatKeys 中的名称；空字符串和 "auto" 表示自动检测
+func normalizeConvertFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	switch format {
+	case "", "auto":
+		return "", nil
+	case "json":
+		return "standard", nil
+	case "codex", "toml":
+		return "codex_toml", nil
+	}
+	if _, ok := convertFormatKeys[format]; !ok {
+		return "", fmt.Errorf("unsupported format: %s", format)
+	}
+	return format, nil
+}
+
+// ConvertFile 读取 inputPath 中的 MCP 服务器，转换为 targetFormat 后写入 outputPath，不涉及 agent 检测和同步。
+// 格式为 standard、zed、codex_toml 或 json5（也接受 json、codex、toml），为空或 "auto" 时自动检测：
+// 源格式按内容检测，目标格式按已有输出文件的内容检测，输出文件不存在时按扩展名决定。
+// 输出文件已存在时只替换其中的服务器部分，其他设置保留
+func (as *AppService) ConvertFile(inputPath, sourceFormat, targetFormat, outputPath string) error {
+	source, err := normalizeConvertFormat(sourceFormat)
+	if err != nil {
+		return err
+	}
+	target, err := normalizeConvertFormat(targetFormat)
+	if err != nil {
+		return err
+	}
+
+	servers, err := as.readConvertInput(inputPath, source)
+	if err != nil {
+		return err
+	}
+
+	if target == "" {
+		target = as.detectConvertOutput(outputPath)
+	}
+	if _, err := os.Stat(outputPath); os.IsNotExist(err) && target == "standard" {
+		// The JSON writer keeps the rest of an existing file, so give it an empty object to start from
+		if err := os.WriteFile(outputPath, []byte("{}\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := formatAdapterFor(target).WriteServers(outputPath, convertFormatKeys[target], coerceServers(servers)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(outputPath), err)
+	}
+	println(fmt.Sprintf("Converted %d servers from %s to %s (%s)", len(servers), filepath.Base(inputPath), filepath.Base(outputPath), target))
+	return nil
+}
+
+// readConvertInput 读取输入文件中的服务器并转换为标准结构；format 为空时按内容检测格式
+func (as *AppService) readConvertInput(path, format string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, _ := as.GetSyncConfig()
+	if err := checkPayloadLimits(effectiveLimits(config), data); err != nil {
+		return nil, err
+	}
+
+	if format == "" {
+		servers, _, err := as.converter.ParseServers(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+		return servers, nil
+	}
+
+	key := convertFormatKeys[format]
+	servers, err := formatAdapterFor(format).ReadServers(path, key)
+	if err != nil {
+		return nil, err
+	}
+	servers, _ = standardServersFrom(map[string]interface{}{key: servers}, key)
+	return servers, nil
+}
+
+// detectConvertOutput 返回输出文件的格式：文件已存在且能识别时按内容，否则按扩展名，默认为 standard
+func (as *AppService) detectConvertOutput(path string) string {
+	if data, err := os.ReadFile(path); err == nil {
+		if format, confidence := as.converter.DetectFormat(data); confidence > 0 {
+			if _, ok := convertFormatKeys[format]; ok {
+				return format
+			}
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return "codex_toml"
+	case ".json5":
+		return "json5"
+	}
+	return "standard"
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertFileStandardToCodexAndBack(t *testing.T) {
+	as := newTestAppService(t)
+	dir := t.TempDir()
+
+	input := filepath.Join(dir, "mcp.json")
+	if err := os.WriteFile(input, []byte(`{
+  "mcpServers": {
+    "fetch": {"command": "uvx", "args": ["mcp-server-fetch"], "env": {"LOG": "info"}},
+    "git": {"command": "git-mcp", "description": "repository tools"}
+  }
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both formats auto-detected: the input by content, the output by its extension
+	codexPath := filepath.Join(dir, "config.toml")
+	if err := as.ConvertFile(input, "", "", codexPath); err != nil {
+		t.Fatalf("ConvertFile(json -> toml) error = %v", err)
+	}
+	toml := readFile(t, codexPath)
+	if !strings.Contains(toml, "[mcp_servers.fetch]") || !strings.Contains(toml, `description = "repository tools"`) {
+		t.Fatalf("converted Codex config is missing servers:\n%s", toml)
+	}
+
+	backPath := filepath.Join(dir, "back.json")
+	if err := as.ConvertFile(codexPath, "codex", "standard", backPath); err != nil {
+		t.Fatalf("ConvertFile(toml -> json) error = %v", err)
+	}
+
+	original, _, err := as.converter.ParseServers([]byte(readFile(t, input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTripped, format, err := as.converter.ParseServers([]byte(readFile(t, backPath)))
+	if err != nil || format != "standard" {
+		t.Fatalf("ParseServers(back.json) = %s, %v, want standard JSON", format, err)
+	}
+	if !reflect.DeepEqual(roundTripped, original) {
+		t.Errorf("round trip changed the servers:\ngot  %v\nwant %v", roundTripped, original)
+	}
+}
+
+func TestConvertFileKeepsOutputSettings(t *testing.T) {
+	as := newTestAppService(t)
+	dir := t.TempDir()
+
+	input := filepath.Join(dir, "settings.json")
+	os.WriteFile(input, []byte(`{"context_servers": {"fs": {"source": "custom", "command": "npx", "args": ["fs"], "enabled": true}}}`), 0644)
+	output := filepath.Join(dir, "config.toml")
+	os.WriteFile(output, []byte("model = \"o3\"\n"), 0644)
+
+	if err := as.ConvertFile(input, "zed", "auto", output); err != nil {
+		t.Fatalf("ConvertFile() error = %v", err)
+	}
+	written := readFile(t, output)
+	if !strings.Contains(written, `model = "o3"`) || !strings.Contains(written, "[mcp_servers.fs]") || strings.Contains(written, "source") {
+		t.Errorf("converted Codex config =\n%s\nwant the model kept and fs without Zed fields", written)
+	}
+
+	if err := as.ConvertFile(input, "yaml", "", output); err == nil {
+		t.Error("ConvertFile() with an unsupported format returned no error")
+	}
+}
@@ -3,6 +3,9 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+
+	"github.com/BurntSushi/toml"
 )
 
 // ConfigConverter handles conversion between different MCP config formats
@@ -64,30 +67,29 @@ func (c *ConfigConverter) ConvertAgentConfig(sourceAgentID, targetAgentID string
 		return result, nil
 	}
 
-	// Apply format conversion
+	// Apply format conversion, through the standard format when there is no direct rule
 	transformKey := fmt.Sprintf("%s_to_%s", sourceAgent.Format, targetAgent.Format)
-	transform := c.configLoader.GetTransformRule(sourceAgent.Format, targetAgent.Format)
-
-	if transform == nil {
-		// Try to convert through standard format as intermediate
-		if sourceAgent.Format != "standard" && targetAgent.Format != "standard" {
-			// Source -> Standard -> Target
-			intermediateResult, err := c.convertToStandard(sourceAgentID, sourceConfig)
-			if err != nil {
-				result.Message = fmt.Sprintf("Failed intermediate conversion: %v", err)
-				return result, err
-			}
-			return c.convertFromStandard(targetAgentID, intermediateResult)
-		}
-
+	chain, ok := c.configLoader.GetTransformChain(sourceAgent.Format, targetAgent.Format)
+	if !ok {
 		result.Message = fmt.Sprintf("No transform rule found: %s", transformKey)
 		return result, fmt.Errorf("transform not found: %s", transformKey)
 	}
 
-	convertedConfig := c.applyTransform(sourceConfig, transform)
+	convertedConfig := sourceConfig
+	for _, transform := range chain {
+		convertedConfig = c.applyTransform(convertedConfig, transform)
+	}
+	// Codex only runs stdio servers; report what it cannot hold instead of converting it silently
+	var skipped []string
+	if isTOMLFormat(targetAgent.Format) {
+		convertedConfig, skipped = dropCodexUnsupported(convertedConfig)
+	}
 	result.ConvertedConfig = convertedConfig
 	result.Success = true
 	result.Message = fmt.Sprintf("Successfully converted from %s to %s format", sourceAgent.Format, targetAgent.Format)
+	if len(skipped) > 0 {
+		result.Message += fmt.Sprintf("; skipped (stdio only): %s", strings.Join(skipped, ", "))
+	}
 
 	return result, nil
 }
@@ -102,60 +104,17 @@ func (c *ConfigConverter) ConvertFromCodex(targetAgentID string, codexConfig map
 	return c.ConvertAgentConfig("codex", targetAgentID, codexConfig)
 }
 
-// convertToStandard converts any format to standard format
-func (c *ConfigConverter) convertToStandard(sourceAgentID string, sourceConfig map[string]interface{}) (map[string]interface{}, error) {
-	sourceAgent := c.configLoader.GetAgentDefinition(sourceAgentID)
-	if sourceAgent == nil {
-		return nil, fmt.Errorf("source agent not found: %s", sourceAgentID)
-	}
-
-	if sourceAgent.Format == "standard" {
-		return sourceConfig, nil
-	}
-
-	transform := c.configLoader.GetTransformRule(sourceAgent.Format, "standard")
-	
-	if transform == nil {
-		return nil, fmt.Errorf("no transform to standard from %s", sourceAgent.Format)
-	}
-
-	return c.applyTransform(sourceConfig, transform), nil
-}
-
-// convertFromStandard converts standard format to any format
-func (c *ConfigConverter) convertFromStandard(targetAgentID string, standardConfig map[string]interface{}) (*ConversionResult, error) {
-	targetAgent := c.configLoader.GetAgentDefinition(targetAgentID)
-	if targetAgent == nil {
-		return nil, fmt.Errorf("target agent not found: %s", targetAgentID)
-	}
-
-	result := &ConversionResult{
-		SourceAgent:     "standard",
-		TargetAgent:     targetAgentID,
-		SourceFormat:    "standard",
-		TargetFormat:    targetAgent.Format,
-		OriginalConfig:  standardConfig,
-	}
-
-	if targetAgent.Format == "standard" {
-		result.ConvertedConfig = standardConfig
-		result.Success = true
-		result.Message = "No conversion needed"
-		return result, nil
-	}
+// reservedServerFields 是服务器上的注释和元数据字段。它们不属于任何 agent 的配置格式，
+// 但所有转换都会原样保留，这样在一个 agent 里写的说明同步到其他 agent 后不会丢失
+var reservedServerFields = []string{"description", "_meta"}
 
-	transform := c.configLoader.GetTransformRule("standard", targetAgent.Format)
-	
-	if transform == nil {
-		result.Message = fmt.Sprintf("No transform from standard to %s", targetAgent.Format)
-		return result, fmt.Errorf("no transform from standard to %s", targetAgent.Format)
+// copyReservedFields 把 src 中的 reservedServerFields 复制到 dst
+func copyReservedFields(dst, src map[string]interface{}) {
+	for _, field := range reservedServerFields {
+		if val, exists := src[field]; exists {
+			dst[field] = val
+		}
 	}
-
-	result.ConvertedConfig = c.applyTransform(standardConfig, transform)
-	result.Success = true
-	result.Message = fmt.Sprintf("Successfully converted to %s format", targetAgent.Format)
-
-	return result, nil
 }
 
 // applyTransform applies transformation rules to config
@@ -179,6 +138,7 @@ func (c *ConfigConverter) applyTransform(config map[string]interface{}, transfor
 					transformedServer[field] = val
 				}
 			}
+			copyReservedFields(transformedServer, serverConfig)
 		} else {
 			// Keep all fields if keep_fields not specified
 			for key, val := range serverConfig {
@@ -238,7 +198,7 @@ func (c *ConfigConverter) ValidateConfigFormat(agentID string, config map[string
 	errors := []string{}
 
 	// Basic validation for standard format
-	if agent.Format == "standard" {
+	if hasStandardServers(agent.Format) {
 		for serverName, serverConfigInterface := range config {
 			serverConfig, ok := serverConfigInterface.(map[string]interface{})
 			if !ok {
@@ -246,8 +206,10 @@ func (c *ConfigConverter) ValidateConfigFormat(agentID string, config map[string
 				continue
 			}
 
-			// Check required fields
-			if _, hasCommand := serverConfig["command"]; !hasCommand {
+			// Check required fields (remote servers use url instead of command)
+			_, hasCommand := serverConfig["command"]
+			_, hasURL := serverConfig["url"]
+			if !hasCommand && !hasURL {
 				errors = append(errors, fmt.Sprintf("Server %s: missing 'command' field", serverName))
 			}
 		}
@@ -272,5 +234,201 @@ func (c *ConfigConverter) ValidateConfigFormat(agentID string, config map[string
 		}
 	}
 
+	// Codex only supports stdio servers
+	if isTOMLFormat(agent.Format) {
+		for serverName, serverConfigInterface := range config {
+			serverConfig, ok := serverConfigInterface.(map[string]interface{})
+			if !ok {
+				errors = append(errors, fmt.Sprintf("Server %s: invalid config structure", serverName))
+				continue
+			}
+			if _, hasCommand := serverConfig["command"]; !hasCommand {
+				errors = append(errors, fmt.Sprintf("Server %s: Codex only supports stdio servers with a 'command'", serverName))
+			}
+		}
+	}
+
 	return len(errors) == 0, errors
 }
+
+// DetectFormat guesses the format of a config file from its content.
+// It returns "standard", "zed", "codex_toml", "json5", "servers_list" (mcp-sync export) or "unknown",
+// with a confidence between 0 and 1. "json5" is only returned for files that are not valid JSON.
+func (c *ConfigConverter) DetectFormat(data []byte) (string, float64) {
+	data, _ = decodeConfigText(data)
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return "unknown", 0
+	}
+
+	if config, ok := parseJSONWithComments(trimmed); ok {
+		return detectJSONFormat(config)
+	}
+
+	// JSON5-only syntax: unquoted keys, single quotes, block comments, trailing commas
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "/") {
+		if config, ok := parseJSON5Object(trimmed); ok {
+			if _, confidence := detectJSONFormat(config); confidence > 0 {
+				return "json5", confidence
+			}
+			return "json5", 0.3
+		}
+	}
+
+	var tomlConfig map[string]interface{}
+	if _, err := toml.Decode(trimmed, &tomlConfig); err == nil {
+		if servers, ok := tomlConfig["mcp_servers"].(map[string]interface{}); ok {
+			if len(servers) == 0 || serversLookValid(servers) {
+				return "codex_toml", 1.0
+			}
+			return "codex_toml", 0.8
+		}
+		// Valid TOML without MCP servers is most likely a Codex config with none configured
+		return "codex_toml", 0.3
+	}
+
+	return "unknown", 0
+}
+
+// detectJSONFormat classifies a parsed JSON config
+func detectJSONFormat(config map[string]interface{}) (string, float64) {
+	if servers, ok := config["context_servers"].(map[string]interface{}); ok {
+		if hasServerField(servers, "source") || hasServerField(servers, "enabled") {
+			return "zed", 1.0
+		}
+		return "zed", 0.9
+	}
+
+	if servers, ok := config["mcpServers"].(map[string]interface{}); ok {
+		// Zed-only fields under mcpServers suggest a hand-converted file
+		if hasServerField(servers, "source") {
+			return "standard", 0.7
+		}
+		if len(servers) == 0 || serversLookValid(servers) {
+			return "standard", 1.0
+		}
+		return "standard", 0.8
+	}
+
+	if _, ok := config["servers"].([]interface{}); ok {
+		return "servers_list", 0.9
+	}
+
+	// A bare map of servers, e.g. the inside of an mcpServers block
+	if len(config) > 0 && serversLookValid(config) {
+		return "standard", 0.5
+	}
+
+	return "unknown", 0
+}
+
+// parseJSONWithComments parses JSON that may contain whole-line // comments
+func parseJSONWithComments(content string) (map[string]interface{}, bool) {
+	var cleanedLines []string
+	for _, line := range strings.Split(content, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			cleanedLines = append(cleanedLines, line)
+		}
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.Join(cleanedLines, "\n")), &config); err != nil {
+		return nil, false
+	}
+	return config, true
+}
+
+// parseJSON5Object parses JSON5 content whose top-level value is an object
+func parseJSON5Object(content string) (map[string]interface{}, bool) {
+	doc, err := parseJSON5([]byte(content))
+	if err != nil {
+		return nil, false
+	}
+	config, ok := doc.value.(map[string]interface{})
+	return config, ok
+}
+
+// serversLookValid reports whether every entry looks like a server config (command or url)
+func serversLookValid(servers map[string]interface{}) bool {
+	for _, server := range servers {
+		serverMap, ok := server.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		_, hasCommand := serverMap["command"]
+		_, hasURL := serverMap["url"]
+		if !hasCommand && !hasURL {
+			return false
+		}
+	}
+	return true
+}
+
+// hasServerField reports whether any server config has the given field
+func hasServerField(servers map[string]interface{}, field string) bool {
+	for _, server := range servers {
+		if serverMap, ok := server.(map[string]interface{}); ok {
+			if _, exists := serverMap[field]; exists {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseServers detects the format of data and returns its servers in standard mcpServers form,
+// along with the detected format. It is the entry point for importing files of unknown format.
+func (c *ConfigConverter) ParseServers(data []byte) (map[string]interface{}, string, error) {
+	data, _ = decodeConfigText(data)
+	format, confidence := c.DetectFormat(data)
+	if format == "unknown" || confidence == 0 {
+		return nil, format, fmt.Errorf("unrecognized config format")
+	}
+
+	switch format {
+	case "codex_toml":
+		var codexConfig CodexConfig
+		if err := toml.Unmarshal(data, &codexConfig); err != nil {
+			return nil, format, fmt.Errorf("failed to parse TOML: %w", err)
+		}
+		return NewTOMLAdapter().CodexToStandard(codexConfig.MCPServers), format, nil
+
+	case "servers_list":
+		var exported struct {
+			Servers []struct {
+				Name    string            `json:"name"`
+				Command string            `json:"command"`
+				Args    []string          `json:"args"`
+				Env     map[string]string `json:"env"`
+			} `json:"servers"`
+		}
+		if err := json.Unmarshal(data, &exported); err != nil {
+			return nil, format, err
+		}
+		servers := make(map[string]interface{})
+		for _, server := range exported.Servers {
+			serverConfig := map[string]interface{}{"command": server.Command}
+			if len(server.Args) > 0 {
+				serverConfig["args"] = server.Args
+			}
+			if len(server.Env) > 0 {
+				serverConfig["env"] = server.Env
+			}
+			servers[server.Name] = serverConfig
+		}
+		return servers, format, nil
+	}
+
+	config, _ := parseJSONWithComments(strings.TrimSpace(string(data)))
+	if format == "json5" {
+		config, _ = parseJSON5Object(strings.TrimSpace(string(data)))
+	}
+	if _, ok := config["context_servers"]; ok && (format == "zed" || format == "json5") {
+		servers, _ := convertZedToStandard(config["context_servers"]).(map[string]interface{})
+		return servers, format, nil
+	}
+	if servers, ok := config["mcpServers"].(map[string]interface{}); ok {
+		return servers, format, nil
+	}
+	return config, format, nil
+}
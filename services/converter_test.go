@@ -0,0 +1,306 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	converter := &ConfigConverter{}
+
+	tests := []struct {
+		name          string
+		data          string
+		wantFormat    string
+		minConfidence float64
+	}{
+		{
+			name:          "standard JSON",
+			data:          `{"mcpServers": {"fs": {"command": "npx", "args": ["-y", "fs"]}}}`,
+			wantFormat:    "standard",
+			minConfidence: 1.0,
+		},
+		{
+			name: "standard JSON with comments",
+			data: `{
+  // local servers
+  "mcpServers": {"web": {"url": "https://example.com/mcp"}}
+}`,
+			wantFormat:    "standard",
+			minConfidence: 1.0,
+		},
+		{
+			name:          "Zed JSON",
+			data:          `{"theme": "One Dark", "context_servers": {"fs": {"source": "custom", "enabled": true, "command": "npx"}}}`,
+			wantFormat:    "zed",
+			minConfidence: 1.0,
+		},
+		{
+			name: "Codex TOML",
+			data: `model = "o3"
+
+[mcp_servers.fs]
+command = "npx"
+args = ["-y", "fs"]
+env = { "TOKEN" = "x" }
+`,
+			wantFormat:    "codex_toml",
+			minConfidence: 1.0,
+		},
+		{
+			name:          "mcp-sync export",
+			data:          `{"servers": [{"name": "fs", "command": "npx"}]}`,
+			wantFormat:    "servers_list",
+			minConfidence: 0.9,
+		},
+		{
+			name:          "bare server map",
+			data:          `{"fs": {"command": "npx"}}`,
+			wantFormat:    "standard",
+			minConfidence: 0.5,
+		},
+		{
+			name: "JSON5 with unquoted keys and single quotes",
+			data: `{
+  /* local servers */
+  mcpServers: {fs: {command: 'npx', args: ['-y', 'fs'],},},
+}`,
+			wantFormat:    "json5",
+			minConfidence: 1.0,
+		},
+		{
+			name:          "JSON5 without servers",
+			data:          `{theme: 'dark'}`,
+			wantFormat:    "json5",
+			minConfidence: 0.3,
+		},
+		{name: "empty", data: "  ", wantFormat: "unknown"},
+		{name: "garbage", data: "not a config [", wantFormat: "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, confidence := converter.DetectFormat([]byte(tt.data))
+			if format != tt.wantFormat {
+				t.Errorf("DetectFormat() format = %s, want %s", format, tt.wantFormat)
+			}
+			if confidence < tt.minConfidence {
+				t.Errorf("DetectFormat() confidence = %v, want >= %v", confidence, tt.minConfidence)
+			}
+		})
+	}
+}
+
+func TestParseServers(t *testing.T) {
+	converter := &ConfigConverter{}
+
+	samples := map[string]string{
+		"standard":     `{"mcpServers": {"fs": {"command": "npx", "args": ["-y", "fs"]}}}`,
+		"zed":          `{"context_servers": {"fs": {"source": "custom", "enabled": true, "command": "npx", "args": ["-y", "fs"]}}}`,
+		"codex_toml":   "[mcp_servers.fs]\ncommand = \"npx\"\nargs = [\"-y\", \"fs\"]\n",
+		"servers_list": `{"servers": [{"name": "fs", "command": "npx", "args": ["-y", "fs"]}]}`,
+		"json5":        `{mcpServers: {fs: {command: 'npx', args: ['-y', 'fs'],}}}`,
+	}
+
+	for wantFormat, data := range samples {
+		t.Run(wantFormat, func(t *testing.T) {
+			servers, format, err := converter.ParseServers([]byte(data))
+			if err != nil {
+				t.Fatalf("ParseServers() error = %v", err)
+			}
+			if format != wantFormat {
+				t.Errorf("ParseServers() format = %s, want %s", format, wantFormat)
+			}
+			fs, ok := servers["fs"].(map[string]interface{})
+			if !ok || fs["command"] != "npx" {
+				t.Fatalf("ParseServers() servers = %v, want fs with command npx", servers)
+			}
+			if _, hasSource := fs["source"]; hasSource {
+				t.Errorf("Zed-only fields should be dropped in standard form: %v", fs)
+			}
+		})
+	}
+
+	if _, _, err := converter.ParseServers([]byte("???")); err == nil {
+		t.Errorf("ParseServers() expected an error for unknown content")
+	}
+}
+
+func TestDescriptionSurvivesConversions(t *testing.T) {
+	loader, err := NewConfigLoader()
+	if err != nil {
+		t.Fatalf("NewConfigLoader() error = %v", err)
+	}
+	converter := NewConfigConverter(loader)
+
+	meta := map[string]interface{}{"owner": "platform-team"}
+	standard := map[string]interface{}{
+		"fs": map[string]interface{}{
+			"command":     "npx",
+			"args":        []interface{}{"-y", "fs"},
+			"description": "Read-only access to ~/projects",
+			"_meta":       meta,
+		},
+	}
+
+	t.Run("standard to zed and back", func(t *testing.T) {
+		toZed, err := converter.ConvertAgentConfig("cursor", "zed", standard)
+		if err != nil {
+			t.Fatalf("ConvertAgentConfig(cursor, zed) error = %v", err)
+		}
+		zedServer := toZed.ConvertedConfig["fs"].(map[string]interface{})
+		if zedServer["description"] != "Read-only access to ~/projects" {
+			t.Errorf("zed server = %v, want description kept", zedServer)
+		}
+
+		back, err := converter.ConvertAgentConfig("zed", "cursor", toZed.ConvertedConfig)
+		if err != nil {
+			t.Fatalf("ConvertAgentConfig(zed, cursor) error = %v", err)
+		}
+		if !reflect.DeepEqual(back.ConvertedConfig, standard) {
+			t.Errorf("round trip = %#v, want %#v", back.ConvertedConfig, standard)
+		}
+
+		// The sync path converts with the built-in Zed functions
+		viaSync := convertZedToStandard(convertStandardToZed(standard))
+		if !reflect.DeepEqual(viaSync, standard) {
+			t.Errorf("convertZedToStandard(convertStandardToZed()) = %#v, want %#v", viaSync, standard)
+		}
+	})
+
+	t.Run("standard to codex", func(t *testing.T) {
+		adapter := NewTOMLAdapter()
+		rendered := adapter.RenderCodexConfig(&CodexConfig{MCPServers: adapter.StandardToCodex(standard)})
+
+		servers, format, err := converter.ParseServers([]byte(rendered))
+		if err != nil || format != "codex_toml" {
+			t.Fatalf("ParseServers() = %s, %v, want codex_toml\n%s", format, err, rendered)
+		}
+		fs := servers["fs"].(map[string]interface{})
+		if fs["description"] != "Read-only access to ~/projects" {
+			t.Errorf("codex server = %v, want description kept\n%s", fs, rendered)
+		}
+		if _, hasMeta := fs["_meta"]; hasMeta {
+			t.Errorf("codex has no place for _meta, got %v", fs["_meta"])
+		}
+	})
+}
+
+func TestStandardToCodexSkipsRemoteServers(t *testing.T) {
+	standard := map[string]interface{}{
+		"fetch":    map[string]interface{}{"command": "uvx", "args": []interface{}{"mcp-server-fetch"}},
+		"typed":    map[string]interface{}{"type": "sse", "url": "https://mcp.example.com/sse"},
+		"url-only": map[string]interface{}{"url": "https://mcp.example.com/mcp"},
+		"proxy":    map[string]interface{}{"command": "mcp-proxy", "url": "https://mcp.example.com/mcp"},
+	}
+
+	servers := NewTOMLAdapter().StandardToCodex(standard)
+	if len(servers) != 2 || servers["fetch"].Command != "uvx" || servers["proxy"].Command != "mcp-proxy" {
+		t.Errorf("StandardToCodex() = %+v, want fetch and proxy only", servers)
+	}
+	// PlanSync and agent-to-agent syncs report exactly what StandardToCodex skips
+	if _, skipped := dropCodexUnsupported(standard); len(skipped) != 2 {
+		t.Errorf("dropCodexUnsupported() skipped = %v, want typed and url-only", skipped)
+	}
+}
+
+func TestServersMapKeepsAnnotations(t *testing.T) {
+	input := map[string]interface{}{
+		"fs": map[string]interface{}{
+			"command":     "npx",
+			"description": "Local files",
+			"_meta":       map[string]interface{}{"added_by": "setup"},
+		},
+	}
+
+	servers := ServersFromMap(input)
+	if len(servers) != 1 || servers[0].Description != "Local files" || servers[0].Meta["added_by"] != "setup" {
+		t.Fatalf("ServersFromMap() = %+v, want description and meta", servers)
+	}
+	if output := ServersToMap(servers); !reflect.DeepEqual(output, input) {
+		t.Errorf("ServersToMap() = %#v, want %#v", output, input)
+	}
+}
+
+func TestHeadersSurviveConversions(t *testing.T) {
+	loader, err := NewConfigLoader()
+	if err != nil {
+		t.Fatalf("NewConfigLoader() error = %v", err)
+	}
+	converter := NewConfigConverter(loader)
+
+	standard := map[string]interface{}{
+		"remote": map[string]interface{}{
+			"url":     "https://mcp.example.com/mcp",
+			"headers": map[string]interface{}{"Authorization": "Bearer sk-live-123456", "X-Team": "platform"},
+		},
+	}
+
+	t.Run("standard to zed and back", func(t *testing.T) {
+		toZed, err := converter.ConvertAgentConfig("cursor", "zed", standard)
+		if err != nil {
+			t.Fatalf("ConvertAgentConfig(cursor, zed) error = %v", err)
+		}
+		back, err := converter.ConvertAgentConfig("zed", "cursor", toZed.ConvertedConfig)
+		if err != nil {
+			t.Fatalf("ConvertAgentConfig(zed, cursor) error = %v", err)
+		}
+		if !reflect.DeepEqual(back.ConvertedConfig, standard) {
+			t.Errorf("round trip = %#v, want %#v", back.ConvertedConfig, standard)
+		}
+
+		viaSync := convertZedToStandard(convertStandardToZed(standard))
+		if !reflect.DeepEqual(viaSync, standard) {
+			t.Errorf("convertZedToStandard(convertStandardToZed()) = %#v, want %#v", viaSync, standard)
+		}
+	})
+
+	t.Run("server list", func(t *testing.T) {
+		servers := ServersFromMap(standard)
+		if len(servers) != 1 || servers[0].Headers["X-Team"] != "platform" {
+			t.Fatalf("ServersFromMap() = %+v, want headers", servers)
+		}
+		if headers := ServersToMap(servers)["remote"].(map[string]interface{})["headers"]; !reflect.DeepEqual(headers, standard["remote"].(map[string]interface{})["headers"]) {
+			t.Errorf("ServersToMap() headers = %#v", headers)
+		}
+	})
+
+	t.Run("masked in redacted output", func(t *testing.T) {
+		sanitized := SanitizeConfig(standard)
+		headers := sanitized["remote"].(map[string]interface{})["headers"].(map[string]interface{})
+		if headers["Authorization"] == "Bearer sk-live-123456" || headers["Authorization"] != MaskSensitiveValue("Bearer sk-live-123456") {
+			t.Errorf("Authorization header = %v, want it masked", headers["Authorization"])
+		}
+		if headers["X-Team"] != "platform" {
+			t.Errorf("X-Team header = %v, want it unchanged", headers["X-Team"])
+		}
+
+		filtered := FilterSensitiveData([]interface{}{standard["remote"]})[0].(map[string]interface{})
+		if filtered["headers"].(map[string]interface{})["Authorization"] == "Bearer sk-live-123456" {
+			t.Errorf("FilterSensitiveData() left the Authorization header in clear text")
+		}
+	})
+
+	t.Run("codex skips and reports", func(t *testing.T) {
+		input := map[string]interface{}{
+			"remote": standard["remote"],
+			"local":  map[string]interface{}{"command": "uvx", "headers": map[string]interface{}{"X-Team": "platform"}},
+		}
+		result, err := converter.ConvertAgentConfig("cursor", "codex", input)
+		if err != nil {
+			t.Fatalf("ConvertAgentConfig(cursor, codex) error = %v", err)
+		}
+		if _, ok := result.ConvertedConfig["remote"]; ok {
+			t.Errorf("codex config kept the HTTP server: %v", result.ConvertedConfig)
+		}
+		if local := result.ConvertedConfig["local"].(map[string]interface{}); local["headers"] != nil || local["command"] != "uvx" {
+			t.Errorf("codex local server = %v, want it without headers", local)
+		}
+		for _, want := range []string{"server remote (url transport)", "headers of server local"} {
+			if !strings.Contains(result.Message, want) {
+				t.Errorf("Message = %q, want it to report %q", result.Message, want)
+			}
+		}
+	})
+}
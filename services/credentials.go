@@ -0,0 +1,219 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// GitHubTokenEnv 是提供 GitHub token 的环境变量，设置后优先于凭据助手和保存的 token（适合 CI，token 不会写入磁盘）
+const GitHubTokenEnv = "MCP_SYNC_GITHUB_TOKEN"
+
+// GitHub token 的来源
+const (
+	TokenSourceEnv    = "env"
+	TokenSourceHelper = "credential_helper"
+	TokenSourceStored = "stored"
+)
+
+// credentialHelperTimeout 限制凭据助手命令的运行时间
+const credentialHelperTimeout = 10 * time.Second
+
+// 凭据检查结果（models.CredStatus.Status）
+const (
+	CredStatusValid            = "valid"
+	CredStatusExpired          = "expired"
+	CredStatusGistInaccessible = "gist_inaccessible"
+	CredStatusUnreachable      = "unreachable"
+	CredStatusNotConfigured    = "not_configured"
+)
+
+// ValidateStoredCredentials 检查已保存的 token 是否仍然有效、Gist 是否仍可访问，并记录结果供 GetCredentialStatus 读取。
+// 启动时和每次自动同步前调用，这样过期或被撤销的 token 不会等到同步中途才以 401 暴露。
+// 网络错误和限流只标记为临时问题（Transient），不当作凭据失效
+func (as *AppService) ValidateStoredCredentials() (*models.CredStatus, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+	token, _, tokenErr := resolveGitHubToken(config)
+	if token == "" || config.GistID == "" {
+		message := "GitHub token or Gist ID not configured"
+		if tokenErr != nil {
+			message = tokenErr.Error()
+		}
+		return as.recordCredStatus(&models.CredStatus{
+			Status:    CredStatusNotConfigured,
+			Message:   message,
+			CheckedAt: nowTime(),
+		}), nil
+	}
+
+	_, gs, err := as.gistSyncBackend()
+	if err != nil {
+		return nil, err
+	}
+	status, _ := as.checkCredentials(gs)
+	return status, nil
+}
+
+// checkCredentials 依次检查 token 和 Gist，记录并返回检查结果；凭据不可用时同时返回原始错误
+func (as *AppService) checkCredentials(gs *GistSyncService) (*models.CredStatus, error) {
+	err := gs.ValidateToken()
+	if err == nil {
+		err = gs.ValidateGist()
+	}
+	return as.recordCredStatus(credStatusFor(err)), err
+}
+
+// credStatusFor 把 token 或 Gist 校验的错误归类为凭据状态
+func credStatusFor(err error) *models.CredStatus {
+	status := &models.CredStatus{CheckedAt: nowTime()}
+	switch {
+	case err == nil:
+		status.Status = CredStatusValid
+		status.Message = "GitHub token and Gist are accessible"
+	case errors.Is(err, ErrGistNotFound), errors.Is(err, ErrGistNoAccess),
+		errors.Is(err, ErrGistWrongOwner), errors.Is(err, ErrGistUnexpectedContent):
+		status.Status = CredStatusGistInaccessible
+		status.Message = fmt.Sprintf("Gist is no longer usable (%v); choose another Gist or re-authenticate", err)
+	case errors.Is(err, ErrUnauthorized):
+		status.Status = CredStatusExpired
+		status.Message = "GitHub token expired or was revoked; re-authenticate"
+	default:
+		// Network failures, rate limits and GitHub outages say nothing about the token itself
+		status.Status = CredStatusUnreachable
+		status.Message = fmt.Sprintf("Could not reach GitHub to check credentials: %v", err)
+		status.Transient = true
+	}
+	return status
+}
+
+// recordCredStatus 保存最近一次的检查结果并原样返回
+func (as *AppService) recordCredStatus(status *models.CredStatus) *models.CredStatus {
+	as.credMu.Lock()
+	defer as.credMu.Unlock()
+	as.credStatus = status
+	return status
+}
+
+// GetCredentialStatus 返回最近一次凭据检查的结果；尚未检查过时返回 nil
+func (as *AppService) GetCredentialStatus() *models.CredStatus {
+	as.credMu.Lock()
+	defer as.credMu.Unlock()
+	if as.credStatus == nil {
+		return nil
+	}
+	status := *as.credStatus
+	return &status
+}
+
+// resolveGitHubToken 按顺序解析同步使用的 GitHub token 及其来源：环境变量 MCP_SYNC_GITHUB_TOKEN、配置的凭据助手命令、
+// sync_config.json 中保存的 token。凭据助手失败时退回保存的 token；没有保存的 token 时返回助手的错误
+func resolveGitHubToken(config models.SyncConfig) (string, string, error) {
+	token, source, err := externalGitHubToken(config)
+	if token != "" {
+		return token, source, nil
+	}
+	if config.GitHubToken != "" {
+		if err != nil {
+			println(fmt.Sprintf("Warning: %v; using the stored GitHub token", err))
+		}
+		return config.GitHubToken, TokenSourceStored, nil
+	}
+	return "", "", err
+}
+
+// externalGitHubToken 返回环境变量或凭据助手提供的 token 及其来源，两者都没有时返回空字符串
+func externalGitHubToken(config models.SyncConfig) (string, string, error) {
+	if token := strings.TrimSpace(os.Getenv(GitHubTokenEnv)); token != "" {
+		return token, TokenSourceEnv, nil
+	}
+	if helper := strings.TrimSpace(config.CredentialHelper); helper != "" {
+		token, err := runCredentialHelper(helper)
+		if err != nil {
+			return "", "", fmt.Errorf("credential helper failed: %w", err)
+		}
+		return token, TokenSourceHelper, nil
+	}
+	return "", "", nil
+}
+
+// runCredentialHelper 通过 shell 运行凭据助手命令并返回它提供的 token。与 git 的凭据助手一样，命令从标准输入收到
+// protocol=https 和 host=github.com；它可以输出 password=<token>，也可以只输出 token 本身（例如 gh auth token）
+func runCredentialHelper(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=github.com\n\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for _, line := range lines {
+		if token, ok := strings.CutPrefix(strings.TrimSpace(line), "password="); ok && token != "" {
+			return token, nil
+		}
+	}
+	if len(lines) == 1 && lines[0] != "" && !strings.Contains(lines[0], "=") {
+		return strings.TrimSpace(lines[0]), nil
+	}
+	return "", errors.New("credential helper printed no token")
+}
+
+// SetCredentialHelper 设置提供 GitHub token 的凭据助手命令，为空时不使用助手。
+// 没有设置 MCP_SYNC_GITHUB_TOKEN 时，助手提供的 token 优先于保存的 token
+func (as *AppService) SetCredentialHelper(command string) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.CredentialHelper = strings.TrimSpace(command)
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save credential helper: %w", err)
+	}
+
+	// Switch the cached backend to the token now in effect
+	if as.gistSync != nil {
+		if token, _, err := resolveGitHubToken(config); err == nil && token != "" {
+			as.gistSync = as.gistSync.WithCredentials(token, as.gistSync.gistID)
+		}
+	}
+	return nil
+}
+
+// GetGitHubTokenSource 返回当前使用的 GitHub token 的来源（env、credential_helper 或 stored），没有 token 时返回空字符串
+func (as *AppService) GetGitHubTokenSource() (string, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load sync config: %w", err)
+	}
+	_, source, err := resolveGitHubToken(config)
+	return source, err
+}
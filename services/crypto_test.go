@@ -1,16 +1,16 @@
 package services
 
 import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
 	"testing"
+
+	"mcp-sync/models"
 )
 
 func TestSecureCryptoFixed(t *testing.T) {
-	// 清理之前的测试状态
-	keyring, _ := NewSystemKeyring()
-	if keyring != nil {
-		_ = keyring.DeleteKey("mcp-sync", "master_key")
-	}
-
 	tests := []struct {
 		name    string
 		plain   string
@@ -33,11 +33,8 @@ func TestSecureCryptoFixed(t *testing.T) {
 		},
 	}
 
-	// 创建安全加密实例
-	crypto, err := NewSecureCrypto()
-	if err != nil {
-		t.Fatalf("Failed to create secure crypto: %v", err)
-	}
+	// 创建安全加密实例，使用内存密钥环避免访问系统密钥环
+	crypto := NewSecureCryptoWithKeyring(NewInMemoryKeyring())
 
 	// 确保加密被禁用开始时
 	if crypto.IsEnabled() {
@@ -45,7 +42,7 @@ func TestSecureCryptoFixed(t *testing.T) {
 	}
 
 	// 启用加密
-	err = crypto.Enable()
+	err := crypto.Enable()
 	if err != nil {
 		t.Fatalf("Failed to enable encryption: %v", err)
 	}
@@ -96,6 +93,61 @@ func TestSystemKeyringFixed(t *testing.T) {
 		t.Skipf("System keyring not available for testing: %v", err)
 		return
 	}
+	// CI 环境通常没有可用的密钥环服务
+	if err := keyring.SetKey("test-mcp-sync-fixed", "probe", []byte("probe")); err != nil {
+		t.Skipf("System keyring not available for testing: %v", err)
+	}
+	_ = keyring.DeleteKey("test-mcp-sync-fixed", "probe")
+
+	testKeyringRoundTrip(t, keyring)
+}
+
+func TestInMemoryKeyring(t *testing.T) {
+	testKeyringRoundTrip(t, NewInMemoryKeyring())
+}
+
+func TestInMemoryKeyringIsolatedPerInstance(t *testing.T) {
+	first := NewInMemoryKeyring()
+	second := NewInMemoryKeyring()
+
+	if err := first.SetKey("test-service", "test-key", []byte("first-key")); err != nil {
+		t.Fatalf("SetKey() error = %v", err)
+	}
+	if _, err := second.GetKey("test-service", "test-key"); err == nil {
+		t.Errorf("a key stored in one keyring must not be visible in another")
+	}
+
+	// SecureCrypto instances backed by separate keyrings cannot read each other's data
+	a := NewSecureCryptoWithKeyring(first)
+	b := NewSecureCryptoWithKeyring(second)
+	if err := a.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	if b.IsEnabled() {
+		t.Errorf("encryption enabled on one keyring leaked into another")
+	}
+	if err := b.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := a.Encrypt("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Decrypt(encrypted); err == nil {
+		t.Errorf("Decrypt() with another keyring's key should fail")
+	}
+
+	// Returned keys are copies, so callers cannot change the stored key
+	key, _ := first.GetKey("mcp-sync", "master_key")
+	key[0] ^= 0xff
+	if stored, _ := first.GetKey("mcp-sync", "master_key"); bytes.Equal(stored, key) {
+		t.Errorf("GetKey() must return a copy of the stored key")
+	}
+}
+
+// testKeyringRoundTrip stores, reads back and deletes a key
+func testKeyringRoundTrip(t *testing.T, keyring SystemKeyring) {
+	t.Helper()
 
 	service := "test-mcp-sync-fixed"
 	keyName := "test-key-fixed"
@@ -105,7 +157,7 @@ func TestSystemKeyringFixed(t *testing.T) {
 	_ = keyring.DeleteKey(service, keyName)
 
 	// 测试存储密钥
-	err = keyring.SetKey(service, keyName, keyData)
+	err := keyring.SetKey(service, keyName, keyData)
 	if err != nil {
 		t.Fatalf("Failed to store key: %v", err)
 	}
@@ -134,12 +186,9 @@ func TestSystemKeyringFixed(t *testing.T) {
 }
 
 func BenchmarkSecureCryptoEncryptDecrypt(b *testing.B) {
-	crypto, err := NewSecureCrypto()
-	if err != nil {
-		b.Fatalf("Failed to create secure crypto: %v", err)
-	}
+	crypto := NewSecureCryptoWithKeyring(NewInMemoryKeyring())
 
-	err = crypto.Enable()
+	err := crypto.Enable()
 	if err != nil {
 		b.Fatalf("Failed to enable encryption: %v", err)
 	}
@@ -173,3 +222,159 @@ func BenchmarkSecureCryptoEncryptDecrypt(b *testing.B) {
 		}
 	})
 }
+
+// newRotationFixture creates encrypted storage with a config, a version and a log entry
+// hookedWriteFS 是真实文件系统，每次写入前调用 beforeWrite（参数是第几次写入），返回错误时写入失败
+type hookedWriteFS struct {
+	osFileSystem
+	writes      int
+	beforeWrite func(n int) error
+}
+
+func (fs *hookedWriteFS) WriteFile(path string, data []byte, perm os.FileMode) error {
+	fs.writes++
+	if err := fs.beforeWrite(fs.writes); err != nil {
+		return err
+	}
+	return fs.osFileSystem.WriteFile(path, data, perm)
+}
+
+func newRotationFixture(t *testing.T) (*StorageService, *InMemoryKeyring) {
+	t.Helper()
+
+	keyring := NewInMemoryKeyring()
+	dir := t.TempDir()
+	crypto := NewSecureCryptoWithKeyring(keyring)
+	crypto.dataDir = dir
+	crypto.fs = osFileSystem{}
+	if err := crypto.Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	storage := &StorageService{dataDir: dir, crypto: crypto, fs: osFileSystem{}, clock: realClock{}}
+
+	if err := storage.SaveSyncConfig(models.SyncConfig{ID: "default", GistID: "gist-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveConfigVersion(models.ConfigVersion{ID: "v1", Content: "version content"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveSyncLog(models.SyncLog{ID: "log1", Action: "push", Message: "log message"}); err != nil {
+		t.Fatal(err)
+	}
+	return storage, keyring
+}
+
+func TestRotateKeyKeepsFilesDecryptable(t *testing.T) {
+	storage, keyring := newRotationFixture(t)
+	oldKey, _ := keyring.GetKey("mcp-sync", "master_key")
+
+	if err := storage.crypto.RotateKey(); err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+
+	if newKey, _ := keyring.GetKey("mcp-sync", "master_key"); bytes.Equal(newKey, oldKey) {
+		t.Errorf("master key was not replaced")
+	}
+	if _, err := keyring.GetKey("mcp-sync", "master_key_pending"); err == nil {
+		t.Errorf("pending key should be removed after rotation")
+	}
+
+	config, err := storage.LoadSyncConfig()
+	if err != nil || config.GistID != "gist-1" {
+		t.Errorf("LoadSyncConfig() after rotation = %+v, %v", config, err)
+	}
+	versions, err := storage.ListConfigVersions(10)
+	if err != nil || len(versions) != 1 || versions[0].Content != "version content" {
+		t.Errorf("ListConfigVersions() after rotation = %+v, %v", versions, err)
+	}
+	logs, err := storage.GetSyncLogs(10)
+	if err != nil || len(logs) != 1 || logs[0].Message != "log message" {
+		t.Errorf("GetSyncLogs() after rotation = %+v, %v", logs, err)
+	}
+}
+
+func TestRotateKeyRollsBackOnFailure(t *testing.T) {
+	storage, keyring := newRotationFixture(t)
+	oldKey, _ := keyring.GetKey("mcp-sync", "master_key")
+
+	before := make(map[string][]byte)
+	for _, path := range dataFilePaths(storage.fs, storage.dataDir) {
+		data, _ := ioutil.ReadFile(path)
+		before[path] = data
+	}
+
+	// Fail on the second file after the first has already been rewritten
+	storage.crypto.fs = &hookedWriteFS{beforeWrite: func(n int) error {
+		if n == 2 {
+			return errors.New("disk full")
+		}
+		return nil
+	}}
+
+	if err := storage.crypto.RotateKey(); err == nil {
+		t.Fatalf("RotateKey() expected an error")
+	}
+
+	if currentKey, _ := keyring.GetKey("mcp-sync", "master_key"); !bytes.Equal(currentKey, oldKey) {
+		t.Errorf("master key must be unchanged after a failed rotation")
+	}
+	if _, err := keyring.GetKey("mcp-sync", "master_key_pending"); err == nil {
+		t.Errorf("pending key should be removed after rollback")
+	}
+	for path, data := range before {
+		current, _ := ioutil.ReadFile(path)
+		if !bytes.Equal(current, data) {
+			t.Errorf("%s was not restored", path)
+		}
+	}
+	if config, err := storage.LoadSyncConfig(); err != nil || config.GistID != "gist-1" {
+		t.Errorf("LoadSyncConfig() after rollback = %+v, %v", config, err)
+	}
+}
+
+func TestResumeKeyRotationAfterInterruption(t *testing.T) {
+	storage, keyring := newRotationFixture(t)
+	oldKey, _ := keyring.GetKey("mcp-sync", "master_key")
+
+	// Simulate a crash after the first file was rewritten: RotateKey never gets to roll back or swap keys
+	storage.crypto.fs = &hookedWriteFS{beforeWrite: func(n int) error {
+		if n == 2 {
+			panic("process killed")
+		}
+		return nil
+	}}
+	func() {
+		defer func() { recover() }()
+		storage.crypto.RotateKey()
+	}()
+	storage.crypto.fs = storage.fs
+
+	pendingKey, err := keyring.GetKey("mcp-sync", "master_key_pending")
+	if err != nil {
+		t.Fatalf("pending key missing after the interrupted rotation: %v", err)
+	}
+	if err := storage.crypto.ResumeKeyRotation(); err != nil {
+		t.Fatalf("ResumeKeyRotation() error = %v", err)
+	}
+
+	if currentKey, _ := keyring.GetKey("mcp-sync", "master_key"); !bytes.Equal(currentKey, pendingKey) || bytes.Equal(currentKey, oldKey) {
+		t.Errorf("master key should be the pending key after resuming")
+	}
+	if _, err := keyring.GetKey("mcp-sync", "master_key_pending"); err == nil {
+		t.Errorf("pending key should be removed after resuming")
+	}
+	if config, err := storage.LoadSyncConfig(); err != nil || config.GistID != "gist-1" {
+		t.Errorf("LoadSyncConfig() after resuming = %+v, %v", config, err)
+	}
+	if versions, err := storage.ListConfigVersions(10); err != nil || len(versions) != 1 || versions[0].Content != "version content" {
+		t.Errorf("ListConfigVersions() after resuming = %+v, %v", versions, err)
+	}
+	if logs, err := storage.GetSyncLogs(10); err != nil || len(logs) != 1 || logs[0].Message != "log message" {
+		t.Errorf("GetSyncLogs() after resuming = %+v, %v", logs, err)
+	}
+
+	// Nothing pending: a no-op
+	if err := storage.crypto.ResumeKeyRotation(); err != nil {
+		t.Errorf("ResumeKeyRotation() without a pending rotation error = %v", err)
+	}
+}
@@ -10,12 +10,17 @@ type AgentDetector struct {
 	configLoader *ConfigLoader
 }
 
-func NewAgentDetector() *AgentDetector {
+// NewAgentDetector 加载 agents.yaml 并创建检测器；agents.yaml 无法加载时返回错误，而不是检测不到任何 agent
+func NewAgentDetector() (*AgentDetector, error) {
 	loader, err := NewConfigLoader()
 	if err != nil {
-		println(fmt.Sprintf("Warning: failed to load agent config: %v", err))
-		loader, _ = NewConfigLoader()
+		return nil, fmt.Errorf("failed to load agent config: %w", err)
 	}
+	return newAgentDetector(loader), nil
+}
+
+// newAgentDetector 用已经加载的 agents.yaml 创建检测器
+func newAgentDetector(loader *ConfigLoader) *AgentDetector {
 	return &AgentDetector{
 		configLoader: loader,
 	}
@@ -0,0 +1,99 @@
+package services
+
+import "fmt"
+
+// disabledServersKey 是 Gist 中每个 agent 配置里的元数据键，记录已停用的服务器（服务器名 -> 标准格式配置）。
+// 停用状态因此不依赖 agent 格式本身能否表示 disabled
+const disabledServersKey = "disabled_servers"
+
+// withDisabledServers 返回附加了 disabled_servers 元数据的 agent 配置，用于推送。元数据包括本地带 disabled 标记的服务器，
+// 以及拉取时从配置文件中省略、只记在本地的服务器；后者如果已被重新加回配置文件，则视为已重新启用
+func (as *AppService) withDisabledServers(agentID string, config map[string]interface{}) map[string]interface{} {
+	servers, _ := standardServersFrom(config, as.configLoader.GetConfigKey(agentID))
+
+	disabled := make(map[string]interface{})
+	if syncConfig, err := as.GetSyncConfig(); err == nil {
+		for name, server := range syncConfig.DisabledServers[agentID] {
+			if _, inFile := servers[name]; !inFile {
+				disabled[name] = server
+			}
+		}
+	}
+	for name, server := range servers {
+		serverMap, ok := server.(map[string]interface{})
+		if !ok || !serverDisabled(serverMap) {
+			continue
+		}
+		entry := make(map[string]interface{}, len(serverMap))
+		for key, value := range serverMap {
+			if key != "disabled" {
+				entry[key] = value
+			}
+		}
+		disabled[name] = entry
+	}
+	if len(disabled) == 0 {
+		return config
+	}
+
+	result := make(map[string]interface{}, len(config)+1)
+	for key, value := range config {
+		result[key] = value
+	}
+	result[disabledServersKey] = disabled
+	return result
+}
+
+// applyRemoteAgentConfig 把从 Gist 拉取的 agent 配置写入本地。disabled_servers 中的服务器不写入配置文件，
+// 只记在本地同步配置中，下次推送时再附加到元数据里，这样停用状态在各台机器之间保持一致
+func (as *AppService) applyRemoteAgentConfig(agentID string, config map[string]interface{}) error {
+	disabled, _ := config[disabledServersKey].(map[string]interface{})
+	if len(disabled) > 0 {
+		if servers, ok := standardServersFrom(config, as.configLoader.GetConfigKey(agentID)); ok {
+			enabled := make(map[string]interface{}, len(servers))
+			for name, server := range servers {
+				if _, off := disabled[name]; !off {
+					enabled[name] = server
+				}
+			}
+			// enabled is in the standard structure; keyed by mcpServers so it is not read as Zed servers
+			settings := as.extraSettingsIn(agentID, config)
+			config = map[string]interface{}{"mcpServers": enabled}
+			for key, value := range settings {
+				config[key] = value
+			}
+		}
+	}
+
+	if err := as.SaveAgentMCPConfig(agentID, config); err != nil {
+		return err
+	}
+	return as.rememberDisabledServers(agentID, disabled)
+}
+
+// rememberDisabledServers 用 disabled 替换本地记录的 agent 已停用服务器；disabled 为空时删除记录
+func (as *AppService) rememberDisabledServers(agentID string, disabled map[string]interface{}) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	syncConfig, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	if existing := syncConfig.DisabledServers[agentID]; (len(existing) == 0 && len(disabled) == 0) || jsonEqual(existing, disabled) {
+		return nil
+	}
+
+	if len(disabled) > 0 {
+		if syncConfig.DisabledServers == nil {
+			syncConfig.DisabledServers = make(map[string]map[string]interface{})
+		}
+		syncConfig.DisabledServers[agentID] = disabled
+	} else {
+		delete(syncConfig.DisabledServers, agentID)
+	}
+	if err := as.storage.SaveSyncConfig(syncConfig); err != nil {
+		return fmt.Errorf("failed to save disabled servers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"bytes"
+	"os"
+)
+
+// utf8BOM 是部分 Windows 编辑器在 UTF-8 文件开头写入的字节顺序标记
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// textEncoding 记录配置文件原有的 BOM 和换行风格，写回时保持不变
+type textEncoding struct {
+	bom  bool
+	crlf bool
+}
+
+// decodeConfigText 去掉开头的 UTF-8 BOM 并把 CRLF 换行统一为 LF，返回解析用的文本和原有编码
+func decodeConfigText(data []byte) ([]byte, textEncoding) {
+	var enc textEncoding
+	if bytes.HasPrefix(data, utf8BOM) {
+		enc.bom = true
+		data = data[len(utf8BOM):]
+	}
+	if bytes.Contains(data, []byte("\r\n")) {
+		enc.crlf = true
+		data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	}
+	return data, enc
+}
+
+// encode 把使用 LF 换行的文本恢复为原有的换行风格和 BOM
+func (enc textEncoding) encode(data []byte) []byte {
+	if enc.crlf {
+		data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	}
+	if enc.bom {
+		data = append(append([]byte{}, utf8BOM...), data...)
+	}
+	return data
+}
+
+// readConfigText 读取配置文件并去掉 BOM、统一换行
+func readConfigText(path string) ([]byte, textEncoding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, textEncoding{}, err
+	}
+	text, enc := decodeConfigText(data)
+	return text, enc, nil
+}
+
+// configFileEncoding 返回已有配置文件的编码；文件不存在或无法读取时使用无 BOM 的 LF
+func configFileEncoding(path string) textEncoding {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return textEncoding{}
+	}
+	_, enc := decodeConfigText(data)
+	return enc
+}
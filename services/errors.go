@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// Error categories returned by the gist and storage layers. Callers match them with errors.Is;
+// the wrapping errors keep the human-readable details.
+var (
+	ErrUnauthorized       = errors.New("GitHub token is invalid or lacks access")
+	ErrNotFound           = errors.New("not found")
+	ErrRateLimited        = errors.New("GitHub API rate limit exceeded")
+	ErrConflict           = errors.New("conflicting changes")
+	ErrEncryptionRequired = errors.New("encryption is required for Gist synchronization")
+	ErrDecryptFailed      = errors.New("failed to decrypt")
+	ErrSchemaValidation   = errors.New("config does not match the agent's schema")
+	ErrValidationFailed   = errors.New("config failed validation")
+)
+
+// kindError 是带有固定消息、同时属于某个错误类别的哨兵错误
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// APIError 是 GitHub API 的非成功响应；errors.Is 可以匹配到对应的错误类别
+type APIError struct {
+	Op         string
+	StatusCode int
+	Body       string
+	kind       error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s failed: %d - %s", e.Op, e.StatusCode, e.Body)
+}
+
+func (e *APIError) Unwrap() error { return e.kind }
+
+// newAPIError 读取响应体并按状态码归类
+func newAPIError(op string, resp *http.Response) *APIError {
+	body, _ := ioutil.ReadAll(resp.Body)
+	return &APIError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		kind:       classifyStatus(resp),
+	}
+}
+
+// classifyStatus maps a GitHub response status to an error category, or nil when none applies
+func classifyStatus(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		// GitHub reports primary rate limits as 403 with no remaining requests
+		if resp.StatusCode == http.StatusTooManyRequests || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			return ErrRateLimited
+		}
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrConflict
+	}
+	return nil
+}
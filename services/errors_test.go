@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIErrorCategories(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		want    error
+	}{
+		{name: "bad token", status: http.StatusUnauthorized, want: ErrUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, headers: map[string]string{"X-RateLimit-Remaining": "42"}, want: ErrUnauthorized},
+		{name: "rate limited", status: http.StatusForbidden, headers: map[string]string{"X-RateLimit-Remaining": "0"}, want: ErrRateLimited},
+		{name: "secondary rate limit", status: http.StatusTooManyRequests, want: ErrRateLimited},
+		{name: "missing gist", status: http.StatusNotFound, want: ErrNotFound},
+		{name: "conflict", status: http.StatusConflict, want: ErrConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"message": "stub"}`))
+			}))
+			defer server.Close()
+
+			gs := newTestGistSync("token-a", "gist-1")
+			gs.apiBaseURL = server.URL
+
+			err := gs.PushAgentConfigsToGist(map[string]interface{}{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("PushAgentConfigsToGist() error = %v, want %v", err, tt.want)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Errorf("PushAgentConfigsToGist() error = %v, want *APIError with status %d", err, tt.status)
+			}
+
+			if _, err := gs.PullAgentConfigsFromGist(); !errors.Is(err, tt.want) {
+				t.Errorf("PullAgentConfigsFromGist() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerErrorHasNoCategory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	gs := newTestGistSync("token-a", "gist-1")
+	gs.apiBaseURL = server.URL
+	err := gs.PushAgentConfigsToGist(map[string]interface{}{})
+	for _, kind := range []error{ErrUnauthorized, ErrNotFound, ErrRateLimited, ErrConflict} {
+		if errors.Is(err, kind) {
+			t.Errorf("502 error %v should not match %v", err, kind)
+		}
+	}
+}
+
+func TestGistErrorsMatchCategories(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+
+	if err := newTestGistSync("token-a", "missing").ValidateGist(); !errors.Is(err, ErrGistNotFound) || !errors.Is(err, ErrNotFound) {
+		t.Errorf("ValidateGist() error = %v, want ErrGistNotFound and ErrNotFound", err)
+	}
+	if _, err := newTestGistSync("token-a", "missing").GetLatestVersion(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetLatestVersion() error = %v, want ErrNotFound", err)
+	}
+	if err := newTestGistSync("bad-token", "missing").ValidateToken(); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("ValidateToken() error = %v, want ErrUnauthorized", err)
+	}
+
+	gistID := server.addGist("alice", `{"servers": []}`)
+	plain := NewGistSyncService("token-a", gistID)
+	if err := plain.PushAgentConfigsToGist(map[string]interface{}{}); !errors.Is(err, ErrEncryptionRequired) {
+		t.Errorf("PushAgentConfigsToGist() without encryption error = %v, want ErrEncryptionRequired", err)
+	}
+
+	otherKey := server.addGist("alice", mustEncryptWith(t, "other-password", `{"agents": {}}`))
+	if _, err := newTestGistSync("token-a", otherKey).PullAgentConfigsFromGist(); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("PullAgentConfigsFromGist() error = %v, want ErrDecryptFailed", err)
+	}
+}
+
+func TestStorageDecryptFailedWithDifferentKey(t *testing.T) {
+	storage, _ := newRotationFixture(t)
+
+	other := NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	other.dataDir = storage.dataDir
+	if err := other.Enable(); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	storage.crypto = other
+
+	if _, err := storage.LoadSyncConfig(); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("LoadSyncConfig() error = %v, want ErrDecryptFailed", err)
+	}
+}
+
+// mustEncryptWith encrypts a gist payload with a password other than the test default
+func mustEncryptWith(t *testing.T, password, plaintext string) string {
+	t.Helper()
+	encrypted, err := NewSecurityManager(password).Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
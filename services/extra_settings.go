@@ -0,0 +1,34 @@
+package services
+
+// readExtraSettings 返回 agent 配置文件中 extra_sync_keys 声明的设置；agent 没有声明时返回 nil
+func (as *AppService) readExtraSettings(agentID, path string) (map[string]interface{}, error) {
+	keys := as.configLoader.GetExtraSyncKeys(agentID)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	adapter, _ := formatAdapterFor(as.configLoader.GetFormat(agentID)).(SettingsAdapter)
+	return adapter.ReadSettings(path, keys)
+}
+
+// extraSettingsIn 返回 config（以配置键为键的 agent 配置）中 extra_sync_keys 声明的设置
+func (as *AppService) extraSettingsIn(agentID string, config map[string]interface{}) map[string]interface{} {
+	keys := as.configLoader.GetExtraSyncKeys(agentID)
+	if len(keys) == 0 {
+		return nil
+	}
+	return pickSettings(config, keys)
+}
+
+// writeExtraSettings 把 settings 中的每个设置写入 agent 配置文件，文件中的其他内容保留
+func (as *AppService) writeExtraSettings(agentID, path string, settings map[string]interface{}) error {
+	if len(settings) == 0 {
+		return nil
+	}
+	adapter, _ := formatAdapterFor(as.configLoader.GetFormat(agentID)).(SettingsAdapter)
+	for _, key := range sortedKeys(settings) {
+		if err := adapter.WriteSetting(path, key, settings[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const cursorExtraKeysYAML = `
+agents:
+  - id: cursor
+    extra_sync_keys: [mcpTimeout, allowedTools]
+`
+
+// extraKeysMachine sets up a machine whose cursor definition syncs mcpTimeout and allowedTools, connected to gistID
+func extraKeysMachine(t *testing.T, gistID string) *AppService {
+	t.Helper()
+	newTestAppService(t)
+	writeUserAgents(t, "cursor.yaml", cursorExtraKeysYAML)
+	as := reloadTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	return as
+}
+
+func TestExtraSyncKeysRoundTrip(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	laptop := extraKeysMachine(t, gistID)
+	writeAgentFile(t, laptop, "cursor", `{
+  "theme": "dark",
+  "mcpTimeout": 30000,
+  "allowedTools": ["fetch", "git"],
+  "mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}}
+}`)
+	if err := laptop.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, "mcpTimeout") || !strings.Contains(pushed, "allowedTools") || strings.Contains(pushed, "theme") {
+		t.Errorf("pushed payload should carry the declared settings only:\n%s", pushed)
+	}
+
+	desktop := extraKeysMachine(t, gistID)
+	path := writeAgentFile(t, desktop, "cursor", `{"theme": "light", "mcpTimeout": 5000, "mcpServers": {}}`)
+	if _, err := desktop.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+
+	var written map[string]interface{}
+	if err := json.Unmarshal([]byte(readFile(t, path)), &written); err != nil {
+		t.Fatal(err)
+	}
+	if written["theme"] != "light" || written["mcpTimeout"] != float64(30000) {
+		t.Errorf("cursor config after pull = %v, want theme kept and mcpTimeout synced", written)
+	}
+	if tools, _ := written["allowedTools"].([]interface{}); len(tools) != 2 {
+		t.Errorf("allowedTools after pull = %v, want [fetch git]", written["allowedTools"])
+	}
+	if servers, _ := written["mcpServers"].(map[string]interface{}); servers["fetch"] == nil {
+		t.Errorf("servers after pull = %v, want fetch", written["mcpServers"])
+	}
+}
+
+func TestExtraSyncKeysValidation(t *testing.T) {
+	loader, err := newConfigLoaderFromYAML([]byte(`
+agents:
+  - id: tool
+    name: Tool
+    config_key: mcpServers
+    format: standard
+    extra_sync_keys: [mcpServers, timeout, ""]
+    platforms:
+      linux:
+        config_paths: [~/.tool.json]
+  - id: codex-like
+    name: Codex Like
+    config_key: mcp_servers
+    format: codex_toml
+    extra_sync_keys: [model]
+    platforms:
+      linux:
+        config_paths: [~/.codex-like.toml]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := loader.GetExtraSyncKeys("tool"); len(keys) != 1 || keys[0] != "timeout" {
+		t.Errorf("GetExtraSyncKeys(tool) = %v, want [timeout]", keys)
+	}
+	if keys := loader.GetExtraSyncKeys("codex-like"); len(keys) != 0 {
+		t.Errorf("GetExtraSyncKeys(codex-like) = %v, want none for TOML", keys)
+	}
+	problems := strings.Join(loader.ValidateAgentDefinitions(), "\n")
+	for _, want := range []string{`"mcpServers" is a servers key`, "empty key in extra_sync_keys", `not supported for format "codex_toml"`} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("ValidateAgentDefinitions() lacks %q:\n%s", want, problems)
+		}
+	}
+}
+
+func TestPushByTagKeepsExtraSyncKeys(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	as := extraKeysMachine(t, gistID)
+	writeAgentFile(t, as, "cursor", `{
+  "mcpTimeout": 30000,
+  "mcpServers": {"work-db": {"command": "db", "tags": ["work"]}}
+}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if err := as.PushByTag("work"); err != nil {
+		t.Fatalf("PushByTag() error = %v", err)
+	}
+
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, "mcpTimeout") || !strings.Contains(pushed, "work-db") {
+		t.Errorf("PushByTag() dropped the declared settings or the tagged server:\n%s", pushed)
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// FileSystem 是 StorageService 使用的文件操作，测试中可以替换
+type FileSystem interface {
+	MkdirAll(path string, perm os.FileMode) error
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// AppendFile 在文件末尾追加 data，文件不存在时创建
+	AppendFile(path string, data []byte, perm os.FileMode) error
+	// ReadDir 返回目录中的条目，按文件名排序
+	ReadDir(path string) ([]os.FileInfo, error)
+	Stat(path string) (os.FileInfo, error)
+	Remove(path string) error
+	Rename(oldPath, newPath string) error
+}
+
+// Clock 提供当前时间，测试中可以替换为固定或可推进的时钟
+type Clock interface {
+	Now() time.Time
+}
+
+// osFileSystem 直接使用 os 和 ioutil
+type osFileSystem struct{}
+
+func (osFileSystem) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFileSystem) ReadFile(path string) ([]byte, error)         { return ioutil.ReadFile(path) }
+func (osFileSystem) ReadDir(path string) ([]os.FileInfo, error)   { return ioutil.ReadDir(path) }
+func (osFileSystem) Stat(path string) (os.FileInfo, error)        { return os.Stat(path) }
+func (osFileSystem) Remove(path string) error                     { return os.Remove(path) }
+func (osFileSystem) Rename(oldPath, newPath string) error         { return os.Rename(oldPath, newPath) }
+
+func (osFileSystem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(path, data, perm)
+}
+
+func (osFileSystem) AppendFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// realClock 返回系统时间
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FormatAdapter 读写某种格式的 agent 配置文件中的 MCP 服务器部分，并保留文件中的其他内容
+type FormatAdapter interface {
+	// ReadServers 返回 configKey 下的服务器，值为 JSON 结构（Zed 保持其原有的字段）
+	ReadServers(path, configKey string) (map[string]interface{}, error)
+	// WriteServers 将标准格式（mcpServers 结构）的服务器写入 configKey
+	WriteServers(path, configKey string, servers map[string]interface{}) error
+}
+
+// SettingsAdapter 由能读写服务器之外的顶层设置（agents.yaml 中的 extra_sync_keys）的适配器实现
+type SettingsAdapter interface {
+	// ReadSettings 返回 keys 中在文件里存在的设置
+	ReadSettings(path string, keys []string) (map[string]interface{}, error)
+	// WriteSetting 把顶层设置 key 替换为 value（不存在时添加），保留文件中的其他内容
+	WriteSetting(path, key string, value interface{}) error
+}
+
+// formatAdapters 按 agents.yaml 中的 format 注册适配器；未注册的格式按标准 JSON 处理
+var formatAdapters = map[string]FormatAdapter{
+	"standard":   jsonFormatAdapter{},
+	"zed":        zedFormatAdapter{},
+	"codex_toml": tomlFormatAdapter{},
+	"codex":      tomlFormatAdapter{},
+	"toml":       tomlFormatAdapter{},
+	"json5":      json5FormatAdapter{},
+}
+
+// formatAdapterFor 返回指定格式的适配器
+func formatAdapterFor(format string) FormatAdapter {
+	if adapter, ok := formatAdapters[format]; ok {
+		return adapter
+	}
+	return jsonFormatAdapter{}
+}
+
+// standardServersFrom 从以任一配置键（context_servers、agent 自己的键、mcpServers 或 mcp_servers）为键的配置中
+// 取出服务器并转换为标准结构；没有服务器部分时返回 false
+func standardServersFrom(config map[string]interface{}, configKey string) (map[string]interface{}, bool) {
+	if zed, ok := config["context_servers"]; ok {
+		servers, _ := convertZedToStandard(zed).(map[string]interface{})
+		return servers, true
+	}
+	for _, key := range []string{configKey, "mcpServers", "mcp_servers"} {
+		if value, ok := config[key]; ok {
+			servers, _ := value.(map[string]interface{})
+			return servers, true
+		}
+	}
+	return nil, false
+}
+
+// jsonFormatAdapter 读写 JSON 配置文件（允许整行 // 注释）
+type jsonFormatAdapter struct{}
+
+func (jsonFormatAdapter) ReadServers(path, configKey string) (map[string]interface{}, error) {
+	config, err := readJSONConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, ok := config[configKey].(map[string]interface{})
+	if !ok {
+		servers = make(map[string]interface{})
+	}
+	return servers, nil
+}
+
+func (jsonFormatAdapter) WriteServers(path, configKey string, servers map[string]interface{}) error {
+	return writeJSONConfigSection(path, configKey, servers)
+}
+
+func (jsonFormatAdapter) ReadSettings(path string, keys []string) (map[string]interface{}, error) {
+	config, err := readJSONConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return pickSettings(config, keys), nil
+}
+
+func (jsonFormatAdapter) WriteSetting(path, key string, value interface{}) error {
+	return writeJSONConfigSection(path, key, value)
+}
+
+// pickSettings 返回 config 中 keys 里存在的键
+func pickSettings(config map[string]interface{}, keys []string) map[string]interface{} {
+	settings := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := config[key]; ok {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// zedFormatAdapter 读写 Zed 的 settings.json，写入时补充 Zed 需要的 source/enabled 字段。
+// settings.json 是 JSONC（允许注释和尾随逗号），写入时只替换 context_servers 的值，其余设置和注释原样保留
+type zedFormatAdapter struct{}
+
+func (zedFormatAdapter) ReadServers(path, configKey string) (map[string]interface{}, error) {
+	return json5FormatAdapter{}.ReadServers(path, configKey)
+}
+
+func (zedFormatAdapter) WriteServers(path, configKey string, servers map[string]interface{}) error {
+	return writeJSONCConfigSection(path, configKey, convertStandardToZed(servers))
+}
+
+func (zedFormatAdapter) ReadSettings(path string, keys []string) (map[string]interface{}, error) {
+	return json5FormatAdapter{}.ReadSettings(path, keys)
+}
+
+func (zedFormatAdapter) WriteSetting(path, key string, value interface{}) error {
+	return writeJSONCConfigSection(path, key, value)
+}
+
+// json5FormatAdapter 读写 JSON5 配置文件，写入时只替换服务器部分，保留其余内容和注释
+type json5FormatAdapter struct{}
+
+func (json5FormatAdapter) ReadServers(path, configKey string) (map[string]interface{}, error) {
+	config, err := readJSON5ConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	servers, ok := config[configKey].(map[string]interface{})
+	if !ok {
+		servers = make(map[string]interface{})
+	}
+	return servers, nil
+}
+
+func (json5FormatAdapter) WriteServers(path, configKey string, servers map[string]interface{}) error {
+	return writeJSON5ConfigSection(path, configKey, servers)
+}
+
+func (json5FormatAdapter) ReadSettings(path string, keys []string) (map[string]interface{}, error) {
+	config, err := readJSON5ConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return pickSettings(config, keys), nil
+}
+
+func (json5FormatAdapter) WriteSetting(path, key string, value interface{}) error {
+	return writeJSON5ConfigSection(path, key, value)
+}
+
+// tomlFormatAdapter 通过 TOMLAdapter 读写 Codex 的 config.toml
+type tomlFormatAdapter struct{}
+
+func (tomlFormatAdapter) ReadServers(path, configKey string) (map[string]interface{}, error) {
+	servers, err := NewTOMLAdapter().GetMCPServersAsStandard(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round-trip through JSON so TOML values have the same types as the JSON read path
+	// ([]interface{} instead of []string), otherwise comparisons with pulled configs differ
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return nil, err
+	}
+	servers = make(map[string]interface{})
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+func (tomlFormatAdapter) WriteServers(path, configKey string, servers map[string]interface{}) error {
+	return NewTOMLAdapter().SetMCPServersFromStandard(path, servers)
+}
+
+// readJSONConfigFile 读取完整的 JSON 配置文件，忽略整行 // 注释
+func readJSONConfigFile(path string) (map[string]interface{}, error) {
+	data, _, err := readConfigText(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cleanedLines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			cleanedLines = append(cleanedLines, line)
+		}
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.Join(cleanedLines, "\n")), &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+	return config, nil
+}
+
+// writeJSONConfigSection 替换 JSON 配置文件中 configKey 的值，保留其他设置。
+// 能逐个服务器修改时只改动变化的服务器（见 patchSection），文件其余部分的格式不变；
+// 否则重新格式化整个文件，设置和服务器保持原来的顺序
+func writeJSONConfigSection(path, configKey string, section interface{}) error {
+	data, enc, err := readConfigText(path)
+	if err != nil {
+		return err
+	}
+	if patched, ok, err := patchSection(data, configKey, section, jsoncStyle); err != nil {
+		return err
+	} else if ok {
+		return os.WriteFile(path, enc.encode(patched), 0644)
+	}
+
+	config, err := readJSONConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Keep the order of the settings and servers as they are in the file
+	var order []string
+	if doc, err := parseJSON5(data); err == nil && doc.objectOpen >= 0 {
+		order = memberKeys(doc.members)
+		if member, ok := doc.member(configKey); ok {
+			if servers, ok := section.(map[string]interface{}); ok {
+				section = withKeyOrder(servers, objectKeyOrder(data, member.valueStart))
+			}
+		}
+	}
+	config[configKey] = section
+
+	data, err = json.MarshalIndent(withKeyOrder(config, order), "", "  ")
+	if err != nil {
+		return err
+	}
+	// Keep the BOM and line endings the file was saved with
+	return os.WriteFile(path, enc.encode(data), 0644)
+}
@@ -0,0 +1,362 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestSaveAgentMCPConfigWritesEachFormat(t *testing.T) {
+	as := newTestAppService(t)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"old": {"command": "old"}}, "theme": "dark"}`)
+	zedPath := writeAgentFile(t, as, "zed", "// Zed settings\n{\"theme\": \"One Dark\", \"context_servers\": {}}")
+	codexPath := writeAgentFile(t, as, "codex", "model = \"o3\"\n\n[mcp_servers.old]\ncommand = \"old\"\n")
+
+	standard := map[string]interface{}{
+		"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{
+				"command": "uvx",
+				"args":    []interface{}{"mcp-server-fetch"},
+				"env":     map[string]interface{}{"LOG": "debug"},
+			},
+		},
+	}
+	for _, agentID := range []string{"cursor", "zed", "codex"} {
+		if err := as.SaveAgentMCPConfig(agentID, standard); err != nil {
+			t.Fatalf("SaveAgentMCPConfig(%s) error = %v", agentID, err)
+		}
+	}
+
+	var cursor map[string]interface{}
+	if err := json.Unmarshal([]byte(readFile(t, cursorPath)), &cursor); err != nil {
+		t.Fatalf("cursor config is not valid JSON: %v", err)
+	}
+	servers, _ := cursor["mcpServers"].(map[string]interface{})
+	if cursor["theme"] != "dark" || servers["fetch"] == nil || servers["old"] != nil {
+		t.Errorf("cursor config = %+v", cursor)
+	}
+
+	// Zed settings are JSONC; the comment is kept
+	zed, err := readJSON5ConfigFile(zedPath)
+	if err != nil {
+		t.Fatalf("zed settings are not valid JSONC: %v", err)
+	}
+	if !strings.HasPrefix(readFile(t, zedPath), "// Zed settings\n") {
+		t.Errorf("zed settings lost their comment: %s", readFile(t, zedPath))
+	}
+	zedServers, _ := zed["context_servers"].(map[string]interface{})
+	fetch, _ := zedServers["fetch"].(map[string]interface{})
+	if zed["theme"] != "One Dark" || fetch["source"] != "custom" || fetch["command"] != "uvx" {
+		t.Errorf("zed settings = %+v", zed)
+	}
+
+	var codex CodexConfig
+	if _, err := toml.Decode(readFile(t, codexPath), &codex); err != nil {
+		t.Fatalf("codex config is not valid TOML: %v", err)
+	}
+	if codex.Model != "o3" || len(codex.MCPServers) != 1 || codex.MCPServers["fetch"].Command != "uvx" {
+		t.Errorf("codex config = %+v", codex)
+	}
+	if env := codex.MCPServers["fetch"].Env; env["LOG"] != "debug" {
+		t.Errorf("codex env = %+v", env)
+	}
+}
+
+func TestSaveAgentMCPConfigAcceptsAgentKeyedInput(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "zed", `{"context_servers": {}}`)
+	codexPath := writeAgentFile(t, as, "codex", "")
+
+	// Configs read from one agent are written back to another in the target's format
+	zedInput := map[string]interface{}{
+		"context_servers": map[string]interface{}{
+			"fetch": map[string]interface{}{"source": "custom", "enabled": true, "command": "uvx"},
+		},
+	}
+	if err := as.SaveAgentMCPConfig("codex", zedInput); err != nil {
+		t.Fatalf("SaveAgentMCPConfig(codex) error = %v", err)
+	}
+	var codex CodexConfig
+	if _, err := toml.Decode(readFile(t, codexPath), &codex); err != nil {
+		t.Fatalf("codex config is not valid TOML: %v", err)
+	}
+	if codex.MCPServers["fetch"].Command != "uvx" {
+		t.Errorf("codex config = %+v", codex)
+	}
+
+	codexInput, err := as.GetAgentMCPConfig("codex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.SaveAgentMCPConfig("zed", codexInput); err != nil {
+		t.Fatalf("SaveAgentMCPConfig(zed) error = %v", err)
+	}
+	zed, err := as.GetAgentMCPConfig("zed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, _ := zed["context_servers"].(map[string]interface{})
+	if fetch, _ := servers["fetch"].(map[string]interface{}); fetch["command"] != "uvx" || fetch["enabled"] != true {
+		t.Errorf("zed config = %+v", zed)
+	}
+}
+
+func TestSyncConfigBetweenAgentsSkipsUnchangedTarget(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"], "env": {"LOG": "debug"}}}}`)
+	targets := map[string]string{
+		"zed":   writeAgentFile(t, as, "zed", `{"theme": "One Dark", "context_servers": {}}`),
+		"codex": writeAgentFile(t, as, "codex", "model = \"o3\"\n"),
+	}
+
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for agentID, path := range targets {
+		result, err := as.SyncConfigBetweenAgents("cursor", agentID)
+		if err != nil || !result.Written {
+			t.Fatalf("first SyncConfigBetweenAgents(%s) = %+v, %v; want written", agentID, result, err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		written := readFile(t, path)
+
+		result, err = as.SyncConfigBetweenAgents("cursor", agentID)
+		if err != nil || result.Written {
+			t.Errorf("second SyncConfigBetweenAgents(%s) = %+v, %v; want not written", agentID, result, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) || readFile(t, path) != written {
+			t.Errorf("%s config was rewritten by an identical sync", agentID)
+		}
+	}
+
+	// A real difference is still written
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"], "env": {"LOG": "info"}}}}`)
+	if result, err := as.SyncConfigBetweenAgents("cursor", "zed"); err != nil || !result.Written {
+		t.Errorf("SyncConfigBetweenAgents(zed) after a change = %+v, %v; want written", result, err)
+	}
+}
+
+func TestAgentConfigWithBOMAndCRLF(t *testing.T) {
+	as := newTestAppService(t)
+	bom := "\xef\xbb\xbf"
+	cursorPath := writeAgentFile(t, as, "cursor", bom+"{\r\n  \"mcpServers\": {\r\n    \"fetch\": {\"command\": \"uvx\"}\r\n  }\r\n}\r\n")
+	codexPath := writeAgentFile(t, as, "codex", bom+"model = \"o3\"\r\n\r\n[mcp_servers.fetch]\r\ncommand = \"uvx\"\r\n")
+
+	// Both configs are read and collected for push instead of being skipped
+	collected, err := as.collectAgentConfigs()
+	if err != nil {
+		t.Fatalf("collectAgentConfigs() error = %v", err)
+	}
+	for _, agentID := range []string{"cursor", "codex"} {
+		config, _ := collected[agentID].(map[string]interface{})
+		servers, _ := standardServersFrom(config, as.configLoader.GetConfigKey(agentID))
+		if servers["fetch"] == nil {
+			t.Errorf("%s config was not read: %#v", agentID, collected[agentID])
+		}
+	}
+
+	standard := map[string]interface{}{"mcpServers": map[string]interface{}{
+		"fetch": map[string]interface{}{"command": "uvx"},
+		"git":   map[string]interface{}{"command": "git-mcp"},
+	}}
+	for _, agentID := range []string{"cursor", "codex"} {
+		if err := as.SaveAgentMCPConfig(agentID, standard); err != nil {
+			t.Fatalf("SaveAgentMCPConfig(%s) error = %v", agentID, err)
+		}
+	}
+
+	// The BOM and CRLF line endings survive the write
+	for _, path := range []string{cursorPath, codexPath} {
+		written := readFile(t, path)
+		if !strings.HasPrefix(written, bom) || !strings.Contains(written, "git-mcp") {
+			t.Errorf("%s lost its BOM or the new server:\n%q", path, written)
+		}
+		if strings.Count(written, "\n") != strings.Count(written, "\r\n") {
+			t.Errorf("%s has mixed line endings:\n%q", path, written)
+		}
+	}
+}
+
+func TestZedWriteReplacesOnlyContextServers(t *testing.T) {
+	as := newTestAppService(t)
+	prefix := `// Zed settings
+//
+// For information on how to configure Zed, see the Zed
+// documentation: https://zed.dev/docs/configuring-zed
+{
+  "theme": {
+    "mode": "system",
+    "light": "One Light",
+    "dark": "One Dark" // hand-picked
+  },
+  "ui_font_size": 16,
+  "buffer_font_size":   15,
+  /* MCP servers */
+  "context_servers": `
+	suffix := `,
+  "vim_mode": true,
+  "languages": {
+    "Python": { "tab_size": 4 },
+  },
+}
+`
+	path := writeAgentFile(t, as, "zed", prefix+`{
+    "old": { "source": "custom", "command": "old-mcp", "enabled": true }
+  }`+suffix)
+
+	if err := as.SaveAgentMCPConfig("zed", map[string]interface{}{"mcpServers": map[string]interface{}{
+		"fetch": map[string]interface{}{"command": "uvx", "args": []interface{}{"mcp-server-fetch"}},
+	}}); err != nil {
+		t.Fatalf("SaveAgentMCPConfig() error = %v", err)
+	}
+
+	written := readFile(t, path)
+	if !strings.HasPrefix(written, prefix) || !strings.HasSuffix(written, suffix) {
+		t.Fatalf("text outside context_servers changed:\n%s", written)
+	}
+	if strings.Contains(written, "old-mcp") {
+		t.Errorf("old servers were not replaced:\n%s", written)
+	}
+
+	var servers map[string]interface{}
+	value := strings.TrimSuffix(strings.TrimPrefix(written, prefix), suffix)
+	if err := json.Unmarshal([]byte(value), &servers); err != nil {
+		t.Fatalf("context_servers is not plain JSON: %v\n%s", err, value)
+	}
+	fetch, _ := servers["fetch"].(map[string]interface{})
+	if fetch["source"] != "custom" || fetch["command"] != "uvx" {
+		t.Errorf("context_servers = %#v", servers)
+	}
+
+	read, err := as.GetAgentMCPConfig("zed")
+	if err != nil {
+		t.Fatalf("GetAgentMCPConfig() error = %v", err)
+	}
+	if got, _ := standardServersFrom(read, "context_servers"); got["fetch"] == nil {
+		t.Errorf("GetAgentMCPConfig() = %#v", read)
+	}
+}
+
+func TestSaveAgentMCPConfigPatchesOnlyChangedServers(t *testing.T) {
+	as := newTestAppService(t)
+	original := `{
+    "editor.fontSize": 14,
+    "mcpServers": {
+        "fetch": {"command": "uvx", "args": ["mcp-server-fetch"]},
+        "git": {
+            "command": "git-mcp",
+            "env": {"GIT_DIR": "/repo"}
+        },
+        "time": {"command": "time-mcp"}
+    },
+    "telemetry": false
+}
+`
+	path := writeAgentFile(t, as, "cursor", original)
+
+	servers := map[string]interface{}{
+		"fetch": map[string]interface{}{"command": "uvx", "args": []interface{}{"mcp-server-fetch"}},
+		"git":   map[string]interface{}{"command": "git-mcp", "env": map[string]interface{}{"GIT_DIR": "/other"}},
+		"time":  map[string]interface{}{"command": "time-mcp"},
+	}
+	if err := as.SaveAgentMCPConfig("cursor", map[string]interface{}{"mcpServers": servers}); err != nil {
+		t.Fatalf("SaveAgentMCPConfig() error = %v", err)
+	}
+
+	// Only the lines of the git server differ; the rest keeps its formatting
+	before := strings.Split(original, "\n")
+	after := strings.Split(readFile(t, path), "\n")
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	changed := after[prefix : len(after)-suffix]
+	if len(changed) == 0 || len(changed) > 6 || !strings.Contains(strings.Join(changed, "\n"), `"/other"`) {
+		t.Fatalf("changing one env value rewrote %d lines:\n%s", len(changed), strings.Join(changed, "\n"))
+	}
+	for _, line := range changed {
+		if strings.Contains(line, "fetch") || strings.Contains(line, "time") {
+			t.Errorf("unchanged server was rewritten: %q", line)
+		}
+	}
+
+	// Removing the last server and adding a new one keeps the file valid JSON
+	delete(servers, "time")
+	servers["memory"] = map[string]interface{}{"command": "memory-mcp"}
+	if err := as.SaveAgentMCPConfig("cursor", map[string]interface{}{"mcpServers": servers}); err != nil {
+		t.Fatalf("SaveAgentMCPConfig() error = %v", err)
+	}
+	written := readFile(t, path)
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(written), &config); err != nil {
+		t.Fatalf("patched file is not valid JSON: %v\n%s", err, written)
+	}
+	got, _ := config["mcpServers"].(map[string]interface{})
+	if len(got) != 3 || got["memory"] == nil || got["time"] != nil {
+		t.Errorf("mcpServers = %v, want fetch, git and memory", got)
+	}
+	if !strings.Contains(written, `"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]},`) {
+		t.Errorf("unchanged server lost its formatting:\n%s", written)
+	}
+}
+
+func TestReadModifyWriteKeepsServerOrder(t *testing.T) {
+	as := newTestAppService(t)
+	codex := "model = \"o3\"\n\n" +
+		"[mcp_servers.zeta]\ncommand = \"zeta-mcp\"\n\n" +
+		"[mcp_servers.alpha]\ncommand = \"alpha-mcp\"\nargs = [\"--x\"]\n\n" +
+		"[mcp_servers.mid]\ncommand = \"mid-mcp\"\n\n"
+	codexPath := writeAgentFile(t, as, "codex", codex)
+	// Members sharing a line cannot be patched, so this file is rewritten as a whole
+	cursorPath := writeAgentFile(t, as, "cursor", `{"theme": "dark", "mcpServers": {"zeta": {"command": "zeta-mcp"}, "alpha": {"command": "alpha-mcp"}, "mid": {"command": "mid-mcp"}}, "accent": "blue"}`)
+
+	for _, agentID := range []string{"codex", "cursor"} {
+		servers, err := as.standardAgentServers(agentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := as.SaveAgentMCPConfig(agentID, map[string]interface{}{"mcpServers": servers}); err != nil {
+			t.Fatalf("SaveAgentMCPConfig(%s) error = %v", agentID, err)
+		}
+	}
+
+	if got := readFile(t, codexPath); got != codex {
+		t.Errorf("unchanged Codex config was rewritten as:\n%s\nwant:\n%s", got, codex)
+	}
+	assertKeyOrder(t, readFile(t, cursorPath), `"theme"`, `"mcpServers"`, `"zeta"`, `"alpha"`, `"mid"`, `"accent"`)
+
+	// A new server goes after the existing ones
+	servers, _ := as.standardAgentServers("codex")
+	servers["beta"] = map[string]interface{}{"command": "beta-mcp"}
+	if err := as.SaveAgentMCPConfig("codex", map[string]interface{}{"mcpServers": servers}); err != nil {
+		t.Fatal(err)
+	}
+	assertKeyOrder(t, readFile(t, codexPath), "mcp_servers.zeta]", "mcp_servers.alpha]", "mcp_servers.mid]", "mcp_servers.beta]")
+}
+
+// assertKeyOrder fails unless each key appears in text after the one before it
+func assertKeyOrder(t *testing.T, text string, keys ...string) {
+	t.Helper()
+	last := -1
+	for _, key := range keys {
+		pos := strings.Index(text, key)
+		if pos <= last {
+			t.Errorf("%s is out of order in:\n%s", key, text)
+			return
+		}
+		last = pos
+	}
+}
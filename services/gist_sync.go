@@ -5,29 +5,106 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"mcp-sync/models"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
+// githubAPIBaseURL is the GitHub REST endpoint used by new GistSyncService instances
+var githubAPIBaseURL = "https://api.github.com"
+
+// DefaultGistFileName 是未配置文件名时 Gist 中保存同步配置的文件
+const DefaultGistFileName = "mcp-config.json"
+
 type GistSyncService struct {
 	githubToken       string
 	gistID            string
+	fileName          string
+	limits            models.ConfigLimits
+	apiBaseURL        string
 	client            *http.Client
 	encryptionEnabled bool
 	encryptionKey     string
 	securityMgr       CryptoOperations
+	// environment 是本机的环境名，拉取时叠加 Gist 中同名的覆盖层
+	environment string
+	// versionCache 由 WithCredentials 等副本共享
+	versionCache *versionCache
+	// readOnly 为 true 时拒绝修改 Gist 的请求，见 ReadOnly
+	readOnly bool
+	// keyring 是不使用密码时保存加密密钥的密钥环；为 nil 时使用系统密钥环
+	keyring SystemKeyring
+}
+
+// versionCache 记录最近一次 GetLatestVersion 的结果，远程未变化时跳过下载和解密。
+// 条目按 Gist、文件名、环境和加密密钥区分，换了其中任何一个都会重新获取
+type versionCache struct {
+	mu      sync.Mutex
+	key     string
+	etag    string
+	rawHash string
+	version *models.ConfigVersion
+}
+
+// lookup 返回与 key 匹配的缓存条目
+func (c *versionCache) lookup(key string) (etag, rawHash string, version *models.ConfigVersion, ok bool) {
+	if c == nil {
+		return "", "", nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key != key {
+		return "", "", nil, false
+	}
+	return c.etag, c.rawHash, copyVersion(c.version), true
+}
+
+func (c *versionCache) store(key, etag, rawHash string, version *models.ConfigVersion) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key, c.etag, c.rawHash, c.version = key, etag, rawHash, copyVersion(version)
+}
+
+// copyVersion 复制版本，避免调用方修改缓存中的值
+func copyVersion(version *models.ConfigVersion) *models.ConfigVersion {
+	if version == nil {
+		return nil
+	}
+	clone := *version
+	return &clone
 }
 
 func NewGistSyncService(githubToken, gistID string) *GistSyncService {
 	return &GistSyncService{
 		githubToken:       githubToken,
 		gistID:            gistID,
+		fileName:          DefaultGistFileName,
+		limits:            DefaultConfigLimits,
+		apiBaseURL:        githubAPIBaseURL,
 		client:            &http.Client{Timeout: 10 * time.Second},
 		encryptionEnabled: false,
+		versionCache:      &versionCache{},
+	}
+}
+
+// SetKeyring 指定不使用密码时保存加密密钥的密钥环（与本地存储共用），在 SetEncryption 之前调用；nil 表示系统密钥环
+func (gs *GistSyncService) SetKeyring(keyring SystemKeyring) {
+	gs.keyring = keyring
+}
+
+// secureCrypto 返回使用 gs.keyring 的 SecureCrypto，没有指定密钥环时使用系统密钥环
+func (gs *GistSyncService) secureCrypto() (*SecureCrypto, error) {
+	if gs.keyring != nil {
+		return NewSecureCryptoWithKeyring(gs.keyring), nil
 	}
+	return NewSecureCrypto()
 }
 
 // SetEncryption 设置加密参数
@@ -36,7 +113,7 @@ func (gs *GistSyncService) SetEncryption(enabled bool, password string) error {
 	if enabled {
 		if password == "" {
 			// 新版本：使用加密文件的密钥而不是用户提供的密码
-			crypto, err := NewSecureCrypto()
+			crypto, err := gs.secureCrypto()
 			if err != nil {
 				return fmt.Errorf("failed to initialize secure encryption: %w", err)
 			}
@@ -56,7 +133,7 @@ func (gs *GistSyncService) SetEncryption(enabled bool, password string) error {
 			gs.securityMgr = NewSecurityManager(password)
 			
 			// 尝试迁移到新系统
-			crypto, _ := NewSecureCrypto()
+			crypto, _ := gs.secureCrypto()
 			if crypto != nil {
 				if err := crypto.MigrateFromPassword(password); err == nil {
 					if err := crypto.Enable(); err == nil {
@@ -74,6 +151,39 @@ func (gs *GistSyncService) SetEncryption(enabled bool, password string) error {
 	return nil
 }
 
+// SetToken 替换 GitHub token，保留 Gist ID 和加密设置
+func (gs *GistSyncService) SetToken(githubToken string) {
+	gs.githubToken = githubToken
+}
+
+// SetFileName 设置 Gist 中保存同步配置的文件名，空字符串恢复默认的 mcp-config.json
+func (gs *GistSyncService) SetFileName(fileName string) {
+	if fileName == "" {
+		fileName = DefaultGistFileName
+	}
+	gs.fileName = fileName
+}
+
+// SetEnvironment 设置本机的环境名，拉取时叠加 Gist 中该名称的覆盖层；为空时不使用覆盖层
+func (gs *GistSyncService) SetEnvironment(name string) {
+	gs.environment = name
+}
+
+// SetLimits 设置拉取内容的规模限制
+func (gs *GistSyncService) SetLimits(limits models.ConfigLimits) {
+	gs.limits = limits
+}
+
+// FileName 返回 Gist 中保存同步配置的文件名
+func (gs *GistSyncService) FileName() string {
+	return gs.fileName
+}
+
+// IsEncryptionEnabled 返回 Gist 同步是否已配置可用的加密
+func (gs *GistSyncService) IsEncryptionEnabled() bool {
+	return gs.encryptionEnabled && gs.securityMgr != nil
+}
+
 // SecurityManagerAdapter 适配器，将SecureCrypto包装为CryptoOperations接口
 type SecurityManagerAdapter struct {
 	crypto *SecureCrypto
@@ -87,16 +197,41 @@ func (sma *SecurityManagerAdapter) Decrypt(ciphertext string) (string, error) {
 	return sma.crypto.Decrypt(ciphertext)
 }
 
+func (sma *SecurityManagerAdapter) DecryptBytes(ciphertext string) ([]byte, error) {
+	return sma.crypto.DecryptBytes(ciphertext)
+}
+
 type GistFile struct {
 	Content string `json:"content"`
 }
 
+type GistOwner struct {
+	Login string `json:"login"`
+}
+
 type GistResponse struct {
-	ID      string             `json:"id"`
-	Files   map[string]GistFile `json:"files"`
-	Updated string             `json:"updated_at"`
+	ID          string              `json:"id"`
+	Description string              `json:"description"`
+	Files       map[string]GistFile `json:"files"`
+	Updated     string              `json:"updated_at"`
+	Owner       *GistOwner          `json:"owner,omitempty"`
 }
 
+// Gist validation errors, distinguished so the UI can guide the user
+var (
+	ErrGistNotFound          error = &kindError{msg: "gist not found", kind: ErrNotFound}
+	ErrGistNoAccess          error = &kindError{msg: "gist is not accessible with this token", kind: ErrUnauthorized}
+	ErrGistWrongOwner              = errors.New("gist belongs to a different GitHub account")
+	ErrGistUnexpectedContent       = errors.New("gist does not contain the sync config file")
+	ErrTokenMissingGistScope       = errors.New("GitHub token is missing the gist scope")
+)
+
+// ErrReadOnly 表示只读的 GistSyncService 拒绝了会修改 Gist 的请求；请求没有发出
+var ErrReadOnly = errors.New("gist client is read-only")
+
+// ErrInvalidPayload 表示解密后的 Gist 内容不是预期的 agent 配置 JSON；拉取会在写入任何 agent 之前中止
+var ErrInvalidPayload = errors.New("gist payload is not a valid sync config")
+
 type GistUpdateRequest struct {
 	Files map[string]map[string]string `json:"files"`
 }
@@ -108,7 +243,7 @@ func (gs *GistSyncService) PushToGist(servers []models.MCPServer) error {
 
 	// Encryption is mandatory for Gist sync
 	if !gs.encryptionEnabled || gs.securityMgr == nil {
-		return fmt.Errorf("encryption is required for Gist synchronization. Please set an encryption password")
+		return fmt.Errorf("%w. Please set an encryption password", ErrEncryptionRequired)
 	}
 
 	// Prepare content
@@ -135,7 +270,7 @@ func (gs *GistSyncService) PushToGist(servers []models.MCPServer) error {
 	// Create update request
 	updateReq := GistUpdateRequest{
 		Files: map[string]map[string]string{
-			"mcp-config.json": {
+			gs.fileName: {
 				"content": contentStr,
 			},
 		},
@@ -146,7 +281,7 @@ func (gs *GistSyncService) PushToGist(servers []models.MCPServer) error {
 		return err
 	}
 
-	url := fmt.Sprintf("https://api.github.com/gists/%s", gs.gistID)
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
 	req, err := http.NewRequest("PATCH", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return err
@@ -156,15 +291,14 @@ func (gs *GistSyncService) PushToGist(servers []models.MCPServer) error {
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := gs.client.Do(req)
+	resp, err := gs.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("gist update failed: %d - %s", resp.StatusCode, string(body))
+		return newAPIError("gist update", resp)
 	}
 
 	return nil
@@ -175,7 +309,7 @@ func (gs *GistSyncService) PullFromGist() ([]models.MCPServer, error) {
 		return nil, fmt.Errorf("gist ID or GitHub token not configured")
 	}
 
-	url := fmt.Sprintf("https://api.github.com/gists/%s", gs.gistID)
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -184,15 +318,14 @@ func (gs *GistSyncService) PullFromGist() ([]models.MCPServer, error) {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := gs.client.Do(req)
+	resp, err := gs.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("gist fetch failed: %d - %s", resp.StatusCode, string(body))
+		return nil, newAPIError("gist fetch", resp)
 	}
 
 	var gistResp GistResponse
@@ -200,10 +333,10 @@ func (gs *GistSyncService) PullFromGist() ([]models.MCPServer, error) {
 		return nil, err
 	}
 
-	// Parse mcp-config.json
-	configFile, exists := gistResp.Files["mcp-config.json"]
-	if !exists {
-		return nil, fmt.Errorf("mcp-config.json not found in gist")
+	// A gist that was never pushed to has nothing to pull
+	configFile, exists := gistResp.Files[gs.fileName]
+	if !exists || strings.TrimSpace(configFile.Content) == "" {
+		return []models.MCPServer{}, nil
 	}
 
 	contentStr := configFile.Content
@@ -215,7 +348,7 @@ func (gs *GistSyncService) PullFromGist() ([]models.MCPServer, error) {
 		// Content is likely encrypted, try to decrypt
 		decrypted, err := gs.securityMgr.Decrypt(contentStr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt configuration: %w (check encryption password)", err)
+			return nil, fmt.Errorf("%w configuration: %w (check encryption password)", ErrDecryptFailed, err)
 		}
 		contentStr = decrypted
 		println("Configuration decrypted after pulling from Gist")
@@ -230,6 +363,10 @@ func (gs *GistSyncService) PullFromGist() ([]models.MCPServer, error) {
 		return nil, err
 	}
 
+	if data.Servers == nil {
+		return []models.MCPServer{}, nil
+	}
+
 	return data.Servers, nil
 }
 
@@ -246,12 +383,21 @@ func (gs *GistSyncService) CreateGist(servers []models.MCPServer, description st
 		return "", err
 	}
 
+	return gs.CreateGistWithContent(string(content), description)
+}
+
+// CreateGistWithContent 使用给定的原始内容（可能已加密）创建新的私有 Gist
+func (gs *GistSyncService) CreateGistWithContent(content, description string) (string, error) {
+	if gs.githubToken == "" {
+		return "", fmt.Errorf("GitHub token not configured")
+	}
+
 	createReq := map[string]interface{}{
 		"description": description,
 		"public":      false,
 		"files": map[string]map[string]string{
-			"mcp-config.json": {
-				"content": string(content),
+			gs.fileName: {
+				"content": content,
 			},
 		},
 	}
@@ -261,7 +407,7 @@ func (gs *GistSyncService) CreateGist(servers []models.MCPServer, description st
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.github.com/gists", bytes.NewReader(reqBody))
+	req, err := http.NewRequest("POST", gs.apiBaseURL+"/gists", bytes.NewReader(reqBody))
 	if err != nil {
 		return "", err
 	}
@@ -270,15 +416,14 @@ func (gs *GistSyncService) CreateGist(servers []models.MCPServer, description st
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := gs.client.Do(req)
+	resp, err := gs.do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("gist creation failed: %d - %s", resp.StatusCode, string(body))
+		return "", newAPIError("gist creation", resp)
 	}
 
 	var gistResp GistResponse
@@ -289,12 +434,275 @@ func (gs *GistSyncService) CreateGist(servers []models.MCPServer, description st
 	return gistResp.ID, nil
 }
 
+// CreateGistOnce 与 CreateGist 相同，但创建是幂等的，见 CreateGistWithContentOnce
+func (gs *GistSyncService) CreateGistOnce(requestID string, servers []models.MCPServer, description string) (string, error) {
+	content, err := json.MarshalIndent(map[string]interface{}{
+		"servers":   servers,
+		"timestamp": time.Now().Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return gs.CreateGistWithContentOnce(requestID, string(content), description)
+}
+
+// CreateGistWithContentOnce 与 CreateGistWithContent 相同，但在描述中标记客户端请求 ID，创建前先查找已带有该标记的 Gist。
+// 创建请求其实已成功、只是响应丢失时，用同一个 requestID 重试会返回已创建的 Gist，而不会再建一个
+func (gs *GistSyncService) CreateGistWithContentOnce(requestID, content, description string) (string, error) {
+	if requestID == "" {
+		return gs.CreateGistWithContent(content, description)
+	}
+
+	tag := gistRequestTag(requestID)
+	existing, err := gs.findGistByDescription(tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to check for an already created gist: %w", err)
+	}
+	if existing != "" {
+		println(fmt.Sprintf("Reusing gist %s created by an earlier attempt", existing))
+		return existing, nil
+	}
+	return gs.CreateGistWithContent(content, description+" "+tag)
+}
+
+// gistRequestTag 返回写入 Gist 描述中的客户端请求 ID 标记
+func gistRequestTag(requestID string) string {
+	return "[mcp-sync:" + requestID + "]"
+}
+
+// findGistByDescription 在当前用户的 Gist 中查找描述包含 marker 的 Gist，没有时返回空字符串
+func (gs *GistSyncService) findGistByDescription(marker string) (string, error) {
+	const perPage = 100
+	const maxPages = 10
+
+	for page := 1; page <= maxPages; page++ {
+		url := fmt.Sprintf("%s/gists?per_page=%d&page=%d", gs.apiBaseURL, perPage, page)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		resp, err := gs.do(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return "", newAPIError("gist list", resp)
+		}
+		var gists []GistResponse
+		err = json.NewDecoder(resp.Body).Decode(&gists)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		for _, gist := range gists {
+			if strings.Contains(gist.Description, marker) {
+				return gist.ID, nil
+			}
+		}
+		if len(gists) < perPage {
+			break
+		}
+	}
+	return "", nil
+}
+
+// FetchRawContent 获取同步配置文件的原始内容（不解密）
+func (gs *GistSyncService) FetchRawContent() (string, error) {
+	if gs.gistID == "" || gs.githubToken == "" {
+		return "", fmt.Errorf("gist ID or GitHub token not configured")
+	}
+
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError("gist fetch", resp)
+	}
+
+	var gistResp GistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gistResp); err != nil {
+		return "", err
+	}
+
+	return gistResp.Files[gs.fileName].Content, nil
+}
+
+// DeleteGist 删除远程 Gist（已删除的 Gist 视为成功）
+func (gs *GistSyncService) DeleteGist() error {
+	if gs.gistID == "" || gs.githubToken == "" {
+		return fmt.Errorf("gist ID or GitHub token not configured")
+	}
+
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// An already-deleted gist is treated as success
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return newAPIError("gist deletion", resp)
+	}
+
+	return nil
+}
+
+// WithCredentials 返回使用新 token/Gist ID 的副本，保留加密设置
+func (gs *GistSyncService) WithCredentials(githubToken, gistID string) *GistSyncService {
+	clone := *gs
+	clone.githubToken = githubToken
+	clone.gistID = gistID
+	return &clone
+}
+
+// ReadOnly 返回只读副本：PATCH、POST、DELETE 等会修改 Gist 的请求直接返回 ErrReadOnly，不会发出。
+// 预演和冲突检测这类只应读取远程的路径使用它，与正常同步共用同一个客户端的配置
+func (gs *GistSyncService) ReadOnly() *GistSyncService {
+	clone := *gs
+	clone.readOnly = true
+	return &clone
+}
+
+// IsReadOnly 返回是否为只读副本
+func (gs *GistSyncService) IsReadOnly() bool {
+	return gs.readOnly
+}
+
+// do 发送 req；只读模式下只允许 GET 和 HEAD
+func (gs *GistSyncService) do(req *http.Request) (*http.Response, error) {
+	if gs.readOnly && req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("%w: refusing %s %s", ErrReadOnly, req.Method, req.URL.Path)
+	}
+	return gs.client.Do(req)
+}
+
+// ValidateGist 检查 Gist 是否存在、token 是否可访问、是否属于当前用户，以及是否包含同步配置文件（或为空）
+func (gs *GistSyncService) ValidateGist() error {
+	if gs.gistID == "" || gs.githubToken == "" {
+		return fmt.Errorf("gist ID or GitHub token not configured")
+	}
+
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrGistNotFound, gs.gistID)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		if classifyStatus(resp) == ErrRateLimited {
+			return newAPIError("gist fetch", resp)
+		}
+		return fmt.Errorf("%w: %s", ErrGistNoAccess, gs.gistID)
+	default:
+		return newAPIError("gist fetch", resp)
+	}
+
+	var gistResp GistResponse
+	if err := json.NewDecoder(resp.Body).Decode(&gistResp); err != nil {
+		return err
+	}
+
+	// Secret gists are readable by anyone with the ID, but only the owner can update them
+	if gistResp.Owner != nil && gistResp.Owner.Login != "" {
+		login, err := gs.currentUser()
+		if err != nil {
+			return err
+		}
+		if login != gistResp.Owner.Login {
+			return fmt.Errorf("%w: owned by %s, token belongs to %s", ErrGistWrongOwner, gistResp.Owner.Login, login)
+		}
+	}
+
+	if _, exists := gistResp.Files[gs.fileName]; !exists && len(gistResp.Files) > 0 {
+		return fmt.Errorf("%w: %s", ErrGistUnexpectedContent, gs.fileName)
+	}
+
+	return nil
+}
+
+// currentUser 返回 token 对应的 GitHub 用户名
+func (gs *GistSyncService) currentUser() (string, error) {
+	req, err := http.NewRequest("GET", gs.apiBaseURL+"/user", nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", tokenError(resp)
+	}
+
+	var user GistOwner
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return "", err
+	}
+
+	return user.Login, nil
+}
+
+// tokenError 把 /user 请求的失败响应转换为错误；401/403 仍报告为无效 token
+func tokenError(resp *http.Response) error {
+	err := newAPIError("token validation", resp)
+	if errors.Is(err, ErrUnauthorized) {
+		return fmt.Errorf("invalid GitHub token: %w", err)
+	}
+	return err
+}
+
+// ValidateToken 只检查 token 能否通过 GitHub 认证，不检查 gist 权限（见 ValidateTokenScopes）
 func (gs *GistSyncService) ValidateToken() error {
 	if gs.githubToken == "" {
 		return fmt.Errorf("GitHub token not configured")
 	}
 
-	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
+	req, err := http.NewRequest("GET", gs.apiBaseURL+"/user", nil)
 	if err != nil {
 		return err
 	}
@@ -302,36 +710,149 @@ func (gs *GistSyncService) ValidateToken() error {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := gs.client.Do(req)
+	resp, err := gs.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("invalid GitHub token")
+		return tokenError(resp)
 	}
 
 	return nil
 }
 
+// ValidateTokenScopes 检查 token 是否有效且具有 gist 权限
+//
+// Supported token types (all sent as "Authorization: Bearer"):
+//   - classic personal access tokens (ghp_) with the gist scope, reported in X-OAuth-Scopes
+//   - fine-grained personal access tokens (github_pat_) with the "Gists" read and write account permission
+//   - GitHub App user access tokens (ghu_) whose app has the "Gists" permission
+//
+// Fine-grained and GitHub App tokens carry no X-OAuth-Scopes header, so gist access is probed directly.
+// GitHub App installation tokens (ghs_) cannot own gists and are not supported.
+func (gs *GistSyncService) ValidateTokenScopes() error {
+	if gs.githubToken == "" {
+		return fmt.Errorf("GitHub token not configured")
+	}
+
+	req, err := http.NewRequest("GET", gs.apiBaseURL+"/user", nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return tokenError(resp)
+	}
+
+	scopesHeader, present := resp.Header["X-Oauth-Scopes"]
+	if !present {
+		return gs.probeGistAccess()
+	}
+	for _, scope := range strings.Split(strings.Join(scopesHeader, ","), ",") {
+		if strings.TrimSpace(scope) == "gist" {
+			return nil
+		}
+	}
+	return ErrTokenMissingGistScope
+}
+
+// probeGistAccess 通过列出 gist 检查没有 scope 头的 token 是否具有 gist 权限
+func (gs *GistSyncService) probeGistAccess() error {
+	req, err := http.NewRequest("GET", gs.apiBaseURL+"/gists?per_page=1", nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	apiErr := newAPIError("gist access check", resp)
+	// A token without the Gists permission is refused with 403 (or 404 for some token types)
+	if resp.StatusCode == http.StatusNotFound || (resp.StatusCode == http.StatusForbidden && !errors.Is(apiErr, ErrRateLimited)) {
+		return fmt.Errorf("%w: grant the token the Gists account permission (%v)", ErrTokenMissingGistScope, apiErr)
+	}
+	return apiErr
+}
+
 // PushAgentConfigsToGist 推送完整的 agent 配置到 Gist（保留完整信息）
 func (gs *GistSyncService) PushAgentConfigsToGist(agentConfigs map[string]interface{}) error {
+	return gs.PushAgentConfigsIfUnchanged(agentConfigs, "")
+}
+
+// PushAgentConfigsIfUnchanged 与 PushAgentConfigsToGist 相同，但 revision 不为空时只在 Gist 仍是该版本（ETag）时推送，
+// 否则返回 ErrConflict。先用条件 GET 确认，再在 PATCH 中带上 If-Match
+func (gs *GistSyncService) PushAgentConfigsIfUnchanged(agentConfigs map[string]interface{}, revision string) error {
 	if gs.gistID == "" || gs.githubToken == "" {
 		return fmt.Errorf("gist ID or GitHub token not configured")
 	}
 
 	// Encryption is mandatory for Gist sync
 	if !gs.encryptionEnabled || gs.securityMgr == nil {
-		return fmt.Errorf("encryption is required for Gist synchronization. Please set an encryption password")
+		return fmt.Errorf("%w. Please set an encryption password", ErrEncryptionRequired)
 	}
 
+	// agentConfigs is this machine's view. Every environment's overlay stays in the gist, and the
+	// base keeps its own values where this machine's overlay replaces them
+	current, err := gs.currentPayloadForPush()
+	if err != nil {
+		return err
+	}
+	agents := agentConfigs
+	if overlay, ok := current.Overlays[gs.environment]; ok && gs.environment != "" {
+		agents = removeOverlay(agentConfigs, overlay, current.Agents)
+	}
+	return gs.writePayload(agents, current.Overlays, revision)
+}
+
+// currentPayloadForPush 读取 Gist 中现有的基础配置和覆盖层。内容无法解密或解析时返回空内容，
+// 推送会整体替换它
+func (gs *GistSyncService) currentPayloadForPush() (*syncPayload, error) {
+	content, _, err := gs.fetchConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if content == "" {
+		return &syncPayload{}, nil
+	}
+	payload, err := gs.decodePayload(content)
+	if errors.Is(err, ErrDecryptFailed) || errors.Is(err, ErrInvalidPayload) {
+		println(fmt.Sprintf("Warning: existing gist content is unreadable and will be replaced: %v", err))
+		return &syncPayload{}, nil
+	}
+	return payload, err
+}
+
+// writePayload 加密并写入基础配置和覆盖层；revision 不为空时只在 Gist 仍是该版本时写入
+func (gs *GistSyncService) writePayload(agentConfigs map[string]interface{}, overlays map[string]map[string]interface{}, revision string) error {
 	// Prepare content
 	data := map[string]interface{}{
 		"agents":    agentConfigs,
 		"timestamp": time.Now().Format(time.RFC3339),
 		"encrypted": true,
 	}
+	if len(overlays) > 0 {
+		data["overlays"] = overlays
+	}
 
 	content, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -350,7 +871,7 @@ func (gs *GistSyncService) PushAgentConfigsToGist(agentConfigs map[string]interf
 	// Create update request
 	updateReq := GistUpdateRequest{
 		Files: map[string]map[string]string{
-			"mcp-config.json": {
+			gs.fileName: {
 				"content": contentStr,
 			},
 		},
@@ -361,7 +882,7 @@ func (gs *GistSyncService) PushAgentConfigsToGist(agentConfigs map[string]interf
 		return err
 	}
 
-	url := fmt.Sprintf("https://api.github.com/gists/%s", gs.gistID)
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
 	req, err := http.NewRequest("PATCH", url, bytes.NewReader(reqBody))
 	if err != nil {
 		return err
@@ -371,95 +892,299 @@ func (gs *GistSyncService) PushAgentConfigsToGist(agentConfigs map[string]interf
 	req.Header.Set("Accept", "application/vnd.github+json")
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := gs.client.Do(req)
+	if revision != "" {
+		if err := gs.checkRevision(revision); err != nil {
+			return err
+		}
+		req.Header.Set("If-Match", revision)
+	}
+
+	resp, err := gs.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("gist update failed: %d - %s", resp.StatusCode, string(body))
+		return newAPIError("gist update", resp)
 	}
 
 	return nil
 }
 
+// prepareReencrypt 用当前密钥读取并解密同步文件（基础配置和所有覆盖层），返回用 newKey 重新加密并写回该内容的函数。
+// 写回时 Gist 已被修改则返回 ErrConflict；同步文件为空时返回的函数什么也不做
+func (gs *GistSyncService) prepareReencrypt() (func(newKey []byte) error, error) {
+	content, revision, err := gs.fetchConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if content == "" {
+		return func([]byte) error { return nil }, nil
+	}
+	payload, err := gs.decodePayload(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(newKey []byte) error {
+		rekeyed := gs.WithCredentials(gs.githubToken, gs.gistID)
+		rekeyed.securityMgr = &SecurityManagerAdapter{crypto: &SecureCrypto{keyring: &memoryKeyring{key: newKey}}}
+		return rekeyed.writePayload(payload.Agents, payload.Overlays, revision)
+	}, nil
+}
+
+// usesKeyringKey 返回 Gist 是否用密钥环中的主密钥加密（没有设置 Gist 加密密码）
+func (gs *GistSyncService) usesKeyringKey() bool {
+	return gs.IsEncryptionEnabled() && gs.encryptionKey == "system-stored-key"
+}
+
+// checkRevision 用条件 GET 确认 Gist 仍是 revision 版本；未变化时 GitHub 返回 304 且不计入速率限制
+func (gs *GistSyncService) checkRevision(revision string) error {
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("If-None-Match", revision)
+
+	resp, err := gs.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil
+	case http.StatusOK:
+		return fmt.Errorf("%w: the Gist changed since it was read, pull and try again", ErrConflict)
+	}
+	return newAPIError("gist revision check", resp)
+}
+
 // PullAgentConfigsFromGist 从 Gist 拉取完整的 agent 配置（保留完整信息）
 func (gs *GistSyncService) PullAgentConfigsFromGist() (map[string]interface{}, error) {
+	agentConfigs, _, err := gs.PullAgentConfigsWithRevision()
+	return agentConfigs, err
+}
+
+// PullAgentConfigsWithRevision 与 PullAgentConfigsFromGist 相同，同时返回 Gist 的 ETag，
+// 供 PushAgentConfigsIfUnchanged 检测其间的远程修改
+func (gs *GistSyncService) PullAgentConfigsWithRevision() (map[string]interface{}, string, error) {
+	content, revision, err := gs.fetchConfigFile()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A gist that was never pushed to has nothing to pull
+	if content == "" {
+		return make(map[string]interface{}), revision, nil
+	}
+
+	agentConfigs, err := gs.decodeAgentPayload(content)
+	return agentConfigs, revision, err
+}
+
+// fetchConfigFile 返回 Gist 中同步文件的原始内容和 ETag；文件不存在或为空时内容为空字符串
+func (gs *GistSyncService) fetchConfigFile() (string, string, error) {
 	if gs.gistID == "" || gs.githubToken == "" {
-		return nil, fmt.Errorf("gist ID or GitHub token not configured")
+		return "", "", fmt.Errorf("gist ID or GitHub token not configured")
 	}
 
-	url := fmt.Sprintf("https://api.github.com/gists/%s", gs.gistID)
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
 	req.Header.Set("Accept", "application/vnd.github+json")
 
-	resp, err := gs.client.Do(req)
+	resp, err := gs.do(req)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("gist fetch failed: %d - %s", resp.StatusCode, string(body))
+		return "", "", newAPIError("gist fetch", resp)
 	}
 
 	var gistResp GistResponse
 	if err := json.NewDecoder(resp.Body).Decode(&gistResp); err != nil {
+		return "", "", err
+	}
+	revision := resp.Header.Get("ETag")
+
+	configFile, exists := gistResp.Files[gs.fileName]
+	if !exists || strings.TrimSpace(configFile.Content) == "" {
+		return "", revision, nil
+	}
+	return configFile.Content, revision, nil
+}
+
+// syncPayload 是同步文件解密后的内容：所有机器共用的 agent 基础配置，以及按环境名保存的覆盖层。
+// 覆盖层的结构与 agents 相同（agent ID -> 配置），按 JSON Merge Patch 的规则叠加在基础配置上
+type syncPayload struct {
+	Agents    map[string]interface{}            `json:"agents"`
+	Overlays  map[string]map[string]interface{} `json:"overlays,omitempty"`
+	Encrypted bool                              `json:"encrypted"`
+}
+
+// decodeAgentPayload 把 Gist 文件内容解密并解析为本机的 agent 配置（基础配置叠加本机的覆盖层）
+func (gs *GistSyncService) decodeAgentPayload(content string) (map[string]interface{}, error) {
+	payload, err := gs.decodePayload(content)
+	if err != nil {
 		return nil, err
 	}
 
-	// Parse mcp-config.json
-	configFile, exists := gistResp.Files["mcp-config.json"]
-	if !exists {
-		return nil, fmt.Errorf("mcp-config.json not found in gist")
+	agents := payload.Agents
+	if overlay, ok := payload.Overlays[gs.environment]; ok && gs.environment != "" {
+		agents = applyOverlay(agents, overlay)
 	}
+	if err := checkAgentConfigLimits(gs.limits, agents); err != nil {
+		return nil, err
+	}
+	return agents, nil
+}
 
-	contentStr := configFile.Content
+// decodePayload 解密并解析同步文件，在返回前检查每个 agent 配置和覆盖层都是对象
+func (gs *GistSyncService) decodePayload(content string) (*syncPayload, error) {
+	plaintext, err := gs.payloadBytes(content)
+	if err != nil {
+		return nil, err
+	}
 
-	// Try to decrypt if encryption is detected
-	var dataMap map[string]interface{}
-	err = json.Unmarshal([]byte(contentStr), &dataMap)
-	if err != nil && gs.encryptionEnabled && gs.securityMgr != nil {
-		// Content is likely encrypted, try to decrypt
-		decrypted, err := gs.securityMgr.Decrypt(contentStr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt configuration: %w (check encryption password)", err)
+	var data syncPayload
+	// Unmarshal parses the buffer in place; a json.Decoder would copy it into its own buffer
+	if err := json.Unmarshal(plaintext, &data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	if data.Agents == nil {
+		data.Agents = make(map[string]interface{})
+	}
+	// Every entry is checked before returning, so a pull applies all agents or none
+	for agentID, config := range data.Agents {
+		if _, ok := config.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%w: config for agent %q is not an object", ErrInvalidPayload, agentID)
+		}
+	}
+	for name, overlay := range data.Overlays {
+		for agentID, patch := range overlay {
+			if _, ok := patch.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("%w: overlay %q for agent %q is not an object", ErrInvalidPayload, name, agentID)
+			}
 		}
-		contentStr = decrypted
-		println("Complete agent configurations decrypted after pulling from Gist")
 	}
 
-	var data struct {
-		Agents    map[string]interface{} `json:"agents"`
-		Encrypted bool                   `json:"encrypted"`
+	return &data, nil
+}
+
+// Overlays 返回 Gist 中保存的所有环境覆盖层
+func (gs *GistSyncService) Overlays() (map[string]map[string]interface{}, error) {
+	content, _, err := gs.fetchConfigFile()
+	if err != nil || content == "" {
+		return map[string]map[string]interface{}{}, err
 	}
+	payload, err := gs.decodePayload(content)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Overlays == nil {
+		return map[string]map[string]interface{}{}, nil
+	}
+	return payload.Overlays, nil
+}
 
-	if err := json.Unmarshal([]byte(contentStr), &data); err != nil {
+// SetOverlay 保存环境 name 的覆盖层，基础配置和其他覆盖层保持不变；overlay 为空时删除该覆盖层
+func (gs *GistSyncService) SetOverlay(name string, overlay map[string]interface{}) error {
+	if !gs.encryptionEnabled || gs.securityMgr == nil {
+		return fmt.Errorf("%w. Please set an encryption password", ErrEncryptionRequired)
+	}
+
+	content, revision, err := gs.fetchConfigFile()
+	if err != nil {
+		return err
+	}
+	payload := &syncPayload{}
+	if content != "" {
+		if payload, err = gs.decodePayload(content); err != nil {
+			return err
+		}
+	}
+
+	if payload.Overlays == nil {
+		payload.Overlays = make(map[string]map[string]interface{})
+	}
+	if len(overlay) == 0 {
+		delete(payload.Overlays, name)
+	} else {
+		payload.Overlays[name] = overlay
+	}
+	if payload.Agents == nil {
+		payload.Agents = make(map[string]interface{})
+	}
+	// Only send the update if nobody pushed in between, otherwise their changes would be lost
+	return gs.writePayload(payload.Agents, payload.Overlays, revision)
+}
+
+// payloadBytes returns the plaintext of a gist payload and enforces the size limits. Encrypted
+// content is decrypted into a single reused buffer, so the payload is never held as both a
+// decrypted string and a byte copy of it.
+func (gs *GistSyncService) payloadBytes(content string) ([]byte, error) {
+	if err := checkPayloadSize(gs.limits, len(content)); err != nil {
 		return nil, err
 	}
 
-	if data.Agents == nil {
-		return make(map[string]interface{}), nil
+	var plaintext []byte
+	if strings.HasPrefix(strings.TrimSpace(content), "{") || !gs.IsEncryptionEnabled() {
+		plaintext = []byte(content)
+	} else {
+		var err error
+		if decrypter, ok := gs.securityMgr.(BytesDecrypter); ok {
+			plaintext, err = decrypter.DecryptBytes(content)
+		} else {
+			var decrypted string
+			decrypted, err = gs.securityMgr.Decrypt(content)
+			plaintext = []byte(decrypted)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w configuration: %w (check encryption password)", ErrDecryptFailed, err)
+		}
 	}
 
-	return data.Agents, nil
+	if err := checkPayloadLimits(gs.limits, plaintext); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
 }
 
-// GetLatestVersion 从 Gist 获取最新的配置版本
+// GetLatestVersion 从 Gist 获取最新的配置版本；Gist 中尚无 agent 配置时返回 nil, nil。
+// 远程未变化时（ETag 命中或文件内容校验和相同）直接返回缓存的版本，不再解密
 func (gs *GistSyncService) GetLatestVersion() (*models.ConfigVersion, error) {
+	return gs.FetchLatestVersion(true)
+}
+
+// FetchLatestVersion 与 GetLatestVersion 相同；useCache 为 false 时忽略缓存，总是重新下载和解密（用于强制操作）
+func (gs *GistSyncService) FetchLatestVersion(useCache bool) (*models.ConfigVersion, error) {
 	if gs.gistID == "" || gs.githubToken == "" {
 		return nil, fmt.Errorf("gist ID or GitHub token not configured")
 	}
 
-	url := fmt.Sprintf("https://api.github.com/gists/%s", gs.gistID)
+	cacheKey := gs.versionCacheKey()
+	var cachedETag, cachedHash string
+	var cached *models.ConfigVersion
+	var hasCached bool
+	if useCache {
+		cachedETag, cachedHash, cached, hasCached = gs.versionCache.lookup(cacheKey)
+	}
+
+	url := fmt.Sprintf("%s/gists/%s", gs.apiBaseURL, gs.gistID)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -467,52 +1192,94 @@ func (gs *GistSyncService) GetLatestVersion() (*models.ConfigVersion, error) {
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.githubToken))
 	req.Header.Set("Accept", "application/vnd.github+json")
+	if hasCached && cachedETag != "" {
+		req.Header.Set("If-None-Match", cachedETag)
+	}
 
-	resp, err := gs.client.Do(req)
+	resp, err := gs.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && hasCached {
+		return cached, nil
+	}
+
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("gist not found")
+		return nil, fmt.Errorf("%w: %s", ErrGistNotFound, gs.gistID)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("gist fetch failed: %d - %s", resp.StatusCode, string(body))
+		return nil, newAPIError("gist fetch", resp)
 	}
 
 	var gistResp GistResponse
 	if err := json.NewDecoder(resp.Body).Decode(&gistResp); err != nil {
 		return nil, err
 	}
+	etag := resp.Header.Get("ETag")
 
-	// Parse mcp-config.json
-	configFile, exists := gistResp.Files["mcp-config.json"]
-	if !exists {
-		return nil, fmt.Errorf("mcp-config.json not found in gist")
+	// An empty or never-pushed gist has no remote version
+	configFile, exists := gistResp.Files[gs.fileName]
+	if !exists || strings.TrimSpace(configFile.Content) == "" {
+		gs.versionCache.store(cacheKey, etag, "", nil)
+		return nil, nil
 	}
 
-	contentStr := configFile.Content
+	// Other files or metadata may change the ETag without touching the sync file
+	rawSum := sha256.Sum256([]byte(configFile.Content))
+	rawHash := hex.EncodeToString(rawSum[:])
+	if hasCached && rawHash == cachedHash {
+		gs.versionCache.store(cacheKey, etag, rawHash, cached)
+		return cached, nil
+	}
 
-	// Try to decrypt if encryption is enabled
-	var dataMap map[string]interface{}
-	err = json.Unmarshal([]byte(contentStr), &dataMap)
-	if err != nil && gs.encryptionEnabled && gs.securityMgr != nil {
-		// Content is likely encrypted, try to decrypt
-		decrypted, err := gs.securityMgr.Decrypt(contentStr)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decrypt configuration: %w", err)
-		}
-		contentStr = decrypted
+	version, err := gs.versionFromContent(gistResp.ID, configFile.Content)
+	if err != nil {
+		return nil, err
+	}
+	gs.versionCache.store(cacheKey, etag, rawHash, version)
+	return version, nil
+}
+
+// versionCacheKey 标识缓存条目适用的 Gist、文件、环境和加密密钥
+func (gs *GistSyncService) versionCacheKey() string {
+	keySum := sha256.Sum256([]byte(gs.encryptionKey))
+	return fmt.Sprintf("%s/%s/%s/%t/%s", gs.gistID, gs.fileName, gs.environment, gs.encryptionEnabled, hex.EncodeToString(keySum[:]))
+}
+
+// versionFromContent 解密并解析同步文件的内容
+func (gs *GistSyncService) versionFromContent(gistID, content string) (*models.ConfigVersion, error) {
+	plaintext, err := gs.payloadBytes(content)
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse timestamp from content
 	var data struct {
-		Timestamp string `json:"timestamp"`
+		Timestamp string                            `json:"timestamp"`
+		Agents    map[string]interface{}            `json:"agents"`
+		Overlays  map[string]map[string]interface{} `json:"overlays"`
+	}
+	json.Unmarshal(plaintext, &data)
+
+	// The version shows this machine's view, the same configs a pull applies
+	if overlay, ok := data.Overlays[gs.environment]; ok && gs.environment != "" {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(plaintext, &raw); err == nil {
+			data.Agents = applyOverlay(data.Agents, overlay)
+			raw["agents"] = data.Agents
+			if view, err := json.MarshalIndent(raw, "", "  "); err == nil {
+				plaintext = view
+			}
+		}
+	}
+
+	// A freshly created gist (or one holding only the legacy servers key) carries no agent configs yet
+	if len(data.Agents) == 0 {
+		return nil, nil
 	}
-	json.Unmarshal([]byte(contentStr), &data)
 
 	timestamp := time.Now()
 	if data.Timestamp != "" {
@@ -521,14 +1288,13 @@ func (gs *GistSyncService) GetLatestVersion() (*models.ConfigVersion, error) {
 		}
 	}
 
-	// Calculate hash of content
-	hash := sha256.Sum256([]byte(contentStr))
-	hashStr := hex.EncodeToString(hash[:])
+	// Hash the agent configs semantically so a reformatted but identical config is not a conflict
+	hashStr := canonicalConfigHash(string(plaintext))
 
 	return &models.ConfigVersion{
-		ID:        gistResp.ID,
+		ID:        gistID,
 		Timestamp: timestamp,
-		Content:   contentStr,
+		Content:   string(plaintext),
 		Source:    "gist",
 		Note:      "Latest version from Gist",
 		Hash:      hashStr,
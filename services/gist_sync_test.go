@@ -0,0 +1,902 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubGist is an in-memory gist held by stubGistServer
+type stubGist struct {
+	ID          string
+	Owner       string
+	Description string
+	Files       map[string]string
+	UpdatedAt   time.Time
+}
+
+// etag changes whenever the gist is updated
+func (g *stubGist) etag() string {
+	return fmt.Sprintf(`"%d"`, g.UpdatedAt.Unix())
+}
+
+// stubGistServer is an httptest-backed fake of the GitHub Gist API
+type stubGistServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	gists    map[string]*stubGist
+	users    map[string]string // token -> login
+	scopes   map[string]string // token -> X-OAuth-Scopes
+	noGists  map[string]bool   // fine-grained tokens without the Gists permission
+	nextID   int
+	lastAuth string
+	requests []string
+	// dropNextCreate makes the next gist creation succeed but lose its response, like a network failure
+	dropNextCreate bool
+	// failures are statuses returned, in order, to the next requests instead of handling them
+	failures []int
+}
+
+// newStubGistServer starts a fake Gist API and points new GistSyncService instances at it
+func newStubGistServer(t *testing.T) *stubGistServer {
+	t.Helper()
+
+	s := &stubGistServer{
+		gists:   make(map[string]*stubGist),
+		users:   make(map[string]string),
+		scopes:  make(map[string]string),
+		noGists: make(map[string]bool),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+
+	previous := githubAPIBaseURL
+	githubAPIBaseURL = s.URL
+	t.Cleanup(func() { githubAPIBaseURL = previous })
+
+	return s
+}
+
+func (s *stubGistServer) addUser(token, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[token] = login
+}
+
+// revokeToken makes the server reject token, as GitHub does for expired or revoked tokens
+func (s *stubGistServer) revokeToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, token)
+}
+
+// addClassicToken registers a classic PAT that reports its scopes
+func (s *stubGistServer) addClassicToken(token, login, scopes string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[token] = login
+	s.scopes[token] = scopes
+}
+
+// addFineGrainedToken registers a token that sends no X-OAuth-Scopes header, with or without gist access
+func (s *stubGistServer) addFineGrainedToken(token, login string, gistAccess bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[token] = login
+	s.noGists[token] = !gistAccess
+}
+
+// addGist creates a gist owned by owner with a single mcp-config.json file
+func (s *stubGistServer) addGist(owner, content string) string {
+	return s.addGistFiles(owner, map[string]string{"mcp-config.json": content})
+}
+
+func (s *stubGistServer) addGistFiles(owner string, files map[string]string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createLocked(owner, "", files)
+}
+
+func (s *stubGistServer) createLocked(owner, description string, files map[string]string) string {
+	s.nextID++
+	id := fmt.Sprintf("gist%03d", s.nextID)
+	s.gists[id] = &stubGist{
+		ID:          id,
+		Owner:       owner,
+		Description: description,
+		Files:       files,
+		UpdatedAt:   time.Date(2024, 1, 1, 0, 0, s.nextID, 0, time.UTC),
+	}
+	return id
+}
+
+func (s *stubGistServer) fileContent(gistID, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.gists[gistID]; ok {
+		return g.Files[name]
+	}
+	return ""
+}
+
+// writeFileForTest replaces mcp-config.json as if another machine had pushed
+func (s *stubGistServer) writeFileForTest(gistID, content string) {
+	s.writeNamedFileForTest(gistID, DefaultGistFileName, content)
+}
+
+// writeNamedFileForTest replaces one file of the gist as if another machine had pushed
+func (s *stubGistServer) writeNamedFileForTest(gistID, fileName, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.gists[gistID]
+	g.Files[fileName] = content
+	g.UpdatedAt = g.UpdatedAt.Add(time.Minute)
+}
+
+// touchForTest updates the gist without changing any file, as a description edit would
+func (s *stubGistServer) touchForTest(gistID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gists[gistID].UpdatedAt = s.gists[gistID].UpdatedAt.Add(time.Minute)
+}
+
+func (s *stubGistServer) hasGist(gistID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.gists[gistID]
+	return ok
+}
+
+func (s *stubGistServer) gistOwner(gistID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.gists[gistID]; ok {
+		return g.Owner
+	}
+	return ""
+}
+
+// failNext makes the next request fail with status, before authentication or routing
+func (s *stubGistServer) failNext(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, status)
+}
+
+func (s *stubGistServer) requestCount(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, method+" ") {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *stubGistServer) writeGist(w http.ResponseWriter, status int, g *stubGist) {
+	files := make(map[string]map[string]string)
+	for name, content := range g.Files {
+		files[name] = map[string]string{"filename": name, "content": content}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", g.etag())
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":          g.ID,
+		"description": g.Description,
+		"owner":       map[string]string{"login": g.Owner},
+		"files":       files,
+		"updated_at":  g.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+func (s *stubGistServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.lastAuth = r.Header.Get("Authorization")
+
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		http.Error(w, fmt.Sprintf(`{"message":%q}`, http.StatusText(status)), status)
+		return
+	}
+
+	token := strings.TrimPrefix(s.lastAuth, "Bearer ")
+	login, ok := s.users[token]
+	if !ok {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/user" && r.Method == http.MethodGet:
+		if scopes, classic := s.scopes[token]; classic {
+			w.Header().Set("X-OAuth-Scopes", scopes)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"login": login})
+
+	case r.URL.Path == "/gists" && r.Method == http.MethodGet:
+		if s.noGists[token] {
+			http.Error(w, `{"message":"Resource not accessible by personal access token"}`, http.StatusForbidden)
+			return
+		}
+		var list []map[string]string
+		for id, g := range s.gists {
+			if g.Owner == login {
+				list = append(list, map[string]string{"id": id, "description": g.Description})
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+
+	case r.URL.Path == "/gists" && r.Method == http.MethodPost:
+		var req struct {
+			Description string                       `json:"description"`
+			Files       map[string]map[string]string `json:"files"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `{"message":"Problems parsing JSON"}`, http.StatusBadRequest)
+			return
+		}
+		files := make(map[string]string)
+		for name, file := range req.Files {
+			files[name] = file["content"]
+		}
+		id := s.createLocked(login, req.Description, files)
+		if s.dropNextCreate {
+			s.dropNextCreate = false
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+			return
+		}
+		s.writeGist(w, http.StatusCreated, s.gists[id])
+
+	case strings.HasPrefix(r.URL.Path, "/gists/"):
+		id := strings.TrimPrefix(r.URL.Path, "/gists/")
+		g, exists := s.gists[id]
+		if !exists {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if r.Header.Get("If-None-Match") == g.etag() {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			s.writeGist(w, http.StatusOK, g)
+		case http.MethodPatch:
+			if g.Owner != login {
+				http.Error(w, `{"message":"Forbidden"}`, http.StatusForbidden)
+				return
+			}
+			if match := r.Header.Get("If-Match"); match != "" && match != g.etag() {
+				http.Error(w, `{"message":"Precondition Failed"}`, http.StatusPreconditionFailed)
+				return
+			}
+			var req struct {
+				Files map[string]map[string]string `json:"files"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, `{"message":"Problems parsing JSON"}`, http.StatusBadRequest)
+				return
+			}
+			for name, file := range req.Files {
+				if file == nil {
+					delete(g.Files, name)
+					continue
+				}
+				g.Files[name] = file["content"]
+			}
+			g.UpdatedAt = g.UpdatedAt.Add(time.Minute)
+			s.writeGist(w, http.StatusOK, g)
+		case http.MethodDelete:
+			if g.Owner != login {
+				http.Error(w, `{"message":"Forbidden"}`, http.StatusForbidden)
+				return
+			}
+			delete(s.gists, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, `{"message":"Method Not Allowed"}`, http.StatusMethodNotAllowed)
+		}
+
+	default:
+		http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+	}
+}
+
+// newTestGistSync creates a gist client with password-based encryption that never touches the keyring
+func newTestGistSync(token, gistID string) *GistSyncService {
+	gs := NewGistSyncService(token, gistID)
+	gs.encryptionEnabled = true
+	gs.securityMgr = NewSecurityManager("test-password")
+	return gs
+}
+
+// encryptForTest encrypts a gist payload the same way newTestGistSync does
+func encryptForTest(t *testing.T, plaintext string) string {
+	t.Helper()
+	encrypted, err := NewSecurityManager("test-password").Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("failed to encrypt test payload: %v", err)
+	}
+	return encrypted
+}
+
+// decryptForTest decrypts a gist payload produced by newTestGistSync
+func decryptForTest(t *testing.T, ciphertext string) string {
+	t.Helper()
+	decrypted, err := NewSecurityManager("test-password").Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("failed to decrypt gist payload: %v", err)
+	}
+	return decrypted
+}
+
+func TestValidateGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	server.addUser("token-b", "bob")
+	valid := server.addGist("alice", `{"servers": []}`)
+	empty := server.addGistFiles("alice", map[string]string{})
+	foreign := server.addGist("bob", `{"servers": []}`)
+	unrelated := server.addGistFiles("alice", map[string]string{"notes.md": "hello"})
+
+	tests := []struct {
+		name    string
+		token   string
+		gistID  string
+		wantErr error
+	}{
+		{name: "valid gist", token: "token-a", gistID: valid},
+		{name: "empty gist", token: "token-a", gistID: empty},
+		{name: "not found", token: "token-a", gistID: "missing", wantErr: ErrGistNotFound},
+		{name: "no access", token: "bad-token", gistID: valid, wantErr: ErrGistNoAccess},
+		{name: "wrong owner", token: "token-a", gistID: foreign, wantErr: ErrGistWrongOwner},
+		{name: "unexpected content", token: "token-a", gistID: unrelated, wantErr: ErrGistUnexpectedContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewGistSyncService(tt.token, tt.gistID).ValidateGist()
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Errorf("ValidateGist() error = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateGist() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", `{"servers": []}`)
+
+	gs := NewGistSyncService("token-a", gistID)
+	if err := gs.DeleteGist(); err != nil {
+		t.Fatalf("DeleteGist() error = %v", err)
+	}
+	if server.hasGist(gistID) {
+		t.Errorf("gist should be deleted")
+	}
+
+	// Deleting again is not an error
+	if err := gs.DeleteGist(); err != nil {
+		t.Errorf("DeleteGist() on an already-deleted gist error = %v", err)
+	}
+}
+
+func TestPullEmptyGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{name: "no config file", files: map[string]string{}},
+		{name: "blank config file", files: map[string]string{"mcp-config.json": " "}},
+		{name: "legacy servers only", files: map[string]string{"mcp-config.json": `{"servers": []}`}},
+		{name: "encrypted payload without agents", files: map[string]string{"mcp-config.json": encryptForTest(t, `{"servers": [], "encrypted": true}`)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGistSync("token-a", server.addGistFiles("alice", tt.files))
+
+			agents, err := gs.PullAgentConfigsFromGist()
+			if err != nil {
+				t.Fatalf("PullAgentConfigsFromGist() error = %v", err)
+			}
+			if len(agents) != 0 {
+				t.Errorf("PullAgentConfigsFromGist() = %v, want empty", agents)
+			}
+
+			version, err := gs.GetLatestVersion()
+			if err != nil || version != nil {
+				t.Errorf("GetLatestVersion() = %v, %v, want nil, nil", version, err)
+			}
+
+			servers, err := gs.PullFromGist()
+			if err != nil || len(servers) != 0 {
+				t.Errorf("PullFromGist() = %v, %v, want empty, nil", servers, err)
+			}
+		})
+	}
+}
+
+func TestCustomGistFileName(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGistFiles("alice", map[string]string{"mcp-config.json": "untouched"})
+
+	gs := newTestGistSync("token-a", gistID)
+	gs.SetFileName("work.json")
+	agents := map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"db": map[string]interface{}{"command": "db"}}},
+	}
+	if err := gs.PushAgentConfigsToGist(agents); err != nil {
+		t.Fatalf("PushAgentConfigsToGist() error = %v", err)
+	}
+
+	if got := server.fileContent(gistID, "mcp-config.json"); got != "untouched" {
+		t.Errorf("default file was modified: %q", got)
+	}
+	if !strings.Contains(decryptForTest(t, server.fileContent(gistID, "work.json")), `"db"`) {
+		t.Errorf("push did not write to the custom file")
+	}
+
+	pulled, err := gs.PullAgentConfigsFromGist()
+	if err != nil {
+		t.Fatalf("PullAgentConfigsFromGist() error = %v", err)
+	}
+	if _, ok := pulled["cursor"]; !ok {
+		t.Errorf("pull did not read the custom file: %v", pulled)
+	}
+	version, err := gs.GetLatestVersion()
+	if err != nil || version == nil {
+		t.Fatalf("GetLatestVersion() = %v, %v", version, err)
+	}
+	if err := gs.ValidateGist(); err != nil {
+		t.Errorf("ValidateGist() error = %v", err)
+	}
+
+	gs.SetFileName("")
+	if gs.FileName() != DefaultGistFileName {
+		t.Errorf("FileName() = %q, want default", gs.FileName())
+	}
+}
+
+func TestValidateTokenScopesTokenTypes(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addClassicToken("classic-gist", "alice", "gist, repo")
+	server.addClassicToken("classic-repo", "alice", "repo")
+	server.addFineGrainedToken("fine-gist", "alice", true)
+	server.addFineGrainedToken("fine-nogist", "alice", false)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "classic token with gist scope", token: "classic-gist"},
+		{name: "classic token missing gist scope", token: "classic-repo", wantErr: ErrTokenMissingGistScope},
+		{name: "fine-grained token with gist access", token: "fine-gist"},
+		{name: "fine-grained token without gist access", token: "fine-nogist", wantErr: ErrTokenMissingGistScope},
+		{name: "invalid token", token: "bogus", wantErr: ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := NewGistSyncService(tt.token, "")
+			err := gs.ValidateTokenScopes()
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("ValidateTokenScopes() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateTokenScopes() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A fine-grained token authenticates even without gist access; only the scope check rejects it
+	if err := NewGistSyncService("fine-nogist", "").ValidateToken(); err != nil {
+		t.Errorf("ValidateToken() error = %v, want nil for an authenticated token", err)
+	}
+}
+
+// legacyDecodeAgentPayload is the decode path used before payloadBytes: probe-parse the content,
+// decrypt to a string, then convert to bytes again for the final parse
+func legacyDecodeAgentPayload(gs *GistSyncService, contentStr string) (map[string]interface{}, error) {
+	var dataMap map[string]interface{}
+	err := json.Unmarshal([]byte(contentStr), &dataMap)
+	if err != nil && gs.encryptionEnabled && gs.securityMgr != nil {
+		decrypted, err := gs.securityMgr.Decrypt(contentStr)
+		if err != nil {
+			return nil, err
+		}
+		contentStr = decrypted
+	}
+	var data struct {
+		Agents map[string]interface{} `json:"agents"`
+	}
+	if err := json.Unmarshal([]byte(contentStr), &data); err != nil {
+		return nil, err
+	}
+	return data.Agents, nil
+}
+
+// largeEncryptedPayload builds an encrypted payload with many agents and servers
+func largeEncryptedPayload(b *testing.B) string {
+	agents := make(map[string]interface{})
+	for a := 0; a < 4; a++ {
+		servers := make(map[string]interface{})
+		for i := 0; i < 200; i++ {
+			servers[fmt.Sprintf("server-%d", i)] = map[string]interface{}{
+				"command": "npx",
+				"args":    []string{"-y", fmt.Sprintf("@scope/server-%d", i), "--verbose"},
+				"env":     map[string]string{"API_KEY": strings.Repeat("k", 40)},
+			}
+		}
+		agents[fmt.Sprintf("agent-%d", a)] = map[string]interface{}{"mcpServers": servers}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"agents": agents, "encrypted": true})
+	encrypted, err := NewSecurityManager("test-password").Encrypt(string(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return encrypted
+}
+
+func BenchmarkDecodeAgentPayload(b *testing.B) {
+	content := largeEncryptedPayload(b)
+	gs := newTestGistSync("token-a", "gist-1")
+
+	b.Run("legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := legacyDecodeAgentPayload(gs, content); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := gs.decodeAgentPayload(content); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// countingCrypto counts decryptions performed through the wrapped CryptoOperations
+type countingCrypto struct {
+	CryptoOperations
+	decrypts int
+}
+
+func (c *countingCrypto) Decrypt(ciphertext string) (string, error) {
+	c.decrypts++
+	return c.CryptoOperations.Decrypt(ciphertext)
+}
+
+func TestGetLatestVersionSkipsDecryptWhenUnchanged(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	payload := `{"agents": {"cursor": {"mcpServers": {"a": {"command": "a"}}}}, "timestamp": "2024-01-01T00:00:00Z"}`
+	gistID := server.addGist("alice", encryptForTest(t, payload))
+
+	gs := newTestGistSync("token-a", gistID)
+	crypto := &countingCrypto{CryptoOperations: gs.securityMgr}
+	gs.securityMgr = crypto
+
+	first, err := gs.GetLatestVersion()
+	if err != nil || first == nil {
+		t.Fatalf("GetLatestVersion() = %v, %v", first, err)
+	}
+
+	// Unchanged remote: the ETag matches and nothing is decrypted again
+	second, err := gs.GetLatestVersion()
+	if err != nil || second == nil || second.Hash != first.Hash {
+		t.Fatalf("cached GetLatestVersion() = %v, %v", second, err)
+	}
+	// Changed metadata but identical sync file: re-downloaded, but the checksum still matches
+	server.touchForTest(gistID)
+	if _, err := gs.WithCredentials("token-a", gistID).GetLatestVersion(); err != nil {
+		t.Fatal(err)
+	}
+	if crypto.decrypts != 1 {
+		t.Errorf("decrypts = %d after unchanged polls, want 1", crypto.decrypts)
+	}
+
+	// Changed remote: the new content is fetched and decrypted
+	server.writeFileForTest(gistID, encryptForTest(t, `{"agents": {"cursor": {"mcpServers": {"b": {"command": "b"}}}}}`))
+	changed, err := gs.GetLatestVersion()
+	if err != nil || changed == nil || changed.Hash == first.Hash || !strings.Contains(changed.Content, `"b"`) {
+		t.Fatalf("GetLatestVersion() after remote change = %+v, %v", changed, err)
+	}
+	if crypto.decrypts != 2 {
+		t.Errorf("decrypts = %d after a remote change, want 2", crypto.decrypts)
+	}
+
+	// Force operations bypass the cache
+	if _, err := gs.FetchLatestVersion(false); err != nil {
+		t.Fatal(err)
+	}
+	if crypto.decrypts != 3 {
+		t.Errorf("decrypts = %d after a bypassing fetch, want 3", crypto.decrypts)
+	}
+}
+
+func TestPushAgentConfigsIfUnchangedDetectsRemoteChange(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", encryptForTest(t, `{"agents": {"cursor": {"mcpServers": {}}}}`))
+	gs := newTestGistSync("token-a", gistID)
+
+	agents, revision, err := gs.PullAgentConfigsWithRevision()
+	if err != nil || revision == "" {
+		t.Fatalf("PullAgentConfigsWithRevision() = %v, %q, %v", agents, revision, err)
+	}
+
+	// Another machine pushes in between
+	other := encryptForTest(t, `{"agents": {"windsurf": {"mcpServers": {}}}}`)
+	server.writeFileForTest(gistID, other)
+
+	if err := gs.PushAgentConfigsIfUnchanged(agents, revision); !errors.Is(err, ErrConflict) {
+		t.Fatalf("PushAgentConfigsIfUnchanged() error = %v, want ErrConflict", err)
+	}
+	if server.fileContent(gistID, DefaultGistFileName) != other {
+		t.Errorf("the concurrent remote change was overwritten")
+	}
+
+	// A fresh revision succeeds
+	_, revision, _ = gs.PullAgentConfigsWithRevision()
+	if err := gs.PushAgentConfigsIfUnchanged(agents, revision); err != nil {
+		t.Errorf("PushAgentConfigsIfUnchanged() with current revision error = %v", err)
+	}
+}
+
+func TestReadOnlyGistSyncRefusesMutations(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	original := encryptForTest(t, `{"agents": {"cursor": {"mcpServers": {}}}}`)
+	gistID := server.addGist("alice", original)
+	gs := newTestGistSync("token-a", gistID)
+	readOnly := gs.ReadOnly()
+
+	// Reads still work
+	agents, revision, err := readOnly.PullAgentConfigsWithRevision()
+	if err != nil {
+		t.Fatalf("PullAgentConfigsWithRevision() in read-only mode error = %v", err)
+	}
+
+	mutations := map[string]func() error{
+		"PushAgentConfigsToGist":      func() error { return readOnly.PushAgentConfigsToGist(agents) },
+		"PushAgentConfigsIfUnchanged": func() error { return readOnly.PushAgentConfigsIfUnchanged(agents, revision) },
+		"PushToGist":                  func() error { return readOnly.PushToGist(nil) },
+		"CreateGistWithContent":       func() error { _, err := readOnly.CreateGistWithContent("{}", "preview"); return err },
+		"DeleteGist":                  readOnly.DeleteGist,
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s() in read-only mode error = %v, want ErrReadOnly", name, err)
+		}
+	}
+	for _, method := range []string{"PATCH", "POST", "DELETE"} {
+		if n := server.requestCount(method); n != 0 {
+			t.Errorf("read-only client sent %d %s requests", n, method)
+		}
+	}
+	if server.fileContent(gistID, DefaultGistFileName) != original || !server.hasGist(gistID) {
+		t.Error("read-only client modified the gist")
+	}
+
+	// The client it was copied from can still write
+	if gs.IsReadOnly() || !readOnly.IsReadOnly() {
+		t.Errorf("IsReadOnly() = %v for the original and %v for the copy, want false and true", gs.IsReadOnly(), readOnly.IsReadOnly())
+	}
+	if err := gs.PushAgentConfigsToGist(agents); err != nil {
+		t.Errorf("PushAgentConfigsToGist() on the original client error = %v", err)
+	}
+}
+
+func TestPreviewsOnlyReadTheGist(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{"fetch": map[string]interface{}{"command": "uvx"}}},
+	}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	if _, err := as.PlanSync("pull"); err != nil {
+		t.Errorf("PlanSync(pull) error = %v", err)
+	}
+	if _, err := as.DetectPushConflict(); err != nil {
+		t.Errorf("DetectPushConflict() error = %v", err)
+	}
+	if _, err := as.DetectPullConflict(); err != nil {
+		t.Errorf("DetectPullConflict() error = %v", err)
+	}
+	if _, err := as.StagePull(); err != nil {
+		t.Errorf("StagePull() error = %v", err)
+	}
+	for _, method := range []string{"PATCH", "POST", "DELETE"} {
+		if n := server.requestCount(method); n != 0 {
+			t.Errorf("previews sent %d %s requests", n, method)
+		}
+	}
+	if as.gistSync.IsReadOnly() {
+		t.Error("previews left the shared gist client read-only")
+	}
+}
+
+func TestGistPushPullRoundTripWithEncryption(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+
+	configs := map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{"command": "uvx", "args": []interface{}{"mcp-server-fetch"}, "env": map[string]interface{}{"LOG": "debug"}},
+		}},
+		"codex": map[string]interface{}{"mcp_servers": map[string]interface{}{}},
+	}
+	if err := newTestGistSync("token-a", gistID).PushAgentConfigsToGist(configs); err != nil {
+		t.Fatalf("PushAgentConfigsToGist() error = %v", err)
+	}
+	if server.lastAuth != "Bearer token-a" {
+		t.Errorf("Authorization header = %q, want the bearer token", server.lastAuth)
+	}
+
+	// The gist only ever holds ciphertext
+	stored := server.fileContent(gistID, "mcp-config.json")
+	if strings.Contains(stored, "mcp-server-fetch") {
+		t.Fatalf("pushed content is not encrypted: %s", stored)
+	}
+	if plain := decryptForTest(t, stored); !strings.Contains(plain, "mcp-server-fetch") {
+		t.Errorf("decrypted payload is missing the server: %s", plain)
+	}
+
+	// A fresh client with the same password reads back exactly what was pushed
+	pulled, err := newTestGistSync("token-a", gistID).PullAgentConfigsFromGist()
+	if err != nil {
+		t.Fatalf("PullAgentConfigsFromGist() error = %v", err)
+	}
+	if !jsonEqual(pulled, configs) {
+		t.Errorf("pulled configs = %v, want %v", pulled, configs)
+	}
+
+	wrong := NewGistSyncService("token-a", gistID)
+	wrong.encryptionEnabled = true
+	wrong.securityMgr = NewSecurityManager("wrong-password")
+	if _, err := wrong.PullAgentConfigsFromGist(); !errors.Is(err, ErrDecryptFailed) {
+		t.Errorf("PullAgentConfigsFromGist() with the wrong password error = %v, want ErrDecryptFailed", err)
+	}
+}
+
+func TestValidateTokenAgainstStubServer(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+
+	if err := NewGistSyncService("token-a", "").ValidateToken(); err != nil {
+		t.Errorf("ValidateToken() with a valid token error = %v", err)
+	}
+	err := NewGistSyncService("token-unknown", "").ValidateToken()
+	var apiErr *APIError
+	if !errors.Is(err, ErrUnauthorized) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("ValidateToken() with a bad token error = %v, want a 401 ErrUnauthorized", err)
+	}
+	if err := NewGistSyncService("", "").ValidateToken(); err == nil {
+		t.Error("ValidateToken() without a token returned no error")
+	}
+}
+
+func TestCreateGistAgainstStubServer(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+
+	gs := NewGistSyncService("token-a", "")
+	gistID, err := gs.CreateGistWithContent(`{"agents": {}}`, "MCP Sync config")
+	if err != nil {
+		t.Fatalf("CreateGistWithContent() error = %v", err)
+	}
+	if !server.hasGist(gistID) || server.gistOwner(gistID) != "alice" {
+		t.Fatalf("gist %q was not created for alice", gistID)
+	}
+	if content := server.fileContent(gistID, "mcp-config.json"); content != `{"agents": {}}` {
+		t.Errorf("created gist content = %q", content)
+	}
+	if server.requestCount("POST") != 1 {
+		t.Errorf("gist creation sent %d POST requests, want 1", server.requestCount("POST"))
+	}
+}
+
+func TestGistErrorStatuses(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+
+	tests := []struct {
+		name       string
+		inject     int // status injected for the next request; 0 uses the server's own response
+		op         func() error
+		wantStatus int
+		wantKind   error
+	}{
+		{
+			name:       "missing gist",
+			op:         func() error { _, err := newTestGistSync("token-a", "gist999").PullAgentConfigsFromGist(); return err },
+			wantStatus: http.StatusNotFound,
+			wantKind:   ErrNotFound,
+		},
+		{
+			name:       "validation failed on create",
+			inject:     http.StatusUnprocessableEntity,
+			op:         func() error { _, err := NewGistSyncService("token-a", "").CreateGistWithContent("{}", ""); return err },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "server error on pull",
+			inject:     http.StatusInternalServerError,
+			op:         func() error { _, err := newTestGistSync("token-a", gistID).PullAgentConfigsFromGist(); return err },
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:   "server error on push",
+			inject: http.StatusInternalServerError,
+			op: func() error {
+				return newTestGistSync("token-a", gistID).PushAgentConfigsToGist(map[string]interface{}{})
+			},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.inject != 0 {
+				server.failNext(tt.inject)
+			}
+			err := tt.op()
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+				t.Fatalf("error = %v, want an APIError with status %d", err, tt.wantStatus)
+			}
+			if !strings.Contains(apiErr.Body, http.StatusText(tt.wantStatus)) {
+				t.Errorf("APIError body = %q, want the server's message", apiErr.Body)
+			}
+			for _, kind := range []error{ErrUnauthorized, ErrNotFound, ErrRateLimited, ErrConflict} {
+				if errors.Is(err, kind) != (kind == tt.wantKind) {
+					t.Errorf("errors.Is(err, %v) = %v", kind, errors.Is(err, kind))
+				}
+			}
+		})
+	}
+
+	// Nothing was written by the failed operations
+	if server.fileContent(gistID, "mcp-config.json") != "" {
+		t.Errorf("failed push modified the gist")
+	}
+}
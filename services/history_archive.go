@@ -0,0 +1,355 @@
+package services
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"mcp-sync/models"
+)
+
+const (
+	// historyArchiveFormat 和 historyArchiveVersion 标识历史归档的 manifest，导入时校验
+	historyArchiveFormat  = "mcp-sync-history"
+	historyArchiveVersion = 1
+	// historyManifestName 是归档中的第一个条目
+	historyManifestName = "manifest.json"
+	// maxHistoryArchiveEntry 限制导入时单个条目的大小
+	maxHistoryArchiveEntry = 32 << 20
+)
+
+// historyArchiveDirs 是导出到历史归档中的数据目录子目录
+var historyArchiveDirs = []string{"versions", "logs"}
+
+// historyFileName 匹配版本和日志的文件名，导入时拒绝其他名称（包括 .. 和路径分隔符）
+var historyFileName = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.json$`)
+
+// ErrInvalidArchive 表示导入的历史归档格式不对、manifest 与内容不符或其中的文件无法解析；导入时不会写入任何文件
+var ErrInvalidArchive = errors.New("invalid history archive")
+
+// historyManifest 描述历史归档中的文件
+type historyManifest struct {
+	Format    string                `json:"format"`
+	Version   int                   `json:"version"`
+	CreatedAt time.Time             `json:"created_at"`
+	Encrypted bool                  `json:"encrypted"` // 文件保持导出时的加密状态，导入时用本机的密钥解密
+	Files     []historyManifestFile `json:"files"`
+}
+
+type historyManifestFile struct {
+	Path   string `json:"path"` // 例如 versions/version_1700000000.json
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ExportHistoryArchive 把 versions 和 logs 目录中的所有文件解密后写入 w，格式为带 manifest 的 gzip 压缩 tar 归档
+func (as *AppService) ExportHistoryArchive(w io.Writer) error {
+	return as.ExportHistoryArchiveWithOptions(w, models.HistoryArchiveOptions{Compress: true})
+}
+
+// ExportHistoryArchiveWithOptions 按 opts 把历史归档写入 w。解密导出时，任一文件无法解密都会返回错误，
+// 可以先用 CompactStorage 清理或改为保持加密导出
+func (as *AppService) ExportHistoryArchiveWithOptions(w io.Writer, opts models.HistoryArchiveOptions) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	manifest := historyManifest{
+		Format:    historyArchiveFormat,
+		Version:   historyArchiveVersion,
+		CreatedAt: nowTime(),
+		Encrypted: opts.KeepEncrypted,
+		Files:     []historyManifestFile{},
+	}
+	var contents [][]byte
+	for _, dir := range historyArchiveDirs {
+		names, err := as.storage.historyFileNames(dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			data, err := as.storage.fs.ReadFile(filepath.Join(as.storage.dataDir, dir, name))
+			if err != nil {
+				return err
+			}
+			if !opts.KeepEncrypted {
+				if data, err = as.storage.decryptIfNeeded(data); err != nil {
+					return fmt.Errorf("failed to decrypt %s/%s for export: %w", dir, name, err)
+				}
+			}
+			sum := sha256.Sum256(data)
+			manifest.Files = append(manifest.Files, historyManifestFile{
+				Path:   path.Join(dir, name),
+				Size:   int64(len(data)),
+				SHA256: hex.EncodeToString(sum[:]),
+			})
+			contents = append(contents, data)
+		}
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out := w
+	var gz *gzip.Writer
+	if opts.Compress {
+		gz = gzip.NewWriter(w)
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+	writeEntry := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: manifest.CreatedAt, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := writeEntry(historyManifestName, manifestData); err != nil {
+		return fmt.Errorf("failed to write history archive: %w", err)
+	}
+	for i, file := range manifest.Files {
+		if err := writeEntry(file.Path, contents[i]); err != nil {
+			return fmt.Errorf("failed to write history archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write history archive: %w", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to write history archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// ImportHistoryArchive 从 r 恢复 ExportHistoryArchive 写出的归档（压缩与否都可以）。先校验 manifest、
+// 每个文件的大小和 hash 以及版本和日志的 JSON 结构，全部通过后才写入；文件按本机当前的加密方式保存。
+// 数据目录中已有的同名文件保留不覆盖。完成后重建版本索引
+func (as *AppService) ImportHistoryArchive(r io.Reader) error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	manifest, files, err := readHistoryArchive(r)
+	if err != nil {
+		return err
+	}
+
+	// Decrypt and check every file before writing any of them
+	plaintexts := make(map[string][]byte, len(files))
+	for _, file := range manifest.Files {
+		data := files[file.Path]
+		if manifest.Encrypted {
+			if data, err = as.storage.decryptIfNeeded(data); err != nil {
+				return fmt.Errorf("%w: %s does not decrypt with this installation's key: %v", ErrInvalidArchive, file.Path, err)
+			}
+		}
+		if err := validateHistoryFile(file.Path, data); err != nil {
+			return err
+		}
+		plaintexts[file.Path] = data
+	}
+
+	imported := 0
+	for _, file := range manifest.Files {
+		target := filepath.Join(as.storage.dataDir, filepath.FromSlash(file.Path))
+		if as.storage.exists(target) {
+			continue
+		}
+		data, err := as.storage.encryptIfNeeded(plaintexts[file.Path])
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", file.Path, err)
+		}
+		if err := as.storage.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := as.storage.fs.WriteFile(target, data, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.Path, err)
+		}
+		imported++
+	}
+
+	if err := as.storage.rebuildVersionIndex(&models.CompactReport{}); err != nil {
+		println(fmt.Sprintf("Warning: failed to rebuild version index after import: %v", err))
+	}
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "import_history",
+		Status:    "success",
+		Message:   fmt.Sprintf("Imported %d of %d files from a history archive", imported, len(manifest.Files)),
+	})
+	return nil
+}
+
+// ExportHistoryArchiveFile 把历史归档写入文件 archivePath
+func (as *AppService) ExportHistoryArchiveFile(archivePath string, opts models.HistoryArchiveOptions) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	if err := as.ExportHistoryArchiveWithOptions(file, opts); err != nil {
+		file.Close()
+		os.Remove(archivePath)
+		return err
+	}
+	return file.Close()
+}
+
+// ImportHistoryArchiveFile 从文件 archivePath 导入历史归档
+func (as *AppService) ImportHistoryArchiveFile(archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return as.ImportHistoryArchive(file)
+}
+
+// historyFileNames 返回数据目录子目录 dir 中的版本或日志文件名
+func (s *StorageService) historyFileNames(dir string) ([]string, error) {
+	files, err := s.fs.ReadDir(filepath.Join(s.dataDir, dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() && historyFileName.MatchString(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// readHistoryArchive 读取归档，校验 manifest 以及文件与 manifest 一致，返回 manifest 和路径 -> 内容
+func readHistoryArchive(r io.Reader) (*historyManifest, map[string][]byte, error) {
+	br := bufio.NewReader(r)
+	var in io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	tr := tar.NewReader(in)
+	var manifest *historyManifest
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, header.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxHistoryArchiveEntry+1))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		if len(data) > maxHistoryArchiveEntry {
+			return nil, nil, fmt.Errorf("%w: %s is too large", ErrInvalidArchive, header.Name)
+		}
+
+		if manifest == nil {
+			if header.Name != historyManifestName {
+				return nil, nil, fmt.Errorf("%w: the first entry must be %s", ErrInvalidArchive, historyManifestName)
+			}
+			if manifest, err = parseHistoryManifest(data); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if _, ok := files[header.Name]; ok {
+			return nil, nil, fmt.Errorf("%w: duplicate entry %s", ErrInvalidArchive, header.Name)
+		}
+		files[header.Name] = data
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("%w: missing %s", ErrInvalidArchive, historyManifestName)
+	}
+
+	listed := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		data, ok := files[file.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s is listed in the manifest but missing", ErrInvalidArchive, file.Path)
+		}
+		sum := sha256.Sum256(data)
+		if int64(len(data)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+			return nil, nil, fmt.Errorf("%w: %s does not match the manifest", ErrInvalidArchive, file.Path)
+		}
+		listed[file.Path] = true
+	}
+	for name := range files {
+		if !listed[name] {
+			return nil, nil, fmt.Errorf("%w: %s is not listed in the manifest", ErrInvalidArchive, name)
+		}
+	}
+	return manifest, files, nil
+}
+
+// parseHistoryManifest 解析并校验 manifest：格式、版本，以及每个文件路径都是 versions 或 logs 中的文件且不重复
+func parseHistoryManifest(data []byte) (*historyManifest, error) {
+	var manifest historyManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrInvalidArchive, err)
+	}
+	if manifest.Format != historyArchiveFormat {
+		return nil, fmt.Errorf("%w: unknown format %q", ErrInvalidArchive, manifest.Format)
+	}
+	if manifest.Version < 1 || manifest.Version > historyArchiveVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, manifest.Version)
+	}
+
+	seen := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		dir, name := path.Split(file.Path)
+		if (dir != "versions/" && dir != "logs/") || !historyFileName.MatchString(name) {
+			return nil, fmt.Errorf("%w: unexpected path %q", ErrInvalidArchive, file.Path)
+		}
+		if seen[file.Path] {
+			return nil, fmt.Errorf("%w: %s is listed twice", ErrInvalidArchive, file.Path)
+		}
+		seen[file.Path] = true
+	}
+	return &manifest, nil
+}
+
+// validateHistoryFile 检查解密后的文件是 versions 中的配置版本或 logs 中的同步日志
+func validateHistoryFile(filePath string, data []byte) error {
+	var valid bool
+	if path.Dir(filePath) == "versions" {
+		var version models.ConfigVersion
+		valid = json.Unmarshal(data, &version) == nil && version.ID != ""
+	} else {
+		var log models.SyncLog
+		valid = json.Unmarshal(data, &log) == nil && log.Action != ""
+	}
+	if !valid {
+		return fmt.Errorf("%w: %s is not a valid %s file", ErrInvalidArchive, filePath, path.Dir(filePath))
+	}
+	return nil
+}
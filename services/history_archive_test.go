@@ -0,0 +1,242 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"mcp-sync/models"
+)
+
+// newEncryptedTestAppService returns an AppService whose local storage is encrypted with its own in-memory key
+func newEncryptedTestAppService(t *testing.T) *AppService {
+	t.Helper()
+	as := newTestAppService(t)
+	as.storage.crypto = NewSecureCryptoWithKeyring(NewInMemoryKeyring())
+	if err := as.storage.crypto.Enable(); err != nil {
+		t.Fatal(err)
+	}
+	return as
+}
+
+// seedHistory saves two versions and two sync logs
+func seedHistory(t *testing.T, as *AppService) {
+	t.Helper()
+	for _, content := range []string{`{"cursor": {"mcpServers": {"a": {"command": "a"}}}}`, `{"cursor": {"mcpServers": {"b": {"command": "b"}}}}`} {
+		if err := as.storage.SaveConfigVersion(models.ConfigVersion{ID: genID(), Timestamp: nowTime(), Content: content, Source: "local"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, action := range []string{"push", "pull"} {
+		if err := as.storage.SaveSyncLog(models.SyncLog{ID: genID(), Timestamp: nowTime(), Action: action, Status: "success"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// historyContents returns the version contents and log actions stored by as, sorted
+func historyContents(t *testing.T, as *AppService) ([]string, []string) {
+	t.Helper()
+	versions, skipped, err := as.storage.ListConfigVersionsWithSkipped(100)
+	if err != nil || skipped != 0 {
+		t.Fatalf("ListConfigVersionsWithSkipped() = %d skipped, %v", skipped, err)
+	}
+	logs, skipped, err := as.storage.GetSyncLogsWithSkipped(100)
+	if err != nil || skipped != 0 {
+		t.Fatalf("GetSyncLogsWithSkipped() = %d skipped, %v", skipped, err)
+	}
+	var contents, actions []string
+	for _, version := range versions {
+		contents = append(contents, version.Content)
+	}
+	for _, log := range logs {
+		actions = append(actions, log.Action)
+	}
+	sort.Strings(contents)
+	sort.Strings(actions)
+	return contents, actions
+}
+
+// buildHistoryArchive writes an uncompressed archive with the manifest first; entries listed in the
+// manifest without a hash get one computed from files
+func buildHistoryArchive(t *testing.T, manifest historyManifest, files map[string]string) []byte {
+	t.Helper()
+	for i, file := range manifest.Files {
+		if file.SHA256 == "" {
+			sum := sha256.Sum256([]byte(files[file.Path]))
+			manifest.Files[i].SHA256 = hex.EncodeToString(sum[:])
+			manifest.Files[i].Size = int64(len(files[file.Path]))
+		}
+	}
+	manifestData, _ := json.Marshal(manifest)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(name, content string) {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	write(historyManifestName, string(manifestData))
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		write(name, files[name])
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func TestHistoryArchiveRoundTrip(t *testing.T) {
+	source := newEncryptedTestAppService(t)
+	seedHistory(t, source)
+	wantVersions, wantLogs := historyContents(t, source)
+
+	var archive bytes.Buffer
+	if err := source.ExportHistoryArchive(&archive); err != nil {
+		t.Fatalf("ExportHistoryArchive() error = %v", err)
+	}
+
+	// Compressed, and decrypted inside
+	gz, err := gzip.NewReader(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("archive is not gzip compressed: %v", err)
+	}
+	raw, _ := io.ReadAll(gz)
+	if !strings.Contains(string(raw), historyArchiveFormat) || !strings.Contains(string(raw), `\"command\": \"a\"`) {
+		t.Errorf("archive is missing the manifest or the decrypted versions")
+	}
+
+	// Another machine with its own key
+	target := newEncryptedTestAppService(t)
+	if err := target.ImportHistoryArchive(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("ImportHistoryArchive() error = %v", err)
+	}
+	gotVersions, gotLogs := historyContents(t, target)
+	if !reflect.DeepEqual(gotVersions, wantVersions) {
+		t.Errorf("imported versions = %v, want %v", gotVersions, wantVersions)
+	}
+	wantLogs = append(wantLogs, "import_history")
+	sort.Strings(wantLogs)
+	if !reflect.DeepEqual(gotLogs, wantLogs) {
+		t.Errorf("imported logs = %v, want %v", gotLogs, wantLogs)
+	}
+	for _, path := range dataFilePaths(target.storage.fs, target.storage.GetDataDir()) {
+		data := readFile(t, path)
+		if _, err := target.storage.crypto.DecryptIfNeeded([]byte(data)); err != nil || !strings.HasPrefix(data, "ENC:") {
+			t.Errorf("%s is not encrypted with the target key: %v", filepath.Base(path), err)
+		}
+	}
+	if index := target.storage.loadVersionIndex(); len(index) != len(wantVersions) {
+		t.Errorf("version index after import has %d entries, want %d", len(index), len(wantVersions))
+	}
+
+	// Importing the same archive again adds nothing
+	if err := target.ImportHistoryArchive(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("second ImportHistoryArchive() error = %v", err)
+	}
+	if again, _ := historyContents(t, target); !reflect.DeepEqual(again, wantVersions) {
+		t.Errorf("versions after importing twice = %v, want %v", again, wantVersions)
+	}
+}
+
+func TestHistoryArchiveKeepEncrypted(t *testing.T) {
+	source := newEncryptedTestAppService(t)
+	seedHistory(t, source)
+	wantVersions, _ := historyContents(t, source)
+
+	var archive bytes.Buffer
+	if err := source.ExportHistoryArchiveWithOptions(&archive, models.HistoryArchiveOptions{KeepEncrypted: true}); err != nil {
+		t.Fatalf("ExportHistoryArchiveWithOptions() error = %v", err)
+	}
+	if strings.Contains(archive.String(), `\"command\": \"a\"`) || !strings.Contains(archive.String(), "ENC:") {
+		t.Fatal("encrypted export contains plaintext")
+	}
+
+	// A different key cannot read it, and nothing is written
+	other := newEncryptedTestAppService(t)
+	if err := other.ImportHistoryArchive(bytes.NewReader(archive.Bytes())); !errors.Is(err, ErrInvalidArchive) {
+		t.Fatalf("ImportHistoryArchive() with another key error = %v, want ErrInvalidArchive", err)
+	}
+	if versions, _ := other.storage.ListConfigVersions(100); len(versions) != 0 {
+		t.Errorf("failed import wrote %d versions", len(versions))
+	}
+
+	// The same key restores it
+	restored := newTestAppService(t)
+	restored.storage.crypto = source.storage.crypto
+	if err := restored.ImportHistoryArchive(bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("ImportHistoryArchive() with the same key error = %v", err)
+	}
+	if got, _ := historyContents(t, restored); !reflect.DeepEqual(got, wantVersions) {
+		t.Errorf("restored versions = %v, want %v", got, wantVersions)
+	}
+}
+
+func TestImportHistoryArchiveValidates(t *testing.T) {
+	version := `{"id": "v1", "content": "{}"}`
+	log := `{"id": "l1", "action": "push", "status": "success"}`
+	manifest := func(paths ...string) historyManifest {
+		m := historyManifest{Format: historyArchiveFormat, Version: historyArchiveVersion}
+		for _, path := range paths {
+			m.Files = append(m.Files, historyManifestFile{Path: path})
+		}
+		return m
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"not an archive", []byte("hello")},
+		{"missing manifest", func() []byte {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			tw.WriteHeader(&tar.Header{Name: "versions/v1.json", Mode: 0644, Size: int64(len(version)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(version))
+			tw.Close()
+			return buf.Bytes()
+		}()},
+		{"unknown format", buildHistoryArchive(t, historyManifest{Format: "zip", Version: 1}, nil)},
+		{"newer version", buildHistoryArchive(t, historyManifest{Format: historyArchiveFormat, Version: historyArchiveVersion + 1}, nil)},
+		{"path outside history", buildHistoryArchive(t, manifest("versions/../sync_config.json"), map[string]string{"versions/../sync_config.json": version})},
+		{"other directory", buildHistoryArchive(t, manifest("backups/cursor_1.json"), map[string]string{"backups/cursor_1.json": version})},
+		{"hash mismatch", buildHistoryArchive(t, historyManifest{Format: historyArchiveFormat, Version: 1, Files: []historyManifestFile{{Path: "versions/v1.json", Size: int64(len(version)), SHA256: strings.Repeat("0", 64)}}}, map[string]string{"versions/v1.json": version})},
+		{"missing file", buildHistoryArchive(t, manifest("versions/v1.json", "logs/l1.json"), map[string]string{"versions/v1.json": version})},
+		{"unlisted file", buildHistoryArchive(t, manifest("versions/v1.json"), map[string]string{"versions/v1.json": version, "logs/l1.json": log})},
+		{"invalid version", buildHistoryArchive(t, manifest("versions/v1.json", "logs/l1.json"), map[string]string{"versions/v1.json": `{"content": "no id"}`, "logs/l1.json": log})},
+		{"invalid log", buildHistoryArchive(t, manifest("versions/v1.json", "logs/l1.json"), map[string]string{"versions/v1.json": version, "logs/l1.json": "not json"})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as := newTestAppService(t)
+			if err := as.ImportHistoryArchive(bytes.NewReader(tt.archive)); !errors.Is(err, ErrInvalidArchive) {
+				t.Fatalf("ImportHistoryArchive() error = %v, want ErrInvalidArchive", err)
+			}
+			names, _ := as.storage.historyFileNames("versions")
+			logs, _ := as.storage.historyFileNames("logs")
+			if len(names)+len(logs) != 0 {
+				t.Errorf("rejected archive wrote %v %v", names, logs)
+			}
+		})
+	}
+
+	// The same files with a correct manifest are accepted
+	as := newTestAppService(t)
+	valid := buildHistoryArchive(t, manifest("versions/v1.json", "logs/l1.json"), map[string]string{"versions/v1.json": version, "logs/l1.json": log})
+	if err := as.ImportHistoryArchive(bytes.NewReader(valid)); err != nil {
+		t.Fatalf("ImportHistoryArchive() with a valid archive error = %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// IsInSync 快速判断本机与 Gist 是否一致：本机 agent 的配置和 Gist 的当前版本都与合并基准（最近一次成功同步的快照）
+// 语义相同时返回 true。先对比本地，不访问网络，本地有改动时直接返回 false；远程通过带 ETag 缓存的 GetLatestVersion
+// 获取，Gist 未变化时不会重新下载和解密。从未同步过时返回 false
+func (as *AppService) IsInSync() (bool, error) {
+	base, err := as.storage.LoadMergeBase()
+	if err != nil {
+		return false, fmt.Errorf("failed to read merge base: %w", err)
+	}
+	if base == nil {
+		return false, nil
+	}
+	var baseAgents map[string]interface{}
+	if err := json.Unmarshal([]byte(base.Content), &baseAgents); err != nil {
+		return false, fmt.Errorf("failed to parse merge base: %w", err)
+	}
+
+	localAgents, err := as.collectAgentConfigs()
+	if err != nil {
+		return false, err
+	}
+	if agentsChangedSince(baseAgents, localAgents) {
+		return false, nil
+	}
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return false, err
+	}
+	remote, err := gs.ReadOnly().GetLatestVersion()
+	if err != nil {
+		return false, fmt.Errorf("failed to get remote version: %w", err)
+	}
+	if remote == nil {
+		// The gist was emptied since the last sync
+		return len(baseAgents) == 0, nil
+	}
+	return remote.Hash == canonicalConfigHash(base.Content), nil
+}
+
+// agentsChangedSince 判断 local 中是否有 agent 的配置与合并基准 base 语义不同，或是 base 中没有的新 agent。
+// 只在 base 中的 agent 不算改动：拉取的配置可能包含本机没有安装的 agent
+func agentsChangedSince(base, local map[string]interface{}) bool {
+	agentHash := func(agentID string, config interface{}) string {
+		data, _ := json.Marshal(map[string]interface{}{agentID: config})
+		return canonicalConfigHash(string(data))
+	}
+	for agentID, config := range local {
+		previous, ok := base[agentID]
+		if !ok || agentHash(agentID, previous) != agentHash(agentID, config) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsInSync(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	cursorPath := writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}}}`)
+
+	if inSync, err := as.IsInSync(); err != nil || inSync {
+		t.Errorf("IsInSync() before the first sync = %v, %v; want false", inSync, err)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	crypto := &countingCrypto{CryptoOperations: as.gistSync.securityMgr}
+	as.gistSync.securityMgr = crypto
+	for i := 0; i < 2; i++ {
+		if inSync, err := as.IsInSync(); err != nil || !inSync {
+			t.Fatalf("IsInSync() after a push = %v, %v; want true", inSync, err)
+		}
+	}
+	// The unchanged gist is answered from the ETag cache
+	if crypto.decrypts > 1 {
+		t.Errorf("decrypts = %d after repeated checks, want at most 1", crypto.decrypts)
+	}
+
+	t.Run("local ahead", func(t *testing.T) {
+		original := readFile(t, cursorPath)
+		writeAgentFile(t, as, "cursor", `{"mcpServers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch", "--verbose"]}}}`)
+		t.Cleanup(func() { writeAgentFile(t, as, "cursor", original) })
+
+		requests := server.requestCount("GET")
+		if inSync, err := as.IsInSync(); err != nil || inSync {
+			t.Errorf("IsInSync() with a local edit = %v, %v; want false", inSync, err)
+		}
+		if server.requestCount("GET") != requests {
+			t.Error("IsInSync() contacted the gist although the local side had diverged")
+		}
+	})
+
+	t.Run("reformatted local file", func(t *testing.T) {
+		original := readFile(t, cursorPath)
+		writeAgentFile(t, as, "cursor", "{\n  \"mcpServers\": {\n    \"fetch\": {\"args\": [\"mcp-server-fetch\"], \"command\": \"uvx\"}\n  }\n}\n")
+		t.Cleanup(func() { writeAgentFile(t, as, "cursor", original) })
+
+		if inSync, err := as.IsInSync(); err != nil || !inSync {
+			t.Errorf("IsInSync() with only formatting changed = %v, %v; want true", inSync, err)
+		}
+	})
+
+	t.Run("remote ahead", func(t *testing.T) {
+		server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+			"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+				"git": map[string]interface{}{"command": "git-mcp"},
+			}},
+		}, time.Now()))
+
+		if inSync, err := as.IsInSync(); err != nil || inSync {
+			t.Errorf("IsInSync() after another machine pushed = %v, %v; want false", inSync, err)
+		}
+	})
+}
+
+func TestIsInSyncAfterPartialPushes(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"work-db": {"command": "db", "tags": ["work"]}}}`)
+
+	if err := as.PushByTag("work"); err != nil {
+		t.Fatalf("PushByTag() error = %v", err)
+	}
+	if inSync, err := as.IsInSync(); err != nil || !inSync {
+		t.Errorf("IsInSync() after PushByTag = %v, %v; want true", inSync, err)
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"work-db": {"command": "db", "args": ["--readonly"], "tags": ["work"]}}}`)
+	if err := as.PushAgentToGist("cursor"); err != nil {
+		t.Fatalf("PushAgentToGist() error = %v", err)
+	}
+	if inSync, err := as.IsInSync(); err != nil || !inSync {
+		t.Errorf("IsInSync() after PushAgentToGist = %v, %v; want true", inSync, err)
+	}
+}
+
+func TestIsInSyncAfterPartialPulls(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "")
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"work-db": {"command": "db", "tags": ["work"]}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+
+	// Another machine changes the tagged server
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"work-db": map[string]interface{}{"command": "db", "args": []string{"--readonly"}, "tags": []string{"work"}},
+		}},
+	}, time.Now()))
+	if _, err := as.PullByTag("work"); err != nil {
+		t.Fatalf("PullByTag() error = %v", err)
+	}
+	if inSync, err := as.IsInSync(); err != nil || !inSync {
+		t.Errorf("IsInSync() after PullByTag = %v, %v; want true", inSync, err)
+	}
+
+	server.writeFileForTest(gistID, remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"work-db": map[string]interface{}{"command": "db-v2", "tags": []string{"work"}},
+		}},
+	}, time.Now()))
+	if err := as.PullAgentFromGist("cursor"); err != nil {
+		t.Fatalf("PullAgentFromGist() error = %v", err)
+	}
+	if inSync, err := as.IsInSync(); err != nil || !inSync {
+		t.Errorf("IsInSync() after PullAgentFromGist = %v, %v; want true", inSync, err)
+	}
+}
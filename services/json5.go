@@ -0,0 +1,723 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// json5Member 记录顶层对象中一个成员的值在源文本中的位置，写入时只替换这一段以保留其他内容和注释
+type json5Member struct {
+	key        string
+	lineStart  int // 成员所在行的起始位置，用于计算缩进
+	valueStart int
+	valueEnd   int
+	comma      bool // 值后面是否已有逗号
+}
+
+// json5Document 是解析后的 JSON5 文档
+type json5Document struct {
+	value       interface{}
+	members     []json5Member // 顶层为对象时的成员，按出现顺序
+	objectOpen  int           // 顶层对象 { 的位置
+	objectClose int           // 顶层对象 } 的位置
+}
+
+// json5Parser 解析 JSON5：注释、不带引号的键、单引号字符串、尾随逗号、十六进制和 Infinity/NaN 等数字
+type json5Parser struct {
+	src []byte
+	pos int
+}
+
+// parseJSON5 解析 JSON5 文本，数字解析为 float64，与 encoding/json 的通用类型一致
+func parseJSON5(data []byte) (*json5Document, error) {
+	p := &json5Parser{src: data}
+	if err := p.skipSpace(); err != nil {
+		return nil, err
+	}
+
+	doc := &json5Document{objectOpen: -1}
+	var err error
+	if p.pos < len(p.src) && p.src[p.pos] == '{' {
+		doc.objectOpen = p.pos
+		doc.value, err = p.parseObject(&doc.members)
+		doc.objectClose = p.pos - 1
+	} else {
+		doc.value, err = p.parseValue()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.skipSpace(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after top-level value", p.src[p.pos])
+	}
+	return doc, nil
+}
+
+func (p *json5Parser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(string(p.src[:p.pos]), "\n")
+	return fmt.Errorf("json5: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace 跳过空白和注释
+func (p *json5Parser) skipSpace() error {
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRune(p.src[p.pos:])
+		switch {
+		case unicode.IsSpace(r) || r == '\uFEFF':
+			p.pos += size
+		case r == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case r == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '*':
+			end := strings.Index(string(p.src[p.pos+2:]), "*/")
+			if end < 0 {
+				return p.errorf("unterminated block comment")
+			}
+			p.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *json5Parser) parseValue() (interface{}, error) {
+	if err := p.skipSpace(); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end of input")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.parseObject(nil)
+	case c == '[':
+		return p.parseArray()
+	case c == '"' || c == '\'':
+		return p.parseString()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	}
+
+	ident := p.parseIdentifier()
+	switch ident {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	case "Infinity":
+		return math.Inf(1), nil
+	case "NaN":
+		return math.NaN(), nil
+	case "":
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return nil, p.errorf("unexpected identifier %q", ident)
+}
+
+// parseObject 解析对象；members 不为 nil 时记录每个成员值的位置
+func (p *json5Parser) parseObject(members *[]json5Member) (map[string]interface{}, error) {
+	p.pos++ // {
+	object := make(map[string]interface{})
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated object")
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return object, nil
+		}
+
+		lineStart := strings.LastIndexByte(string(p.src[:p.pos]), '\n') + 1
+		var key string
+		if c := p.src[p.pos]; c == '"' || c == '\'' {
+			var err error
+			if key, err = p.parseString(); err != nil {
+				return nil, err
+			}
+		} else if key = p.parseIdentifier(); key == "" {
+			return nil, p.errorf("expected object key, found %q", c)
+		}
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+			return nil, p.errorf("expected ':' after key %q", key)
+		}
+		p.pos++
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		valueStart := p.pos
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+		member := json5Member{key: key, lineStart: lineStart, valueStart: valueStart, valueEnd: p.pos}
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		member.comma = p.pos < len(p.src) && p.src[p.pos] == ','
+		if members != nil {
+			*members = append(*members, member)
+		}
+		if member.comma {
+			p.pos++
+		} else if p.pos >= len(p.src) || p.src[p.pos] != '}' {
+			return nil, p.errorf("expected ',' or '}' in object")
+		}
+	}
+}
+
+func (p *json5Parser) parseArray() ([]interface{}, error) {
+	p.pos++ // [
+	array := []interface{}{}
+	for {
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			return array, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+
+		if err := p.skipSpace(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+		} else if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// parseIdentifier 读取不带引号的键或关键字（ECMAScript 标识符，不支持 \u 转义）
+func (p *json5Parser) parseIdentifier() string {
+	start := p.pos
+	for p.pos < len(p.src) {
+		r, size := utf8.DecodeRune(p.src[p.pos:])
+		if !(r == '_' || r == '$' || unicode.IsLetter(r) || (p.pos > start && unicode.IsDigit(r))) {
+			break
+		}
+		p.pos += size
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *json5Parser) parseString() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c != '\\':
+			sb.WriteByte(c)
+			p.pos++
+			continue
+		}
+
+		// Escape sequence
+		p.pos++
+		if p.pos >= len(p.src) {
+			break
+		}
+		e := p.src[p.pos]
+		p.pos++
+		switch e {
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '0':
+			sb.WriteByte(0)
+		case '\n':
+			// Line continuation
+		case '\r':
+			if p.pos < len(p.src) && p.src[p.pos] == '\n' {
+				p.pos++
+			}
+		case 'x', 'u':
+			digits := 2
+			if e == 'u' {
+				digits = 4
+			}
+			if p.pos+digits > len(p.src) {
+				return "", p.errorf("invalid \\%c escape", e)
+			}
+			code, err := strconv.ParseUint(string(p.src[p.pos:p.pos+digits]), 16, 32)
+			if err != nil {
+				return "", p.errorf("invalid \\%c escape", e)
+			}
+			p.pos += digits
+			r := rune(code)
+			// Combine UTF-16 surrogate pairs
+			if r >= 0xD800 && r < 0xDC00 && p.pos+6 <= len(p.src) && p.src[p.pos] == '\\' && p.src[p.pos+1] == 'u' {
+				if low, err := strconv.ParseUint(string(p.src[p.pos+2:p.pos+6]), 16, 32); err == nil && low >= 0xDC00 && low < 0xE000 {
+					r = (r-0xD800)<<10 + (rune(low) - 0xDC00) + 0x10000
+					p.pos += 6
+				}
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(e)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *json5Parser) parseNumber() (float64, error) {
+	start := p.pos
+	sign := 1.0
+	if c := p.src[p.pos]; c == '+' || c == '-' {
+		if c == '-' {
+			sign = -1
+		}
+		p.pos++
+	}
+
+	rest := string(p.src[p.pos:])
+	switch {
+	case strings.HasPrefix(rest, "Infinity"):
+		p.pos += len("Infinity")
+		return sign * math.Inf(1), nil
+	case strings.HasPrefix(rest, "NaN"):
+		p.pos += len("NaN")
+		return math.NaN(), nil
+	case strings.HasPrefix(rest, "0x") || strings.HasPrefix(rest, "0X"):
+		p.pos += 2
+		digitsStart := p.pos
+		for p.pos < len(p.src) && strings.IndexByte("0123456789abcdefABCDEF", p.src[p.pos]) >= 0 {
+			p.pos++
+		}
+		n, err := strconv.ParseUint(string(p.src[digitsStart:p.pos]), 16, 64)
+		if err != nil {
+			return 0, p.errorf("invalid hex number %q", p.src[start:p.pos])
+		}
+		return sign * float64(n), nil
+	}
+
+	for p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	n, err := strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %q", p.src[start:p.pos])
+	}
+	return n, nil
+}
+
+// marshalJSON5 将值序列化为 JSON5：能作为标识符的键不加引号，键按字母排序（orderedObject 按其顺序），嵌套层级在 indent 基础上缩进两个空格
+func marshalJSON5(value interface{}, indent string) (string, error) {
+	var sb strings.Builder
+	if err := writeJSON5Value(&sb, value, indent); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func writeJSON5Value(sb *strings.Builder, value interface{}, indent string) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return writeJSON5Object(sb, sortedKeys(v), v, indent)
+
+	case orderedObject:
+		return writeJSON5Object(sb, v.keys, v.values, indent)
+
+	case []interface{}:
+		if len(v) == 0 {
+			sb.WriteString("[]")
+			return nil
+		}
+		sb.WriteString("[\n")
+		for i, item := range v {
+			sb.WriteString(indent + "  ")
+			if err := writeJSON5Value(sb, item, indent+"  "); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				sb.WriteByte(',')
+			}
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(indent + "]")
+		return nil
+
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			sb.WriteString("NaN")
+			return nil
+		case math.IsInf(v, 1):
+			sb.WriteString("Infinity")
+			return nil
+		case math.IsInf(v, -1):
+			sb.WriteString("-Infinity")
+			return nil
+		}
+	}
+
+	// Other values (and typed maps/slices such as map[string]string) go through a JSON round-trip
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	switch generic.(type) {
+	case map[string]interface{}, []interface{}:
+		return writeJSON5Value(sb, generic, indent)
+	}
+	sb.Write(data)
+	return nil
+}
+
+// writeJSON5Object 按 keys 的顺序写出对象的成员
+func writeJSON5Object(sb *strings.Builder, keys []string, values map[string]interface{}, indent string) error {
+	if len(keys) == 0 {
+		sb.WriteString("{}")
+		return nil
+	}
+
+	sb.WriteString("{\n")
+	for i, key := range keys {
+		sb.WriteString(indent + "  " + json5Key(key) + ": ")
+		if err := writeJSON5Value(sb, values[key], indent+"  "); err != nil {
+			return err
+		}
+		if i < len(keys)-1 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(indent + "}")
+	return nil
+}
+
+// json5Key 返回键的 JSON5 写法：合法标识符不加引号，其余使用双引号
+func json5Key(key string) string {
+	for i, r := range key {
+		if !(r == '_' || r == '$' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			data, _ := json.Marshal(key)
+			return string(data)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// readJSON5ConfigFile 读取完整的 JSON5 配置文件，顶层必须是对象
+func readJSON5ConfigFile(path string) (map[string]interface{}, error) {
+	data, _, err := readConfigText(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return make(map[string]interface{}), nil
+	}
+
+	doc, err := parseJSON5(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	config, ok := doc.value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to parse %s: top-level value is not an object", filepath.Base(path))
+	}
+	return config, nil
+}
+
+// sectionStyle 决定 replaceSection 写入的值和新增键的写法
+type sectionStyle struct {
+	marshal func(value interface{}, indent string) (string, error)
+	key     func(key string) string
+	// trailingComma 为 true 时新增的成员后面带逗号（JSON5 允许，JSONC 中不写以兼容严格的解析器）
+	trailingComma bool
+}
+
+// json5Style 以 JSON5 写法写入：标识符键不加引号，成员带尾随逗号
+var json5Style = sectionStyle{marshal: marshalJSON5, key: json5Key, trailingComma: true}
+
+// jsoncStyle 以普通 JSON 写法写入值，用于带注释的 JSON（例如 Zed 的 settings.json）
+var jsoncStyle = sectionStyle{
+	marshal: func(value interface{}, indent string) (string, error) {
+		data, err := json.MarshalIndent(value, indent, "  ")
+		return string(data), err
+	},
+	key: func(key string) string {
+		quoted, _ := json.Marshal(key)
+		return string(quoted)
+	},
+}
+
+// writeJSON5ConfigSection 替换 JSON5 配置文件中 configKey 的值（不存在时追加该键）。
+// 只改写这个值在文本中的范围，文件其他部分（包括注释）原样保留；被替换的值内部的注释会丢失
+func writeJSON5ConfigSection(path, configKey string, section interface{}) error {
+	return writeConfigSection(path, configKey, section, json5Style)
+}
+
+// writeJSONCConfigSection 与 writeJSON5ConfigSection 相同，但以普通 JSON 写法写入，用于 JSONC 文件
+func writeJSONCConfigSection(path, configKey string, section interface{}) error {
+	return writeConfigSection(path, configKey, section, jsoncStyle)
+}
+
+func writeConfigSection(path, configKey string, section interface{}, style sectionStyle) error {
+	data, enc, err := readConfigText(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	updated, patched, err := patchSection(data, configKey, section, style)
+	if err == nil && !patched {
+		updated, err = replaceSection(data, configKey, section, style)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", filepath.Base(path), err)
+	}
+	return os.WriteFile(path, enc.encode(updated), 0644)
+}
+
+// replaceSection 返回将 data 顶层对象中 configKey 的值替换为 section（不存在时追加）后的文本，其余文本原样保留。
+// 已有的成员保持原来的顺序，新增的成员排在最后
+func replaceSection(data []byte, configKey string, section interface{}, style sectionStyle) ([]byte, error) {
+	comma := ""
+	if style.trailingComma {
+		comma = ","
+	}
+
+	if strings.TrimSpace(string(data)) == "" {
+		value, err := style.marshal(section, "  ")
+		if err != nil {
+			return nil, err
+		}
+		return []byte("{\n  " + style.key(configKey) + ": " + value + comma + "\n}\n"), nil
+	}
+
+	doc, err := parseJSON5(data)
+	if err != nil {
+		return nil, err
+	}
+	if doc.objectOpen < 0 {
+		return nil, fmt.Errorf("top-level value is not an object")
+	}
+
+	var updated string
+	if member, ok := doc.member(configKey); ok {
+		// Keep the servers in the order the file lists them
+		if servers, ok := section.(map[string]interface{}); ok {
+			section = withKeyOrder(servers, objectKeyOrder(data, member.valueStart))
+		}
+		value, err := style.marshal(section, leadingIndent(data[member.lineStart:]))
+		if err != nil {
+			return nil, err
+		}
+		updated = string(data[:member.valueStart]) + value + string(data[member.valueEnd:])
+	} else {
+		// Add the key on its own line before the closing brace, so comments after the
+		// last member stay where they are
+		indent := "  "
+		before := string(data[:doc.objectClose])
+		if len(doc.members) > 0 {
+			last := doc.members[len(doc.members)-1]
+			indent = leadingIndent(data[last.lineStart:])
+			if !last.comma {
+				before = string(data[:last.valueEnd]) + "," + string(data[last.valueEnd:doc.objectClose])
+			}
+		}
+		value, err := style.marshal(section, indent)
+		if err != nil {
+			return nil, err
+		}
+		if trimmed := strings.TrimRight(before, " \t"); !strings.HasSuffix(trimmed, "\n") {
+			before = trimmed + "\n"
+		} else {
+			before = trimmed
+		}
+		updated = before + indent + style.key(configKey) + ": " + value + comma + "\n" + string(data[doc.objectClose:])
+	}
+
+	return []byte(updated), nil
+}
+
+// patchSection 在 configKey 的值和 section 都是对象时逐个成员修改：只改写值有变化的成员、删除 section 中没有的成员、
+// 在末尾追加新成员，未变化的成员连同格式和注释原样保留，使文件的 diff 尽量小。
+// 无法安全地逐个修改时（键不存在、重复的键、多个成员写在同一行等）返回 false，由调用方替换整个值
+func patchSection(data []byte, configKey string, section interface{}, style sectionStyle) ([]byte, bool, error) {
+	wanted, ok := section.(map[string]interface{})
+	if !ok || strings.TrimSpace(string(data)) == "" {
+		return nil, false, nil
+	}
+	doc, err := parseJSON5(data)
+	if err != nil || doc.objectOpen < 0 {
+		return nil, false, nil
+	}
+	member, ok := doc.member(configKey)
+	if !ok || data[member.valueStart] != '{' {
+		return nil, false, nil
+	}
+	current := doc.value.(map[string]interface{})[configKey].(map[string]interface{})
+
+	// Parse the section again to get the position of each of its members
+	var members []json5Member
+	p := &json5Parser{src: data, pos: member.valueStart}
+	if _, err := p.parseObject(&members); err != nil || len(members) == 0 || len(members) != len(current) {
+		return nil, false, nil
+	}
+	closing := p.pos - 1
+
+	// Every member must start on its own line and the closing brace must be on a later line,
+	// so members can be removed or added as whole lines
+	prevEnd := member.valueStart
+	for _, m := range members {
+		if m.lineStart <= prevEnd {
+			return nil, false, nil
+		}
+		prevEnd = m.valueEnd
+	}
+	closeLine := strings.LastIndexByte(string(data[:closing]), '\n') + 1
+	if closeLine <= prevEnd || strings.TrimSpace(string(data[closeLine:closing])) != "" {
+		return nil, false, nil
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	lastKept := -1
+	for i, m := range members {
+		value, keep := wanted[m.key]
+		if !keep {
+			end := strings.IndexByte(string(data[m.valueEnd:]), '\n')
+			edits = append(edits, edit{start: m.lineStart, end: m.valueEnd + end + 1})
+			continue
+		}
+		lastKept = i
+		if jsonEqual(value, current[m.key]) {
+			continue
+		}
+		text, err := style.marshal(value, leadingIndent(data[m.lineStart:]))
+		if err != nil {
+			return nil, false, err
+		}
+		edits = append(edits, edit{start: m.valueStart, end: m.valueEnd, text: text})
+	}
+
+	var added []string
+	for _, key := range sortedKeys(wanted) {
+		if _, ok := current[key]; !ok {
+			added = append(added, key)
+		}
+	}
+
+	// Keep the commas valid: the last remaining member needs one before added members,
+	// and loses the separator it no longer needs when the members after it were removed
+	if lastKept >= 0 {
+		kept := members[lastKept]
+		last := members[len(members)-1]
+		if len(added) > 0 && !kept.comma {
+			edits = append(edits, edit{start: kept.valueEnd, end: kept.valueEnd, text: ","})
+		} else if len(added) == 0 && kept.comma && lastKept != len(members)-1 && !last.comma {
+			comma := kept.valueEnd + strings.IndexByte(string(data[kept.valueEnd:]), ',')
+			edits = append(edits, edit{start: comma, end: comma + 1})
+		}
+	}
+
+	if len(added) > 0 {
+		indent := leadingIndent(data[members[0].lineStart:])
+		var text strings.Builder
+		for i, key := range added {
+			value, err := style.marshal(wanted[key], indent)
+			if err != nil {
+				return nil, false, err
+			}
+			text.WriteString(indent + style.key(key) + ": " + value)
+			if i < len(added)-1 || style.trailingComma {
+				text.WriteString(",")
+			}
+			text.WriteString("\n")
+		}
+		edits = append(edits, edit{start: closeLine, end: closeLine, text: text.String()})
+	}
+
+	if len(edits) == 0 {
+		return data, true, nil
+	}
+	// Apply from the end so earlier positions stay valid
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	updated := string(data)
+	for _, e := range edits {
+		updated = updated[:e.start] + e.text + updated[e.end:]
+	}
+	return []byte(updated), true, nil
+}
+
+// member 返回顶层对象中最后一个名为 key 的成员（重复的键以最后一个为准）
+func (d *json5Document) member(key string) (json5Member, bool) {
+	for i := len(d.members) - 1; i >= 0; i-- {
+		if d.members[i].key == key {
+			return d.members[i], true
+		}
+	}
+	return json5Member{}, false
+}
+
+// leadingIndent 返回一行开头的空白
+func leadingIndent(line []byte) string {
+	end := 0
+	for end < len(line) && (line[end] == ' ' || line[end] == '\t') {
+		end++
+	}
+	return string(line[:end])
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSON5(t *testing.T) {
+	doc, err := parseJSON5([]byte(`// settings
+{
+  /* servers used by the editor */
+  mcpServers: {
+    'fetch': {command: 'uvx', args: ['mcp-server-fetch',], env: {LOG: "it's \"on\""}},
+  },
+  port: 0x1F,
+  ratio: .5,
+  $plain: 'line \
+continued',
+}`))
+	if err != nil {
+		t.Fatalf("parseJSON5() error = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"mcpServers": map[string]interface{}{
+			"fetch": map[string]interface{}{
+				"command": "uvx",
+				"args":    []interface{}{"mcp-server-fetch"},
+				"env":     map[string]interface{}{"LOG": `it's "on"`},
+			},
+		},
+		"port":   float64(31),
+		"ratio":  0.5,
+		"$plain": "line continued",
+	}
+	if !reflect.DeepEqual(doc.value, want) {
+		t.Errorf("parseJSON5() = %#v, want %#v", doc.value, want)
+	}
+
+	for _, invalid := range []string{`{a: }`, `{a: 1`, `{a: 'x}`, `{a: 1} extra`, `{/* open`, `{a: undefined}`} {
+		if _, err := parseJSON5([]byte(invalid)); err == nil {
+			t.Errorf("parseJSON5(%q) expected an error", invalid)
+		}
+	}
+}
+
+func TestJSON5FormatAdapterRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json5")
+	original := `// Editor settings
+{
+  theme: 'dark', // keep this comment
+  /* MCP servers */
+  mcpServers: {
+    old: {command: 'old'},
+  },
+  'font-size': 14,
+}
+`
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	adapter := formatAdapterFor("json5")
+	servers := map[string]interface{}{
+		"fetch": map[string]interface{}{
+			"command": "uvx",
+			"args":    []interface{}{"mcp-server-fetch"},
+			"env":     map[string]interface{}{"API-KEY": "it's here"},
+		},
+	}
+	if err := adapter.WriteServers(path, "mcpServers", servers); err != nil {
+		t.Fatalf("WriteServers() error = %v", err)
+	}
+
+	written := readFile(t, path)
+	for _, kept := range []string{"// Editor settings", "theme: 'dark', // keep this comment", "/* MCP servers */", "'font-size': 14,"} {
+		if !strings.Contains(written, kept) {
+			t.Errorf("written file lost %q:\n%s", kept, written)
+		}
+	}
+	if strings.Contains(written, "old") {
+		t.Errorf("old servers were not replaced:\n%s", written)
+	}
+
+	got, err := adapter.ReadServers(path, "mcpServers")
+	if err != nil {
+		t.Fatalf("ReadServers() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, servers) {
+		t.Errorf("ReadServers() = %#v, want %#v", got, servers)
+	}
+	config, err := readJSON5ConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["theme"] != "dark" || config["font-size"] != float64(14) {
+		t.Errorf("other settings changed: %#v", config)
+	}
+}
+
+func TestJSON5FormatAdapterAddsMissingKey(t *testing.T) {
+	servers := map[string]interface{}{"fetch": map[string]interface{}{"command": "uvx"}}
+
+	for name, original := range map[string]string{
+		"trailing comma":    "{\n  theme: 'dark',\n}\n",
+		"no trailing comma": "{\n  theme: 'dark' // comment\n}\n",
+		"empty object":      "// nothing yet\n{}\n",
+		"empty file":        "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "settings.json5")
+			if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := formatAdapterFor("json5").WriteServers(path, "mcpServers", servers); err != nil {
+				t.Fatalf("WriteServers() error = %v", err)
+			}
+			config, err := readJSON5ConfigFile(path)
+			if err != nil {
+				t.Fatalf("written file is not valid JSON5: %v\n%s", err, readFile(t, path))
+			}
+			if !reflect.DeepEqual(config["mcpServers"], servers) {
+				t.Errorf("mcpServers = %#v", config["mcpServers"])
+			}
+			if strings.Contains(original, "theme") && config["theme"] != "dark" {
+				t.Errorf("theme lost: %#v", config)
+			}
+		})
+	}
+}
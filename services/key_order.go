@@ -0,0 +1,86 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// orderedKeys 返回 keys 的排列：order 中出现的键保持 order 中的顺序，其余键按字母顺序追加在后面。
+// 写回配置文件时用它保持文件中原有的服务器顺序，新增的服务器排在最后
+func orderedKeys(order []string, keys []string) []string {
+	present := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		present[key] = true
+	}
+
+	result := make([]string, 0, len(keys))
+	for _, key := range order {
+		if present[key] {
+			result = append(result, key)
+			delete(present, key)
+		}
+	}
+	var rest []string
+	for key := range present {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	return append(result, rest...)
+}
+
+// orderedObject 是按 keys 顺序序列化的对象（encoding/json 和 marshalJSON5 都按这个顺序写出成员）
+type orderedObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// withKeyOrder 返回按 order 排列 values 的键的 orderedObject，排列方式与 orderedKeys 相同
+func withKeyOrder(values map[string]interface{}, order []string) orderedObject {
+	return orderedObject{keys: orderedKeys(order, sortedKeys(values)), values: values}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// objectKeyOrder 返回 data 中从 start 开始的对象的成员键，按出现顺序；start 处不是对象或无法解析时返回 nil
+func objectKeyOrder(data []byte, start int) []string {
+	if start < 0 || start >= len(data) || data[start] != '{' {
+		return nil
+	}
+	var members []json5Member
+	p := &json5Parser{src: data, pos: start}
+	if _, err := p.parseObject(&members); err != nil {
+		return nil
+	}
+	return memberKeys(members)
+}
+
+// memberKeys 返回成员的键，按出现顺序
+func memberKeys(members []json5Member) []string {
+	keys := make([]string, 0, len(members))
+	for _, m := range members {
+		keys = append(keys, m.key)
+	}
+	return keys
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"mcp-sync/models"
+)
+
+// ErrLimitExceeded 表示拉取或导入的配置超出了 ConfigLimits
+var ErrLimitExceeded = errors.New("config exceeds the allowed size")
+
+// DefaultConfigLimits are generous for real setups and far below what could hurt the app
+var DefaultConfigLimits = models.ConfigLimits{
+	MaxPayloadBytes: 5 << 20,
+	MaxServers:      1000,
+	MaxDepth:        32,
+}
+
+// effectiveLimits 返回配置中的限制，未配置时使用默认值
+func effectiveLimits(config models.SyncConfig) models.ConfigLimits {
+	if config.Limits == nil {
+		return DefaultConfigLimits
+	}
+	return *config.Limits
+}
+
+// checkPayloadSize 检查内容字节数
+func checkPayloadSize(limits models.ConfigLimits, size int) error {
+	if limits.MaxPayloadBytes > 0 && size > limits.MaxPayloadBytes {
+		return fmt.Errorf("%w: payload is %d bytes, limit is %d", ErrLimitExceeded, size, limits.MaxPayloadBytes)
+	}
+	return nil
+}
+
+// checkPayloadLimits 检查原始内容的大小和 JSON 嵌套深度；非 JSON 内容（如密文、TOML）只检查大小
+func checkPayloadLimits(limits models.ConfigLimits, data []byte) error {
+	if err := checkPayloadSize(limits, len(data)); err != nil {
+		return err
+	}
+	if limits.MaxDepth > 0 {
+		if depth, ok := jsonDepth(data, limits.MaxDepth); ok && depth > limits.MaxDepth {
+			return fmt.Errorf("%w: JSON nesting deeper than %d levels", ErrLimitExceeded, limits.MaxDepth)
+		}
+	}
+	return nil
+}
+
+// checkServerLimit 检查服务器总数
+func checkServerLimit(limits models.ConfigLimits, count int) error {
+	if limits.MaxServers > 0 && count > limits.MaxServers {
+		return fmt.Errorf("%w: %d servers, limit is %d", ErrLimitExceeded, count, limits.MaxServers)
+	}
+	return nil
+}
+
+// checkAgentConfigLimits 统计所有 agent 的服务器数量并检查上限
+func checkAgentConfigLimits(limits models.ConfigLimits, agents map[string]interface{}) error {
+	count := 0
+	for _, agentConfig := range agents {
+		sections, ok := agentConfig.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, section := range sections {
+			if servers, ok := section.(map[string]interface{}); ok {
+				count += len(servers)
+			}
+		}
+	}
+	return checkServerLimit(limits, count)
+}
+
+// jsonDepth returns the maximum nesting depth of the JSON document in data, stopping as soon as
+// the depth passes max. It scans bytes without parsing, so it allocates nothing. ok is false when
+// data does not start like a JSON object or array.
+func jsonDepth(data []byte, max int) (int, bool) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return 0, false
+	}
+
+	depth, deepest := 0, 0
+	inString, escaped := false, false
+	for _, c := range trimmed {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > deepest {
+				deepest = depth
+				if deepest > max {
+					return deepest, true
+				}
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return deepest, true
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// nestedJSON returns an agents payload whose single server nests depth levels deep
+func nestedJSON(depth int) string {
+	return `{"agents": {"cursor": {"mcpServers": {"deep": {"command": "x", "env": ` +
+		strings.Repeat(`{"a": `, depth) + `1` + strings.Repeat(`}`, depth) + `}}}}}`
+}
+
+func TestPullRejectsOversizedPayload(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+
+	servers := make(map[string]interface{})
+	for i := 0; i < 50; i++ {
+		servers[fmt.Sprintf("server-%d", i)] = map[string]interface{}{"command": "x"}
+	}
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": servers},
+	}, time.Now()))
+
+	tests := []struct {
+		name   string
+		limits models.ConfigLimits
+	}{
+		{name: "too many servers", limits: models.ConfigLimits{MaxServers: 10}},
+		{name: "too many bytes", limits: models.ConfigLimits{MaxPayloadBytes: 512}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := newTestGistSync("token-a", gistID)
+			gs.SetLimits(tt.limits)
+			if _, err := gs.PullAgentConfigsFromGist(); !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("PullAgentConfigsFromGist() error = %v, want ErrLimitExceeded", err)
+			}
+		})
+	}
+
+	// The default limits accept a normal payload
+	if agents, err := newTestGistSync("token-a", gistID).PullAgentConfigsFromGist(); err != nil || len(agents) != 1 {
+		t.Errorf("PullAgentConfigsFromGist() = %v, %v with default limits", agents, err)
+	}
+}
+
+func TestPullRejectsDeeplyNestedPayload(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	deep := server.addGist("alice", encryptForTest(t, nestedJSON(200)))
+	shallow := server.addGist("alice", encryptForTest(t, nestedJSON(3)))
+
+	if _, err := newTestGistSync("token-a", deep).PullAgentConfigsFromGist(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("PullAgentConfigsFromGist() error = %v, want ErrLimitExceeded", err)
+	}
+	if _, err := newTestGistSync("token-a", deep).GetLatestVersion(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("GetLatestVersion() error = %v, want ErrLimitExceeded", err)
+	}
+	if _, err := newTestGistSync("token-a", shallow).PullAgentConfigsFromGist(); err != nil {
+		t.Errorf("PullAgentConfigsFromGist() error = %v for a shallow payload", err)
+	}
+}
+
+func TestImportRejectsOversizedSnippet(t *testing.T) {
+	as := newTestAppService(t)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+
+	if err := as.SetConfigLimits(models.ConfigLimits{MaxServers: 2, MaxDepth: 8}); err != nil {
+		t.Fatalf("SetConfigLimits() error = %v", err)
+	}
+
+	tooMany := `{"mcpServers": {"a": {"command": "a"}, "b": {"command": "b"}, "c": {"command": "c"}}}`
+	if _, err := as.ImportServersFromJSON([]byte(tooMany), []string{"cursor"}, false); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ImportServersFromJSON() error = %v, want ErrLimitExceeded", err)
+	}
+	tooDeep := `{"mcpServers": {"a": {"command": "a", "env": ` + strings.Repeat(`{"x": `, 10) + `1` + strings.Repeat(`}`, 10) + `}}}`
+	if _, err := as.ImportServersFromJSON([]byte(tooDeep), []string{"cursor"}, false); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("ImportServersFromJSON() error = %v, want ErrLimitExceeded", err)
+	}
+	if content := readFile(t, path); strings.Contains(content, `"a"`) {
+		t.Errorf("rejected import was written: %s", content)
+	}
+
+	if err := as.SetConfigLimits(models.ConfigLimits{MaxServers: -1}); err == nil {
+		t.Error("expected error for negative limits")
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// maxAPIRequestBytes 限制本地 API 请求体的大小
+const maxAPIRequestBytes = 1 << 20
+
+// apiPushRequest 是 POST /api/push 的请求体；AgentID 为空时推送所有已安装的 agent
+type apiPushRequest struct {
+	AgentID string `json:"agent_id"`
+}
+
+// apiPullRequest 是 POST /api/pull 的请求体；AgentID 为空时拉取全部，Merge 为 true 时保留本地独有的服务器
+type apiPullRequest struct {
+	AgentID string `json:"agent_id"`
+	Merge   bool   `json:"merge"`
+}
+
+// apiConvertRequest 是 POST /api/convert 的请求体，字段含义同 ConvertFile
+type apiConvertRequest struct {
+	InputPath    string `json:"input_path"`
+	SourceFormat string `json:"source_format"`
+	TargetFormat string `json:"target_format"`
+	OutputPath   string `json:"output_path"`
+}
+
+// apiError 是本地 API 的错误响应
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// errBadRequest 表示请求体不合法或缺少必需的字段，对应 400
+var errBadRequest = errors.New("bad request")
+
+// ServeLocalAPI 在 addr 上启动本地 HTTP API，供其他进程在没有界面的情况下调用检测、推送、拉取、转换和状态查询。
+// addr 必须是回环地址（如 127.0.0.1:8765），每个请求都要带 Authorization: Bearer <token>。该调用会一直阻塞直到服务停止
+func (as *AppService) ServeLocalAPI(addr, token string) error {
+	if token == "" {
+		return fmt.Errorf("local API token must not be empty")
+	}
+	if err := checkLoopbackAddr(addr); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newLocalAPIHandler(as, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	println(fmt.Sprintf("Local API listening on http://%s", addr))
+	return server.ListenAndServe()
+}
+
+// checkLoopbackAddr 确认 addr 只监听本机回环地址
+func checkLoopbackAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid local API address %q: %w", addr, err)
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("local API address %q must be bound to localhost", addr)
+	}
+	return nil
+}
+
+// isLoopbackHost 判断主机名是否为 localhost 或回环 IP
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newLocalAPIHandler 返回本地 API 的路由，所有请求都先经过 token 和 Host 校验
+func newLocalAPIHandler(as *AppService, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/agents", apiRoute(http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return as.DetectAgents()
+	}))
+	mux.HandleFunc("/api/status", apiRoute(http.MethodGet, func(r *http.Request) (interface{}, error) {
+		return as.GetSyncStatus()
+	}))
+	mux.HandleFunc("/api/push", apiRoute(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		var req apiPushRequest
+		if err := decodeAPIRequest(r, &req); err != nil {
+			return nil, err
+		}
+		if req.AgentID != "" {
+			return map[string]string{"status": "pushed"}, as.PushAgentToGist(req.AgentID)
+		}
+		return map[string]string{"status": "pushed"}, as.PushAllAgentsToGist()
+	}))
+	mux.HandleFunc("/api/pull", apiRoute(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		var req apiPullRequest
+		if err := decodeAPIRequest(r, &req); err != nil {
+			return nil, err
+		}
+		switch {
+		case req.AgentID != "" && req.Merge:
+			return nil, fmt.Errorf("%w: merge is not supported for a single agent", errBadRequest)
+		case req.AgentID != "":
+			return map[string]string{"status": "pulled"}, as.PullAgentFromGist(req.AgentID)
+		case req.Merge:
+			return as.PullFromGistMerge()
+		}
+		return as.PullFromGist()
+	}))
+	mux.HandleFunc("/api/convert", apiRoute(http.MethodPost, func(r *http.Request) (interface{}, error) {
+		var req apiConvertRequest
+		if err := decodeAPIRequest(r, &req); err != nil {
+			return nil, err
+		}
+		if req.InputPath == "" || req.OutputPath == "" {
+			return nil, fmt.Errorf("%w: input_path and output_path are required", errBadRequest)
+		}
+		if err := as.ConvertFile(req.InputPath, req.SourceFormat, req.TargetFormat, req.OutputPath); err != nil {
+			return nil, err
+		}
+		return map[string]string{"status": "converted", "output_path": req.OutputPath}, nil
+	}))
+	return requireAPIToken(token, mux)
+}
+
+// requireAPIToken 拒绝 Host 不是本机（防止 DNS rebinding）或没有正确 Bearer token 的请求
+func requireAPIToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !isLoopbackHost(host) {
+			writeAPIJSON(w, http.StatusForbidden, apiError{Error: "requests must be addressed to localhost"})
+			return
+		}
+
+		given, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !bearer || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIJSON(w, http.StatusUnauthorized, apiError{Error: "missing or invalid API token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRoute 把只接受 method 的处理函数包装为 HTTP handler：结果写为 JSON，错误按类别映射为状态码
+func apiRoute(method string, handle func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAPIJSON(w, http.StatusMethodNotAllowed, apiError{Error: fmt.Sprintf("method %s not allowed", r.Method)})
+			return
+		}
+		result, err := handle(r)
+		if err != nil {
+			writeAPIJSON(w, apiErrorStatus(err), apiError{Error: err.Error()})
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, result)
+	}
+}
+
+// decodeAPIRequest 解析 JSON 请求体到 v；空请求体表示使用默认值，未知字段和多余内容视为错误
+func decodeAPIRequest(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("%w: invalid JSON body: %v", errBadRequest, err)
+	}
+	if dec.More() {
+		return fmt.Errorf("%w: unexpected data after the JSON body", errBadRequest)
+	}
+	return nil
+}
+
+// apiErrorStatus 把错误类别映射为 HTTP 状态码；GitHub 凭据问题用 403，以免与本地 API 自身的 401 混淆
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errBadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrTokenMissingGistScope), errors.Is(err, ErrGistWrongOwner):
+		return http.StatusForbidden
+	case errors.Is(err, ErrSchemaValidation), errors.Is(err, ErrValidationFailed), errors.Is(err, ErrLimitExceeded), errors.Is(err, ErrInvalidPayload),
+		errors.Is(err, ErrEncryptionRequired), errors.Is(err, ErrDecryptFailed):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GetSyncStatus 返回同步配置的概况和最近一次凭据检查的结果，不包含 token
+func (as *AppService) GetSyncStatus() (*models.SyncStatus, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+	status := &models.SyncStatus{
+		GistConfigured:    config.GistID != "",
+		GistID:            config.GistID,
+		EncryptionEnabled: config.EnableEncryption,
+		AutoSync:          config.AutoSync,
+		LastSyncTime:      config.LastSyncTime,
+		LastSyncStatus:    config.LastSyncStatus,
+		Credentials:       as.GetCredentialStatus(),
+	}
+	if until := as.SyncPausedUntil(); !until.IsZero() {
+		status.SyncPausedUntil = &until
+	}
+	return status, nil
+}
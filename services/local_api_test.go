@@ -0,0 +1,188 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mcp-sync/models"
+)
+
+const testAPIToken = "api-secret"
+
+// callLocalAPI sends a request to the local API with the test token and returns the status and body
+func callLocalAPI(t *testing.T, api *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	resp, err := api.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func newTestLocalAPI(t *testing.T, as *AppService) *httptest.Server {
+	t.Helper()
+	api := httptest.NewServer(newLocalAPIHandler(as, testAPIToken))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func TestLocalAPIRequiresToken(t *testing.T) {
+	api := newTestLocalAPI(t, newTestAppService(t))
+
+	for _, auth := range []string{"", "Bearer wrong", testAPIToken} {
+		req, _ := http.NewRequest(http.MethodGet, api.URL+"/api/status", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := api.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, resp.StatusCode)
+		}
+	}
+
+	// Requests addressed to another host name are refused even with the right token
+	req, _ := http.NewRequest(http.MethodGet, api.URL+"/api/status", nil)
+	req.Host = "evil.example.com"
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	resp, err := api.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign Host: status = %d, want 403", resp.StatusCode)
+	}
+
+	if err := checkLoopbackAddr(":8765"); err == nil {
+		t.Error("checkLoopbackAddr(:8765) accepted an address on every interface")
+	}
+	if err := checkLoopbackAddr("127.0.0.1:8765"); err != nil {
+		t.Errorf("checkLoopbackAddr(127.0.0.1:8765) error = %v", err)
+	}
+}
+
+func TestLocalAPIAgentsAndStatus(t *testing.T) {
+	as := newTestAppService(t)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {}}`)
+	connectTestGist(t, as, "token-a", "gist-1")
+	api := newTestLocalAPI(t, as)
+
+	status, body := callLocalAPI(t, api, http.MethodGet, "/api/agents", "")
+	var agents []models.Agent
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &agents) != nil {
+		t.Fatalf("GET /api/agents = %d %s", status, body)
+	}
+	found := false
+	for _, agent := range agents {
+		found = found || agent.ID == "cursor"
+	}
+	if !found {
+		t.Errorf("GET /api/agents did not list cursor: %s", body)
+	}
+
+	status, body = callLocalAPI(t, api, http.MethodGet, "/api/status", "")
+	var syncStatus models.SyncStatus
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &syncStatus) != nil {
+		t.Fatalf("GET /api/status = %d %s", status, body)
+	}
+	if !syncStatus.GistConfigured || syncStatus.GistID != "gist-1" || strings.Contains(body, "token-a") {
+		t.Errorf("GET /api/status = %s", body)
+	}
+
+	if status, _ := callLocalAPI(t, api, http.MethodPost, "/api/status", ""); status != http.StatusMethodNotAllowed {
+		t.Errorf("POST /api/status status = %d, want 405", status)
+	}
+}
+
+func TestLocalAPIPushAndPull(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"remote": map[string]interface{}{"command": "remote-mcp"},
+		}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"local": {"command": "local-mcp"}}}`)
+	api := newTestLocalAPI(t, as)
+
+	// A merge pull keeps the local server and adds the remote one
+	status, body := callLocalAPI(t, api, http.MethodPost, "/api/pull", `{"merge": true}`)
+	var pulled models.PullResult
+	if status != http.StatusOK || json.Unmarshal([]byte(body), &pulled) != nil || pulled.AppliedCount != 1 {
+		t.Fatalf("POST /api/pull = %d %s", status, body)
+	}
+	if text := readFile(t, path); !strings.Contains(text, "remote-mcp") || !strings.Contains(text, "local-mcp") {
+		t.Errorf("cursor config after pull = %s", text)
+	}
+
+	status, body = callLocalAPI(t, api, http.MethodPost, "/api/push", "")
+	if status != http.StatusOK || server.requestCount("PATCH") != 1 {
+		t.Fatalf("POST /api/push = %d %s, PATCH requests = %d", status, body, server.requestCount("PATCH"))
+	}
+	if !strings.Contains(decryptForTest(t, server.fileContent(gistID, "mcp-config.json")), "local-mcp") {
+		t.Error("pushed gist does not contain the local server")
+	}
+
+	// Typed errors map to HTTP statuses
+	if status, body := callLocalAPI(t, api, http.MethodPost, "/api/pull", `{"agent_id": "windsurf"}`); status != http.StatusNotFound {
+		t.Errorf("pull of an agent missing from the gist = %d %s, want 404", status, body)
+	}
+	server.failNext(http.StatusTooManyRequests)
+	if status, body := callLocalAPI(t, api, http.MethodPost, "/api/pull", ""); status != http.StatusTooManyRequests {
+		t.Errorf("pull while rate limited = %d %s, want 429", status, body)
+	}
+	if status, _ := callLocalAPI(t, api, http.MethodPost, "/api/pull", `{"merge": "yes"}`); status != http.StatusBadRequest {
+		t.Errorf("pull with an invalid body status = %d, want 400", status)
+	}
+}
+
+func TestLocalAPIConvert(t *testing.T) {
+	as := newTestAppService(t)
+	api := newTestLocalAPI(t, as)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "mcp.json")
+	output := filepath.Join(dir, "config.toml")
+	os.WriteFile(input, []byte(`{"mcpServers": {"fetch": {"command": "uvx"}}}`), 0644)
+
+	body, _ := json.Marshal(apiConvertRequest{InputPath: input, OutputPath: output})
+	if status, resp := callLocalAPI(t, api, http.MethodPost, "/api/convert", string(body)); status != http.StatusOK {
+		t.Fatalf("POST /api/convert = %d %s", status, resp)
+	}
+	if !strings.Contains(readFile(t, output), "[mcp_servers.fetch]") {
+		t.Errorf("converted file = %s", readFile(t, output))
+	}
+
+	for _, bad := range []string{
+		`{"input_path": ""}`,
+		fmt.Sprintf(`{"input_path": %q, "output_path": %q, "extra": 1}`, input, output),
+		`{"input_path": "a"} {"input_path": "b"}`,
+	} {
+		if status, resp := callLocalAPI(t, api, http.MethodPost, "/api/convert", bad); status != http.StatusBadRequest {
+			t.Errorf("POST /api/convert %s = %d %s, want 400", bad, status, resp)
+		}
+	}
+	if status, _ := callLocalAPI(t, api, http.MethodGet, "/api/convert", ""); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/convert status = %d, want 405", status)
+	}
+}
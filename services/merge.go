@@ -0,0 +1,284 @@
+package services
+
+import (
+	"mcp-sync/models"
+	"reflect"
+	"sort"
+)
+
+// argsHunk is one change a side made to the base args: base[start:end] replaced by lines
+type argsHunk struct {
+	start, end int
+	lines      []string
+}
+
+// diffArgs returns the hunks that turn base into side, using the longest common subsequence
+func diffArgs(base, side []string) []argsHunk {
+	n, m := len(base), len(side)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if base[i] == side[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var hunks []argsHunk
+	i, j := 0, 0
+	hunkI, hunkJ := 0, 0
+	flush := func() {
+		if i > hunkI || j > hunkJ {
+			hunks = append(hunks, argsHunk{start: hunkI, end: i, lines: append([]string{}, side[hunkJ:j]...)})
+		}
+	}
+	for i < n && j < m {
+		switch {
+		case base[i] == side[j]:
+			flush()
+			i++
+			j++
+			hunkI, hunkJ = i, j
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	i, j = n, m
+	flush()
+	return hunks
+}
+
+// mergeArgs performs a three-way merge of an args array. Edits from both sides are combined when
+// they touch different positions of base; it reports false when both sides changed the same position.
+func mergeArgs(base, local, remote []string) ([]string, bool) {
+	localHunks := diffArgs(base, local)
+	remoteHunks := diffArgs(base, remote)
+
+	var hunks []argsHunk
+	for _, lh := range localHunks {
+		duplicate := false
+		for _, rh := range remoteHunks {
+			if lh.start == rh.start && lh.end == rh.end && reflect.DeepEqual(lh.lines, rh.lines) {
+				duplicate = true
+				continue
+			}
+			// Same insertion point or overlapping ranges means both sides edited the same place
+			if lh.start == rh.start || (lh.start < rh.end && rh.start < lh.end) {
+				return nil, false
+			}
+		}
+		if !duplicate {
+			hunks = append(hunks, lh)
+		}
+	}
+	hunks = append(hunks, remoteHunks...)
+	sort.SliceStable(hunks, func(a, b int) bool { return hunks[a].start < hunks[b].start })
+
+	merged := []string{}
+	pos := 0
+	for _, h := range hunks {
+		merged = append(merged, base[pos:h.start]...)
+		merged = append(merged, h.lines...)
+		pos = h.end
+	}
+	merged = append(merged, base[pos:]...)
+	return merged, true
+}
+
+// toStringSlice converts a JSON args value into []string
+func toStringSlice(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, s)
+		}
+		return result, true
+	case nil:
+		return []string{}, true
+	}
+	return nil, false
+}
+
+// mergeValue is a three-way merge of a single value; present flags distinguish a missing key from nil
+func mergeValue(base, local, remote interface{}, inBase, inLocal, inRemote bool) (interface{}, bool, bool) {
+	switch {
+	case inLocal == inRemote && reflect.DeepEqual(local, remote):
+		return local, inLocal, true
+	case inLocal == inBase && reflect.DeepEqual(local, base):
+		return remote, inRemote, true
+	case inRemote == inBase && reflect.DeepEqual(remote, base):
+		return local, inLocal, true
+	}
+	return nil, false, false
+}
+
+// mergeFields three-way merges two maps key by key; nested maps such as env are merged recursively
+// and args arrays are merged element-wise
+func mergeFields(base, local, remote map[string]interface{}) (map[string]interface{}, bool) {
+	result := make(map[string]interface{})
+	for _, key := range unionKeys(unionMap(base, local), remote) {
+		b, inB := base[key]
+		l, inL := local[key]
+		r, inR := remote[key]
+
+		if value, present, ok := mergeValue(b, l, r, inB, inL, inR); ok {
+			if present {
+				result[key] = value
+			}
+			continue
+		}
+
+		// Both sides changed this key differently; try a finer-grained merge
+		if key == "args" && inL && inR {
+			bArgs, okB := toStringSlice(b)
+			lArgs, okL := toStringSlice(l)
+			rArgs, okR := toStringSlice(r)
+			if okB && okL && okR {
+				if merged, ok := mergeArgs(bArgs, lArgs, rArgs); ok {
+					result[key] = merged
+					continue
+				}
+			}
+			return nil, false
+		}
+
+		bMap, okB := b.(map[string]interface{})
+		lMap, okL := l.(map[string]interface{})
+		rMap, okR := r.(map[string]interface{})
+		if !inB {
+			bMap, okB = map[string]interface{}{}, true
+		}
+		if okB && okL && okR {
+			if merged, ok := mergeFields(bMap, lMap, rMap); ok {
+				result[key] = merged
+				continue
+			}
+		}
+		return nil, false
+	}
+	return result, true
+}
+
+// mergeServerMaps three-way merges two server maps (name -> server config). Without a base
+// (nil), servers that differ between local and remote are reported as conflicts as a whole.
+// Conflicting servers are left out of the result and returned by name.
+func mergeServerMaps(base, local, remote map[string]interface{}) (map[string]interface{}, []string) {
+	result := make(map[string]interface{})
+	var conflicts []string
+
+	for _, name := range unionKeys(unionMap(base, local), remote) {
+		b, inB := base[name]
+		l, inL := local[name]
+		r, inR := remote[name]
+
+		if value, present, ok := mergeValue(b, l, r, inB, inL, inR); ok {
+			if present {
+				result[name] = value
+			}
+			continue
+		}
+
+		bMap, okB := b.(map[string]interface{})
+		lMap, okL := l.(map[string]interface{})
+		rMap, okR := r.(map[string]interface{})
+		if inB && inL && inR && okB && okL && okR {
+			if merged, ok := mergeFields(bMap, lMap, rMap); ok {
+				result[name] = merged
+				continue
+			}
+		}
+		conflicts = append(conflicts, name)
+	}
+
+	return result, conflicts
+}
+
+// mergeAgentConfigs three-way merges complete agent configs (agent ID -> {configKey: servers}).
+// Conflicts are reported as "agent/server".
+func mergeAgentConfigs(base, local, remote map[string]interface{}) (map[string]interface{}, []string) {
+	result := make(map[string]interface{})
+	var conflicts []string
+
+	for _, agentID := range unionKeys(unionMap(base, local), remote) {
+		bAgent, _ := base[agentID].(map[string]interface{})
+		lAgent, _ := local[agentID].(map[string]interface{})
+		rAgent, _ := remote[agentID].(map[string]interface{})
+
+		merged := make(map[string]interface{})
+		for _, key := range unionKeys(unionMap(bAgent, lAgent), rAgent) {
+			servers, sectionConflicts := mergeServerMaps(asMap(bAgent[key], base != nil), asMap(lAgent[key], true), asMap(rAgent[key], true))
+			for _, name := range sectionConflicts {
+				conflicts = append(conflicts, agentID+"/"+name)
+			}
+			if len(servers) > 0 || len(sectionConflicts) > 0 {
+				merged[key] = servers
+			}
+		}
+		if len(merged) > 0 {
+			result[agentID] = merged
+		}
+	}
+
+	return result, conflicts
+}
+
+// asMap returns value as a map, or an empty map; when present is false it returns nil (no base)
+func asMap(value interface{}, present bool) map[string]interface{} {
+	if !present {
+		return nil
+	}
+	if m, ok := value.(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
+
+// unionMap returns a map holding the keys of both maps (values are irrelevant)
+func unionMap(a, b map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(a)+len(b))
+	for k := range a {
+		result[k] = true
+	}
+	for k := range b {
+		result[k] = true
+	}
+	return result
+}
+
+// diffFields compares two server configs field by field. Nested maps such as env are compared
+// key by key and reported as "parent.key"; other values, including args, are compared as a whole.
+func diffFields(prefix string, a, b map[string]interface{}) []models.FieldDifference {
+	var diffs []models.FieldDifference
+	for _, key := range unionKeys(a, b) {
+		av, inA := a[key]
+		bv, inB := b[key]
+		if inA == inB && jsonEqual(av, bv) {
+			continue
+		}
+
+		aMap, okA := av.(map[string]interface{})
+		bMap, okB := bv.(map[string]interface{})
+		if okA && okB {
+			diffs = append(diffs, diffFields(prefix+key+".", aMap, bMap)...)
+			continue
+		}
+		diffs = append(diffs, models.FieldDifference{Field: prefix + key, A: av, B: bv})
+	}
+	return diffs
+}
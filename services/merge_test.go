@@ -0,0 +1,124 @@
+package services
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMergeArgs(t *testing.T) {
+	base := []string{"-y", "server", "/path/a"}
+
+	tests := []struct {
+		name   string
+		local  []string
+		remote []string
+		want   []string
+		wantOK bool
+	}{
+		{
+			name:   "flag added and path changed",
+			local:  []string{"-y", "server", "/path/a", "--verbose"},
+			remote: []string{"-y", "server", "/path/b"},
+			want:   []string{"-y", "server", "/path/b", "--verbose"},
+			wantOK: true,
+		},
+		{
+			name:   "edits at both ends",
+			local:  []string{"--debug", "-y", "server", "/path/a"},
+			remote: []string{"-y", "server", "/path/a", "--port", "8080"},
+			want:   []string{"--debug", "-y", "server", "/path/a", "--port", "8080"},
+			wantOK: true,
+		},
+		{
+			name:   "removal and unrelated change",
+			local:  []string{"server", "/path/a"},
+			remote: []string{"-y", "server", "/path/b"},
+			want:   []string{"server", "/path/b"},
+			wantOK: true,
+		},
+		{
+			name:   "identical edits",
+			local:  []string{"-y", "server", "/path/c"},
+			remote: []string{"-y", "server", "/path/c"},
+			want:   []string{"-y", "server", "/path/c"},
+			wantOK: true,
+		},
+		{
+			name:   "same index diverges",
+			local:  []string{"-y", "server", "/path/c"},
+			remote: []string{"-y", "server", "/path/b"},
+			wantOK: false,
+		},
+		{
+			name:   "different flags appended at the same position",
+			local:  []string{"-y", "server", "/path/a", "--verbose"},
+			remote: []string{"-y", "server", "/path/a", "--quiet"},
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mergeArgs(base, tt.local, tt.remote)
+			if ok != tt.wantOK {
+				t.Fatalf("mergeArgs() ok = %v, want %v (got %v)", ok, tt.wantOK, got)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeServerMaps(t *testing.T) {
+	server := func(command string, args ...interface{}) map[string]interface{} {
+		return map[string]interface{}{"command": command, "args": args}
+	}
+	base := map[string]interface{}{
+		"fs":      server("npx", "-y", "fs", "/home"),
+		"removed": server("old"),
+	}
+	local := map[string]interface{}{
+		"fs":        server("npx", "-y", "fs", "/home", "--readonly"),
+		"local-new": server("local"),
+	}
+	remote := map[string]interface{}{
+		"fs":         server("npx", "-y", "fs", "/work"),
+		"removed":    server("old"),
+		"remote-new": server("remote"),
+	}
+
+	merged, conflicts := mergeServerMaps(base, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %v", conflicts)
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"fs", "local-new", "remote-new"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("merged servers = %v, want %v", names, want)
+	}
+	if args := merged["fs"].(map[string]interface{})["args"]; !reflect.DeepEqual(args, []string{"-y", "fs", "/work", "--readonly"}) {
+		t.Errorf("fs args = %v", args)
+	}
+}
+
+func TestMergeServerMapsConflicts(t *testing.T) {
+	local := map[string]interface{}{"fs": map[string]interface{}{"command": "npx", "args": []interface{}{"/a", "--x"}}}
+	remote := map[string]interface{}{"fs": map[string]interface{}{"command": "npx", "args": []interface{}{"/b"}}}
+
+	// Without an ancestor the whole server conflicts
+	if _, conflicts := mergeServerMaps(nil, local, remote); !reflect.DeepEqual(conflicts, []string{"fs"}) {
+		t.Errorf("conflicts without base = %v, want [fs]", conflicts)
+	}
+
+	// With an ancestor, both sides editing the same arg still conflicts
+	base := map[string]interface{}{"fs": map[string]interface{}{"command": "npx", "args": []interface{}{"/base"}}}
+	if _, conflicts := mergeServerMaps(base, local, remote); !reflect.DeepEqual(conflicts, []string{"fs"}) {
+		t.Errorf("conflicts with overlapping args = %v, want [fs]", conflicts)
+	}
+}
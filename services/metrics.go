@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// Metrics 累计同步指标并保存到数据目录下的 metrics.json，只在本地使用，不进行任何网络请求
+type Metrics struct {
+	mu      sync.Mutex
+	storage *StorageService
+}
+
+// NewMetrics 创建使用 storage 持久化的指标收集器
+func NewMetrics(storage *StorageService) *Metrics {
+	return &Metrics{storage: storage}
+}
+
+// RecordSync 记录一次推送（direction 为 "push"）或拉取（"pull"）的结果、内容字节数和耗时
+func (m *Metrics) RecordSync(direction string, bytes int, duration time.Duration, syncErr error) error {
+	return m.update(func(metrics *models.SyncMetrics) {
+		switch {
+		case syncErr != nil:
+			metrics.Failures++
+		case direction == "push":
+			metrics.Pushes++
+			metrics.BytesPushed += int64(bytes)
+		default:
+			metrics.Pulls++
+			metrics.BytesPulled += int64(bytes)
+		}
+
+		ms := float64(duration) / float64(time.Millisecond)
+		metrics.SyncCount++
+		metrics.TotalSyncDurationMs += ms
+		metrics.LastSyncDurationMs = ms
+		metrics.AverageSyncDurationMs = metrics.TotalSyncDurationMs / float64(metrics.SyncCount)
+	})
+}
+
+// RecordConflict 记录一次检测到的同步冲突
+func (m *Metrics) RecordConflict() error {
+	return m.update(func(metrics *models.SyncMetrics) {
+		metrics.Conflicts++
+	})
+}
+
+// Get 返回当前累计的指标
+func (m *Metrics) Get() (models.SyncMetrics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.storage.LoadMetrics()
+}
+
+// Reset 清零所有指标，并从现在开始重新统计
+func (m *Metrics) Reset() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.storage.clock.Now()
+	return m.storage.SaveMetrics(models.SyncMetrics{Since: now, UpdatedAt: now})
+}
+
+func (m *Metrics) update(fn func(*models.SyncMetrics)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics, err := m.storage.LoadMetrics()
+	if err != nil {
+		// A corrupt metrics file only loses counters; start over rather than failing syncs
+		println(fmt.Sprintf("Warning: resetting unreadable metrics: %v", err))
+		metrics = models.SyncMetrics{}
+	}
+
+	now := m.storage.clock.Now()
+	if metrics.Since.IsZero() {
+		metrics.Since = now
+	}
+	fn(&metrics)
+	metrics.UpdatedAt = now
+	return m.storage.SaveMetrics(metrics)
+}
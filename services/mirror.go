@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"mcp-sync/models"
+)
+
+// MirrorFromAgent 把源 agent 的 MCP 服务器复制到每个目标 agent，按目标的格式转换后写入，写入前先备份目标配置。
+// removeExtra 为 true 时目标与源完全一致（删除源中没有的服务器）；为 false 时只添加或覆盖同名服务器，目标多出的服务器保留。
+// 每个目标的失败记录在返回的 map 中（agent ID -> 错误），不影响其他目标；源配置无法读取时返回 error
+func (as *AppService) MirrorFromAgent(sourceAgentID string, targetAgentIDs []string, removeExtra bool) (map[string]error, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("mirror_agents")()
+
+	if as.configLoader.GetAgentDefinition(sourceAgentID) == nil {
+		return nil, fmt.Errorf("unknown agent: %s", sourceAgentID)
+	}
+	sourceServers, err := as.standardAgentServers(sourceAgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error)
+	var written []string
+	for _, targetID := range targetAgentIDs {
+		if targetID == sourceAgentID {
+			continue
+		}
+		changed, err := as.mirrorToAgent(sourceServers, targetID, removeExtra)
+		if err != nil {
+			results[targetID] = err
+			continue
+		}
+		if changed {
+			written = append(written, targetID)
+		}
+	}
+
+	mode := "additive"
+	if removeExtra {
+		mode = "mirror"
+	}
+	status := "success"
+	if len(results) > 0 {
+		status = "failed"
+	}
+	sort.Strings(written)
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "mirror",
+		Status:    status,
+		Message: fmt.Sprintf("Copied %d servers from %s (%s): updated %d agents, %d failed",
+			len(sourceServers), sourceAgentID, mode, len(written), len(results)),
+		Details: strings.Join(written, ","),
+	})
+
+	return results, nil
+}
+
+// mirrorToAgent 把标准格式的 sourceServers 写入目标 agent，报告是否写入；目标已经一致时不写入也不备份
+func (as *AppService) mirrorToAgent(sourceServers map[string]interface{}, targetID string, removeExtra bool) (bool, error) {
+	if as.configLoader.GetAgentDefinition(targetID) == nil {
+		return false, fmt.Errorf("unknown agent: %s", targetID)
+	}
+
+	current, err := as.standardAgentServers(targetID)
+	if err != nil {
+		// A target without a config file yet starts empty
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		current = map[string]interface{}{}
+	}
+
+	servers := make(map[string]interface{}, len(sourceServers)+len(current))
+	if !removeExtra {
+		for name, server := range current {
+			servers[name] = server
+		}
+	}
+	for name, server := range sourceServers {
+		servers[name] = server
+	}
+	if jsonEqual(servers, current) {
+		return false, nil
+	}
+
+	if _, err := as.backupAgentConfig(targetID); err != nil {
+		return false, fmt.Errorf("failed to back up %s: %w", targetID, err)
+	}
+	// servers are in the standard structure; keyed by mcpServers so they are not read as Zed servers
+	if err := as.SaveAgentMCPConfig(targetID, map[string]interface{}{"mcpServers": servers}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"mcp-sync/models"
+)
+
+// Gist 中的 agents 是所有机器共用的基础配置，overlays 按环境名保存每台机器的差异（例如工作电脑和家里电脑的路径不同）。
+// 覆盖层与 agents 结构相同，按 JSON Merge Patch（RFC 7386）叠加：对象逐键合并，其他值整体替换，null 删除该键。
+// 拉取时本机看到的是基础配置叠加本机覆盖层的结果；推送时覆盖层设置的值不会写回基础配置，
+// 这些键在基础配置中保持原值，覆盖层只能通过 SetEnvironmentOverlay 修改。
+
+// applyOverlay 返回 patch 按 JSON Merge Patch 叠加到 base 后的结果，不修改传入的 map
+func applyOverlay(base, patch map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(patch))
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		if patchMap, ok := value.(map[string]interface{}); ok {
+			baseMap, _ := result[key].(map[string]interface{})
+			result[key] = applyOverlay(baseMap, patchMap)
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// removeOverlay 是 applyOverlay 的逆操作：返回 local（叠加过 patch 的本机配置）中 patch 设置的键恢复为 base 中的值后的结果。
+// 本机删除了的对象不会从 base 恢复，这样删除服务器仍能推送出去
+func removeOverlay(local, patch, base map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(local))
+	for key, value := range local {
+		result[key] = value
+	}
+	for key, value := range patch {
+		localValue, inLocal := local[key]
+		baseValue, inBase := base[key]
+
+		if patchMap, ok := value.(map[string]interface{}); ok {
+			localMap, isMap := localValue.(map[string]interface{})
+			if !inLocal || !isMap {
+				continue
+			}
+			baseMap, _ := baseValue.(map[string]interface{})
+			stripped := removeOverlay(localMap, patchMap, baseMap)
+			if len(stripped) == 0 && !inBase {
+				// Only the overlay added this object
+				delete(result, key)
+			} else {
+				result[key] = stripped
+			}
+			continue
+		}
+
+		// The overlay owns this value; the base keeps its own
+		if inBase {
+			result[key] = baseValue
+		} else {
+			delete(result, key)
+		}
+	}
+	return result
+}
+
+// environmentName 返回本机的环境名：用户设置的名称，未设置时使用主机名
+func environmentName(config models.SyncConfig) string {
+	if name := strings.TrimSpace(config.EnvironmentName); name != "" {
+		return name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return ""
+}
+
+// GetEnvironmentName 返回本机用于选择覆盖层的环境名
+func (as *AppService) GetEnvironmentName() (string, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load sync config: %w", err)
+	}
+	return environmentName(config), nil
+}
+
+// SetEnvironmentName 设置本机的环境名，之后的拉取叠加 Gist 中同名的覆盖层；name 为空时恢复为主机名
+func (as *AppService) SetEnvironmentName(name string) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.EnvironmentName = strings.TrimSpace(name)
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save environment name: %w", err)
+	}
+
+	if as.gistSync != nil {
+		gs := as.gistSync.WithCredentials(as.gistSync.githubToken, as.gistSync.gistID)
+		gs.SetEnvironment(environmentName(config))
+		as.gistSync = gs
+	}
+	return nil
+}
+
+// GetEnvironmentOverlays 返回 Gist 中所有环境的覆盖层（环境名 -> agent ID -> 配置片段）
+func (as *AppService) GetEnvironmentOverlays() (map[string]map[string]interface{}, error) {
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+	overlays, err := gs.Overlays()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlays: %w", err)
+	}
+	return overlays, nil
+}
+
+// SetEnvironmentOverlay 保存环境 name 的覆盖层（agent ID -> 要叠加的配置片段），overlay 为空时删除该覆盖层。
+// 只修改 Gist，各机器在下一次拉取时应用
+func (as *AppService) SetEnvironmentOverlay(name string, overlay map[string]interface{}) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("environment name is required")
+	}
+	for agentID, patch := range overlay {
+		if as.configLoader.GetAgentDefinition(agentID) == nil {
+			return fmt.Errorf("unknown agent: %s", agentID)
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return fmt.Errorf("overlay for %s must be an object", agentID)
+		}
+	}
+
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return err
+	}
+	if err := gs.SetOverlay(name, overlay); err != nil {
+		return fmt.Errorf("failed to save overlay %s: %w", name, err)
+	}
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "overlay",
+		Status:    "success",
+		Message:   fmt.Sprintf("Updated overlay for environment %s (%d agents)", name, len(overlay)),
+	})
+	return nil
+}
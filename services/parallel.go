@@ -0,0 +1,43 @@
+package services
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// maxParallelAgentWrites 是同时写入的 agent 数量上限；配置目录可能在慢速磁盘或网络挂载上，并行可以缩短总耗时
+const maxParallelAgentWrites = 4
+
+// forEachAgentParallel 用最多 maxParallelAgentWrites 个 goroutine 对每个 agent 调用 fn，返回每个 agent 的结果（成功时为 nil）。
+// 多个 agent 共用同一配置文件时由 auditedWrite 的文件锁保证依次写入
+func forEachAgentParallel(agentIDs []string, fn func(agentID string) error) map[string]error {
+	results := make(map[string]error, len(agentIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxParallelAgentWrites)
+	for _, agentID := range agentIDs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(agentID string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			err := fn(agentID)
+			mu.Lock()
+			results[agentID] = err
+			mu.Unlock()
+		}(agentID)
+	}
+	wg.Wait()
+	return results
+}
+
+// lockConfigFile 锁定配置文件 path 并返回解锁函数；同一文件（按清理后的绝对路径）的写入互斥
+func (as *AppService) lockConfigFile(path string) func() {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	lock, _ := as.fileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
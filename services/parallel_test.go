@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// parallelTestAgents are JSON agents with distinct config files that the tests install
+var parallelTestAgents = []string{"cursor", "windsurf", "claude-code", "zed"}
+
+func installParallelTestAgents(t testing.TB, as *AppService) map[string]string {
+	t.Helper()
+	paths := make(map[string]string)
+	for _, agentID := range parallelTestAgents {
+		content := `{"mcpServers": {}}`
+		if agentID == "zed" {
+			content = `{"context_servers": {}}`
+		}
+		paths[agentID] = writeAgentFile(t, as, agentID, content)
+	}
+	return paths
+}
+
+// Run with -race: every agent is written from its own goroutine
+func TestApplyConfigToAllAgentsWritesAgentsInParallel(t *testing.T) {
+	as := newTestAppService(t)
+	paths := installParallelTestAgents(t, as)
+
+	servers := []models.MCPServer{{ID: "fetch", Name: "fetch", Command: "uvx", Args: []string{"mcp-server-fetch"}, Enabled: true}}
+	results, err := as.ApplyConfigToAllAgents(servers)
+	if err != nil {
+		t.Fatalf("ApplyConfigToAllAgents() error = %v", err)
+	}
+	for _, agentID := range parallelTestAgents {
+		if err, ok := results[agentID]; !ok || err != nil {
+			t.Errorf("result for %s = %v (present %v)", agentID, err, ok)
+		}
+		if text := readFile(t, paths[agentID]); !strings.Contains(text, "mcp-server-fetch") {
+			t.Errorf("%s config was not written:\n%s", agentID, text)
+		}
+	}
+
+	entries, err := as.GetAuditLog(100)
+	if err != nil || len(entries) != len(parallelTestAgents) {
+		t.Errorf("GetAuditLog() = %d entries, %v; want one per agent", len(entries), err)
+	}
+}
+
+func TestForEachAgentParallelBoundsWorkersAndLocksFiles(t *testing.T) {
+	as := newTestAppService(t)
+	shared := filepath.Join(t.TempDir(), "shared.json")
+
+	var running, peak, inShared int32
+	var agentIDs []string
+	for i := 0; i < 12; i++ {
+		agentIDs = append(agentIDs, fmt.Sprintf("agent-%d", i))
+	}
+	results := forEachAgentParallel(agentIDs, func(agentID string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		// Every agent writes the same file; the lock must let only one in at a time
+		unlock := as.lockConfigFile(shared)
+		defer unlock()
+		if atomic.AddInt32(&inShared, 1) != 1 {
+			t.Errorf("%s entered the shared file while another agent held it", agentID)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&inShared, -1)
+
+		if agentID == "agent-3" {
+			return fmt.Errorf("disk full")
+		}
+		return nil
+	})
+
+	if len(results) != len(agentIDs) || results["agent-3"] == nil || results["agent-0"] != nil {
+		t.Errorf("results = %v", results)
+	}
+	if peak > maxParallelAgentWrites {
+		t.Errorf("%d agents ran at once, want at most %d", peak, maxParallelAgentWrites)
+	}
+}
+
+func TestPullFromGistAppliesAgentsInParallel(t *testing.T) {
+	remote := make(map[string]interface{})
+	for _, agentID := range []string{"cursor", "windsurf", "claude-code"} {
+		remote[agentID] = map[string]interface{}{"mcpServers": map[string]interface{}{
+			agentID + "-server": map[string]interface{}{"command": agentID + "-mcp"},
+		}}
+	}
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, remote, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	paths := installParallelTestAgents(t, as)
+
+	if _, err := as.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+	for _, agentID := range []string{"cursor", "windsurf", "claude-code"} {
+		if text := readFile(t, paths[agentID]); !strings.Contains(text, agentID+"-mcp") {
+			t.Errorf("%s config was not pulled:\n%s", agentID, text)
+		}
+	}
+}
+
+func BenchmarkApplyConfigToAllAgents(b *testing.B) {
+	as := newTestAppService(b)
+	installParallelTestAgents(b, as)
+	servers := []models.MCPServer{{ID: "fetch", Name: "fetch", Command: "uvx", Args: []string{"mcp-server-fetch"}, Enabled: true}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := as.ApplyConfigToAllAgents(servers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DataDirEnv 是指定数据目录的环境变量。设置后所有本地状态（同步配置、版本、日志、备份以及
+// Windows 上的密钥文件）都保存在该目录下，其他平台上的密钥环条目也按该目录区分，可以运行多个互相隔离的实例
+const DataDirEnv = "MCP_SYNC_HOME"
+
+// userHomeDir 返回用户主目录，优先使用 USERPROFILE (Windows)，其次是 HOME (Unix/Linux/macOS)
+func userHomeDir() string {
+	if homeDir := os.Getenv("USERPROFILE"); homeDir != "" {
+		return homeDir
+	}
+	return os.Getenv("HOME")
+}
+
+// DataDir 返回 mcp-sync 的数据目录：设置了 MCP_SYNC_HOME 时使用它，否则为 ~/.mcp-sync
+func DataDir() (string, error) {
+	if dir := os.Getenv(DataDirEnv); dir != "" {
+		return filepath.Abs(dir)
+	}
+	homeDir := userHomeDir()
+	if homeDir == "" {
+		return "", fmt.Errorf("could not determine user home directory, set %s to choose a data directory", DataDirEnv)
+	}
+	return filepath.Join(homeDir, ".mcp-sync"), nil
+}
+
+// keyringServiceName 返回系统密钥环中保存主密钥和 secret 的服务名。设置了 MCP_SYNC_HOME 时在 macOS 和 Linux 上
+// 加上数据目录的哈希，隔离的实例各自使用一把密钥；Windows 的密钥文件本身已经在数据目录下，服务名保持不变
+func keyringServiceName() string {
+	if os.Getenv(DataDirEnv) == "" || runtime.GOOS == "windows" {
+		return "mcp-sync"
+	}
+	dataDir, err := DataDir()
+	if err != nil {
+		return "mcp-sync"
+	}
+	sum := sha256.Sum256([]byte(dataDir))
+	return "mcp-sync-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// keyringFileDir 返回 Windows 上保存 DPAPI 加密密钥文件的目录：设置了 MCP_SYNC_HOME 时为数据目录下的 keyring，
+// 否则为 %APPDATA%\mcp-sync\keyring
+func keyringFileDir() (string, error) {
+	if os.Getenv(DataDirEnv) != "" {
+		dataDir, err := DataDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dataDir, "keyring"), nil
+	}
+
+	dir := os.Getenv("APPDATA")
+	if dir == "" {
+		return "", fmt.Errorf("APPDATA environment variable not set")
+	}
+	return filepath.Join(dir, "mcp-sync", "keyring"), nil
+}
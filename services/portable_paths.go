@@ -0,0 +1,211 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// homeAnchor 是始终可用的路径锚点，对应本机主目录
+const homeAnchor = "HOME"
+
+// anchorNamePattern 是锚点名的格式，与 ${NAME} 环境变量引用相同
+var anchorNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// anchorRefPattern 匹配值开头（或 key= 之后）的 ${NAME} 锚点引用
+var anchorRefPattern = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}(/.*)?$`)
+
+// pathAnchor 是一个锚点及其在本机对应的目录
+type pathAnchor struct {
+	name string
+	dir  string
+}
+
+// pathAnchors 返回本机的路径锚点：HOME 加上 PathAnchors 中配置的锚点，按目录从长到短排列，使更具体的锚点优先匹配
+func (as *AppService) pathAnchors() []pathAnchor {
+	var anchors []pathAnchor
+	if home := userHomeDir(); home != "" {
+		anchors = append(anchors, pathAnchor{name: homeAnchor, dir: filepath.Clean(home)})
+	}
+	// Read without configMu: this also runs while applying configs
+	config, _ := as.storage.LoadSyncConfig()
+	for name, dir := range config.PathAnchors {
+		if name == homeAnchor || dir == "" {
+			continue
+		}
+		anchors = append(anchors, pathAnchor{name: name, dir: filepath.Clean(as.configLoader.ExpandPath(dir))})
+	}
+	sort.SliceStable(anchors, func(i, j int) bool { return len(anchors[i].dir) > len(anchors[j].dir) })
+	return anchors
+}
+
+// PortabilizePaths 返回 servers（标准结构）的副本，其中 command、cwd、args 和 env 中位于锚点目录下的绝对路径
+// 被改写为 ${ANCHOR}/相对路径（分隔符统一为 /）。不在任何锚点下的路径保持不变
+func (as *AppService) PortabilizePaths(servers map[string]interface{}) map[string]interface{} {
+	anchors := as.pathAnchors()
+	return mapServerPaths(servers, func(value string) string {
+		return portablePath(value, anchors)
+	})
+}
+
+// MaterializePaths 是 PortabilizePaths 的反向操作：把 ${ANCHOR}/... 还原为本机锚点目录下的绝对路径。
+// 本机没有配置的锚点保持原样
+func (as *AppService) MaterializePaths(servers map[string]interface{}) map[string]interface{} {
+	dirs := make(map[string]string)
+	for _, anchor := range as.pathAnchors() {
+		dirs[anchor.name] = anchor.dir
+	}
+	return mapServerPaths(servers, func(value string) string {
+		return materializedPath(value, dirs)
+	})
+}
+
+// portablePath 改写单个值；值可以是路径本身，也可以是 --flag=路径 的形式
+func portablePath(value string, anchors []pathAnchor) string {
+	prefix, path := splitFlagValue(value)
+	if !filepath.IsAbs(path) {
+		return value
+	}
+	path = filepath.Clean(path)
+	for _, anchor := range anchors {
+		if path == anchor.dir {
+			return prefix + "${" + anchor.name + "}"
+		}
+		if rel, err := filepath.Rel(anchor.dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return prefix + "${" + anchor.name + "}/" + filepath.ToSlash(rel)
+		}
+	}
+	return value
+}
+
+// materializedPath 还原单个值中的锚点引用
+func materializedPath(value string, dirs map[string]string) string {
+	prefix, path := splitFlagValue(value)
+	match := anchorRefPattern.FindStringSubmatch(path)
+	if match == nil {
+		return value
+	}
+	dir, ok := dirs[match[1]]
+	if !ok {
+		return value
+	}
+	if match[2] == "" {
+		return prefix + dir
+	}
+	return prefix + filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(match[2], "/")))
+}
+
+// splitFlagValue 把 --root=/path 拆为 "--root=" 和 "/path"；没有 = 时前缀为空
+func splitFlagValue(value string) (string, string) {
+	if i := strings.IndexByte(value, '='); i > 0 && strings.HasPrefix(value, "-") {
+		return value[:i+1], value[i+1:]
+	}
+	return "", value
+}
+
+// mapServerPaths 返回 servers 的副本，对每个服务器的 command、cwd、args 和 env 中的字符串调用 fn
+func mapServerPaths(servers map[string]interface{}, fn func(string) string) map[string]interface{} {
+	result := make(map[string]interface{}, len(servers))
+	for name, server := range servers {
+		serverMap, ok := server.(map[string]interface{})
+		if !ok {
+			result[name] = server
+			continue
+		}
+		updated := make(map[string]interface{}, len(serverMap))
+		for key, value := range serverMap {
+			updated[key] = value
+		}
+		for _, key := range []string{"command", "cwd"} {
+			if text, ok := serverMap[key].(string); ok {
+				updated[key] = fn(text)
+			}
+		}
+		switch args := serverMap["args"].(type) {
+		case []interface{}:
+			mapped := make([]interface{}, len(args))
+			for i, arg := range args {
+				if text, ok := arg.(string); ok {
+					mapped[i] = fn(text)
+				} else {
+					mapped[i] = arg
+				}
+			}
+			updated["args"] = mapped
+		case []string:
+			mapped := make([]string, len(args))
+			for i, arg := range args {
+				mapped[i] = fn(arg)
+			}
+			updated["args"] = mapped
+		}
+		switch env := serverMap["env"].(type) {
+		case map[string]interface{}:
+			mapped := make(map[string]interface{}, len(env))
+			for key, value := range env {
+				if text, ok := value.(string); ok {
+					mapped[key] = fn(text)
+				} else {
+					mapped[key] = value
+				}
+			}
+			updated["env"] = mapped
+		case map[string]string:
+			mapped := make(map[string]string, len(env))
+			for key, value := range env {
+				mapped[key] = fn(value)
+			}
+			updated["env"] = mapped
+		}
+		result[name] = updated
+	}
+	return result
+}
+
+// mapConfigServers 对 agent 配置中的每个服务器部分（包括停用服务器的记录）调用 fn，返回新的配置
+func mapConfigServers(config map[string]interface{}, fn func(map[string]interface{}) map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		if servers, ok := value.(map[string]interface{}); ok {
+			result[key] = fn(servers)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// portablePathsEnabled 返回是否开启了可移植路径
+func (as *AppService) portablePathsEnabled() bool {
+	config, err := as.storage.LoadSyncConfig()
+	return err == nil && config.PortablePaths
+}
+
+// SetPortablePaths 开启或关闭可移植路径，并设置 HOME 以外的锚点（锚点名到本机目录，目录可以以 ~ 开头）
+func (as *AppService) SetPortablePaths(enabled bool, anchors map[string]string) error {
+	for name := range anchors {
+		if !anchorNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid path anchor name: %q", name)
+		}
+		if name == homeAnchor {
+			return fmt.Errorf("the %s anchor is always the home directory and cannot be changed", homeAnchor)
+		}
+	}
+
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.PortablePaths = enabled
+	config.PathAnchors = anchors
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save portable paths setting: %w", err)
+	}
+	return nil
+}
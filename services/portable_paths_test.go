@@ -0,0 +1,126 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPortabilizeAndMaterializePaths(t *testing.T) {
+	as := newTestAppService(t)
+	home := os.Getenv("HOME")
+	workspace := filepath.Join(t.TempDir(), "code")
+	if err := as.SetPortablePaths(true, map[string]string{"WORKSPACE": workspace}); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := map[string]interface{}{
+		"fs": map[string]interface{}{
+			"command": filepath.Join(home, "bin", "fs-mcp"),
+			"args":    []interface{}{filepath.Join(workspace, "app"), "--root=" + home, "/opt/tool", "-y", 3},
+			"env":     map[string]interface{}{"DATA_DIR": filepath.Join(home, "data"), "LEVEL": "debug"},
+		},
+	}
+	portable := as.PortabilizePaths(servers)
+	want := map[string]interface{}{
+		"fs": map[string]interface{}{
+			"command": "${HOME}/bin/fs-mcp",
+			"args":    []interface{}{"${WORKSPACE}/app", "--root=${HOME}", "/opt/tool", "-y", 3},
+			"env":     map[string]interface{}{"DATA_DIR": "${HOME}/data", "LEVEL": "debug"},
+		},
+	}
+	if !jsonEqual(portable, want) {
+		t.Errorf("PortabilizePaths() = %s, want %s", mustJSON(t, portable), mustJSON(t, want))
+	}
+	if !jsonEqual(as.MaterializePaths(portable), servers) {
+		t.Errorf("MaterializePaths() = %s, want %s", mustJSON(t, as.MaterializePaths(portable)), mustJSON(t, servers))
+	}
+
+	// Anchors this machine doesn't know about are left for the user to fix
+	unknown := map[string]interface{}{"x": map[string]interface{}{"command": "${PROJECTS}/x"}}
+	if !jsonEqual(as.MaterializePaths(unknown), unknown) {
+		t.Errorf("MaterializePaths() changed an unknown anchor: %s", mustJSON(t, as.MaterializePaths(unknown)))
+	}
+
+	if err := as.SetPortablePaths(true, map[string]string{"HOME": "/tmp"}); err == nil {
+		t.Error("SetPortablePaths() accepted a HOME override")
+	}
+	if err := as.SetPortablePaths(true, map[string]string{"my-dir": "/tmp"}); err == nil {
+		t.Error("SetPortablePaths() accepted an invalid anchor name")
+	}
+}
+
+func TestPortablePathsRoundTripBetweenMachines(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	// Machine A pushes a server rooted in its home directory
+	machineA := newTestAppService(t)
+	homeA := os.Getenv("HOME")
+	connectTestGist(t, machineA, "token-a", gistID)
+	if err := machineA.SetPortablePaths(true, nil); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := json.Marshal([]string{"-y", "server-filesystem", filepath.Join(homeA, "projects", "x"), "/opt/shared"})
+	writeAgentFile(t, machineA, "cursor", `{"mcpServers": {"fs": {"command": "npx", "args": `+string(args)+`}}}`)
+	if err := machineA.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, `"${HOME}/projects/x"`) || strings.Contains(pushed, homeA) {
+		t.Fatalf("gist holds a machine-specific path:\n%s", pushed)
+	}
+
+	// Machine B has a different home directory
+	machineB := newTestAppService(t)
+	homeB := os.Getenv("HOME")
+	connectTestGist(t, machineB, "token-a", gistID)
+	if err := machineB.SetPortablePaths(true, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := writeAgentFile(t, machineB, "cursor", `{"mcpServers": {}}`)
+	if _, err := machineB.PullFromGist(); err != nil {
+		t.Fatalf("PullFromGist() error = %v", err)
+	}
+
+	var pulled struct {
+		MCPServers map[string]struct {
+			Args []string `json:"args"`
+		} `json:"mcpServers"`
+	}
+	if err := json.Unmarshal([]byte(readFile(t, path)), &pulled); err != nil {
+		t.Fatal(err)
+	}
+	got := pulled.MCPServers["fs"].Args
+	want := []string{"-y", "server-filesystem", filepath.Join(homeB, "projects", "x"), "/opt/shared"}
+	if !jsonEqual(got, want) {
+		t.Errorf("pulled args = %v, want %v", got, want)
+	}
+}
+
+func TestPushAgentToGistUsesPortablePaths(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	as := newTestAppService(t)
+	home := os.Getenv("HOME")
+	connectTestGist(t, as, "token-a", gistID)
+	if err := as.SetPortablePaths(true, nil); err != nil {
+		t.Fatal(err)
+	}
+	command, _ := json.Marshal(filepath.Join(home, "bin", "fs-mcp"))
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": `+string(command)+`}}}`)
+	if err := as.PushAgentToGist("cursor"); err != nil {
+		t.Fatalf("PushAgentToGist() error = %v", err)
+	}
+
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, `"${HOME}/bin/fs-mcp"`) || strings.Contains(pushed, home) {
+		t.Errorf("single-agent push holds a machine-specific path:\n%s", pushed)
+	}
+}
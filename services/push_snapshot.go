@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxPushReadAttempts 是推送时读取 agent 配置的最多次数：读取期间有文件被外部修改时重新读取，仍然变化则中止推送
+const maxPushReadAttempts = 2
+
+// afterPushRead 在推送读取并校验完 agent 配置、尚未保存版本之前调用；测试用它模拟外部修改
+var afterPushRead = func() {}
+
+// agentFileStamp 是读取时 agent 配置文件的修改时间和内容 hash；文件不存在时两者都为空
+type agentFileStamp struct {
+	path    string
+	modTime time.Time
+	hash    string
+}
+
+// stampAgentFiles 记录这些 agent 配置文件当前的修改时间和 hash
+func (as *AppService) stampAgentFiles(agentIDs []string) map[string]agentFileStamp {
+	stamps := make(map[string]agentFileStamp, len(agentIDs))
+	for _, agentID := range agentIDs {
+		path, err := as.detector.GetAgentConfigPath(agentID)
+		if err != nil {
+			continue
+		}
+		stamp := agentFileStamp{path: path}
+		if info, err := as.storage.fs.Stat(path); err == nil {
+			stamp.modTime = info.ModTime()
+			stamp.hash = fileSHA256(as.storage.fs, path)
+		}
+		stamps[agentID] = stamp
+	}
+	return stamps
+}
+
+// changedAgentFiles 返回自 stamps 记录以来配置文件被修改过的 agent，按字母排序。
+// 修改时间没变的文件不再计算 hash；只改了修改时间、内容相同的文件不算修改
+func changedAgentFiles(fs FileSystem, stamps map[string]agentFileStamp) []string {
+	var changed []string
+	for agentID, stamp := range stamps {
+		var modTime time.Time
+		if info, err := fs.Stat(stamp.path); err == nil {
+			modTime = info.ModTime()
+		}
+		if modTime.Equal(stamp.modTime) {
+			continue
+		}
+		if fileSHA256(fs, stamp.path) != stamp.hash {
+			changed = append(changed, agentID)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// changedDuringPushError 返回配置文件在推送期间持续被修改时的错误
+func changedDuringPushError(changed []string) error {
+	return fmt.Errorf("%w: agent configs changed on disk while pushing: %s", ErrConflict, strings.Join(changed, ", "))
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// onPushRead replaces afterPushRead for the test; edit gets the number of the read that just finished
+func onPushRead(t *testing.T, edit func(read int)) *int {
+	t.Helper()
+	reads := 0
+	previous := afterPushRead
+	afterPushRead = func() {
+		reads++
+		edit(reads)
+	}
+	t.Cleanup(func() { afterPushRead = previous })
+	return &reads
+}
+
+// editExternally rewrites path with content and moves its mtime forward, as an editor saving the file would
+func editExternally(t *testing.T, path, content string, n int) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Duration(n) * time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pushSnapshotFixture(t *testing.T) (*AppService, *stubGistServer, string, string) {
+	t.Helper()
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	path := writeAgentFile(t, as, "cursor", `{"mcpServers": {"first": {"command": "first-mcp"}}}`)
+	return as, server, gistID, path
+}
+
+func TestPushRereadsFileModifiedDuringRead(t *testing.T) {
+	as, server, gistID, path := pushSnapshotFixture(t)
+	reads := onPushRead(t, func(read int) {
+		if read == 1 {
+			editExternally(t, path, `{"mcpServers": {"second": {"command": "second-mcp"}}}`, read)
+		}
+	})
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if *reads != 2 {
+		t.Errorf("configs were read %d times, want 2", *reads)
+	}
+	pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))
+	if !strings.Contains(pushed, "second-mcp") || strings.Contains(pushed, "first-mcp") {
+		t.Errorf("pushed the stale config:\n%s", pushed)
+	}
+}
+
+func TestPushAbortsWhenFileKeepsChanging(t *testing.T) {
+	as, server, _, path := pushSnapshotFixture(t)
+	onPushRead(t, func(read int) {
+		editExternally(t, path, `{"mcpServers": {"edit": {"command": "edit-`+strings.Repeat("x", read)+`"}}}`, read)
+	})
+
+	err := as.PushAllAgentsToGist()
+	if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), "cursor") {
+		t.Fatalf("PushAllAgentsToGist() error = %v, want ErrConflict naming cursor", err)
+	}
+	if server.requestCount("PATCH") != 0 {
+		t.Error("pushed although the config kept changing")
+	}
+	if log := findSyncLog(t, as, "push"); log == nil || log.Status != "failed" {
+		t.Errorf("push log = %+v, want failed", log)
+	}
+}
+
+func TestPushIgnoresTouchedButUnchangedFile(t *testing.T) {
+	as, _, _, path := pushSnapshotFixture(t)
+	content := readFile(t, path)
+	reads := onPushRead(t, func(read int) {
+		editExternally(t, path, content, read)
+	})
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	if *reads != 1 {
+		t.Errorf("configs were read %d times, want 1 for an mtime-only change", *reads)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"mcp-sync/models"
+)
+
+// 推送校验策略，见 SyncConfig.PushValidation
+const (
+	PushValidationSkip   = "skip"
+	PushValidationStrict = "strict"
+)
+
+// ValidateMCPServer 检查标准结构的单个服务器：必须有非空的 command 或 url，args 是列表，env 是对象。返回发现的问题
+func ValidateMCPServer(name string, server interface{}) []string {
+	serverMap, ok := server.(map[string]interface{})
+	if !ok {
+		return []string{fmt.Sprintf("Server %s: invalid config structure", name)}
+	}
+
+	var problems []string
+	command, hasCommand := serverMap["command"]
+	url, hasURL := serverMap["url"]
+	if !hasCommand && !hasURL {
+		problems = append(problems, fmt.Sprintf("Server %s: missing 'command' or 'url' field", name))
+	}
+	for field, value := range map[string]interface{}{"command": command, "url": url} {
+		if _, present := serverMap[field]; !present {
+			continue
+		}
+		if text, ok := value.(string); !ok || strings.TrimSpace(text) == "" {
+			problems = append(problems, fmt.Sprintf("Server %s: '%s' must be a non-empty string", name, field))
+		}
+	}
+	switch serverMap["args"].(type) {
+	case nil, []interface{}, []string:
+	default:
+		problems = append(problems, fmt.Sprintf("Server %s: 'args' must be a list", name))
+	}
+	switch serverMap["env"].(type) {
+	case nil, map[string]interface{}, map[string]string:
+	default:
+		problems = append(problems, fmt.Sprintf("Server %s: 'env' must be an object", name))
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// validateForPush 用 ValidateConfigFormat（agent 自己的格式）和 ValidateMCPServer（标准结构）检查将要推送的每个 agent 配置，
+// 返回每个无效 agent 的问题。Zed 扩展提供的服务器没有 command，不参与检查
+func (as *AppService) validateForPush(agentConfigs map[string]interface{}) map[string][]string {
+	invalid := make(map[string][]string)
+	for agentID, agentConfig := range agentConfigs {
+		config, _ := agentConfig.(map[string]interface{})
+		keyName := as.configLoader.GetConfigKey(agentID)
+		native, _ := config[keyName].(map[string]interface{})
+
+		checked := make(map[string]interface{}, len(native))
+		for name, server := range native {
+			if serverMap, ok := server.(map[string]interface{}); ok && serverMap["source"] == "extension" {
+				continue
+			}
+			checked[name] = server
+		}
+
+		_, problems := as.ValidateConfigFormat(agentID, checked)
+		servers, _ := standardServersFrom(map[string]interface{}{keyName: checked}, keyName)
+		for _, name := range sortedKeys(servers) {
+			problems = append(problems, ValidateMCPServer(name, servers[name])...)
+		}
+		if len(problems) > 0 {
+			invalid[agentID] = problems
+		}
+	}
+	return invalid
+}
+
+// applyPushValidation 按 PushValidation 策略处理校验失败的 agent：skip 时把它们从 agentConfigs 中移除并返回被跳过的 agent，
+// strict 时返回 ErrValidationFailed。所有 agent 都无效时总是中止，避免用空配置覆盖远程。每个无效的 agent 都会写入同步日志
+func (as *AppService) applyPushValidation(agentConfigs map[string]interface{}) ([]string, error) {
+	invalid := as.validateForPush(agentConfigs)
+	if len(invalid) == 0 {
+		return nil, nil
+	}
+
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+	skipped := make([]string, 0, len(invalid))
+	details := make([]string, 0, len(invalid))
+	for _, agentID := range sortedKeysOf(invalid) {
+		skipped = append(skipped, agentID)
+		details = append(details, fmt.Sprintf("%s: %s", agentID, strings.Join(invalid[agentID], "; ")))
+	}
+
+	if config.PushValidation == PushValidationStrict || len(skipped) == len(agentConfigs) {
+		message := fmt.Sprintf("Push aborted, invalid agent configs: %s", strings.Join(details, " | "))
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "push_validation",
+			Status:    "failed",
+			Message:   message,
+		})
+		return nil, fmt.Errorf("%w: %s", ErrValidationFailed, strings.Join(details, " | "))
+	}
+
+	for _, agentID := range skipped {
+		delete(agentConfigs, agentID)
+		println(fmt.Sprintf("Warning: not pushing %s, its config is invalid: %s", agentID, strings.Join(invalid[agentID], "; ")))
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "push_validation",
+		Status:    "skipped",
+		Message:   fmt.Sprintf("Skipped invalid agent configs: %s", strings.Join(details, " | ")),
+	})
+	return skipped, nil
+}
+
+// sortedKeysOf 返回 m 的键，按字母排序
+func sortedKeysOf(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetPushValidation 设置推送时对校验失败的 agent 的处理策略：skip 或 strict
+func (as *AppService) SetPushValidation(policy string) error {
+	if policy != PushValidationSkip && policy != PushValidationStrict {
+		return fmt.Errorf("unknown push validation policy: %s", policy)
+	}
+
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.PushValidation = policy
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save push validation policy: %w", err)
+	}
+	return nil
+}
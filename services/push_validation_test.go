@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateMCPServer(t *testing.T) {
+	tests := []struct {
+		name   string
+		server interface{}
+		want   string // substring of the first problem, empty when valid
+	}{
+		{"stdio", map[string]interface{}{"command": "uvx", "args": []interface{}{"fetch"}, "env": map[string]interface{}{"A": "1"}}, ""},
+		{"remote", map[string]interface{}{"url": "https://example.com/mcp"}, ""},
+		{"no command", map[string]interface{}{"args": []interface{}{"x"}}, "missing 'command' or 'url'"},
+		{"empty command", map[string]interface{}{"command": "  "}, "'command' must be a non-empty string"},
+		{"args not a list", map[string]interface{}{"command": "uvx", "args": "fetch"}, "'args' must be a list"},
+		{"env not an object", map[string]interface{}{"command": "uvx", "env": []interface{}{"A=1"}}, "'env' must be an object"},
+		{"not an object", "uvx fetch", "invalid config structure"},
+	}
+	for _, tt := range tests {
+		problems := ValidateMCPServer(tt.name, tt.server)
+		if tt.want == "" {
+			if len(problems) != 0 {
+				t.Errorf("%s: ValidateMCPServer() = %v, want no problems", tt.name, problems)
+			}
+			continue
+		}
+		if len(problems) == 0 || !strings.Contains(problems[0], tt.want) {
+			t.Errorf("%s: ValidateMCPServer() = %v, want %q", tt.name, problems, tt.want)
+		}
+	}
+}
+
+// pushValidationFixture installs a broken cursor and a valid windsurf config; the gist holds a good cursor config
+func pushValidationFixture(t *testing.T) (*AppService, *stubGistServer, string) {
+	t.Helper()
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"good": map[string]interface{}{"command": "good-mcp"},
+		}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"broken": {"args": ["--flag"]}}}`)
+	writeAgentFile(t, as, "windsurf", `{"mcpServers": {"search": {"command": "search-mcp"}}}`)
+	return as, server, gistID
+}
+
+func TestPushSkipsInvalidAgent(t *testing.T) {
+	as, server, gistID := pushValidationFixture(t)
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+
+	var pushed struct {
+		Agents map[string]interface{} `json:"agents"`
+	}
+	if err := json.Unmarshal([]byte(decryptForTest(t, server.fileContent(gistID, "mcp-config.json"))), &pushed); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mustJSON(t, pushed.Agents["windsurf"]), "search-mcp") {
+		t.Errorf("windsurf was not pushed: %v", pushed.Agents)
+	}
+	// The broken local cursor config is not pushed; the last good remote one stays
+	if cursor := mustJSON(t, pushed.Agents["cursor"]); strings.Contains(cursor, "broken") || !strings.Contains(cursor, "good-mcp") {
+		t.Errorf("pushed cursor config = %s, want the previous remote config", cursor)
+	}
+
+	log := findSyncLog(t, as, "push_validation")
+	if log == nil || log.Status != "skipped" || !strings.Contains(log.Message, "cursor") || !strings.Contains(log.Message, "broken") {
+		t.Errorf("push_validation log = %+v, want a report naming cursor's broken server", log)
+	}
+	if log := findSyncLog(t, as, "push"); log == nil || !strings.Contains(log.Message, "skipped invalid: cursor") {
+		t.Errorf("push log = %+v", log)
+	}
+}
+
+func TestPushAbortsOnInvalidAgentInStrictMode(t *testing.T) {
+	as, server, gistID := pushValidationFixture(t)
+	before := server.fileContent(gistID, "mcp-config.json")
+	if err := as.SetPushValidation(PushValidationStrict); err != nil {
+		t.Fatal(err)
+	}
+
+	err := as.PushAllAgentsToGist()
+	if !errors.Is(err, ErrValidationFailed) || !strings.Contains(err.Error(), "cursor") {
+		t.Fatalf("PushAllAgentsToGist() error = %v, want ErrValidationFailed naming cursor", err)
+	}
+	if server.requestCount("PATCH") != 0 || server.fileContent(gistID, "mcp-config.json") != before {
+		t.Error("strict mode still updated the gist")
+	}
+	if log := findSyncLog(t, as, "push_validation"); log == nil || log.Status != "failed" {
+		t.Errorf("push_validation log = %+v, want failed", log)
+	}
+
+	if err := as.SetPushValidation("lenient"); err == nil {
+		t.Error("SetPushValidation() accepted an unknown policy")
+	}
+}
+
+func TestPushAbortsWhenEveryAgentIsInvalid(t *testing.T) {
+	as, server, _ := pushValidationFixture(t)
+	writeAgentFile(t, as, "windsurf", `{"mcpServers": {"search": {"command": ""}}}`)
+
+	if err := as.PushAllAgentsToGist(); !errors.Is(err, ErrValidationFailed) {
+		t.Fatalf("PushAllAgentsToGist() error = %v, want ErrValidationFailed", err)
+	}
+	if server.requestCount("PATCH") != 0 {
+		t.Error("pushed although no agent config was valid")
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,497 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// schemaCacheTTL 是从 schema_url 下载的 schema 的缓存有效期，过期后重新下载；下载失败时继续使用过期的缓存
+const schemaCacheTTL = 24 * time.Hour
+
+// maxSchemaBytes 限制下载的 schema 大小
+const maxSchemaBytes = 4 << 20
+
+// maxSchemaRefDepth 限制 $ref 的嵌套层数，避免循环引用
+const maxSchemaRefDepth = 64
+
+var schemaHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// ValidateAgainstSchema 用 agents.yaml 中为 agent 配置的 JSON schema（schema_path 或 schema_url）校验它当前的 MCP 配置，返回发现的问题。
+// 校验的是 {config_key: 服务器} 这一部分而不是整个配置文件；agent 没有配置 schema 时返回空列表
+func (as *AppService) ValidateAgainstSchema(agentID string) ([]string, error) {
+	agent := as.configLoader.GetAgentDefinition(agentID)
+	if agent == nil {
+		return nil, fmt.Errorf("unknown agent: %s", agentID)
+	}
+	schema, err := as.agentSchema(agent)
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return []string{}, nil
+	}
+
+	config, err := as.GetAgentMCPConfig(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s config: %w", agentID, err)
+	}
+	return validateJSONSchema(schema, config)
+}
+
+// SetSchemaSafeMode 设置推送前是否按 agent 的 JSON schema 校验配置
+func (as *AppService) SetSchemaSafeMode(enabled bool) error {
+	as.configMu.Lock()
+	defer as.configMu.Unlock()
+
+	config, err := as.storage.LoadSyncConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load sync config: %w", err)
+	}
+	config.SchemaSafeMode = enabled
+	config.LastUpdateTime = nowTime()
+	if err := as.storage.SaveSyncConfig(config); err != nil {
+		return fmt.Errorf("failed to save schema safe mode: %w", err)
+	}
+	return nil
+}
+
+// checkSchemas 在开启 SchemaSafeMode 时校验将要推送的 agent，任何一个不符合 schema 都返回 ErrSchemaValidation
+func (as *AppService) checkSchemas(agentIDs []string) error {
+	config, err := as.GetSyncConfig()
+	if err != nil || !config.SchemaSafeMode {
+		return nil
+	}
+
+	ids := append([]string(nil), agentIDs...)
+	sort.Strings(ids)
+	var problems []string
+	for _, agentID := range ids {
+		agentProblems, err := as.ValidateAgainstSchema(agentID)
+		if err != nil {
+			return fmt.Errorf("schema check for %s failed: %w", agentID, err)
+		}
+		for _, problem := range agentProblems {
+			problems = append(problems, agentID+": "+problem)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrSchemaValidation, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// agentSchema 读取 agent 的 schema；agent 没有配置 schema 时返回 nil
+func (as *AppService) agentSchema(agent *AgentDefinition) (interface{}, error) {
+	var data []byte
+	switch {
+	case agent.SchemaPath != "":
+		var err error
+		data, err = os.ReadFile(as.configLoader.ExpandPath(agent.SchemaPath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema for %s: %w", agent.ID, err)
+		}
+	case agent.SchemaURL != "":
+		var err error
+		data, err = as.fetchSchema(agent.SchemaURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch schema for %s: %w", agent.ID, err)
+		}
+	default:
+		return nil, nil
+	}
+
+	schema, err := parseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema for %s: %w", agent.ID, err)
+	}
+	return schema, nil
+}
+
+// fetchSchema 返回 url 处的 schema，优先使用未过期的本地缓存；缓存按 URL 保存，schema_url 改变后会重新下载
+func (as *AppService) fetchSchema(url string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(url))
+	key := hex.EncodeToString(sum[:])
+
+	cached, savedAt, cacheErr := as.storage.LoadSchemaCache(key)
+	if cacheErr == nil && time.Since(savedAt) < schemaCacheTTL {
+		return cached, nil
+	}
+
+	data, err := downloadSchema(url)
+	if err != nil {
+		if cacheErr == nil {
+			println(fmt.Sprintf("Warning: failed to refresh schema %s, using cached copy: %v", url, err))
+			return cached, nil
+		}
+		return nil, err
+	}
+	if err := as.storage.SaveSchemaCache(key, data); err != nil {
+		println(fmt.Sprintf("Warning: failed to cache schema %s: %v", url, err))
+	}
+	return data, nil
+}
+
+// downloadSchema 下载 url 处的 schema，并确认内容是可用的 schema 后才返回
+func downloadSchema(url string) ([]byte, error) {
+	resp, err := schemaHTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSchemaBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSchemaBytes {
+		return nil, fmt.Errorf("schema is larger than %d bytes", maxSchemaBytes)
+	}
+	if _, err := parseSchema(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// parseSchema 解析 JSON schema 文档，顶层必须是对象或布尔值
+func parseSchema(data []byte) (interface{}, error) {
+	var schema interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	switch schema.(type) {
+	case map[string]interface{}, bool:
+		return schema, nil
+	}
+	return nil, errors.New("schema must be a JSON object or boolean")
+}
+
+// validateJSONSchema 校验 value 是否符合 schema，返回问题列表（按位置排序）。
+// 支持 draft-07 的常用关键字：type、enum、const、properties、required、additionalProperties、patternProperties、
+// items、长度和数值范围、pattern、allOf、anyOf、oneOf、not，以及指向同一文档的 $ref；其他关键字被忽略
+func validateJSONSchema(schema interface{}, value interface{}) ([]string, error) {
+	// Agent configs may hold typed values ([]string args from TOML); compare in plain JSON form
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	v := &schemaValidator{root: schema}
+	v.validate(schema, doc, "", 0)
+	sort.Strings(v.problems)
+	if v.problems == nil {
+		return []string{}, nil
+	}
+	return v.problems, nil
+}
+
+// schemaValidator 收集一次校验中发现的问题
+type schemaValidator struct {
+	root     interface{}
+	problems []string
+}
+
+func (v *schemaValidator) addf(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "(root)"
+	}
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+// matches 报告 value 是否符合 schema，不记录问题（用于 anyOf、oneOf 和 not）
+func (v *schemaValidator) matches(schema, value interface{}, path string, refDepth int) bool {
+	sub := &schemaValidator{root: v.root}
+	sub.validate(schema, value, path, refDepth)
+	return len(sub.problems) == 0
+}
+
+func (v *schemaValidator) validate(schema interface{}, value interface{}, path string, refDepth int) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			v.addf(path, "not allowed")
+		}
+		return
+	case map[string]interface{}:
+		if ref, ok := s["$ref"].(string); ok {
+			if refDepth >= maxSchemaRefDepth {
+				v.addf(path, "$ref %s nested too deeply", ref)
+				return
+			}
+			target, err := resolveSchemaRef(v.root, ref)
+			if err != nil {
+				v.addf(path, "%v", err)
+				return
+			}
+			// In draft-07 $ref replaces the other keywords of its schema
+			v.validate(target, value, path, refDepth+1)
+			return
+		}
+		v.validateKeywords(s, value, path, refDepth)
+	}
+}
+
+func (v *schemaValidator) validateKeywords(schema map[string]interface{}, value interface{}, path string, refDepth int) {
+	if types, ok := schemaTypes(schema["type"]); ok && !matchesAnyType(value, types) {
+		v.addf(path, "expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if jsonEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.addf(path, "value %v is not one of the allowed values", value)
+		}
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, value) {
+		v.addf(path, "value must be %v", constant)
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, val, path, refDepth)
+	case []interface{}:
+		v.validateArray(schema, val, path, refDepth)
+	case string:
+		length := utf8.RuneCountInString(val)
+		if min, ok := schemaNumber(schema["minLength"]); ok && float64(length) < min {
+			v.addf(path, "shorter than %v characters", min)
+		}
+		if max, ok := schemaNumber(schema["maxLength"]); ok && float64(length) > max {
+			v.addf(path, "longer than %v characters", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				v.addf(path, "schema pattern %q is not supported: %v", pattern, err)
+			} else if !re.MatchString(val) {
+				v.addf(path, "does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && val < min {
+			v.addf(path, "less than minimum %v", min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && val > max {
+			v.addf(path, "greater than maximum %v", max)
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			v.validate(sub, value, path, refDepth)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if v.matches(sub, value, path, refDepth) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.addf(path, "does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, sub := range oneOf {
+			if v.matches(sub, value, path, refDepth) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.addf(path, "matches %d of the oneOf schemas, expected exactly 1", count)
+		}
+	}
+	if not, ok := schema["not"]; ok && v.matches(not, value, path, refDepth) {
+		v.addf(path, "matches a schema it must not match")
+	}
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, refDepth int) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, field := range required {
+			if name, ok := field.(string); ok {
+				if _, exists := obj[name]; !exists {
+					v.addf(path, "missing required field %q", name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+
+	for _, key := range sortedKeys(obj) {
+		childPath := joinSchemaPath(path, key)
+		covered := false
+		if sub, ok := properties[key]; ok {
+			covered = true
+			v.validate(sub, obj[key], childPath, refDepth)
+		}
+		for pattern, sub := range patternProperties {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				continue
+			}
+			if re.MatchString(key) {
+				covered = true
+				v.validate(sub, obj[key], childPath, refDepth)
+			}
+		}
+		if !covered && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.addf(path, "unexpected field %q", key)
+			} else {
+				v.validate(additional, obj[key], childPath, refDepth)
+			}
+		}
+	}
+
+	if min, ok := schemaNumber(schema["minProperties"]); ok && float64(len(obj)) < min {
+		v.addf(path, "fewer than %v fields", min)
+	}
+	if max, ok := schemaNumber(schema["maxProperties"]); ok && float64(len(obj)) > max {
+		v.addf(path, "more than %v fields", max)
+	}
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, arr []interface{}, path string, refDepth int) {
+	switch items := schema["items"].(type) {
+	case []interface{}:
+		// Tuple form: one schema per position
+		for i, sub := range items {
+			if i < len(arr) {
+				v.validate(sub, arr[i], fmt.Sprintf("%s[%d]", path, i), refDepth)
+			}
+		}
+	case map[string]interface{}, bool:
+		for i, item := range arr {
+			v.validate(items, item, fmt.Sprintf("%s[%d]", path, i), refDepth)
+		}
+	}
+	if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(arr)) < min {
+		v.addf(path, "fewer than %v items", min)
+	}
+	if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(arr)) > max {
+		v.addf(path, "more than %v items", max)
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					v.addf(path, "items %d and %d are identical", i, j)
+				}
+			}
+		}
+	}
+}
+
+// resolveSchemaRef 解析指向同一文档的 $ref（"#" 或 "#/definitions/..." 形式的 JSON Pointer）
+func resolveSchemaRef(root interface{}, ref string) (interface{}, error) {
+	if ref == "#" {
+		return root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %s (only references within the schema are supported)", ref)
+	}
+	current := root
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %s not found in schema", ref)
+		}
+		if current, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("$ref %s not found in schema", ref)
+		}
+	}
+	return current, nil
+}
+
+// schemaTypes 返回 type 关键字列出的类型（字符串或字符串数组）
+func schemaTypes(raw interface{}) ([]string, bool) {
+	switch t := raw.(type) {
+	case string:
+		return []string{t}, true
+	case []interface{}:
+		types := make([]string, 0, len(t))
+		for _, item := range t {
+			if name, ok := item.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types, len(types) > 0
+	}
+	return nil, false
+}
+
+func matchesAnyType(value interface{}, types []string) bool {
+	actual := jsonTypeName(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName 返回 JSON schema 中 value 的类型名；没有小数部分的数字视为 integer
+func jsonTypeName(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaNumber(raw interface{}) (float64, bool) {
+	n, ok := raw.(float64)
+	return n, ok
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServerSchema requires every server to have a string command and only string args
+const testServerSchema = `{
+	"type": "object",
+	"properties": {
+		"mcpServers": {
+			"type": "object",
+			"additionalProperties": {"$ref": "#/definitions/server"}
+		}
+	},
+	"definitions": {
+		"server": {
+			"type": "object",
+			"required": ["command"],
+			"properties": {
+				"command": {"type": "string", "minLength": 1},
+				"args": {"type": "array", "items": {"type": "string"}},
+				"env": {"type": "object", "additionalProperties": {"type": "string"}}
+			}
+		}
+	}
+}`
+
+// setAgentSchema points the agent's schema_path and schema_url at the given values
+func setAgentSchema(as *AppService, agentID, schemaPath, schemaURL string) {
+	for i := range as.configLoader.config.Agents {
+		if as.configLoader.config.Agents[i].ID == agentID {
+			as.configLoader.config.Agents[i].SchemaPath = schemaPath
+			as.configLoader.config.Agents[i].SchemaURL = schemaURL
+		}
+	}
+}
+
+func writeTestSchema(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cursor.schema.json")
+	if err := os.WriteFile(path, []byte(testServerSchema), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateAgainstSchemaWithLocalFile(t *testing.T) {
+	as := newTestAppService(t)
+
+	if problems, err := as.ValidateAgainstSchema("cursor"); err != nil || len(problems) != 0 {
+		t.Fatalf("ValidateAgainstSchema() without a schema = %v, %v, want no problems", problems, err)
+	}
+
+	setAgentSchema(as, "cursor", writeTestSchema(t), "")
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"command": "npx", "args": ["-y", "fs"], "env": {"ROOT": "/tmp"}}}}`)
+	problems, err := as.ValidateAgainstSchema("cursor")
+	if err != nil {
+		t.Fatalf("ValidateAgainstSchema() error = %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("ValidateAgainstSchema() good config = %v, want no problems", problems)
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"bad": {"args": ["-y", 3], "env": {"PORT": 8080}}}}`)
+	problems, err = as.ValidateAgainstSchema("cursor")
+	if err != nil {
+		t.Fatalf("ValidateAgainstSchema() error = %v", err)
+	}
+	want := []string{
+		`mcpServers.bad.args[1]: expected string, got integer`,
+		`mcpServers.bad.env.PORT: expected string, got integer`,
+		`mcpServers.bad: missing required field "command"`,
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("ValidateAgainstSchema() bad config =\n%q\nwant\n%q", problems, want)
+	}
+}
+
+func TestSchemaSafeModeBlocksInvalidPush(t *testing.T) {
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", "{}")
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	setAgentSchema(as, "cursor", writeTestSchema(t), "")
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"bad": {"args": ["x"]}}}`)
+
+	// Safe mode is off by default, so only an explicit opt-in blocks the push
+	if err := as.SetSchemaSafeMode(true); err != nil {
+		t.Fatalf("SetSchemaSafeMode() error = %v", err)
+	}
+	err := as.PushAllAgentsToGist()
+	if !errors.Is(err, ErrSchemaValidation) || !strings.Contains(err.Error(), `cursor: mcpServers.bad: missing required field "command"`) {
+		t.Fatalf("PushAllAgentsToGist() error = %v, want ErrSchemaValidation naming the missing field", err)
+	}
+	if server.fileContent(gistID, "mcp-config.json") != "{}" {
+		t.Errorf("gist was modified by a push that failed validation")
+	}
+
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"good": {"command": "node"}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() with a valid config error = %v", err)
+	}
+}
+
+func TestSchemaURLIsCached(t *testing.T) {
+	var hits int32
+	schemaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(testServerSchema))
+	}))
+	defer schemaServer.Close()
+
+	as := newTestAppService(t)
+	setAgentSchema(as, "cursor", "", schemaServer.URL+"/cursor.json")
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"fs": {"args": ["x"]}}}`)
+
+	for i := 0; i < 2; i++ {
+		problems, err := as.ValidateAgainstSchema("cursor")
+		if err != nil {
+			t.Fatalf("ValidateAgainstSchema() error = %v", err)
+		}
+		if len(problems) != 1 {
+			t.Errorf("ValidateAgainstSchema() = %v, want the missing command", problems)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("schema downloaded %d times, want 1 (cached)", hits)
+	}
+
+	// An expired cache is still used when the schema cannot be downloaded
+	schemaServer.Close()
+	cacheDir := filepath.Join(as.storage.GetDataDir(), "schemas")
+	files, _ := os.ReadDir(cacheDir)
+	if len(files) != 1 {
+		t.Fatalf("schema cache has %d files, want 1", len(files))
+	}
+	old := time.Now().Add(-2 * schemaCacheTTL)
+	os.Chtimes(filepath.Join(cacheDir, files[0].Name()), old, old)
+	if problems, err := as.ValidateAgainstSchema("cursor"); err != nil || len(problems) != 1 {
+		t.Errorf("ValidateAgainstSchema() with stale cache = %v, %v, want the cached schema to be used", problems, err)
+	}
+}
+
+func TestValidateJSONSchemaKeywords(t *testing.T) {
+	schema, err := parseSchema([]byte(`{
+		"type": "object",
+		"additionalProperties": false,
+		"properties": {
+			"mode": {"enum": ["stdio", "http"]},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"url": {"type": "string", "pattern": "^https?://"},
+			"target": {"oneOf": [{"required": ["command"]}, {"required": ["url"]}]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := validateJSONSchema(schema, map[string]interface{}{
+		"mode":   "grpc",
+		"port":   70000,
+		"url":    "ftp://example.com",
+		"target": map[string]interface{}{"command": "x", "url": "y"},
+		"extra":  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`(root): unexpected field "extra"`,
+		`mode: value grpc is not one of the allowed values`,
+		`port: greater than maximum 65535`,
+		`target: matches 2 of the oneOf schemas, expected exactly 1`,
+		`url: does not match pattern "^https?://"`,
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("validateJSONSchema() =\n%q\nwant\n%q", problems, want)
+	}
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// SecretStore 存储 env 中 ${secret:name} 引用所指向的真实值
+type SecretStore interface {
+	SetSecret(name, value string) error
+	GetSecret(name string) (string, error)
+	DeleteSecret(name string) error
+}
+
+// secretRefPattern matches ${secret:name} references inside env values
+var secretRefPattern = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+// hasSecretRef reports whether value contains at least one ${secret:name} reference
+func hasSecretRef(value string) bool {
+	return secretRefPattern.MatchString(value)
+}
+
+// expandSecretRefs replaces every ${secret:name} in template with the stored value
+func expandSecretRefs(template string, store SecretStore) (string, error) {
+	if store == nil {
+		return "", fmt.Errorf("secret store unavailable, cannot resolve %s", template)
+	}
+
+	var resolveErr error
+	expanded := secretRefPattern.ReplaceAllStringFunc(template, func(ref string) string {
+		name := secretRefPattern.FindStringSubmatch(ref)[1]
+		value, err := store.GetSecret(name)
+		if err != nil && resolveErr == nil {
+			resolveErr = err
+		}
+		return value
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return expanded, nil
+}
+
+// envVarRefPattern matches ${NAME} environment variable references; ${secret:name} is not matched
+var envVarRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// hasEnvVarRef reports whether value contains at least one ${NAME} reference
+func hasEnvVarRef(value string) bool {
+	return envVarRefPattern.MatchString(value)
+}
+
+// expandEnvVarRefs replaces every ${NAME} whose variable is set; references to unset variables are kept
+func expandEnvVarRefs(template string) string {
+	return envVarRefPattern.ReplaceAllStringFunc(template, func(ref string) string {
+		if value, ok := os.LookupEnv(envVarRefPattern.FindStringSubmatch(ref)[1]); ok {
+			return value
+		}
+		println(fmt.Sprintf("Warning: environment variable in %s is not set, keeping the reference", ref))
+		return ref
+	})
+}
+
+// expandEnvTemplate expands the ${secret:name} references in template and, when expandEnv is set,
+// its ${NAME} environment variable references
+func expandEnvTemplate(template string, store SecretStore, expandEnv bool) (string, error) {
+	expanded := template
+	if hasSecretRef(template) {
+		var err error
+		if expanded, err = expandSecretRefs(template, store); err != nil {
+			return "", err
+		}
+	}
+	if expandEnv {
+		expanded = expandEnvVarRefs(expanded)
+	}
+	return expanded, nil
+}
+
+// secretRefKey identifies one env entry of one server of one agent
+func secretRefKey(agentID, serverName, envKey string) string {
+	return agentID + "/" + serverName + "/" + envKey
+}
+
+// forEachServerEnv calls fn for every server's env map in an agent config, storing back what fn returns
+func forEachServerEnv(config map[string]interface{}, fn func(serverName string, env map[string]interface{}) (map[string]interface{}, error)) error {
+	for _, section := range config {
+		servers, ok := section.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for serverName, server := range servers {
+			serverMap, ok := server.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			var env map[string]interface{}
+			switch e := serverMap["env"].(type) {
+			case map[string]interface{}:
+				env = e
+			case map[string]string:
+				env = make(map[string]interface{}, len(e))
+				for k, v := range e {
+					env[k] = v
+				}
+			default:
+				continue
+			}
+
+			updated, err := fn(serverName, env)
+			if err != nil {
+				return err
+			}
+			if updated != nil {
+				copied := make(map[string]interface{}, len(serverMap))
+				for k, v := range serverMap {
+					copied[k] = v
+				}
+				copied["env"] = updated
+				servers[serverName] = copied
+			}
+		}
+	}
+	return nil
+}
+
+// copyServerSections copies config and its server maps so forEachServerEnv can replace servers
+// without touching the caller's maps
+func copyServerSections(config map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(config))
+	for key, section := range config {
+		if servers, ok := section.(map[string]interface{}); ok {
+			servers2 := make(map[string]interface{}, len(servers))
+			for name, server := range servers {
+				servers2[name] = server
+			}
+			section = servers2
+		}
+		copied[key] = section
+	}
+	return copied
+}
+
+// resolveSecretRefs returns a copy of config whose ${secret:name} env values are replaced by
+// the stored secrets, recording each original template in refs so it can be restored on push.
+// With expandEnv, ${NAME} environment variable references are expanded and recorded the same way
+func resolveSecretRefs(agentID string, config map[string]interface{}, store SecretStore, refs map[string]string, expandEnv bool) (map[string]interface{}, error) {
+	resolved := copyServerSections(config)
+
+	err := forEachServerEnv(resolved, func(serverName string, env map[string]interface{}) (map[string]interface{}, error) {
+		var updated map[string]interface{}
+		for envKey, value := range env {
+			template, ok := value.(string)
+			if !ok || !(hasSecretRef(template) || expandEnv && hasEnvVarRef(template)) {
+				continue
+			}
+			expanded, err := expandEnvTemplate(template, store, expandEnv)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve %s for server %s: %w", envKey, serverName, err)
+			}
+			if expanded == template {
+				continue
+			}
+			if updated == nil {
+				updated = make(map[string]interface{}, len(env))
+				for k, v := range env {
+					updated[k] = v
+				}
+			}
+			updated[envKey] = expanded
+			refs[secretRefKey(agentID, serverName, envKey)] = template
+		}
+		return updated, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// restoreSecretRefs puts ${secret:name} and ${NAME} templates back in place of resolved values in config,
+// so synced content never carries the secret itself or machine-specific paths. Values the user changed locally are kept.
+func restoreSecretRefs(agentID string, config map[string]interface{}, store SecretStore, refs map[string]string) error {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	return forEachServerEnv(config, func(serverName string, env map[string]interface{}) (map[string]interface{}, error) {
+		var updated map[string]interface{}
+		for envKey, value := range env {
+			template, ok := refs[secretRefKey(agentID, serverName, envKey)]
+			if !ok {
+				continue
+			}
+			current, ok := value.(string)
+			if !ok {
+				continue
+			}
+			// The value was written with or without environment expansion, depending on the agent
+			expanded, err := expandEnvTemplate(template, store, false)
+			if err != nil || (expanded != current && expandEnvVarRefs(expanded) != current) {
+				continue
+			}
+			if updated == nil {
+				updated = make(map[string]interface{}, len(env))
+				for k, v := range env {
+					updated[k] = v
+				}
+			}
+			updated[envKey] = template
+		}
+		return updated, nil
+	})
+}
+
+// LocalSecretPlaceholder 替换推送前被剥离的敏感 env 值；拉取时不会覆盖本地的原值
+const LocalSecretPlaceholder = "${local-only}"
+
+// stripSensitiveEnv returns a copy of config whose sensitive env values (see IsSensitiveField) are
+// replaced by LocalSecretPlaceholder. ${secret:name} references hold no secret and are kept.
+func stripSensitiveEnv(config map[string]interface{}) map[string]interface{} {
+	stripped := copyServerSections(config)
+	forEachServerEnv(stripped, func(serverName string, env map[string]interface{}) (map[string]interface{}, error) {
+		var updated map[string]interface{}
+		for envKey, value := range env {
+			str, ok := value.(string)
+			if !IsSensitiveField(envKey) || !ok || str == LocalSecretPlaceholder || hasSecretRef(str) {
+				continue
+			}
+			if updated == nil {
+				updated = make(map[string]interface{}, len(env))
+				for k, v := range env {
+					updated[k] = v
+				}
+			}
+			updated[envKey] = LocalSecretPlaceholder
+		}
+		return updated, nil
+	})
+	return stripped
+}
+
+// keepLocalSecrets returns a copy of config whose LocalSecretPlaceholder env values are replaced by
+// the value the same server has in local. Placeholders with no local value are dropped rather than
+// written into the agent's config.
+func keepLocalSecrets(config, local map[string]interface{}) map[string]interface{} {
+	localEnv := make(map[string]map[string]interface{})
+	forEachServerEnv(copyServerSections(local), func(serverName string, env map[string]interface{}) (map[string]interface{}, error) {
+		localEnv[serverName] = env
+		return nil, nil
+	})
+
+	kept := copyServerSections(config)
+	forEachServerEnv(kept, func(serverName string, env map[string]interface{}) (map[string]interface{}, error) {
+		var updated map[string]interface{}
+		for envKey, value := range env {
+			if value != LocalSecretPlaceholder {
+				continue
+			}
+			if updated == nil {
+				updated = make(map[string]interface{}, len(env))
+				for k, v := range env {
+					updated[k] = v
+				}
+			}
+			if localValue, ok := localEnv[serverName][envKey]; ok && localValue != LocalSecretPlaceholder {
+				updated[envKey] = localValue
+			} else {
+				println(fmt.Sprintf("Warning: %s of server %s is kept local-only and has no local value", envKey, serverName))
+				delete(updated, envKey)
+			}
+		}
+		return updated, nil
+	})
+	return kept
+}
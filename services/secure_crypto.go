@@ -8,6 +8,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strings"
 )
 
@@ -15,6 +16,18 @@ import (
 type SecureCrypto struct {
 	keyring     SystemKeyring
 	serviceName string
+	// dataDir 是加密文件所在目录，RotateKey 会重新加密其中的文件
+	dataDir string
+	// fs 是读写加密文件使用的文件系统，为空时使用真实文件系统
+	fs FileSystem
+}
+
+// fileSystem 返回读写加密文件使用的文件系统
+func (sc *SecureCrypto) fileSystem() FileSystem {
+	if sc.fs == nil {
+		return osFileSystem{}
+	}
+	return sc.fs
 }
 
 // NewSecureCrypto 创建一个新的安全加密实例
@@ -24,10 +37,15 @@ func NewSecureCrypto() (*SecureCrypto, error) {
 		return nil, fmt.Errorf("failed to initialize system keyring: %w", err)
 	}
 	
+	return NewSecureCryptoWithKeyring(keyring), nil
+}
+
+// NewSecureCryptoWithKeyring 使用指定的密钥环创建安全加密实例（例如测试中的 InMemoryKeyring）
+func NewSecureCryptoWithKeyring(keyring SystemKeyring) *SecureCrypto {
 	return &SecureCrypto{
 		keyring:     keyring,
-		serviceName: "mcp-sync",
-	}, nil
+		serviceName: keyringServiceName(),
+	}
 }
 
 // Enable 启用加密，生成新的密钥并存储到系统密钥环
@@ -64,6 +82,20 @@ func (sc *SecureCrypto) IsEnabled() bool {
 	return err == nil && len(key) > 0
 }
 
+// KeyringAvailable 检查系统密钥环能否保存和读取密钥：已有主密钥时直接可用，否则写入、读取并删除一个探测密钥
+func (sc *SecureCrypto) KeyringAvailable() bool {
+	if sc.IsEnabled() {
+		return true
+	}
+	probe := []byte("mcp-sync keyring probe")
+	if err := sc.keyring.SetKey(sc.serviceName, "keyring_probe", probe); err != nil {
+		return false
+	}
+	defer sc.keyring.DeleteKey(sc.serviceName, "keyring_probe")
+	stored, err := sc.keyring.GetKey(sc.serviceName, "keyring_probe")
+	return err == nil && string(stored) == string(probe)
+}
+
 // getKey 获取加密密钥
 func (sc *SecureCrypto) getKey() ([]byte, error) {
 	return sc.keyring.GetKey(sc.serviceName, "master_key")
@@ -89,6 +121,16 @@ func (sc *SecureCrypto) Decrypt(ciphertext string) (string, error) {
 	return decryptData(key, ciphertext)
 }
 
+// DecryptBytes 解密并直接返回字节
+func (sc *SecureCrypto) DecryptBytes(ciphertext string) ([]byte, error) {
+	key, err := sc.getKey()
+	if err != nil {
+		return nil, fmt.Errorf("encryption not enabled or key not available: %w", err)
+	}
+
+	return openSealed(key, ciphertext)
+}
+
 // EncryptIfNeeded 如果加密启用则加密数据
 func (sc *SecureCrypto) EncryptIfNeeded(data []byte) ([]byte, error) {
 	if !sc.IsEnabled() {
@@ -159,32 +201,10 @@ func encryptData(key []byte, plaintext string) (string, error) {
 
 // decryptData 使用给定密钥解密数据的通用函数
 func decryptData(key []byte, ciphertext string) (string, error) {
-	cipherBytes, err := base64.StdEncoding.DecodeString(ciphertext)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode base64: %w", err)
-	}
-
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	plaintext, err := openSealed(key, ciphertext)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	nonceSize := gcm.NonceSize()
-	if len(cipherBytes) < nonceSize {
-		return "", fmt.Errorf("ciphertext too short")
+		return "", err
 	}
-
-	nonce, ciphertext2 := cipherBytes[:nonceSize], cipherBytes[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext2, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to decrypt: %w", err)
-	}
-
 	return string(plaintext), nil
 }
 
@@ -219,6 +239,163 @@ func (sc *SecureCrypto) MigrateFromPassword(password string) error {
 	return nil
 }
 
+// RotateKey 生成新的主密钥，用新密钥重新加密数据目录中的所有文件，然后替换密钥环中的主密钥。
+// 任一文件失败时恢复所有已写入的文件，旧密钥保持不变；进程在写入途中退出时，下次启动由 ResumeKeyRotation 完成轮换。
+func (sc *SecureCrypto) RotateKey() error {
+	return sc.RotateKeyWith(nil)
+}
+
+// RotateKeyWith 与 RotateKey 相同，但在所有文件重写之后、替换主密钥之前调用 beforeSwap(newKey)，
+// 让调用方用新密钥重新加密保存在数据目录之外的数据（如 Gist）。beforeSwap 返回错误时回滚所有文件；
+// beforeSwap 成功后替换主密钥失败时不再回滚，待定密钥留在密钥环中，下次启动由 ResumeKeyRotation 完成轮换
+func (sc *SecureCrypto) RotateKeyWith(beforeSwap func(newKey []byte) error) error {
+	oldKey, err := sc.getKey()
+	if err != nil || len(oldKey) == 0 {
+		return fmt.Errorf("encryption not enabled or key not available: %w", err)
+	}
+
+	newKey, err := generateRandomKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+
+	// Re-encrypt everything in memory first so a bad file aborts before anything is written
+	originals := make(map[string][]byte)
+	rotated := make(map[string][]byte)
+	var paths []string
+	fs := sc.fileSystem()
+	for _, path := range dataFilePaths(fs, sc.dataDir) {
+		data, err := fs.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !sc.isEncrypted(data) {
+			continue
+		}
+		plaintext, err := decryptData(oldKey, strings.TrimPrefix(string(data), "ENC:"))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s with current key: %w", filepath.Base(path), err)
+		}
+		encrypted, err := encryptData(newKey, plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s with new key: %w", filepath.Base(path), err)
+		}
+		originals[path] = data
+		rotated[path] = []byte("ENC:" + encrypted)
+		paths = append(paths, path)
+	}
+
+	// Keep the new key in the keyring while files are rewritten so it is never lost
+	if err := sc.keyring.SetKey(sc.serviceName, "master_key_pending", newKey); err != nil {
+		return fmt.Errorf("failed to store new encryption key: %w", err)
+	}
+
+	rollback := func(written []string) {
+		for _, path := range written {
+			if err := fs.WriteFile(path, originals[path], 0644); err != nil {
+				println(fmt.Sprintf("Warning: failed to restore %s during key rotation rollback: %v", path, err))
+			}
+		}
+		sc.keyring.DeleteKey(sc.serviceName, "master_key_pending")
+	}
+
+	var written []string
+	for _, path := range paths {
+		if err := fs.WriteFile(path, rotated[path], 0644); err != nil {
+			rollback(append(written, path))
+			return fmt.Errorf("key rotation failed on %s, rolled back: %w", filepath.Base(path), err)
+		}
+		written = append(written, path)
+	}
+
+	if beforeSwap != nil {
+		if err := beforeSwap(newKey); err != nil {
+			rollback(written)
+			return fmt.Errorf("key rotation aborted, rolled back: %w", err)
+		}
+	}
+
+	if err := sc.keyring.SetKey(sc.serviceName, "master_key", newKey); err != nil {
+		if beforeSwap != nil {
+			// Data outside the data dir is already under the new key, so keep it pending instead of rolling back
+			return fmt.Errorf("failed to swap encryption key, it will be swapped on the next start: %w", err)
+		}
+		rollback(written)
+		return fmt.Errorf("failed to swap encryption key, rolled back: %w", err)
+	}
+	sc.keyring.DeleteKey(sc.serviceName, "master_key_pending")
+
+	return nil
+}
+
+// ResumeKeyRotation 完成一次被中断的密钥轮换：密钥环中还有 master_key_pending 时，RotateKey 在写入文件途中退出了，
+// 部分文件已经用新密钥加密。仍用旧密钥加密的文件用新密钥重新加密，然后替换主密钥；没有未完成的轮换时什么也不做。
+// 有文件两把密钥都无法解密时返回错误，待定密钥保留在密钥环中
+func (sc *SecureCrypto) ResumeKeyRotation() error {
+	pendingKey, err := sc.keyring.GetKey(sc.serviceName, "master_key_pending")
+	if err != nil || len(pendingKey) == 0 {
+		return nil
+	}
+	oldKey, _ := sc.getKey()
+
+	fs := sc.fileSystem()
+	for _, path := range dataFilePaths(fs, sc.dataDir) {
+		data, err := fs.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !sc.isEncrypted(data) {
+			continue
+		}
+		ciphertext := strings.TrimPrefix(string(data), "ENC:")
+		if _, err := decryptData(pendingKey, ciphertext); err == nil {
+			continue
+		}
+		if len(oldKey) == 0 {
+			return fmt.Errorf("failed to resume key rotation: %s is not readable with the new key and the old key is gone", filepath.Base(path))
+		}
+		plaintext, err := decryptData(oldKey, ciphertext)
+		if err != nil {
+			return fmt.Errorf("failed to resume key rotation: %s is readable with neither key: %w", filepath.Base(path), err)
+		}
+		encrypted, err := encryptData(pendingKey, plaintext)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s with new key: %w", filepath.Base(path), err)
+		}
+		if err := fs.WriteFile(path, []byte("ENC:"+encrypted), 0644); err != nil {
+			return fmt.Errorf("failed to resume key rotation on %s: %w", filepath.Base(path), err)
+		}
+	}
+
+	if err := sc.keyring.SetKey(sc.serviceName, "master_key", pendingKey); err != nil {
+		return fmt.Errorf("failed to swap encryption key: %w", err)
+	}
+	sc.keyring.DeleteKey(sc.serviceName, "master_key_pending")
+	return nil
+}
+
+// SetSecret 将命名的密钥值（如 API key）存储到系统密钥环，供 ${secret:name} 引用
+func (sc *SecureCrypto) SetSecret(name, value string) error {
+	if name == "" {
+		return fmt.Errorf("secret name is required")
+	}
+	return sc.keyring.SetKey(sc.serviceName, "secret_"+name, []byte(value))
+}
+
+// GetSecret 从系统密钥环读取命名的密钥值
+func (sc *SecureCrypto) GetSecret(name string) (string, error) {
+	value, err := sc.keyring.GetKey(sc.serviceName, "secret_"+name)
+	if err != nil {
+		return "", fmt.Errorf("secret %q not found: %w", name, err)
+	}
+	return string(value), nil
+}
+
+// DeleteSecret 从系统密钥环删除命名的密钥值
+func (sc *SecureCrypto) DeleteSecret(name string) error {
+	return sc.keyring.DeleteKey(sc.serviceName, "secret_"+name)
+}
+
 // memoryKeyring 用于迁移时的临时密钥存储
 type memoryKeyring struct {
 	key []byte
@@ -7,7 +7,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // CryptoOperations 定义加密操作接口
@@ -38,10 +40,56 @@ var sensitivePatterns = []string{
 	"auth",
 }
 
-// IsSensitiveField 检查字段是否包含敏感信息
+// extraSensitivePatterns 是用户在 SyncConfig.ExtraSensitivePatterns 中追加的模式，与默认模式一起生效
+var (
+	extraSensitivePatterns   []string
+	extraSensitivePatternsMu sync.RWMutex
+)
+
+// normalizeSensitivePattern 返回小写、去掉首尾空白的模式
+func normalizeSensitivePattern(pattern string) string {
+	return strings.ToLower(strings.TrimSpace(pattern))
+}
+
+// SetExtraSensitivePatterns 设置追加在默认模式之后的敏感字段模式，忽略空模式
+func SetExtraSensitivePatterns(patterns []string) {
+	extra := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern = normalizeSensitivePattern(pattern); pattern != "" {
+			extra = append(extra, pattern)
+		}
+	}
+
+	extraSensitivePatternsMu.Lock()
+	defer extraSensitivePatternsMu.Unlock()
+	extraSensitivePatterns = extra
+}
+
+// SensitivePatterns 返回当前生效的敏感字段模式：默认模式在前，其后是去重排序后的自定义模式
+func SensitivePatterns() []string {
+	extraSensitivePatternsMu.RLock()
+	defer extraSensitivePatternsMu.RUnlock()
+
+	patterns := append([]string{}, sensitivePatterns...)
+	seen := make(map[string]bool, len(patterns)+len(extraSensitivePatterns))
+	for _, pattern := range patterns {
+		seen[pattern] = true
+	}
+	var extra []string
+	for _, pattern := range extraSensitivePatterns {
+		if !seen[pattern] {
+			seen[pattern] = true
+			extra = append(extra, pattern)
+		}
+	}
+	sort.Strings(extra)
+	return append(patterns, extra...)
+}
+
+// IsSensitiveField 检查字段是否包含敏感信息（匹配默认模式或自定义模式）
 func IsSensitiveField(fieldName string) bool {
 	lowerName := strings.ToLower(fieldName)
-	for _, pattern := range sensitivePatterns {
+	for _, pattern := range SensitivePatterns() {
 		if strings.Contains(lowerName, pattern) {
 			return true
 		}
@@ -125,35 +173,55 @@ func (sm *SecurityManager) Encrypt(plaintext string) (string, error) {
 
 // Decrypt 解密字符串
 func (sm *SecurityManager) Decrypt(ciphertext string) (string, error) {
-	key := []byte(sm.encryptionKey)
-
-	cipherBytes, err := base64.StdEncoding.DecodeString(ciphertext)
+	plaintext, err := sm.DecryptBytes(ciphertext)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64: %w", err)
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// DecryptBytes 解密并直接返回字节，避免再复制一份字符串
+func (sm *SecurityManager) DecryptBytes(ciphertext string) ([]byte, error) {
+	return openSealed([]byte(sm.encryptionKey), ciphertext)
+}
+
+// BytesDecrypter 由能直接解密为 []byte 的加密实现提供，大负载时省去一次复制
+type BytesDecrypter interface {
+	DecryptBytes(ciphertext string) ([]byte, error)
+}
+
+// openSealed decodes base64 AES-GCM ciphertext and decrypts it in place. The base64 text is
+// streamed into a single buffer, and the plaintext reuses that buffer instead of a new one.
+func openSealed(key []byte, ciphertext string) ([]byte, error) {
+	buf := make([]byte, base64.StdEncoding.DecodedLen(len(ciphertext)))
+	n, err := io.ReadFull(base64.NewDecoder(base64.StdEncoding, strings.NewReader(ciphertext)), buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to decode base64: %w", err)
 	}
+	cipherBytes := buf[:n]
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
 	nonceSize := gcm.NonceSize()
 	if len(cipherBytes) < nonceSize {
-		return "", fmt.Errorf("ciphertext too short")
+		return nil, fmt.Errorf("ciphertext too short")
 	}
 
-	nonce, ciphertext2 := cipherBytes[:nonceSize], cipherBytes[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext2, nil)
+	nonce, sealed := cipherBytes[:nonceSize], cipherBytes[nonceSize:]
+	plaintext, err := gcm.Open(sealed[:0], nonce, sealed, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrDecryptFailed, err)
 	}
 
-	return string(plaintext), nil
+	return plaintext, nil
 }
 
 // padKey 将密钥补充到 32 字节（AES-256）
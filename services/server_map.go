@@ -0,0 +1,102 @@
+package services
+
+import (
+	"mcp-sync/models"
+)
+
+// ServersFromMap 将以服务器名为键的配置（mcpServers 结构）转换为 MCPServer 列表，读取 command、args、env、headers 和 description、_meta 注释字段。
+// 非字符串的 args 元素保存在 RawArgs 中，不会被丢弃
+func ServersFromMap(serversData interface{}) []models.MCPServer {
+	var servers []models.MCPServer
+	serverMap, ok := serversData.(map[string]interface{})
+	if !ok {
+		return servers
+	}
+
+	for serverName, serverConfig := range serverMap {
+		server := models.MCPServer{
+			ID:   serverName,
+			Name: serverName,
+		}
+		if config, ok := serverConfig.(map[string]interface{}); ok {
+			if cmd, ok := config["command"].(string); ok {
+				server.Command = cmd
+			}
+
+			// Handle args - support both []interface{} and []string
+			switch args := config["args"].(type) {
+			case []interface{}:
+				server.SetArgList(args)
+			case []string:
+				server.Args = args
+			}
+
+			// Handle env - support both map[string]interface{} and map[string]string.
+			// Numbers and booleans become strings, the same as when writing an agent (see coerce.go)
+			switch env := config["env"].(type) {
+			case map[string]interface{}:
+				server.Env = make(map[string]string)
+				for k, v := range coerceEnv(env).(map[string]interface{}) {
+					server.Env[k] = v.(string)
+				}
+			case map[string]string:
+				server.Env = env
+			}
+
+			switch headers := config["headers"].(type) {
+			case map[string]interface{}:
+				server.Headers = make(map[string]string)
+				for k, v := range headers {
+					if strVal, ok := v.(string); ok {
+						server.Headers[k] = strVal
+					}
+				}
+			case map[string]string:
+				server.Headers = headers
+			}
+
+			if description, ok := config["description"].(string); ok {
+				server.Description = description
+			}
+			if meta, ok := config["_meta"].(map[string]interface{}); ok {
+				server.Meta = meta
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// ServersToMap 是 ServersFromMap 的逆转换，RawArgs 中的非字符串参数原样写回
+func ServersToMap(servers []models.MCPServer) map[string]interface{} {
+	serversData := make(map[string]interface{})
+	for _, server := range servers {
+		serverConfig := make(map[string]interface{})
+		serverConfig["command"] = server.Command
+		if args := server.ArgList(); len(args) > 0 {
+			serverConfig["args"] = args
+		}
+		if len(server.Env) > 0 {
+			envInterface := make(map[string]interface{})
+			for k, v := range server.Env {
+				envInterface[k] = v
+			}
+			serverConfig["env"] = envInterface
+		}
+		if len(server.Headers) > 0 {
+			headers := make(map[string]interface{})
+			for k, v := range server.Headers {
+				headers[k] = v
+			}
+			serverConfig["headers"] = headers
+		}
+		if server.Description != "" {
+			serverConfig["description"] = server.Description
+		}
+		if len(server.Meta) > 0 {
+			serverConfig["_meta"] = server.Meta
+		}
+		serversData[server.Name] = serverConfig
+	}
+	return serversData
+}
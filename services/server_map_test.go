@@ -0,0 +1,64 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestServersMapRoundTripKeepsObjectArgs(t *testing.T) {
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"search": {
+			"command": "npx",
+			"args": ["-y", "search-mcp", {"flag": "--region", "value": "eu"}, 3],
+			"env": {"LOG": "debug"}
+		},
+		"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}
+	}`), &input); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := ServersFromMap(input)
+	if len(servers) != 2 {
+		t.Fatalf("ServersFromMap() returned %d servers, want 2", len(servers))
+	}
+	for _, server := range servers {
+		switch server.Name {
+		case "search":
+			if !reflect.DeepEqual(server.Args, []string{"-y", "search-mcp"}) {
+				t.Errorf("search Args = %v, want only the string args", server.Args)
+			}
+			if len(server.RawArgs) != 4 {
+				t.Errorf("search RawArgs = %v, want all 4 args", server.RawArgs)
+			}
+		case "fetch":
+			if server.RawArgs != nil || !reflect.DeepEqual(server.Args, []string{"mcp-server-fetch"}) {
+				t.Errorf("fetch args = %v / %v, want plain string args", server.Args, server.RawArgs)
+			}
+		}
+	}
+
+	if output := ServersToMap(servers); !reflect.DeepEqual(output, input) {
+		t.Errorf("round trip changed servers:\n got %#v\nwant %#v", output, input)
+	}
+}
+
+func TestServersFromMapCoercesScalarEnv(t *testing.T) {
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"api": {"command": "api", "env": {"PORT": 8080, "DEBUG": true, "RATIO": 0.5, "NAME": "x", "UNSET": null}}
+	}`), &input); err != nil {
+		t.Fatal(err)
+	}
+
+	servers := ServersFromMap(input)
+	want := map[string]string{"PORT": "8080", "DEBUG": "true", "RATIO": "0.5", "NAME": "x"}
+	if len(servers) != 1 || !reflect.DeepEqual(servers[0].Env, want) {
+		t.Fatalf("ServersFromMap() env = %v, want %v", servers[0].Env, want)
+	}
+	env, _ := ServersToMap(servers)["api"].(map[string]interface{})["env"].(map[string]interface{})
+	if len(env) != len(want) || env["PORT"] != "8080" {
+		t.Errorf("ServersToMap() env = %v, want the coerced values", env)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mcp-sync/models"
+)
+
+// CheckServerPaths 检查 agent 的服务器中 command、args、env 和 cwd 里看起来像路径的值，返回本机不存在的路径，
+// 用于拉取其他机器的配置后找出需要修改的服务器。只检查绝对路径（以及 ~/ 开头的路径），
+// 相对路径、URL 和包含 ${...} 引用的值都不检查，避免误报
+func (as *AppService) CheckServerPaths(agentID string) ([]models.PathIssue, error) {
+	if as.configLoader.GetAgentDefinition(agentID) == nil {
+		return nil, fmt.Errorf("unknown agent: %s", agentID)
+	}
+	// Check the values as written on disk, not with ${secret:...} references restored
+	config, err := as.readAgentMCPConfig(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s config: %w", agentID, err)
+	}
+	servers, _ := standardServersFrom(config, as.configLoader.GetConfigKey(agentID))
+
+	issues := []models.PathIssue{}
+	for _, name := range sortedKeys(servers) {
+		server, ok := servers[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		check := func(field string, value interface{}) {
+			if issue, ok := checkLocalPath(value); ok {
+				issue.Server = name
+				issue.Field = field
+				issues = append(issues, issue)
+			}
+		}
+
+		check("command", server["command"])
+		check("cwd", server["cwd"])
+		if args, ok := coerceArgs(server["args"]).([]interface{}); ok {
+			for i, arg := range args {
+				check(fmt.Sprintf("args[%d]", i), arg)
+			}
+		}
+		if env, ok := coerceEnv(server["env"]).(map[string]interface{}); ok {
+			keys := make([]string, 0, len(env))
+			for key := range env {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				check("env."+key, env[key])
+			}
+		}
+	}
+	return issues, nil
+}
+
+// checkLocalPath 在 value 是本机上不存在或无法访问的绝对路径时返回对应的 PathIssue（不含服务器和字段）
+func checkLocalPath(value interface{}) (models.PathIssue, bool) {
+	path, ok := localPathValue(value)
+	if !ok {
+		return models.PathIssue{}, false
+	}
+
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		return models.PathIssue{}, false
+	case os.IsNotExist(err):
+		return models.PathIssue{Path: value.(string), Reason: "missing"}, true
+	default:
+		return models.PathIssue{Path: value.(string), Reason: "inaccessible"}, true
+	}
+}
+
+// localPathValue 返回 value 表示的本机绝对路径（~/ 展开为主目录）；不像路径的值返回 false
+func localPathValue(value interface{}) (string, bool) {
+	str, ok := value.(string)
+	if !ok || strings.ContainsAny(str, "\n") || strings.Contains(str, "${") || strings.Contains(str, "://") {
+		return "", false
+	}
+
+	if strings.HasPrefix(str, "~/") || strings.HasPrefix(str, `~\`) {
+		home := userHomeDir()
+		if home == "" {
+			return "", false
+		}
+		return filepath.Join(home, str[2:]), true
+	}
+	// Single-letter switches such as cmd's /c look absolute on Unix
+	if !filepath.IsAbs(str) || len(str) <= 2 {
+		return "", false
+	}
+	return str, true
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mcp-sync/models"
+)
+
+func TestCheckServerPaths(t *testing.T) {
+	as := newTestAppService(t)
+	existing := t.TempDir()
+	missing := filepath.Join(existing, "gone")
+	script := filepath.Join(existing, "server.js")
+	if err := os.WriteFile(script, []byte("//"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `{"mcpServers": {
+		"fs": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-filesystem", "EXISTING", "MISSING"]},
+		"local": {"command": "node", "args": ["SCRIPT", "relative/dir", "https://example.com/a"], "env": {"DATA": "MISSING/data", "HOME_REF": "${HOME}/x"}},
+		"win": {"command": "cmd", "args": ["/c", "npx", "tool"]}
+	}}`
+	r := strings.NewReplacer("EXISTING", existing, "MISSING", missing, "SCRIPT", script)
+	writeAgentFile(t, as, "cursor", strings.ReplaceAll(r.Replace(config), `\`, `\\`))
+
+	issues, err := as.CheckServerPaths("cursor")
+	if err != nil {
+		t.Fatalf("CheckServerPaths() error = %v", err)
+	}
+	want := []models.PathIssue{
+		{Server: "fs", Field: "args[3]", Path: missing, Reason: "missing"},
+		{Server: "local", Field: "env.DATA", Path: missing + "/data", Reason: "missing"},
+	}
+	if !reflect.DeepEqual(issues, want) {
+		t.Errorf("CheckServerPaths() =\n%+v\nwant\n%+v", issues, want)
+	}
+
+	if _, err := as.CheckServerPaths("no-such-agent"); err == nil {
+		t.Error("CheckServerPaths() for an unknown agent returned no error")
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"mcp-sync/models"
+)
+
+// GetSetupState 返回首次设置向导的进度：检测 agent、配置 GitHub token、初始化 Gist、开启加密，
+// 并为未完成的步骤给出建议和默认选项
+func (as *AppService) GetSetupState() (*models.SetupState, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+
+	state := &models.SetupState{
+		DetectedAgents: []string{},
+		Defaults: models.SetupDefaults{
+			EnableEncryption: true,
+			CreateGist:       config.GistID == "",
+		},
+	}
+
+	agentsStep := models.SetupStep{ID: "agents", Title: "Detect installed agents"}
+	if agents, err := as.detector.DetectInstalledAgents(); err != nil {
+		agentsStep.Detail = fmt.Sprintf("Agent detection failed: %v", err)
+		agentsStep.Suggestion = "Check agents.yaml for syntax errors"
+	} else {
+		for _, agent := range agents {
+			if agent.Status == "detected" {
+				state.DetectedAgents = append(state.DetectedAgents, agent.ID)
+			}
+		}
+		sort.Strings(state.DetectedAgents)
+		agentsStep.Done = len(state.DetectedAgents) > 0
+		agentsStep.Detail = fmt.Sprintf("%d agents detected", len(state.DetectedAgents))
+		if !agentsStep.Done {
+			agentsStep.Suggestion = "Install a supported agent or add its config path to agents.yaml"
+		}
+	}
+
+	token, source, _ := resolveGitHubToken(config)
+	tokenStep := models.SetupStep{ID: "token", Title: "Connect a GitHub token", Done: token != ""}
+	switch source {
+	case TokenSourceEnv:
+		tokenStep.Detail = "From " + GitHubTokenEnv
+	case TokenSourceHelper:
+		tokenStep.Detail = "From the credential helper"
+	}
+	if !tokenStep.Done {
+		tokenStep.Suggestion = "Create a token with the gist scope (classic) or Gists read and write access (fine-grained)"
+	}
+
+	gistStep := models.SetupStep{ID: "gist", Title: "Choose a Gist", Done: config.GistID != "", Detail: config.GistID}
+	if !gistStep.Done {
+		gistStep.Suggestion = "Leave the Gist ID empty to create a new private Gist"
+	}
+
+	encryptionStep := models.SetupStep{ID: "encryption", Title: "Enable encryption", Done: config.EnableEncryption}
+	if !encryptionStep.Done {
+		encryptionStep.Suggestion = "Enable encryption; configs are only pushed to the Gist encrypted"
+	}
+
+	state.Steps = []models.SetupStep{agentsStep, tokenStep, gistStep, encryptionStep}
+	for _, step := range state.Steps {
+		if !step.Done {
+			state.NextStep = step.ID
+			break
+		}
+	}
+	state.Complete = state.NextStep == ""
+	return state, nil
+}
+
+// CompleteSetupStep 完成设置向导中的一步并返回新的进度。value 的含义取决于步骤：
+// token 为 GitHub token，gist 为 Gist ID（为空时新建），encryption 为 Gist 加密密码（为空时使用系统密钥环）；
+// agents 步骤只重新检测，不需要 value。步骤必须按顺序完成
+func (as *AppService) CompleteSetupStep(step, value string) (*models.SetupState, error) {
+	config, err := as.GetSyncConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sync config: %w", err)
+	}
+
+	switch step {
+	case "agents":
+		// Detection runs as part of GetSetupState
+	case "token":
+		if err := as.UpdateGitHubToken(value); err != nil {
+			return nil, err
+		}
+	case "gist":
+		if token, _, _ := resolveGitHubToken(config); token == "" {
+			return nil, fmt.Errorf("connect a GitHub token before choosing a Gist")
+		}
+		// An empty token makes InitializeGistSyncDetailed resolve it, so external tokens are not stored
+		if _, err := as.InitializeGistSyncDetailed("", value); err != nil {
+			return nil, err
+		}
+	case "encryption":
+		if _, _, err := as.gistSyncBackend(); err != nil {
+			return nil, fmt.Errorf("choose a Gist before enabling encryption: %w", err)
+		}
+		if err := as.SetupGistEncryption(true, value); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown setup step %q", step)
+	}
+
+	return as.GetSetupState()
+}
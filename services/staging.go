@@ -0,0 +1,213 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"mcp-sync/models"
+)
+
+// StagePull 从 Gist 拉取并解密配置，计算每个本机安装的 agent 相对本地配置的变化并保存到暂存区，不写入任何 agent 配置文件。
+// 再次暂存会替换之前暂存的内容；用 CommitStagedPull 应用选中的 agent，用 DiscardStagedPull 放弃
+func (as *AppService) StagePull() (*models.StagedPull, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("stage_pull")()
+
+	_, gs, err := as.prepareGistSync()
+	if err != nil {
+		return nil, err
+	}
+	// Staging previews the pull and must never modify the gist
+	agentConfigs, err := gs.ReadOnly().PullAgentConfigsFromGist()
+	if err != nil {
+		return nil, err
+	}
+
+	staged := models.StagedPull{
+		StagedAt: nowTime(),
+		Agents:   []models.StagedAgentPull{},
+		Configs:  make(map[string]interface{}),
+	}
+	scope := as.agentScope()
+	for _, agentID := range sortedKeys(agentConfigs) {
+		config, ok := agentConfigs[agentID].(map[string]interface{})
+		if !ok || (scope != nil && !scope[agentID]) {
+			continue
+		}
+		// Like a pull, only agents installed here are written
+		if _, err := as.detector.GetAgentConfigPath(agentID); err != nil {
+			continue
+		}
+
+		change, err := as.stagedAgentChange(agentID, config)
+		if err != nil {
+			return nil, err
+		}
+		staged.Agents = append(staged.Agents, *change)
+		staged.Configs[agentID] = config
+	}
+
+	if err := as.storage.SaveStagedPull(staged); err != nil {
+		return nil, fmt.Errorf("failed to save staged pull: %w", err)
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "stage_pull",
+		Status:    "success",
+		Message:   fmt.Sprintf("Staged remote configs for %d agents for review", len(staged.Agents)),
+	})
+	return &staged, nil
+}
+
+// stagedAgentChange 对比 agent 的本地服务器和远程配置 remote 中会被写入的服务器（远程停用的服务器不会写入）
+func (as *AppService) stagedAgentChange(agentID string, remote map[string]interface{}) (*models.StagedAgentPull, error) {
+	local, err := as.standardAgentServers(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	incoming := make(map[string]interface{})
+	servers, _ := standardServersFrom(remote, as.configLoader.GetConfigKey(agentID))
+	disabled, _ := remote[disabledServersKey].(map[string]interface{})
+	for name, server := range servers {
+		if _, off := disabled[name]; !off {
+			incoming[name] = server
+		}
+	}
+	// Normalize to the JSON types the local side was read with
+	data, err := json.Marshal(incoming)
+	if err != nil {
+		return nil, err
+	}
+	incoming = make(map[string]interface{})
+	if err := json.Unmarshal(data, &incoming); err != nil {
+		return nil, err
+	}
+
+	change := &models.StagedAgentPull{
+		AgentID:   agentID,
+		Added:     []string{},
+		Removed:   []string{},
+		Updated:   []models.ServerDifference{},
+		Unchanged: []string{},
+	}
+	for _, name := range unionKeys(local, incoming) {
+		localServer, inLocal := local[name]
+		remoteServer, inRemote := incoming[name]
+		switch {
+		case !inLocal:
+			change.Added = append(change.Added, name)
+		case !inRemote:
+			change.Removed = append(change.Removed, name)
+		default:
+			localMap, _ := localServer.(map[string]interface{})
+			remoteMap, _ := remoteServer.(map[string]interface{})
+			if fields := diffFields("", localMap, remoteMap); len(fields) > 0 {
+				change.Updated = append(change.Updated, models.ServerDifference{Name: name, Fields: fields})
+			} else {
+				change.Unchanged = append(change.Unchanged, name)
+			}
+		}
+	}
+	return change, nil
+}
+
+// GetStagedPull 返回当前暂存的拉取；没有暂存内容时返回 nil
+func (as *AppService) GetStagedPull() (*models.StagedPull, error) {
+	return as.storage.LoadStagedPull()
+}
+
+// CommitStagedPull 把暂存的远程配置写入 agentIDs 中的 agent（为空时写入全部暂存的 agent），写入前先备份。
+// 每个 agent 的失败记录在返回的 map 中；成功写入的 agent 从暂存区移除，全部写入后暂存区被清空。
+// 一次提交了全部暂存的 agent 时，效果与完整拉取相同，会更新合并基准和同步时间
+func (as *AppService) CommitStagedPull(agentIDs []string) (map[string]error, error) {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	defer as.beginOperation("commit_staged_pull")()
+
+	staged, err := as.storage.LoadStagedPull()
+	if err != nil {
+		return nil, err
+	}
+	if staged == nil {
+		return nil, fmt.Errorf("%w: no staged pull", ErrNotFound)
+	}
+
+	if len(agentIDs) == 0 {
+		agentIDs = sortedKeys(staged.Configs)
+	}
+	results := make(map[string]error)
+	applied := make(map[string]bool)
+	for _, agentID := range agentIDs {
+		config, ok := staged.Configs[agentID].(map[string]interface{})
+		if !ok {
+			results[agentID] = fmt.Errorf("%w: agent %s is not staged", ErrNotFound, agentID)
+			continue
+		}
+		if _, err := as.backupAgentConfig(agentID); err != nil {
+			results[agentID] = fmt.Errorf("failed to back up %s before pull: %w", agentID, err)
+			continue
+		}
+		if err := as.applyRemoteAgentConfig(agentID, config); err != nil {
+			results[agentID] = err
+			continue
+		}
+		applied[agentID] = true
+	}
+
+	whole := len(applied) == len(staged.Configs)
+	if whole {
+		// Every staged agent was applied, which is a complete pull
+		as.updateMergeBase(staged.Configs, "pull")
+		as.recordSyncSuccess()
+		configContent, _ := json.MarshalIndent(staged.Configs, "", "  ")
+		as.storage.SaveConfigVersion(models.ConfigVersion{
+			ID:        "remote_" + nowStr(),
+			Timestamp: nowTime(),
+			Content:   string(configContent),
+			Source:    "gist",
+			Note:      "Committed staged pull",
+		})
+	}
+
+	// Keep what is still waiting for review
+	remaining := staged.Agents[:0]
+	for _, agent := range staged.Agents {
+		if !applied[agent.AgentID] {
+			remaining = append(remaining, agent)
+			continue
+		}
+		delete(staged.Configs, agent.AgentID)
+	}
+	staged.Agents = remaining
+	if len(staged.Configs) == 0 {
+		err = as.storage.ClearStagedPull()
+	} else {
+		err = as.storage.SaveStagedPull(*staged)
+	}
+	if err != nil {
+		return results, fmt.Errorf("failed to update staged pull: %w", err)
+	}
+
+	status := "success"
+	if len(results) > 0 {
+		status = "failed"
+	}
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "pull_staged",
+		Status:    status,
+		Message:   fmt.Sprintf("Applied staged configs to %d agents, %d failed", len(applied), len(results)),
+	})
+	return results, nil
+}
+
+// DiscardStagedPull 放弃暂存的拉取，agent 配置文件保持不变
+func (as *AppService) DiscardStagedPull() error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+	return as.storage.ClearStagedPull()
+}
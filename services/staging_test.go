@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mcp-sync/models"
+)
+
+func stagedPullFixture(t *testing.T) (*AppService, *stubGistServer, map[string]string) {
+	t.Helper()
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{
+		"cursor": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"shared": map[string]interface{}{"command": "shared-mcp", "args": []interface{}{"--v2"}},
+			"remote": map[string]interface{}{"command": "remote-mcp"},
+			"same":   map[string]interface{}{"command": "same-mcp"},
+		}},
+		"windsurf": map[string]interface{}{"mcpServers": map[string]interface{}{
+			"search": map[string]interface{}{"command": "search-mcp"},
+		}},
+	}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	paths := map[string]string{
+		"cursor": writeAgentFile(t, as, "cursor", `{"mcpServers": {
+		"shared": {"command": "shared-mcp", "args": ["--v1"]},
+		"local": {"command": "local-mcp"},
+		"same": {"command": "same-mcp"}
+	}}`),
+		"windsurf": writeAgentFile(t, as, "windsurf", `{"mcpServers": {"old": {"command": "old-mcp"}}}`),
+	}
+	return as, server, paths
+}
+
+func TestStagePullWritesNothing(t *testing.T) {
+	as, server, paths := stagedPullFixture(t)
+	before := map[string]string{}
+	for agentID, path := range paths {
+		before[agentID] = readFile(t, path)
+	}
+
+	staged, err := as.StagePull()
+	if err != nil {
+		t.Fatalf("StagePull() error = %v", err)
+	}
+	for agentID, path := range paths {
+		if got := readFile(t, path); got != before[agentID] {
+			t.Errorf("StagePull() rewrote %s:\n%s", agentID, got)
+		}
+	}
+	if patches := server.requestCount("PATCH"); patches != 0 {
+		t.Errorf("StagePull() updated the gist %d times", patches)
+	}
+
+	want := models.StagedAgentPull{
+		AgentID: "cursor",
+		Added:   []string{"remote"},
+		Removed: []string{"local"},
+		Updated: []models.ServerDifference{{
+			Name:   "shared",
+			Fields: []models.FieldDifference{{Field: "args", A: []interface{}{"--v1"}, B: []interface{}{"--v2"}}},
+		}},
+		Unchanged: []string{"same"},
+	}
+	if len(staged.Agents) != 2 || !reflect.DeepEqual(staged.Agents[0], want) {
+		t.Errorf("StagePull() agents = %+v, want cursor as %+v", staged.Agents, want)
+	}
+
+	stored, err := as.GetStagedPull()
+	if err != nil || stored == nil || len(stored.Agents) != 2 {
+		t.Fatalf("GetStagedPull() = %+v, %v", stored, err)
+	}
+
+	if err := as.DiscardStagedPull(); err != nil {
+		t.Fatalf("DiscardStagedPull() error = %v", err)
+	}
+	if stored, _ := as.GetStagedPull(); stored != nil {
+		t.Errorf("GetStagedPull() after discard = %+v, want nil", stored)
+	}
+	if _, err := as.CommitStagedPull(nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CommitStagedPull() without a staged pull error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCommitStagedPullAppliesOnlySelectedAgents(t *testing.T) {
+	as, _, paths := stagedPullFixture(t)
+	windsurfBefore := readFile(t, paths["windsurf"])
+
+	if _, err := as.StagePull(); err != nil {
+		t.Fatalf("StagePull() error = %v", err)
+	}
+	before, _ := as.GetSyncConfig()
+	failures, err := as.CommitStagedPull([]string{"cursor"})
+	if err != nil || len(failures) != 0 {
+		t.Fatalf("CommitStagedPull(cursor) = %v, %v", failures, err)
+	}
+
+	servers, err := as.standardAgentServers("cursor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers["remote"] == nil || servers["local"] != nil {
+		t.Errorf("cursor servers after commit = %#v, want the remote config", servers)
+	}
+	if got := readFile(t, paths["windsurf"]); got != windsurfBefore {
+		t.Errorf("windsurf was written although it was not committed:\n%s", got)
+	}
+
+	// The uncommitted agent stays staged and the sync is not complete yet
+	stored, err := as.GetStagedPull()
+	if err != nil || stored == nil || len(stored.Agents) != 1 || stored.Agents[0].AgentID != "windsurf" {
+		t.Fatalf("GetStagedPull() after partial commit = %+v, %v", stored, err)
+	}
+	if config, _ := as.GetSyncConfig(); !config.LastSyncTime.Equal(before.LastSyncTime) {
+		t.Errorf("LastSyncTime = %v after a partial commit, want %v", config.LastSyncTime, before.LastSyncTime)
+	}
+
+	if _, err := as.CommitStagedPull(nil); err != nil {
+		t.Fatalf("CommitStagedPull(rest) error = %v", err)
+	}
+	if !strings.Contains(readFile(t, paths["windsurf"]), "search-mcp") {
+		t.Errorf("windsurf was not written: %s", readFile(t, paths["windsurf"]))
+	}
+	if stored, _ := as.GetStagedPull(); stored != nil {
+		t.Errorf("GetStagedPull() after committing everything = %+v, want nil", stored)
+	}
+}
@@ -1,65 +1,113 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"mcp-sync/models"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// ErrEncryptionKeyMissing 表示配置要求加密，但系统密钥环中没有可以解密现有文件的密钥
+var ErrEncryptionKeyMissing = errors.New("encryption is enabled but the key is missing from the system keyring; restore the key or reset encryption")
+
+// ErrKeyringUnavailable 表示没有可用的系统密钥环，无法启用本地加密
+var ErrKeyringUnavailable = errors.New("no working system keyring is available, so local encryption cannot be enabled")
+
 type StorageService struct {
 	dataDir string
 	crypto  *SecureCrypto
 	// 保留旧的securityMgr以兼容现有代码（将在下个版本移除）
 	securityMgr *SecurityManager
 	oldEnabled  bool
+	fs          FileSystem
+	clock       Clock
 }
 
 func NewStorageService(dataDir string) (*StorageService, error) {
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		return nil, err
-	}
+	return NewStorageServiceWithDeps(dataDir, osFileSystem{}, realClock{})
+}
 
-	// 初始化新的安全加密服务
-	crypto, err := NewSecureCrypto()
+// NewStorageServiceWithDeps 使用指定的文件系统和时钟创建存储服务；传入 nil 时使用真实实现
+func NewStorageServiceWithDeps(dataDir string, fs FileSystem, clock Clock) (*StorageService, error) {
+	keyring, err := NewSystemKeyring()
 	if err != nil {
 		// 如果系统密钥环不可用，仍然返回服务但加密功能将被禁用
 		fmt.Printf("Warning: failed to initialize secure crypto: %v\n", err)
 	}
+	return NewStorageServiceWithKeyring(dataDir, fs, clock, keyring)
+}
+
+// NewStorageServiceWithKeyring 与 NewStorageServiceWithDeps 相同，但加密密钥保存在指定的密钥环中（例如测试中的 InMemoryKeyring）；
+// keyring 为 nil 时本地加密不可用
+func NewStorageServiceWithKeyring(dataDir string, fs FileSystem, clock Clock, keyring SystemKeyring) (*StorageService, error) {
+	if fs == nil {
+		fs = osFileSystem{}
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+	if err := fs.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var crypto *SecureCrypto
+	if keyring != nil {
+		crypto = NewSecureCryptoWithKeyring(keyring)
+		crypto.dataDir = dataDir
+		crypto.fs = fs
+		// A rotation interrupted by a crash leaves files under two keys; finish it before anything reads them
+		if err := crypto.ResumeKeyRotation(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
 
 	return &StorageService{
 		dataDir: dataDir,
 		crypto:  crypto,
+		fs:      fs,
+		clock:   clock,
 	}, nil
 }
 
 // EnableEncryption enables encryption for the storage service
-// 注意：新版本不再需要密码参数，使用系统密钥环
-func (s *StorageService) EnableEncryption(password string) {
+// 注意：新版本不再需要密码参数，使用系统密钥环。没有可用的密钥环时返回 ErrKeyringUnavailable，
+// 调用方不能在这种情况下把配置标记为已加密，否则数据会以明文保存
+func (s *StorageService) EnableEncryption(password string) error {
+	if s.crypto == nil {
+		return ErrKeyringUnavailable
+	}
+
 	// 如果提供了密码，说明是从旧版本迁移
-	if password != "" && s.crypto != nil {
+	if password != "" {
 		// 尝试从密码迁移到新系统
 		if err := s.crypto.MigrateFromPassword(password); err != nil {
 			fmt.Printf("Warning: failed to migrate from password encryption: %v\n", err)
 			// 作为fallback，仍然使用旧方式
 			s.securityMgr = NewSecurityManager(password)
 			s.oldEnabled = true
-		} else {
-			// 迁移成功，启用新系统
-			if err := s.crypto.Enable(); err != nil {
-				fmt.Printf("Warning: failed to enable secure crypto: %v\n", err)
-			}
-		}
-	} else if s.crypto != nil {
-		// 直接启用新系统
-		if err := s.crypto.Enable(); err != nil {
-			fmt.Printf("Warning: failed to enable secure crypto: %v\n", err)
+			return nil
 		}
 	}
+
+	if err := s.crypto.Enable(); err != nil {
+		return fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+	}
+	if !s.crypto.IsEnabled() {
+		return ErrKeyringUnavailable
+	}
+	return nil
+}
+
+// KeyringAvailable 报告系统密钥环是否可用，即能否启用本地加密
+func (s *StorageService) KeyringAvailable() bool {
+	return s.crypto != nil && s.crypto.KeyringAvailable()
 }
 
 // DisableEncryption disables encryption for the storage service
@@ -79,6 +127,113 @@ func (s *StorageService) DisableEncryption() error {
 	return nil
 }
 
+// DecryptDataFiles rewrites every encrypted file in the data directory as plaintext.
+// It must run before the key is dropped, otherwise existing history becomes unreadable.
+func (s *StorageService) DecryptDataFiles() error {
+	return s.rewriteDataFiles(s.decryptIfNeeded)
+}
+
+// MigrateLegacyEncryption 把数据目录中用旧密码加密的文件改为用密钥环中的主密钥加密，未加密的文件也一并加密。
+// 每个文件写入前后都确认能用主密钥解密出原内容；任一文件无法解密时返回错误，尚未处理的文件保持原样
+func (s *StorageService) MigrateLegacyEncryption(password string) error {
+	if s.crypto == nil {
+		return ErrKeyringUnavailable
+	}
+	if !s.crypto.IsEnabled() {
+		if err := s.crypto.Enable(); err != nil {
+			return fmt.Errorf("%w: %v", ErrKeyringUnavailable, err)
+		}
+	}
+
+	var legacy *SecurityManager
+	if password != "" {
+		legacy = NewSecurityManager(password)
+	}
+
+	for _, path := range dataFilePaths(s.fs, s.dataDir) {
+		data, err := s.fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := s.crypto.DecryptIfNeeded(data)
+		if err != nil && legacy != nil {
+			var decrypted string
+			if decrypted, err = legacy.Decrypt(strings.TrimPrefix(string(data), "ENC:")); err == nil {
+				plaintext = []byte(decrypted)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", ErrDecryptFailed, filepath.Base(path), err)
+		}
+
+		encrypted, err := s.crypto.EncryptIfNeeded(plaintext)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(encrypted, data) {
+			if err := s.fs.WriteFile(path, encrypted, 0644); err != nil {
+				return err
+			}
+		}
+
+		// Confirm the file on disk now reads back with the keyring key alone
+		written, err := s.fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if check, err := s.crypto.DecryptIfNeeded(written); err != nil || !bytes.Equal(check, plaintext) {
+			return fmt.Errorf("%w %s: it does not read back with the keyring key", ErrDecryptFailed, filepath.Base(path))
+		}
+	}
+
+	s.securityMgr = nil
+	s.oldEnabled = false
+	return nil
+}
+
+// dataFilePaths lists the existing files in dataDir that may hold encrypted data
+func dataFilePaths(fs FileSystem, dataDir string) []string {
+	var paths []string
+	for _, name := range []string{"sync_config.json", "secret_refs.json", "merge_base.json", "profile_merge_bases.json", "version_index.json", "staged_pull.json"} {
+		path := filepath.Join(dataDir, name)
+		if _, err := fs.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	for _, sub := range []string{"versions", "logs", "pending", "backups"} {
+		files, err := fs.ReadDir(filepath.Join(dataDir, sub))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !file.IsDir() {
+				paths = append(paths, filepath.Join(dataDir, sub, file.Name()))
+			}
+		}
+	}
+	return paths
+}
+
+// rewriteDataFiles applies transform to the sync config and every versions/logs/pending/backups file
+func (s *StorageService) rewriteDataFiles(transform func([]byte) ([]byte, error)) error {
+	for _, path := range dataFilePaths(s.fs, s.dataDir) {
+		data, err := s.fs.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rewritten, err := transform(data)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite %s: %w", filepath.Base(path), err)
+		}
+		if err := s.fs.WriteFile(path, rewritten, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // IsEncryptionEnabled checks if encryption is currently enabled
 func (s *StorageService) IsEncryptionEnabled() bool {
 	if s.crypto != nil && s.crypto.IsEnabled() {
@@ -146,8 +301,13 @@ func (s *StorageService) decryptIfNeeded(data []byte) ([]byte, error) {
 		return data, nil
 	}
 
+	// 有密钥但解密失败，说明文件是用另一个密钥加密的
+	if s.crypto != nil && s.crypto.IsEnabled() {
+		return nil, fmt.Errorf("%w file: it was encrypted with a different key", ErrDecryptFailed)
+	}
+
 	// 如果数据已加密但没有可用的解密系统
-	return nil, fmt.Errorf("file is encrypted but no decryption key available")
+	return nil, fmt.Errorf("file is encrypted but no decryption key available: %w", ErrEncryptionKeyMissing)
 }
 
 // decryptIfNeededOld 兼容旧的解密方法
@@ -162,7 +322,7 @@ func (s *StorageService) decryptIfNeededOld(data []byte) ([]byte, error) {
 	// Decrypt
 	decrypted, err := s.securityMgr.Decrypt(encryptedData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt file (check encryption password): %w", err)
+		return nil, fmt.Errorf("%w file (check encryption password): %w", ErrDecryptFailed, err)
 	}
 
 	return []byte(decrypted), nil
@@ -172,7 +332,7 @@ func (s *StorageService) SaveSyncConfig(config models.SyncConfig) error {
 	path := filepath.Join(s.dataDir, "sync_config.json")
 
 	// Ensure directory exists before saving
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	if err := s.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -187,7 +347,7 @@ func (s *StorageService) SaveSyncConfig(config models.SyncConfig) error {
 		return fmt.Errorf("failed to encrypt configuration: %w", err)
 	}
 
-	return ioutil.WriteFile(path, data, 0644)
+	return s.fs.WriteFile(path, data, 0644)
 }
 
 func (s *StorageService) LoadSyncConfig() (models.SyncConfig, error) {
@@ -195,21 +355,25 @@ func (s *StorageService) LoadSyncConfig() (models.SyncConfig, error) {
 
 	var config models.SyncConfig
 
-	if !fileExists(path) {
+	if !s.exists(path) {
 		// Return default config
 		config.ID = "default"
 		config.Servers = []models.MCPServer{}
-		config.LastSyncTime = time.Now()
+		config.LastSyncTime = s.clock.Now()
 		config.AutoSync = false
 		config.AutoSyncInterval = 3600 // 1 hour
 		return config, nil
 	}
 
-	data, err := ioutil.ReadFile(path)
+	data, err := s.fs.ReadFile(path)
 	if err != nil {
 		return config, err
 	}
 
+	if s.isEncrypted(data) && !s.IsEncryptionEnabled() {
+		return config, ErrEncryptionKeyMissing
+	}
+
 	// Decrypt if needed
 	data, err = s.decryptIfNeeded(data)
 	if err != nil {
@@ -222,13 +386,19 @@ func (s *StorageService) LoadSyncConfig() (models.SyncConfig, error) {
 
 	// Auto-enable encryption if configured but not yet enabled
 	if config.EnableEncryption && !s.IsEncryptionEnabled() {
+		// Minting a new key would orphan files encrypted with the lost one
+		if s.hasEncryptedFiles() {
+			return config, ErrEncryptionKeyMissing
+		}
 		println("Auto-enabling local storage encryption")
-		s.EnableEncryption("") // 新版本不需要密码
+		if err := s.EnableEncryption(""); err != nil { // 新版本不需要密码
+			fmt.Printf("Warning: local storage stays unencrypted: %v\n", err)
+		}
 
 		// Re-encrypt the file if it's not already encrypted
 		data, _ := json.MarshalIndent(config, "", "  ")
 		data, _ = s.encryptIfNeeded(data)
-		ioutil.WriteFile(path, data, 0644)
+		s.fs.WriteFile(path, data, 0644)
 	}
 
 	// 处理密码迁移逻辑
@@ -242,21 +412,33 @@ func (s *StorageService) LoadSyncConfig() (models.SyncConfig, error) {
 		// 保存更新后的配置（包含新的密码字段）
 		configData, _ := json.MarshalIndent(config, "", "  ")
 		configData, _ = s.encryptIfNeeded(configData)
-		ioutil.WriteFile(path, configData, 0644)
+		s.fs.WriteFile(path, configData, 0644)
 	}
 
 	return config, nil
 }
 
+// SaveConfigVersion 保存一个配置版本，并把 Content 的语义 hash（见 canonicalConfigHash）记录在 Hash 中，读取时不需要重新计算。
+// 内容与最新版本只有格式不同时不再保存；更早的相同内容仍会保存，这样最新版本始终反映最近一次的配置
 func (s *StorageService) SaveConfigVersion(version models.ConfigVersion) error {
 	dir := filepath.Join(s.dataDir, "versions")
 
 	// Ensure directory exists before saving
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := s.fs.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create versions directory: %w", err)
 	}
 
-	filename := fmt.Sprintf("version_%d.json", time.Now().Unix())
+	version.Hash = canonicalConfigHash(version.Content)
+	names, err := s.versionFileNames()
+	if err != nil {
+		return err
+	}
+	index := s.loadVersionIndex()
+	if len(names) > 0 && s.versionHash(index, names[len(names)-1]) == version.Hash {
+		return nil
+	}
+
+	filename := fmt.Sprintf("version_%d.json", s.clock.Now().UnixNano())
 	path := filepath.Join(dir, filename)
 
 	data, err := json.MarshalIndent(version, "", "  ")
@@ -270,20 +452,157 @@ func (s *StorageService) SaveConfigVersion(version models.ConfigVersion) error {
 		return fmt.Errorf("failed to encrypt version: %w", err)
 	}
 
-	return ioutil.WriteFile(path, data, 0644)
+	if err := s.fs.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	// Drop entries for versions that were deleted or pruned since the last save
+	current := make(map[string]string, len(names)+1)
+	for _, name := range names {
+		if hash, ok := index[name]; ok {
+			current[name] = hash
+		}
+	}
+	current[filename] = version.Hash
+	if err := s.saveVersionIndex(current); err != nil {
+		println(fmt.Sprintf("Warning: failed to update version index: %v", err))
+	}
+	return nil
 }
 
-func (s *StorageService) ListConfigVersions(limit int) ([]models.ConfigVersion, error) {
+// versionIndexPath 返回版本 hash 索引（版本文件名 -> 内容 hash）的路径。
+// 索引不放在 versions 目录中，以免被当作版本文件读取
+func (s *StorageService) versionIndexPath() string {
+	return filepath.Join(s.dataDir, "version_index.json")
+}
+
+// versionFileNames 返回 versions 目录中的版本文件名，从旧到新排列
+func (s *StorageService) versionFileNames() ([]string, error) {
 	dir := filepath.Join(s.dataDir, "versions")
+	if !s.exists(dir) {
+		return nil, nil
+	}
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// versionIndexFormat 是版本 hash 索引的格式版本，hash 的算法改变时递增。
+// 1 之前的索引（没有 version 字段）记录的是原文的 SHA-256，2 起为 canonicalConfigHash
+const versionIndexFormat = 2
+
+// versionIndexFile 是 version_index.json 的内容
+type versionIndexFile struct {
+	Version int               `json:"version"`
+	Hashes  map[string]string `json:"hashes"`
+}
+
+// loadVersionIndex 读取版本 hash 索引；索引不存在、无法读取或格式版本不是 versionIndexFormat 时返回空索引，
+// 缺少的条目由 versionHash 按当前算法补上
+func (s *StorageService) loadVersionIndex() map[string]string {
+	index := make(map[string]string)
+	data, err := s.fs.ReadFile(s.versionIndexPath())
+	if err != nil {
+		return index
+	}
+	if data, err = s.decryptIfNeeded(data); err != nil {
+		return index
+	}
+	var stored versionIndexFile
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != versionIndexFormat {
+		return index
+	}
+	for name, hash := range stored.Hashes {
+		index[name] = hash
+	}
+	return index
+}
+
+func (s *StorageService) saveVersionIndex(index map[string]string) error {
+	data, err := json.MarshalIndent(versionIndexFile{Version: versionIndexFormat, Hashes: index}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data, err = s.encryptIfNeeded(data)
+	if err != nil {
+		return err
+	}
+	return s.fs.WriteFile(s.versionIndexPath(), data, 0644)
+}
 
-	if !fileExists(dir) {
-		return []models.ConfigVersion{}, nil
+// versionHash 返回版本文件 name 的内容 hash。索引中没有时读取文件计算并加入 index；文件中保存的 Hash 不使用，
+// 旧版本保存的是原文的 hash。文件无法读取时返回空字符串
+func (s *StorageService) versionHash(index map[string]string, name string) string {
+	if hash, ok := index[name]; ok {
+		return hash
 	}
+	version, err := s.readConfigVersion(filepath.Join(s.dataDir, "versions", name))
+	if err != nil {
+		return ""
+	}
+	hash := canonicalConfigHash(version.Content)
+	index[name] = hash
+	return hash
+}
 
-	files, err := ioutil.ReadDir(dir)
+// FindConfigVersionByHash 返回内容 hash 为 hash 的最新版本，没有时返回 ErrNotFound
+func (s *StorageService) FindConfigVersionByHash(hash string) (*models.ConfigVersion, error) {
+	names, err := s.versionFileNames()
 	if err != nil {
 		return nil, err
 	}
+	index := s.loadVersionIndex()
+	indexed := len(index)
+	defer func() {
+		// Keep the hashes computed for versions missing from the index or indexed with an older algorithm
+		if len(index) > indexed {
+			if err := s.saveVersionIndex(index); err != nil {
+				println(fmt.Sprintf("Warning: failed to update version index: %v", err))
+			}
+		}
+	}()
+
+	for i := len(names) - 1; i >= 0; i-- {
+		if s.versionHash(index, names[i]) != hash {
+			continue
+		}
+		version, err := s.readConfigVersion(filepath.Join(s.dataDir, "versions", names[i]))
+		if err != nil {
+			return nil, err
+		}
+		version.Hash = hash
+		return version, nil
+	}
+	return nil, fmt.Errorf("version with hash %s: %w", hash, ErrNotFound)
+}
+
+func (s *StorageService) ListConfigVersions(limit int) ([]models.ConfigVersion, error) {
+	versions, _, err := s.ListConfigVersionsWithSkipped(limit)
+	return versions, err
+}
+
+// ListConfigVersionsWithSkipped 与 ListConfigVersions 相同，但同时返回因读取、解密或解析失败而跳过的文件数
+func (s *StorageService) ListConfigVersionsWithSkipped(limit int) ([]models.ConfigVersion, int, error) {
+	dir := filepath.Join(s.dataDir, "versions")
+
+	if !s.exists(dir) {
+		return []models.ConfigVersion{}, 0, nil
+	}
+
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	skipped := 0
 
 	var versions []models.ConfigVersion
 
@@ -293,39 +612,149 @@ func (s *StorageService) ListConfigVersions(limit int) ([]models.ConfigVersion,
 			continue
 		}
 
-		path := filepath.Join(dir, files[i].Name())
-		data, err := ioutil.ReadFile(path)
+		// Skip files that can't be read, decrypted or parsed
+		version, err := s.readConfigVersion(filepath.Join(dir, files[i].Name()))
 		if err != nil {
+			skipped++
 			continue
 		}
 
-		// Decrypt if needed
+		versions = append(versions, *version)
+	}
+
+	return versions, skipped, nil
+}
+
+// readConfigVersion 读取并解密一个版本文件
+func (s *StorageService) readConfigVersion(path string) (*models.ConfigVersion, error) {
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.decryptIfNeeded(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var version models.ConfigVersion
+	if err := json.Unmarshal(data, &version); err != nil {
+		return nil, fmt.Errorf("invalid version file %s: %w", filepath.Base(path), err)
+	}
+	return &version, nil
+}
+
+// findConfigVersion 返回 ID 为 versionID 的版本文件路径；versionID 也可以是 versions 目录中的文件名，
+// 这样无法解密或解析的文件也能找到。找不到时返回 ErrNotFound
+func (s *StorageService) findConfigVersion(versionID string) (string, error) {
+	if versionID == "" || versionID != filepath.Base(versionID) {
+		return "", fmt.Errorf("invalid version ID %q", versionID)
+	}
+
+	dir := filepath.Join(s.dataDir, "versions")
+	if !s.exists(dir) {
+		return "", fmt.Errorf("version %s: %w", versionID, ErrNotFound)
+	}
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	// Newest first, like ListConfigVersions
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, files[i].Name())
+		if files[i].Name() == versionID {
+			return path, nil
+		}
+		if version, err := s.readConfigVersion(path); err == nil && version.ID == versionID {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("version %s: %w", versionID, ErrNotFound)
+}
+
+// GetConfigVersion 按 ID 或文件名读取一个版本，解密失败时返回具体原因
+func (s *StorageService) GetConfigVersion(versionID string) (*models.ConfigVersion, error) {
+	path, err := s.findConfigVersion(versionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.readConfigVersion(path)
+}
+
+// DeleteConfigVersion 按 ID 或文件名删除一个版本文件
+func (s *StorageService) DeleteConfigVersion(versionID string) error {
+	path, err := s.findConfigVersion(versionID)
+	if err != nil {
+		return err
+	}
+	return s.fs.Remove(path)
+}
+
+// PruneConfigVersions 删除早于 maxAge 的版本文件，返回删除的数量。
+// 无法解密或解析的文件会被保留，以免误删仍可恢复的历史
+func (s *StorageService) PruneConfigVersions(maxAge time.Duration) (int, error) {
+	dir := filepath.Join(s.dataDir, "versions")
+	if !s.exists(dir) {
+		return 0, nil
+	}
+
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := s.clock.Now().Add(-maxAge)
+	pruned := 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		data, err := s.fs.ReadFile(path)
+		if err != nil {
+			continue
+		}
 		data, err = s.decryptIfNeeded(data)
 		if err != nil {
-			// Skip files that can't be decrypted
 			continue
 		}
-
 		var version models.ConfigVersion
 		if err := json.Unmarshal(data, &version); err != nil {
 			continue
 		}
 
-		versions = append(versions, version)
+		// 旧版本文件可能没有时间戳，退回到文件修改时间
+		created := version.Timestamp
+		if created.IsZero() {
+			created = file.ModTime()
+		}
+		if !created.Before(cutoff) {
+			continue
+		}
+
+		if err := s.fs.Remove(path); err != nil {
+			return pruned, fmt.Errorf("failed to prune %s: %w", file.Name(), err)
+		}
+		pruned++
 	}
 
-	return versions, nil
+	return pruned, nil
 }
 
 func (s *StorageService) SaveSyncLog(log models.SyncLog) error {
 	dir := filepath.Join(s.dataDir, "logs")
 
 	// Ensure directory exists before saving
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := s.fs.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("failed to create logs directory: %w", err)
 	}
 
-	filename := fmt.Sprintf("sync_%d.json", time.Now().Unix())
+	filename := fmt.Sprintf("sync_%d.json", s.clock.Now().UnixNano())
 	path := filepath.Join(dir, filename)
 
 	data, err := json.MarshalIndent(log, "", "  ")
@@ -339,21 +768,29 @@ func (s *StorageService) SaveSyncLog(log models.SyncLog) error {
 		return fmt.Errorf("failed to encrypt log: %w", err)
 	}
 
-	return ioutil.WriteFile(path, data, 0644)
+	return s.fs.WriteFile(path, data, 0644)
 }
 
 func (s *StorageService) GetSyncLogs(limit int) ([]models.SyncLog, error) {
+	logs, _, err := s.GetSyncLogsWithSkipped(limit)
+	return logs, err
+}
+
+// GetSyncLogsWithSkipped 与 GetSyncLogs 相同，但同时返回因读取、解密或解析失败而跳过的文件数
+func (s *StorageService) GetSyncLogsWithSkipped(limit int) ([]models.SyncLog, int, error) {
 	dir := filepath.Join(s.dataDir, "logs")
 
-	if !fileExists(dir) {
-		return []models.SyncLog{}, nil
+	if !s.exists(dir) {
+		return []models.SyncLog{}, 0, nil
 	}
 
-	files, err := ioutil.ReadDir(dir)
+	files, err := s.fs.ReadDir(dir)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	skipped := 0
+
 	var logs []models.SyncLog
 
 	// Read files in reverse order (newest first)
@@ -363,8 +800,9 @@ func (s *StorageService) GetSyncLogs(limit int) ([]models.SyncLog, error) {
 		}
 
 		path := filepath.Join(dir, files[i].Name())
-		data, err := ioutil.ReadFile(path)
+		data, err := s.fs.ReadFile(path)
 		if err != nil {
+			skipped++
 			continue
 		}
 
@@ -372,18 +810,663 @@ func (s *StorageService) GetSyncLogs(limit int) ([]models.SyncLog, error) {
 		data, err = s.decryptIfNeeded(data)
 		if err != nil {
 			// Skip files that can't be decrypted
+			skipped++
 			continue
 		}
 
 		var log models.SyncLog
 		if err := json.Unmarshal(data, &log); err != nil {
+			skipped++
 			continue
 		}
 
 		logs = append(logs, log)
 	}
 
-	return logs, nil
+	return logs, skipped, nil
+}
+
+// AppendAuditEntry 在 audit.log 末尾追加一行审计记录（JSON Lines）。记录只包含路径和 hash，不含配置内容，因此不加密
+func (s *StorageService) AppendAuditEntry(entry models.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.fs.AppendFile(filepath.Join(s.dataDir, "audit.log"), append(data, '\n'), 0644)
+}
+
+// GetAuditLog 返回最新的 limit 条审计记录，最新的在前；无法解析的行被跳过
+func (s *StorageService) GetAuditLog(limit int) ([]models.AuditEntry, error) {
+	path := filepath.Join(s.dataDir, "audit.log")
+	if !s.exists(path) {
+		return []models.AuditEntry{}, nil
+	}
+
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	entries := []models.AuditEntry{}
+	for i := len(lines) - 1; i >= 0 && len(entries) < limit; i-- {
+		var entry models.AuditEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SavePendingPush queues a push payload that could not be sent because the network was unavailable
+func (s *StorageService) SavePendingPush(push models.PendingPush) error {
+	dir := filepath.Join(s.dataDir, "pending")
+
+	// Ensure directory exists before saving
+	if err := s.fs.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create pending directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(push, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Encrypt if encryption is enabled
+	data, err = s.encryptIfNeeded(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt pending push: %w", err)
+	}
+
+	return s.fs.WriteFile(filepath.Join(dir, "push_"+push.ID+".json"), data, 0644)
+}
+
+// ListPendingPushes returns queued pushes, oldest first
+func (s *StorageService) ListPendingPushes() ([]models.PendingPush, error) {
+	dir := filepath.Join(s.dataDir, "pending")
+
+	if !s.exists(dir) {
+		return []models.PendingPush{}, nil
+	}
+
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pushes []models.PendingPush
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		data, err := s.fs.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = s.decryptIfNeeded(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt pending push %s: %w", file.Name(), err)
+		}
+
+		var push models.PendingPush
+		if err := json.Unmarshal(data, &push); err != nil {
+			return nil, fmt.Errorf("failed to parse pending push %s: %w", file.Name(), err)
+		}
+
+		pushes = append(pushes, push)
+	}
+
+	return pushes, nil
+}
+
+// DeletePendingPush removes a queued push once it has been sent or superseded
+func (s *StorageService) DeletePendingPush(id string) error {
+	path := filepath.Join(s.dataDir, "pending", "push_"+id+".json")
+	if err := s.fs.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// BackupAgentFile copies an agent config file into the backups directory before it is overwritten.
+// Returns the backup path, or an empty string if the source file does not exist yet.
+func (s *StorageService) BackupAgentFile(agentID, sourcePath string) (string, error) {
+	if !s.exists(sourcePath) {
+		return "", nil
+	}
+
+	dir := filepath.Join(s.dataDir, "backups")
+
+	// Ensure directory exists before saving
+	if err := s.fs.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backups directory: %w", err)
+	}
+
+	data, err := s.fs.ReadFile(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s for backup: %w", sourcePath, err)
+	}
+
+	// Encrypt if encryption is enabled
+	data, err = s.encryptIfNeeded(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+
+	filename := fmt.Sprintf("%s_%d%s", agentID, s.clock.Now().UnixNano(), filepath.Ext(sourcePath))
+	path := filepath.Join(dir, filename)
+
+	if err := s.fs.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// PruneBackups 按保留策略删除 backups 目录中的 agent 配置备份，返回删除的数量。每个 agent 分别计算：
+// 最近的 maxCount 个备份和不早于 maxAge 的备份都保留，其余删除；maxCount 和 maxAge 都为 0 时不删除任何备份
+func (s *StorageService) PruneBackups(maxCount int, maxAge time.Duration) (int, error) {
+	if maxCount <= 0 && maxAge <= 0 {
+		return 0, nil
+	}
+	dir := filepath.Join(s.dataDir, "backups")
+	if !s.exists(dir) {
+		return 0, nil
+	}
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	type backup struct {
+		name    string
+		created time.Time
+	}
+	byAgent := make(map[string][]backup)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		// Backups are named <agent>_<unix nanoseconds><ext>; fall back to the modification time
+		name := file.Name()
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		sep := strings.LastIndex(stem, "_")
+		if sep <= 0 {
+			continue
+		}
+		created := file.ModTime()
+		if nanos, err := strconv.ParseInt(stem[sep+1:], 10, 64); err == nil {
+			created = time.Unix(0, nanos)
+		}
+		byAgent[stem[:sep]] = append(byAgent[stem[:sep]], backup{name: name, created: created})
+	}
+
+	cutoff := s.clock.Now().Add(-maxAge)
+	pruned := 0
+	for _, backups := range byAgent {
+		sort.Slice(backups, func(i, j int) bool { return backups[i].created.After(backups[j].created) })
+		for i, b := range backups {
+			if maxCount > 0 && i < maxCount {
+				continue
+			}
+			if maxAge > 0 && !b.created.Before(cutoff) {
+				continue
+			}
+			if err := s.fs.Remove(filepath.Join(dir, b.name)); err != nil {
+				return pruned, fmt.Errorf("failed to prune backup %s: %w", b.name, err)
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// SaveMergeBase 保存最近一次成功同步时的配置快照，作为三方合并的共同祖先
+func (s *StorageService) SaveMergeBase(base models.ConfigVersion) error {
+	path := filepath.Join(s.dataDir, "merge_base.json")
+
+	data, err := json.MarshalIndent(base, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data, err = s.encryptIfNeeded(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt merge base: %w", err)
+	}
+
+	return s.fs.WriteFile(path, data, 0644)
+}
+
+// LoadMergeBase 读取合并基准快照；尚未成功同步过时返回 nil
+func (s *StorageService) LoadMergeBase() (*models.ConfigVersion, error) {
+	path := filepath.Join(s.dataDir, "merge_base.json")
+	if !s.exists(path) {
+		return nil, nil
+	}
+
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.decryptIfNeeded(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt merge base: %w", err)
+	}
+
+	var base models.ConfigVersion
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, err
+	}
+	return &base, nil
+}
+
+// ClearMergeBase 删除合并基准快照，例如切换到另一个 Gist 之后
+func (s *StorageService) ClearMergeBase() error {
+	err := s.fs.Remove(filepath.Join(s.dataDir, "merge_base.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// profileMergeBasesPath 返回非当前同步配置（profile）的合并基准的保存位置，以配置名为键
+func (s *StorageService) profileMergeBasesPath() string {
+	return filepath.Join(s.dataDir, "profile_merge_bases.json")
+}
+
+func (s *StorageService) loadProfileMergeBases() (map[string]models.ConfigVersion, error) {
+	bases := make(map[string]models.ConfigVersion)
+	path := s.profileMergeBasesPath()
+	if !s.exists(path) {
+		return bases, nil
+	}
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = s.decryptIfNeeded(data); err != nil {
+		return nil, fmt.Errorf("failed to decrypt profile merge bases: %w", err)
+	}
+	if err := json.Unmarshal(data, &bases); err != nil {
+		return nil, err
+	}
+	return bases, nil
+}
+
+func (s *StorageService) saveProfileMergeBases(bases map[string]models.ConfigVersion) error {
+	data, err := json.MarshalIndent(bases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = s.encryptIfNeeded(data); err != nil {
+		return fmt.Errorf("failed to encrypt profile merge bases: %w", err)
+	}
+	return s.fs.WriteFile(s.profileMergeBasesPath(), data, 0644)
+}
+
+// SwapMergeBase 在切换同步配置时交换合并基准：当前的合并基准保存为配置 from 的，配置 to 之前保存的合并基准成为当前的；
+// to 从未同步过时清除当前的合并基准
+func (s *StorageService) SwapMergeBase(from, to string) error {
+	bases, err := s.loadProfileMergeBases()
+	if err != nil {
+		return err
+	}
+	current, err := s.LoadMergeBase()
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		bases[from] = *current
+	} else {
+		delete(bases, from)
+	}
+
+	next, ok := bases[to]
+	delete(bases, to)
+	if ok {
+		err = s.SaveMergeBase(next)
+	} else {
+		err = s.ClearMergeBase()
+	}
+	if err != nil {
+		return err
+	}
+	return s.saveProfileMergeBases(bases)
+}
+
+// SaveStagedPull 保存暂存的拉取，替换之前暂存的内容
+func (s *StorageService) SaveStagedPull(staged models.StagedPull) error {
+	data, err := json.MarshalIndent(staged, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data, err = s.encryptIfNeeded(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt staged pull: %w", err)
+	}
+
+	return s.fs.WriteFile(filepath.Join(s.dataDir, "staged_pull.json"), data, 0644)
+}
+
+// LoadStagedPull 读取暂存的拉取；没有暂存内容时返回 nil
+func (s *StorageService) LoadStagedPull() (*models.StagedPull, error) {
+	path := filepath.Join(s.dataDir, "staged_pull.json")
+	if !s.exists(path) {
+		return nil, nil
+	}
+
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.decryptIfNeeded(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt staged pull: %w", err)
+	}
+
+	var staged models.StagedPull
+	if err := json.Unmarshal(data, &staged); err != nil {
+		return nil, err
+	}
+	return &staged, nil
+}
+
+// ClearStagedPull 删除暂存的拉取
+func (s *StorageService) ClearStagedPull() error {
+	err := s.fs.Remove(filepath.Join(s.dataDir, "staged_pull.json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// conflictFilePath 返回操作 operationID 的冲突文件路径
+func (s *StorageService) conflictFilePath(operationID string) (string, error) {
+	if operationID == "" || operationID != filepath.Base(operationID) || strings.HasPrefix(operationID, ".") {
+		return "", fmt.Errorf("invalid operation ID %q", operationID)
+	}
+	return filepath.Join(s.dataDir, "conflicts", operationID+".json"), nil
+}
+
+// SaveConflictFile 把冲突文档写入 conflicts/<操作 ID>.json 并返回路径。
+// 文件需要用户手动编辑，因此始终以明文保存，权限只对当前用户开放
+func (s *StorageService) SaveConflictFile(file models.ConflictFile) (string, error) {
+	path, err := s.conflictFilePath(file.OperationID)
+	if err != nil {
+		return "", err
+	}
+	if err := s.fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create conflicts directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := s.fs.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// LoadConflictFile 读取操作 operationID 的冲突文件；文件不存在时返回 ErrNotFound
+func (s *StorageService) LoadConflictFile(operationID string) (*models.ConflictFile, error) {
+	path, err := s.conflictFilePath(operationID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.exists(path) {
+		return nil, fmt.Errorf("conflict file %s: %w", operationID, ErrNotFound)
+	}
+
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file models.ConflictFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid conflict file %s: %w", filepath.Base(path), err)
+	}
+	return &file, nil
+}
+
+// DeleteConflictFile 删除操作 operationID 的冲突文件，文件不存在时不报错
+func (s *StorageService) DeleteConflictFile(operationID string) error {
+	path, err := s.conflictFilePath(operationID)
+	if err != nil {
+		return err
+	}
+	if err := s.fs.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ListConflictFiles 返回尚未应用的冲突文件对应的操作 ID
+func (s *StorageService) ListConflictFiles() ([]string, error) {
+	dir := filepath.Join(s.dataDir, "conflicts")
+	if !s.exists(dir) {
+		return []string{}, nil
+	}
+	files, err := s.fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return ids, nil
+}
+
+// schemaCachePath 返回 key 对应的 schema 缓存文件路径
+func (s *StorageService) schemaCachePath(key string) (string, error) {
+	if key == "" || key != filepath.Base(key) || strings.HasPrefix(key, ".") {
+		return "", fmt.Errorf("invalid schema cache key %q", key)
+	}
+	return filepath.Join(s.dataDir, "schemas", key+".json"), nil
+}
+
+// SaveSchemaCache 把下载的 JSON schema 保存到 schemas/<key>.json。schema 是公开内容，不加密
+func (s *StorageService) SaveSchemaCache(key string, data []byte) error {
+	path, err := s.schemaCachePath(key)
+	if err != nil {
+		return err
+	}
+	if err := s.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create schemas directory: %w", err)
+	}
+	return s.fs.WriteFile(path, data, 0644)
+}
+
+// LoadSchemaCache 读取缓存的 schema 及其保存时间；没有缓存时返回 ErrNotFound
+func (s *StorageService) LoadSchemaCache(key string) ([]byte, time.Time, error) {
+	path, err := s.schemaCachePath(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := s.fs.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, fmt.Errorf("schema cache %s: %w", key, ErrNotFound)
+		}
+		return nil, time.Time{}, err
+	}
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
+
+// SaveSecretRefs 保存 env 值到 ${secret:name} 模板的映射（只包含引用，不包含密钥值）
+func (s *StorageService) SaveSecretRefs(refs map[string]string) error {
+	path := filepath.Join(s.dataDir, "secret_refs.json")
+
+	data, err := json.MarshalIndent(refs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	data, err = s.encryptIfNeeded(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret references: %w", err)
+	}
+
+	return s.fs.WriteFile(path, data, 0644)
+}
+
+// LoadSecretRefs 读取 env 值到 ${secret:name} 模板的映射
+func (s *StorageService) LoadSecretRefs() (map[string]string, error) {
+	path := filepath.Join(s.dataDir, "secret_refs.json")
+
+	refs := make(map[string]string)
+	if !s.exists(path) {
+		return refs, nil
+	}
+
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.decryptIfNeeded(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret references: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// SaveMetrics 保存本地同步指标；指标不包含配置内容，因此不加密
+func (s *StorageService) SaveMetrics(metrics models.SyncMetrics) error {
+	data, err := json.MarshalIndent(metrics, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.fs.WriteFile(filepath.Join(s.dataDir, "metrics.json"), data, 0644)
+}
+
+// LoadMetrics 读取本地同步指标；尚未记录过时返回空指标
+func (s *StorageService) LoadMetrics() (models.SyncMetrics, error) {
+	var metrics models.SyncMetrics
+	path := filepath.Join(s.dataDir, "metrics.json")
+	if !s.exists(path) {
+		return metrics, nil
+	}
+
+	data, err := s.fs.ReadFile(path)
+	if err != nil {
+		return metrics, err
+	}
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return models.SyncMetrics{}, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// hasEncryptedFiles 检查数据目录中是否存在已加密的文件
+func (s *StorageService) hasEncryptedFiles() bool {
+	for _, path := range dataFilePaths(s.fs, s.dataDir) {
+		data, err := s.fs.ReadFile(path)
+		if err == nil && s.isEncrypted(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// OrphanEncryptedFiles 将无法解密的加密文件移动到 orphaned_<时间> 目录（不删除），返回该目录
+func (s *StorageService) OrphanEncryptedFiles() (string, error) {
+	orphanDir := filepath.Join(s.dataDir, fmt.Sprintf("orphaned_%d", s.clock.Now().UnixNano()))
+	moved := 0
+	for _, path := range dataFilePaths(s.fs, s.dataDir) {
+		data, err := s.fs.ReadFile(path)
+		if err != nil || !s.isEncrypted(data) {
+			continue
+		}
+		if _, err := s.decryptIfNeeded(data); err == nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(s.dataDir, path)
+		if err != nil {
+			return "", err
+		}
+		target := filepath.Join(orphanDir, rel)
+		if err := s.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", err
+		}
+		if err := s.fs.Rename(path, target); err != nil {
+			return "", fmt.Errorf("failed to move %s: %w", rel, err)
+		}
+		moved++
+	}
+
+	if moved == 0 {
+		return "", nil
+	}
+	return orphanDir, nil
+}
+
+// CheckWritable 检查数据目录是否可写
+func (s *StorageService) CheckWritable() error {
+	if err := s.fs.MkdirAll(s.dataDir, 0755); err != nil {
+		return err
+	}
+	probe := filepath.Join(s.dataDir, ".write_probe")
+	if err := s.fs.WriteFile(probe, []byte("ok"), 0644); err != nil {
+		return err
+	}
+	return s.fs.Remove(probe)
+}
+
+// UndecryptableFiles 返回无法解密或解析的版本和日志文件，格式为 "目录/文件名: 原因"
+func (s *StorageService) UndecryptableFiles() []string {
+	var broken []string
+	for _, sub := range []string{"versions", "logs"} {
+		dir := filepath.Join(s.dataDir, sub)
+		files, err := s.fs.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+				continue
+			}
+			data, err := s.fs.ReadFile(filepath.Join(dir, file.Name()))
+			if err == nil {
+				data, err = s.decryptIfNeeded(data)
+			}
+			if err == nil && !json.Valid(data) {
+				err = fmt.Errorf("invalid JSON")
+			}
+			if err != nil {
+				broken = append(broken, fmt.Sprintf("%s: %v", filepath.Join(sub, file.Name()), err))
+			}
+		}
+	}
+	return broken
+}
+
+// exists 检查路径是否存在
+func (s *StorageService) exists(path string) bool {
+	_, err := s.fs.Stat(path)
+	return err == nil
 }
 
 func (s *StorageService) GetDataDir() string {
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// fakeClock is a Clock that only moves when the test advances it
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestPruneConfigVersionsByAge(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	storage, err := NewStorageServiceWithKeyring(t.TempDir(), nil, clock, NewInMemoryKeyring())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One version per day for five days
+	for _, id := range []string{"day1", "day2", "day3", "day4", "day5"} {
+		if err := storage.SaveConfigVersion(models.ConfigVersion{ID: id, Timestamp: clock.Now(), Content: id}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(24 * time.Hour)
+	}
+
+	// Now is the start of day 6; keep anything from the last 3 days
+	pruned, err := storage.PruneConfigVersions(72 * time.Hour)
+	if err != nil {
+		t.Fatalf("PruneConfigVersions() error = %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("PruneConfigVersions() pruned %d, want 2", pruned)
+	}
+
+	versions, err := storage.ListConfigVersions(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, v := range versions {
+		ids = append(ids, v.ID)
+	}
+	if len(ids) != 3 || ids[0] != "day5" || ids[2] != "day3" {
+		t.Errorf("remaining versions = %v, want [day5 day4 day3]", ids)
+	}
+
+	// Nothing else is old enough until the clock moves on
+	if pruned, _ := storage.PruneConfigVersions(72 * time.Hour); pruned != 0 {
+		t.Errorf("second prune removed %d versions, want 0", pruned)
+	}
+	clock.Advance(24 * time.Hour)
+	if pruned, _ := storage.PruneConfigVersions(72 * time.Hour); pruned != 1 {
+		t.Errorf("prune after a day removed %d versions, want 1", pruned)
+	}
+}
+
+func TestSaveConfigVersionSkipsDuplicateContent(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	storage, err := NewStorageServiceWithKeyring(t.TempDir(), nil, clock, NewInMemoryKeyring())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(id, content string) {
+		t.Helper()
+		clock.Advance(time.Minute)
+		if err := storage.SaveConfigVersion(models.ConfigVersion{ID: id, Timestamp: clock.Now(), Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := func() []string {
+		versions, err := storage.ListConfigVersions(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, v := range versions {
+			ids = append(ids, v.ID)
+		}
+		return ids
+	}
+
+	save("v1", `{"cursor": {}}`)
+	save("v1-again", `{"cursor": {}}`)
+	if got := ids(); !reflect.DeepEqual(got, []string{"v1"}) {
+		t.Fatalf("versions after saving identical content twice = %v, want [v1]", got)
+	}
+
+	// Returning to earlier content is a new state and is kept, so the newest version stays current
+	save("v2", `{"zed": {}}`)
+	save("v3", `{"cursor": {}}`)
+	if got := ids(); !reflect.DeepEqual(got, []string{"v3", "v2", "v1"}) {
+		t.Errorf("versions = %v, want [v3 v2 v1]", got)
+	}
+
+	found, err := storage.FindConfigVersionByHash(canonicalConfigHash(`{"zed": {}}`))
+	if err != nil || found.ID != "v2" {
+		t.Errorf("FindConfigVersionByHash() = %+v, %v, want v2", found, err)
+	}
+	if _, err := storage.FindConfigVersionByHash(computeHash("never saved")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FindConfigVersionByHash() for unknown content error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestConfigVersionHashIsPersisted(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewStorageServiceWithKeyring(dir, nil, nil, NewInMemoryKeyring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.crypto = nil
+
+	// A version saved before hashes were stored, with no index entry
+	versionsDir := filepath.Join(dir, "versions")
+	if err := os.MkdirAll(versionsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(versionsDir, "version_1.json"), []byte(`{"id": "legacy", "content": "old"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveConfigVersion(models.ConfigVersion{ID: "legacy-again", Content: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveConfigVersion(models.ConfigVersion{ID: "new", Content: "new", Hash: "ignored"}); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListConfigVersions(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].ID != "new" || versions[1].ID != "legacy" {
+		t.Fatalf("versions = %+v, want new and legacy", versions)
+	}
+	if versions[0].Hash != computeHash("new") {
+		t.Errorf("stored hash = %q, want the SHA-256 of the content", versions[0].Hash)
+	}
+
+	var index versionIndexFile
+	if err := json.Unmarshal([]byte(readFile(t, filepath.Join(dir, "version_index.json"))), &index); err != nil {
+		t.Fatalf("version index is not readable: %v", err)
+	}
+	if index.Version != versionIndexFormat || index.Hashes["version_1.json"] != computeHash("old") || len(index.Hashes) != 2 {
+		t.Errorf("version index = %+v, want hashes for both versions", index)
+	}
+}
+
+func TestVersionIndexFromBeforeCanonicalHashesIsRebuilt(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewStorageServiceWithKeyring(dir, nil, nil, NewInMemoryKeyring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.crypto = nil
+
+	// A version and index written when hashes were taken over the raw content
+	content := "{\n  \"cursor\": {\"mcpServers\": {}}\n}"
+	versionsDir := filepath.Join(dir, "versions")
+	if err := os.MkdirAll(versionsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacy, _ := json.Marshal(models.ConfigVersion{ID: "legacy", Content: content, Hash: computeHash(content)})
+	if err := os.WriteFile(filepath.Join(versionsDir, "version_1.json"), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldIndex, _ := json.Marshal(map[string]string{"version_1.json": computeHash(content)})
+	if err := os.WriteFile(filepath.Join(dir, "version_index.json"), oldIndex, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := storage.FindConfigVersionByHash(canonicalConfigHash(content))
+	if err != nil || found.ID != "legacy" || found.Hash != canonicalConfigHash(content) {
+		t.Fatalf("FindConfigVersionByHash() = %+v, %v, want legacy with its canonical hash", found, err)
+	}
+	if index := storage.loadVersionIndex(); index["version_1.json"] != canonicalConfigHash(content) {
+		t.Errorf("version index after lookup = %v, want the canonical hash", index)
+	}
+
+	// The same config, formatted differently, is not saved again
+	if err := storage.SaveConfigVersion(models.ConfigVersion{ID: "again", Content: `{"cursor":{"mcpServers":{}}}`}); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := storage.versionFileNames(); len(names) != 1 {
+		t.Errorf("versions = %v, want the legacy version only", names)
+	}
+}
+
+// backupNames lists the files in the backups directory
+func backupNames(t *testing.T, storage *StorageService) []string {
+	t.Helper()
+	files, err := os.ReadDir(filepath.Join(storage.GetDataDir(), "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	return names
+}
+
+func TestPruneBackups(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxCount   int
+		maxAge     time.Duration
+		wantPruned int
+		wantKept   []string // per agent, newest first
+	}{
+		// Five daily backups of cursor and two of codex; now is one day after the last one
+		{name: "count", maxCount: 2, wantPruned: 3, wantKept: []string{"cursor day5", "cursor day4", "codex day5", "codex day4"}},
+		{name: "age", maxAge: 72 * time.Hour, wantPruned: 2, wantKept: []string{"cursor day5", "cursor day4", "cursor day3", "codex day5", "codex day4"}},
+		// Whichever keeps more: the last one, or anything from the last 3 days
+		{name: "combined count wins", maxCount: 4, maxAge: 48 * time.Hour, wantPruned: 1, wantKept: []string{"cursor day5", "cursor day4", "cursor day3", "cursor day2", "codex day5", "codex day4"}},
+		{name: "combined age wins", maxCount: 1, maxAge: 72 * time.Hour, wantPruned: 2, wantKept: []string{"cursor day5", "cursor day4", "cursor day3", "codex day5", "codex day4"}},
+		{name: "no policy", wantPruned: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			storage, err := NewStorageServiceWithKeyring(t.TempDir(), nil, clock, NewInMemoryKeyring())
+			if err != nil {
+				t.Fatal(err)
+			}
+			source := filepath.Join(t.TempDir(), "mcp.json")
+			contents := make(map[string]string)
+			for day := 1; day <= 5; day++ {
+				for _, agentID := range []string{"cursor", "codex"} {
+					if agentID == "codex" && day < 4 {
+						continue
+					}
+					content := fmt.Sprintf("%s day%d", agentID, day)
+					os.WriteFile(source, []byte(content), 0644)
+					path, err := storage.BackupAgentFile(agentID, source)
+					if err != nil {
+						t.Fatal(err)
+					}
+					contents[filepath.Base(path)] = content
+				}
+				clock.Advance(24 * time.Hour)
+			}
+
+			pruned, err := storage.PruneBackups(tt.maxCount, tt.maxAge)
+			if err != nil {
+				t.Fatalf("PruneBackups() error = %v", err)
+			}
+			if pruned != tt.wantPruned {
+				t.Errorf("PruneBackups() pruned %d, want %d", pruned, tt.wantPruned)
+			}
+			if tt.wantKept == nil {
+				return
+			}
+			kept := make(map[string]bool)
+			for _, name := range backupNames(t, storage) {
+				kept[contents[name]] = true
+			}
+			want := make(map[string]bool)
+			for _, content := range tt.wantKept {
+				want[content] = true
+			}
+			if !reflect.DeepEqual(kept, want) {
+				t.Errorf("kept backups = %v, want %v", kept, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"mcp-sync/models"
+)
+
+// PauseSync 在 duration 内暂停自动同步（RunAutoSync 直接返回 paused），到期后自动恢复。
+// 再次调用会用新的 duration 替换原来的暂停；手动推送、拉取等操作不受影响
+func (as *AppService) PauseSync(duration time.Duration) error {
+	if duration <= 0 {
+		return fmt.Errorf("pause duration must be positive, got %s", duration)
+	}
+
+	until := time.Now().Add(duration)
+	as.pauseMu.Lock()
+	as.pausedUntil = until
+	as.pauseMu.Unlock()
+
+	as.storage.SaveSyncLog(models.SyncLog{
+		ID:        genID(),
+		Timestamp: nowTime(),
+		Action:    "pause_sync",
+		Status:    "success",
+		Message:   fmt.Sprintf("Auto-sync paused until %s", until.Format(time.RFC3339)),
+	})
+	return nil
+}
+
+// ResumeSync 立即结束暂停；没有暂停时什么也不做
+func (as *AppService) ResumeSync() {
+	as.pauseMu.Lock()
+	wasPaused := !as.pausedUntil.IsZero() && time.Now().Before(as.pausedUntil)
+	as.pausedUntil = time.Time{}
+	as.pauseMu.Unlock()
+
+	if wasPaused {
+		as.storage.SaveSyncLog(models.SyncLog{
+			ID:        genID(),
+			Timestamp: nowTime(),
+			Action:    "resume_sync",
+			Status:    "success",
+			Message:   "Auto-sync resumed",
+		})
+	}
+}
+
+// SyncPausedUntil 返回自动同步暂停到的时间；未暂停或暂停已到期时返回零值
+func (as *AppService) SyncPausedUntil() time.Time {
+	as.pauseMu.Lock()
+	defer as.pauseMu.Unlock()
+
+	if !as.pausedUntil.IsZero() && !time.Now().Before(as.pausedUntil) {
+		as.pausedUntil = time.Time{}
+	}
+	return as.pausedUntil
+}
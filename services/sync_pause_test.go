@@ -0,0 +1,92 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// localEditForAutoSync pushes a baseline and then edits cursor locally, so the next auto-sync cycle pushes
+func localEditForAutoSync(t *testing.T) (*AppService, *stubGistServer, string) {
+	t.Helper()
+	server := newStubGistServer(t)
+	server.addUser("token-a", "alice")
+	gistID := server.addGist("alice", remotePayload(t, map[string]interface{}{}, nowTime()))
+
+	as := newTestAppService(t)
+	connectTestGist(t, as, "token-a", gistID)
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"base": {"command": "base"}}}`)
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() error = %v", err)
+	}
+	writeAgentFile(t, as, "cursor", `{"mcpServers": {"local-edit": {"command": "local"}}}`)
+	return as, server, gistID
+}
+
+func TestPausedAutoSyncDoesNotRun(t *testing.T) {
+	as, server, _ := localEditForAutoSync(t)
+	if err := as.PauseSync(time.Hour); err != nil {
+		t.Fatalf("PauseSync() error = %v", err)
+	}
+	requests := server.requestCount("GET") + server.requestCount("PATCH")
+
+	result, err := as.RunAutoSync()
+	if err != nil {
+		t.Fatalf("RunAutoSync() error = %v", err)
+	}
+	if result.Action != "paused" {
+		t.Errorf("RunAutoSync() = %+v, want paused", result)
+	}
+	if made := server.requestCount("GET") + server.requestCount("PATCH") - requests; made != 0 {
+		t.Errorf("paused cycle made %d gist requests", made)
+	}
+	if status, err := as.GetSyncStatus(); err != nil || status.SyncPausedUntil == nil {
+		t.Errorf("GetSyncStatus() = %+v, %v, want the pause reported", status, err)
+	}
+
+	as.ResumeSync()
+	result, err = as.RunAutoSync()
+	if err != nil {
+		t.Fatalf("RunAutoSync() after ResumeSync() error = %v", err)
+	}
+	if result.Action != "push" {
+		t.Errorf("RunAutoSync() after ResumeSync() = %+v, want push", result)
+	}
+}
+
+func TestPauseSyncExpires(t *testing.T) {
+	as, _, _ := localEditForAutoSync(t)
+	if err := as.PauseSync(20 * time.Millisecond); err != nil {
+		t.Fatalf("PauseSync() error = %v", err)
+	}
+	if result, err := as.RunAutoSync(); err != nil || result.Action != "paused" {
+		t.Fatalf("RunAutoSync() = %+v, %v, want paused", result, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if until := as.SyncPausedUntil(); !until.IsZero() {
+		t.Errorf("SyncPausedUntil() = %v after the pause expired", until)
+	}
+	result, err := as.RunAutoSync()
+	if err != nil || result.Action != "push" {
+		t.Errorf("RunAutoSync() after the pause expired = %+v, %v, want push", result, err)
+	}
+}
+
+func TestManualSyncWorksWhilePaused(t *testing.T) {
+	as, server, gistID := localEditForAutoSync(t)
+	if err := as.PauseSync(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.PushAllAgentsToGist(); err != nil {
+		t.Fatalf("PushAllAgentsToGist() while paused error = %v", err)
+	}
+	if pushed := decryptForTest(t, server.fileContent(gistID, "mcp-config.json")); !strings.Contains(pushed, "local-edit") {
+		t.Errorf("manual push while paused did not reach the gist: %s", pushed)
+	}
+
+	if err := as.PauseSync(0); err == nil {
+		t.Error("PauseSync(0) was accepted")
+	}
+}
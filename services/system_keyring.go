@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"runtime"
+	"sync"
 
 	"github.com/zalando/go-keyring"
 )
@@ -34,6 +35,42 @@ func NewSystemKeyring() (SystemKeyring, error) {
 	}
 }
 
+// InMemoryKeyring 是仅存在于内存中的 SystemKeyring，不访问系统密钥环或文件系统，
+// 每个实例互相隔离，主要用于测试
+type InMemoryKeyring struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+}
+
+// NewInMemoryKeyring 创建一个空的内存密钥环
+func NewInMemoryKeyring() *InMemoryKeyring {
+	return &InMemoryKeyring{keys: make(map[string][]byte)}
+}
+
+func (mk *InMemoryKeyring) SetKey(service, keyName string, keyData []byte) error {
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	mk.keys[service+"/"+keyName] = append([]byte(nil), keyData...)
+	return nil
+}
+
+func (mk *InMemoryKeyring) GetKey(service, keyName string) ([]byte, error) {
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	keyData, ok := mk.keys[service+"/"+keyName]
+	if !ok {
+		return nil, fmt.Errorf("key %s not found in in-memory keyring", keyName)
+	}
+	return append([]byte(nil), keyData...), nil
+}
+
+func (mk *InMemoryKeyring) DeleteKey(service, keyName string) error {
+	mk.mu.Lock()
+	defer mk.mu.Unlock()
+	delete(mk.keys, service+"/"+keyName)
+	return nil
+}
+
 // generateRandomKey 生成一个随机的加密密钥
 func generateRandomKey() ([]byte, error) {
 	key := make([]byte, 32) // 256-bit key for AES-256
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/billgraziano/dpapi"
 )
@@ -13,7 +14,7 @@ import (
 // WindowsKeyring 使用Windows DPAPI存储密钥
 type WindowsKeyring struct{}
 
-// SetKey 将指定服务的密钥使用Windows DPAPI加密并保存到APPDATA目录下的keyring文件
+// SetKey 将指定服务的密钥使用Windows DPAPI加密并保存到 keyringFileDir() 下的keyring文件
 func (wk *WindowsKeyring) SetKey(service, keyName string, keyData []byte) error {
 	// 使用DPAPI加密密钥数据
 	encrypted, err := dpapi.EncryptBytes(keyData)
@@ -21,29 +22,27 @@ func (wk *WindowsKeyring) SetKey(service, keyName string, keyData []byte) error
 		return fmt.Errorf("failed to encrypt key data with DPAPI: %w", err)
 	}
 
-	dir := os.Getenv("APPDATA")
-	if dir == "" {
-		return fmt.Errorf("APPDATA environment variable not set")
+	keyringDir, err := keyringFileDir()
+	if err != nil {
+		return err
 	}
-
-	keyringDir := fmt.Sprintf("%s\\mcp-sync\\keyring", dir)
 	if err := os.MkdirAll(keyringDir, 0700); err != nil {
 		return fmt.Errorf("failed to create keyring directory: %w", err)
 	}
 
-	keyFile := fmt.Sprintf("%s\\%s_%s.key", keyringDir, service, keyName)
+	keyFile := filepath.Join(keyringDir, fmt.Sprintf("%s_%s.key", service, keyName))
 
 	// 存储DPAPI加密后的密钥
 	return os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(encrypted)), 0600)
 }
 
 func (wk *WindowsKeyring) GetKey(service, keyName string) ([]byte, error) {
-	dir := os.Getenv("APPDATA")
-	if dir == "" {
-		return nil, fmt.Errorf("APPDATA environment variable not set")
+	keyringDir, err := keyringFileDir()
+	if err != nil {
+		return nil, err
 	}
 
-	keyFile := fmt.Sprintf("%s\\mcp-sync\\keyring\\%s_%s.key", dir, service, keyName)
+	keyFile := filepath.Join(keyringDir, fmt.Sprintf("%s_%s.key", service, keyName))
 
 	data, err := os.ReadFile(keyFile)
 	if err != nil {
@@ -66,12 +65,12 @@ func (wk *WindowsKeyring) GetKey(service, keyName string) ([]byte, error) {
 }
 
 func (wk *WindowsKeyring) DeleteKey(service, keyName string) error {
-	dir := os.Getenv("APPDATA")
-	if dir == "" {
-		return fmt.Errorf("APPDATA environment variable not set")
+	keyringDir, err := keyringFileDir()
+	if err != nil {
+		return err
 	}
 
-	keyFile := fmt.Sprintf("%s\\mcp-sync\\keyring\\%s_%s.key", dir, service, keyName)
+	keyFile := filepath.Join(keyringDir, fmt.Sprintf("%s_%s.key", service, keyName))
 
 	if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to delete key file: %w", err)
@@ -3,6 +3,7 @@ package services
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -16,6 +17,8 @@ type CodexConfig struct {
 	DisableResponseStorage bool                        `toml:"disable_response_storage,omitempty"`
 	MCPServers            map[string]CodexMCPServer    `toml:"mcp_servers,omitempty"`
 	ModelProviders        map[string]interface{}       `toml:"model_providers,omitempty"`
+	// ServerOrder 是 mcp_servers 在文件中的顺序，写回时按这个顺序输出，新增的服务器排在最后
+	ServerOrder []string `toml:"-"`
 }
 
 // CodexMCPServer represents a single MCP server configuration in Codex TOML format
@@ -24,6 +27,10 @@ type CodexMCPServer struct {
 	Args    []string          `toml:"args,omitempty"`
 	Env     map[string]string `toml:"env,omitempty"`
 	CWD     string            `toml:"cwd,omitempty"`
+	// Enabled 为 false 时 Codex 不启动该服务器；未设置表示启用
+	Enabled *bool `toml:"enabled,omitempty"`
+	// Description 对应标准格式的 description 注释，Codex 忽略该键
+	Description string `toml:"description,omitempty"`
 }
 
 // TOMLAdapter handles conversion between Codex TOML format and standard JSON format
@@ -38,20 +45,35 @@ func NewTOMLAdapter() *TOMLAdapter {
 func (ta *TOMLAdapter) ReadCodexConfig(filePath string) (*CodexConfig, error) {
 	var config CodexConfig
 	
-	data, err := os.ReadFile(filePath)
+	data, _, err := readConfigText(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	if err := toml.Unmarshal(data, &config); err != nil {
+	meta, err := toml.Decode(string(data), &config)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse TOML: %w", err)
 	}
+	seen := make(map[string]bool)
+	for _, key := range meta.Keys() {
+		if len(key) >= 2 && key[0] == "mcp_servers" && !seen[key[1]] {
+			seen[key[1]] = true
+			config.ServerOrder = append(config.ServerOrder, key[1])
+		}
+	}
 
 	return &config, nil
 }
 
 // WriteCodexConfig writes Codex config back to TOML file
 func (ta *TOMLAdapter) WriteCodexConfig(filePath string, config *CodexConfig) error {
+	return os.WriteFile(filePath, configFileEncoding(filePath).encode([]byte(ta.RenderCodexConfig(config))), 0644)
+}
+
+// RenderCodexConfig returns the TOML written by WriteCodexConfig. MCP servers are written in
+// ServerOrder with new ones after them; other tables and keys are written in sorted order,
+// so the same config always renders the same text.
+func (ta *TOMLAdapter) RenderCodexConfig(config *CodexConfig) string {
 	// Manually build TOML to ensure inline table format for env
 	var content strings.Builder
 
@@ -72,10 +94,12 @@ func (ta *TOMLAdapter) WriteCodexConfig(filePath string, config *CodexConfig) er
 	// Write model_providers if exists (preserving complex nested structure)
 	if len(config.ModelProviders) > 0 {
 		content.WriteString("\n")
-		for providerName, providerData := range config.ModelProviders {
+		for _, providerName := range sortedKeys(config.ModelProviders) {
+			providerData := config.ModelProviders[providerName]
 			content.WriteString(fmt.Sprintf("[model_providers.%s]\n", providerName))
 			if providerMap, ok := providerData.(map[string]interface{}); ok {
-				for key, value := range providerMap {
+				for _, key := range sortedKeys(providerMap) {
+					value := providerMap[key]
 					switch v := value.(type) {
 					case string:
 						content.WriteString(fmt.Sprintf("  %s = %q\n", key, v))
@@ -95,7 +119,12 @@ func (ta *TOMLAdapter) WriteCodexConfig(filePath string, config *CodexConfig) er
 	// Write MCP servers with inline env tables
 	if len(config.MCPServers) > 0 {
 		content.WriteString("\n")
-		for serverName, server := range config.MCPServers {
+		serverNames := make([]string, 0, len(config.MCPServers))
+		for serverName := range config.MCPServers {
+			serverNames = append(serverNames, serverName)
+		}
+		for _, serverName := range orderedKeys(config.ServerOrder, serverNames) {
+			server := config.MCPServers[serverName]
 			content.WriteString(fmt.Sprintf("[mcp_servers.%s]\n", serverName))
 			content.WriteString(fmt.Sprintf("command = %q\n", server.Command))
 			
@@ -113,13 +142,16 @@ func (ta *TOMLAdapter) WriteCodexConfig(filePath string, config *CodexConfig) er
 			// Write env as inline table
 			if len(server.Env) > 0 {
 				content.WriteString("env = { ")
-				i := 0
-				for key, value := range server.Env {
+				envKeys := make([]string, 0, len(server.Env))
+				for key := range server.Env {
+					envKeys = append(envKeys, key)
+				}
+				sort.Strings(envKeys)
+				for i, key := range envKeys {
 					if i > 0 {
 						content.WriteString(", ")
 					}
-					content.WriteString(fmt.Sprintf("%q = %q", key, value))
-					i++
+					content.WriteString(fmt.Sprintf("%q = %q", key, server.Env[key]))
 				}
 				content.WriteString(" }\n")
 			}
@@ -127,13 +159,30 @@ func (ta *TOMLAdapter) WriteCodexConfig(filePath string, config *CodexConfig) er
 			if server.CWD != "" {
 				content.WriteString(fmt.Sprintf("cwd = %q\n", server.CWD))
 			}
+
+			if server.Enabled != nil && !*server.Enabled {
+				content.WriteString("enabled = false\n")
+			}
+
+			if server.Description != "" {
+				content.WriteString(fmt.Sprintf("description = %q\n", server.Description))
+			}
 			
 			content.WriteString("\n")
 		}
 	}
 
-	// Write to file
-	return os.WriteFile(filePath, []byte(content.String()), 0644)
+	return content.String()
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // CodexToStandard converts Codex TOML MCP servers to standard JSON format
@@ -156,6 +205,14 @@ func (ta *TOMLAdapter) CodexToStandard(codexServers map[string]CodexMCPServer) m
 			serverConfig["cwd"] = server.CWD
 		}
 
+		if server.Enabled != nil && !*server.Enabled {
+			serverConfig["disabled"] = true
+		}
+
+		if server.Description != "" {
+			serverConfig["description"] = server.Description
+		}
+
 		result[name] = serverConfig
 	}
 
@@ -163,7 +220,9 @@ func (ta *TOMLAdapter) CodexToStandard(codexServers map[string]CodexMCPServer) m
 }
 
 // StandardToCodex converts standard JSON MCP servers to Codex TOML format
-// Note: Codex only supports stdio transport. HTTP/SSE servers will be skipped.
+// Note: Codex only supports stdio transport. HTTP/SSE servers, and servers with a url but no command, will be skipped.
+// Custom fields such as tags, _meta and headers are dropped because the Codex schema has no place for them;
+// description is kept as a key Codex ignores.
 func (ta *TOMLAdapter) StandardToCodex(standardServers map[string]interface{}) map[string]CodexMCPServer {
 	result := make(map[string]CodexMCPServer)
 
@@ -174,35 +233,39 @@ func (ta *TOMLAdapter) StandardToCodex(standardServers map[string]interface{}) m
 		}
 
 		// Check if this is an HTTP or SSE server - Codex doesn't support these
-		if serverType, hasType := serverMap["type"].(string); hasType {
-			if serverType == "http" || serverType == "sse" {
-				println(fmt.Sprintf("[TOML] Skipping server '%s': Codex does not support %s transport (only stdio is supported)", name, serverType))
-				continue
-			}
+		if transport, unsupported := codexUnsupportedTransport(serverMap); unsupported {
+			println(fmt.Sprintf("[TOML] Skipping server '%s': Codex does not support %s transport (only stdio is supported)", name, transport))
+			continue
 		}
 
 		server := CodexMCPServer{}
 
+		// Codex has no request headers; a stdio server keeps working without them
+		if hasHeaders(serverMap) {
+			println(fmt.Sprintf("[TOML] Skipping headers of server '%s': Codex only supports stdio transport", name))
+		}
+
 		if cmd, ok := serverMap["command"].(string); ok {
 			server.Command = cmd
 		}
 
-		if args, ok := serverMap["args"].([]interface{}); ok {
+		// Scalars are coerced to strings (see coerce.go); Codex has no place for non-scalar args
+		if args, ok := coerceArgs(serverMap["args"]).([]interface{}); ok {
 			for _, arg := range args {
 				if argStr, ok := arg.(string); ok {
 					server.Args = append(server.Args, argStr)
+				} else {
+					println(fmt.Sprintf("[TOML] Skipping non-scalar argument of server '%s'", name))
 				}
 			}
 		} else if args, ok := serverMap["args"].([]string); ok {
 			server.Args = args
 		}
 
-		if env, ok := serverMap["env"].(map[string]interface{}); ok {
+		if env, ok := coerceEnv(serverMap["env"]).(map[string]interface{}); ok {
 			server.Env = make(map[string]string)
 			for k, v := range env {
-				if strVal, ok := v.(string); ok {
-					server.Env[k] = strVal
-				}
+				server.Env[k] = v.(string)
 			}
 		} else if env, ok := serverMap["env"].(map[string]string); ok {
 			server.Env = env
@@ -212,12 +275,78 @@ func (ta *TOMLAdapter) StandardToCodex(standardServers map[string]interface{}) m
 			server.CWD = cwd
 		}
 
+		if serverDisabled(serverMap) {
+			enabled := false
+			server.Enabled = &enabled
+		}
+
+		if description, ok := serverMap["description"].(string); ok {
+			server.Description = description
+		}
+
 		result[name] = server
 	}
 
 	return result
 }
 
+// codexUnsupportedTransport reports whether a standard server uses a transport Codex cannot run:
+// an explicit http/sse type, or a url without a command
+func codexUnsupportedTransport(serverMap map[string]interface{}) (string, bool) {
+	if serverType, hasType := serverMap["type"].(string); hasType {
+		if serverType == "http" || serverType == "sse" {
+			return serverType, true
+		}
+	}
+	if _, hasCommand := serverMap["command"]; !hasCommand {
+		if _, hasURL := serverMap["url"]; hasURL {
+			return "url", true
+		}
+	}
+	return "", false
+}
+
+// hasHeaders reports whether a standard server has a non-empty headers map
+func hasHeaders(serverMap map[string]interface{}) bool {
+	switch headers := serverMap["headers"].(type) {
+	case map[string]interface{}:
+		return len(headers) > 0
+	case map[string]string:
+		return len(headers) > 0
+	}
+	return false
+}
+
+// dropCodexUnsupported returns a copy of standard servers without what StandardToCodex would skip:
+// servers on an HTTP/SSE transport and the headers of stdio servers. skipped describes each skip.
+func dropCodexUnsupported(standardServers map[string]interface{}) (map[string]interface{}, []string) {
+	result := make(map[string]interface{}, len(standardServers))
+	var skipped []string
+	for _, name := range sortedKeys(standardServers) {
+		serverMap, ok := standardServers[name].(map[string]interface{})
+		if !ok {
+			result[name] = standardServers[name]
+			continue
+		}
+		if transport, unsupported := codexUnsupportedTransport(serverMap); unsupported {
+			skipped = append(skipped, fmt.Sprintf("server %s (%s transport)", name, transport))
+			continue
+		}
+		if hasHeaders(serverMap) {
+			kept := make(map[string]interface{}, len(serverMap))
+			for key, value := range serverMap {
+				if key != "headers" {
+					kept[key] = value
+				}
+			}
+			serverMap = kept
+			skipped = append(skipped, fmt.Sprintf("headers of server %s", name))
+		}
+		result[name] = serverMap
+	}
+	return result, skipped
+}
+
 // GetMCPServersAsStandard reads Codex config and returns MCP servers in standard format
 func (ta *TOMLAdapter) GetMCPServersAsStandard(filePath string) (map[string]interface{}, error) {
 	config, err := ta.ReadCodexConfig(filePath)
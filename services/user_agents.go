@@ -0,0 +1,136 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UserAgentsDir 返回用户自定义 agent 定义所在的目录：数据目录下的 agents.d。
+// 其中的 *.yaml / *.yml 文件与 agents.yaml 格式相同，按文件名顺序合并到内置定义中
+func UserAgentsDir() (string, error) {
+	dataDir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "agents.d"), nil
+}
+
+// mergeUserAgents 把 dir 中用户的 agent 定义和转换规则合并到 config：
+// 与内置 agent 同 ID 的定义覆盖其中设置了的字段（platforms 按平台覆盖），其他 ID 作为新 agent 添加。
+// 两个用户文件定义同一个 agent 或同一条转换规则时返回 *AgentsConfigError；目录不存在时什么也不做
+func mergeUserAgents(config *AgentsConfig, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	builtIn := make(map[string]int, len(config.Agents))
+	for i, agent := range config.Agents {
+		builtIn[agent.ID] = i
+	}
+	agentSource := make(map[string]string)
+	transformSource := make(map[string]string)
+
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		user, err := decodeAgentsConfig(path, data)
+		if err != nil {
+			return err
+		}
+
+		for key, rule := range user.Transforms {
+			if other, ok := transformSource[key]; ok {
+				return &AgentsConfigError{Source: path, Message: fmt.Sprintf("transform %q is also defined in %s", key, other)}
+			}
+			transformSource[key] = path
+			if config.Transforms == nil {
+				config.Transforms = make(map[string]TransformRule)
+			}
+			config.Transforms[key] = rule
+		}
+
+		for _, agent := range user.Agents {
+			if other, ok := agentSource[agent.ID]; ok {
+				return &AgentsConfigError{Source: path, Message: fmt.Sprintf("agent %q is also defined in %s", agent.ID, other)}
+			}
+			agentSource[agent.ID] = path
+
+			if i, ok := builtIn[agent.ID]; ok {
+				config.Agents[i] = overlayAgentDefinition(config.Agents[i], agent)
+				println(fmt.Sprintf("Using %s to override built-in agent %s", path, agent.ID))
+				continue
+			}
+			config.Agents = append(config.Agents, agent)
+			println(fmt.Sprintf("Loaded custom agent %s from %s", agent.ID, path))
+		}
+	}
+	return nil
+}
+
+// overlayAgentDefinition 返回用 user 中设置了的字段覆盖 base 后的定义；platforms 逐个平台替换
+func overlayAgentDefinition(base, user AgentDefinition) AgentDefinition {
+	merged := base
+	if user.Name != "" {
+		merged.Name = user.Name
+	}
+	if user.Description != "" {
+		merged.Description = user.Description
+	}
+	if user.ConfigKey != "" {
+		merged.ConfigKey = user.ConfigKey
+	}
+	if user.Format != "" {
+		merged.Format = user.Format
+	}
+	if user.SchemaURL != "" {
+		merged.SchemaURL = user.SchemaURL
+	}
+	if user.SchemaPath != "" {
+		merged.SchemaPath = user.SchemaPath
+	}
+	if user.ExtraSyncKeys != nil {
+		merged.ExtraSyncKeys = user.ExtraSyncKeys
+	}
+	if len(user.Platforms) > 0 {
+		merged.Platforms = make(map[string]PlatformConfig, len(base.Platforms)+len(user.Platforms))
+		for platform, paths := range base.Platforms {
+			merged.Platforms[platform] = paths
+		}
+		for platform, paths := range user.Platforms {
+			merged.Platforms[platform] = paths
+		}
+	}
+	return merged
+}
+
+// ReloadAgentDefinitions 重新读取内置和用户的 agent 定义，供 detector、config manager 和 converter 使用。
+// 持有 syncMu，正在进行的推送或拉取结束后才替换，不会在一次同步中途改变定义；出错时保留原来的定义
+func (as *AppService) ReloadAgentDefinitions() error {
+	as.syncMu.Lock()
+	defer as.syncMu.Unlock()
+
+	if err := as.configLoader.Reload(); err != nil {
+		return fmt.Errorf("failed to reload agent definitions: %w", err)
+	}
+	println(fmt.Sprintf("Reloaded %d agent definitions", len(as.configLoader.GetAgentDefinitions())))
+	return nil
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUserAgents writes an agents.d file into the data directory of the current test HOME
+func writeUserAgents(t *testing.T, name, content string) {
+	t.Helper()
+	dir, err := UserAgentsDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reloadTestAppService creates a new AppService for the current test HOME, picking up agents.d
+func reloadTestAppService(t *testing.T) *AppService {
+	t.Helper()
+	as := startTestAppService(t)
+	as.storage.crypto = nil
+	as.secrets = mapSecretStore{}
+	return as
+}
+
+const nicheAgentYAML = `
+agents:
+  - id: niche
+    name: Niche Client
+    config_key: servers
+    format: standard
+    platforms:
+      darwin:
+        config_paths: [~/.niche/mcp.json]
+      linux:
+        config_paths: [~/.niche/mcp.json]
+      windows:
+        config_paths: [~/.niche/mcp.json]
+`
+
+func TestUserAgentIsDetectedAndRoundTrips(t *testing.T) {
+	newTestAppService(t)
+	writeUserAgents(t, "niche.yaml", nicheAgentYAML)
+	as := reloadTestAppService(t)
+	path := writeAgentFile(t, as, "niche", `{"theme": "dark", "servers": {"fetch": {"command": "uvx", "args": ["mcp-server-fetch"]}}}`)
+
+	agents, err := as.DetectAgents()
+	if err != nil {
+		t.Fatalf("DetectAgents() error = %v", err)
+	}
+	found := false
+	for _, agent := range agents {
+		if agent.ID == "niche" {
+			found = agent.Status == "detected"
+		}
+	}
+	if !found {
+		t.Fatalf("custom agent niche was not detected: %+v", agents)
+	}
+
+	config, err := as.GetAgentMCPConfig("niche")
+	if err != nil {
+		t.Fatalf("GetAgentMCPConfig(niche) error = %v", err)
+	}
+	servers := config["servers"].(map[string]interface{})
+	servers["git"] = map[string]interface{}{"command": "git-mcp"}
+	if err := as.SaveAgentMCPConfig("niche", config); err != nil {
+		t.Fatalf("SaveAgentMCPConfig(niche) error = %v", err)
+	}
+
+	text := readFile(t, path)
+	for _, want := range []string{`"theme": "dark"`, `"servers"`, "mcp-server-fetch", "git-mcp"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("niche config lacks %s after the round trip:\n%s", want, text)
+		}
+	}
+}
+
+func TestUserAgentOverridesBuiltInPaths(t *testing.T) {
+	newTestAppService(t)
+	writeUserAgents(t, "cursor.yml", `
+agents:
+  - id: cursor
+    platforms:
+      darwin:
+        config_paths: [~/work/cursor-mcp.json]
+      linux:
+        config_paths: [~/work/cursor-mcp.json]
+      windows:
+        config_paths: [~/work/cursor-mcp.json]
+`)
+	as := reloadTestAppService(t)
+
+	path, err := as.detector.GetAgentConfigPath("cursor")
+	if err != nil || path != filepath.Join(os.Getenv("HOME"), "work", "cursor-mcp.json") {
+		t.Errorf("GetAgentConfigPath(cursor) = %q, %v, want the overridden path", path, err)
+	}
+	// Fields the override leaves out keep their built-in values
+	if key, format := as.configLoader.GetConfigKey("cursor"), as.configLoader.GetFormat("cursor"); key != "mcpServers" || format != "standard" {
+		t.Errorf("cursor config key/format = %s/%s, want the built-in mcpServers/standard", key, format)
+	}
+}
+
+func TestConflictingUserAgentsFailToLoad(t *testing.T) {
+	newTestAppService(t)
+	writeUserAgents(t, "a.yaml", nicheAgentYAML)
+	writeUserAgents(t, "b.yaml", nicheAgentYAML)
+
+	_, err := NewConfigLoader()
+	var configErr *AgentsConfigError
+	if !errors.As(err, &configErr) || !strings.Contains(err.Error(), `agent "niche" is also defined in`) ||
+		!strings.HasSuffix(configErr.Source, "b.yaml") {
+		t.Fatalf("NewConfigLoader() error = %v, want a conflict between a.yaml and b.yaml", err)
+	}
+}
+
+func TestBrokenUserAgentsFileFailsToLoad(t *testing.T) {
+	newTestAppService(t)
+	writeUserAgents(t, "broken.yaml", "agents:\n  - id: niche\n    platforms: oops\n")
+
+	_, err := NewConfigLoader()
+	var configErr *AgentsConfigError
+	if !errors.As(err, &configErr) || !strings.HasSuffix(configErr.Source, "broken.yaml") || configErr.Line != 3 {
+		t.Fatalf("NewConfigLoader() error = %v, want broken.yaml line 3", err)
+	}
+}
+
+func detectedAgentIDs(t *testing.T, as *AppService) map[string]bool {
+	t.Helper()
+	agents, err := as.DetectAgents()
+	if err != nil {
+		t.Fatalf("DetectAgents() error = %v", err)
+	}
+	ids := make(map[string]bool)
+	for _, agent := range agents {
+		if agent.Status == "detected" {
+			ids[agent.ID] = true
+		}
+	}
+	return ids
+}
+
+func TestReloadAgentDefinitionsPicksUpEdits(t *testing.T) {
+	newTestAppService(t)
+	writeUserAgents(t, "niche.yaml", nicheAgentYAML)
+	as := reloadTestAppService(t)
+	writeAgentFile(t, as, "niche", `{"servers": {}}`)
+	moved := filepath.Join(os.Getenv("HOME"), ".niche-v2", "mcp.json")
+	if err := os.MkdirAll(filepath.Dir(moved), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(moved, []byte(`{"servers": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !detectedAgentIDs(t, as)["niche"] {
+		t.Fatal("niche was not detected before the edit")
+	}
+
+	// Point niche at a path that does not exist, then at the moved file
+	writeUserAgents(t, "niche.yaml", strings.ReplaceAll(nicheAgentYAML, "~/.niche/", "~/.missing/"))
+	if err := as.ReloadAgentDefinitions(); err != nil {
+		t.Fatalf("ReloadAgentDefinitions() error = %v", err)
+	}
+	if detectedAgentIDs(t, as)["niche"] {
+		t.Error("niche is still detected after its path was changed to a missing file")
+	}
+
+	writeUserAgents(t, "niche.yaml", strings.ReplaceAll(nicheAgentYAML, "~/.niche/", "~/.niche-v2/"))
+	if err := as.ReloadAgentDefinitions(); err != nil {
+		t.Fatalf("ReloadAgentDefinitions() error = %v", err)
+	}
+	if !detectedAgentIDs(t, as)["niche"] {
+		t.Error("niche was not detected at its new path after reloading")
+	}
+	if path, err := as.detector.GetAgentConfigPath("niche"); err != nil || path != moved {
+		t.Errorf("GetAgentConfigPath(niche) = %q, %v, want %s", path, err, moved)
+	}
+}
+
+func TestReloadAgentDefinitionsKeepsDefinitionsOnError(t *testing.T) {
+	newTestAppService(t)
+	writeUserAgents(t, "niche.yaml", nicheAgentYAML)
+	as := reloadTestAppService(t)
+	writeAgentFile(t, as, "niche", `{"servers": {}}`)
+
+	writeUserAgents(t, "niche.yaml", "agents: [")
+	var configErr *AgentsConfigError
+	if err := as.ReloadAgentDefinitions(); !errors.As(err, &configErr) {
+		t.Fatalf("ReloadAgentDefinitions() error = %v, want an AgentsConfigError", err)
+	}
+	if !detectedAgentIDs(t, as)["niche"] {
+		t.Error("a failed reload dropped the previous definitions")
+	}
+}
+
+func TestReloadAgentDefinitionsDuringSync(t *testing.T) {
+	as, _, _, _ := pushSnapshotFixture(t)
+	writeUserAgents(t, "niche.yaml", nicheAgentYAML)
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if err := as.ReloadAgentDefinitions(); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 5; i++ {
+		if err := as.PushAllAgentsToGist(); err != nil {
+			t.Fatalf("PushAllAgentsToGist() while reloading error = %v", err)
+		}
+		detectedAgentIDs(t, as)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ReloadAgentDefinitions() error = %v", err)
+	}
+}
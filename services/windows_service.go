@@ -126,17 +126,17 @@ func (ws *WindowsService) ApplyWindowsTransformation(servers []models.MCPServer,
 	for _, server := range servers {
 		transformedServer := server
 
-		serverArgs := ws.convertToInterfaceSlice(server.Args)
+		serverArgs := server.ArgList()
 		if wrap && ws.ShouldWrapForWindows(server.Command, serverArgs) {
 			// Wrap npx commands for Windows
 			newCommand, newArgs := ws.WrapNpxCommand(server.Command, serverArgs)
 			transformedServer.Command = newCommand
-			transformedServer.Args = ws.convertToStringSlice(newArgs)
+			transformedServer.SetArgList(newArgs)
 		} else if !wrap && ws.IsAlreadyWrapped(server.Command, serverArgs) {
 			// Unwrap npx commands when leaving Windows
 			newCommand, newArgs := ws.UnwrapNpxCommand(server.Command, serverArgs)
 			transformedServer.Command = newCommand
-			transformedServer.Args = ws.convertToStringSlice(newArgs)
+			transformedServer.SetArgList(newArgs)
 		}
 
 		result = append(result, transformedServer)
@@ -145,25 +145,6 @@ func (ws *WindowsService) ApplyWindowsTransformation(servers []models.MCPServer,
 	return result
 }
 
-// Helper methods for type conversions
-func (ws *WindowsService) convertToInterfaceSlice(stringSlice []string) []interface{} {
-	result := make([]interface{}, len(stringSlice))
-	for i, v := range stringSlice {
-		result[i] = v
-	}
-	return result
-}
-
-func (ws *WindowsService) convertToStringSlice(interfaceSlice []interface{}) []string {
-	result := make([]string, len(interfaceSlice))
-	for i, v := range interfaceSlice {
-		if str, ok := v.(string); ok {
-			result[i] = str
-		}
-	}
-	return result
-}
-
 // ConvertToInterfaceSlice converts string slice to interface slice
 func ConvertToInterfaceSlice(stringSlice []string) []interface{} {
 	result := make([]interface{}, len(stringSlice))